@@ -0,0 +1,264 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/codegangsta/cli"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/models/import"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var CmdAdmin = cli.Command{
+	Name:        "admin",
+	Usage:       "Perform administrative tasks",
+	Description: `Admin runs maintenance tasks on the Gogs installation of current directory.`,
+	Subcommands: []cli.Command{
+		subcmdRewriteKeys,
+		subcmdImportKeys,
+		subcmdImportGitLabKeys,
+		subcmdImportAuthorizedKeys,
+	},
+}
+
+var subcmdRewriteKeys = cli.Command{
+	Name:  "rewrite-keys",
+	Usage: "Rewrite authorized_keys file with all public keys in database",
+	Description: `Rewrite-keys regenerates authorized_keys file from database,
+keys that were not added through Gogs will be lost`,
+	Action: runRewriteKeys,
+	Flags: []cli.Flag{
+		cli.StringFlag{"config, c", "custom/conf/app.ini", "Custom configuration file path", ""},
+	},
+}
+
+var subcmdImportKeys = cli.Command{
+	Name:  "import-keys",
+	Usage: "Import public keys of users from a CSV file",
+	Description: `Import-keys adds public keys listed in rows of "username,title,key" format,
+rows whose key already belongs to the user are skipped, so same file can be imported again.
+A report of every row is written to standard output or given file in CSV format.`,
+	Action: runImportKeys,
+	Flags: []cli.Flag{
+		cli.StringFlag{"config, c", "custom/conf/app.ini", "Custom configuration file path", ""},
+		cli.StringFlag{"report, r", "", "Path to write report, default is standard output", ""},
+	},
+}
+
+var subcmdImportGitLabKeys = cli.Command{
+	Name:  "import-gitlab-keys",
+	Usage: "Import public keys of users from GitLab",
+	Description: `Import-gitlab-keys adds public keys of GitLab users to users who have same activated e-mail address,
+keys imported before are skipped, so it can be run again safely.
+A report in JSON format is written to standard output or given file, it lists keys that were not imported.`,
+	Action: runImportGitLabKeys,
+	Flags: []cli.Flag{
+		cli.StringFlag{"config, c", "custom/conf/app.ini", "Custom configuration file path", ""},
+		cli.StringFlag{"url", "", "GitLab URL", ""},
+		cli.StringFlag{"token", "", "Private token of a GitLab admin", ""},
+		cli.StringFlag{"report, r", "", "Path to write report, default is standard output", ""},
+	},
+}
+
+var subcmdImportAuthorizedKeys = cli.Command{
+	Name:  "import-authorized-keys",
+	Usage: "Import public keys of an existing authorized_keys file",
+	Description: `Import-authorized-keys adds keys of an authorized_keys file that was not written by Gogs,
+keys are given to the user of --user, or to the user whose e-mail or name is the comment of key.
+Lines with options are only imported with --force, since their options are replaced by the ones of Gogs.
+Authorized_keys file of Gogs is backed up and rewritten afterwards, lines that were not imported are kept at the end of it.
+A report of every line is written to standard output or given file in CSV format.`,
+	Action: runImportAuthorizedKeys,
+	Flags: []cli.Flag{
+		cli.StringFlag{"config, c", "custom/conf/app.ini", "Custom configuration file path", ""},
+		cli.StringFlag{"file, f", "", "Path of authorized_keys file to import", ""},
+		cli.StringFlag{"map-by", models.AUTHORIZED_KEYS_MAP_BY_COMMENT, "How to find owner of keys: comment or user", ""},
+		cli.StringFlag{"user, u", "", "Name of user who owns all keys, implies --map-by user", ""},
+		cli.BoolFlag{"dry-run", "Only report what would be imported", ""},
+		cli.BoolFlag{"force", "Import lines with options as well, dropping their options", ""},
+		cli.StringFlag{"report, r", "", "Path to write report, default is standard output", ""},
+	},
+}
+
+// initAdminContext loads configuration and database for admin tasks.
+func initAdminContext(ctx *cli.Context) {
+	if ctx.IsSet("config") {
+		setting.CustomConf = ctx.String("config")
+	}
+	setting.NewConfigContext()
+	models.LoadModelsConfig()
+
+	if setting.UseSQLite3 {
+		workDir, _ := setting.WorkDir()
+		os.Chdir(workDir)
+	}
+	if err := models.SetEngine(); err != nil {
+		log.Fatalf("Fail to initialize ORM engine: %v", err)
+	}
+}
+
+func runRewriteKeys(ctx *cli.Context) {
+	initAdminContext(ctx)
+
+	result, err := models.RewriteAllPublicKeysOnce()
+	if err != nil {
+		log.Fatalf("Fail to rewrite authorized_keys file: %v", err)
+	}
+	fmt.Printf("Rewrote %d public keys in %s\n", result.NumKeys, result.Duration)
+
+	// Web server replicates in background, this process exits right away.
+	models.ReplicateAuthorizedKeys()
+}
+
+func runImportKeys(ctx *cli.Context) {
+	if len(ctx.Args()) != 1 {
+		log.Fatal("Usage: gogs admin import-keys [--config path] [--report path] file.csv")
+	}
+	f, err := os.Open(ctx.Args()[0])
+	if err != nil {
+		log.Fatalf("Fail to open CSV file: %v", err)
+	}
+	defer f.Close()
+
+	// Open report file before importing, so results are never lost.
+	report := os.Stdout
+	if ctx.IsSet("report") {
+		if report, err = os.Create(ctx.String("report")); err != nil {
+			log.Fatalf("Fail to create report file: %v", err)
+		}
+		defer report.Close()
+	}
+
+	initAdminContext(ctx)
+
+	results, importErr := models.BulkImportPublicKeys(f, models.SecurityActorSystem)
+	if err = models.WriteBulkKeyImportReport(report, results); err != nil {
+		log.Fatalf("Fail to write report: %v", err)
+	}
+
+	stats := make(map[string]int)
+	for _, r := range results {
+		stats[r.Status]++
+	}
+	fmt.Fprintf(os.Stderr, "%d rows: %d added, %d already existed, %d failed\n", len(results),
+		stats[models.KEY_IMPORT_ADDED], stats[models.KEY_IMPORT_EXISTS],
+		len(results)-stats[models.KEY_IMPORT_ADDED]-stats[models.KEY_IMPORT_EXISTS])
+	if stats[models.KEY_IMPORT_ADDED] > 0 {
+		models.ReplicateAuthorizedKeys()
+	}
+	if importErr != nil {
+		log.Fatalf("Fail to import keys: %v", importErr)
+	}
+}
+
+func runImportGitLabKeys(ctx *cli.Context) {
+	if !ctx.IsSet("url") || !ctx.IsSet("token") {
+		log.Fatal("Usage: gogs admin import-gitlab-keys [--config path] [--report path] --url URL --token TOKEN")
+	}
+
+	report := os.Stdout
+	if ctx.IsSet("report") {
+		var err error
+		if report, err = os.Create(ctx.String("report")); err != nil {
+			log.Fatalf("Fail to create report file: %v", err)
+		}
+		defer report.Close()
+	}
+
+	initAdminContext(ctx)
+
+	result, importErr := importer.ImportKeysFromGitLab(ctx.String("token"), ctx.String("url"), func(msg string) {
+		fmt.Fprintln(os.Stderr, msg)
+	})
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Fatalf("Fail to encode report: %v", err)
+	}
+	report.Write(append(data, '\n'))
+
+	if result.Keys.Created > 0 {
+		models.ReplicateAuthorizedKeys()
+	}
+	if importErr != nil {
+		log.Fatalf("Fail to import keys: %v", importErr)
+	}
+}
+
+func runImportAuthorizedKeys(ctx *cli.Context) {
+	if !ctx.IsSet("file") {
+		log.Fatal("Usage: gogs admin import-authorized-keys [--config path] [--report path] [--dry-run] [--force] --file path [--map-by comment|--user name]")
+	}
+	opts := models.AuthorizedKeysImportOptions{
+		MapBy:    ctx.String("map-by"),
+		UserName: ctx.String("user"),
+		DryRun:   ctx.Bool("dry-run"),
+		Force:    ctx.Bool("force"),
+		Actor:    models.SecurityActorSystem,
+	}
+	if ctx.IsSet("user") {
+		opts.MapBy = models.AUTHORIZED_KEYS_MAP_BY_USER
+	} else if opts.MapBy == models.AUTHORIZED_KEYS_MAP_BY_USER {
+		log.Fatal("--map-by user requires --user")
+	}
+
+	f, err := os.Open(ctx.String("file"))
+	if err != nil {
+		log.Fatalf("Fail to open authorized_keys file: %v", err)
+	}
+	defer f.Close()
+
+	report := os.Stdout
+	if ctx.IsSet("report") {
+		if report, err = os.Create(ctx.String("report")); err != nil {
+			log.Fatalf("Fail to create report file: %v", err)
+		}
+		defer report.Close()
+	}
+
+	initAdminContext(ctx)
+
+	result, importErr := models.ImportAuthorizedKeys(f, opts)
+	if result == nil {
+		log.Fatalf("Fail to import keys: %v", importErr)
+	}
+	if err = models.WriteAuthorizedKeysImportReport(report, result.Results); err != nil {
+		log.Fatalf("Fail to write report: %v", err)
+	}
+
+	stats := make(map[string]int)
+	for _, r := range result.Results {
+		stats[r.Status]++
+	}
+	if opts.DryRun {
+		fmt.Fprintf(os.Stderr, "Dry run, %d lines: %d would be added, %d already existed, %d written by Gogs, %d failed\n",
+			len(result.Results), stats[models.KEY_IMPORT_WOULD_ADD], stats[models.KEY_IMPORT_EXISTS], stats[models.KEY_IMPORT_MANAGED],
+			len(result.Results)-stats[models.KEY_IMPORT_WOULD_ADD]-stats[models.KEY_IMPORT_EXISTS]-stats[models.KEY_IMPORT_MANAGED])
+	} else {
+		fmt.Fprintf(os.Stderr, "%d lines: %d added, %d already existed, %d written by Gogs, %d failed\n",
+			len(result.Results), result.Added, stats[models.KEY_IMPORT_EXISTS], stats[models.KEY_IMPORT_MANAGED],
+			len(result.Results)-result.Added-stats[models.KEY_IMPORT_EXISTS]-stats[models.KEY_IMPORT_MANAGED])
+	}
+	if stats[models.KEY_IMPORT_RESTRICTED] > 0 {
+		fmt.Fprintf(os.Stderr, "%d lines have options and were not imported, use --force to import them without their options\n",
+			stats[models.KEY_IMPORT_RESTRICTED])
+	}
+	if len(result.Backup) > 0 {
+		fmt.Fprintf(os.Stderr, "Previous authorized_keys file has been backed up to %s, %d lines that were not imported have been kept\n",
+			result.Backup, result.Kept)
+	}
+
+	if result.Added > 0 {
+		models.ReplicateAuthorizedKeys()
+	}
+	if importErr != nil {
+		log.Fatalf("Fail to import keys: %v", importErr)
+	}
+}
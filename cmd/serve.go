@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Unknwon/com"
@@ -17,13 +18,14 @@ import (
 
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/serv"
 	"github.com/gogits/gogs/modules/setting"
 	"github.com/gogits/gogs/modules/uuid"
 )
 
-const (
-	_ACCESS_DENIED_MESSAGE = "Repository does not exist or you do not have access"
-)
+// KEY_ACTIVITY_TIMEOUT is how long serv waits for key activity to be recorded
+// after git command has finished.
+const KEY_ACTIVITY_TIMEOUT = 2 * time.Second
 
 var CmdServ = cli.Command{
 	Name:        "serv",
@@ -35,7 +37,8 @@ var CmdServ = cli.Command{
 	},
 }
 
-func setup(logPath string) {
+// loadConfig loads configuration without connecting to database.
+func loadConfig(logPath string) {
 	setting.NewConfigContext()
 	log.NewGitLogger(filepath.Join(setting.LogRootPath, logPath))
 
@@ -50,34 +53,77 @@ func setup(logPath string) {
 		workDir, _ := setting.WorkDir()
 		os.Chdir(workDir)
 	}
+}
+
+var setEngineOnce sync.Once
+
+// setEngine connects to database when it is first needed.
+func setEngine() {
+	setEngineOnce.Do(func() {
+		if err := models.SetEngine(); err != nil {
+			log.GitLogger.Fatal(2, "SetEngine: %v", err)
+		}
+	})
+}
 
-	models.SetEngine()
+func setup(logPath string) {
+	loadConfig(logPath)
+	setEngine()
 }
 
-func parseCmd(cmd string) (string, string) {
-	ss := strings.SplitN(cmd, " ", 2)
-	if len(ss) != 2 {
-		return "", ""
+// checkRequest asks web process to check the request, so serv does not connect to
+// database before running git command. Database is queried directly when web
+// process cannot answer.
+func checkRequest(keyId int64, cmd string) (*models.PublicKey, *models.User, *models.SSHCommand, error) {
+	if len(setting.InternalSocket) > 0 {
+		key, user, sshCmd, err := serv.Check(setting.InternalSocket, setting.InternalTokenFile, keyId, cmd)
+		if err == nil || models.IsErrSSHAccess(err) {
+			return key, user, sshCmd, err
+		}
+		log.GitLogger.Warn("Internal endpoint is not available, query database directly: %v", err)
 	}
-	return ss[0], strings.Replace(ss[1], "'/", "'", 1)
+
+	setEngine()
+	return models.CheckSSHRequest(keyId, cmd)
 }
 
-var (
-	COMMANDS = map[string]models.AccessMode{
-		"git-upload-pack":    models.ACCESS_MODE_READ,
-		"git-upload-archive": models.ACCESS_MODE_READ,
-		"git-receive-pack":   models.ACCESS_MODE_WRITE,
+// addKeyActivity asks web process to record key activity, database is
+// written directly when web process cannot answer.
+func addKeyActivity(activity *models.KeyActivity) error {
+	if len(setting.InternalSocket) > 0 {
+		err := serv.AddKeyActivity(setting.InternalSocket, setting.InternalTokenFile, activity)
+		if err == nil {
+			return nil
+		}
+		log.GitLogger.Warn("Internal endpoint is not available, write database directly: %v", err)
 	}
-)
+
+	setEngine()
+	return models.AddKeyActivity(activity)
+}
+
+// recordKeyUsage is same as addKeyActivity but records usage of key on repository.
+func recordKeyUsage(keyId, repoId int64, action string) error {
+	if len(setting.InternalSocket) > 0 {
+		err := serv.RecordKeyUsage(setting.InternalSocket, setting.InternalTokenFile, keyId, repoId, action)
+		if err == nil {
+			return nil
+		}
+		log.GitLogger.Warn("Internal endpoint is not available, write database directly: %v", err)
+	}
+
+	setEngine()
+	return models.RecordKeyUsage(keyId, repoId, action)
+}
 
 func runServ(c *cli.Context) {
 	if c.IsSet("config") {
 		setting.CustomConf = c.String("config")
 	}
-	setup("serv.log")
+	loadConfig("serv.log")
 
 	fail := func(userMessage, logMessage string, args ...interface{}) {
-		fmt.Fprintln(os.Stderr, "Gogs: ", userMessage)
+		fmt.Fprintln(os.Stderr, "Gogs:", userMessage)
 		log.GitLogger.Fatal(2, logMessage, args...)
 	}
 
@@ -95,69 +141,64 @@ func runServ(c *cli.Context) {
 		fail("key-id format error", "Invalid key id: %s", err)
 	}
 
-	user, err := models.GetUserByKeyId(keyId)
-	if err != nil {
-		fail("internal error", "Fail to get user by key ID(%d): %v", keyId, err)
+	// failRequest tells client why request is rejected, detailed reason is only logged.
+	failRequest := func(err error) {
+		fmt.Fprint(os.Stderr, models.SSHErrorLine(err))
+		log.GitLogger.Fatal(2, "Key(%d): %v", keyId, err)
 	}
 
+	// Key and its owner are always reloaded, authorized_keys file may be stale.
 	cmd := os.Getenv("SSH_ORIGINAL_COMMAND")
-	if cmd == "" {
+	key, user, sshCmd, err := checkRequest(keyId, cmd)
+	if err != nil {
+		failRequest(err)
+	}
+
+	if sshCmd == nil {
 		println("Hi", user.Name, "! You've successfully authenticated, but Gogs does not provide shell access.")
 		if user.IsAdmin {
 			println("If this is unexpected, please log in with password and setup Gogs under another user.")
 		}
 		return
 	}
+	verb, repoPath := sshCmd.Verb, sshCmd.RepoPath
+	repoUser, repo, requestedMode := sshCmd.RepoOwner, sshCmd.Repo, sshCmd.Mode
 
-	verb, args := parseCmd(cmd)
-	repoPath := strings.Trim(args, "'")
-	rr := strings.SplitN(repoPath, "/", 2)
-	if len(rr) != 2 {
-		fail("Invalid repository path", "Invalide repository path: %v", args)
-	}
-	repoUserName := rr[0]
-	repoName := strings.TrimSuffix(rr[1], ".git")
+	uuid := uuid.NewV4().String()
+	os.Setenv("uuid", uuid)
+	os.Setenv("repoId", com.ToStr(repo.Id))
 
-	repoUser, err := models.GetUserByName(repoUserName)
-	if err != nil {
-		if err == models.ErrUserNotExist {
-			fail("Repository owner does not exist", "Unregistered owner: %s", repoUserName)
-		}
-		fail("Internal error", "Fail to get repository owner(%s): %v", repoUserName, err)
+	remoteAddr := os.Getenv("SSH_CONNECTION")
+	if len(remoteAddr) == 0 {
+		remoteAddr = os.Getenv("SSH_CLIENT")
 	}
-
-	repo, err := models.GetRepositoryByName(repoUser.Id, repoName)
-	if err != nil {
-		if models.IsErrRepoNotExist(err) {
-			if user.Id == repoUser.Id || repoUser.IsOwnedBy(user.Id) {
-				fail("Repository does not exist", "Repository does not exist: %s/%s", repoUser.Name, repoName)
-			} else {
-				fail(_ACCESS_DENIED_MESSAGE, "Repository does not exist: %s/%s", repoUser.Name, repoName)
-			}
-		}
-		fail("Internal error", "Fail to get repository: %v", err)
+	if fields := strings.Fields(remoteAddr); len(fields) > 0 {
+		remoteAddr = fields[0]
 	}
-
-	requestedMode, has := COMMANDS[verb]
-	if !has {
-		fail("Unknown git command", "Unknown git command %s", verb)
+	activity := &models.KeyActivity{
+		KeyId:      keyId,
+		KeyName:    key.Name,
+		OwnerId:    user.Id,
+		OwnerName:  user.Name,
+		RepoId:     repo.Id,
+		RepoName:   repoUser.Name + "/" + repo.Name,
+		Operation:  sshCmd.Operation(),
+		RemoteAddr: remoteAddr,
 	}
-
-	mode, err := models.AccessLevel(user, repo)
-	if err != nil {
-		fail("Internal error", "Fail to check access: %v", err)
-	} else if mode < requestedMode {
-		clientMessage := _ACCESS_DENIED_MESSAGE
-		if mode >= models.ACCESS_MODE_READ {
-			clientMessage = "You do not have sufficient authorization for this action"
-		}
-		fail(clientMessage,
-			"User %s does not have level %v access to repository %s",
-			user.Name, requestedMode, repoPath)
+	var actDone chan error
+	startKeyActivity := func() {
+		actDone = make(chan error, 1)
+		go func(done chan error) {
+			// Recording no longer holds up git command.
+			done <- addKeyActivity(activity)
+		}(actDone)
 	}
 
-	uuid := uuid.NewV4().String()
-	os.Setenv("uuid", uuid)
+	// Reads are recorded while git command is running, pushes are recorded
+	// once refs have been updated, so rejected pushes are not shown as last push.
+	if requestedMode != models.ACCESS_MODE_WRITE {
+		startKeyActivity()
+	}
 
 	var gitcmd *exec.Cmd
 	verbs := strings.Split(verb, " ")
@@ -167,6 +208,7 @@ func runServ(c *cli.Context) {
 		gitcmd = exec.Command(verb, repoPath)
 	}
 	gitcmd.Dir = setting.RepoRootPath
+	gitcmd.Env = append(os.Environ(), sshCmd.Env(keyId, user)...)
 	gitcmd.Stdout = os.Stdout
 	gitcmd.Stdin = os.Stdin
 	gitcmd.Stderr = os.Stderr
@@ -175,31 +217,58 @@ func runServ(c *cli.Context) {
 	}
 
 	if requestedMode == models.ACCESS_MODE_WRITE {
+		setEngine()
 		tasks, err := models.GetUpdateTasksByUuid(uuid)
 		if err != nil {
 			log.GitLogger.Fatal(2, "GetUpdateTasksByUuid: %v", err)
 		}
+		if len(tasks) > 0 {
+			startKeyActivity()
+		}
 
+		// Settings of mail services decide whether pushes are queued for notification.
+		setting.NewMailServices()
 		for _, task := range tasks {
-			err = models.Update(task.RefName, task.OldCommitId, task.NewCommitId,
-				user.Name, repoUserName, repoName, user.Id)
+			commits, err := models.Update(task.RefName, task.OldCommitId, task.NewCommitId,
+				user.Name, repoUser.Name, repo.Name, user.Id)
 			if err != nil {
 				log.GitLogger.Error(2, "Fail to update: %v", err)
+			} else if err = models.QueuePushNotification(repo, models.RepoPath(repoUser.Name, repo.Name),
+				task.RefName, task.OldCommitId, user.Name, commits); err != nil {
+				log.GitLogger.Error(2, "QueuePushNotification: %v", err)
 			}
 		}
 
 		if err = models.DelUpdateTasksByUuid(uuid); err != nil {
 			log.GitLogger.Fatal(2, "DelUpdateTasksByUuid: %v", err)
 		}
+
+		// Recalculate repository size while finishing up remaining work.
+		sizeDone := make(chan error, 1)
+		go func() {
+			sizeDone <- models.UpdateRepoSize(repo)
+		}()
+		defer func() {
+			if err := <-sizeDone; err != nil {
+				log.GitLogger.Error(2, "UpdateRepoSize: %v", err)
+			}
+		}()
 	}
 
-	// Update key activity.
-	key, err := models.GetPublicKeyById(keyId)
-	if err != nil {
-		fail("Internal error", "GetPublicKeyById: %v", err)
+	// Audit records must not hold up client when database is slow or unavailable.
+	if actDone != nil {
+		select {
+		case err = <-actDone:
+			if err != nil {
+				log.GitLogger.Warn("AddKeyActivity(%d): %v", keyId, err)
+			}
+		case <-time.After(KEY_ACTIVITY_TIMEOUT):
+			log.GitLogger.Warn("AddKeyActivity(%d): timed out after %v", keyId, KEY_ACTIVITY_TIMEOUT)
+		}
 	}
-	key.Updated = time.Now()
-	if err = models.UpdatePublicKey(key); err != nil {
-		fail("Internal error", "UpdatePublicKey: %v", err)
+
+	// Update key usage and activity.
+	if err = recordKeyUsage(keyId, repo.Id, sshCmd.Operation()); err != nil {
+		log.GitLogger.Warn("RecordKeyUsage(%d): %v", keyId, err)
 	}
 }
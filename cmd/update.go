@@ -5,8 +5,10 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/Unknwon/com"
 	"github.com/codegangsta/cli"
 
 	"github.com/gogits/gogs/models"
@@ -28,8 +30,11 @@ func runUpdate(c *cli.Context) {
 	if c.IsSet("config") {
 		setting.CustomConf = c.String("config")
 	}
-	cmd := os.Getenv("SSH_ORIGINAL_COMMAND")
-	if cmd == "" {
+	// Pushes over HTTP set only repoId, so rules of repository are checked
+	// but pushed refs are processed by web process itself.
+	isSSH := len(os.Getenv("SSH_ORIGINAL_COMMAND")) > 0
+	repoId := com.StrTo(os.Getenv("repoId")).MustInt64()
+	if !isSSH && repoId == 0 {
 		return
 	}
 
@@ -42,6 +47,42 @@ func runUpdate(c *cli.Context) {
 		log.GitLogger.Fatal(2, "refName is empty, shouldn't use")
 	}
 
+	// Validate commit messages against rules of repository.
+	if repoId > 0 {
+		repo, err := models.GetRepositoryById(repoId)
+		if err != nil {
+			log.GitLogger.Fatal(2, "GetRepositoryById: %v", err)
+		}
+		repoPath, err := repo.RepoPath()
+		if err != nil {
+			log.GitLogger.Fatal(2, "RepoPath: %v", err)
+		}
+
+		warnings, err := models.ValidateCommitMessages(repo.Id, repoPath, args[0], args[2])
+		for _, w := range warnings {
+			fmt.Fprintln(os.Stderr, "Gogs: warning:", w)
+		}
+		if err != nil {
+			if models.IsErrCommitMessageRejected(err) {
+				fmt.Fprintln(os.Stderr, "Gogs:", err)
+				os.Exit(1)
+			}
+			log.GitLogger.Fatal(2, "ValidateCommitMessages: %v", err)
+		}
+
+		if err = models.ValidateTagSignature(repo.Id, repoPath, args[0], args[2]); err != nil {
+			if models.IsErrTagSignatureRejected(err) {
+				fmt.Fprintln(os.Stderr, "Gogs:", err)
+				os.Exit(1)
+			}
+			log.GitLogger.Fatal(2, "ValidateTagSignature: %v", err)
+		}
+	}
+
+	if !isSSH {
+		return
+	}
+
 	uuid := os.Getenv("uuid")
 
 	task := models.UpdateTask{
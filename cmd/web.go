@@ -182,9 +182,14 @@ func runWeb(ctx *cli.Context) {
 	bind := binding.Bind
 	bindIgnErr := binding.BindIgnErr
 
+	// Scopes of access tokens that API routes require.
+	reqScope := middleware.ApiReqScope
+	reqWriteScope := middleware.ApiReqWriteScope
+
 	// Routers.
 	m.Get("/", ignSignIn, routers.Home)
 	m.Get("/explore", ignSignIn, routers.Explore)
+	m.Get("/attachments/:uuid", ignSignIn, repo.IssueGetAttachment)
 	m.Combo("/install", routers.InstallInit).
 		Get(routers.Install).
 		Post(bindIgnErr(auth.InstallForm{}), routers.InstallPost)
@@ -200,6 +205,7 @@ func runWeb(ctx *cli.Context) {
 			// Miscellaneous.
 			m.Post("/markdown", bindIgnErr(apiv1.MarkdownForm{}), v1.Markdown)
 			m.Post("/markdown/raw", v1.MarkdownRaw)
+			m.Get("/search", reqScope(models.ACCESS_TOKEN_SCOPE_READ_REPO), v1.SearchAll)
 
 			// Users.
 			m.Group("/users", func() {
@@ -207,6 +213,7 @@ func runWeb(ctx *cli.Context) {
 
 				m.Group("/:username", func() {
 					m.Get("", v1.GetUserInfo)
+					m.Get("/keys", v1.ListUserPublicKeys)
 
 					m.Group("/tokens", func() {
 						m.Combo("").Get(v1.ListAccessTokens).Post(bind(v1.CreateAccessTokenForm{}), v1.CreateAccessToken)
@@ -214,20 +221,86 @@ func runWeb(ctx *cli.Context) {
 				})
 			})
 
+			m.Group("/user/blocks", func() {
+				m.Get("", v1.ListBlockedUsers)
+				m.Combo("/:username").Put(v1.BlockUser).Delete(v1.UnblockUser)
+			}, middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_READ_USER),
+				reqWriteScope(models.ACCESS_TOKEN_SCOPE_WRITE_USER))
+			m.Combo("/user/keys", middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_READ_USER, models.ACCESS_TOKEN_SCOPE_WRITE_KEY),
+				reqWriteScope(models.ACCESS_TOKEN_SCOPE_WRITE_KEY), middleware.KeyRateLimit()).
+				Get(v1.ListMyPublicKeys).
+				Post(bind(v1.CreatePublicKeyOption{}), v1.CreateMyPublicKey)
+			m.Post("/user/keys/import", middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_WRITE_KEY),
+				middleware.KeyRateLimit(), bind(v1.ImportPublicKeysOption{}), v1.ImportMyPublicKeys)
+			m.Get("/user/keys/export", middleware.ApiReqToken(),
+				reqScope(models.ACCESS_TOKEN_SCOPE_READ_USER, models.ACCESS_TOKEN_SCOPE_WRITE_KEY), v1.ExportPublicKeys)
+			m.Combo("/user/keys/:id:int", middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_READ_USER, models.ACCESS_TOKEN_SCOPE_WRITE_KEY),
+				reqWriteScope(models.ACCESS_TOKEN_SCOPE_WRITE_KEY), middleware.KeyRateLimit()).
+				Get(v1.GetMyPublicKey).
+				Patch(bind(v1.EditPublicKeyOption{}), v1.EditPublicKey).
+				Delete(v1.DeletePublicKey)
+
+			m.Get("/keys/search", middleware.ApiReqToken(),
+				reqScope(models.ACCESS_TOKEN_SCOPE_READ_USER, models.ACCESS_TOKEN_SCOPE_WRITE_KEY), v1.SearchPublicKeyByFingerprint)
+
+			// Administration.
+			m.Group("/admin/users/:username/keys", func() {
+				m.Post("", bind(v1.CreatePublicKeyOption{}), v1.AdminCreatePublicKey)
+				m.Delete("/:id:int", v1.AdminDeletePublicKey)
+			}, middleware.ApiReqToken(), middleware.ApiReqAdmin(), reqScope(models.ACCESS_TOKEN_SCOPE_ADMIN), middleware.KeyRateLimit())
+			m.Post("/admin/keys/rewrite", middleware.ApiReqToken(), middleware.ApiReqAdmin(),
+				reqScope(models.ACCESS_TOKEN_SCOPE_ADMIN), v1.AdminRewritePublicKeys)
+			m.Post("/webhooks/test-signature", middleware.ApiReqToken(), middleware.ApiReqAdmin(),
+				reqScope(models.ACCESS_TOKEN_SCOPE_ADMIN), bind(v1.TestSignatureOption{}), v1.TestWebhookSignature)
+
 			// Repositories.
-			m.Combo("/user/repos", middleware.ApiReqToken()).Get(v1.ListMyRepos).Post(bind(api.CreateRepoOption{}), v1.CreateRepo)
-			m.Post("/org/:org/repos", middleware.ApiReqToken(), bind(api.CreateRepoOption{}), v1.CreateOrgRepo)
+			m.Combo("/user/repos", middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_READ_REPO),
+				reqWriteScope(models.ACCESS_TOKEN_SCOPE_WRITE_REPO)).
+				Get(v1.ListMyRepos).Post(bind(api.CreateRepoOption{}), v1.CreateRepo)
+			m.Post("/org/:org/repos", middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_WRITE_REPO),
+				bind(api.CreateRepoOption{}), v1.CreateOrgRepo)
 			m.Group("/repos", func() {
 				m.Get("/search", v1.SearchRepos)
-				m.Post("/migrate", bindIgnErr(auth.MigrateRepoForm{}), v1.MigrateRepo)
+				m.Post("/migrate", reqScope(models.ACCESS_TOKEN_SCOPE_WRITE_REPO), bindIgnErr(auth.MigrateRepoForm{}), v1.MigrateRepo)
 
 				m.Group("/:username/:reponame", func() {
+					m.Combo("").Get(v1.GetRepo).Patch(bind(v1.EditRepoOption{}), v1.EditRepo)
+					m.Get("/branches/:branch/protection", v1.GetBranchProtection)
+					m.Get("/commits", v1.ListRepoCommits)
+					m.Get("/commits/:sha", v1.GetRepoCommit)
+					m.Get("/contributors", v1.ListRepoContributors)
+					m.Get("/languages", v1.ListRepoLanguages)
+					m.Combo("/subscription/push").Get(v1.GetPushSubscription).
+						Put(bind(v1.PushSubscriptionOption{}), v1.SubscribePush).Delete(v1.UnsubscribePush)
+					m.Get("/traffic/clones", v1.GetRepoCloneTraffic)
+					m.Get("/traffic/views", v1.GetRepoViewTraffic)
+					m.Group("/issues", func() {
+						m.Post("/labels/bulk", bind(v1.BulkIssueLabelsOption{}), v1.BulkIssueLabels)
+						m.Combo("/:index:int/labels").Post(bind(v1.IssueLabelsOption{}), v1.ReplaceIssueLabels).
+							Delete(v1.ClearIssueLabels)
+					}, middleware.ApiRepoIssuesEnabled())
 					m.Combo("/hooks").Get(v1.ListRepoHooks).Post(bind(api.CreateHookOption{}), v1.CreateRepoHook)
 					m.Patch("/hooks/:id:int", bind(api.EditHookOption{}), v1.EditRepoHook)
 					m.Get("/raw/*", middleware.RepoRef(), v1.GetRepoRawFile)
-				}, middleware.ApiRepoAssignment(), middleware.ApiReqToken())
+					m.Post("/generate", bind(v1.GenerateRepoOption{}), v1.GenerateRepo)
+				}, middleware.ApiRepoAssignment(), middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_READ_REPO),
+					reqWriteScope(models.ACCESS_TOKEN_SCOPE_WRITE_REPO))
 			})
 
+			// Organizations.
+			m.Group("/orgs/:orgname", func() {
+				m.Get("/members", v1.ListOrgMembers)
+				m.Get("/bots", v1.ListOrgBots)
+				m.Combo("/teams").Get(v1.ListOrgTeams).Post(bind(v1.CreateTeamOption{}), v1.CreateTeam)
+				m.Post("/transfer", bind(v1.TransferOrgOption{}), v1.TransferOrg)
+			}, middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_READ_ORG), reqWriteScope(models.ACCESS_TOKEN_SCOPE_WRITE_ORG))
+			m.Group("/teams/:id:int", func() {
+				m.Combo("").Get(v1.GetTeam).Patch(bind(v1.EditTeamOption{}), v1.EditTeam).Delete(v1.DeleteTeam)
+				m.Get("/members", v1.ListTeamMembers)
+				m.Combo("/members/:username").Get(v1.GetTeamMember).Put(v1.AddTeamMember).Delete(v1.RemoveTeamMember)
+				m.Combo("/repos/:owner/:reponame").Get(v1.GetTeamRepo).Put(v1.AddTeamRepo).Delete(v1.RemoveTeamRepo)
+			}, middleware.ApiReqToken(), reqScope(models.ACCESS_TOKEN_SCOPE_READ_ORG), reqWriteScope(models.ACCESS_TOKEN_SCOPE_WRITE_ORG))
+
 			m.Any("/*", func(ctx *middleware.Context) {
 				ctx.HandleAPI(404, "Page not found")
 			})
@@ -244,16 +317,31 @@ func runWeb(ctx *cli.Context) {
 		m.Get("/reset_password", user.ResetPasswd)
 		m.Post("/reset_password", user.ResetPasswdPost)
 	}, reqSignOut)
+	m.Group("/auth/saml/:source", func() {
+		m.Get("/metadata", user.SAMLMetadata)
+		m.Get("/login", user.SAMLLogin)
+		m.Post("/acs", user.SAMLAssertionConsumer)
+	}, ignSignInAndCsrf)
 	m.Group("/user/settings", func() {
 		m.Get("", user.Settings)
 		m.Post("", bindIgnErr(auth.UpdateProfileForm{}), user.SettingsPost)
 		m.Post("/avatar", binding.MultipartForm(auth.UploadAvatarForm{}), user.SettingsAvatar)
+		m.Post("/avatar/delete", user.SettingsDeleteAvatar)
 		m.Get("/email", user.SettingsEmails)
 		m.Post("/email", bindIgnErr(auth.AddEmailForm{}), user.SettingsEmailPost)
 		m.Get("/password", user.SettingsPassword)
 		m.Post("/password", bindIgnErr(auth.ChangePasswordForm{}), user.SettingsPasswordPost)
 		m.Get("/ssh", user.SettingsSSHKeys)
-		m.Post("/ssh", bindIgnErr(auth.AddSSHKeyForm{}), user.SettingsSSHKeysPost)
+		m.Post("/ssh", middleware.KeyRateLimit(), bindIgnErr(auth.AddSSHKeyForm{}), user.SettingsSSHKeysPost)
+		m.Post("/ssh/import", middleware.KeyRateLimit(), bindIgnErr(auth.ImportSSHKeysForm{}), user.SettingsSSHKeysImportPost)
+		m.Post("/ssh/source", middleware.KeyRateLimit(), bindIgnErr(auth.KeySourceForm{}), user.SettingsSSHKeySourcePost)
+		m.Get("/ssh/export", user.SettingsSSHKeysExport)
+		m.Get("/ssh/:id:int/activity", user.SettingsSSHKeyActivity)
+		m.Get("/gpg", user.SettingsGPGKeys)
+		m.Post("/gpg", bindIgnErr(auth.AddGPGKeyForm{}), user.SettingsGPGKeysPost)
+		m.Get("/security", user.SettingsSecurityLog)
+		m.Combo("/export").Get(user.SettingsExport).Post(user.SettingsExportPost)
+		m.Get("/export/:id:int", user.SettingsExportDownload)
 		m.Get("/social", user.SettingsSocial)
 		m.Combo("/applications").Get(user.SettingsApplications).Post(bindIgnErr(auth.NewAccessTokenForm{}), user.SettingsApplicationsPost)
 		m.Route("/delete", "GET,POST", user.SettingsDelete)
@@ -277,6 +365,7 @@ func runWeb(ctx *cli.Context) {
 
 	m.Group("/admin", func() {
 		m.Get("", adminReq, admin.Dashboard)
+		m.Post("/maintenance/recalculate-disk-usage", admin.RecalculateDiskUsage)
 		m.Get("/config", admin.Config)
 		m.Get("/monitor", admin.Monitor)
 
@@ -295,6 +384,27 @@ func runWeb(ctx *cli.Context) {
 
 		m.Group("/repos", func() {
 			m.Get("", admin.Repositories)
+			m.Post("/undelete", admin.RestoreRepository)
+			m.Post("/fsck-all", admin.CheckAllRepoHealth)
+			m.Post("/:id:int/fsck", admin.CheckRepoHealth)
+			m.Post("/mirrors/:id:int/confirm", admin.ConfirmMirrorHostKey)
+			m.Post("/mirrors/:id:int/rotate", admin.RotateMirrorHostKey)
+		})
+
+		m.Group("/keys", func() {
+			m.Get("", admin.Keys)
+			m.Get("/export", admin.ExportKeys)
+			m.Combo("/import").Get(admin.ImportKeys).
+				Post(binding.MultipartForm(auth.ImportKeysForm{}), admin.ImportKeysPost)
+			m.Get("/activity", admin.KeyActivities)
+			m.Get("/security", admin.SecurityLogs)
+			m.Get("/replication", admin.KeyReplication)
+			m.Post("/replication/sync", admin.KeyReplicationSync)
+			m.Post("/:id:int/delete", admin.DeleteKey)
+			m.Post("/:id:int/toggle", admin.ToggleKey)
+			m.Post("/:id:int/approve", admin.ApproveKey)
+			m.Post("/:id:int/reject", admin.RejectKey)
+			m.Post("/approve", admin.BulkApproveKeys)
 		})
 
 		m.Group("/auths", func() {
@@ -306,10 +416,20 @@ func runWeb(ctx *cli.Context) {
 			m.Post("/:authid/delete", admin.DeleteAuthSource)
 		})
 
+		m.Combo("/import/gitlab").Get(admin.ImportGitLab).Post(admin.ImportGitLabPost)
+
 		m.Group("/notices", func() {
 			m.Get("", admin.Notices)
 			m.Get("/:id:int/delete", admin.DeleteNotice)
 		})
+
+		m.Group("/hooks", func() {
+			m.Get("", admin.Webhooks)
+			m.Get("/new", repo.WebHooksNew)
+			m.Post("/gogs/new", bindIgnErr(auth.NewWebhookForm{}), repo.WebHooksNewPost)
+			m.Get("/:id", repo.WebHooksEdit)
+			m.Post("/gogs/:id", bindIgnErr(auth.NewWebhookForm{}), repo.WebHooksEditPost)
+		})
 	}, adminReq)
 
 	m.Get("/:username", ignSignIn, user.Profile)
@@ -354,6 +474,7 @@ func runWeb(ctx *cli.Context) {
 				m.Get("/hooks/:id", repo.WebHooksEdit)
 				m.Post("/hooks/gogs/:id", bindIgnErr(auth.NewWebhookForm{}), repo.WebHooksEditPost)
 				m.Post("/hooks/slack/:id", bindIgnErr(auth.NewSlackHookForm{}), repo.SlackHooksEditPost)
+				m.Route("/transfer", "GET,POST", org.SettingsTransfer)
 				m.Route("/delete", "GET,POST", org.SettingsDelete)
 			})
 
@@ -379,6 +500,11 @@ func runWeb(ctx *cli.Context) {
 		m.Post("/settings", bindIgnErr(auth.RepoSettingForm{}), repo.SettingsPost)
 		m.Group("/settings", func() {
 			m.Route("/collaboration", "GET,POST", repo.SettingsCollaboration)
+			m.Get("/commit_rules", repo.CommitRules)
+			m.Post("/commit_rules", bindIgnErr(auth.CommitMessageRuleForm{}), repo.CommitRulesPost)
+			m.Post("/commit_rules/conventional", repo.CommitRulesConventional)
+			m.Get("/tag_policies", repo.TagPolicies)
+			m.Post("/tag_policies", bindIgnErr(auth.TagPolicyForm{}), repo.TagPoliciesPost)
 			m.Get("/hooks", repo.Webhooks)
 			m.Get("/hooks/new", repo.WebHooksNew)
 			m.Post("/hooks/gogs/new", bindIgnErr(auth.NewWebhookForm{}), repo.WebHooksNewPost)
@@ -405,7 +531,6 @@ func runWeb(ctx *cli.Context) {
 			m.Post("/:index/label", repo.UpdateIssueLabel)
 			m.Post("/:index/milestone", repo.UpdateIssueMilestone)
 			m.Post("/:index/assignee", repo.UpdateAssignee)
-			m.Get("/:index/attachment/:id", repo.IssueGetAttachment)
 			m.Post("/labels/new", bindIgnErr(auth.CreateLabelForm{}), repo.NewLabel)
 			m.Post("/labels/edit", bindIgnErr(auth.CreateLabelForm{}), repo.UpdateLabel)
 			m.Post("/labels/delete", repo.DeleteLabel)
@@ -414,9 +539,9 @@ func runWeb(ctx *cli.Context) {
 			m.Get("/milestones/:index/edit", repo.UpdateMilestone)
 			m.Post("/milestones/:index/edit", bindIgnErr(auth.CreateMilestoneForm{}), repo.UpdateMilestonePost)
 			m.Get("/milestones/:index/:action", repo.UpdateMilestone)
-		})
+		}, middleware.RepoIssuesEnabled())
 
-		m.Post("/comment/:action", repo.Comment)
+		m.Post("/comment/:action", middleware.RepoIssuesEnabled(), repo.Comment)
 
 		m.Group("/releases", func() {
 			m.Get("/new", repo.NewRelease)
@@ -428,9 +553,12 @@ func runWeb(ctx *cli.Context) {
 
 	m.Group("/:username/:reponame", func() {
 		m.Get("/releases", middleware.RepoRef(), repo.Releases)
-		m.Get("/issues", repo.Issues)
-		m.Get("/issues/:index", repo.ViewIssue)
-		m.Get("/issues/milestones", repo.Milestones)
+		m.Get("/contributors", repo.Contributors)
+		m.Group("/issues", func() {
+			m.Get("", repo.Issues)
+			m.Get("/:index", repo.ViewIssue)
+			m.Get("/milestones", repo.Milestones)
+		}, middleware.RepoIssuesEnabled())
 		m.Get("/pulls", repo.Pulls)
 		m.Get("/branches", repo.Branches)
 		m.Get("/archive/*", repo.Download)
@@ -463,6 +591,22 @@ func runWeb(ctx *cli.Context) {
 		}
 	})
 
+	// Custom branding images.
+	m.Get("/branding/logo", func(ctx *middleware.Context) {
+		if len(setting.Branding.LogoPath) > 0 {
+			ctx.ServeFile(setting.Branding.LogoPath)
+		} else {
+			ctx.Error(404)
+		}
+	})
+	m.Get("/branding/favicon", func(ctx *middleware.Context) {
+		if len(setting.Branding.FaviconPath) > 0 {
+			ctx.ServeFile(setting.Branding.FaviconPath)
+		} else {
+			ctx.Error(404)
+		}
+	})
+
 	// Not found handler.
 	m.NotFound(routers.NotFound)
 
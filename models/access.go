@@ -170,15 +170,18 @@ func (repo *Repository) recalculateTeamAccesses(e Engine, ignTeamID int64) (err
 			if t.ID == ignTeamID {
 				continue
 			}
-			if t.IsOwnerTeam() {
-				t.Authorize = ACCESS_MODE_OWNER
+
+			// Repository-specific permission of team takes precedence over its default.
+			mode, err := t.teamRepoAccessMode(e, repo.Id)
+			if err != nil {
+				return fmt.Errorf("teamRepoAccessMode '%d': %v", t.ID, err)
 			}
 
 			if err = t.getMembers(e); err != nil {
 				return fmt.Errorf("getMembers '%d': %v", t.ID, err)
 			}
 			for _, m := range t.Members {
-				accessMap[m.Id] = maxAccessMode(accessMap[m.Id], t.Authorize)
+				accessMap[m.Id] = maxAccessMode(accessMap[m.Id], mode)
 			}
 		}
 	}
@@ -0,0 +1,317 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var (
+	ErrAccountExportNotExist   = errors.New("Account export does not exist")
+	ErrAccountExportInProgress = errors.New("Account export is already in progress")
+)
+
+type AccountExportStatus int
+
+const (
+	ACCOUNT_EXPORT_PENDING AccountExportStatus = iota + 1
+	ACCOUNT_EXPORT_READY
+	ACCOUNT_EXPORT_FAILED
+)
+
+// AccountExport represents an archive of all data stored about a user.
+// Archive is generated in background and stored under AccountExportPath.
+type AccountExport struct {
+	Id      int64
+	UserId  int64 `xorm:"INDEX"`
+	Status  AccountExportStatus
+	Created time.Time `xorm:"CREATED"`
+	Updated time.Time `xorm:"UPDATED"`
+}
+
+func (e *AccountExport) IsPending() bool {
+	return e.Status == ACCOUNT_EXPORT_PENDING
+}
+
+func (e *AccountExport) IsReady() bool {
+	return e.Status == ACCOUNT_EXPORT_READY
+}
+
+func (e *AccountExport) IsFailed() bool {
+	return e.Status == ACCOUNT_EXPORT_FAILED
+}
+
+// accountExportsPath returns path of directory that stores archives of given user.
+func accountExportsPath(uid int64) string {
+	return path.Join(setting.AccountExportPath, fmt.Sprint(uid))
+}
+
+// ArchivePath returns path of generated archive file.
+func (e *AccountExport) ArchivePath() string {
+	return path.Join(accountExportsPath(e.UserId), fmt.Sprintf("%d.zip", e.Id))
+}
+
+// FileName returns file name that archive is downloaded as.
+func (e *AccountExport) FileName(u *User) string {
+	return fmt.Sprintf("%s-%s.zip", u.LowerName, e.Created.Format("20060102150405"))
+}
+
+// accountExportUser represents profile of user in exported archive.
+type accountExportUser struct {
+	Name     string    `json:"name"`
+	FullName string    `json:"full_name"`
+	Email    string    `json:"email"`
+	Location string    `json:"location"`
+	Website  string    `json:"website"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// accountExportKey represents a public key in exported archive.
+type accountExportKey struct {
+	Name              string    `json:"name"`
+	Content           string    `json:"content"`
+	Fingerprint       string    `json:"fingerprint"`
+	Created           time.Time `json:"created"`
+	Updated           time.Time `json:"updated"`
+	HasUsed           bool      `json:"has_used"`
+	HasRecentActivity bool      `json:"has_recent_activity"`
+	IsDisabled        bool      `json:"is_disabled"`
+	IsPending         bool      `json:"is_pending"`
+	Verified          bool      `json:"verified"`
+	BelowPolicy       bool      `json:"below_policy"`
+}
+
+// accountExportSecurityLog represents a security log entry in exported archive.
+type accountExportSecurityLog struct {
+	Operation      string    `json:"operation"`
+	ActorType      string    `json:"actor_type"`
+	ActorName      string    `json:"actor_name,omitempty"`
+	KeyName        string    `json:"key_name"`
+	KeyFingerprint string    `json:"key_fingerprint"`
+	Created        time.Time `json:"created"`
+}
+
+var securityActorTypeNames = map[SecurityActorType]string{
+	SECURITY_ACTOR_SELF:   "self",
+	SECURITY_ACTOR_ADMIN:  "admin",
+	SECURITY_ACTOR_TOKEN:  "token",
+	SECURITY_ACTOR_SYSTEM: "system",
+}
+
+// writeJSONArray writes records of given bean type that match cond
+// to w as a JSON array, converting each record with conv.
+func writeJSONArray(w io.Writer, bean interface{}, cond string, args []interface{}, conv func(interface{}) interface{}) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	err := x.Where(cond, args...).Asc("id").Iterate(bean, func(idx int, bean interface{}) error {
+		data, err := json.MarshalIndent(conv(bean), "", "  ")
+		if err != nil {
+			return err
+		}
+		if idx > 0 {
+			if _, err = io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// writeAccountArchive writes all data of given user to w as a zip archive.
+func writeAccountArchive(w io.Writer, u *User) (err error) {
+	z := zip.NewWriter(w)
+	defer func() {
+		if cerr := z.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	f, err := z.Create("user.json")
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(&accountExportUser{
+		Name:     u.Name,
+		FullName: u.FullName,
+		Email:    u.Email,
+		Location: u.Location,
+		Website:  u.Website,
+		Created:  u.Created,
+		Updated:  u.Updated,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+
+	// Key content always comes from database rather than authorized_keys,
+	// which could be rewritten or out of sync.
+	if f, err = z.Create("keys.json"); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err = writeJSONArray(f, new(PublicKey), "owner_id=?", []interface{}{u.Id},
+		func(bean interface{}) interface{} {
+			key := bean.(*PublicKey)
+			return &accountExportKey{
+				Name:              key.Name,
+				Content:           strings.TrimSpace(key.Content),
+				Fingerprint:       key.Fingerprint,
+				Created:           key.Created,
+				Updated:           key.Updated,
+				HasUsed:           key.Updated.After(key.Created),
+				HasRecentActivity: keyHasRecentActivity(key.Updated, now),
+				IsDisabled:        key.IsDisabled,
+				IsPending:         key.IsPending,
+				Verified:          key.Verified,
+				BelowPolicy:       key.BelowPolicy,
+			}
+		}); err != nil {
+		return fmt.Errorf("write keys: %v", err)
+	}
+
+	if f, err = z.Create("security_log.json"); err != nil {
+		return err
+	}
+	if err = writeJSONArray(f, new(SecurityLog), "owner_id=? AND operation LIKE 'key_%'", []interface{}{u.Id},
+		func(bean interface{}) interface{} {
+			l := bean.(*SecurityLog)
+			return &accountExportSecurityLog{
+				Operation:      l.Operation,
+				ActorType:      securityActorTypeNames[l.ActorType],
+				ActorName:      l.ActorName,
+				KeyName:        l.KeyName,
+				KeyFingerprint: l.KeyFingerprint,
+				Created:        l.Created,
+			}
+		}); err != nil {
+		return fmt.Errorf("write security logs: %v", err)
+	}
+	return nil
+}
+
+// generateAccountExport writes archive of given export to disk
+// and updates its status accordingly.
+func generateAccountExport(u *User, e *AccountExport) {
+	err := func() error {
+		archivePath := e.ArchivePath()
+		if err := os.MkdirAll(path.Dir(archivePath), os.ModePerm); err != nil {
+			return err
+		}
+
+		// Write to temporary file first so a partial archive is never served.
+		tmpPath := archivePath + ".tmp"
+		fw, err := os.Create(tmpPath)
+		if err != nil {
+			return err
+		}
+		if err = writeAccountArchive(fw, u); err != nil {
+			fw.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		if err = fw.Close(); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		return os.Rename(tmpPath, archivePath)
+	}()
+
+	e.Status = ACCOUNT_EXPORT_READY
+	if err != nil {
+		log.Error(4, "generateAccountExport(%d): %v", e.Id, err)
+		e.Status = ACCOUNT_EXPORT_FAILED
+	}
+	if _, err = x.Id(e.Id).AllCols().Update(e); err != nil {
+		log.Error(4, "Update account export status(%d): %v", e.Id, err)
+	}
+}
+
+// CreateAccountExport starts generating a new data export of given user in background.
+// Only one export can be in progress for a user at a time, pending exports older
+// than an hour are considered abandoned (e.g. server restarted) and do not count.
+func CreateAccountExport(u *User) (*AccountExport, error) {
+	has, err := x.Where("user_id=? AND status=? AND created>?",
+		u.Id, ACCOUNT_EXPORT_PENDING, time.Now().Add(-time.Hour)).Get(new(AccountExport))
+	if err != nil {
+		return nil, err
+	} else if has {
+		return nil, ErrAccountExportInProgress
+	}
+
+	e := &AccountExport{
+		UserId: u.Id,
+		Status: ACCOUNT_EXPORT_PENDING,
+	}
+	if _, err = x.Insert(e); err != nil {
+		return nil, err
+	}
+
+	go generateAccountExport(u, e)
+	return e, nil
+}
+
+// GetAccountExports returns all data exports of given user, latest first.
+func GetAccountExports(uid int64) ([]*AccountExport, error) {
+	exports := make([]*AccountExport, 0, 5)
+	return exports, x.Where("user_id=?", uid).Desc("id").Find(&exports)
+}
+
+// GetAccountExportById returns data export of given user by given ID.
+func GetAccountExportById(uid, id int64) (*AccountExport, error) {
+	e := &AccountExport{Id: id, UserId: uid}
+	has, err := x.Get(e)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrAccountExportNotExist
+	}
+	return e, nil
+}
+
+// PruneAccountExports deletes data exports along with their archives
+// that are older than expiration period.
+func PruneAccountExports() {
+	if setting.AccountExportExpire <= 0 {
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -setting.AccountExportExpire)
+	exports := make([]*AccountExport, 0, 10)
+	if err := x.Where("created<?", before).Find(&exports); err != nil {
+		log.Error(4, "PruneAccountExports: %v", err)
+		return
+	}
+	for _, e := range exports {
+		if err := os.Remove(e.ArchivePath()); err != nil && !os.IsNotExist(err) {
+			log.Error(4, "Remove account export archive(%d): %v", e.Id, err)
+			continue
+		}
+		if _, err := x.Id(e.Id).Delete(new(AccountExport)); err != nil {
+			log.Error(4, "Delete account export(%d): %v", e.Id, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestAccountArchiveContainsKeys(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook))
+	defer cleanup()
+	var err error
+
+	u := &User{Id: 1, Name: "user1", LowerName: "user1"}
+	key := &PublicKey{
+		OwnerId:     1,
+		Name:        "laptop",
+		Fingerprint: "fingerprint",
+		Content:     "ssh-rsa AAAAB3NzaC1yc2E user1@fake.local\n",
+		IsDisabled:  true,
+	}
+	if _, err = x.Insert(key, &PublicKey{OwnerId: 2, Name: "other", Content: "ssh-rsa AAAA"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = logKeyOperation(x, key, SECURITY_OP_KEY_DISABLE, SecurityActor{Type: SECURITY_ACTOR_SELF, Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err = writeAccountArchive(buf, u); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = ioutil.ReadAll(r)
+		r.Close()
+	}
+
+	var keys []accountExportKey
+	if err = json.Unmarshal(files["keys.json"], &keys); err != nil {
+		t.Fatalf("decode keys.json: %v", err)
+	} else if len(keys) != 1 {
+		t.Fatalf("expect 1 key but got %d", len(keys))
+	}
+	if keys[0].Content != "ssh-rsa AAAAB3NzaC1yc2E user1@fake.local" || !keys[0].IsDisabled {
+		t.Errorf("unexpected exported key: %+v", keys[0])
+	}
+
+	var logs []accountExportSecurityLog
+	if err = json.Unmarshal(files["security_log.json"], &logs); err != nil {
+		t.Fatalf("decode security_log.json: %v", err)
+	} else if len(logs) != 1 || logs[0].Operation != SECURITY_OP_KEY_DISABLE || logs[0].ActorType != "self" {
+		t.Errorf("unexpected exported security logs: %+v", logs)
+	}
+}
+
+func TestPruneAccountExports(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(AccountExport))
+	defer cleanup()
+	var err error
+
+	oldPath, oldExpire := setting.AccountExportPath, setting.AccountExportExpire
+	defer func() {
+		setting.AccountExportPath, setting.AccountExportExpire = oldPath, oldExpire
+	}()
+	setting.AccountExportPath = filepath.Join(tmpDir, "exports")
+	setting.AccountExportExpire = 7
+
+	expired := &AccountExport{UserId: 1, Status: ACCOUNT_EXPORT_READY}
+	recent := &AccountExport{UserId: 1, Status: ACCOUNT_EXPORT_READY}
+	for _, e := range []*AccountExport{expired, recent} {
+		if _, err = x.Insert(e); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.MkdirAll(filepath.Dir(e.ArchivePath()), os.ModePerm); err != nil {
+			t.Fatal(err)
+		} else if err = ioutil.WriteFile(e.ArchivePath(), []byte("zip"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = x.Id(expired.Id).Cols("created").Update(&AccountExport{Created: time.Now().AddDate(0, 0, -8)}); err != nil {
+		t.Fatal(err)
+	}
+
+	PruneAccountExports()
+
+	if _, err = GetAccountExportById(1, expired.Id); err != ErrAccountExportNotExist {
+		t.Errorf("expect expired export to be deleted but got %v", err)
+	} else if _, err = os.Stat(expired.ArchivePath()); !os.IsNotExist(err) {
+		t.Errorf("expect archive of expired export to be removed but got %v", err)
+	}
+	if _, err = GetAccountExportById(1, recent.Id); err != nil {
+		t.Errorf("expect recent export to be kept but got %v", err)
+	} else if _, err = os.Stat(recent.ArchivePath()); err != nil {
+		t.Errorf("expect archive of recent export to be kept but got %v", err)
+	}
+}
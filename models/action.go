@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -52,8 +53,24 @@ func assembleKeywordsPattern(words []string) string {
 	return fmt.Sprintf(`(?i)(?:%s) \S+`, strings.Join(words, "|"))
 }
 
+// assembleIssueRefPattern returns pattern that matches any of given keywords
+// followed by an issue index, e.g. "Fixes #12".
+func assembleIssueRefPattern(words []string) string {
+	quoted := make([]string, len(words))
+	for i := range words {
+		quoted[i] = regexp.QuoteMeta(words[i])
+	}
+	return fmt.Sprintf(`(?i)(?:^|[^\w#])(%s):?\s+#(\d+)\b`, strings.Join(quoted, "|"))
+}
+
+// SetIssueCloseKeywords replaces keywords that close issues from commit messages.
+func SetIssueCloseKeywords(words []string) {
+	IssueCloseKeywords = words
+	IssueCloseKeywordsPat = regexp.MustCompile(assembleIssueRefPattern(words))
+}
+
 func init() {
-	IssueCloseKeywordsPat = regexp.MustCompile(assembleKeywordsPattern(IssueCloseKeywords))
+	SetIssueCloseKeywords(IssueCloseKeywords)
 	IssueReopenKeywordsPat = regexp.MustCompile(assembleKeywordsPattern(IssueReopenKeywords))
 	IssueReferenceKeywordsPat = regexp.MustCompile(`(?i)(?:)(^| )\S+`)
 }
@@ -124,7 +141,33 @@ func (a Action) GetIssueInfos() []string {
 	return strings.SplitN(a.Content, "|", 2)
 }
 
-func updateIssuesCommit(userId, repoId int64, repoUserName, repoName string, commits []*base.PushCommit) error {
+// IssueRef represents an issue of the same repository that a commit message
+// asks to close, e.g. "Fixes #12".
+type IssueRef struct {
+	Action     string // Keyword in lower case, e.g. "fixes".
+	IssueIndex int
+}
+
+// ParseIssueRefsFromMessage returns references to issues of the same repository
+// that given commit message closes, each issue is returned once.
+func ParseIssueRefsFromMessage(message string) []*IssueRef {
+	refs := make([]*IssueRef, 0, 2)
+	seen := make(map[int]bool)
+	for _, m := range IssueCloseKeywordsPat.FindAllStringSubmatch(message, -1) {
+		index, err := strconv.Atoi(m[2])
+		if err != nil || index <= 0 || seen[index] {
+			continue
+		}
+		seen[index] = true
+		refs = append(refs, &IssueRef{
+			Action:     strings.ToLower(m[1]),
+			IssueIndex: index,
+		})
+	}
+	return refs
+}
+
+func updateIssuesCommit(userId, repoId int64, repoUserName, repoName string, isDefaultBranch bool, commits []*base.PushCommit) error {
 	for _, c := range commits {
 		for _, ref := range IssueReferenceKeywordsPat.FindAllString(c.Message, -1) {
 			ref := ref[strings.IndexByte(ref, byte(' '))+1:]
@@ -158,60 +201,18 @@ func updateIssuesCommit(userId, repoId int64, repoUserName, repoName string, com
 			}
 		}
 
-		for _, ref := range IssueCloseKeywordsPat.FindAllString(c.Message, -1) {
-			ref := ref[strings.IndexByte(ref, byte(' '))+1:]
-			ref = strings.TrimRightFunc(ref, func(c rune) bool {
-				return !unicode.IsDigit(c)
-			})
-
-			if len(ref) == 0 {
-				continue
-			}
-
-			// Add repo name if missing
-			if ref[0] == '#' {
-				ref = fmt.Sprintf("%s/%s%s", repoUserName, repoName, ref)
-			} else if strings.Contains(ref, "/") == false {
-				// We don't support User#ID syntax yet
-				// return ErrNotImplemented
-
-				continue
-			}
-
-			issue, err := GetIssueByRef(ref)
-			if err != nil {
-				return err
-			}
-
-			if issue.RepoId == repoId {
-				if issue.IsClosed {
-					continue
-				}
-				issue.IsClosed = true
-
-				if err = issue.GetLabels(); err != nil {
-					return err
-				}
-				for _, label := range issue.Labels {
-					label.NumClosedIssues++
-
-					if err = UpdateLabel(label); err != nil {
-						return err
+		// Issues are only closed by commits that land on default branch,
+		// commit has been linked to the issue as a reference above.
+		if isDefaultBranch {
+			for _, ref := range ParseIssueRefsFromMessage(c.Message) {
+				issue, err := GetIssueByIndex(repoId, int64(ref.IssueIndex))
+				if err != nil {
+					if err == ErrIssueNotExist {
+						continue
 					}
-				}
-
-				if err = UpdateIssue(issue); err != nil {
-					return err
-				} else if err = UpdateIssueUserPairsByStatus(issue.Id, issue.IsClosed); err != nil {
 					return err
 				}
-
-				if err = ChangeMilestoneIssueStats(issue); err != nil {
-					return err
-				}
-
-				// If commit happened in the referenced repository, it means the issue can be closed.
-				if _, err = CreateComment(userId, repoId, issue.Id, 0, 0, COMMENT_TYPE_CLOSE, "", nil); err != nil {
+				if err = CloseIssueById(userId, issue.Id); err != nil {
 					return err
 				}
 			}
@@ -313,7 +314,7 @@ func CommitRepoAction(userId, repoUserId int64, userName, actEmail string,
 		return errors.New("action.CommitRepoAction(UpdateRepository): " + err.Error())
 	}
 
-	err = updateIssuesCommit(userId, repoId, repoUserName, repoName, commit.Commits)
+	err = updateIssuesCommit(userId, repoId, repoUserName, repoName, refName == repo.DefaultBranch, commit.Commits)
 
 	if err != nil {
 		log.Debug("action.CommitRepoAction(updateIssuesCommit): ", err)
@@ -433,6 +434,7 @@ func CommitRepoAction(userId, repoUserId int64, userName, actEmail string,
 					BasePayload: p,
 					ContentType: w.ContentType,
 					IsSsl:       w.IsSsl,
+					Secret:      w.Secret,
 				})
 			}
 		}
@@ -0,0 +1,45 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestParseIssueRefsFromMessage(t *testing.T) {
+	cases := []struct {
+		message string
+		refs    []IssueRef
+	}{
+		{"Fixes #1", []IssueRef{{"fixes", 1}}},
+		{"closes #2, resolve #3\n\nAlso fix #2 again", []IssueRef{{"closes", 2}, {"resolve", 3}}},
+		{"RESOLVED: #4", []IssueRef{{"resolved", 4}}},
+		{"See #5 and prefix #6", nil},
+		{"fixes user/repo#7", nil},
+		{"unfixed #8", nil},
+	}
+	for _, c := range cases {
+		refs := ParseIssueRefsFromMessage(c.message)
+		if len(refs) != len(c.refs) {
+			t.Errorf("%q: expect %d references but got %d", c.message, len(c.refs), len(refs))
+			continue
+		}
+		for i := range refs {
+			if *refs[i] != c.refs[i] {
+				t.Errorf("%q: expect %+v but got %+v", c.message, c.refs[i], *refs[i])
+			}
+		}
+	}
+}
+
+func TestSetIssueCloseKeywords(t *testing.T) {
+	defer SetIssueCloseKeywords(IssueCloseKeywords)
+
+	SetIssueCloseKeywords([]string{"done", "c++"})
+	if refs := ParseIssueRefsFromMessage("Fixes #1, done #2, c++ #3"); len(refs) != 2 ||
+		refs[0].IssueIndex != 2 || refs[1].IssueIndex != 3 {
+		t.Errorf("unexpected references with custom keywords: %v", refs)
+	}
+}
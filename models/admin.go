@@ -14,6 +14,8 @@ type NoticeType int
 
 const (
 	NOTICE_REPOSITORY NoticeType = iota + 1
+	NOTICE_KEY_REPLICATION
+	NOTICE_KEY_CHANGE_HOOK
 )
 
 // Notice represents a system notice for admin.
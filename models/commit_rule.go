@@ -0,0 +1,175 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/process"
+)
+
+var (
+	ErrCommitMessageRuleNotExist       = errors.New("Commit message rule does not exist")
+	ErrCommitMessageRuleInvalidPattern = errors.New("Commit message rule pattern is not a valid regular expression")
+)
+
+const (
+	CONVENTIONAL_COMMITS_PATTERN       = `^(build|chore|ci|docs|feat|fix|perf|refactor|revert|style|test)(\([\w\-\.]+\))?!?: .+`
+	CONVENTIONAL_COMMITS_ERROR_MESSAGE = "Commit message must follow Conventional Commits, e.g. 'feat(scope): add something'"
+)
+
+// CommitMessageRule represents a regular expression that commit messages
+// pushed to the repository are validated against.
+type CommitMessageRule struct {
+	Id           int64
+	RepoId       int64 `xorm:"INDEX"`
+	Pattern      string
+	ErrorMessage string
+	IsRequired   bool
+	IsActive     bool
+	Created      time.Time `xorm:"CREATED"`
+}
+
+// Match returns true if given commit message matches the rule.
+func (r *CommitMessageRule) Match(msg string) bool {
+	reg, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return false
+	}
+	return reg.MatchString(msg)
+}
+
+// NewCommitMessageRule creates a new commit message rule.
+func NewCommitMessageRule(r *CommitMessageRule) error {
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return ErrCommitMessageRuleInvalidPattern
+	}
+	_, err := x.Insert(r)
+	return err
+}
+
+// NewConventionalCommitsRule creates the built-in required rule
+// of Conventional Commits for given repository.
+func NewConventionalCommitsRule(repoId int64) error {
+	return NewCommitMessageRule(&CommitMessageRule{
+		RepoId:       repoId,
+		Pattern:      CONVENTIONAL_COMMITS_PATTERN,
+		ErrorMessage: CONVENTIONAL_COMMITS_ERROR_MESSAGE,
+		IsRequired:   true,
+		IsActive:     true,
+	})
+}
+
+// GetCommitMessageRuleById returns the commit message rule of repository by given ID.
+func GetCommitMessageRuleById(repoId, id int64) (*CommitMessageRule, error) {
+	r := &CommitMessageRule{
+		Id:     id,
+		RepoId: repoId,
+	}
+	has, err := x.Get(r)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrCommitMessageRuleNotExist
+	}
+	return r, nil
+}
+
+// GetCommitMessageRules returns all commit message rules of given repository.
+func GetCommitMessageRules(repoId int64) ([]*CommitMessageRule, error) {
+	rules := make([]*CommitMessageRule, 0, 5)
+	return rules, x.Where("repo_id=?", repoId).Asc("id").Find(&rules)
+}
+
+// GetActiveCommitMessageRules returns active commit message rules of given repository.
+func GetActiveCommitMessageRules(repoId int64) ([]*CommitMessageRule, error) {
+	rules := make([]*CommitMessageRule, 0, 5)
+	return rules, x.Where("repo_id=?", repoId).And("is_active=?", true).Asc("id").Find(&rules)
+}
+
+// UpdateCommitMessageRule updates information of commit message rule.
+func UpdateCommitMessageRule(r *CommitMessageRule) error {
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return ErrCommitMessageRuleInvalidPattern
+	}
+	_, err := x.Id(r.Id).AllCols().Update(r)
+	return err
+}
+
+// DeleteCommitMessageRule deletes commit message rule of repository by given ID.
+func DeleteCommitMessageRule(repoId, id int64) error {
+	_, err := x.Delete(&CommitMessageRule{Id: id, RepoId: repoId})
+	return err
+}
+
+// ErrCommitMessageRejected represents a pushed commit that failed a required rule.
+type ErrCommitMessageRejected struct {
+	CommitId string
+	Rule     *CommitMessageRule
+}
+
+func (err ErrCommitMessageRejected) Error() string {
+	return fmt.Sprintf("commit %s rejected: %s", err.CommitId, err.Rule.ErrorMessage)
+}
+
+func IsErrCommitMessageRejected(err error) bool {
+	_, ok := err.(ErrCommitMessageRejected)
+	return ok
+}
+
+// ValidateCommitMessages validates messages of commits that are newly introduced
+// by updating a reference to newCommitId against active rules of repository.
+// It must be called before the reference is updated, i.e. in the update hook.
+// Warnings of failed optional rules are returned along with the error of
+// the first failed required rule.
+func ValidateCommitMessages(repoId int64, repoPath, refName, newCommitId string) (warnings []string, err error) {
+	if strings.HasPrefix(newCommitId, "0000000") || strings.HasPrefix(refName, "refs/tags/") {
+		return nil, nil
+	}
+
+	rules, err := GetActiveCommitMessageRules(repoId)
+	if err != nil {
+		return nil, fmt.Errorf("GetActiveCommitMessageRules: %v", err)
+	} else if len(rules) == 0 {
+		return nil, nil
+	}
+
+	// Only check commits that do not exist in any reference yet.
+	stdout, stderr, err := process.ExecDir(-1, repoPath,
+		fmt.Sprintf("ValidateCommitMessages(git rev-list): %s", repoPath),
+		"git", "rev-list", newCommitId, "--not", "--all")
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list: %s", stderr)
+	}
+
+	repo, err := git.OpenRepository(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("OpenRepository: %v", err)
+	}
+
+	for _, commitId := range strings.Fields(stdout) {
+		commit, err := repo.GetCommit(commitId)
+		if err != nil {
+			return nil, fmt.Errorf("GetCommit(%s): %v", commitId, err)
+		}
+
+		msg := commit.Message()
+		for _, r := range rules {
+			if r.Match(msg) {
+				continue
+			}
+			if r.IsRequired {
+				return warnings, ErrCommitMessageRejected{commitId, r}
+			}
+			warnings = append(warnings, fmt.Sprintf("commit %s: %s", commitId, r.ErrorMessage))
+		}
+	}
+	return warnings, nil
+}
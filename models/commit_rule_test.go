@@ -0,0 +1,116 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommitMessageRuleMatch(t *testing.T) {
+	r := &CommitMessageRule{Pattern: CONVENTIONAL_COMMITS_PATTERN}
+	for msg, expect := range map[string]bool{
+		"feat: add something":              true,
+		"fix(ssh): handle unborn HEAD\n":   true,
+		"refactor!: drop old API":          true,
+		"Add something":                    false,
+		"feat:missing space":               false,
+		"wip(ssh): not a conventional one": false,
+	} {
+		if r.Match(msg) != expect {
+			t.Errorf("expect %q matching conventional commits to be %v", msg, expect)
+		}
+	}
+
+	if (&CommitMessageRule{Pattern: "[a"}).Match("a") {
+		t.Error("expect invalid pattern to match nothing")
+	}
+}
+
+func TestNewCommitMessageRuleInvalidPattern(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(CommitMessageRule))
+	defer cleanup()
+
+	if err := NewCommitMessageRule(&CommitMessageRule{RepoId: 1, Pattern: "[a"}); err != ErrCommitMessageRuleInvalidPattern {
+		t.Errorf("expect ErrCommitMessageRuleInvalidPattern but got %v", err)
+	}
+	if count, err := x.Count(new(CommitMessageRule)); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Errorf("expect invalid rule not to be saved but got %d rules", count)
+	}
+}
+
+func TestValidateCommitMessages(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(CommitMessageRule))
+	defer cleanup()
+	var err error
+
+	repoPath := filepath.Join(tmpDir, "repo")
+	runGit(t, tmpDir, "init", repoPath)
+	if err = ioutil.WriteFile(filepath.Join(repoPath, "README.md"), []byte("# repo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "add", "--all")
+	runGit(t, repoPath, "commit", "-m", "Initial commit")
+
+	// Commits that are not referenced yet, as seen by update hook before ref is updated.
+	good := runGit(t, repoPath, "commit-tree", "HEAD^{tree}", "-p", "HEAD", "-m", "feat: add README")
+	bad := runGit(t, repoPath, "commit-tree", "HEAD^{tree}", "-p", good, "-m", "Update README")
+
+	// No active rule, nothing is checked.
+	if err = NewCommitMessageRule(&CommitMessageRule{RepoId: 1, Pattern: "^never", IsRequired: true}); err != nil {
+		t.Fatal(err)
+	}
+	if warnings, err := ValidateCommitMessages(1, repoPath, "refs/heads/master", bad); err != nil || len(warnings) > 0 {
+		t.Errorf("expect inactive rule to be ignored but got %v, %v", warnings, err)
+	}
+
+	if err = NewConventionalCommitsRule(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = NewCommitMessageRule(&CommitMessageRule{RepoId: 1, Pattern: "README", ErrorMessage: "mention README", IsActive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Initial commit is referenced already so it is not checked again.
+	if warnings, err := ValidateCommitMessages(1, repoPath, "refs/heads/master", good); err != nil {
+		t.Errorf("expect conventional commit to be accepted but got %v", err)
+	} else if len(warnings) > 0 {
+		t.Errorf("expect no warning but got %v", warnings)
+	}
+
+	warnings, err := ValidateCommitMessages(1, repoPath, "refs/heads/master", bad)
+	if !IsErrCommitMessageRejected(err) {
+		t.Fatalf("expect ErrCommitMessageRejected but got %v", err)
+	} else if rejected := err.(ErrCommitMessageRejected); rejected.CommitId != bad ||
+		rejected.Rule.Pattern != CONVENTIONAL_COMMITS_PATTERN {
+		t.Errorf("unexpected rejection: %v", err)
+	} else if len(warnings) > 0 {
+		t.Errorf("expect no warning before rejection but got %v", warnings)
+	}
+
+	// Optional rule only warns.
+	if err = NewCommitMessageRule(&CommitMessageRule{RepoId: 1, Pattern: "^feat", ErrorMessage: "not a feature", IsActive: true}); err != nil {
+		t.Fatal(err)
+	}
+	fix := runGit(t, repoPath, "commit-tree", "HEAD^{tree}", "-p", "HEAD", "-m", "fix: typo in README")
+	if warnings, err = ValidateCommitMessages(1, repoPath, "refs/heads/master", fix); err != nil {
+		t.Fatal(err)
+	} else if len(warnings) != 1 || warnings[0] != "commit "+fix+": not a feature" {
+		t.Errorf("expect 1 warning of optional rule but got %v", warnings)
+	}
+
+	// Tags and deletions are not validated.
+	if _, err = ValidateCommitMessages(1, repoPath, "refs/tags/v1.0", bad); err != nil {
+		t.Errorf("expect tag to be skipped but got %v", err)
+	}
+	if _, err = ValidateCommitMessages(1, repoPath, "refs/heads/master", "0000000000000000000000000000000000000000"); err != nil {
+		t.Errorf("expect deletion to be skipped but got %v", err)
+	}
+}
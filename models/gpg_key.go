@@ -0,0 +1,483 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var (
+	ErrGPGKeyNotExist          = errors.New("GPG key does not exist")
+	ErrGPGKeyAlreadyExist      = errors.New("GPG key already exists")
+	ErrGPGKeyInvalid           = errors.New("GPG key is not a valid armored public key")
+	ErrGPGCommitNotSigned      = errors.New("Commit is not signed")
+	ErrGPGTagNotSigned         = errors.New("Tag is not signed")
+	ErrGPGSignatureNotVerified = errors.New("Commit signature cannot be verified")
+)
+
+// GPGKey represents a GPG public key that is used to verify signed commits.
+type GPGKey struct {
+	Id               int64
+	UserId           int64  `xorm:"INDEX NOT NULL"`
+	KeyId            string `xorm:"UNIQUE NOT NULL"`
+	ArmoredPublicKey string `xorm:"TEXT NOT NULL"`
+	CanVerify        bool
+	Verified         bool
+	Added            time.Time `xorm:"CREATED"`
+}
+
+// gnupgHome returns GnuPG home directory that holds keyring of all added keys.
+func gnupgHome() string {
+	return path.Join(setting.RepoRootPath, ".gnupg")
+}
+
+// execGPG runs gpg with given arguments against keyring of Gogs.
+func execGPG(stdin string, args ...string) (string, error) {
+	home := gnupgHome()
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch"}, args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// importGPGKey imports armored public key into keyring
+// and returns fingerprint of imported primary key.
+func importGPGKey(armored string) (string, error) {
+	stdout, err := execGPG(armored, "--status-fd", "1", "--import")
+	if err != nil {
+		return "", err
+	}
+
+	// [GNUPG:] IMPORT_OK <reason> <fingerprint>
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 4 && fields[0] == "[GNUPG:]" && fields[1] == "IMPORT_OK" {
+			return strings.ToUpper(fields[3]), nil
+		}
+	}
+	return "", ErrGPGKeyInvalid
+}
+
+// inspectGPGKey returns whether key of given fingerprint is capable of signing
+// and e-mail addresses of its user IDs.
+func inspectGPGKey(fingerprint string) (canSign bool, emails []string, err error) {
+	stdout, err := execGPG("", "--with-colons", "--fixed-list-mode", "--list-keys", fingerprint)
+	if err != nil {
+		return false, nil, err
+	}
+
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub" && len(fields) > 11:
+			canSign = strings.ContainsAny(fields[11], "sS")
+		case fields[0] == "uid" && len(fields) > 9:
+			uid := fields[9]
+			if start, end := strings.LastIndex(uid, "<"), strings.LastIndex(uid, ">"); start > -1 && end > start {
+				emails = append(emails, strings.ToLower(uid[start+1:end]))
+			}
+		}
+	}
+	return canSign, emails, nil
+}
+
+// AddGPGKey adds new GPG key for given user. The key is marked as verified
+// when one of its user IDs matches an activated e-mail address of the user.
+func AddGPGKey(userID int64, armored string) (*GPGKey, error) {
+	armored = strings.TrimSpace(armored)
+	if !strings.HasPrefix(armored, "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		return nil, ErrGPGKeyInvalid
+	}
+
+	fingerprint, err := importGPGKey(armored)
+	if err != nil {
+		log.Error(4, "importGPGKey: %v", err)
+		return nil, ErrGPGKeyInvalid
+	}
+	keyID := fingerprint[len(fingerprint)-16:]
+
+	has, err := x.Get(&GPGKey{KeyId: keyID})
+	if err != nil {
+		return nil, err
+	} else if has {
+		return nil, ErrGPGKeyAlreadyExist
+	}
+
+	canSign, uidEmails, err := inspectGPGKey(fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("inspectGPGKey: %v", err)
+	}
+
+	emails, err := GetEmailAddresses(userID)
+	if err != nil {
+		return nil, fmt.Errorf("GetEmailAddresses: %v", err)
+	}
+	verified := false
+	for _, e := range emails {
+		if !e.IsActivated {
+			continue
+		}
+		for _, email := range uidEmails {
+			if strings.ToLower(e.Email) == email {
+				verified = true
+				break
+			}
+		}
+	}
+
+	key := &GPGKey{
+		UserId:           userID,
+		KeyId:            keyID,
+		ArmoredPublicKey: armored,
+		CanVerify:        canSign,
+		Verified:         verified,
+	}
+	if _, err = x.Insert(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DeleteGPGKey deletes GPG key of given user by ID and removes it from keyring.
+func DeleteGPGKey(userID, id int64) error {
+	key := &GPGKey{Id: id, UserId: userID}
+	has, err := x.Get(key)
+	if err != nil {
+		return err
+	} else if !has {
+		return ErrGPGKeyNotExist
+	}
+
+	if _, err = x.Id(key.Id).Delete(new(GPGKey)); err != nil {
+		return err
+	}
+
+	if _, err = execGPG("", "--yes", "--delete-keys", key.KeyId); err != nil {
+		log.Error(4, "Fail to delete GPG key(%s) from keyring: %v", key.KeyId, err)
+	}
+	return nil
+}
+
+// ListGPGKeys returns all GPG keys of given user.
+func ListGPGKeys(userID int64) ([]*GPGKey, error) {
+	keys := make([]*GPGKey, 0, 5)
+	return keys, x.Where("user_id=?", userID).Find(&keys)
+}
+
+// GetGPGKeyByKeyID returns GPG key by given 16-character key ID.
+func GetGPGKeyByKeyID(keyID string) (*GPGKey, error) {
+	key := &GPGKey{KeyId: strings.ToUpper(keyID)}
+	has, err := x.Get(key)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrGPGKeyNotExist
+	}
+	return key, nil
+}
+
+// isKeyOfEmail returns true if given key is verified and belongs to the user
+// who has activated given e-mail, so signatures cannot be attributed to others.
+func isKeyOfEmail(key *GPGKey, email string) (bool, error) {
+	if !key.Verified {
+		return false, nil
+	}
+	u, err := GetUserByActivatedEmail(email)
+	if err != nil {
+		if err == ErrUserNotExist {
+			return false, nil
+		}
+		return false, err
+	}
+	return key.UserId == u.Id, nil
+}
+
+// VerifyCommitSignature verifies signature of given commit and returns the GPG key
+// that made the signature, the key must be one of the committer's.
+func VerifyCommitSignature(c *git.Commit) (*GPGKey, error) {
+	status, err := c.VerifySignature(gnupgHome())
+	if !strings.Contains(status, "[GNUPG:]") {
+		return nil, ErrGPGCommitNotSigned
+	} else if err != nil {
+		return nil, ErrGPGSignatureNotVerified
+	}
+
+	key, err := signingGPGKey(status)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := isKeyOfEmail(key, c.Committer.Email); err != nil {
+		return nil, fmt.Errorf("isKeyOfEmail: %v", err)
+	} else if !ok {
+		return nil, ErrGPGSignatureNotVerified
+	}
+	return key, nil
+}
+
+// signingGPGKey returns registered GPG key that made a valid signature
+// according to machine-readable status output of GnuPG.
+func signingGPGKey(status string) (*GPGKey, error) {
+	// [GNUPG:] VALIDSIG <fingerprint> ... <primary-key-fingerprint>
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+
+		fingerprint := fields[len(fields)-1]
+		if len(fingerprint) < 16 {
+			break
+		}
+		key, err := GetGPGKeyByKeyID(fingerprint[len(fingerprint)-16:])
+		if err != nil {
+			if err == ErrGPGKeyNotExist {
+				return nil, ErrGPGSignatureNotVerified
+			}
+			return nil, err
+		} else if !key.CanVerify {
+			return nil, ErrGPGSignatureNotVerified
+		}
+		return key, nil
+	}
+	return nil, ErrGPGSignatureNotVerified
+}
+
+// CommitVerification represents result of verifying signature of a commit.
+type CommitVerification struct {
+	IsSigned    bool
+	IsVerified  bool
+	Reason      string // One of SIGNATURE_* when known.
+	Key         *GPGKey
+	SSHKey      *PublicKey // Key that made the signature when signed with SSH.
+	Fingerprint string     // Fingerprint of SSH key that made the signature.
+}
+
+// SignerKeyId returns ID of GPG key or fingerprint of SSH key that made the signature.
+func (v *CommitVerification) SignerKeyId() string {
+	if v.Key != nil {
+		return v.Key.KeyId
+	}
+	return v.Fingerprint
+}
+
+// IsSSH returns true if commit is signed with an SSH key.
+func (v *CommitVerification) IsSSH() bool {
+	return len(v.Fingerprint) > 0
+}
+
+// ParseCommitVerification returns verification result of given commit.
+func ParseCommitVerification(c *git.Commit) *CommitVerification {
+	if isSSHSigned(c.Signature) {
+		return verifySSHSignature(c.Signature, c.Committer.Email)
+	}
+
+	key, err := VerifyCommitSignature(c)
+	switch err {
+	case nil:
+		return &CommitVerification{IsSigned: true, IsVerified: true, Reason: SIGNATURE_GOOD, Key: key}
+	case ErrGPGCommitNotSigned:
+		return &CommitVerification{}
+	case ErrGPGSignatureNotVerified:
+		return &CommitVerification{IsSigned: true}
+	}
+	log.Error(4, "VerifyCommitSignature(%s): %v", c.Id, err)
+	return &CommitVerification{IsSigned: true}
+}
+
+type SignCommit struct {
+	*UserCommit
+	Verification *CommitVerification
+}
+
+// ParseCommitsWithSignature attaches verification results of signatures
+// to commits that have been validated with e-mails.
+func ParseCommitsWithSignature(oldCommits *list.List) *list.List {
+	newCommits := list.New()
+	for e := oldCommits.Front(); e != nil; e = e.Next() {
+		c := e.Value.(UserCommit)
+		newCommits.PushBack(SignCommit{
+			UserCommit:   &c,
+			Verification: ParseCommitVerification(c.Commit),
+		})
+	}
+	return newCommits
+}
+
+// Reasons of commit signature verification.
+const (
+	SIGNATURE_GOOD   = "good"
+	SIGNATURE_BAD    = "bad"
+	SIGNATURE_NO_KEY = "no-key"
+
+	// Signed with an SSH key that has been deleted by its owner since.
+	SIGNATURE_KEY_DELETED = "key-deleted"
+)
+
+// CommitWithVerification represents verification result of GPG signature of a commit.
+type CommitWithVerification struct {
+	CommitId       string
+	CommitterEmail string
+	Verified       bool
+	Reason         string // Empty when commit is not signed.
+	SignerName     string
+	SignerEmail    string
+	Key            *GPGKey // Key that made the signature, nil when not verified.
+}
+
+// parseSigner splits `Name <email>` quoted in output of GnuPG.
+func parseSigner(s string) (name, email string) {
+	start, end := strings.Index(s, "\""), strings.LastIndex(s, "\"")
+	if start == -1 || end <= start {
+		return "", ""
+	}
+	s = s[start+1 : end]
+	if i := strings.LastIndex(s, " <"); i > -1 && strings.HasSuffix(s, ">") {
+		return s[:i], s[i+2 : len(s)-1]
+	}
+	return s, ""
+}
+
+// parseSignatureOutput returns verification result and ID of signing key
+// from human-readable output of GnuPG printed by "git log --show-signature".
+func parseSignatureOutput(output string) (cv *CommitWithVerification, keyID string) {
+	cv = new(CommitWithVerification)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "gpg:"))
+		switch {
+		case strings.HasPrefix(line, "Good signature from"):
+			cv.Reason = SIGNATURE_GOOD
+			cv.SignerName, cv.SignerEmail = parseSigner(line)
+		case strings.HasPrefix(line, "BAD signature from"):
+			cv.Reason = SIGNATURE_BAD
+			cv.SignerName, cv.SignerEmail = parseSigner(line)
+		case strings.HasPrefix(line, "Can't check signature"):
+			cv.Reason = SIGNATURE_NO_KEY
+		case strings.Contains(line, "using ") && strings.Contains(line, " key"):
+			// "using RSA key <fingerprint>" or "using RSA key ID <short ID>".
+			fields := strings.Fields(line)
+			keyID = strings.ToUpper(fields[len(fields)-1])
+			if len(keyID) > 16 {
+				keyID = keyID[len(keyID)-16:]
+			}
+		}
+	}
+	if cv.Reason == "" && len(output) > 0 {
+		cv.Reason = SIGNATURE_BAD
+	}
+	return cv, keyID
+}
+
+// GetSignedCommits returns results of verifying GPG signatures of commits of given page
+// from ref of repository, signatures are only verified against verified GPG keys of committers.
+// SSH signatures are verified by ParseCommitsWithVerifications since GnuPG cannot check them.
+func GetSignedCommits(repoId int64, ref string, page, limit int) ([]*CommitWithVerification, error) {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return nil, err
+	}
+	repoPath, err := repo.RepoPath()
+	if err != nil {
+		return nil, err
+	}
+	gitRepo, err := git.OpenRepository(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("OpenRepository: %v", err)
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	sigs, err := gitRepo.LogSignatures(ref, (page-1)*limit, limit, gnupgHome())
+	if err != nil {
+		return nil, fmt.Errorf("LogSignatures: %v", err)
+	}
+
+	commits := make([]*CommitWithVerification, len(sigs))
+	for i, sig := range sigs {
+		cv, keyID := parseSignatureOutput(sig.Output)
+		cv.CommitId, cv.CommitterEmail = sig.CommitId, sig.CommitterEmail
+		commits[i] = cv
+		if cv.Reason != SIGNATURE_GOOD {
+			continue
+		}
+
+		// Keyring only holds keys added by users, but the key may have been
+		// deleted in Gogs, not be allowed to sign or belong to someone else.
+		key, err := GetGPGKeyByKeyID(keyID)
+		if err != nil {
+			if err != ErrGPGKeyNotExist {
+				return nil, fmt.Errorf("GetGPGKeyByKeyID(%s): %v", keyID, err)
+			}
+			cv.Reason = SIGNATURE_NO_KEY
+			continue
+		} else if !key.CanVerify {
+			cv.Reason = SIGNATURE_NO_KEY
+			continue
+		}
+
+		ok, err := isKeyOfEmail(key, cv.CommitterEmail)
+		if err != nil {
+			return nil, fmt.Errorf("isKeyOfEmail: %v", err)
+		} else if !ok {
+			cv.Reason = SIGNATURE_NO_KEY
+			continue
+		}
+		cv.Verified = true
+		cv.Key = key
+	}
+	return commits, nil
+}
+
+// ParseCommitsWithVerifications attaches given verification results of signatures
+// to commits that have been validated with e-mails.
+func ParseCommitsWithVerifications(oldCommits *list.List, verifications []*CommitWithVerification) *list.List {
+	results := make(map[string]*CommitWithVerification, len(verifications))
+	for _, v := range verifications {
+		results[v.CommitId] = v
+	}
+
+	newCommits := list.New()
+	for e := oldCommits.Front(); e != nil; e = e.Next() {
+		c := e.Value.(UserCommit)
+		verification := &CommitVerification{}
+		if isSSHSigned(c.Signature) {
+			// GnuPG cannot check SSH signatures, they are verified natively instead.
+			verification = verifySSHSignature(c.Signature, c.Committer.Email)
+		} else if v, ok := results[c.Id.String()]; ok && len(v.Reason) > 0 {
+			verification = &CommitVerification{
+				IsSigned:   true,
+				IsVerified: v.Verified,
+				Reason:     v.Reason,
+				Key:        v.Key,
+			}
+		}
+		newCommits.PushBack(SignCommit{
+			UserCommit:   &c,
+			Verification: verification,
+		})
+	}
+	return newCommits
+}
@@ -0,0 +1,63 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestIsKeyOfEmail(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(EmailAddress))
+	defer cleanup()
+	var err error
+
+	alice := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true, IsEmailConfirmed: true}
+	mallory := &User{Name: "mallory", LowerName: "mallory", Email: "mallory@example.com", IsActive: true,
+		EmailConfirmToken: "unconfirmed"}
+	for _, u := range []*User{alice, mallory} {
+		if _, err = x.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range []*EmailAddress{
+		{Uid: alice.Id, Email: "alice@work.example.com", IsActivated: true},
+		{Uid: alice.Id, Email: "alice@home.example.com"},
+	} {
+		if _, err = x.Insert(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	verified := &GPGKey{UserId: alice.Id, KeyId: "0123456789ABCDEF", CanVerify: true, Verified: true}
+	unverified := &GPGKey{UserId: alice.Id, KeyId: "FEDCBA9876543210", CanVerify: true}
+	for _, c := range []struct {
+		key    *GPGKey
+		email  string
+		expect bool
+	}{
+		{verified, "alice@example.com", true},
+		{verified, "Alice@Work.example.com", true},
+		{verified, "alice@home.example.com", false},
+		{verified, "mallory@example.com", false},
+		{verified, "nobody@example.com", false},
+		{unverified, "alice@example.com", false},
+	} {
+		if ok, err := isKeyOfEmail(c.key, c.email); err != nil {
+			t.Fatal(err)
+		} else if ok != c.expect {
+			t.Errorf("isKeyOfEmail(%s, %s): expect %v but got %v", c.key.KeyId, c.email, c.expect, ok)
+		}
+	}
+
+	// Primary e-mail that has not been confirmed cannot be used to claim signatures.
+	setting.Service.RegisterEmailConfirm = true
+	if _, err = GetUserByActivatedEmail(mallory.Email); err != ErrUserNotExist {
+		t.Errorf("expect unconfirmed primary e-mail not to match but got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestParseSignatureOutput(t *testing.T) {
+	tests := []struct {
+		output      string
+		reason      string
+		keyID       string
+		signerName  string
+		signerEmail string
+	}{
+		{"", "", "", "", ""},
+		{`gpg: Signature made Sun Mar  1 12:00:00 2015 UTC
+gpg:                using RSA key 0123456789ABCDEF0123456789ABCDEF01234567
+gpg: Good signature from "Joe Doe <joe@example.com>" [unknown]`,
+			SIGNATURE_GOOD, "89ABCDEF01234567", "Joe Doe", "joe@example.com"},
+		{`gpg: Signature made Sun Mar  1 12:00:00 2015 UTC using RSA key ID 01234567
+gpg: BAD signature from "Joe Doe <joe@example.com>"`,
+			SIGNATURE_BAD, "01234567", "Joe Doe", "joe@example.com"},
+		{`gpg: Signature made Sun Mar  1 12:00:00 2015 UTC
+gpg:                using RSA key 89abcdef01234567
+gpg: Can't check signature: No public key`,
+			SIGNATURE_NO_KEY, "89ABCDEF01234567", "", ""},
+	}
+	for i, tc := range tests {
+		cv, keyID := parseSignatureOutput(tc.output)
+		if cv.Reason != tc.reason || keyID != tc.keyID ||
+			cv.SignerName != tc.signerName || cv.SignerEmail != tc.signerEmail {
+			t.Errorf("case %d: got (%q, %q, %q, %q)", i, cv.Reason, keyID, cv.SignerName, cv.SignerEmail)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package importer imports repositories and users' data from other Git hosting services.
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/httplib"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/process"
+)
+
+const _GITLAB_PAGE_SIZE = 100
+
+// ImportStats represents counts of imported items of a category.
+type ImportStats struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// KeyImportFailure represents a SSH key that could not be imported.
+type KeyImportFailure struct {
+	UserName string `json:"username"`
+	Title    string `json:"title"`
+	Reason   string `json:"reason"`
+}
+
+// ImportReport represents result of an import.
+type ImportReport struct {
+	Repos       ImportStats         `json:"repos"`
+	Keys        ImportStats         `json:"keys"`
+	KeyFailures []*KeyImportFailure `json:"key_failures"`
+}
+
+// ProgressFunc receives a human readable message whenever an item has been processed.
+type ProgressFunc func(msg string)
+
+type gitlabNamespace struct {
+	Path string `json:"path"`
+}
+
+type gitlabProject struct {
+	Id            int64           `json:"id"`
+	Path          string          `json:"path"`
+	Description   string          `json:"description"`
+	Public        bool            `json:"public"`
+	HttpUrlToRepo string          `json:"http_url_to_repo"`
+	Namespace     gitlabNamespace `json:"namespace"`
+}
+
+type gitlabUser struct {
+	Id       int64  `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type gitlabKey struct {
+	Id    int64  `json:"id"`
+	Title string `json:"title"`
+	Key   string `json:"key"`
+}
+
+type gitlabClient struct {
+	baseURL string
+	token   string
+}
+
+// get requests given API path and decodes JSON response into v.
+func (c *gitlabClient) get(apiPath string, v interface{}) error {
+	resp, err := httplib.Get(c.baseURL+"/api/v3"+apiPath).
+		SetTimeout(10*time.Second, time.Minute).
+		Header("PRIVATE-TOKEN", c.token).Response()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %s: unexpected status %s", apiPath, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// cloneURL returns clone address of given project with credentials of token owner,
+// GitLab accepts a personal access token in place of password over HTTP.
+func (c *gitlabClient) cloneURL(p *gitlabProject, username string) (string, error) {
+	u, err := url.Parse(p.HttpUrlToRepo)
+	if err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword(username, c.token)
+	return u.String(), nil
+}
+
+// ImportFromGitLab imports all projects visible to given admin token and SSH keys
+// of users who have an account with same activated e-mail address.
+func ImportFromGitLab(adminToken, gitlabURL string) (*ImportReport, error) {
+	return ImportFromGitLabWithProgress(adminToken, gitlabURL, nil)
+}
+
+// ImportFromGitLabWithProgress is same as ImportFromGitLab but reports progress to given function.
+// An error is only returned when GitLab cannot be queried, failures of individual items
+// are counted in report and logged.
+func ImportFromGitLabWithProgress(adminToken, gitlabURL string, progress ProgressFunc) (*ImportReport, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	c := &gitlabClient{strings.TrimSuffix(gitlabURL, "/"), adminToken}
+
+	admin := new(gitlabUser)
+	if err := c.get("/user", admin); err != nil {
+		return nil, fmt.Errorf("get token owner: %v", err)
+	}
+
+	report := &ImportReport{KeyFailures: make([]*KeyImportFailure, 0)}
+	for page := 1; ; page++ {
+		projects := make([]*gitlabProject, 0, _GITLAB_PAGE_SIZE)
+		if err := c.get(fmt.Sprintf("/projects/all?page=%d&per_page=%d", page, _GITLAB_PAGE_SIZE), &projects); err != nil {
+			return report, fmt.Errorf("list projects: %v", err)
+		}
+		for _, p := range projects {
+			progress(importGitLabProject(c, admin, p, &report.Repos))
+		}
+		if len(projects) < _GITLAB_PAGE_SIZE {
+			break
+		}
+	}
+
+	return report, importGitLabKeys(c, report, progress)
+}
+
+// ImportKeysFromGitLab only imports SSH keys of GitLab users who have an account with
+// same activated e-mail address, e.g. for repositories that have been migrated separately.
+// Keys imported before are skipped, so it can be run again safely.
+func ImportKeysFromGitLab(adminToken, gitlabURL string, progress ProgressFunc) (*ImportReport, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	c := &gitlabClient{strings.TrimSuffix(gitlabURL, "/"), adminToken}
+
+	report := &ImportReport{KeyFailures: make([]*KeyImportFailure, 0)}
+	return report, importGitLabKeys(c, report, progress)
+}
+
+// importGitLabKeys imports SSH keys of all GitLab users.
+func importGitLabKeys(c *gitlabClient, report *ImportReport, progress ProgressFunc) error {
+	for page := 1; ; page++ {
+		users := make([]*gitlabUser, 0, _GITLAB_PAGE_SIZE)
+		if err := c.get(fmt.Sprintf("/users?page=%d&per_page=%d", page, _GITLAB_PAGE_SIZE), &users); err != nil {
+			return fmt.Errorf("list users: %v", err)
+		}
+		for _, u := range users {
+			progress(importGitLabUserKeys(c, u, report))
+		}
+		if len(users) < _GITLAB_PAGE_SIZE {
+			break
+		}
+	}
+	return nil
+}
+
+// importGitLabProject creates or updates repository of given project,
+// the owner is the user or organization that has same name as project namespace.
+func importGitLabProject(c *gitlabClient, admin *gitlabUser, p *gitlabProject, stats *ImportStats) string {
+	fullName := p.Namespace.Path + "/" + p.Path
+
+	owner, err := models.GetUserByName(p.Namespace.Path)
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			stats.Skipped++
+			return fmt.Sprintf("Skipped project %s: owner does not exist", fullName)
+		}
+		stats.Failed++
+		log.Error(4, "GetUserByName(%s): %v", p.Namespace.Path, err)
+		return fmt.Sprintf("Failed to import project %s: %v", fullName, err)
+	}
+
+	repo, err := models.GetRepositoryByName(owner.Id, p.Path)
+	if err == nil {
+		// Only update description, Git data and visibility of existing repository
+		// are never changed, it may have been made private on purpose.
+		if repo.Description == p.Description {
+			stats.Skipped++
+			return fmt.Sprintf("Skipped project %s: repository already exists", fullName)
+		}
+		repo.Description = p.Description
+		if err = models.UpdateRepository(repo, false); err != nil {
+			stats.Failed++
+			log.Error(4, "UpdateRepository(%s): %v", fullName, err)
+			return fmt.Sprintf("Failed to update repository %s: %v", fullName, err)
+		}
+		stats.Updated++
+		return fmt.Sprintf("Updated repository %s", fullName)
+	} else if !models.IsErrRepoNotExist(err) {
+		stats.Failed++
+		log.Error(4, "GetRepositoryByName(%s): %v", fullName, err)
+		return fmt.Sprintf("Failed to import project %s: %v", fullName, err)
+	}
+
+	cloneURL, err := c.cloneURL(p, admin.Username)
+	if err != nil {
+		stats.Failed++
+		return fmt.Sprintf("Failed to import project %s: %v", fullName, err)
+	}
+	repo, err = models.MigrateRepository(owner, p.Path, p.Description, !p.Public, false, cloneURL)
+	if err != nil {
+		if repo != nil {
+			if errDelete := models.DeleteRepository(owner.Id, repo.Id, owner.Name); errDelete != nil {
+				log.Error(4, "DeleteRepository: %v", errDelete)
+			}
+		}
+		stats.Failed++
+		// Do not leak token in clone address.
+		msg := strings.Replace(err.Error(), c.token, "******", -1)
+		log.Error(4, "MigrateRepository(%s): %s", fullName, msg)
+		return fmt.Sprintf("Failed to import project %s: %s", fullName, msg)
+	}
+
+	// Clone address contains admin token, it must not stay in repository config.
+	repoPath := models.RepoPath(owner.Name, p.Path)
+	if _, stderr, err := process.ExecDir(-1, repoPath,
+		fmt.Sprintf("importGitLabProject(git remote rm): %s", repoPath),
+		"git", "remote", "rm", "origin"); err != nil {
+		log.Error(4, "git remote rm origin(%s): %v - %s", repoPath, err, stderr)
+	}
+	stats.Created++
+	return fmt.Sprintf("Imported repository %s", fullName)
+}
+
+// importGitLabUserKeys adds SSH keys of given GitLab user to the user that has same
+// activated e-mail address, so keys cannot be claimed by adding someone else's e-mail address.
+// Keys imported before are skipped, and keys that fail validation are listed in report.
+func importGitLabUserKeys(c *gitlabClient, gu *gitlabUser, report *ImportReport) string {
+	stats := &report.Keys
+	u, err := models.GetUserByActivatedEmail(gu.Email)
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			stats.Skipped++
+			return fmt.Sprintf("Skipped SSH keys of %s: no user with activated e-mail %s", gu.Username, gu.Email)
+		}
+		stats.Failed++
+		log.Error(4, "GetUserByActivatedEmail(%s): %v", gu.Email, err)
+		return fmt.Sprintf("Failed to import SSH keys of %s: %v", gu.Username, err)
+	}
+
+	keys := make([]*gitlabKey, 0, 5)
+	if err = c.get(fmt.Sprintf("/users/%d/keys", gu.Id), &keys); err != nil {
+		stats.Failed++
+		return fmt.Sprintf("Failed to import SSH keys of %s: %v", gu.Username, err)
+	}
+
+	var created, skipped, failed int
+	fail := func(k *gitlabKey, err error) {
+		failed++
+		report.KeyFailures = append(report.KeyFailures, &KeyImportFailure{gu.Username, k.Title, err.Error()})
+	}
+	for _, k := range keys {
+		if imported, err := models.IsPublicKeyImported(c.baseURL, k.Id); err != nil {
+			fail(k, err)
+			log.Error(4, "IsPublicKeyImported(%d): %v", k.Id, err)
+			continue
+		} else if imported {
+			skipped++
+			continue
+		}
+
+		content, err := models.ParseKeyString(k.Key)
+		if err == nil {
+			_, err = models.CheckPublicKeyString(content)
+		}
+		if err != nil && err != models.ErrKeyUnableVerify {
+			fail(k, err)
+			continue
+		}
+
+		key := &models.PublicKey{
+			OwnerId: u.Id,
+			Name:    k.Title,
+			Content: content,
+		}
+		if err = models.ImportPublicKey(key, c.baseURL, k.Id); err != nil {
+			fail(k, err)
+			if err != models.ErrKeyAlreadyExist && !models.IsErrKeyFingerprintAlreadyUsed(err) &&
+				!models.IsErrKeyNameAlreadyUsed(err) {
+				log.Error(4, "ImportPublicKey(%s): %v", gu.Username, err)
+			}
+			continue
+		}
+		created++
+	}
+	stats.Created += created
+	stats.Skipped += skipped
+	stats.Failed += failed
+	return fmt.Sprintf("Imported SSH keys of %s as %s: %d added, %d skipped, %d failed",
+		gu.Username, u.Name, created, skipped, failed)
+}
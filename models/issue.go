@@ -8,7 +8,10 @@ import (
 	"bytes"
 	"errors"
 	"html/template"
+	"io"
+	"net/http"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -17,16 +20,21 @@ import (
 	"github.com/go-xorm/xorm"
 
 	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/setting"
+	"github.com/gogits/gogs/modules/uuid"
 )
 
 var (
-	ErrIssueNotExist       = errors.New("Issue does not exist")
-	ErrLabelNotExist       = errors.New("Label does not exist")
-	ErrMilestoneNotExist   = errors.New("Milestone does not exist")
-	ErrWrongIssueCounter   = errors.New("Invalid number of issues for this milestone")
-	ErrAttachmentNotExist  = errors.New("Attachment does not exist")
-	ErrAttachmentNotLinked = errors.New("Attachment does not belong to this issue")
-	ErrMissingIssueNumber  = errors.New("No issue number specified")
+	ErrIssueNotExist           = errors.New("Issue does not exist")
+	ErrLabelNotExist           = errors.New("Label does not exist")
+	ErrMilestoneNotExist       = errors.New("Milestone does not exist")
+	ErrWrongIssueCounter       = errors.New("Invalid number of issues for this milestone")
+	ErrAttachmentNotExist      = errors.New("Attachment does not exist")
+	ErrAttachmentNotLinked     = errors.New("Attachment does not belong to this issue")
+	ErrAttachmentTooLarge      = errors.New("Attachment is larger than maximum size")
+	ErrAttachmentTypeForbidden = errors.New("File type is not allowed")
+	ErrMissingIssueNumber      = errors.New("No issue number specified")
+	ErrInvalidLabelOp          = errors.New("Invalid label operation")
 )
 
 // Issue represents an issue or pull request of repository.
@@ -133,6 +141,7 @@ func NewIssue(issue *Issue) (err error) {
 	if err = sess.Commit(); err != nil {
 		return err
 	}
+	indexIssue(issue)
 
 	if issue.MilestoneId > 0 {
 		// FIXES(280): Update milestone counter.
@@ -448,12 +457,43 @@ func GetUserIssueStats(uid int64, filterMode int) *IssueStats {
 
 // UpdateIssue updates information of issue.
 func UpdateIssue(issue *Issue) error {
-	_, err := x.Id(issue.Id).AllCols().Update(issue)
+	if _, err := x.Id(issue.Id).AllCols().Update(issue); err != nil {
+		return err
+	}
+	indexIssue(issue)
+	return nil
+}
 
+// CloseIssueById closes issue of given ID on behalf of given user,
+// it does nothing if the issue is already closed.
+func CloseIssueById(doerId, issueId int64) error {
+	issue, err := GetIssueById(issueId)
 	if err != nil {
 		return err
+	} else if issue.IsClosed {
+		return nil
+	}
+	issue.IsClosed = true
+
+	if err = issue.GetLabels(); err != nil {
+		return err
+	}
+	for _, label := range issue.Labels {
+		label.NumClosedIssues++
+		if err = UpdateLabel(label); err != nil {
+			return err
+		}
 	}
 
+	if err = UpdateIssue(issue); err != nil {
+		return err
+	} else if err = UpdateIssueUserPairsByStatus(issue.Id, issue.IsClosed); err != nil {
+		return err
+	} else if err = ChangeMilestoneIssueStats(issue); err != nil {
+		return err
+	}
+
+	_, err = CreateComment(doerId, issue.RepoId, issue.Id, 0, 0, COMMENT_TYPE_CLOSE, "", nil)
 	return err
 }
 
@@ -568,6 +608,113 @@ func UpdateLabel(l *Label) error {
 	return err
 }
 
+const (
+	LABEL_OP_ADD     = "add"
+	LABEL_OP_REMOVE  = "remove"
+	LABEL_OP_REPLACE = "replace"
+)
+
+// BatchUpdateIssueLabels adds, removes or replaces labels of given issues
+// of repository in a single transaction. Empty labels with replace clears labels.
+func BatchUpdateIssueLabels(repoId int64, issueIndexes []int, labelIds []int, op string) (err error) {
+	if op != LABEL_OP_ADD && op != LABEL_OP_REMOVE && op != LABEL_OP_REPLACE {
+		return ErrInvalidLabelOp
+	}
+
+	repoLabels, err := GetLabels(repoId)
+	if err != nil {
+		return err
+	}
+	labels := make(map[int64]*Label, len(repoLabels))
+	for _, l := range repoLabels {
+		labels[l.Id] = l
+	}
+	for _, id := range labelIds {
+		if labels[int64(id)] == nil {
+			return ErrLabelNotExist
+		}
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	changed := make(map[int64]bool)
+	for _, index := range issueIndexes {
+		issue := &Issue{RepoId: repoId, Index: int64(index)}
+		has, err := sess.Get(issue)
+		if err != nil {
+			return err
+		} else if !has {
+			return ErrIssueNotExist
+		}
+
+		var newIds []int64
+		if op != LABEL_OP_REPLACE {
+			for _, l := range repoLabels {
+				if strings.Contains(issue.LabelIds, "$"+com.ToStr(l.Id)+"|") {
+					newIds = append(newIds, l.Id)
+				}
+			}
+		}
+		for _, id := range labelIds {
+			switch op {
+			case LABEL_OP_ADD, LABEL_OP_REPLACE:
+				if !com.IsSliceContainsInt64(newIds, int64(id)) {
+					newIds = append(newIds, int64(id))
+				}
+			case LABEL_OP_REMOVE:
+				for i := range newIds {
+					if newIds[i] == int64(id) {
+						newIds = append(newIds[:i], newIds[i+1:]...)
+						break
+					}
+				}
+			}
+		}
+
+		labelStr := ""
+		for _, id := range newIds {
+			labelStr += "$" + com.ToStr(id) + "|"
+		}
+		if labelStr == issue.LabelIds {
+			continue
+		}
+
+		// Update counters of labels that are attached or detached.
+		for _, l := range labels {
+			had := strings.Contains(issue.LabelIds, "$"+com.ToStr(l.Id)+"|")
+			has := com.IsSliceContainsInt64(newIds, l.Id)
+			if had == has {
+				continue
+			}
+			delta := 1
+			if had {
+				delta = -1
+			}
+			l.NumIssues += delta
+			if issue.IsClosed {
+				l.NumClosedIssues += delta
+			}
+			changed[l.Id] = true
+		}
+
+		issue.LabelIds = labelStr
+		if _, err = sess.Id(issue.Id).Cols("label_ids").Update(issue); err != nil {
+			return err
+		}
+	}
+
+	for id := range changed {
+		if _, err = sess.Id(id).AllCols().Update(labels[id]); err != nil {
+			return err
+		}
+	}
+	return sess.Commit()
+}
+
 // DeleteLabel delete a label of given repository.
 func DeleteLabel(repoId int64, strId string) error {
 	id, _ := com.StrTo(strId).Int64()
@@ -977,12 +1124,15 @@ func (c *Comment) AfterDelete() {
 }
 
 type Attachment struct {
-	Id        int64
-	IssueId   int64
-	CommentId int64
-	Name      string
-	Path      string    `xorm:"TEXT"`
-	Created   time.Time `xorm:"CREATED"`
+	Id            int64
+	Uuid          string `xorm:"UNIQUE"`
+	IssueId       int64  `xorm:"INDEX"`
+	CommentId     *int64 // Nil when attachment belongs to issue itself.
+	Name          string
+	Path          string `xorm:"TEXT"`
+	Size          int64
+	DownloadCount int
+	Created       time.Time `xorm:"CREATED"`
 }
 
 // CreateAttachment creates a new attachment inside the database and
@@ -994,7 +1144,13 @@ func CreateAttachment(issueId, commentId int64, name, path string) (*Attachment,
 		return nil, err
 	}
 
-	a := &Attachment{IssueId: issueId, CommentId: commentId, Name: name, Path: path}
+	a := &Attachment{Uuid: uuid.NewV4().String(), IssueId: issueId, Name: name, Path: path}
+	if commentId > 0 {
+		a.CommentId = &commentId
+	}
+	if fi, err := os.Stat(path); err == nil {
+		a.Size = fi.Size()
+	}
 
 	if _, err := sess.Insert(a); err != nil {
 		sess.Rollback()
@@ -1004,6 +1160,69 @@ func CreateAttachment(issueId, commentId int64, name, path string) (*Attachment,
 	return a, sess.Commit()
 }
 
+// isAttachmentTypeAllowed returns true if given MIME type is in allowed types of attachments.
+func isAttachmentTypeAllowed(fileType string) bool {
+	for _, t := range strings.Split(setting.AttachmentAllowedTypes, "|") {
+		t = strings.TrimSpace(t)
+		if t == "*/*" || t == fileType {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadAttachment saves content of given reader as a new attachment of issue.
+func UploadAttachment(r io.Reader, name string, issueId int64) (*Attachment, error) {
+	return UploadCommentAttachment(r, name, issueId, 0)
+}
+
+// UploadCommentAttachment saves content of given reader as a new attachment of comment,
+// content must be of allowed type and not larger than maximum size of attachments.
+func UploadCommentAttachment(r io.Reader, name string, issueId, commentId int64) (*Attachment, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	if !isAttachmentTypeAllowed(http.DetectContentType(head)) {
+		return nil, ErrAttachmentTypeForbidden
+	}
+
+	a := &Attachment{
+		Uuid:    uuid.NewV4().String(),
+		IssueId: issueId,
+		Name:    name,
+	}
+	if commentId > 0 {
+		a.CommentId = &commentId
+	}
+	a.Path = path.Join(setting.AttachmentPath, a.Uuid)
+	if err = os.MkdirAll(setting.AttachmentPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	fw, err := os.Create(a.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read one more byte than allowed to tell whether content is too large.
+	maxSize := setting.AttachmentMaxSize << 20
+	a.Size, err = io.Copy(fw, io.LimitReader(io.MultiReader(bytes.NewReader(head), r), maxSize+1))
+	fw.Close()
+	if err == nil && a.Size > maxSize {
+		err = ErrAttachmentTooLarge
+	}
+	if err == nil {
+		_, err = x.Insert(a)
+	}
+	if err != nil {
+		os.Remove(a.Path)
+		return nil, err
+	}
+	return a, nil
+}
+
 // Attachment returns the attachment by given ID.
 func GetAttachmentById(id int64) (*Attachment, error) {
 	m := &Attachment{Id: id}
@@ -1021,19 +1240,46 @@ func GetAttachmentById(id int64) (*Attachment, error) {
 	return m, nil
 }
 
+// GetAttachmentByUUID returns attachment by given UUID.
+func GetAttachmentByUUID(uuid string) (*Attachment, error) {
+	if len(uuid) == 0 {
+		return nil, ErrAttachmentNotExist
+	}
+	a := &Attachment{Uuid: uuid}
+	has, err := x.Get(a)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrAttachmentNotExist
+	}
+	return a, nil
+}
+
+// IncreaseAttachmentDownloadCount increases download count of given attachment by one.
+func IncreaseAttachmentDownloadCount(a *Attachment) error {
+	_, err := x.Exec("UPDATE `attachment` SET download_count=download_count+1 WHERE id=?", a.Id)
+	return err
+}
+
 func GetAttachmentsForIssue(issueId int64) ([]*Attachment, error) {
 	attachments := make([]*Attachment, 0, 10)
-	err := x.Where("issue_id = ?", issueId).And("comment_id = 0").Find(&attachments)
+	err := x.Where("issue_id = ?", issueId).And("comment_id IS NULL").Find(&attachments)
 	return attachments, err
 }
 
 // GetAttachmentsByIssue returns a list of attachments for the given issue
 func GetAttachmentsByIssue(issueId int64) ([]*Attachment, error) {
 	attachments := make([]*Attachment, 0, 10)
-	err := x.Where("issue_id = ?", issueId).And("comment_id > 0").Find(&attachments)
+	err := x.Where("issue_id = ?", issueId).And("comment_id IS NOT NULL").Find(&attachments)
 	return attachments, err
 }
 
+// ListIssueAttachments returns all attachments of given issue and its comments.
+func ListIssueAttachments(issueId int64) ([]*Attachment, error) {
+	attachments := make([]*Attachment, 0, 10)
+	return attachments, x.Where("issue_id=?", issueId).Asc("id").Find(&attachments)
+}
+
 // GetAttachmentsByComment returns a list of attachments for the given comment
 func GetAttachmentsByComment(commentId int64) ([]*Attachment, error) {
 	attachments := make([]*Attachment, 0, 10)
@@ -1051,12 +1297,12 @@ func DeleteAttachment(a *Attachment, remove bool) error {
 func DeleteAttachments(attachments []*Attachment, remove bool) (int, error) {
 	for i, a := range attachments {
 		if remove {
-			if err := os.Remove(a.Path); err != nil {
+			if err := os.Remove(a.Path); err != nil && !os.IsNotExist(err) {
 				return i, err
 			}
 		}
 
-		if _, err := x.Delete(a.Id); err != nil {
+		if _, err := x.Delete(&Attachment{Id: a.Id}); err != nil {
 			return i, err
 		}
 	}
@@ -0,0 +1,143 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/gogits/gogs/modules/git"
+)
+
+const (
+	ISSUE_TEMPLATE_FILE = ".gogs/ISSUE_TEMPLATE.md"
+	ISSUE_TEMPLATE_DIR  = ".gogs/issue_templates"
+)
+
+// IssueTemplate represents a template of issue content read from repository.
+type IssueTemplate struct {
+	Name   string
+	About  string
+	Body   string
+	Labels []string
+}
+
+// parseIssueTemplate parses optional front matter of template content:
+//
+//	---
+//	name: Bug report
+//	about: Create a report to help us improve
+//	labels: bug, help wanted
+//	---
+func parseIssueTemplate(name, content string) *IssueTemplate {
+	t := &IssueTemplate{
+		Name: name,
+		Body: content,
+	}
+
+	content = strings.Replace(content, "\r\n", "\n", -1)
+	if !strings.HasPrefix(content, "---\n") {
+		return t
+	}
+	end := strings.Index(content[4:], "\n---")
+	if end == -1 {
+		return t
+	}
+
+	for _, line := range strings.Split(content[4:4+end], "\n") {
+		infos := strings.SplitN(line, ":", 2)
+		if len(infos) != 2 {
+			continue
+		}
+		val := strings.Trim(strings.TrimSpace(infos[1]), `"'`)
+		switch strings.TrimSpace(infos[0]) {
+		case "name":
+			t.Name = val
+		case "about":
+			t.About = val
+		case "labels":
+			for _, label := range strings.Split(strings.Trim(val, "[]"), ",") {
+				label = strings.Trim(strings.TrimSpace(label), `"'`)
+				if len(label) > 0 {
+					t.Labels = append(t.Labels, label)
+				}
+			}
+		}
+	}
+	t.Body = strings.TrimLeft(content[4+end+4:], "\n")
+	return t
+}
+
+func readIssueTemplate(commit *git.Commit, name, treePath string) (*IssueTemplate, error) {
+	blob, err := commit.GetBlobByPath(treePath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := blob.Data()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseIssueTemplate(name, string(data)), nil
+}
+
+// GetIssueTemplates returns issue templates of repository at given reference,
+// default branch is used when reference is empty.
+// Template of ISSUE_TEMPLATE.md always comes first if exists.
+func GetIssueTemplates(repoId int64, ref string) ([]*IssueTemplate, error) {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return nil, err
+	} else if repo.IsBare {
+		return nil, nil
+	}
+	repoPath, err := repo.RepoPath()
+	if err != nil {
+		return nil, fmt.Errorf("RepoPath: %v", err)
+	}
+
+	gitRepo, err := git.OpenRepository(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("OpenRepository: %v", err)
+	}
+	if len(ref) == 0 {
+		ref = repo.DefaultBranch
+	}
+	commit, err := gitRepo.GetCommitOfBranch(ref)
+	if err != nil {
+		return nil, fmt.Errorf("GetCommitOfBranch(%s): %v", ref, err)
+	}
+
+	templates := make([]*IssueTemplate, 0, 5)
+	if t, err := readIssueTemplate(commit, "Default", ISSUE_TEMPLATE_FILE); err == nil {
+		templates = append(templates, t)
+	}
+
+	tree, err := commit.SubTree(ISSUE_TEMPLATE_DIR)
+	if err != nil {
+		return templates, nil
+	}
+	entries, err := tree.ListEntries("")
+	if err != nil {
+		return nil, fmt.Errorf("ListEntries: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.ToLower(path.Ext(entry.Name())) != ".md" {
+			continue
+		}
+		t, err := readIssueTemplate(commit, strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())),
+			path.Join(ISSUE_TEMPLATE_DIR, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("readIssueTemplate(%s): %v", entry.Name(), err)
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
@@ -0,0 +1,159 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestUploadAttachment(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(Attachment))
+	defer cleanup()
+	setting.AttachmentPath = filepath.Join(tmpDir, "attachments")
+	setting.AttachmentAllowedTypes = "text/plain; charset=utf-8"
+	setting.AttachmentMaxSize = 1
+
+	a, err := UploadAttachment(strings.NewReader("hello"), "hello.txt", 1)
+	if err != nil {
+		t.Fatalf("UploadAttachment: %v", err)
+	}
+	if a.Size != 5 || len(a.Uuid) == 0 {
+		t.Errorf("got size %d and uuid %q", a.Size, a.Uuid)
+	}
+	if got, err := GetAttachmentByUUID(a.Uuid); err != nil || got.Id != a.Id {
+		t.Errorf("GetAttachmentByUUID: %v, %v", got, err)
+	}
+
+	if _, err = UploadAttachment(bytes.NewReader([]byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}), "a.png", 1); err != ErrAttachmentTypeForbidden {
+		t.Errorf("expected ErrAttachmentTypeForbidden, got %v", err)
+	}
+	if _, err = UploadAttachment(strings.NewReader(strings.Repeat("a", 1<<20+1)), "big.txt", 1); err != ErrAttachmentTooLarge {
+		t.Errorf("expected ErrAttachmentTooLarge, got %v", err)
+	}
+
+	if a.CommentId != nil {
+		t.Errorf("expected no comment for issue attachment, got %d", *a.CommentId)
+	}
+	ca, err := UploadCommentAttachment(strings.NewReader("reply"), "reply.txt", 1, 7)
+	if err != nil {
+		t.Fatalf("UploadCommentAttachment: %v", err)
+	} else if ca.CommentId == nil || *ca.CommentId != 7 {
+		t.Errorf("expected comment 7, got %v", ca.CommentId)
+	}
+	if list, err := GetAttachmentsForIssue(1); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 1 || list[0].Id != a.Id || list[0].CommentId != nil {
+		t.Errorf("expected only issue attachment, got %v", list)
+	}
+	if list, err := GetAttachmentsByComment(7); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 1 || list[0].Id != ca.Id {
+		t.Errorf("expected only comment attachment, got %v", list)
+	}
+
+	list, err := ListIssueAttachments(1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(list) != 2 {
+		t.Fatalf("expected 2 attachments, got %d", len(list))
+	}
+
+	if err = DeleteAttachment(a, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(a.Path); !os.IsNotExist(err) {
+		t.Errorf("expected file to be removed, got %v", err)
+	}
+	if _, err = GetAttachmentByUUID(a.Uuid); err != ErrAttachmentNotExist {
+		t.Errorf("expected ErrAttachmentNotExist, got %v", err)
+	}
+}
+
+func TestBatchUpdateIssueLabels(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(Issue), new(Label))
+	defer cleanup()
+	var err error
+
+	bug := &Label{RepoId: 1, Name: "bug", Color: "#ee0701"}
+	docs := &Label{RepoId: 1, Name: "docs", Color: "#cccccc"}
+	other := &Label{RepoId: 2, Name: "other", Color: "#000000"}
+	if _, err = x.Insert(bug, docs, other); err != nil {
+		t.Fatal(err)
+	}
+	open := &Issue{RepoId: 1, Index: 1, Name: "open"}
+	closed := &Issue{RepoId: 1, Index: 2, Name: "closed", IsClosed: true}
+	if _, err = x.Insert(open, closed); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(step string, issue *Issue, labelIds string, l *Label, numIssues, numClosed int) {
+		if i, err := GetIssueById(issue.Id); err != nil {
+			t.Fatal(err)
+		} else if i.LabelIds != labelIds {
+			t.Errorf("%s: expected labels %q of issue %d, got %q", step, labelIds, issue.Index, i.LabelIds)
+		}
+		if l == nil {
+			return
+		}
+		if got, err := GetLabelById(l.Id); err != nil {
+			t.Fatal(err)
+		} else if got.NumIssues != numIssues || got.NumClosedIssues != numClosed {
+			t.Errorf("%s: expected %d/%d issues of label %s, got %d/%d",
+				step, numIssues, numClosed, l.Name, got.NumIssues, got.NumClosedIssues)
+		}
+	}
+
+	if err = BatchUpdateIssueLabels(1, []int{1, 2}, []int{int(bug.Id)}, LABEL_OP_ADD); err != nil {
+		t.Fatal(err)
+	}
+	expect("add", open, "$"+com.ToStr(bug.Id)+"|", bug, 2, 1)
+	expect("add", closed, "$"+com.ToStr(bug.Id)+"|", nil, 0, 0)
+
+	// Adding again changes nothing.
+	if err = BatchUpdateIssueLabels(1, []int{1}, []int{int(bug.Id)}, LABEL_OP_ADD); err != nil {
+		t.Fatal(err)
+	}
+	expect("add again", open, "$"+com.ToStr(bug.Id)+"|", bug, 2, 1)
+
+	if err = BatchUpdateIssueLabels(1, []int{2}, []int{int(docs.Id)}, LABEL_OP_REPLACE); err != nil {
+		t.Fatal(err)
+	}
+	expect("replace", closed, "$"+com.ToStr(docs.Id)+"|", bug, 1, 0)
+	expect("replace", closed, "$"+com.ToStr(docs.Id)+"|", docs, 1, 1)
+
+	if err = BatchUpdateIssueLabels(1, []int{1}, []int{int(bug.Id)}, LABEL_OP_REMOVE); err != nil {
+		t.Fatal(err)
+	}
+	expect("remove", open, "", bug, 0, 0)
+
+	// Replacing with no label clears labels.
+	if err = BatchUpdateIssueLabels(1, []int{2}, nil, LABEL_OP_REPLACE); err != nil {
+		t.Fatal(err)
+	}
+	expect("clear", closed, "", docs, 0, 0)
+
+	if err = BatchUpdateIssueLabels(1, []int{1}, []int{int(bug.Id)}, "toggle"); err != ErrInvalidLabelOp {
+		t.Errorf("expected ErrInvalidLabelOp, got %v", err)
+	}
+	if err = BatchUpdateIssueLabels(1, []int{1}, []int{int(other.Id)}, LABEL_OP_ADD); err != ErrLabelNotExist {
+		t.Errorf("expected ErrLabelNotExist for label of other repository, got %v", err)
+	}
+
+	// Nothing is changed when one of issues does not exist.
+	if err = BatchUpdateIssueLabels(1, []int{1, 3}, []int{int(bug.Id)}, LABEL_OP_ADD); err != ErrIssueNotExist {
+		t.Errorf("expected ErrIssueNotExist, got %v", err)
+	}
+	expect("rollback", open, "", bug, 0, 0)
+}
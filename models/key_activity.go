@@ -0,0 +1,160 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+const KEY_ACTIVITY_PAGE_SIZE = 30
+
+// KeyActivity represents a Git operation performed over SSH with a public key.
+// Names of owner and repository are kept so records stay meaningful
+// after renames and deletions.
+type KeyActivity struct {
+	Id         int64
+	KeyId      int64 `xorm:"INDEX"`
+	KeyName    string
+	OwnerId    int64 `xorm:"INDEX"`
+	OwnerName  string
+	RepoId     int64 `xorm:"INDEX"`
+	RepoName   string
+	Operation  string
+	RemoteAddr string
+	Created    time.Time `xorm:"CREATED INDEX"`
+}
+
+// AddKeyActivity records a new key activity.
+func AddKeyActivity(a *KeyActivity) error {
+	_, err := x.Insert(a)
+	return err
+}
+
+// GetLastKeyPush returns latest push to given repository performed with
+// a public key, it returns nil if there is none.
+func GetLastKeyPush(repoID int64) (*KeyActivity, error) {
+	a := new(KeyActivity)
+	has, err := x.Where("repo_id=? AND operation=?", repoID, "receive-pack").Desc("id").Get(a)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// ListKeyActivity returns a page of activities of given key, latest first.
+func ListKeyActivity(keyID int64, page int) ([]*KeyActivity, error) {
+	if page < 1 {
+		page = 1
+	}
+	acts := make([]*KeyActivity, 0, KEY_ACTIVITY_PAGE_SIZE)
+	return acts, x.Where("key_id=?", keyID).Desc("id").
+		Limit(KEY_ACTIVITY_PAGE_SIZE, (page-1)*KEY_ACTIVITY_PAGE_SIZE).Find(&acts)
+}
+
+// CountKeyActivity returns number of activities of given key.
+func CountKeyActivity(keyID int64) int64 {
+	count, _ := x.Where("key_id=?", keyID).Count(new(KeyActivity))
+	return count
+}
+
+// SearchKeyActivities returns a page of activities filtered by repository
+// and/or user when given IDs are greater than zero, latest first.
+func SearchKeyActivities(repoID, ownerID int64, page int) ([]*KeyActivity, error) {
+	if page < 1 {
+		page = 1
+	}
+	acts := make([]*KeyActivity, 0, KEY_ACTIVITY_PAGE_SIZE)
+	return acts, x.Desc("id").Limit(KEY_ACTIVITY_PAGE_SIZE, (page-1)*KEY_ACTIVITY_PAGE_SIZE).
+		Find(&acts, &KeyActivity{RepoId: repoID, OwnerId: ownerID})
+}
+
+// CountKeyActivities returns number of activities filtered by repository and/or user.
+func CountKeyActivities(repoID, ownerID int64) int64 {
+	count, _ := x.Count(&KeyActivity{RepoId: repoID, OwnerId: ownerID})
+	return count
+}
+
+// PruneKeyActivities deletes key activities that are older than retention period.
+func PruneKeyActivities() {
+	if setting.SSHKeyActivityRetention <= 0 {
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -setting.SSHKeyActivityRetention)
+	if _, err := x.Where("created<?", before).Delete(new(KeyActivity)); err != nil {
+		log.Error(4, "PruneKeyActivities: %v", err)
+	}
+}
+
+// KeyUsage represents summary of how a public key has been used on a repository
+// for an action, unlike key activities it is not pruned.
+type KeyUsage struct {
+	Id       int64
+	KeyId    int64  `xorm:"UNIQUE(s)"`
+	RepoId   int64  `xorm:"UNIQUE(s)"`
+	Action   string `xorm:"UNIQUE(s)"`
+	RepoName string
+	NumUses  int64
+	LastUsed time.Time
+}
+
+// incrKeyUsage increases usage count of existing usage record,
+// it returns false if the record does not exist yet.
+func incrKeyUsage(keyId, repoId int64, action string, now time.Time) (bool, error) {
+	result, err := x.Exec("UPDATE `key_usage` SET num_uses=num_uses+1, last_used=? WHERE key_id=? AND repo_id=? AND action=?",
+		now, keyId, repoId, action)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RecordKeyUsage increases usage count of given key on given repository for action,
+// and updates last used time of the key.
+func RecordKeyUsage(keyId, repoId int64, action string) error {
+	now := time.Now()
+	has, err := incrKeyUsage(keyId, repoId, action, now)
+	if err != nil {
+		return err
+	} else if !has {
+		repo, err := GetRepositoryById(repoId)
+		if err != nil {
+			return err
+		} else if err = repo.GetOwner(); err != nil {
+			return err
+		}
+
+		if _, err = x.Insert(&KeyUsage{
+			KeyId:    keyId,
+			RepoId:   repoId,
+			Action:   action,
+			RepoName: repo.Owner.Name + "/" + repo.Name,
+			NumUses:  1,
+			LastUsed: now,
+		}); err != nil {
+			// Another connection may have inserted the same record in the meantime.
+			if has, err = incrKeyUsage(keyId, repoId, action, now); err != nil {
+				return err
+			} else if !has {
+				return fmt.Errorf("insert key usage(%d:%d:%s) failed", keyId, repoId, action)
+			}
+		}
+	}
+
+	return UpdateKeyActivity(keyId)
+}
+
+// GetKeyUsages returns usage summary of given key, most recently used first.
+func GetKeyUsages(keyId int64) ([]*KeyUsage, error) {
+	usages := make([]*KeyUsage, 0, 5)
+	return usages, x.Where("key_id=?", keyId).Desc("last_used").Find(&usages)
+}
@@ -0,0 +1,90 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestRecordKeyUsage(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(Repository), new(PublicKey), new(KeyUsage))
+	defer cleanup()
+	var err error
+
+	u := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local"}
+	if _, err = x.Insert(u); err != nil {
+		t.Fatal(err)
+	}
+	repo := &Repository{OwnerId: u.Id, Name: "repo1", LowerName: "repo1"}
+	if _, err = x.Insert(repo); err != nil {
+		t.Fatal(err)
+	}
+	key := &PublicKey{OwnerId: u.Id, Name: "laptop", Content: "ssh-rsa AAAA"}
+	if _, err = x.Insert(key); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = RecordKeyUsage(key.Id, repo.Id, "receive-pack"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = RecordKeyUsage(key.Id, repo.Id, "upload-pack"); err != nil {
+		t.Fatal(err)
+	}
+
+	usages, err := GetKeyUsages(key.Id)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(usages) != 2 {
+		t.Fatalf("expect 2 key usages but got %d", len(usages))
+	}
+	for _, usage := range usages {
+		expect := int64(1)
+		if usage.Action == "receive-pack" {
+			expect = 2
+		}
+		if usage.NumUses != expect || usage.RepoName != "user1/repo1" {
+			t.Errorf("unexpected key usage: %+v", usage)
+		}
+	}
+
+	if key, err = GetPublicKeyById(key.Id); err != nil {
+		t.Fatal(err)
+	} else if key.Updated.IsZero() {
+		t.Error("expect last used time of key to be updated")
+	}
+}
+
+func TestGetLastKeyPush(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(KeyActivity))
+	defer cleanup()
+
+	if a, err := GetLastKeyPush(1); err != nil {
+		t.Fatal(err)
+	} else if a != nil {
+		t.Fatalf("expect no push but got %+v", a)
+	}
+
+	for _, a := range []*KeyActivity{
+		{KeyId: 1, KeyName: "laptop", RepoId: 1, Operation: "receive-pack"},
+		{KeyId: 2, KeyName: "build-box", RepoId: 1, Operation: "receive-pack"},
+		{KeyId: 1, KeyName: "laptop", RepoId: 1, Operation: "upload-pack"},
+		{KeyId: 1, KeyName: "laptop", RepoId: 2, Operation: "receive-pack"},
+	} {
+		if err := AddKeyActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := GetLastKeyPush(1)
+	if err != nil {
+		t.Fatal(err)
+	} else if a == nil || a.KeyId != 2 || a.KeyName != "build-box" {
+		t.Errorf("expect last push via key 2 but got %+v", a)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/go-xorm/xorm"
 
 	"github.com/gogits/gogs/modules/auth/ldap"
+	"github.com/gogits/gogs/modules/auth/saml"
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/uuid"
 )
@@ -28,6 +29,7 @@ const (
 	PLAIN
 	LDAP
 	SMTP
+	SAML
 )
 
 var (
@@ -39,12 +41,14 @@ var (
 var LoginTypes = map[LoginType]string{
 	LDAP: "LDAP",
 	SMTP: "SMTP",
+	SAML: "SAML",
 }
 
 // Ensure structs implemented interface.
 var (
 	_ core.Conversion = &LDAPConfig{}
 	_ core.Conversion = &SMTPConfig{}
+	_ core.Conversion = &SAMLConfig{}
 )
 
 type LDAPConfig struct {
@@ -74,6 +78,18 @@ func (cfg *SMTPConfig) ToDB() ([]byte, error) {
 	return json.Marshal(cfg)
 }
 
+type SAMLConfig struct {
+	saml.SAMLSource
+}
+
+func (cfg *SAMLConfig) FromDB(bs []byte) error {
+	return json.Unmarshal(bs, &cfg.SAMLSource)
+}
+
+func (cfg *SAMLConfig) ToDB() ([]byte, error) {
+	return json.Marshal(cfg.SAMLSource)
+}
+
 type LoginSource struct {
 	Id                int64
 	Type              LoginType
@@ -97,6 +113,10 @@ func (source *LoginSource) SMTP() *SMTPConfig {
 	return source.Cfg.(*SMTPConfig)
 }
 
+func (source *LoginSource) SAML() *SAMLConfig {
+	return source.Cfg.(*SAMLConfig)
+}
+
 func (source *LoginSource) BeforeSet(colName string, val xorm.Cell) {
 	if colName == "type" {
 		ty := (*val).(int64)
@@ -105,6 +125,8 @@ func (source *LoginSource) BeforeSet(colName string, val xorm.Cell) {
 			source.Cfg = new(LDAPConfig)
 		case SMTP:
 			source.Cfg = new(SMTPConfig)
+		case SAML:
+			source.Cfg = new(SAMLConfig)
 		}
 	}
 }
@@ -162,6 +184,10 @@ func UserSignIn(uname, passwd string) (*User, error) {
 		return nil, err
 	}
 
+	if has && u.IsBot {
+		return nil, ErrBotUserCannotLogin
+	}
+
 	if u.LoginType == NOTYPE && has {
 		u.LoginType = PLAIN
 	}
@@ -233,6 +259,7 @@ func LoginUserLdapSource(u *User, name, passwd string, sourceId int64, cfg *LDAP
 		return nil, ErrUserNotExist
 	}
 	if !autoRegister {
+		syncLDAPPublicKeys(u, passwd, sourceId, cfg)
 		return u, nil
 	}
 
@@ -251,7 +278,11 @@ func LoginUserLdapSource(u *User, name, passwd string, sourceId int64, cfg *LDAP
 		Email:       mail,
 		IsActive:    true,
 	}
-	return u, CreateUser(u)
+	if err := CreateUser(u); err != nil {
+		return u, err
+	}
+	syncLDAPPublicKeys(u, passwd, sourceId, cfg)
+	return u, nil
 }
 
 type loginAuth struct {
@@ -358,3 +389,60 @@ func LoginUserSMTPSource(u *User, name, passwd string, sourceId int64, cfg *SMTP
 	err := CreateUser(u)
 	return u, err
 }
+
+// LoginUserSAMLSource returns the local user bound to the identity asserted by
+// SAML identity provider, and keeps the full name and e-mail in sync.
+// A local user is created on first sign in if auto registration is allowed.
+// Bot users and users deactivated by admin cannot sign in.
+func LoginUserSAMLSource(id *saml.Identity, sourceId int64, autoRegister bool) (*User, error) {
+	u := &User{
+		LoginType:   SAML,
+		LoginSource: sourceId,
+		LoginName:   id.NameID,
+	}
+	has, err := x.Get(u)
+	if err != nil {
+		return nil, err
+	} else if has {
+		if u.IsBot {
+			return nil, ErrBotUserCannotLogin
+		} else if !u.IsActive {
+			return nil, ErrUserNotActive
+		}
+
+		if (len(id.FullName) > 0 && id.FullName != u.FullName) ||
+			(len(id.Email) > 0 && id.Email != u.Email) {
+			if len(id.FullName) > 0 {
+				u.FullName = id.FullName
+			}
+			if len(id.Email) > 0 {
+				u.Email = id.Email
+			}
+			if err = UpdateUser(u); err != nil {
+				return nil, err
+			}
+		}
+		return u, nil
+	}
+
+	if !autoRegister {
+		return nil, ErrUserNotExist
+	}
+
+	// Fallback.
+	if len(id.Email) == 0 {
+		id.Email = uuid.NewV4().String() + "@localhost"
+	}
+
+	u = &User{
+		Name:        id.Name,
+		FullName:    id.FullName,
+		LoginType:   SAML,
+		LoginSource: sourceId,
+		LoginName:   id.NameID,
+		Passwd:      uuid.NewV4().String(),
+		Email:       id.Email,
+		IsActive:    true,
+	}
+	return u, CreateUser(u)
+}
@@ -0,0 +1,60 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/gogits/gogs/modules/auth/saml"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestLoginUserSAMLSource(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(EmailAddress))
+	defer cleanup()
+	var err error
+	setting.RepoRootPath = tmpDir
+
+	if _, err = LoginUserSAMLSource(&saml.Identity{NameID: "alice@example.com", Name: "alice"}, 1, false); err != ErrUserNotExist {
+		t.Fatalf("expect unknown user without auto registration to be rejected but got %v", err)
+	}
+
+	id := &saml.Identity{NameID: "alice@example.com", Name: "alice", FullName: "Alice", Email: "alice@example.com"}
+	u, err := LoginUserSAMLSource(id, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if !u.IsActive || u.LoginType != SAML || u.LoginName != id.NameID {
+		t.Fatalf("expect active user bound to SAML identity but got %+v", u)
+	}
+
+	// Profile is kept in sync on later sign in.
+	id.FullName = "Alice Liddell"
+	if u, err = LoginUserSAMLSource(id, 1, true); err != nil {
+		t.Fatal(err)
+	} else if u.FullName != "Alice Liddell" {
+		t.Errorf("expect full name to be updated but got %q", u.FullName)
+	}
+
+	// Identity of another source is a different user.
+	if _, err = LoginUserSAMLSource(id, 2, false); err != ErrUserNotExist {
+		t.Errorf("expect identity of other source not to match but got %v", err)
+	}
+
+	// Users deactivated by admin and bots cannot sign in.
+	if _, err = x.Id(u.Id).Cols("is_active").Update(&User{IsActive: false}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoginUserSAMLSource(id, 1, true); err != ErrUserNotActive {
+		t.Errorf("expect inactive user to be rejected but got %v", err)
+	}
+	if _, err = x.Id(u.Id).Cols("is_active", "is_bot").Update(&User{IsActive: true, IsBot: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoginUserSAMLSource(id, 1, true); err != ErrBotUserCannotLogin {
+		t.Errorf("expect bot user to be rejected but got %v", err)
+	}
+}
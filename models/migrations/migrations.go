@@ -5,15 +5,28 @@
 package migrations
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/Unknwon/com"
 	"github.com/go-xorm/xorm"
 
+	"github.com/gogits/gogs/modules/git"
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/setting"
+	"github.com/gogits/gogs/modules/uuid"
 )
 
 const _MIN_DB_VER = 0
@@ -50,10 +63,21 @@ type Version struct {
 // If you want to "retire" a migration, remove it from the top of the list and
 // update _MIN_VER_DB accordingly
 var migrations = []Migration{
-	NewMigration("generate collaboration from access", accessToCollaboration), // V0 -> V1
-	NewMigration("make authorize 4 if team is owners", ownerTeamUpdate),       // V1 -> V2
-	NewMigration("refactor access table to use id's", accessRefactor),         // V2 -> V3
-	NewMigration("generate team-repo from team", teamToTeamRepo),              // V3 -> V4
+	NewMigration("generate collaboration from access", accessToCollaboration),    // V0 -> V1
+	NewMigration("make authorize 4 if team is owners", ownerTeamUpdate),          // V1 -> V2
+	NewMigration("refactor access table to use id's", accessRefactor),            // V2 -> V3
+	NewMigration("generate team-repo from team", teamToTeamRepo),                 // V3 -> V4
+	NewMigration("calculate type and size of public keys", publicKeyTypeSize),    // V4 -> V5
+	NewMigration("convert custom avatars to PNG", convertAvatarsToPNG),           // V5 -> V6
+	NewMigration("generate server-side hooks with site hooks", serverHooks),      // V6 -> V7
+	NewMigration("mark existing public keys as verified", verifyPublicKeys),      // V7 -> V8
+	NewMigration("report case-only duplicate key names", keyNameDuplicates),      // V8 -> V9
+	NewMigration("calculate fingerprints of public keys", publicKeyFingerprints), // V9 -> V10
+	NewMigration("give existing access tokens full access", accessTokenScopes),   // V10 -> V11
+	NewMigration("generate UUIDs and sizes of attachments", attachmentUUIDs),     // V11 -> V12
+	NewMigration("enable issues and wiki of repositories", repoFeatures),         // V12 -> V13
+	NewMigration("unset comment of issue attachments", attachmentCommentIds),     // V13 -> V14
+	NewMigration("calculate sizes of repositories", repoGitSizes),                // V14 -> V15
 }
 
 // Migrate database to current version
@@ -372,3 +396,299 @@ func teamToTeamRepo(x *xorm.Engine) error {
 
 	return sess.Commit()
 }
+
+func publicKeyTypeSize(x *xorm.Engine) error {
+	type PublicKey struct {
+		Id         int64
+		Content    string `xorm:"TEXT NOT NULL"`
+		Type       string `xorm:"VARCHAR(20)"`
+		Size       int
+		IsDisabled bool
+	}
+
+	if err := x.Sync2(new(PublicKey)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+
+	keys := make([]*PublicKey, 0, 50)
+	if err := x.Find(&keys); err != nil {
+		return fmt.Errorf("find public keys: %v", err)
+	}
+
+	tmpPath := path.Join(os.TempDir(), fmt.Sprintf("gogs_migration_%d.pub", time.Now().Nanosecond()))
+	defer os.Remove(tmpPath)
+	for _, key := range keys {
+		if err := ioutil.WriteFile(tmpPath, []byte(key.Content), 0600); err != nil {
+			return err
+		}
+		stdout, err := exec.Command("ssh-keygen", "-l", "-f", tmpPath).Output()
+		if err != nil {
+			log.Warn("Fail to calculate type and size of public key(%d): %v", key.Id, err)
+			continue
+		}
+
+		// Format: <size> <fingerprint> <comment> (<type>)
+		fields := strings.Fields(string(stdout))
+		if len(fields) < 3 {
+			continue
+		}
+		key.Size = com.StrTo(fields[0]).MustInt()
+		key.Type = strings.Trim(fields[len(fields)-1], "()")
+		if _, err = x.Id(key.Id).Cols("type", "size").Update(key); err != nil {
+			return fmt.Errorf("update public key(%d): %v", key.Id, err)
+		}
+	}
+	return nil
+}
+
+func convertAvatarsToPNG(x *xorm.Engine) error {
+	type User struct {
+		Id              int64
+		UseCustomAvatar bool
+	}
+
+	users := make([]*User, 0, 10)
+	if err := x.Where("use_custom_avatar=?", true).Find(&users); err != nil {
+		return fmt.Errorf("find users: %v", err)
+	}
+
+	for _, u := range users {
+		oldPath := path.Join(setting.AvatarUploadPath, com.ToStr(u.Id))
+		if !com.IsFile(oldPath) {
+			continue
+		}
+
+		if err := func() error {
+			fr, err := os.Open(oldPath)
+			if err != nil {
+				return err
+			}
+			defer fr.Close()
+
+			img, _, err := image.Decode(fr)
+			if err != nil {
+				return err
+			}
+
+			fw, err := os.Create(oldPath + ".png")
+			if err != nil {
+				return err
+			}
+			defer fw.Close()
+			return png.Encode(fw, img)
+		}(); err != nil {
+			log.Warn("Fail to convert custom avatar of user(%d): %v", u.Id, err)
+			continue
+		}
+		os.Remove(oldPath)
+	}
+	return nil
+}
+
+func serverHooks(x *xorm.Engine) error {
+	type SiteHook struct {
+		Id      int64
+		Event   string `xorm:"INDEX NOT NULL"`
+		Content string `xorm:"TEXT"`
+		Active  bool
+		Created time.Time `xorm:"CREATED"`
+		Updated time.Time `xorm:"UPDATED"`
+	}
+
+	if err := x.Sync2(new(SiteHook)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+
+	appPath, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return fmt.Errorf("LookPath: %v", err)
+	} else if appPath, err = filepath.Abs(appPath); err != nil {
+		return fmt.Errorf("Abs: %v", err)
+	}
+	appPath = strings.Replace(appPath, "\\", "/", -1)
+
+	results, err := x.Query("SELECT u.lower_name AS `owner`, r.lower_name AS `name` FROM `repository` r INNER JOIN `user` u ON r.owner_id=u.id")
+	if err != nil {
+		return fmt.Errorf("select repositories: %v", err)
+	}
+	for _, result := range results {
+		repoPath := filepath.Join(setting.RepoRootPath, string(result["owner"]), string(result["name"])+".git")
+		if !com.IsDir(repoPath) {
+			continue
+		}
+		if err = git.WriteServerHooks(repoPath, setting.ScriptType, setting.SiteHookRoot, map[string]string{
+			"update": fmt.Sprintf("\"%s\" update $1 $2 $3 --config='%s'", appPath, setting.CustomConf),
+		}); err != nil {
+			return fmt.Errorf("WriteServerHooks(%s): %v", repoPath, err)
+		}
+	}
+	return nil
+}
+
+func verifyPublicKeys(x *xorm.Engine) error {
+	type PublicKey struct {
+		Id          int64
+		Verified    bool
+		VerifyToken string
+	}
+
+	if err := x.Sync2(new(PublicKey)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+
+	// Keys added before verification was introduced are trusted.
+	_, err := x.Exec("UPDATE `public_key` SET verified=?", true)
+	return err
+}
+
+func keyNameDuplicates(x *xorm.Engine) error {
+	results, err := x.Query("SELECT `owner_id`, LOWER(`name`) AS `lower_name`, COUNT(*) AS `num` FROM `public_key` GROUP BY `owner_id`, LOWER(`name`) HAVING COUNT(*) > 1")
+	if err != nil {
+		return err
+	}
+
+	// Keys are kept as is, owners or admins need to rename them manually
+	// because renaming any of them to the same name would fail from now on.
+	for _, result := range results {
+		log.Warn("Public keys of user(%s) have names that differ only in case: %s (%s keys)",
+			result["owner_id"], result["lower_name"], result["num"])
+	}
+	return nil
+}
+
+func publicKeyFingerprints(x *xorm.Engine) error {
+	type PublicKey struct {
+		Id                int64
+		Content           string `xorm:"TEXT NOT NULL"`
+		FingerprintSha256 string `xorm:"INDEX"`
+		FingerprintMd5    string `xorm:"INDEX"`
+	}
+
+	if err := x.Sync2(new(PublicKey)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+
+	keys := make([]*PublicKey, 0, 50)
+	if err := x.Find(&keys); err != nil {
+		return fmt.Errorf("find public keys: %v", err)
+	}
+	for _, key := range keys {
+		fields := strings.Fields(key.Content)
+		if len(fields) < 2 {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			log.Warn("Fail to calculate fingerprints of public key(%d): %v", key.Id, err)
+			continue
+		}
+
+		sha256Sum := sha256.Sum256(blob)
+		md5Sum := md5.Sum(blob)
+		hexes := make([]string, len(md5Sum))
+		for i, b := range md5Sum {
+			hexes[i] = fmt.Sprintf("%02x", b)
+		}
+		key.FingerprintSha256 = "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(sha256Sum[:]), "=")
+		key.FingerprintMd5 = strings.Join(hexes, ":")
+		if _, err = x.Id(key.Id).Cols("fingerprint_sha256", "fingerprint_md5").Update(key); err != nil {
+			return fmt.Errorf("update public key(%d): %v", key.Id, err)
+		}
+	}
+	return nil
+}
+
+func accessTokenScopes(x *xorm.Engine) error {
+	type AccessToken struct {
+		Id     int64
+		Scopes string
+	}
+
+	if err := x.Sync2(new(AccessToken)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+	_, err := x.Exec("UPDATE `access_token` SET scopes=? WHERE scopes IS NULL OR scopes=''", "all")
+	return err
+}
+
+func attachmentUUIDs(x *xorm.Engine) error {
+	type Attachment struct {
+		Id            int64
+		Uuid          string
+		Path          string `xorm:"TEXT"`
+		Size          int64
+		DownloadCount int
+	}
+
+	if err := x.Sync2(new(Attachment)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+
+	attachments := make([]*Attachment, 0, 10)
+	if err := x.Where("uuid IS NULL OR uuid=''").Find(&attachments); err != nil {
+		return fmt.Errorf("find attachments: %v", err)
+	}
+	for _, a := range attachments {
+		a.Uuid = uuid.NewV4().String()
+		if fi, err := os.Stat(a.Path); err == nil {
+			a.Size = fi.Size()
+		}
+		if _, err := x.Id(a.Id).Cols("uuid", "size").Update(a); err != nil {
+			return fmt.Errorf("update attachment(%d): %v", a.Id, err)
+		}
+	}
+	return nil
+}
+
+func repoFeatures(x *xorm.Engine) error {
+	type Repository struct {
+		Id        int64
+		HasIssues bool `xorm:"NOT NULL DEFAULT true"`
+		HasWiki   bool `xorm:"NOT NULL DEFAULT true"`
+	}
+
+	if err := x.Sync2(new(Repository)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+	_, err := x.Exec("UPDATE `repository` SET has_issues=?, has_wiki=?", true, true)
+	return err
+}
+
+// attachmentCommentIds sets comment ID of attachments that belong to issue itself
+// to NULL instead of 0.
+func attachmentCommentIds(x *xorm.Engine) error {
+	_, err := x.Exec("UPDATE `attachment` SET comment_id=NULL WHERE comment_id=0")
+	return err
+}
+
+func repoGitSizes(x *xorm.Engine) error {
+	type Repository struct {
+		Id      int64
+		GitSize int64 `xorm:"NOT NULL DEFAULT 0"`
+	}
+
+	if err := x.Sync2(new(Repository)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+
+	results, err := x.Query("SELECT r.id, u.lower_name AS `owner`, r.lower_name AS `name` FROM `repository` r INNER JOIN `user` u ON r.owner_id=u.id")
+	if err != nil {
+		return fmt.Errorf("select repositories: %v", err)
+	}
+	for _, result := range results {
+		repoPath := filepath.Join(setting.RepoRootPath, string(result["owner"]), string(result["name"])+".git")
+		if !com.IsDir(repoPath) {
+			continue
+		}
+		size, err := git.GetRepoSize(repoPath)
+		if err != nil {
+			log.Warn("Fail to calculate size of repository(%s): %v", repoPath, err)
+			continue
+		}
+		if _, err = x.Id(com.StrTo(string(result["id"])).MustInt64()).Cols("git_size").
+			Update(&Repository{GitSize: size}); err != nil {
+			return fmt.Errorf("update repository(%s): %v", result["id"], err)
+		}
+	}
+	return nil
+}
@@ -60,8 +60,12 @@ func init() {
 		new(Issue), new(Comment), new(Attachment), new(IssueUser), new(Label), new(Milestone),
 		new(Mirror), new(Release), new(LoginSource), new(Webhook),
 		new(UpdateTask), new(HookTask),
-		new(Team), new(OrgUser), new(TeamUser), new(TeamRepo),
-		new(Notice), new(EmailAddress))
+		new(Team), new(OrgUser), new(TeamUser), new(TeamRepo), new(TeamRepoPermission),
+		new(Notice), new(EmailAddress), new(CommitMessageRule), new(TagPolicy),
+		new(KeyActivity), new(GPGKey), new(UserBlock), new(SiteHook), new(SecurityLog),
+		new(AccountExport), new(KeyUsage), new(RepoLanguage), new(RepoLanguageTask), new(SearchIndexTask),
+		new(KeySource), new(KeyReplica), new(RepoTraffic), new(RepoTrafficVisitor),
+		new(ImportedPublicKey), new(PushSubscription), new(PushNotifyTask), new(KeyApprovalNotifyTask))
 }
 
 func LoadModelsConfig() {
@@ -83,6 +87,12 @@ func LoadModelsConfig() {
 	}
 	DbCfg.SSLMode = sec.Key("SSL_MODE").String()
 	DbCfg.Path = sec.Key("PATH").MustString("data/gogs.db")
+
+	loadSSHPath()
+
+	if len(setting.IssueCloseKeywords) > 0 {
+		SetIssueCloseKeywords(setting.IssueCloseKeywords)
+	}
 }
 
 func getEngine() (*xorm.Engine, error) {
@@ -179,7 +189,8 @@ type Statistic struct {
 		Issue, Comment, Oauth, Follow,
 		Mirror, Release, LoginSource, Webhook,
 		Milestone, Label, HookTask,
-		Team, UpdateTask, Attachment int64
+		Team, UpdateTask, Attachment,
+		PendingPublicKey int64
 	}
 }
 
@@ -187,6 +198,7 @@ func GetStatistic() (stats Statistic) {
 	stats.Counter.User = CountUsers()
 	stats.Counter.Org = CountOrganizations()
 	stats.Counter.PublicKey, _ = x.Count(new(PublicKey))
+	stats.Counter.PendingPublicKey = CountPendingPublicKeys()
 	stats.Counter.Repo = CountRepositories()
 	stats.Counter.Watch, _ = x.Count(new(Watch))
 	stats.Counter.Star, _ = x.Count(new(Star))
@@ -213,6 +225,11 @@ func Ping() error {
 	return x.Ping()
 }
 
+// CloseEngine closes all connections to database.
+func CloseEngine() error {
+	return x.Close()
+}
+
 // DumpDatabase dumps all data from database to file system.
 func DumpDatabase(filePath string) error {
 	return x.DumpAllToFile(filePath)
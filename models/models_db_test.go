@@ -0,0 +1,58 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-xorm/xorm"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// newTestEngine makes a sqlite database in a temporary directory, with given tables,
+// the engine of package. Returned function restores engine, paths and settings that
+// tests commonly change, and removes the directory.
+func newTestEngine(t *testing.T, beans ...interface{}) (string, func()) {
+	tmpDir, err := ioutil.TempDir("", "gogs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldEngine, oldSSHPath := x, SSHPath
+	oldRepoRootPath, oldSSHRootPath := setting.RepoRootPath, setting.SSHRootPath
+	oldLogRootPath, oldDefaultBranch := setting.LogRootPath, setting.DefaultBranch
+	oldAttachmentPath, oldAttachmentTypes, oldAttachmentSize :=
+		setting.AttachmentPath, setting.AttachmentAllowedTypes, setting.AttachmentMaxSize
+	oldService := setting.Service
+	cleanup := func() {
+		if x != nil && x != oldEngine {
+			x.Close()
+		}
+		x, SSHPath = oldEngine, oldSSHPath
+		setting.RepoRootPath, setting.SSHRootPath = oldRepoRootPath, oldSSHRootPath
+		setting.LogRootPath, setting.DefaultBranch = oldLogRootPath, oldDefaultBranch
+		setting.AttachmentPath, setting.AttachmentAllowedTypes, setting.AttachmentMaxSize =
+			oldAttachmentPath, oldAttachmentTypes, oldAttachmentSize
+		setting.Service = oldService
+		os.RemoveAll(tmpDir)
+	}
+
+	x, err = xorm.NewEngine("sqlite3", filepath.Join(tmpDir, "gogs.db"))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err = x.Sync2(beans...); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return tmpDir, cleanup
+}
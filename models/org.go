@@ -19,6 +19,13 @@ var (
 	ErrTeamNotExist     = errors.New("Team does not exist")
 	ErrTeamNameIllegal  = errors.New("Team name contains illegal characters")
 	ErrLastOrgOwner     = errors.New("The user to remove is the last member in owner team")
+
+	ErrOrgTransferNotOwner  = errors.New("Previous owner is not an owner of organization")
+	ErrOrgTransferNotMember = errors.New("New owner is not a member of organization")
+	ErrOrgTransferSameOwner = errors.New("New owner is the same as previous owner")
+
+	ErrTeamRepoPermissionNotExist = errors.New("Team repository permission does not exist")
+	ErrTeamPermissionInvalid      = errors.New("Team permission must be read, write or admin")
 )
 
 // IsOwnedBy returns true if given user is in the owner team.
@@ -216,7 +223,7 @@ func GetOrganizations(num, offset int) ([]*User, error) {
 // TODO: need some kind of mechanism to record failure.
 // DeleteOrganization completely and permanently deletes everything of organization.
 func DeleteOrganization(org *User) (err error) {
-	if err := DeleteUser(org); err != nil {
+	if err := DeleteUser(org, SecurityActorSystem); err != nil {
 		return err
 	}
 
@@ -290,6 +297,40 @@ func GetOrgUsersByOrgId(orgId int64) ([]*OrgUser, error) {
 	return ous, err
 }
 
+// GetOrgMembers returns a page of members of organization by given name
+// and total number of members.
+func GetOrgMembers(orgName string, page, limit int) ([]*User, int64, error) {
+	org, err := GetOrgByName(orgName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := x.Where("org_id=?", org.Id).Count(new(OrgUser))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ous := make([]*OrgUser, 0, limit)
+	if err = x.Where("org_id=?", org.Id).Asc("id").Limit(limit, (page-1)*limit).Find(&ous); err != nil {
+		return nil, 0, err
+	}
+
+	members := make([]*User, len(ous))
+	for i, ou := range ous {
+		members[i], err = GetUserById(ou.Uid)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return members, total, nil
+}
+
+// GetOrgTeams returns a page of teams of organization.
+func GetOrgTeams(orgId int64, page, limit int) ([]*Team, error) {
+	teams := make([]*Team, 0, limit)
+	return teams, x.Where("org_id=?", orgId).Asc("id").Limit(limit, (page-1)*limit).Find(&teams)
+}
+
 // ChangeOrgUserStatus changes public or private membership status.
 func ChangeOrgUserStatus(orgId, uid int64, public bool) error {
 	ou := new(OrgUser)
@@ -333,6 +374,45 @@ func AddOrgUser(orgId, uid int64) error {
 	return sess.Commit()
 }
 
+// TransferOrgOwnership makes new owner a member of owner team of organization
+// in place of previous owner, who stays in organization as a regular member.
+// New owner must already be a member of organization.
+func TransferOrgOwnership(orgId int64, oldOwner, newOwner *User) error {
+	org, err := GetUserById(orgId)
+	if err != nil {
+		return err
+	} else if !org.IsOrganization() {
+		return ErrOrgNotExist
+	}
+
+	if oldOwner.Id == newOwner.Id {
+		return ErrOrgTransferSameOwner
+	} else if !org.IsOwnedBy(oldOwner.Id) {
+		return ErrOrgTransferNotOwner
+	} else if !org.IsOrgMember(newOwner.Id) {
+		return ErrOrgTransferNotMember
+	}
+
+	t, err := org.GetOwnerTeam()
+	if err != nil {
+		return fmt.Errorf("GetOwnerTeam: %v", err)
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	// New owner is added first, so previous owner is never the last one to be removed.
+	if err = addTeamMember(sess, orgId, t.ID, newOwner.Id); err != nil {
+		return fmt.Errorf("addTeamMember: %v", err)
+	} else if err = removeTeamMember(sess, orgId, t.ID, oldOwner.Id); err != nil {
+		return fmt.Errorf("removeTeamMember: %v", err)
+	}
+	return sess.Commit()
+}
+
 // RemoveOrgUser removes user from given organization.
 func RemoveOrgUser(orgId, uid int64) error {
 	ou := new(OrgUser)
@@ -544,6 +624,8 @@ func (t *Team) AddRepository(repo *Repository) (err error) {
 func (t *Team) removeRepository(e Engine, repo *Repository, recalculate bool) (err error) {
 	if err = removeTeamRepo(e, t.ID, repo.Id); err != nil {
 		return err
+	} else if _, err = e.Delete(&TeamRepoPermission{TeamID: t.ID, RepoID: repo.Id}); err != nil {
+		return fmt.Errorf("delete team repository permission: %v", err)
 	}
 
 	t.NumRepos--
@@ -745,6 +827,11 @@ func DeleteTeam(t *Team) error {
 		return err
 	}
 
+	// Delete team-repository permission overrides.
+	if _, err = sess.Delete(&TeamRepoPermission{TeamID: t.ID}); err != nil {
+		return err
+	}
+
 	// Delete team.
 	if _, err = sess.Id(t.ID).Delete(new(Team)); err != nil {
 		return err
@@ -798,9 +885,22 @@ func getTeamMembers(e Engine, teamID int64) (_ []*User, err error) {
 	return members, nil
 }
 
-// GetTeamMembers returns all members in given team of organization.
-func GetTeamMembers(teamID int64) ([]*User, error) {
-	return getTeamMembers(x, teamID)
+// GetTeamMembers returns a page of members in given team of organization.
+func GetTeamMembers(teamID int64, page, limit int) ([]*User, error) {
+	teamUsers := make([]*TeamUser, 0, limit)
+	if err := x.Where("team_id=?", teamID).Asc("id").Limit(limit, (page-1)*limit).Find(&teamUsers); err != nil {
+		return nil, fmt.Errorf("get team-users: %v", err)
+	}
+
+	members := make([]*User, len(teamUsers))
+	for i := range teamUsers {
+		u, err := GetUserById(teamUsers[i].Uid)
+		if err != nil {
+			return nil, fmt.Errorf("GetUserById(%d): %v", teamUsers[i].Uid, err)
+		}
+		members[i] = u
+	}
+	return members, nil
 }
 
 func getUserTeams(e Engine, orgId, uid int64) ([]*Team, error) {
@@ -838,20 +938,31 @@ func AddTeamMember(orgId, teamId, uid int64) error {
 		return err
 	}
 
-	// Get team and its repositories.
-	t, err := GetTeamById(teamId)
-	if err != nil {
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err := sess.Begin(); err != nil {
 		return err
 	}
-	t.NumMembers++
+	if err := addTeamMember(sess, orgId, teamId, uid); err != nil {
+		return err
+	}
+	return sess.Commit()
+}
 
-	if err = t.GetRepositories(); err != nil {
+// addTeamMember adds user who is already a member of organization to given team.
+func addTeamMember(e Engine, orgId, teamId, uid int64) error {
+	if isTeamMember(e, orgId, teamId, uid) {
+		return nil
+	}
+
+	// Get team and its repositories.
+	t, err := getTeamById(e, teamId)
+	if err != nil {
 		return err
 	}
+	t.NumMembers++
 
-	sess := x.NewSession()
-	defer sessionRelease(sess)
-	if err = sess.Begin(); err != nil {
+	if err = t.getRepositories(e); err != nil {
 		return err
 	}
 
@@ -860,33 +971,32 @@ func AddTeamMember(orgId, teamId, uid int64) error {
 		OrgID:  orgId,
 		TeamID: teamId,
 	}
-	if _, err = sess.Insert(tu); err != nil {
+	if _, err = e.Insert(tu); err != nil {
 		return err
-	} else if _, err = sess.Id(t.ID).Update(t); err != nil {
+	} else if _, err = e.Id(t.ID).Update(t); err != nil {
 		return err
 	}
 
 	// Give access to team repositories.
 	for _, repo := range t.Repos {
-		if err = repo.recalculateTeamAccesses(sess, 0); err != nil {
+		if err = repo.recalculateTeamAccesses(e, 0); err != nil {
 			return err
 		}
 	}
 
 	// We make sure it exists before.
 	ou := new(OrgUser)
-	if _, err = sess.Where("uid=?", uid).And("org_id=?", orgId).Get(ou); err != nil {
+	if _, err = e.Where("uid=?", uid).And("org_id=?", orgId).Get(ou); err != nil {
 		return err
 	}
 	ou.NumTeams++
 	if t.IsOwnerTeam() {
 		ou.IsOwner = true
 	}
-	if _, err = sess.Id(ou.ID).AllCols().Update(ou); err != nil {
+	if _, err = e.Id(ou.ID).AllCols().Update(ou); err != nil {
 		return err
 	}
-
-	return sess.Commit()
+	return nil
 }
 
 func removeTeamMember(e Engine, orgId, teamId, uid int64) error {
@@ -1028,3 +1138,139 @@ func removeOrgRepo(e Engine, orgID, repoID int64) error {
 func RemoveOrgRepo(orgID, repoID int64) error {
 	return removeOrgRepo(x, orgID, repoID)
 }
+
+// TeamRepoPermission represents a repository-specific permission of a team
+// that overrides the default authorization of the team.
+type TeamRepoPermission struct {
+	ID         int64  `xorm:"pk autoincr"`
+	TeamID     int64  `xorm:"UNIQUE(s)"`
+	RepoID     int64  `xorm:"UNIQUE(s) INDEX"`
+	Permission string `xorm:"VARCHAR(10)"` // One of "read", "write" and "admin".
+}
+
+// teamPermissionAccessMode returns access mode of given team permission.
+func teamPermissionAccessMode(permission string) (AccessMode, error) {
+	switch permission {
+	case "read":
+		return ACCESS_MODE_READ, nil
+	case "write":
+		return ACCESS_MODE_WRITE, nil
+	case "admin":
+		return ACCESS_MODE_ADMIN, nil
+	}
+	return ACCESS_MODE_NONE, ErrTeamPermissionInvalid
+}
+
+func getTeamRepoPermission(e Engine, teamID, repoID int64) (*TeamRepoPermission, error) {
+	p := &TeamRepoPermission{
+		TeamID: teamID,
+		RepoID: repoID,
+	}
+	has, err := e.Get(p)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrTeamRepoPermissionNotExist
+	}
+	return p, nil
+}
+
+// GetTeamRepoPermission returns the permission override of team for given repository.
+func GetTeamRepoPermission(teamID, repoID int64) (*TeamRepoPermission, error) {
+	return getTeamRepoPermission(x, teamID, repoID)
+}
+
+// teamRepoAccessMode returns the access mode of team to given repository,
+// taking repository-specific overrides into account.
+func (t *Team) teamRepoAccessMode(e Engine, repoID int64) (AccessMode, error) {
+	if t.IsOwnerTeam() {
+		return ACCESS_MODE_OWNER, nil
+	}
+
+	p, err := getTeamRepoPermission(e, t.ID, repoID)
+	if err != nil {
+		if err == ErrTeamRepoPermissionNotExist {
+			return t.Authorize, nil
+		}
+		return ACCESS_MODE_NONE, err
+	}
+	return teamPermissionAccessMode(p.Permission)
+}
+
+// SetTeamRepoPermission sets the permission of team to given repository,
+// overriding the default authorization of the team.
+func SetTeamRepoPermission(teamID, repoID int64, permission string) (err error) {
+	if _, err = teamPermissionAccessMode(permission); err != nil {
+		return err
+	}
+
+	t, err := GetTeamById(teamID)
+	if err != nil {
+		return err
+	} else if t.IsOwnerTeam() {
+		return errors.New("Cannot override permission of owner team")
+	} else if !t.HasRepository(repoID) {
+		return errors.New("Repository does not belong to team")
+	}
+
+	repo, err := GetRepositoryById(repoID)
+	if err != nil {
+		return err
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	p, err := getTeamRepoPermission(sess, teamID, repoID)
+	if err != nil {
+		if err != ErrTeamRepoPermissionNotExist {
+			return err
+		}
+		if _, err = sess.Insert(&TeamRepoPermission{
+			TeamID:     teamID,
+			RepoID:     repoID,
+			Permission: permission,
+		}); err != nil {
+			return fmt.Errorf("insert: %v", err)
+		}
+	} else {
+		p.Permission = permission
+		if _, err = sess.Id(p.ID).AllCols().Update(p); err != nil {
+			return fmt.Errorf("update: %v", err)
+		}
+	}
+
+	if err = repo.recalculateTeamAccesses(sess, 0); err != nil {
+		return fmt.Errorf("recalculateTeamAccesses: %v", err)
+	}
+	return sess.Commit()
+}
+
+// DeleteTeamRepoPermission removes the permission override of team for given repository,
+// so the default authorization of the team applies again.
+func DeleteTeamRepoPermission(teamID, repoID int64) (err error) {
+	repo, err := GetRepositoryById(repoID)
+	if err != nil {
+		return err
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if has, err := sess.Delete(&TeamRepoPermission{TeamID: teamID, RepoID: repoID}); err != nil {
+		return err
+	} else if has == 0 {
+		return ErrTeamRepoPermissionNotExist
+	}
+
+	if err = repo.recalculateTeamAccesses(sess, 0); err != nil {
+		return fmt.Errorf("recalculateTeamAccesses: %v", err)
+	}
+	return sess.Commit()
+}
@@ -0,0 +1,136 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestTransferOrgOwnership(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(OrgUser), new(Team), new(TeamUser), new(TeamRepo))
+	defer cleanup()
+	var err error
+
+	org := &User{Name: "org", LowerName: "org", Type: ORGANIZATION}
+	owner := &User{Name: "owner", LowerName: "owner"}
+	member := &User{Name: "member", LowerName: "member"}
+	outsider := &User{Name: "outsider", LowerName: "outsider"}
+	for _, u := range []*User{org, owner, member, outsider} {
+		if _, err = x.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	team := &Team{OrgID: org.Id, LowerName: "owners", Name: OWNER_TEAM, Authorize: ACCESS_MODE_OWNER, NumMembers: 1}
+	if _, err = x.Insert(team); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = x.Insert(&OrgUser{Uid: owner.Id, OrgID: org.Id, IsOwner: true, NumTeams: 1},
+		&OrgUser{Uid: member.Id, OrgID: org.Id},
+		&TeamUser{Uid: owner.Id, OrgID: org.Id, TeamID: team.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = TransferOrgOwnership(org.Id, owner, outsider); err != ErrOrgTransferNotMember {
+		t.Errorf("expect ErrOrgTransferNotMember but got %v", err)
+	}
+	if err = TransferOrgOwnership(org.Id, member, owner); err != ErrOrgTransferNotOwner {
+		t.Errorf("expect ErrOrgTransferNotOwner but got %v", err)
+	}
+	if err = TransferOrgOwnership(org.Id, owner, owner); err != ErrOrgTransferSameOwner {
+		t.Errorf("expect ErrOrgTransferSameOwner but got %v", err)
+	}
+
+	if err = TransferOrgOwnership(org.Id, owner, member); err != nil {
+		t.Fatal(err)
+	}
+	if !IsOrganizationOwner(org.Id, member.Id) {
+		t.Error("expect new owner to be an owner")
+	}
+	if IsOrganizationOwner(org.Id, owner.Id) {
+		t.Error("expect previous owner not to be an owner")
+	}
+	if !IsOrganizationMember(org.Id, owner.Id) {
+		t.Error("expect previous owner to stay a member")
+	}
+	if IsTeamMember(org.Id, team.ID, owner.Id) || !IsTeamMember(org.Id, team.ID, member.Id) {
+		t.Error("expect owner team to only have new owner")
+	}
+}
+
+func TestTeamRepoPermission(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(OrgUser), new(Team), new(TeamUser), new(TeamRepo),
+		new(TeamRepoPermission), new(Repository), new(Access), new(Collaboration))
+	defer cleanup()
+	var err error
+
+	org := &User{Name: "org", LowerName: "org", Type: ORGANIZATION}
+	member := &User{Name: "member", LowerName: "member"}
+	for _, u := range []*User{org, member} {
+		if _, err = x.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := &Repository{OwnerId: org.Id, Name: "repo", LowerName: "repo", IsPrivate: true}
+	other := &Repository{OwnerId: org.Id, Name: "other", LowerName: "other", IsPrivate: true}
+	if _, err = x.Insert(repo, other); err != nil {
+		t.Fatal(err)
+	}
+	team := &Team{OrgID: org.Id, LowerName: "readers", Name: "Readers", Authorize: ACCESS_MODE_READ, NumMembers: 1, NumRepos: 1}
+	if _, err = x.Insert(team); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = x.Insert(&OrgUser{Uid: member.Id, OrgID: org.Id, NumTeams: 1},
+		&TeamUser{Uid: member.Id, OrgID: org.Id, TeamID: team.ID},
+		&TeamRepo{OrgID: org.Id, TeamID: team.ID, RepoID: repo.Id}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectMode := func(expect AccessMode) {
+		if mode, err := AccessLevel(member, repo); err != nil {
+			t.Fatal(err)
+		} else if mode != expect {
+			t.Errorf("expect access mode %d but got %d", expect, mode)
+		}
+	}
+
+	if err = SetTeamRepoPermission(team.ID, repo.Id, "owner"); err != ErrTeamPermissionInvalid {
+		t.Errorf("expect ErrTeamPermissionInvalid but got %v", err)
+	}
+	if err = SetTeamRepoPermission(team.ID, other.Id, "write"); err == nil {
+		t.Error("expect repository that does not belong to team to be rejected")
+	}
+
+	if err = SetTeamRepoPermission(team.ID, repo.Id, "write"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := GetTeamRepoPermission(team.ID, repo.Id); err != nil {
+		t.Fatal(err)
+	} else if p.Permission != "write" {
+		t.Errorf("expect permission write but got %q", p.Permission)
+	}
+	expectMode(ACCESS_MODE_WRITE)
+
+	// Existing override is updated in place.
+	if err = SetTeamRepoPermission(team.ID, repo.Id, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := x.Count(new(TeamRepoPermission)); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Errorf("expect 1 override but got %d", count)
+	}
+	expectMode(ACCESS_MODE_ADMIN)
+
+	// Team default applies again once override is removed.
+	if err = DeleteTeamRepoPermission(team.ID, repo.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectMode(ACCESS_MODE_READ)
+	if err = DeleteTeamRepoPermission(team.ID, repo.Id); err != ErrTeamRepoPermissionNotExist {
+		t.Errorf("expect ErrTeamRepoPermissionNotExist but got %v", err)
+	}
+}
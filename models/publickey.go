@@ -6,6 +6,8 @@ package models
 
 import (
 	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
@@ -16,12 +18,15 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Unknwon/com"
+	"github.com/go-xorm/xorm"
 
+	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/process"
 	"github.com/gogits/gogs/modules/setting"
@@ -30,14 +35,92 @@ import (
 const (
 	// "### autogenerated by gitgos, DO NOT EDIT\n"
 	_TPL_PUBLICK_KEY = `command="%s serv key-%d --config='%s'",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty %s` + "\n"
+
+	// Principal and namespace used by "ssh-keygen -Y" to verify ownership of public key.
+	_KEY_VERIFY_PRINCIPAL = "gogs"
+	_KEY_VERIFY_NAMESPACE = "gogs"
 )
 
 var (
 	ErrKeyAlreadyExist = errors.New("Public key already exists")
 	ErrKeyNotExist     = errors.New("Public key does not exist")
 	ErrKeyUnableVerify = errors.New("Unable to verify public key")
+	ErrKeyAccessDenied = errors.New("User does not have access to public key")
+	ErrKeyVerifyFailed = errors.New("Unable to verify signature of public key")
+	ErrKeyNotPending   = errors.New("Public key is not waiting for approval")
+
+	ErrInvalidFingerprint = errors.New("Invalid fingerprint format")
+
+	ErrKeysRewriteInProgress = errors.New("Rewrite of authorized_keys file is in progress")
+	ErrKeyExternallyManaged  = errors.New("Public key is managed by login source")
+	ErrInvalidKeyAccessMode  = errors.New("Invalid access mode of public key")
+)
+
+// KeyAccessMode limits git commands a public key can run over SSH,
+// regardless of access its owner has to the repository.
+type KeyAccessMode string
+
+const (
+	KEY_ACCESS_MODE_FULL KeyAccessMode = "full"
+	KEY_ACCESS_MODE_READ KeyAccessMode = "read-only"
 )
 
+// ParseKeyAccessMode returns access mode of given name, empty name means full access.
+func ParseKeyAccessMode(name string) (KeyAccessMode, error) {
+	switch KeyAccessMode(name) {
+	case "", KEY_ACCESS_MODE_FULL:
+		return KEY_ACCESS_MODE_FULL, nil
+	case KEY_ACCESS_MODE_READ:
+		return KEY_ACCESS_MODE_READ, nil
+	}
+	return "", ErrInvalidKeyAccessMode
+}
+
+// ErrKeyQuotaExceeded represents a user who has reached maximum number of SSH keys.
+type ErrKeyQuotaExceeded struct {
+	Quota int
+}
+
+func (err ErrKeyQuotaExceeded) Error() string {
+	return fmt.Sprintf("maximum number of SSH keys (%d) has been reached", err.Quota)
+}
+
+func IsErrKeyQuotaExceeded(err error) bool {
+	_, ok := err.(ErrKeyQuotaExceeded)
+	return ok
+}
+
+// ErrKeyNameAlreadyUsed represents a key name which has been used by another key of same owner,
+// names are compared case-insensitively.
+type ErrKeyNameAlreadyUsed struct {
+	OwnerId int64
+	Name    string
+}
+
+func (err ErrKeyNameAlreadyUsed) Error() string {
+	return fmt.Sprintf("public key name has been used [owner_id: %d, name: %s]", err.OwnerId, err.Name)
+}
+
+func IsErrKeyNameAlreadyUsed(err error) bool {
+	_, ok := err.(ErrKeyNameAlreadyUsed)
+	return ok
+}
+
+// ErrKeyFingerprintAlreadyUsed represents an error that public key being added
+// has been registered to another user.
+type ErrKeyFingerprintAlreadyUsed struct {
+	OwnerName string
+}
+
+func (err ErrKeyFingerprintAlreadyUsed) Error() string {
+	return fmt.Sprintf("public key has been registered to another user [owner: %s]", err.OwnerName)
+}
+
+func IsErrKeyFingerprintAlreadyUsed(err error) bool {
+	_, ok := err.(ErrKeyFingerprintAlreadyUsed)
+	return ok
+}
+
 var sshOpLocker = sync.Mutex{}
 
 var (
@@ -70,32 +153,124 @@ func init() {
 		log.Fatal(4, "fail to get app path: %v\n", err)
 	}
 	appPath = strings.Replace(appPath, "\\", "/", -1)
+}
 
-	// Determine and create .ssh path.
-	SSHPath = filepath.Join(homeDir(), ".ssh")
-	if err = os.MkdirAll(SSHPath, 0700); err != nil {
+// loadSSHPath determines and creates directory of authorized_keys file after
+// configuration is loaded, it is .ssh in home directory unless configured.
+func loadSSHPath() {
+	SSHPath = setting.SSHRootPath
+	if len(SSHPath) == 0 {
+		SSHPath = filepath.Join(homeDir(), ".ssh")
+	}
+	if err := os.MkdirAll(SSHPath, 0700); err != nil {
 		log.Fatal(4, "fail to create '%s': %v", SSHPath, err)
 	}
 }
 
+// CheckSSHPath returns an error if authorized_keys file cannot be written,
+// nothing is checked when the file is not managed by Gogs.
+func CheckSSHPath() error {
+	if setting.DisableSSH || !isAuthorizedKeysManaged() {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(SSHPath, "authorized_keys.check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	fpath := filepath.Join(SSHPath, "authorized_keys")
+	if !com.IsExist(fpath) {
+		return nil
+	}
+	if f, err = os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
 // PublicKey represents a SSH key.
 type PublicKey struct {
 	Id                int64
-	OwnerId           int64     `xorm:"UNIQUE(s) INDEX NOT NULL"`
-	Name              string    `xorm:"UNIQUE(s) NOT NULL"`
-	Fingerprint       string    `xorm:"INDEX NOT NULL"`
-	Content           string    `xorm:"TEXT NOT NULL"`
-	Created           time.Time `xorm:"CREATED"`
+	OwnerId           int64  `xorm:"UNIQUE(s) INDEX NOT NULL"`
+	Name              string `xorm:"UNIQUE(s) NOT NULL"`
+	Fingerprint       string `xorm:"INDEX NOT NULL"`
+	FingerprintSha256 string `xorm:"INDEX"` // Always in "SHA256:<base64>" format regardless of ssh-keygen version.
+	FingerprintMd5    string `xorm:"INDEX"` // Always in "aa:bb:..." format regardless of ssh-keygen version.
+	Content           string `xorm:"TEXT NOT NULL"`
+	Type              string `xorm:"VARCHAR(20)"`
+	Size              int
+	IsDisabled        bool
+	IsPending         bool // Waiting for admin approval.
+	Verified          bool
+	VerifyToken       string
+	BelowPolicy       bool          // Does not meet current minimum size or type policy.
+	BelowPolicySince  time.Time     // When key was flagged as below policy.
+	PolicyVersion     string        `xorm:"VARCHAR(32)"` // Version of policy key was last checked against.
+	Revision          int64         // Increased on every change, so listings can tell when keys were modified.
+	LoginSource       int64         // Login source that manages key, 0 for keys added by user.
+	KeySourceId       int64         // Key source URL that manages key, 0 for keys added by user.
+	Mode              KeyAccessMode `xorm:"VARCHAR(20)"` // Empty for keys added before modes existed, which have full access.
+	Created           time.Time     `xorm:"CREATED"`
 	Updated           time.Time
 	HasRecentActivity bool `xorm:"-"`
 	HasUsed           bool `xorm:"-"`
 }
 
+// IsExternal returns true if key is managed by a login source or a key source URL,
+// e.g. synchronized from LDAP, such keys cannot be changed by their owners.
+func (k *PublicKey) IsExternal() bool {
+	return k.LoginSource > 0 || k.KeySourceId > 0
+}
+
+// IsReadOnly returns true if key can only be used to fetch repositories.
+func (k *PublicKey) IsReadOnly() bool {
+	return k.Mode == KEY_ACCESS_MODE_READ
+}
+
+// AccessMode returns access mode of key, which is full for keys that have none set.
+func (k *PublicKey) AccessMode() KeyAccessMode {
+	if k.IsReadOnly() {
+		return KEY_ACCESS_MODE_READ
+	}
+	return KEY_ACCESS_MODE_FULL
+}
+
 // OmitEmail returns content of public key but without e-mail address.
 func (k *PublicKey) OmitEmail() string {
 	return strings.Join(strings.Split(k.Content, " ")[:2], " ")
 }
 
+// IsUsable returns true if key can be used to access repositories,
+// which means it is enabled, approved and verified when verification is required.
+func (k *PublicKey) IsUsable() bool {
+	return !k.IsDisabled && !k.IsPending && (k.Verified || !setting.RequireSSHKeyVerify)
+}
+
+// ExpiresAt returns time when key stops working without action of its owner,
+// which is when unverified key gets deleted or key below policy gets disabled.
+// Zero time is returned when key does not expire.
+func (k *PublicKey) ExpiresAt() time.Time {
+	var expires time.Time
+	if !k.Verified && setting.RequireSSHKeyVerify && setting.UnverifiedSSHKeyExpire > 0 {
+		expires = k.Created.AddDate(0, 0, setting.UnverifiedSSHKeyExpire)
+	}
+	if k.BelowPolicy && !k.IsDisabled && setting.SSHKeyPolicyGraceDays > 0 {
+		if t := k.BelowPolicySince.AddDate(0, 0, setting.SSHKeyPolicyGraceDays); expires.IsZero() || t.Before(expires) {
+			expires = t
+		}
+	}
+	return expires
+}
+
+// VerifyCommand returns command for owner to sign verification token with private key,
+// path of private key file is a placeholder since it differs by key type and user.
+func (k *PublicKey) VerifyCommand() string {
+	return fmt.Sprintf("echo -n '%s' | ssh-keygen -Y sign -n %s -f /path/to/private_key", k.VerifyToken, _KEY_VERIFY_NAMESPACE)
+}
+
 // GetAuthorizedString generates and returns formatted public key string for authorized_keys file.
 func (key *PublicKey) GetAuthorizedString() string {
 	return fmt.Sprintf(_TPL_PUBLICK_KEY, appPath, key.Id, setting.CustomConf, key.Content)
@@ -113,6 +288,28 @@ var (
 	}
 )
 
+// KeyPolicyVersion returns version of current minimum key size and type policy,
+// which changes whenever MinimumKeySize is changed.
+func KeyPolicyVersion() string {
+	policies := make([]string, 0, len(MinimumKeySize))
+	for keyType, size := range MinimumKeySize {
+		policies = append(policies, fmt.Sprintf("%s:%d", keyType, size))
+	}
+	sort.Strings(policies)
+	return base.EncodeMd5(strings.Join(policies, ","))
+}
+
+// MeetsPolicy returns true if key type is allowed and key size is not less than
+// minimum size of its type. Keys without known type are considered as meeting policy
+// because they cannot be evaluated.
+func (k *PublicKey) MeetsPolicy() bool {
+	if len(k.Type) == 0 {
+		return true
+	}
+	minimumKeySize := MinimumKeySize["("+k.Type+")"]
+	return minimumKeySize > 0 && k.Size >= minimumKeySize
+}
+
 func extractTypeFromBase64Key(key string) (string, error) {
 	b, err := base64.StdEncoding.DecodeString(key)
 	if err != nil || len(b) < 4 {
@@ -242,8 +439,18 @@ func CheckPublicKeyString(content string) (bool, error) {
 	return true, nil
 }
 
+// isAuthorizedKeysManaged returns true if authorized_keys file should be kept in sync
+// with database, which is not needed when only built-in SSH server is used.
+func isAuthorizedKeysManaged() bool {
+	return !setting.StartSSHServer || setting.SSHKeepAuthorizedKeys
+}
+
 // saveAuthorizedKeyFile writes SSH key content to authorized_keys file.
 func saveAuthorizedKeyFile(keys ...*PublicKey) error {
+	if !isAuthorizedKeysManaged() {
+		return nil
+	}
+
 	sshOpLocker.Lock()
 	defer sshOpLocker.Unlock()
 
@@ -275,11 +482,72 @@ func saveAuthorizedKeyFile(keys ...*PublicKey) error {
 			return err
 		}
 	}
+	QueueKeyReplication()
+	return nil
+}
+
+// sshKeyQuota returns maximum number of SSH keys of user, 0 means unlimited.
+func sshKeyQuota(u *User) int {
+	switch {
+	case u.MaxSSHKeys < 0:
+		return 0
+	case u.MaxSSHKeys > 0:
+		return u.MaxSSHKeys
+	}
+	return setting.MaxSSHKeysPerUser
+}
+
+// checkKeyQuota returns ErrKeyQuotaExceeded if owner cannot add more SSH keys.
+// Users that already have more keys than quota keep them.
+func checkKeyQuota(ownerId int64) error {
+	u, err := GetUserById(ownerId)
+	if err != nil {
+		return err
+	}
+	quota := sshKeyQuota(u)
+	if quota <= 0 {
+		return nil
+	}
+
+	count, err := x.Where("owner_id=?", ownerId).Count(new(PublicKey))
+	if err != nil {
+		return err
+	} else if count >= int64(quota) {
+		return ErrKeyQuotaExceeded{quota}
+	}
+	return nil
+}
+
+// errKeyUsed returns error of adding a key of given owner whose fingerprint
+// is used by existing key.
+func errKeyUsed(existing *PublicKey, ownerId int64) error {
+	if existing.OwnerId == ownerId {
+		return ErrKeyAlreadyExist
+	}
+	owner, err := GetUserById(existing.OwnerId)
+	if err != nil {
+		if err == ErrUserNotExist {
+			return ErrKeyAlreadyExist
+		}
+		return fmt.Errorf("GetUserById: %v", err)
+	}
+	return ErrKeyFingerprintAlreadyUsed{owner.Name}
+}
+
+// AddPublicKey adds new public key to database and authorized_keys file,
+// addition is recorded in security log as done by actor.
+func AddPublicKey(key *PublicKey, actor SecurityActor) error {
+	if err := addPublicKey(key, true, actor); err != nil {
+		return err
+	}
+	runKeyChangeHook(KEY_EVENT_ADD, key)
 	return nil
 }
 
-// AddPublicKey adds new public key to database and authorized_keys file.
-func AddPublicKey(key *PublicKey) (err error) {
+// addPublicKey adds new public key to database, and to authorized_keys file
+// if saveFile is true. Callers that add many keys at once can rewrite the file
+// once at the end instead.
+func addPublicKey(key *PublicKey, saveFile bool, actor SecurityActor) (err error) {
 	has, err := x.Get(key)
 	if err != nil {
 		return err
@@ -287,6 +555,16 @@ func AddPublicKey(key *PublicKey) (err error) {
 		return ErrKeyAlreadyExist
 	}
 
+	if err = checkKeyQuota(key.OwnerId); err != nil {
+		return err
+	}
+
+	if used, err := isKeyNameUsed(key.OwnerId, 0, key.Name); err != nil {
+		return err
+	} else if used {
+		return ErrKeyNameAlreadyUsed{key.OwnerId, key.Name}
+	}
+
 	// Calculate fingerprint.
 	tmpPath := strings.Replace(path.Join(os.TempDir(), fmt.Sprintf("%d", time.Now().Nanosecond()),
 		"id_rsa.pub"), "\\", "/", -1)
@@ -300,23 +578,51 @@ func AddPublicKey(key *PublicKey) (err error) {
 	} else if len(stdout) < 2 {
 		return errors.New("not enough output for calculating fingerprint: " + stdout)
 	}
-	key.Fingerprint = strings.Split(stdout, " ")[1]
-	if has, err := x.Get(&PublicKey{Fingerprint: key.Fingerprint}); err == nil && has {
-		return ErrKeyAlreadyExist
+	fields := strings.Split(strings.TrimSpace(stdout), " ")
+	key.Fingerprint = fields[1]
+	key.Size = com.StrTo(fields[0]).MustInt()
+	if len(fields) > 2 {
+		key.Type = strings.Trim(fields[len(fields)-1], "()")
 	}
-
-	// Save SSH key.
-	if _, err = x.Insert(key); err != nil {
+	existing := &PublicKey{Fingerprint: key.Fingerprint}
+	if has, err := x.Get(existing); err != nil {
 		return err
-	} else if err = saveAuthorizedKeyFile(key); err != nil {
-		// Roll back.
-		if _, err2 := x.Delete(key); err2 != nil {
-			return err2
-		}
+	} else if has {
+		return errKeyUsed(existing, key.OwnerId)
+	}
+	if key.FingerprintSha256, key.FingerprintMd5, err = keyFingerprints(key.Content); err != nil {
+		return fmt.Errorf("keyFingerprints: %v", err)
+	}
+
+	// Save SSH key, unusable key is written to authorized_keys file after verification.
+	key.VerifyToken = base.GetRandomString(40)
+	key.IsPending = setting.RequireSSHKeyApproval
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
 		return err
 	}
 
-	return nil
+	if _, err = sess.Insert(key); err != nil {
+		return err
+	} else if err = logKeyOperation(sess, key, SECURITY_OP_KEY_ADD, actor); err != nil {
+		return err
+	}
+	if key.IsPending {
+		// Admins are notified however the key has been added.
+		if _, err = sess.Insert(&KeyApprovalNotifyTask{KeyId: key.Id}); err != nil {
+			return fmt.Errorf("queue approval notification: %v", err)
+		}
+	}
+	// Writing authorized_keys file has to be the last operation
+	// because it cannot be rolled back.
+	if key.IsUsable() && saveFile {
+		if err = saveAuthorizedKeyFile(key); err != nil {
+			return err
+		}
+	}
+	return sess.Commit()
 }
 
 // GetPublicKeyById returns public key by given ID.
@@ -331,21 +637,243 @@ func GetPublicKeyById(keyId int64) (*PublicKey, error) {
 	return key, nil
 }
 
-// ListPublicKeys returns a list of public keys belongs to given user.
-func ListPublicKeys(uid int64) ([]*PublicKey, error) {
-	keys := make([]*PublicKey, 0, 5)
-	err := x.Where("owner_id=?", uid).Find(&keys)
+// keyFingerprints returns SHA256 and MD5 fingerprints of given public key content
+// in formats printed by OpenSSH.
+func keyFingerprints(content string) (sha256Fingerprint, md5Fingerprint string, err error) {
+	fields := strings.Fields(content)
+	if len(fields) < 2 {
+		return "", "", errors.New("Invalid key format")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", "", err
+	}
+
+	sha256Sum := sha256.Sum256(blob)
+	md5Sum := md5.Sum(blob)
+	hexes := make([]string, len(md5Sum))
+	for i, b := range md5Sum {
+		hexes[i] = fmt.Sprintf("%02x", b)
+	}
+	return "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(sha256Sum[:]), "="), strings.Join(hexes, ":"), nil
+}
+
+// SearchPublicKeyByFingerprint returns public key by given fingerprint in either
+// "SHA256:<base64>" or MD5 format, where MD5 format may have a "MD5:" prefix.
+func SearchPublicKeyByFingerprint(fingerprint string) (*PublicKey, error) {
+	fingerprint = strings.TrimSpace(fingerprint)
+
+	var col string
+	switch {
+	case strings.HasPrefix(fingerprint, "SHA256:"):
+		// OpenSSH prints fingerprints without padding, which may or may not be given.
+		encoded := strings.TrimRight(fingerprint[7:], "=")
+		if n := len(encoded) % 4; n > 0 {
+			encoded += strings.Repeat("=", 4-n)
+		}
+		sum, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, ErrInvalidFingerprint
+		}
+		fingerprint = "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(sum), "=")
+		col = "fingerprint_sha256"
+	default:
+		fingerprint = strings.ToLower(strings.TrimPrefix(fingerprint, "MD5:"))
+		hexes := strings.Split(fingerprint, ":")
+		if len(hexes) != md5.Size {
+			return nil, ErrInvalidFingerprint
+		}
+		for _, h := range hexes {
+			if len(h) != 2 || strings.Trim(h, "0123456789abcdef") != "" {
+				return nil, ErrInvalidFingerprint
+			}
+		}
+		col = "fingerprint_md5"
+	}
+
+	key := new(PublicKey)
+	has, err := x.Where(col+"=?", fingerprint).Get(key)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrKeyNotExist
+	}
+	return key, nil
+}
+
+// GetPublicKeyByFingerprint returns public key by given fingerprint.
+func GetPublicKeyByFingerprint(fingerprint string) (*PublicKey, error) {
+	key := new(PublicKey)
+	has, err := x.Where("fingerprint=?", fingerprint).Get(key)
 	if err != nil {
 		return nil, err
+	} else if !has {
+		return nil, ErrKeyNotExist
+	}
+	return key, nil
+}
+
+const PUBLIC_KEY_PAGE_SIZE = 30
+
+const (
+	KEY_ORDER_CREATED   = "created"
+	KEY_ORDER_LAST_USED = "last_used"
+)
+
+// PublicKeyListOptions represents options of listing public keys of a user.
+type PublicKeyListOptions struct {
+	Type    string // Only keys of given type, e.g. "RSA", when not empty.
+	OrderBy string // KEY_ORDER_CREATED (default) or KEY_ORDER_LAST_USED.
+}
+
+// publicKeysCond returns condition of public keys of given user filtered by given options.
+func publicKeysCond(uid int64, opts *PublicKeyListOptions) (string, []interface{}) {
+	cond := "owner_id=?"
+	args := []interface{}{uid}
+	if opts != nil && len(opts.Type) > 0 {
+		cond += " AND type=?"
+		args = append(args, strings.ToUpper(opts.Type))
+	}
+	return cond, args
+}
+
+// CountPublicKeys returns number of public keys of given user filtered by given options.
+func CountPublicKeys(uid int64, opts *PublicKeyListOptions) (int64, error) {
+	cond, args := publicKeysCond(uid, opts)
+	return x.Where(cond, args...).Count(new(PublicKey))
+}
+
+// ListPublicKeysPaged returns a page of public keys of given user filtered
+// and ordered by given options.
+func ListPublicKeysPaged(uid int64, page, pageSize int, opts *PublicKeyListOptions) ([]*PublicKey, error) {
+	if page < 1 {
+		page = 1
+	}
+	cond, args := publicKeysCond(uid, opts)
+	sess := x.Where(cond, args...)
+	if opts != nil && opts.OrderBy == KEY_ORDER_LAST_USED {
+		sess = sess.Desc("updated")
+	} else {
+		sess = sess.Asc("created")
 	}
 
+	keys := make([]*PublicKey, 0, pageSize)
+	if err := sess.Asc("id").Limit(pageSize, (page-1)*pageSize).Find(&keys); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
 	for _, key := range keys {
 		key.HasUsed = key.Updated.After(key.Created)
-		key.HasRecentActivity = key.Updated.Add(7 * 24 * time.Hour).After(time.Now())
+		key.HasRecentActivity = keyHasRecentActivity(key.Updated, now)
 	}
 	return keys, nil
 }
 
+// IteratePublicKeys calls fn with each public key of given user,
+// keys are read one by one so memory usage does not grow with number of keys.
+func IteratePublicKeys(uid int64, fn func(*PublicKey) error) error {
+	return x.Where("owner_id=?", uid).Asc("id").Iterate(new(PublicKey),
+		func(idx int, bean interface{}) error {
+			return fn(bean.(*PublicKey))
+		})
+}
+
+// HasPublicKeyBelowPolicy returns true if any enabled public key of given user
+// does not meet current key policy.
+func HasPublicKeyBelowPolicy(uid int64) (bool, error) {
+	return x.Where("owner_id=? AND below_policy=? AND is_disabled=?", uid, true, false).Get(new(PublicKey))
+}
+
+// usablePublicKeysCond returns condition of public keys of given user that can be used
+// to access repositories, keys are filtered by exact fingerprint when it is not empty.
+func usablePublicKeysCond(uid int64, fingerprint string) (string, []interface{}) {
+	cond := "owner_id=? AND is_disabled=? AND is_pending=?"
+	args := []interface{}{uid, false, false}
+	if setting.RequireSSHKeyVerify {
+		cond += " AND verified=?"
+		args = append(args, true)
+	}
+	if len(fingerprint) > 0 {
+		cond += " AND fingerprint=?"
+		args = append(args, fingerprint)
+	}
+	return cond, args
+}
+
+// ListUsablePublicKeys returns a page of public keys of given user that can be used
+// to access repositories, and total number of such keys. Keys are filtered by exact
+// fingerprint when it is not empty.
+func ListUsablePublicKeys(uid int64, fingerprint string, page, limit int) ([]*PublicKey, int64, error) {
+	cond, args := usablePublicKeysCond(uid, fingerprint)
+	total, err := x.Where(cond, args...).Count(new(PublicKey))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys := make([]*PublicKey, 0, limit)
+	return keys, total, x.Where(cond, args...).Asc("id").Limit(limit, (page-1)*limit).Find(&keys)
+}
+
+// IterateUsablePublicKeys calls fn with each usable public key of given user,
+// keys are read one by one so memory usage does not grow with number of keys.
+func IterateUsablePublicKeys(uid int64, fn func(*PublicKey) error) error {
+	cond, args := usablePublicKeysCond(uid, "")
+	return x.Where(cond, args...).Asc("id").Iterate(new(PublicKey),
+		func(idx int, bean interface{}) error {
+			return fn(bean.(*PublicKey))
+		})
+}
+
+// PublicKeysStamp returns a value that changes whenever a public key of given user
+// is added, deleted, modified or used, it only takes one aggregate query.
+func PublicKeysStamp(uid int64) (string, error) {
+	results, err := x.Query("SELECT COUNT(*) AS num, MAX(id) AS max_id, SUM(revision) AS revisions, MAX(updated) AS last_used FROM `public_key` WHERE owner_id=?", uid)
+	if err != nil {
+		return "", err
+	} else if len(results) == 0 {
+		return "", nil
+	}
+	r := results[0]
+	return fmt.Sprintf("%s-%s-%s-%s", r["num"], r["max_id"], r["revisions"], r["last_used"]), nil
+}
+
+// PublicKeyExport represents a public key in exported JSON format.
+type PublicKeyExport struct {
+	Name        string     `json:"name"`
+	Fingerprint string     `json:"fingerprint"`
+	Content     string     `json:"content"`
+	Created     time.Time  `json:"created"`
+	LastUsed    *time.Time `json:"last_used"`
+}
+
+// ExportPublicKeys writes all public keys of given user to w as openssh lines,
+// or as a JSON array when asJSON is true. Keys are read and written one by one
+// so memory usage does not grow with number of keys.
+func ExportPublicKeys(w io.Writer, uid int64, asJSON bool) error {
+	if asJSON {
+		return writeJSONArray(w, new(PublicKey), "owner_id=?", []interface{}{uid},
+			func(bean interface{}) interface{} {
+				key := bean.(*PublicKey)
+				export := &PublicKeyExport{
+					Name:        key.Name,
+					Fingerprint: key.Fingerprint,
+					Content:     strings.TrimSpace(key.Content),
+					Created:     key.Created,
+				}
+				if key.Updated.After(key.Created) {
+					export.LastUsed = &key.Updated
+				}
+				return export
+			})
+	}
+
+	return IteratePublicKeys(uid, func(key *PublicKey) error {
+		_, err := io.WriteString(w, strings.TrimSpace(key.Content)+"\n")
+		return err
+	})
+}
+
 // rewriteAuthorizedKeys finds and deletes corresponding line in authorized_keys file.
 func rewriteAuthorizedKeys(key *PublicKey, p, tmpP string) error {
 	sshOpLocker.Lock()
@@ -401,63 +929,635 @@ func rewriteAuthorizedKeys(key *PublicKey, p, tmpP string) error {
 
 // UpdatePublicKey updates given public key.
 func UpdatePublicKey(key *PublicKey) error {
+	key.Revision++
 	_, err := x.Id(key.Id).AllCols().Update(key)
 	return err
 }
 
-// DeletePublicKey deletes SSH key information both in database and authorized_keys file.
-func DeletePublicKey(key *PublicKey) error {
-	has, err := x.Get(key)
+const _KEY_RECENT_ACTIVITY_WINDOW = 7 * 24 * time.Hour
+
+// keyHasRecentActivity returns true if key was last used within the recent activity window.
+func keyHasRecentActivity(updated, now time.Time) bool {
+	return updated.Add(_KEY_RECENT_ACTIVITY_WINDOW).After(now)
+}
+
+// keyActivityNeedsUpdate returns true if last recorded activity is old enough
+// to be written again. Interval is capped to keep recent activity accurate.
+func keyActivityNeedsUpdate(updated, now time.Time, interval time.Duration) bool {
+	if interval > _KEY_RECENT_ACTIVITY_WINDOW/7 {
+		interval = _KEY_RECENT_ACTIVITY_WINDOW / 7
+	}
+	return !updated.Add(interval).After(now)
+}
+
+// UpdateKeyActivity records usage of given key, it only writes to database
+// when recorded timestamp is older than the configured interval.
+func UpdateKeyActivity(keyID int64) error {
+	key, err := GetPublicKeyById(keyID)
 	if err != nil {
 		return err
-	} else if !has {
+	}
+
+	now := time.Now()
+	if !keyActivityNeedsUpdate(key.Updated, now, setting.SSHKeyActivityInterval) {
+		return nil
+	}
+	_, err = x.Id(keyID).Cols("updated").Update(&PublicKey{Updated: now})
+	return err
+}
+
+// PublicKeySearchOptions represents filters of searching public keys,
+// zero value of a field means no filter on it.
+type PublicKeySearchOptions struct {
+	OwnerId           int64
+	Type              string
+	MinSize           int
+	MaxSize           int
+	FingerprintPrefix string
+	UsedBefore        time.Time
+	UsedAfter         time.Time
+	OnlyPending       bool
+	Page              int
+	PageSize          int // All matched keys are returned when it is zero.
+}
+
+func (opts *PublicKeySearchOptions) session() *xorm.Session {
+	sess := x.Asc("id")
+	if opts.OwnerId > 0 {
+		sess.And("owner_id=?", opts.OwnerId)
+	}
+	if len(opts.Type) > 0 {
+		sess.And("type=?", strings.ToUpper(opts.Type))
+	}
+	if opts.MinSize > 0 {
+		sess.And("size>=?", opts.MinSize)
+	}
+	if opts.MaxSize > 0 {
+		sess.And("size<=?", opts.MaxSize)
+	}
+	if len(opts.FingerprintPrefix) > 0 {
+		sess.And("fingerprint LIKE ?", opts.FingerprintPrefix+"%")
+	}
+	if !opts.UsedBefore.IsZero() {
+		sess.And("updated<?", opts.UsedBefore)
+	}
+	if !opts.UsedAfter.IsZero() {
+		sess.And("updated>?", opts.UsedAfter)
+	}
+	if opts.OnlyPending {
+		sess.And("is_pending=?", true)
+	}
+	return sess
+}
+
+// SearchPublicKeys returns public keys that match given options
+// and total number of matched keys.
+func SearchPublicKeys(opts *PublicKeySearchOptions) ([]*PublicKey, int64, error) {
+	total, err := opts.session().Count(new(PublicKey))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sess := opts.session()
+	if opts.PageSize > 0 {
+		if opts.Page < 1 {
+			opts.Page = 1
+		}
+		sess.Limit(opts.PageSize, (opts.Page-1)*opts.PageSize)
+	}
+	keys := make([]*PublicKey, 0, opts.PageSize)
+	if err = sess.Find(&keys); err != nil {
+		return nil, 0, err
+	}
+	return keys, total, nil
+}
+
+// isKeyNameUsed returns true if the name has been used case-insensitively
+// by any other key than given one of the same owner.
+func isKeyNameUsed(ownerId, keyId int64, name string) (bool, error) {
+	return x.Where("owner_id=? AND LOWER(name)=? AND id!=?", ownerId, strings.ToLower(name), keyId).Get(new(PublicKey))
+}
+
+// uniqueKeyName returns first unused key name of owner in "<prefix>-<n>" format,
+// n starts from given number.
+func uniqueKeyName(ownerId int64, prefix string, n int) (string, error) {
+	for ; ; n++ {
+		name := fmt.Sprintf("%s-%d", prefix, n)
+		if used, err := isKeyNameUsed(ownerId, 0, name); err != nil {
+			return "", err
+		} else if !used {
+			return name, nil
+		}
+	}
+}
+
+// UpdatePublicKeyName changes name of public key that belongs to given owner,
+// content of authorized_keys file is not affected.
+func UpdatePublicKeyName(ownerId, keyId int64, newName string) error {
+	key, err := GetPublicKeyById(keyId)
+	if err != nil {
+		return err
+	} else if key.OwnerId != ownerId {
+		return ErrKeyNotExist
+	} else if key.IsExternal() {
+		return ErrKeyExternallyManaged
+	} else if key.Name == newName {
+		return nil
+	}
+
+	if used, err := isKeyNameUsed(ownerId, keyId, newName); err != nil {
+		return err
+	} else if used {
+		return ErrKeyNameAlreadyUsed{ownerId, newName}
+	}
+
+	key.Name = newName
+	key.Revision++
+	_, err = x.Id(keyId).Cols("name", "revision").Update(key)
+	return err
+}
+
+// UpdatePublicKeyMode changes access mode of public key that belongs to given owner,
+// it is enforced by serv so authorized_keys file is not affected.
+func UpdatePublicKeyMode(ownerId, keyId int64, mode KeyAccessMode) error {
+	if _, err := ParseKeyAccessMode(string(mode)); err != nil {
+		return err
+	}
+
+	key, err := GetPublicKeyById(keyId)
+	if err != nil {
+		return err
+	} else if key.OwnerId != ownerId {
 		return ErrKeyNotExist
+	} else if key.AccessMode() == mode {
+		return nil
+	}
+
+	key.Mode = mode
+	key.Revision++
+	_, err = x.Id(keyId).Cols("mode", "revision").Update(key)
+	return err
+}
+
+// SetPublicKeyDisabled disables or enables public key
+// and rewrites authorized_keys file accordingly.
+// Nothing is changed when key is already in given state.
+func SetPublicKeyDisabled(key *PublicKey, disabled bool, actor SecurityActor) (err error) {
+	if key.IsDisabled == disabled {
+		return nil
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	key.IsDisabled = disabled
+	key.Revision++
+	op := SECURITY_OP_KEY_ENABLE
+	if disabled {
+		op = SECURITY_OP_KEY_DISABLE
+	}
+	if _, err = sess.Id(key.Id).Cols("is_disabled", "revision").Update(key); err != nil {
+		return err
+	} else if err = logKeyOperation(sess, key, op, actor); err != nil {
+		return err
+	}
+	if disabled {
+		err = removeAuthorizedKey(key)
+	} else if key.IsUsable() {
+		err = saveAuthorizedKeyFile(key)
+	}
+	if err != nil {
+		return err
+	}
+	return sess.Commit()
+}
+
+// ApprovePublicKey activates pending public key and writes it to authorized_keys file.
+func ApprovePublicKey(key *PublicKey, actor SecurityActor) (err error) {
+	if !key.IsPending {
+		return nil
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	key.IsPending = false
+	key.Revision++
+	if _, err = sess.Id(key.Id).Cols("is_pending", "revision").Update(key); err != nil {
+		return err
+	} else if err = logKeyOperation(sess, key, SECURITY_OP_KEY_APPROVE, actor); err != nil {
+		return err
+	}
+	if key.IsUsable() {
+		if err = saveAuthorizedKeyFile(key); err != nil {
+			return err
+		}
+	}
+	return sess.Commit()
+}
+
+// RejectPublicKey deletes public key that is waiting for approval,
+// keys that have been approved must be deleted instead.
+func RejectPublicKey(key *PublicKey, actor SecurityActor) error {
+	if !key.IsPending {
+		return ErrKeyNotPending
+	}
+	return deletePublicKey(key, SECURITY_OP_KEY_REJECT, actor)
+}
+
+// KeyApprovalNotifyTask represents a public key waiting for approval
+// that admins have not been notified about yet. Tasks are queued when
+// keys are added and notified by web server.
+type KeyApprovalNotifyTask struct {
+	Id      int64
+	KeyId   int64
+	Created time.Time `xorm:"CREATED"`
+}
+
+// GetKeyApprovalNotifyTasks returns at most given number of queued notifications, oldest first.
+func GetKeyApprovalNotifyTasks(limit int) ([]*KeyApprovalNotifyTask, error) {
+	tasks := make([]*KeyApprovalNotifyTask, 0, limit)
+	return tasks, x.Asc("id").Limit(limit).Find(&tasks)
+}
+
+// DeleteKeyApprovalNotifyTask removes queued notification that has been sent.
+func DeleteKeyApprovalNotifyTask(id int64) error {
+	_, err := x.Id(id).Delete(new(KeyApprovalNotifyTask))
+	return err
+}
+
+// CountPendingPublicKeys returns number of public keys waiting for approval.
+func CountPendingPublicKeys() int64 {
+	count, _ := x.Where("is_pending=?", true).Count(new(PublicKey))
+	return count
+}
+
+// VerifyPublicKey checks signature of verification token made by private key
+// of given public key, and marks key as verified when succeed.
+func VerifyPublicKey(ownerId, keyId int64, signature string) error {
+	key, err := GetPublicKeyById(keyId)
+	if err != nil {
+		return err
+	} else if key.OwnerId != ownerId {
+		return ErrKeyAccessDenied
+	} else if key.Verified {
+		return nil
+	}
+
+	tmpDir, err := ioutil.TempDir("", "gogs-key-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	signersPath := filepath.Join(tmpDir, "allowed_signers")
+	if err = ioutil.WriteFile(signersPath, []byte(_KEY_VERIFY_PRINCIPAL+" "+key.OmitEmail()+"\n"), 0600); err != nil {
+		return err
+	}
+	sigPath := filepath.Join(tmpDir, "signature")
+	if err = ioutil.WriteFile(sigPath, []byte(strings.Replace(strings.TrimSpace(signature), "\r", "", -1)+"\n"), 0600); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", signersPath,
+		"-I", _KEY_VERIFY_PRINCIPAL, "-n", _KEY_VERIFY_NAMESPACE, "-s", sigPath)
+	cmd.Stdin = strings.NewReader(key.VerifyToken)
+	if stdout, err := cmd.CombinedOutput(); err != nil {
+		log.Debug("VerifyPublicKey(%d): %v - %s", key.Id, err, stdout)
+		return ErrKeyVerifyFailed
+	}
+
+	key.Verified = true
+	key.VerifyToken = ""
+	key.Revision++
+	if _, err = x.Id(key.Id).Cols("verified", "verify_token", "revision").Update(key); err != nil {
+		return err
+	}
+
+	// Key has not been written to authorized_keys file before verification.
+	if setting.RequireSSHKeyVerify && key.IsUsable() {
+		return saveAuthorizedKeyFile(key)
+	}
+	return nil
+}
+
+// DeleteExpiredUnverifiedPublicKeys deletes unverified public keys
+// that have been added for longer than configured days, keys only
+// expire when verification is required.
+func DeleteExpiredUnverifiedPublicKeys() {
+	if !setting.RequireSSHKeyVerify || setting.UnverifiedSSHKeyExpire <= 0 {
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -setting.UnverifiedSSHKeyExpire)
+	keys := make([]*PublicKey, 0, 10)
+	if err := x.Where("verified=? AND created<?", false, before).Find(&keys); err != nil {
+		log.Error(4, "DeleteExpiredUnverifiedPublicKeys: %v", err)
+		return
+	} else if len(keys) == 0 {
+		return
+	}
+
+	for _, key := range keys {
+		if err := deleteExpiredPublicKey(key); err != nil {
+			log.Error(4, "DeleteExpiredUnverifiedPublicKeys[%d]: %v", key.Id, err)
+		}
+	}
+
+	if err := RewriteAllPublicKeys(); err != nil {
+		log.Error(4, "RewriteAllPublicKeys: %v", err)
+	}
+}
+
+// deleteExpiredPublicKey deletes given unverified key from database,
+// authorized_keys file is rewritten by caller once for all keys.
+func deleteExpiredPublicKey(key *PublicKey) (err error) {
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if _, err = sess.Id(key.Id).Delete(new(PublicKey)); err != nil {
+		return err
+	} else if err = logKeyOperation(sess, key, SECURITY_OP_KEY_EXPIRE, SecurityActorSystem); err != nil {
+		return err
+	}
+	return sess.Commit()
+}
+
+// CheckPublicKeysPolicy evaluates public keys that are new or have been checked
+// against an older policy, and flags keys no longer meeting current policy.
+func CheckPublicKeysPolicy() {
+	version := KeyPolicyVersion()
+	keys := make([]*PublicKey, 0, 10)
+	if err := x.Where("policy_version IS NULL OR policy_version!=?", version).Find(&keys); err != nil {
+		log.Error(4, "CheckPublicKeysPolicy: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		belowPolicy := !key.MeetsPolicy()
+		if belowPolicy && !key.BelowPolicy {
+			key.BelowPolicySince = now
+		}
+		key.BelowPolicy = belowPolicy
+		key.PolicyVersion = version
+		key.Revision++
+		if _, err := x.Id(key.Id).Cols("below_policy", "below_policy_since", "policy_version", "revision").Update(key); err != nil {
+			log.Error(4, "CheckPublicKeysPolicy[%d]: %v", key.Id, err)
+		}
+	}
+}
+
+// DisableExpiredBelowPolicyPublicKeys disables public keys that have been below policy
+// for longer than configured grace period, and returns keys have been disabled,
+// which may be partial when error occurs.
+func DisableExpiredBelowPolicyPublicKeys() ([]*PublicKey, error) {
+	if setting.SSHKeyPolicyGraceDays <= 0 {
+		return nil, nil
+	}
+
+	before := time.Now().AddDate(0, 0, -setting.SSHKeyPolicyGraceDays)
+	keys := make([]*PublicKey, 0, 10)
+	if err := x.Where("below_policy=? AND is_disabled=? AND below_policy_since<?", true, false, before).
+		Find(&keys); err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		if err := SetPublicKeyDisabled(key, true, SecurityActorSystem); err != nil {
+			return keys[:i], fmt.Errorf("SetPublicKeyDisabled[%d]: %v", key.Id, err)
+		}
 	}
+	return keys, nil
+}
 
-	if _, err = x.Delete(key); err != nil {
+// removeAuthorizedKey removes line of given key from authorized_keys file.
+func removeAuthorizedKey(key *PublicKey) error {
+	if !isAuthorizedKeysManaged() {
+		return nil
+	}
+
+	// Temporary file must be unique, web and CLI may rewrite at the same time.
+	tmpFile, err := ioutil.TempFile(SSHPath, "authorized_keys.tmp")
+	if err != nil {
 		return err
 	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
 
 	fpath := filepath.Join(SSHPath, "authorized_keys")
-	tmpPath := filepath.Join(SSHPath, "authorized_keys.tmp")
 	if err = rewriteAuthorizedKeys(key, fpath, tmpPath); err != nil {
 		return err
 	} else if err = os.Remove(fpath); err != nil {
 		return err
+	} else if err = os.Rename(tmpPath, fpath); err != nil {
+		return err
+	}
+	QueueKeyReplication()
+	return nil
+}
+
+// deletePublicKey deletes given key both in database and authorized_keys file,
+// deletion is recorded in security log as given operation done by actor.
+func deletePublicKey(key *PublicKey, op string, actor SecurityActor) (err error) {
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if _, err = sess.Id(key.Id).Delete(new(PublicKey)); err != nil {
+		return err
+	} else if err = logKeyOperation(sess, key, op, actor); err != nil {
+		return err
+	} else if err = removeAuthorizedKey(key); err != nil {
+		return err
+	} else if err = sess.Commit(); err != nil {
+		return err
+	}
+	runKeyChangeHook(KEY_EVENT_DELETE, key)
+	return nil
+}
+
+// DeletePublicKey deletes SSH key that belongs to given owner
+// both in database and authorized_keys file.
+func DeletePublicKey(ownerId, keyId int64, actor SecurityActor) error {
+	key, err := GetPublicKeyById(keyId)
+	if err != nil {
+		return err
+	} else if key.OwnerId != ownerId {
+		return ErrKeyAccessDenied
 	}
-	return os.Rename(tmpPath, fpath)
+	return deletePublicKey(key, SECURITY_OP_KEY_DELETE, actor)
+}
+
+// DeletePublicKeyAdmin deletes SSH key regardless of its owner.
+func DeletePublicKeyAdmin(keyId int64, actor SecurityActor) error {
+	key, err := GetPublicKeyById(keyId)
+	if err != nil {
+		return err
+	}
+	return deletePublicKey(key, SECURITY_OP_KEY_DELETE, actor)
+}
+
+// DeletePublicKeysByUser deletes all public keys of given user
+// and rewrites authorized_keys file only once.
+func DeletePublicKeysByUser(uid int64, actor SecurityActor) error {
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err := sess.Begin(); err != nil {
+		return err
+	}
+
+	u, err := getUserById(sess, uid)
+	if err != nil {
+		return err
+	}
+	keys, err := deletePublicKeysByUser(sess, u.Id, u.Name, actor)
+	if err != nil {
+		return err
+	} else if err = sess.Commit(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		runKeyChangeHook(KEY_EVENT_DELETE, key)
+	}
+	runKeyChangeHook(KEY_EVENT_REWRITE, nil)
+	return nil
+}
+
+// deletePublicKeysByUser must be called within a transaction,
+// so database changes can be rolled back when failed to update authorized_keys file.
+// Name of owner is given because owner may have been deleted in same transaction.
+// It returns keys have been deleted.
+func deletePublicKeysByUser(e Engine, uid int64, ownerName string, actor SecurityActor) ([]*PublicKey, error) {
+	keys := make([]*PublicKey, 0, 5)
+	if err := e.Where("owner_id=?", uid).Find(&keys); err != nil {
+		return nil, err
+	} else if _, err = e.Delete(&PublicKey{OwnerId: uid}); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := logKeyOperationOfOwner(e, key, ownerName, SECURITY_OP_KEY_DELETE, actor); err != nil {
+			return nil, err
+		}
+	}
+	_, err := rewriteAllPublicKeys(e)
+	return keys, err
 }
 
 // RewriteAllPublicKeys removes any authorized key and rewrite all keys from database again.
 func RewriteAllPublicKeys() error {
+	if _, err := rewriteAllPublicKeys(x); err != nil {
+		return err
+	}
+	runKeyChangeHook(KEY_EVENT_REWRITE, nil)
+	return nil
+}
+
+// PublicKeysRewriteResult represents outcome of a full rewrite of authorized_keys file.
+type PublicKeysRewriteResult struct {
+	NumKeys  int
+	Duration time.Duration
+}
+
+var keysRewriteStatus = struct {
+	sync.Mutex
+	running   bool
+	startedAt time.Time
+}{}
+
+// PublicKeysRewriteStatus returns whether a rewrite started by RewriteAllPublicKeysOnce
+// is still running and when it was started.
+func PublicKeysRewriteStatus() (bool, time.Time) {
+	keysRewriteStatus.Lock()
+	defer keysRewriteStatus.Unlock()
+	return keysRewriteStatus.running, keysRewriteStatus.startedAt
+}
+
+// RewriteAllPublicKeysOnce rewrites authorized_keys file and reports number of keys
+// written and time it took. It returns ErrKeysRewriteInProgress without doing anything
+// when another rewrite started by this function has not finished yet.
+func RewriteAllPublicKeysOnce() (*PublicKeysRewriteResult, error) {
+	keysRewriteStatus.Lock()
+	if keysRewriteStatus.running {
+		keysRewriteStatus.Unlock()
+		return nil, ErrKeysRewriteInProgress
+	}
+	start := time.Now()
+	keysRewriteStatus.running = true
+	keysRewriteStatus.startedAt = start
+	keysRewriteStatus.Unlock()
+
+	defer func() {
+		keysRewriteStatus.Lock()
+		keysRewriteStatus.running = false
+		keysRewriteStatus.Unlock()
+	}()
+
+	num, err := rewriteAllPublicKeys(x)
+	if err != nil {
+		return nil, err
+	}
+	result := &PublicKeysRewriteResult{num, time.Since(start)}
+	runKeyChangeHook(KEY_EVENT_REWRITE, nil)
+	return result, nil
+}
+
+// rewriteAllPublicKeys writes all usable keys to a temporary file and swaps it
+// with authorized_keys file, it returns number of keys written.
+func rewriteAllPublicKeys(e Engine) (int, error) {
+	if !isAuthorizedKeysManaged() {
+		return 0, nil
+	}
+
 	sshOpLocker.Lock()
 	defer sshOpLocker.Unlock()
 
-	tmpPath := filepath.Join(SSHPath, "authorized_keys.tmp")
-	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	// Temporary file must be unique, web and CLI may rewrite at the same time.
+	f, err := ioutil.TempFile(SSHPath, "authorized_keys.tmp")
 	if err != nil {
-		return err
+		return 0, err
 	}
+	tmpPath := f.Name()
 	defer os.Remove(tmpPath)
 
-	err = x.Iterate(new(PublicKey), func(idx int, bean interface{}) (err error) {
-		_, err = f.WriteString((bean.(*PublicKey)).GetAuthorizedString())
+	num := 0
+	err = e.Where("is_disabled=?", false).Iterate(new(PublicKey), func(idx int, bean interface{}) (err error) {
+		key := bean.(*PublicKey)
+		if !key.IsUsable() {
+			return nil
+		}
+		if _, err = f.WriteString(key.GetAuthorizedString()); err == nil {
+			num++
+		}
 		return err
 	})
 	f.Close()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	fpath := filepath.Join(SSHPath, "authorized_keys")
 	if com.IsExist(fpath) {
 		if err = os.Remove(fpath); err != nil {
-			return err
+			return 0, fmt.Errorf("remove old authorized_keys: %v", err)
 		}
 	}
 	if err = os.Rename(tmpPath, fpath); err != nil {
-		return err
+		return 0, fmt.Errorf("swap authorized_keys: %v", err)
 	}
 
-	return nil
+	QueueKeyReplication()
+	return num, nil
 }
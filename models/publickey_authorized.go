@@ -0,0 +1,350 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+)
+
+// Ways to decide owner of keys imported from an authorized_keys file.
+const (
+	AUTHORIZED_KEYS_MAP_BY_USER    = "user"    // All keys belong to one given user.
+	AUTHORIZED_KEYS_MAP_BY_COMMENT = "comment" // Comment of key is e-mail or name of owner.
+)
+
+// Additional status of a line in result of importing authorized_keys file.
+const (
+	KEY_IMPORT_MANAGED    = "managed"    // Line has been written by Gogs.
+	KEY_IMPORT_WOULD_ADD  = "would_add"  // Key would be added if it was not a dry run.
+	KEY_IMPORT_RESTRICTED = "restricted" // Line has options that would be lost, only imported when forced.
+)
+
+// AuthorizedKeysImportOptions represents options of importing an authorized_keys file.
+type AuthorizedKeysImportOptions struct {
+	MapBy    string // One of AUTHORIZED_KEYS_MAP_BY_*.
+	UserName string // Owner of all keys when mapped by user.
+	DryRun   bool   // Only report what would be done, neither database nor authorized_keys file is changed.
+	Force    bool   // Import lines with options as well, their restrictions are replaced by the ones of Gogs.
+	Actor    SecurityActor
+}
+
+// AuthorizedKeyImportResult represents what happened to one line of imported authorized_keys file.
+type AuthorizedKeyImportResult struct {
+	Line     int // Line number in file, starts from 1.
+	UserName string
+	Title    string
+	Options  string // Options of line, they are not kept since Gogs writes its own ones.
+	Status   string
+	Error    string // Reason of line that has not been imported.
+}
+
+// AuthorizedKeysImportReport represents result of importing an authorized_keys file.
+type AuthorizedKeysImportReport struct {
+	Results []*AuthorizedKeyImportResult
+	Added   int
+	Kept    int    // Number of lines of authorized_keys file that were not imported and have been kept.
+	Backup  string // Path of copy of authorized_keys file made before it was rewritten.
+}
+
+// parseAuthorizedKeysLine splits a line of authorized_keys file into its options,
+// key (type and base64 encoded blob) and comment.
+func parseAuthorizedKeysLine(line string) (options, key, comment string, err error) {
+	line = strings.TrimSpace(line)
+
+	// Options never start with a key type, and may contain quoted spaces.
+	if !isKeyTypeField(line) {
+		inQuote := false
+		end := -1
+		for i := 0; i < len(line) && end == -1; i++ {
+			switch c := line[i]; {
+			case c == '\\' && inQuote:
+				i++
+			case c == '"':
+				inQuote = !inQuote
+			case (c == ' ' || c == '\t') && !inQuote:
+				end = i
+			}
+		}
+		if end == -1 {
+			return "", "", "", errors.New("no key after options")
+		}
+		options, line = line[:end], strings.TrimSpace(line[end:])
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", "", "", errors.New("missing key type or key")
+	}
+	if len(fields) > 2 {
+		comment = strings.Join(fields[2:], " ")
+	}
+	return options, fields[0] + " " + fields[1], comment, nil
+}
+
+// isKeyTypeField returns true if line starts with a key type rather than options.
+func isKeyTypeField(line string) bool {
+	for _, prefix := range []string{"ssh-", "ecdsa-", "sk-"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isManagedAuthorizedKeysLine returns true if line has been written by Gogs.
+func isManagedAuthorizedKeysLine(options string) bool {
+	return strings.Contains(options, "command=") && strings.Contains(options, " serv key-")
+}
+
+// authorizedKeyOwner returns user who owns key of a line according to options.
+func authorizedKeyOwner(opts AuthorizedKeysImportOptions, comment string) (*User, error) {
+	if opts.MapBy == AUTHORIZED_KEYS_MAP_BY_USER {
+		return GetUserByName(opts.UserName)
+	}
+
+	if len(comment) == 0 {
+		return nil, ErrUserNotExist
+	} else if strings.Contains(comment, "@") {
+		if u, err := GetUserByEmail(comment); err != ErrUserNotExist {
+			return u, err
+		}
+	}
+	return GetUserByName(comment)
+}
+
+// importAuthorizedKeyLine adds key of one line of authorized_keys file to its owner,
+// seen maps fingerprints of keys handled before in same file to their owners.
+func importAuthorizedKeyLine(r *AuthorizedKeyImportResult, opts AuthorizedKeysImportOptions,
+	content, comment string, seen map[string]int64) {
+
+	u, err := authorizedKeyOwner(opts, comment)
+	if err != nil {
+		if err == ErrUserNotExist {
+			r.Status, r.Error = KEY_IMPORT_UNKNOWN_USER, fmt.Sprintf("no user matches comment %q", comment)
+			if opts.MapBy == AUTHORIZED_KEYS_MAP_BY_USER {
+				r.Error = err.Error()
+			}
+		} else {
+			r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+		}
+		return
+	}
+	r.UserName = u.Name
+
+	if content, err = ParseKeyString(content + " " + comment); err != nil {
+		r.Status, r.Error = KEY_IMPORT_INVALID, err.Error()
+		return
+	}
+	if ok, err := CheckPublicKeyString(content); !ok && err != ErrKeyUnableVerify {
+		r.Status, r.Error = KEY_IMPORT_INVALID, err.Error()
+		return
+	}
+
+	fingerprint, _, err := keyFingerprints(content)
+	if err != nil {
+		r.Status, r.Error = KEY_IMPORT_INVALID, err.Error()
+		return
+	}
+	ownerId, has := seen[fingerprint]
+	if !has {
+		existing := &PublicKey{FingerprintSha256: fingerprint}
+		if has, err = x.Get(existing); err != nil {
+			r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+			return
+		}
+		ownerId = existing.OwnerId
+	}
+	if has {
+		if ownerId == u.Id {
+			r.Status = KEY_IMPORT_EXISTS
+		} else {
+			r.Status, r.Error = KEY_IMPORT_DUPLICATE, ErrKeyAlreadyExist.Error()
+		}
+		return
+	}
+	seen[fingerprint] = u.Id
+
+	if r.Title, err = uniqueKeyName(u.Id, "authorized_keys", r.Line); err != nil {
+		r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+		return
+	}
+	if opts.DryRun {
+		r.Status = KEY_IMPORT_WOULD_ADD
+		return
+	}
+
+	key := &PublicKey{
+		OwnerId: u.Id,
+		Name:    r.Title,
+		Content: content,
+	}
+	if err = addPublicKey(key, false, opts.Actor); err != nil {
+		switch {
+		case err == ErrKeyAlreadyExist, IsErrKeyFingerprintAlreadyUsed(err), IsErrKeyNameAlreadyUsed(err):
+			r.Status, r.Error = KEY_IMPORT_DUPLICATE, err.Error()
+		default:
+			r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+		}
+		return
+	}
+	r.Status = KEY_IMPORT_ADDED
+}
+
+// backupAuthorizedKeys copies current authorized_keys file next to it
+// and returns path of the copy, or empty string if there is no such file.
+func backupAuthorizedKeys() (string, error) {
+	fpath := filepath.Join(SSHPath, "authorized_keys")
+	if !com.IsFile(fpath) {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return "", err
+	}
+	backup := fmt.Sprintf("%s.%d.bak", fpath, time.Now().Unix())
+	return backup, ioutil.WriteFile(backup, data, 0600)
+}
+
+// unimportedAuthorizedKeysLines returns lines of authorized_keys file that have neither
+// been written by Gogs nor have a key in imported, which maps keys (type and base64 encoded blob)
+// that are now written by Gogs.
+func unimportedAuthorizedKeysLines(data []byte, imported map[string]bool) []string {
+	lines := make([]string, 0, 10)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			if options, key, _, err := parseAuthorizedKeysLine(line); err == nil &&
+				(isManagedAuthorizedKeysLine(options) || imported[key]) {
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// rewriteAuthorizedKeysKeeping rewrites authorized_keys file and appends given lines
+// of previous file to it, so keys that were not imported still work as before.
+func rewriteAuthorizedKeysKeeping(lines []string) error {
+	if err := RewriteAllPublicKeys(); err != nil {
+		return fmt.Errorf("RewriteAllPublicKeys: %v", err)
+	} else if len(lines) == 0 {
+		return nil
+	}
+
+	sshOpLocker.Lock()
+	defer sshOpLocker.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(SSHPath, "authorized_keys"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+	return err
+}
+
+// ImportAuthorizedKeys adds keys of an authorized_keys file that has not been written
+// by Gogs to their owners, it is the reverse of RewriteAllPublicKeys. Keys are checked
+// the same way as added one by one, and authorized_keys file is rewritten once at the end
+// after making a backup of it. Lines of previous authorized_keys file whose keys are not
+// written by Gogs afterwards are kept at the end of it. Lines with options are not imported
+// unless forced, because Gogs replaces options by its own ones.
+// An error is only returned when file cannot be read or authorized_keys file cannot be
+// rewritten; failures of individual lines are reported in results.
+func ImportAuthorizedKeys(r io.Reader, opts AuthorizedKeysImportOptions) (*AuthorizedKeysImportReport, error) {
+	if opts.MapBy == AUTHORIZED_KEYS_MAP_BY_USER {
+		if _, err := GetUserByName(opts.UserName); err != nil {
+			return nil, fmt.Errorf("GetUserByName(%s): %v", opts.UserName, err)
+		}
+	} else if opts.MapBy != AUTHORIZED_KEYS_MAP_BY_COMMENT {
+		return nil, fmt.Errorf("unknown way to map keys: %s", opts.MapBy)
+	}
+
+	report := &AuthorizedKeysImportReport{
+		Results: make([]*AuthorizedKeyImportResult, 0, 50),
+	}
+	seen := make(map[string]int64)
+	imported := make(map[string]bool)
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return report, fmt.Errorf("read authorized_keys: %v", err)
+		}
+		isEOF := err == io.EOF
+
+		if text = strings.TrimSpace(text); len(text) > 0 && !strings.HasPrefix(text, "#") {
+			res := &AuthorizedKeyImportResult{Line: line}
+			report.Results = append(report.Results, res)
+			if options, content, comment, err := parseAuthorizedKeysLine(text); err != nil {
+				res.Status, res.Error = KEY_IMPORT_INVALID, err.Error()
+			} else if res.Options = options; isManagedAuthorizedKeysLine(options) {
+				res.Status = KEY_IMPORT_MANAGED
+			} else if len(options) > 0 && !opts.Force {
+				res.Status, res.Error = KEY_IMPORT_RESTRICTED, "options would be replaced by the ones of Gogs, force import to accept"
+			} else {
+				importAuthorizedKeyLine(res, opts, content, comment, seen)
+				switch res.Status {
+				case KEY_IMPORT_ADDED:
+					report.Added++
+					fallthrough
+				case KEY_IMPORT_EXISTS:
+					imported[content] = true
+				}
+			}
+		}
+
+		if isEOF {
+			break
+		}
+	}
+
+	if report.Added > 0 {
+		var err error
+		if report.Backup, err = backupAuthorizedKeys(); err != nil {
+			return report, fmt.Errorf("backupAuthorizedKeys: %v", err)
+		}
+
+		var kept []string
+		if len(report.Backup) > 0 {
+			data, err := ioutil.ReadFile(report.Backup)
+			if err != nil {
+				return report, fmt.Errorf("read authorized_keys: %v", err)
+			}
+			kept = unimportedAuthorizedKeysLines(data, imported)
+		}
+		if err = rewriteAuthorizedKeysKeeping(kept); err != nil {
+			return report, err
+		}
+		report.Kept = len(kept)
+	}
+	return report, nil
+}
+
+// WriteAuthorizedKeysImportReport writes results of importing authorized_keys file in CSV format.
+func WriteAuthorizedKeysImportReport(w io.Writer, results []*AuthorizedKeyImportResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"line", "username", "title", "status", "options", "error"})
+	for _, r := range results {
+		cw.Write([]string{com.ToStr(r.Line), r.UserName, r.Title, r.Status, r.Options, r.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAuthorizedKeysLine(t *testing.T) {
+	cases := []struct {
+		line                  string
+		options, key, comment string
+		hasErr                bool
+	}{
+		{"ssh-ed25519 AAAAC3Nza", "", "ssh-ed25519 AAAAC3Nza", "", false},
+		{"ssh-rsa AAAAB3Nza alice@example.com", "", "ssh-rsa AAAAB3Nza", "alice@example.com", false},
+		{"  ecdsa-sha2-nistp256 AAAAE2Vj my laptop ", "", "ecdsa-sha2-nistp256 AAAAE2Vj", "my laptop", false},
+		{`no-pty,from="10.0.0.1" ssh-ed25519 AAAAC3Nza bob`, `no-pty,from="10.0.0.1"`, "ssh-ed25519 AAAAC3Nza", "bob", false},
+		{`command="echo \"a b\"",no-pty ssh-rsa AAAAB3Nza bob`, `command="echo \"a b\"",no-pty`, "ssh-rsa AAAAB3Nza", "bob", false},
+		{"no-pty", "", "", "", true},
+		{"ssh-rsa", "", "", "", true},
+		{"no-pty ssh-rsa", "", "", "", true},
+	}
+	for _, c := range cases {
+		options, key, comment, err := parseAuthorizedKeysLine(c.line)
+		if (err != nil) != c.hasErr {
+			t.Errorf("%q: expect error %v but got %v", c.line, c.hasErr, err)
+			continue
+		}
+		if options != c.options || key != c.key || comment != c.comment {
+			t.Errorf("%q: expect (%q, %q, %q) but got (%q, %q, %q)", c.line,
+				c.options, c.key, c.comment, options, key, comment)
+		}
+	}
+}
+
+func TestIsManagedAuthorizedKeysLine(t *testing.T) {
+	managed := `command="/home/git/gogs serv key-3 --config='/home/git/custom/conf/app.ini'",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty`
+	if !isManagedAuthorizedKeysLine(managed) {
+		t.Errorf("expect line written by Gogs to be managed")
+	}
+	for _, options := range []string{"", "no-pty", `command="/usr/bin/git-shell"`} {
+		if isManagedAuthorizedKeysLine(options) {
+			t.Errorf("%q: expect not managed", options)
+		}
+	}
+}
+
+func TestUnimportedAuthorizedKeysLines(t *testing.T) {
+	data := []byte(`# keys of deploy scripts
+command="/home/git/gogs serv key-3 --config='/home/git/custom/conf/app.ini'",no-pty ssh-rsa AAAAB3Nza gogs
+ssh-ed25519 AAAAC3Nza alice@example.com
+
+from="10.0.0.1" ssh-rsa AAAAB3Nzb deploy
+ssh-rsa AAAAB3Nzc unknown
+`)
+	lines := unimportedAuthorizedKeysLines(data, map[string]bool{"ssh-ed25519 AAAAC3Nza": true})
+	expect := []string{"# keys of deploy scripts", `from="10.0.0.1" ssh-rsa AAAAB3Nzb deploy`, "ssh-rsa AAAAB3Nzc unknown"}
+	if len(lines) != len(expect) {
+		t.Fatalf("expect %d lines but got %q", len(expect), lines)
+	}
+	for i := range expect {
+		if lines[i] != expect[i] {
+			t.Errorf("line %d: expect %q but got %q", i, expect[i], lines[i])
+		}
+	}
+}
+
+func TestImportAuthorizedKeysRestricted(t *testing.T) {
+	data := `from="10.0.0.1",no-pty ssh-ed25519 AAAAC3Nza alice@example.com
+command="/home/git/gogs serv key-3 --config='/home/git/custom/conf/app.ini'",no-pty ssh-rsa AAAAB3Nza gogs
+`
+	report, err := ImportAuthorizedKeys(strings.NewReader(data), AuthorizedKeysImportOptions{
+		MapBy: AUTHORIZED_KEYS_MAP_BY_COMMENT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(report.Results) != 2 {
+		t.Fatalf("expect 2 results but got %d", len(report.Results))
+	}
+	if r := report.Results[0]; r.Status != KEY_IMPORT_RESTRICTED || r.Options != `from="10.0.0.1",no-pty` {
+		t.Errorf("expect line with options to be restricted but got %+v", r)
+	}
+	if r := report.Results[1]; r.Status != KEY_IMPORT_MANAGED {
+		t.Errorf("expect line written by Gogs to be managed but got %+v", r)
+	}
+	if report.Added > 0 || len(report.Backup) > 0 {
+		t.Errorf("expect nothing to be changed but got %+v", report)
+	}
+}
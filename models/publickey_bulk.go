@@ -0,0 +1,160 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Unknwon/com"
+)
+
+// Additional status of a key in result of bulk importing.
+const (
+	KEY_IMPORT_UNKNOWN_USER = "unknown_user"
+	KEY_IMPORT_DUPLICATE    = "duplicate"
+)
+
+// BulkKeyImportResult represents what happened to one row of bulk key import.
+type BulkKeyImportResult struct {
+	Row      int // Position of row in file, starts from 1, comment lines are not counted.
+	UserName string
+	Title    string
+	Status   string
+	Error    string // Reason of row that has not been imported.
+}
+
+// IsSucceed returns true if key of row exists after importing,
+// either added by this import or by a previous one.
+func (r *BulkKeyImportResult) IsSucceed() bool {
+	return r.Status == KEY_IMPORT_ADDED || r.Status == KEY_IMPORT_EXISTS
+}
+
+// importKeyRow adds key of one row to its owner.
+func importKeyRow(r *BulkKeyImportResult, content string, actor SecurityActor) {
+	u, err := GetUserByName(r.UserName)
+	if err != nil {
+		if err == ErrUserNotExist {
+			r.Status, r.Error = KEY_IMPORT_UNKNOWN_USER, err.Error()
+		} else {
+			r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+		}
+		return
+	}
+
+	if content, err = ParseKeyString(content); err != nil {
+		r.Status, r.Error = KEY_IMPORT_INVALID, err.Error()
+		return
+	}
+	if ok, err := CheckPublicKeyString(content); !ok && err != ErrKeyUnableVerify {
+		r.Status, r.Error = KEY_IMPORT_INVALID, err.Error()
+		return
+	}
+
+	// Row that succeeded before is skipped, so same file can be imported again
+	// after fixing failed rows.
+	fingerprint, _, err := keyFingerprints(content)
+	if err != nil {
+		r.Status, r.Error = KEY_IMPORT_INVALID, err.Error()
+		return
+	}
+	existing := &PublicKey{FingerprintSha256: fingerprint}
+	if has, err := x.Get(existing); err != nil {
+		r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+		return
+	} else if has {
+		if existing.OwnerId == u.Id {
+			r.Status = KEY_IMPORT_EXISTS
+		} else {
+			r.Status, r.Error = KEY_IMPORT_DUPLICATE, ErrKeyAlreadyExist.Error()
+		}
+		return
+	}
+
+	key := &PublicKey{
+		OwnerId: u.Id,
+		Name:    r.Title,
+		Content: content,
+	}
+	if err = addPublicKey(key, false, actor); err != nil {
+		switch {
+		case err == ErrKeyAlreadyExist, IsErrKeyFingerprintAlreadyUsed(err), IsErrKeyNameAlreadyUsed(err):
+			r.Status, r.Error = KEY_IMPORT_DUPLICATE, err.Error()
+		default:
+			r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+		}
+		return
+	}
+	r.Status = KEY_IMPORT_ADDED
+}
+
+// BulkImportPublicKeys adds public keys listed in CSV rows of "username,title,key" format,
+// an optional header row is skipped. Keys are checked the same way as added one by one,
+// and authorized_keys file is rewritten once at the end, even when reading stops early,
+// so keys added before are usable. Rows whose key already belongs to the user are reported
+// as existing, so same file can be imported again safely.
+// An error is only returned when file cannot be read or authorized_keys file cannot be
+// rewritten; failures of individual rows are reported in results.
+func BulkImportPublicKeys(r io.Reader, actor SecurityActor) (results []*BulkKeyImportResult, err error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	results = make([]*BulkKeyImportResult, 0, 50)
+	added := 0
+	defer func() {
+		if added == 0 {
+			return
+		}
+		if rewriteErr := RewriteAllPublicKeys(); rewriteErr != nil && err == nil {
+			err = fmt.Errorf("RewriteAllPublicKeys: %v", rewriteErr)
+		}
+	}()
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return results, fmt.Errorf("read CSV: %v", err)
+		}
+
+		if row == 1 && len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "username") {
+			continue
+		}
+
+		res := &BulkKeyImportResult{Row: row}
+		results = append(results, res)
+		if len(record) != 3 {
+			res.Status, res.Error = KEY_IMPORT_INVALID, fmt.Sprintf("expect 3 fields but got %d", len(record))
+			continue
+		}
+		res.UserName, res.Title = strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if len(res.Title) == 0 {
+			res.Status, res.Error = KEY_IMPORT_INVALID, "empty title"
+			continue
+		}
+
+		importKeyRow(res, strings.TrimSpace(record[2]), actor)
+		if res.Status == KEY_IMPORT_ADDED {
+			added++
+		}
+	}
+	return results, nil
+}
+
+// WriteBulkKeyImportReport writes results of bulk key import in CSV format.
+func WriteBulkKeyImportReport(w io.Writer, results []*BulkKeyImportResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"row", "username", "title", "status", "error"})
+	for _, r := range results {
+		cw.Write([]string{com.ToStr(r.Row), r.UserName, r.Title, r.Status, r.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
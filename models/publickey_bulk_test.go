@@ -0,0 +1,97 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBulkImportPublicKeysRowErrors(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	SSHPath = tmpDir
+
+	csv := strings.Join([]string{
+		"username,title,key",
+		"# comment lines are skipped",
+		"nobody,laptop,ssh-ed25519 AAAAC3NzaC1lZDI1NTE5",
+		"user1,laptop",
+		"user1, ,ssh-ed25519 AAAAC3NzaC1lZDI1NTE5",
+	}, "\n")
+	results, err := BulkImportPublicKeys(strings.NewReader(csv), SecurityActorSystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []struct {
+		row    int
+		status string
+	}{
+		{2, KEY_IMPORT_UNKNOWN_USER},
+		{3, KEY_IMPORT_INVALID},
+		{4, KEY_IMPORT_INVALID},
+	}
+	if len(results) != len(expect) {
+		t.Fatalf("expect %d results but got %d", len(expect), len(results))
+	}
+	for i, r := range results {
+		if r.Row != expect[i].row || r.Status != expect[i].status {
+			t.Errorf("result %d: expect row %d %q but got row %d %q", i, expect[i].row, expect[i].status, r.Row, r.Status)
+		} else if r.IsSucceed() || len(r.Error) == 0 {
+			t.Errorf("result %d: expect failure with reason but got %+v", i, r)
+		}
+	}
+
+	var report bytes.Buffer
+	if err = WriteBulkKeyImportReport(&report, results); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(report.String()), "\n"); len(lines) != 4 {
+		t.Errorf("expect header and 3 rows in report but got %q", report.String())
+	} else if !strings.HasPrefix(lines[1], "2,nobody,laptop,unknown_user,") {
+		t.Errorf("unexpected report row: %q", lines[1])
+	}
+}
+
+func TestBulkImportPublicKeysRewriteOnError(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	SSHPath = tmpDir
+	var err error
+
+	u := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local"}
+	if _, err = x.Insert(u); err != nil {
+		t.Fatal(err)
+	}
+
+	// Key of first row has been added when reading stops at second row.
+	csv := "user1,laptop," + testSSHSigKey + "\nuser1,desktop,ssh-rsa \"AAAA\n"
+	results, err := BulkImportPublicKeys(strings.NewReader(csv), SecurityActorSystem)
+	if err == nil {
+		t.Fatal("expect error of malformed row")
+	} else if len(results) != 1 || results[0].Status != KEY_IMPORT_ADDED {
+		t.Fatalf("expect first row to be added but got %+v", results)
+	}
+
+	key := &PublicKey{OwnerId: u.Id, Name: "laptop"}
+	if has, err := x.Get(key); err != nil {
+		t.Fatal(err)
+	} else if !has {
+		t.Fatal("expect key to be added")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(SSHPath, "authorized_keys"))
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(data), fmt.Sprintf("key-%d ", key.Id)) {
+		t.Errorf("expect authorized_keys to contain added key but got %q", data)
+	}
+}
@@ -0,0 +1,529 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestDeletePublicKeyOwnership(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	key := &PublicKey{
+		OwnerId:     1,
+		Name:        "key",
+		Fingerprint: "fingerprint",
+		Content:     "ssh-rsa AAAAB3NzaC1yc2E user1@fake.local",
+	}
+	if _, err = x.Insert(key); err != nil {
+		t.Fatal(err)
+	}
+	if err = RewriteAllPublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = DeletePublicKey(1, key.Id+1, SecurityActorSystem); err != ErrKeyNotExist {
+		t.Errorf("expect ErrKeyNotExist but got %v", err)
+	}
+	if err = DeletePublicKey(2, key.Id, SecurityActorSystem); err != ErrKeyAccessDenied {
+		t.Errorf("expect ErrKeyAccessDenied but got %v", err)
+	}
+	if _, err = GetPublicKeyById(key.Id); err != nil {
+		t.Fatalf("key should not be deleted by other user: %v", err)
+	}
+
+	if err = DeletePublicKey(1, key.Id, SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetPublicKeyById(key.Id); err != ErrKeyNotExist {
+		t.Errorf("expect ErrKeyNotExist after deletion but got %v", err)
+	}
+}
+
+func TestUpdatePublicKeyNameCaseInsensitive(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(PublicKey))
+	defer cleanup()
+	var err error
+
+	keys := []*PublicKey{
+		{OwnerId: 1, Name: "Laptop", Fingerprint: "fingerprint1", Content: "ssh-rsa AAAAB3NzaC1yc2E user1@laptop"},
+		{OwnerId: 1, Name: "desktop", Fingerprint: "fingerprint2", Content: "ssh-rsa AAAAB3NzaC1yc2F user1@desktop"},
+	}
+	for _, key := range keys {
+		if _, err = x.Insert(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = UpdatePublicKeyName(1, keys[1].Id, "LAPTOP")
+	if !IsErrKeyNameAlreadyUsed(err) {
+		t.Fatalf("expect ErrKeyNameAlreadyUsed but got %v", err)
+	} else if e := err.(ErrKeyNameAlreadyUsed); e.OwnerId != 1 || e.Name != "LAPTOP" {
+		t.Errorf("unexpected error details: %+v", e)
+	}
+
+	// Changing case of its own name is allowed.
+	if err = UpdatePublicKeyName(1, keys[0].Id, "laptop"); err != nil {
+		t.Errorf("expect renaming to own name in different case to succeed but got %v", err)
+	}
+}
+
+func TestExportPublicKeys(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(PublicKey))
+	defer cleanup()
+	var err error
+
+	keys := []*PublicKey{
+		{OwnerId: 1, Name: "laptop", Fingerprint: "fingerprint1", Content: "ssh-rsa AAAAB3NzaC1yc2E user1@laptop\n"},
+		{OwnerId: 1, Name: "desktop", Fingerprint: "fingerprint2", Content: "ssh-rsa AAAAB3NzaC1yc2F user1@desktop"},
+		{OwnerId: 2, Name: "other", Fingerprint: "fingerprint3", Content: "ssh-rsa AAAAB3NzaC1yc2G user2@other"},
+	}
+	for _, key := range keys {
+		if _, err = x.Insert(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err = ExportPublicKeys(buf, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	expect := "ssh-rsa AAAAB3NzaC1yc2E user1@laptop\nssh-rsa AAAAB3NzaC1yc2F user1@desktop\n"
+	if buf.String() != expect {
+		t.Errorf("expect %q but got %q", expect, buf.String())
+	}
+
+	buf.Reset()
+	if err = ExportPublicKeys(buf, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	var exports []*PublicKeyExport
+	if err = json.Unmarshal(buf.Bytes(), &exports); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	} else if len(exports) != 2 || exports[0].Name != "laptop" || exports[1].Fingerprint != "fingerprint2" {
+		t.Errorf("unexpected exported keys: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err = ExportPublicKeys(buf, 3, true); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != "[]" {
+		t.Errorf("expect empty array but got %q", buf.String())
+	}
+}
+
+func TestListUsablePublicKeys(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(PublicKey))
+	defer cleanup()
+	var err error
+
+	if _, err = x.Insert(
+		&PublicKey{OwnerId: 1, Name: "key1", Fingerprint: "fp1", Content: "ssh-rsa AAAA1"},
+		&PublicKey{OwnerId: 1, Name: "key2", Fingerprint: "fp2", Content: "ssh-rsa AAAA2"},
+		&PublicKey{OwnerId: 1, Name: "key3", Fingerprint: "fp3", Content: "ssh-rsa AAAA3"},
+		&PublicKey{OwnerId: 1, Name: "disabled", Fingerprint: "fp4", Content: "ssh-rsa AAAA4", IsDisabled: true},
+		&PublicKey{OwnerId: 1, Name: "pending", Fingerprint: "fp5", Content: "ssh-rsa AAAA5", IsPending: true},
+		&PublicKey{OwnerId: 2, Name: "other", Fingerprint: "fp6", Content: "ssh-rsa AAAA6"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, total, err := ListUsablePublicKeys(1, "", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if total != 3 {
+		t.Errorf("expect 3 usable keys but got %d", total)
+	} else if len(keys) != 1 || keys[0].Name != "key3" {
+		t.Errorf("expect only key3 on second page but got %+v", keys)
+	}
+
+	if keys, total, err = ListUsablePublicKeys(1, "fp2", 1, 10); err != nil {
+		t.Fatal(err)
+	} else if total != 1 || len(keys) != 1 || keys[0].Name != "key2" {
+		t.Errorf("expect only key2 by fingerprint but got %d: %+v", total, keys)
+	}
+
+	if _, total, err = ListUsablePublicKeys(1, "fp4", 1, 10); err != nil {
+		t.Fatal(err)
+	} else if total != 0 {
+		t.Errorf("expect disabled key not to be listed but got %d", total)
+	}
+}
+
+func TestPublicKeysStamp(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	key1 := &PublicKey{OwnerId: 1, Name: "key1", Fingerprint: "fp1", Content: "ssh-rsa AAAA1"}
+	key2 := &PublicKey{OwnerId: 1, Name: "key2", Fingerprint: "fp2", Content: "ssh-rsa AAAA2"}
+	if _, err = x.Insert(key1, key2); err != nil {
+		t.Fatal(err)
+	}
+
+	stamp, err := PublicKeysStamp(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectChanged := func(action string) {
+		newStamp, err := PublicKeysStamp(1)
+		if err != nil {
+			t.Fatal(err)
+		} else if newStamp == stamp {
+			t.Errorf("expect stamp to change after %s", action)
+		}
+		stamp = newStamp
+	}
+
+	if same, err := PublicKeysStamp(1); err != nil {
+		t.Fatal(err)
+	} else if same != stamp {
+		t.Errorf("expect same stamp without changes but got %q and %q", stamp, same)
+	}
+
+	// Disabling a key that is not the newest one must still change stamp.
+	if err = SetPublicKeyDisabled(key1, true, SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	}
+	expectChanged("disabling key")
+
+	names := make([]string, 0, 1)
+	if err = IterateUsablePublicKeys(1, func(key *PublicKey) error {
+		names = append(names, key.Name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if len(names) != 1 || names[0] != "key2" {
+		t.Errorf("expect only key2 but got %v", names)
+	}
+
+	if err = UpdatePublicKeyName(1, key1.Id, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	expectChanged("renaming key")
+
+	key3 := &PublicKey{OwnerId: 1, Name: "key3", Fingerprint: "fp3", Content: "ssh-rsa AAAA3"}
+	if _, err = x.Insert(key3); err != nil {
+		t.Fatal(err)
+	}
+	expectChanged("adding key")
+
+	if _, err = x.Delete(&PublicKey{Id: key2.Id}); err != nil {
+		t.Fatal(err)
+	}
+	expectChanged("deleting key")
+
+	// Keys of other users do not affect stamp.
+	if _, err = x.Insert(&PublicKey{OwnerId: 2, Name: "key", Fingerprint: "fp4", Content: "ssh-rsa AAAA4"}); err != nil {
+		t.Fatal(err)
+	}
+	if same, err := PublicKeysStamp(1); err != nil {
+		t.Fatal(err)
+	} else if same != stamp {
+		t.Errorf("expect stamp not to change by keys of other users")
+	}
+}
+
+func TestListPublicKeysPaged(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(PublicKey))
+	defer cleanup()
+	var err error
+
+	now := time.Now()
+	if _, err = x.Insert(
+		&PublicKey{OwnerId: 1, Name: "key1", Fingerprint: "fp1", Content: "ssh-rsa AAAA1", Type: "RSA", Updated: now.Add(-time.Hour)},
+		&PublicKey{OwnerId: 1, Name: "key2", Fingerprint: "fp2", Content: "ssh-dss AAAA2", Type: "DSA", Updated: now},
+		&PublicKey{OwnerId: 1, Name: "key3", Fingerprint: "fp3", Content: "ssh-rsa AAAA3", Type: "RSA", Updated: now.Add(-2 * time.Hour)},
+		&PublicKey{OwnerId: 2, Name: "other", Fingerprint: "fp4", Content: "ssh-rsa AAAA4", Type: "RSA"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if total, err := CountPublicKeys(1, nil); err != nil {
+		t.Fatal(err)
+	} else if total != 3 {
+		t.Errorf("expect 3 keys but got %d", total)
+	}
+	keys, err := ListPublicKeysPaged(1, 2, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 1 || keys[0].Name != "key3" {
+		t.Errorf("expect only key3 on second page but got %+v", keys)
+	}
+
+	opts := &PublicKeyListOptions{Type: "rsa", OrderBy: KEY_ORDER_LAST_USED}
+	if total, err := CountPublicKeys(1, opts); err != nil {
+		t.Fatal(err)
+	} else if total != 2 {
+		t.Errorf("expect 2 RSA keys but got %d", total)
+	}
+	if keys, err = ListPublicKeysPaged(1, 1, 10, opts); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 2 || keys[0].Name != "key1" || keys[1].Name != "key3" {
+		t.Errorf("expect key1 and key3 by last used but got %+v", keys)
+	}
+}
+
+func TestSearchPublicKeyByFingerprint(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(PublicKey))
+	defer cleanup()
+	var err error
+
+	key := &PublicKey{OwnerId: 1, Name: "key", Fingerprint: "fp", Content: "ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB user1@fake.local"}
+	if key.FingerprintSha256, key.FingerprintMd5, err = keyFingerprints(key.Content); err != nil {
+		t.Fatal(err)
+	} else if key.FingerprintSha256 != "SHA256:FiE53OrBzpgUnWF4bDhyvwz08CxT6vCFY5eBKi4x9dI" ||
+		key.FingerprintMd5 != "a5:61:3d:ac:36:24:1b:d0:8d:9a:5c:05:a1:ed:60:2a" {
+		t.Fatalf("unexpected fingerprints: %s, %s", key.FingerprintSha256, key.FingerprintMd5)
+	}
+	if _, err = x.Insert(key); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fingerprint := range []string{
+		"SHA256:FiE53OrBzpgUnWF4bDhyvwz08CxT6vCFY5eBKi4x9dI",
+		"SHA256:FiE53OrBzpgUnWF4bDhyvwz08CxT6vCFY5eBKi4x9dI=",
+		"a5:61:3d:ac:36:24:1b:d0:8d:9a:5c:05:a1:ed:60:2a",
+		"MD5:A5:61:3D:AC:36:24:1B:D0:8D:9A:5C:05:A1:ED:60:2A",
+	} {
+		if found, err := SearchPublicKeyByFingerprint(fingerprint); err != nil {
+			t.Errorf("%s: %v", fingerprint, err)
+		} else if found.Id != key.Id {
+			t.Errorf("%s: expect key(%d) but got key(%d)", fingerprint, key.Id, found.Id)
+		}
+	}
+
+	if _, err = SearchPublicKeyByFingerprint("SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU"); err != ErrKeyNotExist {
+		t.Errorf("expect ErrKeyNotExist but got %v", err)
+	}
+	for _, fingerprint := range []string{"", "SHA256:abc", "a5:61:3d", "zz:61:3d:ac:36:24:1b:d0:8d:9a:5c:05:a1:ed:60:2a"} {
+		if _, err = SearchPublicKeyByFingerprint(fingerprint); err != ErrInvalidFingerprint {
+			t.Errorf("%q: expect ErrInvalidFingerprint but got %v", fingerprint, err)
+		}
+	}
+}
+
+func TestRewriteAllPublicKeysOnce(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(PublicKey))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	if _, err = x.Insert(
+		&PublicKey{OwnerId: 1, Name: "key1", Fingerprint: "fp1", Content: "ssh-rsa AAAA1"},
+		&PublicKey{OwnerId: 1, Name: "key2", Fingerprint: "fp2", Content: "ssh-rsa AAAA2"},
+		&PublicKey{OwnerId: 1, Name: "key3", Fingerprint: "fp3", Content: "ssh-rsa AAAA3", IsDisabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	// Temporary file of a rewrite in another process must be left alone.
+	otherTmpPath := filepath.Join(tmpDir, "authorized_keys.tmp")
+	if err = ioutil.WriteFile(otherTmpPath, []byte("other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := RewriteAllPublicKeysOnce()
+	if err != nil {
+		t.Fatal(err)
+	} else if result.NumKeys != 2 {
+		t.Errorf("expect 2 keys written but got %d", result.NumKeys)
+	}
+	if data, err := ioutil.ReadFile(otherTmpPath); err != nil || string(data) != "other\n" {
+		t.Errorf("expect temporary file of other rewrite to be untouched but got %q: %v", data, err)
+	}
+	if running, _ := PublicKeysRewriteStatus(); running {
+		t.Error("expect rewrite not to be running after finished")
+	}
+
+	keysRewriteStatus.running = true
+	defer func() { keysRewriteStatus.running = false }()
+	if _, err = RewriteAllPublicKeysOnce(); err != ErrKeysRewriteInProgress {
+		t.Errorf("expect ErrKeysRewriteInProgress but got %v", err)
+	}
+}
+
+func TestSetPublicKeyDisabledUnchanged(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	key := &PublicKey{OwnerId: 1, Name: "key1", Fingerprint: "fp1", Content: "ssh-rsa AAAA1"}
+	if _, err = x.Insert(key); err != nil {
+		t.Fatal(err)
+	} else if err = RewriteAllPublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+	revision := key.Revision
+
+	// Enabling a key that is already enabled must not add another line.
+	if err = SetPublicKeyDisabled(key, false, SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	} else if key.Revision != revision {
+		t.Errorf("expect revision %d to be kept but got %d", revision, key.Revision)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(SSHPath, "authorized_keys"))
+	if err != nil {
+		t.Fatal(err)
+	} else if n := strings.Count(string(data), fmt.Sprintf("key-%d ", key.Id)); n != 1 {
+		t.Errorf("expect key to be written once but got %d times", n)
+	}
+
+	for _, disabled := range []bool{true, true, false} {
+		if err = SetPublicKeyDisabled(key, disabled, SecurityActorSystem); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err = ioutil.ReadFile(filepath.Join(SSHPath, "authorized_keys")); err != nil {
+		t.Fatal(err)
+	} else if n := strings.Count(string(data), fmt.Sprintf("key-%d ", key.Id)); n != 1 {
+		t.Errorf("expect re-enabled key to be written once but got %d times", n)
+	}
+}
+
+func TestAddPublicKeyFingerprintUsedByOtherUser(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	for _, name := range []string{"alice", "bob"} {
+		if _, err = x.Insert(&User{Name: name, LowerName: name, Email: name + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice, _ := GetUserByName("alice")
+	bob, _ := GetUserByName("bob")
+
+	const content = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMWJrPvKG8fveAGtThsvOwG1WCU3New8B4YX0vQwDlXS"
+	if err = AddPublicKey(&PublicKey{OwnerId: alice.Id, Name: "laptop", Content: content}, SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	}
+
+	err = AddPublicKey(&PublicKey{OwnerId: bob.Id, Name: "laptop", Content: content}, SecurityActorSystem)
+	if !IsErrKeyFingerprintAlreadyUsed(err) {
+		t.Fatalf("expect ErrKeyFingerprintAlreadyUsed but got %v", err)
+	} else if owner := err.(ErrKeyFingerprintAlreadyUsed).OwnerName; owner != "alice" {
+		t.Errorf("expect owner alice but got %s", owner)
+	}
+
+	// Same user adding same key again is not told about itself.
+	if err = AddPublicKey(&PublicKey{OwnerId: alice.Id, Name: "desktop", Content: content}, SecurityActorSystem); err != ErrKeyAlreadyExist {
+		t.Errorf("expect ErrKeyAlreadyExist but got %v", err)
+	}
+}
+
+func TestDeleteExpiredUnverifiedPublicKeys(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask), new(SiteHook))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	oldVerify, oldExpire := setting.RequireSSHKeyVerify, setting.UnverifiedSSHKeyExpire
+	defer func() {
+		setting.RequireSSHKeyVerify, setting.UnverifiedSSHKeyExpire = oldVerify, oldExpire
+	}()
+	setting.UnverifiedSSHKeyExpire = 7
+
+	old := time.Now().AddDate(0, 0, -8)
+	for i, verified := range []bool{false, true} {
+		key := &PublicKey{
+			OwnerId:     1,
+			Name:        fmt.Sprintf("key%d", i),
+			Fingerprint: fmt.Sprintf("fingerprint%d", i),
+			Content:     fmt.Sprintf("ssh-rsa AAAAB3NzaC1yc2E%d user1@fake.local", i),
+			Verified:    verified,
+		}
+		if _, err = x.Insert(key); err != nil {
+			t.Fatal(err)
+		} else if _, err = x.Id(key.Id).Cols("created").Update(&PublicKey{Created: old}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Keys do not expire when verification is not required.
+	setting.RequireSSHKeyVerify = false
+	DeleteExpiredUnverifiedPublicKeys()
+	if count, err := x.Count(new(PublicKey)); err != nil {
+		t.Fatal(err)
+	} else if count != 2 {
+		t.Fatalf("expect no key to be deleted but got %d keys", count)
+	}
+
+	setting.RequireSSHKeyVerify = true
+	DeleteExpiredUnverifiedPublicKeys()
+	keys := make([]*PublicKey, 0, 2)
+	if err = x.Find(&keys); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 1 || !keys[0].Verified {
+		t.Errorf("expect only verified key to be kept but got %d keys", len(keys))
+	}
+}
+
+func TestPublicKeyApproval(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask), new(KeyApprovalNotifyTask))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	oldApproval := setting.RequireSSHKeyApproval
+	defer func() { setting.RequireSSHKeyApproval = oldApproval }()
+	setting.RequireSSHKeyApproval = true
+
+	pending := &PublicKey{OwnerId: 1, Name: "laptop", Content: testSSHSigKey}
+	if err = AddPublicKey(pending, SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	} else if !pending.IsPending {
+		t.Fatal("expect new key to wait for approval")
+	}
+
+	// Admins are notified of keys however they have been added.
+	tasks, err := GetKeyApprovalNotifyTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tasks) != 1 || tasks[0].KeyId != pending.Id {
+		t.Fatalf("expect notification of key(%d) to be queued but got %d tasks", pending.Id, len(tasks))
+	} else if err = DeleteKeyApprovalNotifyTask(tasks[0].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	approved := &PublicKey{OwnerId: 1, Name: "desktop", Fingerprint: "fp1", Content: "ssh-rsa AAAA1"}
+	if _, err = x.Insert(approved); err != nil {
+		t.Fatal(err)
+	}
+	if err = RejectPublicKey(approved, SecurityActorSystem); err != ErrKeyNotPending {
+		t.Errorf("expect approved key not to be rejected but got %v", err)
+	} else if _, err = GetPublicKeyById(approved.Id); err != nil {
+		t.Errorf("expect approved key to be kept: %v", err)
+	}
+
+	if err = RejectPublicKey(pending, SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	} else if _, err = GetPublicKeyById(pending.Id); err != ErrKeyNotExist {
+		t.Errorf("expect rejected key to be deleted but got %v", err)
+	}
+
+	// Both operations are recorded along with the changes.
+	logs, err := SearchSecurityLogs(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(logs) != 2 || logs[0].Operation != SECURITY_OP_KEY_REJECT || logs[1].Operation != SECURITY_OP_KEY_ADD {
+		t.Errorf("expect rejection and addition to be recorded but got %d logs", len(logs))
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/httplib"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var (
+	ErrGitHubUserNameInvalid = errors.New("Invalid GitHub user name")
+	ErrGitHubUserNotExist    = errors.New("GitHub user does not exist")
+)
+
+// ErrGitHubKeysFetch represents a failure to fetch public keys from GitHub,
+// no key is imported when it happens.
+type ErrGitHubKeysFetch struct {
+	UserName string
+	Err      error
+}
+
+func (err ErrGitHubKeysFetch) Error() string {
+	return fmt.Sprintf("Fail to fetch public keys of GitHub user %s: %v", err.UserName, err.Err)
+}
+
+func IsErrGitHubKeysFetch(err error) bool {
+	_, ok := err.(ErrGitHubKeysFetch)
+	return ok
+}
+
+// GitHubKeysURL is the address of public keys of a GitHub user, %s is user name.
+var GitHubKeysURL = "https://github.com/%s.keys"
+
+const (
+	// Users rarely have more than a handful keys, larger responses are rejected.
+	githubKeysMaxSize = 64 << 10
+)
+
+var githubUserNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]{0,38}$`)
+
+// fetchGitHubKeys returns non-empty lines of public keys of given GitHub user.
+func fetchGitHubKeys(userName string) ([]string, error) {
+	if !githubUserNamePattern.MatchString(userName) {
+		return nil, ErrGitHubUserNameInvalid
+	}
+
+	resp, err := httplib.Get(fmt.Sprintf(GitHubKeysURL, userName)).
+		SetTimeout(10*time.Second, 30*time.Second).
+		SetProxy(setting.Proxy).Response()
+	if err != nil {
+		return nil, ErrGitHubKeysFetch{userName, err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return nil, ErrGitHubUserNotExist
+	case resp.StatusCode != 200:
+		return nil, ErrGitHubKeysFetch{userName, fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, githubKeysMaxSize+1))
+	if err != nil {
+		return nil, ErrGitHubKeysFetch{userName, err}
+	} else if len(data) > githubKeysMaxSize {
+		return nil, ErrGitHubKeysFetch{userName, fmt.Errorf("response exceeds %d bytes", githubKeysMaxSize)}
+	}
+
+	lines := make([]string, 0, 5)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Status of a key in result of importing.
+const (
+	KEY_IMPORT_ADDED   = "added"
+	KEY_IMPORT_EXISTS  = "exists"
+	KEY_IMPORT_INVALID = "invalid"
+	KEY_IMPORT_FAILED  = "failed"
+)
+
+// KeyImportResult represents what happened to one of imported keys.
+type KeyImportResult struct {
+	Line   int    // Position of key in fetched list, starts from 1.
+	Name   string // Only set when key has been added.
+	Status string
+	Error  string     // Reason of invalid or failed key.
+	Key    *PublicKey // Only set when key has been added.
+}
+
+// ImportGitHubPublicKeys fetches public keys of given GitHub user and adds them to owner,
+// keys that already exist are skipped. An error is only returned when keys cannot be fetched,
+// and nothing is imported in that case; failures of individual keys are reported in results.
+func ImportGitHubPublicKeys(ownerId int64, userName string, actor SecurityActor) ([]*KeyImportResult, error) {
+	lines, err := fetchGitHubKeys(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*KeyImportResult, len(lines))
+	for i, line := range lines {
+		r := &KeyImportResult{Line: i + 1}
+		results[i] = r
+
+		content, err := ParseKeyString(line)
+		if err != nil {
+			r.Status, r.Error = KEY_IMPORT_INVALID, err.Error()
+			continue
+		}
+		if ok, err := CheckPublicKeyString(content); !ok && err != ErrKeyUnableVerify {
+			r.Status, r.Error = KEY_IMPORT_INVALID, err.Error()
+			continue
+		}
+
+		name, err := uniqueKeyName(ownerId, "github:"+userName, r.Line)
+		if err != nil {
+			r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+			continue
+		}
+		key := &PublicKey{
+			OwnerId: ownerId,
+			Name:    name,
+			Content: content,
+		}
+		if err = AddPublicKey(key, actor); err != nil {
+			if err == ErrKeyAlreadyExist {
+				r.Status = KEY_IMPORT_EXISTS
+			} else {
+				r.Status, r.Error = KEY_IMPORT_FAILED, err.Error()
+			}
+			continue
+		}
+		r.Name, r.Status, r.Key = name, KEY_IMPORT_ADDED, key
+	}
+	return results, nil
+}
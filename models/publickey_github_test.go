@@ -0,0 +1,52 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchGitHubKeys(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user1.keys":
+			fmt.Fprint(w, "ssh-rsa AAAAB3NzaC1yc2E\n\nssh-ed25519 AAAAC3NzaC1lZDI1NTE5\n")
+		case "/huge.keys":
+			fmt.Fprint(w, strings.Repeat("a", githubKeysMaxSize+1))
+		case "/broken.keys":
+			w.WriteHeader(500)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	oldURL := GitHubKeysURL
+	defer func() { GitHubKeysURL = oldURL }()
+	GitHubKeysURL = ts.URL + "/%s.keys"
+
+	lines, err := fetchGitHubKeys("user1")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(lines) != 2 || lines[1] != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5" {
+		t.Errorf("unexpected keys: %q", lines)
+	}
+
+	if _, err = fetchGitHubKeys("user1/../admin"); err != ErrGitHubUserNameInvalid {
+		t.Errorf("expect ErrGitHubUserNameInvalid but got %v", err)
+	}
+	if _, err = fetchGitHubKeys("nobody"); err != ErrGitHubUserNotExist {
+		t.Errorf("expect ErrGitHubUserNotExist but got %v", err)
+	}
+	for _, name := range []string{"huge", "broken"} {
+		if _, err = fetchGitHubKeys(name); !IsErrGitHubKeysFetch(err) {
+			t.Errorf("%s: expect ErrGitHubKeysFetch but got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/process"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+type KeyEvent string
+
+const (
+	KEY_EVENT_ADD     KeyEvent = "add"
+	KEY_EVENT_DELETE  KeyEvent = "delete"
+	KEY_EVENT_REWRITE KeyEvent = "rewrite"
+)
+
+// keyChangeHookEnv returns environment variables describing the event for key change hook,
+// key is nil when all keys have been rewritten.
+func keyChangeHookEnv(event KeyEvent, key *PublicKey, owner string) []string {
+	env := []string{"GOGS_KEY_EVENT=" + string(event)}
+	if key == nil {
+		return env
+	}
+
+	fingerprint := key.FingerprintSha256
+	if len(fingerprint) == 0 {
+		fingerprint = key.Fingerprint
+	}
+	return append(env,
+		"GOGS_KEY_ID="+com.ToStr(key.Id),
+		"GOGS_KEY_FINGERPRINT="+fingerprint,
+		"GOGS_KEY_OWNER="+owner)
+}
+
+// keyChangeHookEvent represents a change of SSH keys waiting to be passed to key change hook.
+type keyChangeHookEvent struct {
+	event KeyEvent
+	key   *PublicKey
+	owner string
+}
+
+// keyChangeHookQueue holds changes of SSH keys in order they happened,
+// it is nil when processes run hook synchronously, e.g. admin commands.
+var keyChangeHookQueue chan *keyChangeHookEvent
+
+// runKeyChangeHook runs the executable set by SSH_KEY_CHANGE_HOOK after SSH keys
+// have been changed. It must be called without holding sshOpLocker. Hook is run
+// in background one event after another when NewKeyChangeHookContext has been called,
+// so a slow hook does not hold up key operations.
+func runKeyChangeHook(event KeyEvent, key *PublicKey) {
+	if len(setting.SSHKeyChangeHook) == 0 {
+		return
+	}
+
+	var owner string
+	if key != nil {
+		if u, err := GetUserById(key.OwnerId); err == nil {
+			owner = u.Name
+		} else {
+			// Owner may have been deleted along with the key.
+			owner = com.ToStr(key.OwnerId)
+		}
+	}
+	runKeyChangeHookOfOwner(event, key, owner)
+}
+
+// runKeyChangeHookOfOwner is same as runKeyChangeHook but with name of owner of key given,
+// which is used when owner has been deleted.
+func runKeyChangeHookOfOwner(event KeyEvent, key *PublicKey, owner string) {
+	if len(setting.SSHKeyChangeHook) == 0 {
+		return
+	}
+
+	e := &keyChangeHookEvent{event: event, owner: owner}
+	if key != nil {
+		// Key may be changed by caller after hook has been queued.
+		k := *key
+		e.key = &k
+	}
+	if keyChangeHookQueue == nil {
+		execKeyChangeHook(e)
+		return
+	}
+	keyChangeHookQueue <- e
+}
+
+// execKeyChangeHook runs key change hook for given event. Failure of the hook is logged
+// and reported to admin but does not undo the change.
+func execKeyChangeHook(e *keyChangeHookEvent) {
+	desc := fmt.Sprintf("SSH key change hook(%s)", e.event)
+	stdout, stderr, err := process.ExecDirEnv(setting.SSHKeyChangeHookTimeout, "", desc,
+		keyChangeHookEnv(e.event, e.key, e.owner), setting.SSHKeyChangeHook)
+	if len(strings.TrimSpace(stdout)) > 0 {
+		log.Info("%s: %s", desc, strings.TrimSpace(stdout))
+	}
+	if err == nil {
+		if len(strings.TrimSpace(stderr)) > 0 {
+			log.Warn("%s: %s", desc, strings.TrimSpace(stderr))
+		}
+		return
+	}
+
+	msg := fmt.Sprintf("Fail to run SSH key change hook for %s event: %v - %s", e.event, err, strings.TrimSpace(stderr))
+	log.Error(4, "%s", msg)
+	if err = CreateNotice(NOTICE_KEY_CHANGE_HOOK, msg); err != nil {
+		log.Error(4, "CreateNotice: %v", err)
+	}
+}
+
+// NewKeyChangeHookContext starts running key change hook in background.
+func NewKeyChangeHookContext() {
+	if len(setting.SSHKeyChangeHook) == 0 {
+		return
+	}
+
+	keyChangeHookQueue = make(chan *keyChangeHookEvent, 100)
+	go func() {
+		for e := range keyChangeHookQueue {
+			execKeyChangeHook(e)
+		}
+	}()
+}
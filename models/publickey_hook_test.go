@@ -0,0 +1,100 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestKeyChangeHookEnv(t *testing.T) {
+	key := &PublicKey{Id: 3, OwnerId: 2, Fingerprint: "md5", FingerprintSha256: "SHA256:abc"}
+	expect := []string{
+		"GOGS_KEY_EVENT=add",
+		"GOGS_KEY_ID=3",
+		"GOGS_KEY_FINGERPRINT=SHA256:abc",
+		"GOGS_KEY_OWNER=alice",
+	}
+	if env := keyChangeHookEnv(KEY_EVENT_ADD, key, "alice"); strings.Join(env, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expect %v but got %v", expect, env)
+	}
+
+	if env := keyChangeHookEnv(KEY_EVENT_REWRITE, nil, ""); len(env) != 1 || env[0] != "GOGS_KEY_EVENT=rewrite" {
+		t.Errorf("expect only event for rewrite but got %v", env)
+	}
+}
+
+func TestRunKeyChangeHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogs-key-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	hook := filepath.Join(dir, "hook.sh")
+	if err = ioutil.WriteFile(hook, []byte("#!/bin/sh\necho \"$GOGS_KEY_EVENT\" > "+out+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	oldHook, oldTimeout := setting.SSHKeyChangeHook, setting.SSHKeyChangeHookTimeout
+	defer func() {
+		setting.SSHKeyChangeHook, setting.SSHKeyChangeHookTimeout = oldHook, oldTimeout
+	}()
+	setting.SSHKeyChangeHook, setting.SSHKeyChangeHookTimeout = hook, 10*time.Second
+
+	runKeyChangeHook(KEY_EVENT_REWRITE, nil)
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.TrimSpace(string(data)) != "rewrite" {
+		t.Errorf("expect hook to receive rewrite event but got %q", data)
+	}
+}
+
+func TestRunKeyChangeHookQueued(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogs-key-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	hook := filepath.Join(dir, "hook.sh")
+	if err = ioutil.WriteFile(hook, []byte("#!/bin/sh\necho \"$GOGS_KEY_EVENT $GOGS_KEY_OWNER\" >> "+out+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	oldHook, oldTimeout := setting.SSHKeyChangeHook, setting.SSHKeyChangeHookTimeout
+	defer func() {
+		setting.SSHKeyChangeHook, setting.SSHKeyChangeHookTimeout = oldHook, oldTimeout
+	}()
+	setting.SSHKeyChangeHook, setting.SSHKeyChangeHookTimeout = hook, 10*time.Second
+
+	NewKeyChangeHookContext()
+	defer func() {
+		close(keyChangeHookQueue)
+		keyChangeHookQueue = nil
+	}()
+
+	key := &PublicKey{Id: 3, OwnerId: 2, FingerprintSha256: "SHA256:abc"}
+	runKeyChangeHookOfOwner(KEY_EVENT_DELETE, key, "alice")
+	runKeyChangeHook(KEY_EVENT_REWRITE, nil)
+
+	expect := "delete alice\nrewrite"
+	var data []byte
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if data, _ = ioutil.ReadFile(out); strings.TrimSpace(string(data)) == expect {
+			return
+		}
+	}
+	t.Errorf("expect hook to receive events in order %q but got %q", expect, data)
+}
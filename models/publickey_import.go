@@ -0,0 +1,57 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"time"
+)
+
+// ImportedPublicKey maps a public key of an external service to the key it has been
+// imported as, so importing from same service again does not add it again,
+// even when user has deleted the imported key since.
+type ImportedPublicKey struct {
+	Id         int64
+	Source     string `xorm:"UNIQUE(s) NOT NULL"` // Base URL of external service.
+	ExternalId int64  `xorm:"UNIQUE(s) NOT NULL"` // ID of key in external service.
+	OwnerId    int64  `xorm:"INDEX NOT NULL"`
+	KeyId      int64
+	Created    time.Time `xorm:"CREATED"`
+}
+
+// IsPublicKeyImported returns true if key of external service has been imported before.
+func IsPublicKeyImported(source string, externalId int64) (bool, error) {
+	return x.Get(&ImportedPublicKey{Source: source, ExternalId: externalId})
+}
+
+// ImportPublicKey adds key of external service to its owner and records the mapping.
+// When owner already has same key, only the mapping is recorded.
+func ImportPublicKey(key *PublicKey, source string, externalId int64) error {
+	if err := AddPublicKey(key, SecurityActorSystem); err != nil {
+		if err != ErrKeyAlreadyExist {
+			return err
+		}
+
+		// Only treat it as imported when the key belongs to same user.
+		fingerprint, _, ferr := keyFingerprints(key.Content)
+		if ferr != nil {
+			return ferr
+		}
+		existing := &PublicKey{OwnerId: key.OwnerId, FingerprintSha256: fingerprint}
+		if has, gerr := x.Get(existing); gerr != nil {
+			return gerr
+		} else if !has {
+			return err
+		}
+		key = existing
+	}
+
+	_, err := x.Insert(&ImportedPublicKey{
+		Source:     source,
+		ExternalId: externalId,
+		OwnerId:    key.OwnerId,
+		KeyId:      key.Id,
+	})
+	return err
+}
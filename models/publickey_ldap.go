@@ -0,0 +1,140 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/gogits/gogs/modules/log"
+)
+
+// SyncExternalPublicKeys makes keys of user managed by given source match given contents,
+// missing keys are added and keys no longer listed are deleted. Source is either LoginSource
+// or KeySourceId of managed. Keys added by user are never touched, and a listed key that
+// user has already added stays managed by user.
+func SyncExternalPublicKeys(u *User, managed *PublicKey, namePrefix string, contents []string) (added, removed []*PublicKey, err error) {
+	if !managed.IsExternal() {
+		return nil, nil, fmt.Errorf("no source of managed keys is given")
+	}
+
+	keys := make([]*PublicKey, 0, 5)
+	if err = x.Find(&keys, &PublicKey{
+		OwnerId:     u.Id,
+		LoginSource: managed.LoginSource,
+		KeySourceId: managed.KeySourceId,
+	}); err != nil {
+		return nil, nil, err
+	}
+	// Keys are matched by SHA256 fingerprint, which does not depend on key comment.
+	existing := make(map[string]*PublicKey, len(keys))
+	for _, key := range keys {
+		existing[key.FingerprintSha256] = key
+	}
+
+	listed := make(map[string]bool, len(contents))
+	for _, content := range contents {
+		content, err := ParseKeyString(content)
+		if err != nil {
+			log.Warn("SyncExternalPublicKeys[%s]: invalid key: %v", u.Name, err)
+			continue
+		}
+		fingerprint, _, err := keyFingerprints(content)
+		if err != nil {
+			log.Warn("SyncExternalPublicKeys[%s]: invalid key: %v", u.Name, err)
+			continue
+		} else if listed[fingerprint] {
+			continue
+		}
+		listed[fingerprint] = true
+		if existing[fingerprint] != nil {
+			continue
+		}
+
+		name, err := uniqueKeyName(u.Id, namePrefix, 1)
+		if err != nil {
+			return added, removed, err
+		}
+		key := &PublicKey{
+			OwnerId:     u.Id,
+			Name:        name,
+			Content:     content,
+			LoginSource: managed.LoginSource,
+			KeySourceId: managed.KeySourceId,
+		}
+		if err = AddPublicKey(key, SecurityActorSystem); err != nil {
+			if err != ErrKeyAlreadyExist && !IsErrKeyFingerprintAlreadyUsed(err) {
+				log.Error(4, "SyncExternalPublicKeys[%s]: AddPublicKey: %v", u.Name, err)
+			}
+			continue
+		}
+		added = append(added, key)
+	}
+
+	for fingerprint, key := range existing {
+		if listed[fingerprint] {
+			continue
+		}
+		if err = DeletePublicKey(u.Id, key.Id, SecurityActorSystem); err != nil {
+			return added, removed, fmt.Errorf("DeletePublicKey[%d]: %v", key.Id, err)
+		}
+		removed = append(removed, key)
+	}
+	return added, removed, nil
+}
+
+// syncLDAPPublicKeys synchronizes SSH keys of user from LDAP source when key attribute is set,
+// binding as user when password is given. Failures are logged, keys are left unchanged
+// when they cannot be searched.
+func syncLDAPPublicKeys(u *User, passwd string, sourceId int64, cfg *LDAPConfig) {
+	if len(cfg.AttributeSSHPublicKey) == 0 {
+		return
+	}
+
+	contents, err := cfg.SearchPublicKeys(u.LoginName, passwd)
+	if err != nil {
+		log.Warn("Fail to search SSH keys of %s in LDAP(%s): %v", u.LoginName, cfg.Name, err)
+		return
+	}
+	added, removed, err := SyncExternalPublicKeys(u, &PublicKey{LoginSource: sourceId}, "ldap:"+cfg.Name, contents)
+	if err != nil {
+		log.Error(4, "SyncExternalPublicKeys[%s]: %v", u.Name, err)
+	} else if len(added) > 0 || len(removed) > 0 {
+		log.Trace("SSH keys of %s synchronized from LDAP(%s): %d added, %d removed", u.Name, cfg.Name, len(added), len(removed))
+	}
+}
+
+// Prevent duplicate tasks.
+var isLDAPKeysSyncing = false
+
+// SyncLDAPPublicKeys synchronizes SSH keys of all users of active LDAP sources
+// that have key attribute set.
+func SyncLDAPPublicKeys() {
+	if isLDAPKeysSyncing {
+		return
+	}
+	isLDAPKeysSyncing = true
+	defer func() { isLDAPKeysSyncing = false }()
+
+	sources := make([]*LoginSource, 0, 5)
+	if err := x.UseBool().Find(&sources, &LoginSource{Type: LDAP, IsActived: true}); err != nil {
+		log.Error(4, "SyncLDAPPublicKeys: %v", err)
+		return
+	}
+	for _, source := range sources {
+		cfg := source.LDAP()
+		if len(cfg.AttributeSSHPublicKey) == 0 {
+			continue
+		}
+
+		users := make([]*User, 0, 10)
+		if err := x.Find(&users, &User{LoginType: LDAP, LoginSource: source.Id}); err != nil {
+			log.Error(4, "SyncLDAPPublicKeys[%s]: %v", source.Name, err)
+			continue
+		}
+		for _, u := range users {
+			syncLDAPPublicKeys(u, "", source.Id, cfg)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestSyncExternalPublicKeysKeepsManualKeys(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	u := &User{Id: 1, Name: "user1"}
+	keys := []*PublicKey{
+		{OwnerId: 1, Name: "manual", Fingerprint: "fingerprint1", FingerprintSha256: "SHA256:manual", Content: "ssh-rsa AAAAB3NzaC1yc2E user1@laptop"},
+		{OwnerId: 1, Name: "ldap:corp", Fingerprint: "fingerprint2", FingerprintSha256: "SHA256:ldap", Content: "ssh-rsa AAAAB3NzaC1yc2F user1@ldap", LoginSource: 3},
+		{OwnerId: 1, Name: "ldap:other", Fingerprint: "fingerprint3", FingerprintSha256: "SHA256:other", Content: "ssh-rsa AAAAB3NzaC1yc2G user1@other", LoginSource: 4},
+	}
+	for _, key := range keys {
+		if _, err = x.Insert(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = RewriteAllPublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = UpdatePublicKeyName(1, keys[1].Id, "renamed"); err != ErrKeyExternallyManaged {
+		t.Errorf("expect ErrKeyExternallyManaged but got %v", err)
+	}
+
+	// Key no longer listed in source is removed, keys of user and other sources are kept.
+	added, removed, err := SyncExternalPublicKeys(u, &PublicKey{LoginSource: 3}, "ldap:corp", nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(added) != 0 || len(removed) != 1 {
+		t.Errorf("expect 0 added and 1 removed but got %d and %d", len(added), len(removed))
+	}
+	if _, err = GetPublicKeyById(keys[1].Id); err != ErrKeyNotExist {
+		t.Errorf("expect synced key to be deleted but got %v", err)
+	}
+	for _, key := range []*PublicKey{keys[0], keys[2]} {
+		if _, err = GetPublicKeyById(key.Id); err != nil {
+			t.Errorf("expect key %q to be kept but got %v", key.Name, err)
+		}
+	}
+}
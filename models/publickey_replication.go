@@ -0,0 +1,370 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/process"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// KeyReplica represents state of replicating authorized_keys file to a configured target.
+type KeyReplica struct {
+	Id          int64
+	Target      string `xorm:"UNIQUE NOT NULL"`
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Failures    int    // Number of consecutive failed replications.
+	LastError   string `xorm:"TEXT"`
+}
+
+// HasSynced returns true if authorized_keys file has been copied to target at least once.
+func (r *KeyReplica) HasSynced() bool {
+	return !r.LastSuccess.IsZero()
+}
+
+// IsFailing returns true if last replication to target has failed.
+func (r *KeyReplica) IsFailing() bool {
+	return r.Failures > 0
+}
+
+// keyReplicaTarget represents a parsed destination of authorized_keys file replication,
+// scheme is empty for a local path.
+type keyReplicaTarget struct {
+	Raw    string
+	Scheme string
+	User   string
+	Host   string
+	Port   string
+	Path   string
+}
+
+// parseKeyReplicaTarget parses an absolute local path,
+// or an URL in form of "scp://[user@]host[:port]/path" or "sftp://[user@]host[:port]/path".
+func parseKeyReplicaTarget(raw string) (*keyReplicaTarget, error) {
+	if filepath.IsAbs(raw) {
+		return &keyReplicaTarget{Raw: raw, Path: filepath.Clean(raw)}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replication target %q: %v", raw, err)
+	} else if u.Scheme != "scp" && u.Scheme != "sftp" {
+		return nil, fmt.Errorf("invalid replication target %q: must be an absolute path, scp:// or sftp:// URL", raw)
+	} else if u.User != nil {
+		if _, has := u.User.Password(); has {
+			return nil, fmt.Errorf("invalid replication target %q: password must not be part of target", raw)
+		}
+	}
+
+	t := &keyReplicaTarget{
+		Raw:    raw,
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Port:   "22",
+		Path:   u.Path,
+	}
+	if u.User != nil {
+		t.User = u.User.Username()
+	}
+	if i := strings.LastIndex(u.Host, ":"); i > -1 {
+		t.Host, t.Port = u.Host[:i], u.Host[i+1:]
+	}
+	if len(t.Host) == 0 || len(t.Path) <= 1 || strings.HasPrefix(t.Host, "-") {
+		return nil, fmt.Errorf("invalid replication target %q: host and path are required", raw)
+	}
+	return t, nil
+}
+
+// keyReplicaTargets returns parsed targets of configuration.
+func keyReplicaTargets() ([]*keyReplicaTarget, error) {
+	targets := make([]*keyReplicaTarget, 0, len(setting.SSHReplication.Targets))
+	for _, raw := range setting.SSHReplication.Targets {
+		t, err := parseKeyReplicaTarget(raw)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// remoteAddr returns address of target in form accepted by scp and sftp.
+func (t *keyReplicaTarget) remoteAddr() string {
+	if len(t.User) > 0 {
+		return t.User + "@" + t.Host
+	}
+	return t.Host
+}
+
+// sshArgs returns options of scp and sftp to connect to target without any prompt,
+// credentials are taken from configuration.
+func (t *keyReplicaTarget) sshArgs() []string {
+	args := []string{"-P", t.Port, "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes"}
+	if len(setting.SSHReplication.IdentityFile) > 0 {
+		args = append(args, "-i", setting.SSHReplication.IdentityFile)
+	}
+	if len(setting.SSHReplication.KnownHostsFile) > 0 {
+		args = append(args, "-o", "UserKnownHostsFile="+setting.SSHReplication.KnownHostsFile)
+	}
+	return args
+}
+
+// copyLocal copies file to local target, it is written to a temporary file
+// next to target first so readers never see a partial file.
+func (t *keyReplicaTarget) copyLocal(src string) error {
+	fr, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	tmpPath := t.Path + ".tmp"
+	fw, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	_, err = io.Copy(fw, fr)
+	if cerr := fw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, t.Path)
+}
+
+// copyFile copies file to target.
+func (t *keyReplicaTarget) copyFile(src string) error {
+	desc := fmt.Sprintf("ReplicateAuthorizedKeys: %s", t.Raw)
+	timeout := setting.SSHReplication.Timeout
+
+	var stderr string
+	var err error
+	switch t.Scheme {
+	case "":
+		return t.copyLocal(src)
+	case "scp":
+		args := append(t.sshArgs(), "-q", src, t.remoteAddr()+":"+t.Path)
+		_, stderr, err = process.ExecTimeout(timeout, desc, "scp", args...)
+	case "sftp":
+		// Upload to a temporary file and rename it,
+		// so the SSH server of target never reads a partial file.
+		var batch *os.File
+		if batch, err = ioutil.TempFile("", "gogs-replication"); err != nil {
+			return err
+		}
+		defer os.Remove(batch.Name())
+		fmt.Fprintf(batch, "put %q %q\nchmod 600 %q\nrename %q %q\n",
+			src, t.Path+".tmp", t.Path+".tmp", t.Path+".tmp", t.Path)
+		batch.Close()
+
+		args := append(t.sshArgs(), "-q", "-b", batch.Name(), t.remoteAddr())
+		_, stderr, err = process.ExecTimeout(timeout, desc, "sftp", args...)
+	}
+	if err != nil {
+		return fmt.Errorf("%v - %s", err, strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// replicateToTarget copies file to target, failed copies are retried
+// with an interval that doubles on every retry.
+func replicateToTarget(src string, t *keyReplicaTarget) (err error) {
+	interval := setting.SSHReplication.RetryInterval
+	for i := 0; ; i++ {
+		if err = t.copyFile(src); err == nil || i >= setting.SSHReplication.MaxRetries {
+			return err
+		}
+		log.Warn("Replicate authorized_keys to %s (attempt %d): %v", t.Raw, i+1, err)
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
+// updateKeyReplica records result of replication to target. Failures are logged,
+// and a system notice is created when a target starts failing.
+func updateKeyReplica(target string, attempted time.Time, err error) error {
+	r := &KeyReplica{Target: target}
+	has, gerr := x.Get(r)
+	if gerr != nil {
+		return gerr
+	}
+
+	r.LastAttempt = attempted
+	if err == nil {
+		r.LastSuccess = attempted
+		r.Failures, r.LastError = 0, ""
+	} else {
+		r.Failures++
+		r.LastError = err.Error()
+		log.Error(4, "Fail to replicate authorized_keys to %s: %v", target, err)
+		if r.Failures == 1 {
+			desc := fmt.Sprintf("Fail to replicate authorized_keys to %s: %v", target, err)
+			if nerr := CreateNotice(NOTICE_KEY_REPLICATION, desc); nerr != nil {
+				log.Error(4, "CreateNotice: %v", nerr)
+			}
+		}
+	}
+
+	if !has {
+		_, err = x.Insert(r)
+	} else {
+		_, err = x.Id(r.Id).AllCols().Update(r)
+	}
+	return err
+}
+
+// snapshotAuthorizedKeys copies authorized_keys file to a temporary file,
+// so it is not changed while being replicated.
+func snapshotAuthorizedKeys() (string, error) {
+	sshOpLocker.Lock()
+	defer sshOpLocker.Unlock()
+
+	fr, err := os.Open(filepath.Join(SSHPath, "authorized_keys"))
+	if err != nil {
+		return "", err
+	}
+	defer fr.Close()
+
+	fw, err := ioutil.TempFile(SSHPath, "authorized_keys.replica")
+	if err != nil {
+		return "", err
+	}
+	defer fw.Close()
+
+	if _, err = io.Copy(fw, fr); err != nil {
+		os.Remove(fw.Name())
+		return "", err
+	}
+	return fw.Name(), nil
+}
+
+// Prevent duplicate tasks, and show state in admin panel.
+var isKeyReplicating = false
+
+// IsKeyReplicating returns true if authorized_keys file is being replicated.
+func IsKeyReplicating() bool {
+	return isKeyReplicating
+}
+
+// ReplicateAuthorizedKeys copies current authorized_keys file to all configured targets
+// in parallel, and records results. It blocks until all targets succeeded or ran out of retries.
+func ReplicateAuthorizedKeys() {
+	if !setting.SSHReplication.Enabled || !isAuthorizedKeysManaged() || isKeyReplicating {
+		return
+	}
+	isKeyReplicating = true
+	defer func() { isKeyReplicating = false }()
+
+	targets, err := keyReplicaTargets()
+	if err != nil {
+		log.Error(4, "Fail to replicate authorized_keys: %v", err)
+		return
+	}
+	src, err := snapshotAuthorizedKeys()
+	if err != nil {
+		log.Error(4, "Fail to replicate authorized_keys: snapshot: %v", err)
+		return
+	}
+	defer os.Remove(src)
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t *keyReplicaTarget) {
+			defer wg.Done()
+			start := time.Now()
+			err := replicateToTarget(src, t)
+			if err = updateKeyReplica(t.Raw, start, err); err != nil {
+				log.Error(4, "updateKeyReplica[%s]: %v", t.Raw, err)
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// keyReplicaQueue holds at most one pending replication, changes made while
+// a replication is running are picked up by the single pending one.
+var keyReplicaQueue chan bool
+
+// QueueKeyReplication asynchronously replicates authorized_keys file,
+// it returns false when replication is not enabled.
+func QueueKeyReplication() bool {
+	if keyReplicaQueue == nil {
+		return false
+	}
+	select {
+	case keyReplicaQueue <- true:
+	default:
+	}
+	return true
+}
+
+// NewKeyReplicationContext validates replication targets and starts replicating
+// authorized_keys file in background whenever it changes.
+func NewKeyReplicationContext() {
+	if !setting.SSHReplication.Enabled {
+		return
+	}
+	if _, err := keyReplicaTargets(); err != nil {
+		log.Fatal(4, "Fail to load SSH replication targets: %v", err)
+	}
+
+	keyReplicaQueue = make(chan bool, 1)
+	go func() {
+		for _ = range keyReplicaQueue {
+			ReplicateAuthorizedKeys()
+		}
+	}()
+
+	// Bring targets up to date with changes made while server was not running.
+	QueueKeyReplication()
+}
+
+// GetKeyReplicas returns replication states of all configured targets,
+// targets that have never been replicated have zero states.
+func GetKeyReplicas() ([]*KeyReplica, error) {
+	replicas := make([]*KeyReplica, 0, len(setting.SSHReplication.Targets))
+	for _, target := range setting.SSHReplication.Targets {
+		r := &KeyReplica{Target: target}
+		if _, err := x.Get(r); err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	return replicas, nil
+}
+
+// CountFailingKeyReplicas returns number of configured targets whose last replication failed.
+func CountFailingKeyReplicas() int {
+	if !setting.SSHReplication.Enabled {
+		return 0
+	}
+	replicas, err := GetKeyReplicas()
+	if err != nil {
+		log.Error(4, "GetKeyReplicas: %v", err)
+		return 0
+	}
+	count := 0
+	for _, r := range replicas {
+		if r.IsFailing() {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,383 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/httplib"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var (
+	ErrKeySourceNotExist      = errors.New("Key source does not exist")
+	ErrKeySourceURLInvalid    = errors.New("Key source URL must be an absolute HTTPS URL")
+	ErrKeySourceURLNotAllowed = errors.New("Key source URL must not point to a private network address")
+)
+
+// ErrKeySourceFetch represents a failure to fetch public keys from key source URL.
+type ErrKeySourceFetch struct {
+	Url string
+	Err error
+}
+
+func (err ErrKeySourceFetch) Error() string {
+	return fmt.Sprintf("Fail to fetch public keys from %s: %v", err.Url, err.Err)
+}
+
+func IsErrKeySourceFetch(err error) bool {
+	_, ok := err.(ErrKeySourceFetch)
+	return ok
+}
+
+const (
+	// Published authorized_keys files rarely have more than a handful keys, larger responses are rejected.
+	keySourceMaxSize = 64 << 10
+
+	keySourceSyncInterval = time.Hour
+	keySourceMaxBackoff   = 24 * time.Hour
+)
+
+// KeySource represents an URL where user publishes its public keys in authorized_keys format,
+// keys are periodically synchronized from it.
+type KeySource struct {
+	Id           int64
+	OwnerId      int64  `xorm:"UNIQUE NOT NULL"`
+	Url          string `xorm:"TEXT NOT NULL"`
+	ETag         string // Validators of last fetched response, for conditional requests.
+	LastModified string
+	Failures     int       // Number of consecutive failed fetches.
+	LastError    string    `xorm:"TEXT"`
+	LastSync     time.Time // Last time keys were fetched successfully.
+	NextSync     time.Time `xorm:"INDEX"`
+	Created      time.Time `xorm:"CREATED"`
+}
+
+// HasSynced returns true if keys have been fetched successfully at least once.
+func (s *KeySource) HasSynced() bool {
+	return !s.LastSync.IsZero()
+}
+
+// Networks that are not reachable from internet, fetching from them would let users
+// probe services next to the server.
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// isPublicIP returns false if given address is loopback, link-local or in a private network.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupIP is replaced in tests so that they do not depend on DNS.
+var lookupIP = net.LookupIP
+
+// resolvePublicHost returns addresses of given host, it fails if any of them is not public.
+func resolvePublicHost(host string) ([]net.IP, error) {
+	ips, err := lookupIP(host)
+	if err != nil {
+		return nil, err
+	} else if len(ips) == 0 {
+		return nil, fmt.Errorf("no address of host %s", host)
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return nil, ErrKeySourceURLNotAllowed
+		}
+	}
+	return ips, nil
+}
+
+// urlHost returns host of URL without port.
+func urlHost(u *url.URL) string {
+	if host, _, err := net.SplitHostPort(u.Host); err == nil {
+		return host
+	}
+	return strings.Trim(u.Host, "[]")
+}
+
+// validateKeySourceURL returns error if given address is not an absolute HTTPS URL,
+// or its host resolves to an address that is not public.
+func validateKeySourceURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+		return ErrKeySourceURLInvalid
+	}
+	if _, err = resolvePublicHost(urlHost(u)); err != nil {
+		if err == ErrKeySourceURLNotAllowed {
+			return err
+		}
+		return ErrKeySourceURLInvalid
+	}
+	return nil
+}
+
+// keySourceTransport checks host of every request, including the ones of redirects,
+// before it is sent.
+type keySourceTransport struct {
+	*http.Transport
+}
+
+func (t *keySourceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("redirected to non-HTTPS URL %s", req.URL)
+	} else if _, err := resolvePublicHost(urlHost(req.URL)); err != nil {
+		return nil, err
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// newKeySourceTransport returns transport that only connects to public addresses.
+// Without proxy, connection is made to the address that has been checked,
+// so host cannot resolve to another one in between.
+func newKeySourceTransport() http.RoundTripper {
+	dial := httplib.TimeoutDialer(10*time.Second, 30*time.Second)
+	t := &http.Transport{Proxy: setting.Proxy, Dial: dial}
+	if setting.ProxyURL == nil {
+		t.Dial = func(netw, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			ips, err := resolvePublicHost(host)
+			if err != nil {
+				return nil, err
+			}
+			return dial(netw, net.JoinHostPort(ips[0].String(), port))
+		}
+	}
+	return &keySourceTransport{t}
+}
+
+// GetKeySourceByOwner returns key source of given user.
+func GetKeySourceByOwner(ownerId int64) (*KeySource, error) {
+	s := &KeySource{OwnerId: ownerId}
+	has, err := x.Get(s)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrKeySourceNotExist
+	}
+	return s, nil
+}
+
+// SetKeySource sets key source URL of given user, state of previous URL is reset
+// and keys are fetched by next synchronization.
+func SetKeySource(ownerId int64, rawurl string) (*KeySource, error) {
+	if err := validateKeySourceURL(rawurl); err != nil {
+		return nil, err
+	}
+
+	s, err := GetKeySourceByOwner(ownerId)
+	if err == ErrKeySourceNotExist {
+		s = &KeySource{OwnerId: ownerId, Url: rawurl, NextSync: time.Now()}
+		_, err = x.Insert(s)
+		return s, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Keys previously synchronized from old URL stay with the source,
+	// so the ones not published at new URL are removed by next synchronization.
+	s.Url = rawurl
+	s.ETag, s.LastModified = "", ""
+	s.Failures, s.LastError = 0, ""
+	s.NextSync = time.Now()
+	_, err = x.Id(s.Id).AllCols().Update(s)
+	return s, err
+}
+
+// DeleteKeySource deletes key source of given user and keys synchronized from it.
+func DeleteKeySource(ownerId int64) error {
+	s, err := GetKeySourceByOwner(ownerId)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*PublicKey, 0, 5)
+	if err = x.Find(&keys, &PublicKey{OwnerId: ownerId, KeySourceId: s.Id}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = DeletePublicKey(ownerId, key.Id, SecurityActorSystem); err != nil {
+			return fmt.Errorf("DeletePublicKey[%d]: %v", key.Id, err)
+		}
+	}
+
+	_, err = x.Id(s.Id).Delete(new(KeySource))
+	return err
+}
+
+// fetchKeySource returns non-empty and non-comment lines published at key source URL,
+// notModified is true when content has not changed since last fetch.
+func fetchKeySource(s *KeySource) (lines []string, notModified bool, err error) {
+	req := httplib.Get(s.Url).SetTransport(newKeySourceTransport())
+	if len(s.ETag) > 0 {
+		req.Header("If-None-Match", s.ETag)
+	}
+	if len(s.LastModified) > 0 {
+		req.Header("If-Modified-Since", s.LastModified)
+	}
+	resp, err := req.Response()
+	if err != nil {
+		return nil, false, ErrKeySourceFetch{s.Url, err}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+	case 304:
+		return nil, true, nil
+	default:
+		return nil, false, ErrKeySourceFetch{s.Url, fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, keySourceMaxSize+1))
+	if err != nil {
+		return nil, false, ErrKeySourceFetch{s.Url, err}
+	} else if len(data) > keySourceMaxSize {
+		return nil, false, ErrKeySourceFetch{s.Url, fmt.Errorf("response exceeds %d bytes", keySourceMaxSize)}
+	}
+	s.ETag = resp.Header.Get("ETag")
+	s.LastModified = resp.Header.Get("Last-Modified")
+
+	lines = make([]string, 0, 5)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, false, ErrKeySourceFetch{s.Url, err}
+	}
+	return lines, false, nil
+}
+
+// keySourceBackoff returns how long to wait before next fetch after given number
+// of consecutive failures, the wait doubles on every failure up to a limit.
+func keySourceBackoff(failures int) time.Duration {
+	backoff := keySourceSyncInterval
+	for i := 1; i < failures && backoff < keySourceMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > keySourceMaxBackoff {
+		backoff = keySourceMaxBackoff
+	}
+	return backoff
+}
+
+// SyncKeySource fetches keys published at key source URL and makes keys synchronized from it
+// match them. Failures are recorded in source and retried with backoff, keys are left
+// unchanged in that case.
+func SyncKeySource(s *KeySource) (added, removed []*PublicKey, err error) {
+	u, err := GetUserById(s.OwnerId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetUserById[%d]: %v", s.OwnerId, err)
+	}
+
+	lines, notModified, err := fetchKeySource(s)
+	if err == nil && !notModified {
+		prefix := "url"
+		if su, perr := url.Parse(s.Url); perr == nil {
+			prefix += ":" + su.Host
+		}
+		added, removed, err = SyncExternalPublicKeys(u, &PublicKey{KeySourceId: s.Id}, prefix, lines)
+	}
+	if err != nil {
+		// Keys may not match fetched content, so next fetch must not be conditional.
+		s.ETag, s.LastModified = "", ""
+		s.Failures++
+		s.LastError = err.Error()
+		s.NextSync = time.Now().Add(keySourceBackoff(s.Failures))
+	} else {
+		s.Failures, s.LastError = 0, ""
+		s.LastSync = time.Now()
+		s.NextSync = s.LastSync.Add(keySourceSyncInterval)
+	}
+
+	if _, uerr := x.Id(s.Id).AllCols().Update(s); uerr != nil {
+		return added, removed, fmt.Errorf("update key source: %v", uerr)
+	}
+	return added, removed, err
+}
+
+// KeySourceChange represents keys changed by synchronization of a key source.
+type KeySourceChange struct {
+	Owner   *User
+	Source  *KeySource
+	Added   []*PublicKey
+	Removed []*PublicKey
+}
+
+// Prevent duplicate tasks.
+var isKeySourcesSyncing = false
+
+// SyncKeySources synchronizes all key sources that are due,
+// and returns the ones that changed keys of their owners.
+func SyncKeySources() []*KeySourceChange {
+	if isKeySourcesSyncing {
+		return nil
+	}
+	isKeySourcesSyncing = true
+	defer func() { isKeySourcesSyncing = false }()
+
+	sources := make([]*KeySource, 0, 10)
+	if err := x.Where("next_sync <= ?", time.Now()).Find(&sources); err != nil {
+		log.Error(4, "SyncKeySources: %v", err)
+		return nil
+	}
+
+	changes := make([]*KeySourceChange, 0, len(sources))
+	for _, s := range sources {
+		added, removed, err := SyncKeySource(s)
+		if err != nil {
+			log.Warn("SyncKeySource[%d]: %v", s.Id, err)
+		}
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+
+		u, err := GetUserById(s.OwnerId)
+		if err != nil {
+			log.Error(4, "GetUserById[%d]: %v", s.OwnerId, err)
+			continue
+		}
+		changes = append(changes, &KeySourceChange{u, s, added, removed})
+	}
+	return changes
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// stubLookupIP resolves hosts from given map instead of DNS until returned function is called.
+func stubLookupIP(hosts map[string]string) func() {
+	oldLookupIP := lookupIP
+	lookupIP = func(host string) ([]net.IP, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IP{ip}, nil
+		} else if addr, ok := hosts[host]; ok {
+			return []net.IP{net.ParseIP(addr)}, nil
+		}
+		return nil, fmt.Errorf("no such host: %s", host)
+	}
+	return func() { lookupIP = oldLookupIP }
+}
+
+func TestValidateKeySourceURL(t *testing.T) {
+	defer stubLookupIP(map[string]string{
+		"example.com":    "93.184.216.34",
+		"intranet.local": "10.1.2.3",
+	})()
+
+	for rawurl, expect := range map[string]error{
+		"https://example.com/keys":        nil,
+		"https://example.com:8443/u/keys": nil,
+		"http://example.com/keys":         ErrKeySourceURLInvalid,
+		"ftp://example.com/keys":          ErrKeySourceURLInvalid,
+		"https:///keys":                   ErrKeySourceURLInvalid,
+		"example.com/keys":                ErrKeySourceURLInvalid,
+		"":                                ErrKeySourceURLInvalid,
+		"https://unknown.example/keys":    ErrKeySourceURLInvalid,
+		"https://intranet.local/keys":     ErrKeySourceURLNotAllowed,
+		"https://127.0.0.1/keys":          ErrKeySourceURLNotAllowed,
+		"https://169.254.169.254/keys":    ErrKeySourceURLNotAllowed,
+		"https://[::1]:8443/keys":         ErrKeySourceURLNotAllowed,
+		"https://[fd00::1]/keys":          ErrKeySourceURLNotAllowed,
+	} {
+		if err := validateKeySourceURL(rawurl); err != expect {
+			t.Errorf("validateKeySourceURL(%q): expect %v but got %v", rawurl, expect, err)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"172.32.0.1":      true,
+		"2606:4700::1":    true,
+		"10.0.0.1":        false,
+		"172.16.5.4":      false,
+		"192.168.1.1":     false,
+		"100.64.0.1":      false,
+		"127.0.0.1":       false,
+		"169.254.1.1":     false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := isPublicIP(net.ParseIP(addr)); got != public {
+			t.Errorf("isPublicIP(%s): expect %v but got %v", addr, public, got)
+		}
+	}
+}
+
+func TestKeySourceTransport(t *testing.T) {
+	defer stubLookupIP(map[string]string{"intranet.local": "192.168.0.10"})()
+
+	// Redirects go through transport as well, so both are refused before connecting.
+	transport := newKeySourceTransport()
+	for _, rawurl := range []string{"https://intranet.local/keys", "http://93.184.216.34/keys"} {
+		req, err := http.NewRequest("GET", rawurl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = transport.RoundTrip(req); err == nil {
+			t.Errorf("expect request to %s to be refused", rawurl)
+		}
+	}
+}
+
+func TestKeySourceBackoff(t *testing.T) {
+	for failures, expect := range map[int]time.Duration{
+		1:  keySourceSyncInterval,
+		2:  2 * keySourceSyncInterval,
+		3:  4 * keySourceSyncInterval,
+		5:  16 * keySourceSyncInterval,
+		6:  keySourceMaxBackoff,
+		50: keySourceMaxBackoff,
+	} {
+		if backoff := keySourceBackoff(failures); backoff != expect {
+			t.Errorf("keySourceBackoff(%d): expect %v but got %v", failures, expect, backoff)
+		}
+	}
+}
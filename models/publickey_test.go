@@ -0,0 +1,168 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestKeyActivityNeedsUpdate(t *testing.T) {
+	now := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	interval := time.Hour
+
+	cases := []struct {
+		desc    string
+		updated time.Time
+		need    bool
+	}{
+		{"never used", time.Time{}, true},
+		{"just used", now, false},
+		{"one second before interval", now.Add(-interval + time.Second), false},
+		{"exactly at interval", now.Add(-interval), true},
+		{"one second after interval", now.Add(-interval - time.Second), true},
+		{"updated in the future", now.Add(time.Minute), false},
+	}
+	for _, c := range cases {
+		if need := keyActivityNeedsUpdate(c.updated, now, interval); need != c.need {
+			t.Errorf("%s: expect %v but got %v", c.desc, c.need, need)
+		}
+	}
+}
+
+func TestKeyActivityNeedsUpdateZeroInterval(t *testing.T) {
+	now := time.Now()
+	if !keyActivityNeedsUpdate(now, now, 0) {
+		t.Error("zero interval should always update")
+	}
+}
+
+func TestKeyActivityIntervalIsCapped(t *testing.T) {
+	now := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	updated := now.Add(-25 * time.Hour)
+	if !keyActivityNeedsUpdate(updated, now, 30*24*time.Hour) {
+		t.Error("interval longer than one day should be capped")
+	}
+}
+
+func TestKeyHasRecentActivityWithThrottle(t *testing.T) {
+	now := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	interval := time.Hour
+
+	// A skipped write leaves a timestamp at most one interval old,
+	// which must still count as recent activity.
+	updated := now.Add(-interval + time.Second)
+	if keyActivityNeedsUpdate(updated, now, interval) {
+		t.Fatal("write should be throttled")
+	}
+	if !keyHasRecentActivity(updated, now) {
+		t.Error("throttled key should have recent activity")
+	}
+
+	if !keyHasRecentActivity(now.Add(-_KEY_RECENT_ACTIVITY_WINDOW+time.Second), now) {
+		t.Error("key used just within window should have recent activity")
+	}
+	if keyHasRecentActivity(now.Add(-_KEY_RECENT_ACTIVITY_WINDOW), now) {
+		t.Error("key used exactly at window boundary should not have recent activity")
+	}
+	if keyHasRecentActivity(time.Time{}, now) {
+		t.Error("unused key should not have recent activity")
+	}
+}
+
+func TestPublicKeyMeetsPolicy(t *testing.T) {
+	cases := []struct {
+		desc  string
+		key   *PublicKey
+		meets bool
+	}{
+		{"strong RSA", &PublicKey{Type: "RSA", Size: 4096}, true},
+		{"minimum RSA", &PublicKey{Type: "RSA", Size: 2048}, true},
+		{"weak RSA", &PublicKey{Type: "RSA", Size: 1024}, false},
+		{"unknown type", &PublicKey{Type: "RSA1", Size: 4096}, false},
+		{"type not recorded", &PublicKey{}, true},
+	}
+	for _, c := range cases {
+		if meets := c.key.MeetsPolicy(); meets != c.meets {
+			t.Errorf("%s: expect %v but got %v", c.desc, c.meets, meets)
+		}
+	}
+}
+
+func TestKeyPolicyVersion(t *testing.T) {
+	version := KeyPolicyVersion()
+	if version != KeyPolicyVersion() {
+		t.Fatal("policy version should be stable")
+	}
+
+	MinimumKeySize["(RSA)"] = 3072
+	defer func() { MinimumKeySize["(RSA)"] = 2048 }()
+	if version == KeyPolicyVersion() {
+		t.Error("policy version should change after policy changed")
+	}
+}
+
+func TestLoadSSHPath(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-ssh-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldRootPath, oldSSHPath := setting.SSHRootPath, SSHPath
+	defer func() {
+		setting.SSHRootPath, SSHPath = oldRootPath, oldSSHPath
+	}()
+
+	setting.SSHRootPath = filepath.Join(tmpDir, "keys")
+	loadSSHPath()
+	if SSHPath != setting.SSHRootPath {
+		t.Fatalf("expect SSH path %s but got %s", setting.SSHRootPath, SSHPath)
+	}
+	if err = CheckSSHPath(); err != nil {
+		t.Errorf("expect configured SSH path to be writable: %v", err)
+	}
+
+	SSHPath = filepath.Join(tmpDir, "missing")
+	if err = CheckSSHPath(); err == nil {
+		t.Errorf("expect error for missing SSH path")
+	}
+}
+
+func TestPublicKeyExpiresAt(t *testing.T) {
+	oldVerify, oldExpire, oldGrace := setting.RequireSSHKeyVerify, setting.UnverifiedSSHKeyExpire, setting.SSHKeyPolicyGraceDays
+	defer func() {
+		setting.RequireSSHKeyVerify, setting.UnverifiedSSHKeyExpire, setting.SSHKeyPolicyGraceDays = oldVerify, oldExpire, oldGrace
+	}()
+	setting.UnverifiedSSHKeyExpire, setting.SSHKeyPolicyGraceDays = 7, 0
+
+	created := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	key := &PublicKey{Created: created}
+	setting.RequireSSHKeyVerify = false
+	if expires := key.ExpiresAt(); !expires.IsZero() {
+		t.Errorf("expect unverified key not to expire when verification is not required but got %v", expires)
+	}
+
+	setting.RequireSSHKeyVerify = true
+	if expires := key.ExpiresAt(); !expires.Equal(created.AddDate(0, 0, 7)) {
+		t.Errorf("expect unverified key to expire after 7 days but got %v", expires)
+	}
+	key.Verified = true
+	if expires := key.ExpiresAt(); !expires.IsZero() {
+		t.Errorf("expect verified key not to expire but got %v", expires)
+	}
+}
+
+func TestPublicKeyVerifyCommand(t *testing.T) {
+	key := &PublicKey{VerifyToken: "token"}
+	if cmd := key.VerifyCommand(); cmd != "echo -n 'token' | ssh-keygen -Y sign -n "+_KEY_VERIFY_NAMESPACE+" -f /path/to/private_key" {
+		t.Errorf("unexpected verify command: %s", cmd)
+	}
+}
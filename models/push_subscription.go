@@ -0,0 +1,223 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/process"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// PushSubscription represents a subscription of user to e-mail notifications
+// of pushes to a repository.
+type PushSubscription struct {
+	Id       int64
+	RepoId   int64  `xorm:"UNIQUE(s)"`
+	UserId   int64  `xorm:"UNIQUE(s) INDEX"`
+	Branches string `xorm:"TEXT"` // Comma-separated branch names, empty means all branches.
+}
+
+// BranchList returns list of subscribed branches, empty list means all branches.
+func (s *PushSubscription) BranchList() []string {
+	branches := make([]string, 0, 3)
+	for _, b := range strings.Split(s.Branches, ",") {
+		if b = strings.TrimSpace(b); len(b) > 0 {
+			branches = append(branches, b)
+		}
+	}
+	return branches
+}
+
+// MatchBranch returns true if pushes to given branch are subscribed.
+func (s *PushSubscription) MatchBranch(branch string) bool {
+	branches := s.BranchList()
+	if len(branches) == 0 {
+		return true
+	}
+	for _, b := range branches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPushSubscription returns push subscription of user to given repository,
+// it returns nil if user has not subscribed.
+func GetPushSubscription(uid, repoId int64) (*PushSubscription, error) {
+	s := &PushSubscription{RepoId: repoId, UserId: uid}
+	has, err := x.Get(s)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// SubscribePush subscribes user to pushes of given branches of repository,
+// or updates branches of existing subscription.
+func SubscribePush(uid, repoId int64, branches string) error {
+	s := &PushSubscription{RepoId: repoId, UserId: uid, Branches: branches}
+	s.Branches = strings.Join(s.BranchList(), ",")
+
+	has, err := x.Get(&PushSubscription{RepoId: repoId, UserId: uid})
+	if err != nil {
+		return err
+	}
+
+	if has {
+		_, err = x.Where("repo_id=? AND user_id=?", repoId, uid).Cols("branches").Update(s)
+	} else {
+		_, err = x.Insert(s)
+	}
+	return err
+}
+
+// UnsubscribePush removes push subscription of user to given repository.
+func UnsubscribePush(uid, repoId int64) error {
+	_, err := x.Delete(&PushSubscription{RepoId: repoId, UserId: uid})
+	return err
+}
+
+// GetPushSubscribers returns users who subscribed to pushes of given branch of repository
+// and should be notified by e-mail: bots, inactive users, users who turned off e-mail
+// notifications and users who can no longer read repository are skipped.
+func GetPushSubscribers(repoId int64, branch string) ([]*User, error) {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return nil, err
+	}
+
+	subs := make([]*PushSubscription, 0, 10)
+	if err = x.Where("repo_id=?", repoId).Find(&subs); err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, 0, len(subs))
+	for _, s := range subs {
+		if !s.MatchBranch(branch) {
+			continue
+		}
+
+		u, err := GetUserById(s.UserId)
+		if err != nil {
+			if err == ErrUserNotExist {
+				continue
+			}
+			return nil, err
+		} else if u.IsBot || !u.IsActive || !u.EnableEmailNotification || len(u.Email) == 0 {
+			continue
+		}
+
+		if has, err := HasAccess(u, repo, ACCESS_MODE_READ); err != nil {
+			return nil, err
+		} else if !has {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// PushNotifyTask represents a push waiting to be notified to subscribers by e-mail.
+// Pushes are queued by update of repository and notified by web server,
+// so git client does not wait for e-mails to be sent.
+type PushNotifyTask struct {
+	Id        int64
+	RepoId    int64
+	Branch    string
+	Pusher    string
+	CommitIds string    `xorm:"TEXT"` // Space-separated IDs of pushed commits, from newest to oldest.
+	Created   time.Time `xorm:"CREATED"`
+}
+
+// CommitIdList returns list of IDs of pushed commits, from newest to oldest.
+func (t *PushNotifyTask) CommitIdList() []string {
+	return strings.Fields(t.CommitIds)
+}
+
+// newBranchCommitIds returns IDs of commits of a newly pushed branch
+// that are not reachable from any other branch of repository.
+func newBranchCommitIds(repoPath, refName, newCommitId string) (map[string]bool, error) {
+	stdout, stderr, err := process.ExecDir(-1, repoPath,
+		fmt.Sprintf("newBranchCommitIds(git for-each-ref): %s", repoPath),
+		"git", "for-each-ref", "--format=%(refname)", "refs/heads/")
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %v - %s", err, stderr)
+	}
+	args := []string{"rev-list", newCommitId}
+	for _, ref := range strings.Fields(stdout) {
+		if ref != refName {
+			args = append(args, "^"+ref)
+		}
+	}
+
+	stdout, stderr, err = process.ExecDir(-1, repoPath,
+		fmt.Sprintf("newBranchCommitIds(git rev-list): %s", repoPath), "git", args...)
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list: %v - %s", err, stderr)
+	}
+	ids := make(map[string]bool)
+	for _, id := range strings.Fields(stdout) {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// QueuePushNotification queues commits pushed to given ref of repository to be notified
+// to subscribers when e-mail notification is enabled and anyone subscribed to repository.
+// Commits of a new branch that already exist on other branches are left out.
+func QueuePushNotification(repo *Repository, repoPath, refName, oldCommitId, pusher string, commits []*git.Commit) error {
+	if !setting.Service.EnableNotifyMail || len(commits) == 0 {
+		return nil
+	}
+	if count, err := x.Where("repo_id=?", repo.Id).Count(new(PushSubscription)); err != nil {
+		return err
+	} else if count == 0 {
+		return nil
+	}
+
+	var newIds map[string]bool
+	if strings.HasPrefix(oldCommitId, "0000000") {
+		var err error
+		if newIds, err = newBranchCommitIds(repoPath, refName, commits[0].Id.String()); err != nil {
+			return err
+		}
+	}
+	ids := make([]string, 0, len(commits))
+	for _, c := range commits {
+		if id := c.Id.String(); newIds == nil || newIds[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := x.Insert(&PushNotifyTask{
+		RepoId:    repo.Id,
+		Branch:    git.RefEndName(refName),
+		Pusher:    pusher,
+		CommitIds: strings.Join(ids, " "),
+	})
+	return err
+}
+
+// GetPushNotifyTasks returns at most given number of queued pushes, oldest first.
+func GetPushNotifyTasks(limit int) ([]*PushNotifyTask, error) {
+	tasks := make([]*PushNotifyTask, 0, limit)
+	return tasks, x.Asc("id").Limit(limit).Find(&tasks)
+}
+
+// DeletePushNotifyTask removes queued push that has been notified.
+func DeletePushNotifyTask(id int64) error {
+	_, err := x.Id(id).Delete(new(PushNotifyTask))
+	return err
+}
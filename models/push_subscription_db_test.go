@@ -0,0 +1,84 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestQueuePushNotification(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(PushSubscription), new(PushNotifyTask))
+	defer cleanup()
+	var err error
+	setting.Service.EnableNotifyMail = true
+
+	// Branch feature has one commit on top of master.
+	repoPath := filepath.Join(tmpDir, "repo")
+	runGit(t, tmpDir, "init", repoPath)
+	for _, name := range []string{"README.md", "main.go"} {
+		if err = ioutil.WriteFile(filepath.Join(repoPath, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, repoPath, "add", name)
+		runGit(t, repoPath, "commit", "-m", "Add "+name)
+		if name == "README.md" {
+			runGit(t, repoPath, "branch", "-M", "master")
+			runGit(t, repoPath, "checkout", "-b", "feature")
+		}
+	}
+	gitRepo, err := git.OpenRepository(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits := make([]*git.Commit, 2)
+	for i, rev := range []string{"feature", "master"} {
+		if commits[i], err = gitRepo.GetCommit(runGit(t, repoPath, "rev-parse", rev)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo := &Repository{Id: 1}
+	const newBranch = "0000000000000000000000000000000000000000"
+	if err = QueuePushNotification(repo, repoPath, "refs/heads/feature", newBranch, "alice", commits); err != nil {
+		t.Fatal(err)
+	} else if count, err := x.Count(new(PushNotifyTask)); err != nil {
+		t.Fatal(err)
+	} else if count > 0 {
+		t.Fatalf("expect no push to be queued without subscribers but got %d", count)
+	}
+
+	if err = SubscribePush(2, repo.Id, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = QueuePushNotification(repo, repoPath, "refs/heads/feature", newBranch, "alice", commits); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := GetPushNotifyTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tasks) != 1 {
+		t.Fatalf("expect 1 queued push but got %d", len(tasks))
+	}
+	if ids := tasks[0].CommitIdList(); len(ids) != 1 || ids[0] != commits[0].Id.String() {
+		t.Errorf("expect only commit not on other branches but got %v", ids)
+	} else if tasks[0].Branch != "feature" || tasks[0].Pusher != "alice" {
+		t.Errorf("unexpected queued push: %+v", tasks[0])
+	}
+
+	if err = DeletePushNotifyTask(tasks[0].Id); err != nil {
+		t.Fatal(err)
+	} else if tasks, err = GetPushNotifyTasks(10); err != nil {
+		t.Fatal(err)
+	} else if len(tasks) > 0 {
+		t.Errorf("expect queued push to be deleted but got %d", len(tasks))
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPushSubscriptionMatchBranch(t *testing.T) {
+	cases := []struct {
+		branches string
+		list     []string
+		match    map[string]bool
+	}{
+		{"", []string{}, map[string]bool{"master": true, "dev": true}},
+		{" , ", []string{}, map[string]bool{"master": true}},
+		{"master", []string{"master"}, map[string]bool{"master": true, "dev": false}},
+		{"master, release/1.0 ,", []string{"master", "release/1.0"},
+			map[string]bool{"master": true, "release/1.0": true, "release": false, "master2": false}},
+	}
+	for _, c := range cases {
+		s := &PushSubscription{Branches: c.branches}
+		if list := s.BranchList(); strings.Join(list, ",") != strings.Join(c.list, ",") {
+			t.Errorf("%q: expect branches %v but got %v", c.branches, c.list, list)
+		}
+		for branch, expect := range c.match {
+			if s.MatchBranch(branch) != expect {
+				t.Errorf("%q: expect match of %s to be %v", c.branches, branch, expect)
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
@@ -30,16 +31,21 @@ import (
 )
 
 const (
-	_TPL_UPDATE_HOOK = "#!/usr/bin/env %s\n%s update $1 $2 $3 --config='%s'\n"
+	_TPL_UPDATE_HOOK = "\"%s\" update $1 $2 $3 --config='%s'"
 )
 
 var (
-	ErrRepoAlreadyExist  = errors.New("Repository already exist")
-	ErrRepoFileNotExist  = errors.New("Repository file does not exist")
-	ErrRepoNameIllegal   = errors.New("Repository name contains illegal characters")
-	ErrRepoFileNotLoaded = errors.New("Repository file not loaded")
-	ErrMirrorNotExist    = errors.New("Mirror does not exist")
-	ErrInvalidReference  = errors.New("Invalid reference specified")
+	ErrRepoAlreadyExist       = errors.New("Repository already exist")
+	ErrRepoFileNotExist       = errors.New("Repository file does not exist")
+	ErrRepoNameIllegal        = errors.New("Repository name contains illegal characters")
+	ErrRepoFileNotLoaded      = errors.New("Repository file not loaded")
+	ErrMirrorNotExist         = errors.New("Mirror does not exist")
+	ErrInvalidReference       = errors.New("Invalid reference specified")
+	ErrRepoAlreadyInitialized = errors.New("Repository has already been initialized")
+	ErrRepoNotTemplate        = errors.New("Repository is not a template")
+	ErrBranchNotExist         = errors.New("Branch does not exist")
+	ErrRepoTrashDisabled      = errors.New("Repository trash is not enabled")
+	ErrTrashRepoNotExist      = errors.New("Repository does not exist in trash")
 )
 
 var (
@@ -161,6 +167,17 @@ type Repository struct {
 	ForkId   int64
 	ForkRepo *Repository `xorm:"-"`
 
+	IsTemplate bool `xorm:"NOT NULL DEFAULT false"`
+
+	// Features that can be turned off, new repositories have them enabled.
+	// There is no wiki yet, HasWiki is only kept for API clients
+	// that expect the field GitHub has.
+	HasIssues bool `xorm:"NOT NULL DEFAULT true"`
+	HasWiki   bool `xorm:"NOT NULL DEFAULT true"`
+
+	// GitSize is the disk usage of repository objects in KB.
+	GitSize int64 `xorm:"NOT NULL DEFAULT 0"`
+
 	Created time.Time `xorm:"CREATED"`
 	Updated time.Time `xorm:"UPDATED"`
 }
@@ -221,6 +238,11 @@ func (repo *Repository) DescriptionHtml() template.HTML {
 	return template.HTML(DescPattern.ReplaceAllStringFunc(base.Sanitizer.Sanitize(repo.Description), sanitize))
 }
 
+// SizeString returns human-readable disk usage of repository.
+func (repo *Repository) SizeString() string {
+	return base.FileSize(repo.GitSize * 1024)
+}
+
 // IsRepositoryExist returns true if the repository with given name under user has already existed.
 func IsRepositoryExist(u *User, repoName string) bool {
 	has, _ := x.Get(&Repository{
@@ -252,7 +274,7 @@ func (repo *Repository) CloneLink() (cl CloneLink, err error) {
 }
 
 var (
-	illegalEquals  = []string{"debug", "raw", "install", "api", "avatar", "user", "org", "help", "stars", "issues", "pulls", "commits", "repo", "template", "admin", "new"}
+	illegalEquals  = []string{"debug", "raw", "install", "api", "avatar", "user", "org", "help", "stars", "issues", "pulls", "commits", "repo", "template", "admin", "new", "attachments", "branding"}
 	illegalSuffixs = []string{".git", ".keys"}
 )
 
@@ -280,6 +302,11 @@ type Mirror struct {
 	Interval   int       // Hour.
 	Updated    time.Time `xorm:"UPDATED"`
 	NextUpdate time.Time
+
+	// Host keys of SSH remote in known_hosts format, empty for other remotes.
+	// Mirror is only synced with these keys after they are confirmed by admin.
+	MirrorSSHHostKey string `xorm:"TEXT"`
+	HostKeyConfirmed bool
 }
 
 func GetMirror(repoId int64) (*Mirror, error) {
@@ -299,21 +326,34 @@ func UpdateMirror(m *Mirror) error {
 }
 
 // MirrorRepository creates a mirror repository from source.
+// Host key of SSH source is trusted on first clone, and has to be confirmed by admin
+// before mirror is synced.
 func MirrorRepository(repoId int64, userName, repoName, repoPath, url string) error {
-	_, stderr, err := process.ExecTimeout(10*time.Minute,
-		fmt.Sprintf("MirrorRepository: %s/%s", userName, repoName),
-		"git", "clone", "--mirror", url, repoPath)
-	if err != nil {
-		return errors.New("git clone --mirror: " + stderr)
-	}
-
-	if _, err = x.InsertOne(&Mirror{
+	m := &Mirror{
 		RepoId:     repoId,
 		RepoName:   strings.ToLower(userName + "/" + repoName),
 		Interval:   24,
 		NextUpdate: time.Now().Add(24 * time.Hour),
-	}); err != nil {
+	}
+
+	desc := fmt.Sprintf("MirrorRepository: %s/%s", userName, repoName)
+	if host, port, ok := parseSSHRemote(url); ok {
+		var err error
+		if m.MirrorSSHHostKey, err = scanSSHHostKey(host, port); err != nil {
+			return err
+		}
+		if stderr, err := execWithHostKey(m.MirrorSSHHostKey, "", desc, "clone", "--mirror", url, repoPath); err != nil {
+			return errors.New("git clone --mirror: " + stderr)
+		}
+	} else if _, stderr, err := process.ExecTimeout(10*time.Minute, desc,
+		"git", "clone", "--mirror", url, repoPath); err != nil {
+		return errors.New("git clone --mirror: " + stderr)
+	}
+
+	if _, err := x.InsertOne(m); err != nil {
 		return err
+	} else if len(m.MirrorSSHHostKey) > 0 {
+		return notifyMirrorHostKey(m)
 	}
 	return nil
 }
@@ -376,8 +416,9 @@ func extractGitBareZip(repoPath string) error {
 	return z.ExtractTo(repoPath)
 }
 
-// initRepoCommit temporarily changes with work directory.
-func initRepoCommit(tmpPath string, sig *git.Signature) (err error) {
+// initRepoCommit temporarily changes with work directory,
+// and pushes init commit to given branch.
+func initRepoCommit(tmpPath string, sig *git.Signature, branch string) (err error) {
 	var stderr string
 	if _, stderr, err = process.ExecDir(-1,
 		tmpPath, fmt.Sprintf("initRepoCommit(git add): %s", tmpPath),
@@ -394,15 +435,43 @@ func initRepoCommit(tmpPath string, sig *git.Signature) (err error) {
 
 	if _, stderr, err = process.ExecDir(-1,
 		tmpPath, fmt.Sprintf("initRepoCommit(git push): %s", tmpPath),
-		"git", "push", "origin", "master"); err != nil {
+		"git", "push", "origin", "HEAD:refs/heads/"+branch); err != nil {
 		return errors.New("git push: " + stderr)
 	}
 	return nil
 }
 
+// createUpdateHook writes wrapper scripts of server-side hooks to repository,
+// site hooks run before repository-level hooks and Gogs' update command.
 func createUpdateHook(repoPath string) error {
-	return ioutil.WriteFile(path.Join(repoPath, "hooks/update"),
-		[]byte(fmt.Sprintf(_TPL_UPDATE_HOOK, setting.ScriptType, "\""+appPath+"\"", setting.CustomConf)), 0777)
+	return git.WriteServerHooks(repoPath, setting.ScriptType, setting.SiteHookRoot, map[string]string{
+		"update": fmt.Sprintf(_TPL_UPDATE_HOOK, appPath, setting.CustomConf),
+	})
+}
+
+// copyRepoInitFile copies the named .gitignore or license template to target,
+// custom templates are checked when no bundled one has the given name.
+// Only names of templates loaded by LoadRepoConfig are accepted.
+func copyRepoInitFile(tp, name, target string) error {
+	var available []string
+	switch tp {
+	case "gitignore":
+		available = Gitignores
+	case "license":
+		available = Licenses
+	}
+	if !com.IsSliceContainsStr(available, name) {
+		return ErrRepoFileNotExist
+	}
+
+	filePath := path.Join("conf", tp, name)
+	if !com.IsFile(filePath) {
+		filePath = path.Join(setting.CustomPath, "conf", tp, name)
+		if !com.IsFile(filePath) {
+			return ErrRepoFileNotExist
+		}
+	}
+	return com.Copy(filePath, target)
 }
 
 // InitRepository initializes README and .gitignore if needed.
@@ -418,6 +487,15 @@ func initRepository(e Engine, f string, u *User, repo *Repository, initReadme bo
 		return err
 	}
 
+	// Point HEAD to default branch of owner, so clones check it out
+	// once it has been pushed.
+	branch := u.RepoDefaultBranch()
+	if _, stderr, err := process.ExecDir(-1, repoPath,
+		fmt.Sprintf("initRepository(git symbolic-ref): %s", repoPath),
+		"git", "symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+		return fmt.Errorf("git symbolic-ref: %v - %s", err, stderr)
+	}
+
 	// Initialize repository according to user's choice.
 	fileName := map[string]string{}
 	if initReadme {
@@ -452,45 +530,23 @@ func initRepository(e Engine, f string, u *User, repo *Repository, initReadme bo
 	}
 
 	// .gitignore
-	filePath := "conf/gitignore/" + repoLang
-	if com.IsFile(filePath) {
-		targetPath := path.Join(tmpDir, fileName["gitign"])
-		if com.IsFile(filePath) {
-			if err = com.Copy(filePath, targetPath); err != nil {
+	if repoLang != "" {
+		if err = copyRepoInitFile("gitignore", repoLang, path.Join(tmpDir, fileName["gitign"])); err != nil {
+			if err != ErrRepoFileNotExist {
 				return err
 			}
-		} else {
-			// Check custom files.
-			filePath = path.Join(setting.CustomPath, "conf/gitignore", repoLang)
-			if com.IsFile(filePath) {
-				if err := com.Copy(filePath, targetPath); err != nil {
-					return err
-				}
-			}
+			delete(fileName, "gitign")
 		}
-	} else {
-		delete(fileName, "gitign")
 	}
 
 	// LICENSE
-	filePath = "conf/license/" + license
-	if com.IsFile(filePath) {
-		targetPath := path.Join(tmpDir, fileName["license"])
-		if com.IsFile(filePath) {
-			if err = com.Copy(filePath, targetPath); err != nil {
+	if license != "" {
+		if err = copyRepoInitFile("license", license, path.Join(tmpDir, fileName["license"])); err != nil {
+			if err != ErrRepoFileNotExist {
 				return err
 			}
-		} else {
-			// Check custom files.
-			filePath = path.Join(setting.CustomPath, "conf/license", license)
-			if com.IsFile(filePath) {
-				if err := com.Copy(filePath, targetPath); err != nil {
-					return err
-				}
-			}
+			delete(fileName, "license")
 		}
-	} else {
-		delete(fileName, "license")
 	}
 
 	if len(fileName) == 0 {
@@ -500,12 +556,70 @@ func initRepository(e Engine, f string, u *User, repo *Repository, initReadme bo
 			return err
 		}
 		repo.IsBare = true
-		repo.DefaultBranch = "master"
+		repo.DefaultBranch = branch
 		return updateRepository(e, repo, false)
 	}
 
 	// Apply changes and commit.
-	return initRepoCommit(tmpDir, u.NewGitSig())
+	if err = initRepoCommit(tmpDir, u.NewGitSig(), branch); err != nil {
+		return err
+	}
+	repo.DefaultBranch = branch
+	_, err = e.Id(repo.Id).Cols("default_branch").Update(repo)
+	return err
+}
+
+// AutoInitWithTemplates populates a bare repository with the given .gitignore
+// and license templates and creates the initial commit.
+func AutoInitWithTemplates(repoId int64, gitignoreTemplate, licenseTemplate string) error {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return err
+	} else if !repo.IsBare {
+		return ErrRepoAlreadyInitialized
+	} else if err = repo.GetOwner(); err != nil {
+		return err
+	}
+
+	if gitignoreTemplate == "" && licenseTemplate == "" {
+		return nil
+	}
+
+	repoPath := RepoPath(repo.Owner.Name, repo.Name)
+	tmpDir := filepath.Join(os.TempDir(), com.ToStr(time.Now().Nanosecond()))
+	os.MkdirAll(tmpDir, os.ModePerm)
+	defer os.RemoveAll(tmpDir)
+
+	_, stderr, err := process.Exec(
+		fmt.Sprintf("AutoInitWithTemplates(git clone): %s", repoPath),
+		"git", "clone", repoPath, tmpDir)
+	if err != nil {
+		return errors.New("git clone: " + stderr)
+	}
+
+	if gitignoreTemplate != "" {
+		if err = copyRepoInitFile("gitignore", gitignoreTemplate, path.Join(tmpDir, ".gitignore")); err != nil {
+			return fmt.Errorf("copy .gitignore template '%s': %v", gitignoreTemplate, err)
+		}
+	}
+	if licenseTemplate != "" {
+		if err = copyRepoInitFile("license", licenseTemplate, path.Join(tmpDir, "LICENSE")); err != nil {
+			return fmt.Errorf("copy license template '%s': %v", licenseTemplate, err)
+		}
+	}
+
+	// HEAD of bare repository was pointed to default branch when it was created.
+	branch := repo.DefaultBranch
+	if len(branch) == 0 {
+		branch = repo.Owner.RepoDefaultBranch()
+	}
+	if err = initRepoCommit(tmpDir, repo.Owner.NewGitSig(), branch); err != nil {
+		return fmt.Errorf("initRepoCommit: %v", err)
+	}
+
+	repo.IsBare = false
+	repo.DefaultBranch = branch
+	return UpdateRepository(repo, false)
 }
 
 // CreateRepository creates a repository for given user or organization.
@@ -525,6 +639,8 @@ func CreateRepository(u *User, name, desc, lang, license string, isPrivate, isMi
 		LowerName:   strings.ToLower(name),
 		Description: desc,
 		IsPrivate:   isPrivate,
+		HasIssues:   true,
+		HasWiki:     true,
 	}
 
 	sess := x.NewSession()
@@ -778,6 +894,55 @@ func UpdateRepository(repo *Repository, visibilityChanged bool) (err error) {
 	return sess.Commit()
 }
 
+// RepoSettings represents features of a repository that can be turned on and off.
+type RepoSettings struct {
+	HasIssues bool
+	HasWiki   bool
+}
+
+// UpdateRepoSettings turns features of repository on or off.
+func UpdateRepoSettings(repoId int64, settings RepoSettings) error {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return err
+	}
+
+	if _, err = x.Id(repoId).Cols("has_issues", "has_wiki").Update(&Repository{
+		HasIssues: settings.HasIssues,
+		HasWiki:   settings.HasWiki,
+	}); err != nil {
+		return err
+	}
+
+	if repo.HasIssues != settings.HasIssues {
+		indexRepoIssues(repoId, settings.HasIssues)
+	}
+	return nil
+}
+
+// UpdateDefaultBranch points HEAD of repository to given branch
+// and saves it as default branch.
+func UpdateDefaultBranch(repo *Repository, branch string) error {
+	repoPath, err := repo.RepoPath()
+	if err != nil {
+		return fmt.Errorf("RepoPath: %v", err)
+	}
+
+	if !git.IsBranchExist(repoPath, branch) {
+		return ErrBranchNotExist
+	}
+
+	if _, stderr, err := process.ExecDir(-1, repoPath,
+		fmt.Sprintf("UpdateDefaultBranch(git symbolic-ref): %s", repoPath),
+		"git", "symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+		return fmt.Errorf("git symbolic-ref: %v - %s", err, stderr)
+	}
+
+	repo.DefaultBranch = branch
+	_, err = x.Id(repo.Id).Cols("default_branch").Update(repo)
+	return err
+}
+
 // DeleteRepository deletes a repository for a user or organization.
 func DeleteRepository(uid, repoID int64, userName string) error {
 	repo := &Repository{Id: repoID, OwnerId: uid}
@@ -823,6 +988,8 @@ func DeleteRepository(uid, repoID int64, userName string) error {
 		return err
 	} else if _, err = sess.Delete(&Watch{RepoId: repoID}); err != nil {
 		return err
+	} else if _, err = sess.Delete(&PushSubscription{RepoId: repoID}); err != nil {
+		return err
 	} else if _, err = sess.Delete(&Mirror{RepoId: repoID}); err != nil {
 		return err
 	} else if _, err = sess.Delete(&IssueUser{RepoId: repoID}); err != nil {
@@ -833,6 +1000,22 @@ func DeleteRepository(uid, repoID int64, userName string) error {
 		return err
 	} else if _, err = sess.Delete(&Collaboration{RepoID: repoID}); err != nil {
 		return err
+	} else if _, err = sess.Delete(&TeamRepoPermission{RepoID: repoID}); err != nil {
+		return err
+	} else if _, err = sess.Delete(&CommitMessageRule{RepoId: repoID}); err != nil {
+		return err
+	} else if _, err = sess.Delete(&TagPolicy{RepoId: repoID}); err != nil {
+		return err
+	} else if _, err = sess.Delete(&RepoLanguage{RepoId: repoID}); err != nil {
+		return err
+	} else if _, err = sess.Delete(&RepoLanguageTask{RepoId: repoID}); err != nil {
+		return err
+	} else if _, err = sess.Delete(&SearchIndexTask{RepoId: repoID}); err != nil {
+		return err
+	} else if _, err = sess.Delete(&RepoTraffic{RepoId: repoID}); err != nil {
+		return err
+	} else if _, err = sess.Delete(&RepoTrafficVisitor{RepoId: repoID}); err != nil {
+		return err
 	}
 
 	// Delete comments.
@@ -860,8 +1043,10 @@ func DeleteRepository(uid, repoID int64, userName string) error {
 		return err
 	}
 
+	InvalidateRepositoryContributors(repo.Id)
+
 	// Remove repository files.
-	if err = os.RemoveAll(RepoPath(userName, repo.Name)); err != nil {
+	if err = removeRepoFiles(uid, userName, repo.Name); err != nil {
 		desc := fmt.Sprintf("delete repository files(%s/%s): %v", userName, repo.Name, err)
 		log.Warn(desc)
 		if err = CreateRepositoryNotice(desc); err != nil {
@@ -869,7 +1054,204 @@ func DeleteRepository(uid, repoID int64, userName string) error {
 		}
 	}
 
-	return sess.Commit()
+	if err = sess.Commit(); err != nil {
+		return err
+	}
+	unindexRepository(repoID)
+	return nil
+}
+
+// trashRepoPath returns path of deleted repository in trash.
+func trashRepoPath(userName, repoName string) string {
+	return filepath.Join(setting.RepoTrashPath, strings.ToLower(userName), strings.ToLower(repoName)+".git")
+}
+
+// TRASH_META_FILE is the file in deleted repository that records its original owner and name.
+const TRASH_META_FILE = "gogs-trash.json"
+
+// trashMeta represents original owner and name of deleted repository,
+// path of trash is in lower case and owner may have been renamed since.
+type trashMeta struct {
+	OwnerId   int64
+	OwnerName string
+	Name      string
+}
+
+// readTrashMeta returns metadata of deleted repository at given path in trash.
+// Repositories moved to trash before metadata was recorded have none.
+func readTrashMeta(trashPath string) (*trashMeta, error) {
+	data, err := ioutil.ReadFile(filepath.Join(trashPath, TRASH_META_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	meta := new(trashMeta)
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", TRASH_META_FILE, err)
+	}
+	return meta, nil
+}
+
+// removeRepoFiles moves Git data of repository to trash when trash is enabled,
+// otherwise removes it. Previously trashed repository with same name is replaced.
+func removeRepoFiles(ownerId int64, userName, repoName string) error {
+	repoPath := RepoPath(userName, repoName)
+	if len(setting.RepoTrashPath) == 0 {
+		return os.RemoveAll(repoPath)
+	}
+
+	trashPath := trashRepoPath(userName, repoName)
+	if err := os.RemoveAll(trashPath); err != nil {
+		return fmt.Errorf("remove old trash: %v", err)
+	} else if err = os.MkdirAll(filepath.Dir(trashPath), os.ModePerm); err != nil {
+		return fmt.Errorf("create trash directory: %v", err)
+	} else if err = os.Rename(repoPath, trashPath); err != nil {
+		return fmt.Errorf("move to trash: %v", err)
+	}
+
+	data, err := json.Marshal(&trashMeta{ownerId, userName, repoName})
+	if err != nil {
+		return err
+	} else if err = ioutil.WriteFile(filepath.Join(trashPath, TRASH_META_FILE), data, 0644); err != nil {
+		return fmt.Errorf("write %s: %v", TRASH_META_FILE, err)
+	}
+	return nil
+}
+
+// ListTrashRepositories returns paths of deleted repositories in trash,
+// relative to trash directory, e.g. "owner/name.git".
+func ListTrashRepositories() ([]string, error) {
+	if len(setting.RepoTrashPath) == 0 {
+		return nil, nil
+	}
+
+	owners, err := ioutil.ReadDir(setting.RepoTrashPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(owners))
+	for _, owner := range owners {
+		if !owner.IsDir() {
+			continue
+		}
+		repos, err := ioutil.ReadDir(filepath.Join(setting.RepoTrashPath, owner.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range repos {
+			if repo.IsDir() && strings.HasSuffix(repo.Name(), ".git") {
+				paths = append(paths, owner.Name()+"/"+repo.Name())
+			}
+		}
+	}
+	return paths, nil
+}
+
+// AdminRestoreRepository restores deleted repository at given path in trash,
+// e.g. "owner/name.git", and registers it to its original owner with its original name.
+// Metadata such as issues has been deleted with the repository and
+// restored repository is private until owner changes it.
+func AdminRestoreRepository(trashPath string) (_ *Repository, err error) {
+	if len(setting.RepoTrashPath) == 0 {
+		return nil, ErrRepoTrashDisabled
+	}
+
+	fields := strings.Split(path.Clean("/" + trashPath)[1:], "/")
+	if len(fields) != 2 || !strings.HasSuffix(fields[1], ".git") {
+		return nil, ErrTrashRepoNotExist
+	}
+	ownerName, repoName := fields[0], strings.TrimSuffix(fields[1], ".git")
+	srcPath := trashRepoPath(ownerName, repoName)
+	if !com.IsDir(srcPath) {
+		return nil, ErrTrashRepoNotExist
+	}
+
+	meta, err := readTrashMeta(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	var u *User
+	if meta != nil {
+		// Owner is looked up by ID because it may have been renamed.
+		repoName = meta.Name
+		u, err = GetUserById(meta.OwnerId)
+	} else {
+		u, err = GetUserByName(ownerName)
+	}
+	if err != nil {
+		return nil, err
+	} else if IsRepositoryExist(u, repoName) {
+		return nil, ErrRepoAlreadyExist
+	}
+
+	repo := &Repository{
+		OwnerId:   u.Id,
+		Owner:     u,
+		Name:      repoName,
+		LowerName: strings.ToLower(repoName),
+		IsPrivate: true,
+		HasIssues: true,
+		HasWiki:   true,
+	}
+	stdout, _, _ := com.ExecCmdDir(srcPath, "git", "show-ref", "--heads")
+	repo.IsBare = len(strings.TrimSpace(stdout)) == 0
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return nil, err
+	}
+
+	if _, err = sess.Insert(repo); err != nil {
+		return nil, err
+	} else if _, err = sess.Exec("UPDATE `user` SET num_repos = num_repos + 1 WHERE id = ?", u.Id); err != nil {
+		return nil, err
+	}
+
+	if u.IsOrganization() {
+		t, err := u.getOwnerTeam(sess)
+		if err != nil {
+			return nil, fmt.Errorf("getOwnerTeam: %v", err)
+		} else if err = t.addRepository(sess, repo); err != nil {
+			return nil, fmt.Errorf("addRepository: %v", err)
+		}
+	} else if err = repo.recalculateAccesses(sess); err != nil {
+		return nil, fmt.Errorf("recalculateAccesses: %v", err)
+	}
+
+	if err = watchRepo(sess, u.Id, repo.Id, true); err != nil {
+		return nil, fmt.Errorf("watchRepo: %v", err)
+	}
+
+	repoPath := RepoPath(u.Name, repo.Name)
+	if err = os.MkdirAll(filepath.Dir(repoPath), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("create owner directory: %v", err)
+	} else if err = os.Rename(srcPath, repoPath); err != nil {
+		return nil, fmt.Errorf("move from trash: %v", err)
+	}
+
+	if err = sess.Commit(); err != nil {
+		if err2 := os.Rename(repoPath, srcPath); err2 != nil {
+			log.Error(4, "AdminRestoreRepository(move back to trash): %v", err2)
+		}
+		return nil, err
+	}
+
+	if err = os.Remove(filepath.Join(repoPath, TRASH_META_FILE)); err != nil && !os.IsNotExist(err) {
+		log.Error(4, "AdminRestoreRepository(remove %s): %v", TRASH_META_FILE, err)
+	}
+
+	// Hooks may contain outdated path of executable.
+	if err = createUpdateHook(repoPath); err != nil {
+		log.Error(4, "AdminRestoreRepository(createUpdateHook): %v", err)
+	}
+	return repo, nil
 }
 
 // GetRepositoryByRef returns a Repository specified by a GFM reference.
@@ -946,11 +1328,25 @@ func GetRepositoryCount(user *User) (int64, error) {
 	return x.Count(&Repository{OwnerId: user.Id})
 }
 
+// CountUserPublicRepos returns number of public repositories owned by user of given ID.
+func CountUserPublicRepos(uid int64) (int, error) {
+	count, err := x.Where("owner_id=?", uid).And("is_private=?", false).Count(new(Repository))
+	return int(count), err
+}
+
+// CountUserPrivateRepos returns number of private repositories owned by user of given ID.
+func CountUserPrivateRepos(uid int64) (int, error) {
+	count, err := x.Where("owner_id=?", uid).And("is_private=?", true).Count(new(Repository))
+	return int(count), err
+}
+
 type SearchOption struct {
 	Keyword string
 	Uid     int64
 	Limit   int
 	Private bool
+	MinSize int64 // In KB.
+	MaxSize int64 // In KB.
 }
 
 // SearchRepositoryByName returns given number of repositories whose name contains keyword.
@@ -970,10 +1366,46 @@ func SearchRepositoryByName(opt SearchOption) (repos []*Repository, err error) {
 	if !opt.Private {
 		sess.And("is_private=false")
 	}
+	if opt.MinSize > 0 {
+		sess.And("git_size>=?", opt.MinSize)
+	}
+	if opt.MaxSize > 0 {
+		sess.And("git_size<=?", opt.MaxSize)
+	}
 	sess.And("lower_name like ?", "%"+opt.Keyword+"%").Find(&repos)
 	return repos, err
 }
 
+// UpdateRepoSize recalculates and saves disk usage of given repository.
+func UpdateRepoSize(repo *Repository) error {
+	if err := repo.GetOwner(); err != nil {
+		return fmt.Errorf("GetOwner: %v", err)
+	}
+
+	size, err := git.GetRepoSize(RepoPath(repo.Owner.Name, repo.Name))
+	if err != nil {
+		return fmt.Errorf("GetRepoSize: %v", err)
+	}
+
+	repo.GitSize = size
+	_, err = x.Id(repo.Id).Cols("git_size").Update(repo)
+	return err
+}
+
+// UpdateRepoSizeByName recalculates and saves disk usage of repository
+// with given owner and repository name.
+func UpdateRepoSizeByName(ownerName, repoName string) error {
+	owner, err := GetUserByName(ownerName)
+	if err != nil {
+		return fmt.Errorf("GetUserByName: %v", err)
+	}
+	repo, err := GetRepositoryByName(owner.Id, repoName)
+	if err != nil {
+		return fmt.Errorf("GetRepositoryByName: %v", err)
+	}
+	return UpdateRepoSize(repo)
+}
+
 // DeleteRepositoryArchives deletes all repositories' archives.
 func DeleteRepositoryArchives() error {
 	return x.Where("id > 0").Iterate(new(Repository),
@@ -1013,6 +1445,7 @@ func MirrorUpdate() {
 	defer func() { isMirrorUpdating = false }()
 
 	mirrors := make([]*Mirror, 0, 10)
+	scanned := make([]*Mirror, 0, 10)
 
 	if err := x.Iterate(new(Mirror), func(idx int, bean interface{}) error {
 		m := bean.(*Mirror)
@@ -1021,9 +1454,31 @@ func MirrorUpdate() {
 		}
 
 		repoPath := filepath.Join(setting.RepoRootPath, m.RepoName+".git")
-		if _, stderr, err := process.ExecDir(10*time.Minute,
-			repoPath, fmt.Sprintf("MirrorUpdate: %s", repoPath),
-			"git", "remote", "update"); err != nil {
+		desc := fmt.Sprintf("MirrorUpdate: %s", repoPath)
+
+		// Mirrors of SSH remotes are only synced with confirmed host key,
+		// host key of mirrors created before it was recorded is scanned first.
+		var stderr string
+		remote, err := mirrorRemoteURL(repoPath)
+		if err != nil {
+			log.Error(4, "MirrorUpdate: %v", err)
+		}
+		if host, port, ok := parseSSHRemote(remote); ok {
+			if len(m.MirrorSSHHostKey) == 0 {
+				if m.MirrorSSHHostKey, err = scanSSHHostKey(host, port); err != nil {
+					log.Error(4, "MirrorUpdate(%s): %v", repoPath, err)
+				} else {
+					scanned = append(scanned, m)
+				}
+				return nil
+			} else if !m.HostKeyConfirmed {
+				return nil
+			}
+			stderr, err = execWithHostKey(m.MirrorSSHHostKey, repoPath, desc, "remote", "update")
+		} else {
+			_, stderr, err = process.ExecDir(10*time.Minute, repoPath, desc, "git", "remote", "update")
+		}
+		if err != nil {
 			desc := fmt.Sprintf("Fail to update mirror repository(%s): %s", repoPath, stderr)
 			log.Error(4, desc)
 			if err = CreateRepositoryNotice(desc); err != nil {
@@ -1044,6 +1499,13 @@ func MirrorUpdate() {
 			log.Error(4, "UpdateMirror", fmt.Sprintf("%s: %v", mirrors[i].RepoName, err))
 		}
 	}
+	for _, m := range scanned {
+		if err := updateMirrorHostKey(m); err != nil {
+			log.Error(4, "updateMirrorHostKey(%s): %v", m.RepoName, err)
+		} else if err = notifyMirrorHostKey(m); err != nil {
+			log.Error(4, "Fail to add notice: %v", err)
+		}
+	}
 }
 
 // GitFsck calls 'git fsck' to check repository health.
@@ -1224,7 +1686,16 @@ func watchRepo(e Engine, uid, repoId int64, watch bool) (err error) {
 
 // Watch or unwatch repository.
 func WatchRepo(uid, repoId int64, watch bool) (err error) {
-	return watchRepo(x, uid, repoId, watch)
+	if IsWatching(uid, repoId) == watch {
+		return nil
+	} else if err = watchRepo(x, uid, repoId, watch); err != nil {
+		return err
+	}
+
+	prepareRepoWebhooks(uid, repoId, WATCH, func(repo *PayloadRepo, sender *PayloadAuthor) SecretPayload {
+		return &WatchPayload{Action: payloadAction(watch), Repo: repo, Sender: sender}
+	})
+	return nil
 }
 
 func getWatchers(e Engine, rid int64) ([]*Watch, error) {
@@ -1306,7 +1777,14 @@ func StarRepo(uid, repoId int64, star bool) (err error) {
 		}
 		_, err = x.Exec("UPDATE `user` SET num_stars = num_stars - 1 WHERE id = ?", uid)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+
+	prepareRepoWebhooks(uid, repoId, STAR, func(repo *PayloadRepo, sender *PayloadAuthor) SecretPayload {
+		return &StarPayload{Action: payloadAction(star), Repo: repo, Sender: sender}
+	})
+	return nil
 }
 
 // IsStaring checks if user has starred given repository.
@@ -1344,6 +1822,8 @@ func ForkRepository(u *User, oldRepo *Repository, name, desc string) (_ *Reposit
 		IsPrivate:   oldRepo.IsPrivate,
 		IsFork:      true,
 		ForkId:      oldRepo.Id,
+		HasIssues:   true,
+		HasWiki:     true,
 	}
 
 	sess := x.NewSession()
@@ -1408,5 +1888,235 @@ func ForkRepository(u *User, oldRepo *Repository, name, desc string) (_ *Reposit
 		return nil, fmt.Errorf("createUpdateHook: %v", err)
 	}
 
+	if err = sess.Commit(); err != nil {
+		return nil, err
+	}
+
+	forkee := repo.composePayloadRepo()
+	prepareRepoWebhooks(u.Id, oldRepo.Id, FORK, func(repo *PayloadRepo, sender *PayloadAuthor) SecretPayload {
+		return &ForkPayload{Forkee: forkee, Repo: repo, Sender: sender}
+	})
+	return repo, nil
+}
+
+// copyTemplateRepoSettings copies labels, milestones and webhooks
+// of template repository to the new repository. Webhooks are copied inactive
+// and without secrets, so that owner of new repository has to set their own.
+func copyTemplateRepoSettings(e Engine, templateRepo, repo *Repository) error {
+	labels := make([]*Label, 0, 10)
+	if err := e.Where("repo_id=?", templateRepo.Id).Find(&labels); err != nil {
+		return fmt.Errorf("find labels: %v", err)
+	}
+	for _, l := range labels {
+		if _, err := e.Insert(&Label{
+			RepoId: repo.Id,
+			Name:   l.Name,
+			Color:  l.Color,
+		}); err != nil {
+			return fmt.Errorf("insert label: %v", err)
+		}
+	}
+
+	miles := make([]*Milestone, 0, 10)
+	if err := e.Where("repo_id=?", templateRepo.Id).Find(&miles); err != nil {
+		return fmt.Errorf("find milestones: %v", err)
+	}
+	for _, m := range miles {
+		if _, err := e.Insert(&Milestone{
+			RepoId:     repo.Id,
+			Index:      m.Index,
+			Name:       m.Name,
+			Content:    m.Content,
+			IsClosed:   m.IsClosed,
+			Deadline:   m.Deadline,
+			ClosedDate: m.ClosedDate,
+		}); err != nil {
+			return fmt.Errorf("insert milestone: %v", err)
+		}
+		repo.NumMilestones++
+		if m.IsClosed {
+			repo.NumClosedMilestones++
+		}
+	}
+
+	ws := make([]*Webhook, 0, 5)
+	if err := e.Where("repo_id=?", templateRepo.Id).Find(&ws); err != nil {
+		return fmt.Errorf("find webhooks: %v", err)
+	}
+	for _, w := range ws {
+		w.Id = 0
+		w.RepoId = repo.Id
+		w.Secret = ""
+		w.IsActive = false
+		if _, err := e.Insert(w); err != nil {
+			return fmt.Errorf("insert webhook: %v", err)
+		}
+	}
+
+	_, err := e.Id(repo.Id).Cols("num_milestones", "num_closed_milestones").Update(repo)
+	return err
+}
+
+// initRepoFromTemplate creates a bare repository whose only commit contains
+// the tree of default branch of template repository, on given branch.
+func initRepoFromTemplate(templateRepoPath, repoPath string, templateRepo *Repository, sig *git.Signature, branch string) error {
+	if err := extractGitBareZip(repoPath); err != nil {
+		return fmt.Errorf("extractGitBareZip: %v", err)
+	}
+	if _, stderr, err := process.ExecDir(-1, repoPath,
+		fmt.Sprintf("initRepoFromTemplate(git symbolic-ref): %s", repoPath),
+		"git", "symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+		return fmt.Errorf("git symbolic-ref: %v - %s", err, stderr)
+	}
+	if templateRepo.IsBare {
+		return nil
+	}
+
+	tmpDir := filepath.Join(os.TempDir(), com.ToStr(time.Now().Nanosecond()))
+	os.MkdirAll(tmpDir, os.ModePerm)
+	defer os.RemoveAll(tmpDir)
+
+	_, stderr, err := process.Exec(
+		fmt.Sprintf("initRepoFromTemplate(git clone): %s", repoPath),
+		"git", "clone", repoPath, tmpDir)
+	if err != nil {
+		return errors.New("git clone: " + stderr)
+	}
+
+	if _, stderr, err = process.ExecDir(10*time.Minute,
+		tmpDir, fmt.Sprintf("initRepoFromTemplate(git fetch): %s", tmpDir),
+		"git", "fetch", templateRepoPath, "HEAD"); err != nil {
+		return errors.New("git fetch: " + stderr)
+	}
+	if _, stderr, err = process.ExecDir(-1,
+		tmpDir, fmt.Sprintf("initRepoFromTemplate(git checkout): %s", tmpDir),
+		"git", "checkout", "FETCH_HEAD", "--", "."); err != nil {
+		return errors.New("git checkout: " + stderr)
+	}
+
+	if _, stderr, err = process.ExecDir(-1,
+		tmpDir, fmt.Sprintf("initRepoFromTemplate(git commit): %s", tmpDir),
+		"git", "commit", fmt.Sprintf("--author='%s <%s>'", sig.Name, sig.Email),
+		"-m", "Initial commit"); err != nil {
+		return errors.New("git commit: " + stderr)
+	}
+	if _, stderr, err = process.ExecDir(-1,
+		tmpDir, fmt.Sprintf("initRepoFromTemplate(git push): %s", tmpDir),
+		"git", "push", "origin", "HEAD:refs/heads/"+branch); err != nil {
+		return errors.New("git push: " + stderr)
+	}
+	return nil
+}
+
+// CreateRepoFromTemplate creates a new repository for given user or organization
+// with content of template repository. When includeGitHistory is false,
+// the new repository only has a single commit of default branch tree of template.
+// Labels, milestones and webhooks are copied but issues are not.
+func CreateRepoFromTemplate(templateRepoId int64, owner *User, name, description string, includeGitHistory bool) (_ *Repository, err error) {
+	templateRepo, err := GetRepositoryById(templateRepoId)
+	if err != nil {
+		return nil, err
+	} else if !templateRepo.IsTemplate {
+		return nil, ErrRepoNotTemplate
+	}
+
+	if !IsLegalName(name) {
+		return nil, ErrRepoNameIllegal
+	} else if IsRepositoryExist(owner, name) {
+		return nil, ErrRepoAlreadyExist
+	}
+
+	repo := &Repository{
+		OwnerId:       owner.Id,
+		Owner:         owner,
+		Name:          name,
+		LowerName:     strings.ToLower(name),
+		Description:   description,
+		IsPrivate:     templateRepo.IsPrivate,
+		IsBare:        templateRepo.IsBare,
+		DefaultBranch: templateRepo.DefaultBranch,
+		HasIssues:     true,
+		HasWiki:       true,
+	}
+	if len(repo.DefaultBranch) == 0 {
+		// Clones keep HEAD of template, which is master for repositories
+		// created before default branch could be chosen.
+		if includeGitHistory {
+			repo.DefaultBranch = "master"
+		} else {
+			repo.DefaultBranch = owner.RepoDefaultBranch()
+		}
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return nil, err
+	}
+
+	if _, err = sess.Insert(repo); err != nil {
+		return nil, err
+	} else if _, err = sess.Exec("UPDATE `user` SET num_repos = num_repos + 1 WHERE id = ?", owner.Id); err != nil {
+		return nil, err
+	}
+
+	if owner.IsOrganization() {
+		t, err := owner.getOwnerTeam(sess)
+		if err != nil {
+			return nil, fmt.Errorf("getOwnerTeam: %v", err)
+		} else if err = t.addRepository(sess, repo); err != nil {
+			return nil, fmt.Errorf("addRepository: %v", err)
+		}
+	} else {
+		if err = repo.recalculateAccesses(sess); err != nil {
+			return nil, fmt.Errorf("recalculateAccesses: %v", err)
+		}
+	}
+
+	if err = watchRepo(sess, owner.Id, repo.Id, true); err != nil {
+		return nil, fmt.Errorf("watchRepo: %v", err)
+	} else if err = newRepoAction(sess, owner, repo); err != nil {
+		return nil, fmt.Errorf("newRepoAction: %v", err)
+	} else if err = copyTemplateRepoSettings(sess, templateRepo, repo); err != nil {
+		return nil, fmt.Errorf("copyTemplateRepoSettings: %v", err)
+	}
+
+	templateRepoPath, err := templateRepo.RepoPath()
+	if err != nil {
+		return nil, fmt.Errorf("get template repository path: %v", err)
+	}
+
+	// Database changes are rolled back on failure, so must be the repository directory.
+	repoPath := RepoPath(owner.Name, repo.Name)
+	defer func() {
+		if err != nil {
+			if err2 := os.RemoveAll(repoPath); err2 != nil {
+				log.Error(4, "CreateRepoFromTemplate(delete %s): %v", repoPath, err2)
+			}
+		}
+	}()
+
+	if includeGitHistory {
+		_, stderr, err := process.ExecTimeout(10*time.Minute,
+			fmt.Sprintf("CreateRepoFromTemplate(git clone): %s/%s", owner.Name, repo.Name),
+			"git", "clone", "--bare", templateRepoPath, repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("git clone: %v", stderr)
+		}
+	} else if err = initRepoFromTemplate(templateRepoPath, repoPath, templateRepo, owner.NewGitSig(), repo.DefaultBranch); err != nil {
+		return nil, fmt.Errorf("initRepoFromTemplate: %v", err)
+	}
+
+	_, stderr, err := process.ExecDir(-1,
+		repoPath, fmt.Sprintf("CreateRepoFromTemplate(git update-server-info): %s", repoPath),
+		"git", "update-server-info")
+	if err != nil {
+		return nil, fmt.Errorf("git update-server-info: %v", stderr)
+	}
+
+	if err = createUpdateHook(repoPath); err != nil {
+		return nil, fmt.Errorf("createUpdateHook: %v", err)
+	}
+
 	return repo, sess.Commit()
 }
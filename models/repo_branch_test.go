@@ -0,0 +1,83 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Gogs", "GIT_AUTHOR_EMAIL=gogs@fake.local",
+		"GIT_COMMITTER_NAME=Gogs", "GIT_COMMITTER_EMAIL=gogs@fake.local")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v - %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestUpdateDefaultBranch(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(Repository))
+	defer cleanup()
+	var err error
+
+	owner := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local"}
+	if _, err = x.Insert(owner); err != nil {
+		t.Fatal(err)
+	}
+	repo := &Repository{OwnerId: owner.Id, Name: "repo1", LowerName: "repo1", DefaultBranch: "master"}
+	if _, err = x.Insert(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Prepare bare repository with branches master and develop.
+	setting.RepoRootPath = filepath.Join(tmpDir, "repositories")
+	repoPath := RepoPath(owner.Name, repo.Name)
+	if err = os.MkdirAll(repoPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "init", "--bare")
+
+	workDir := filepath.Join(tmpDir, "work")
+	runGit(t, tmpDir, "clone", repoPath, workDir)
+	if err = ioutil.WriteFile(filepath.Join(workDir, "README.md"), []byte("# repo1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "Initial commit")
+	runGit(t, workDir, "push", "origin", "HEAD:refs/heads/master")
+	runGit(t, workDir, "push", "origin", "HEAD:refs/heads/develop")
+
+	if err = UpdateDefaultBranch(repo, "not-exist"); err != ErrBranchNotExist {
+		t.Fatalf("expect ErrBranchNotExist but got %v", err)
+	}
+
+	if err = UpdateDefaultBranch(repo, "develop"); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := GetRepositoryById(repo.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.DefaultBranch != "develop" {
+		t.Errorf("expect default branch 'develop' but got '%s'", updated.DefaultBranch)
+	}
+	if head := runGit(t, repoPath, "symbolic-ref", "HEAD"); head != "refs/heads/develop" {
+		t.Errorf("expect HEAD 'refs/heads/develop' but got '%s'", head)
+	}
+}
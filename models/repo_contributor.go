@@ -0,0 +1,137 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/git"
+)
+
+// Contributor represents an author of commits in a repository.
+type Contributor struct {
+	Name     string
+	Email    string
+	Commits  int
+	Avatar   string
+	UserName string // Name of registered user matched by e-mail, empty if none.
+}
+
+type contributorsCache struct {
+	headId  string
+	entries []*git.ShortlogEntry
+}
+
+// Shortlog entries are cached per repository along with commit ID of HEAD they were
+// calculated from, so the cache is invalidated by any push that moves HEAD even
+// when it is received by another process.
+var (
+	contributorsCacheLock sync.RWMutex
+	repoContributors      = make(map[int64]*contributorsCache)
+)
+
+// getShortlogEntries returns all authors of commits in given repository.
+func getShortlogEntries(repoId int64) ([]*git.ShortlogEntry, error) {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return nil, err
+	} else if repo.IsBare {
+		return []*git.ShortlogEntry{}, nil
+	}
+
+	repoPath, err := repo.RepoPath()
+	if err != nil {
+		return nil, fmt.Errorf("RepoPath: %v", err)
+	}
+	headId, err := git.GetHeadCommitId(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("GetHeadCommitId: %v", err)
+	} else if len(headId) == 0 {
+		// Branch that HEAD points to has no commits.
+		return []*git.ShortlogEntry{}, nil
+	}
+
+	contributorsCacheLock.RLock()
+	cache := repoContributors[repoId]
+	contributorsCacheLock.RUnlock()
+	if cache != nil && cache.headId == headId {
+		return cache.entries, nil
+	}
+
+	entries, err := git.GetShortlog(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("GetShortlog: %v", err)
+	}
+
+	contributorsCacheLock.Lock()
+	repoContributors[repoId] = &contributorsCache{headId, entries}
+	contributorsCacheLock.Unlock()
+	return entries, nil
+}
+
+// GetRepositoryContributors returns a page of contributors of given repository,
+// most commits first.
+func GetRepositoryContributors(repoId int64, page, pageSize int) ([]*Contributor, error) {
+	entries, err := getShortlogEntries(repoId)
+	if err != nil {
+		return nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * pageSize
+	if start >= len(entries) {
+		return []*Contributor{}, nil
+	}
+	end := start + pageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+	entries = entries[start:end]
+
+	// Only contributors of the page are matched against registered users.
+	emails := make([]string, len(entries))
+	for i, e := range entries {
+		emails[i] = e.Email
+	}
+	users, err := getUsersByEmails(emails)
+	if err != nil {
+		return nil, fmt.Errorf("getUsersByEmails: %v", err)
+	}
+
+	contributors := make([]*Contributor, len(entries))
+	for i, e := range entries {
+		c := &Contributor{
+			Name:    e.Name,
+			Email:   e.Email,
+			Commits: e.Commits,
+		}
+		if u := users[strings.ToLower(e.Email)]; u != nil {
+			c.Avatar = u.AvatarLink()
+			c.UserName = u.Name
+		} else {
+			c.Avatar = base.AvatarLink(e.Email)
+		}
+		contributors[i] = c
+	}
+	return contributors, nil
+}
+
+// CountRepositoryContributors returns number of contributors of given repository.
+func CountRepositoryContributors(repoId int64) (int, error) {
+	entries, err := getShortlogEntries(repoId)
+	return len(entries), err
+}
+
+// InvalidateRepositoryContributors removes cached contributors of given repository.
+func InvalidateRepositoryContributors(repoId int64) {
+	contributorsCacheLock.Lock()
+	delete(repoContributors, repoId)
+	contributorsCacheLock.Unlock()
+}
@@ -0,0 +1,90 @@
+//go:build sqlite
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestGetRepositoryContributors(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(EmailAddress), new(Repository))
+	defer cleanup()
+	var err error
+
+	owner := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local"}
+	other := &User{Name: "user2", LowerName: "user2", Email: "user2@fake.local"}
+	if _, err = x.Insert(owner, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = x.Insert(&EmailAddress{Uid: other.Id, Email: "alt@fake.local", IsActivated: true},
+		&EmailAddress{Uid: other.Id, Email: "pending@fake.local"}); err != nil {
+		t.Fatal(err)
+	}
+	repo := &Repository{OwnerId: owner.Id, Name: "repo1", LowerName: "repo1"}
+	if _, err = x.Insert(repo); err != nil {
+		t.Fatal(err)
+	}
+	InvalidateRepositoryContributors(repo.Id)
+	defer InvalidateRepositoryContributors(repo.Id)
+
+	// Repository without any commit has no contributors.
+	setting.RepoRootPath = filepath.Join(tmpDir, "repositories")
+	repoPath := RepoPath(owner.Name, repo.Name)
+	runGit(t, tmpDir, "init", repoPath)
+	if count, err := CountRepositoryContributors(repo.Id); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("expect no contributors but got %d", count)
+	}
+
+	for _, author := range []string{
+		"User One <USER1@fake.local>",
+		"User One <user1@fake.local>",
+		"Alt Person <alt@fake.local>",
+		"Pending Person <pending@fake.local>",
+		"Stranger <stranger@fake.local>",
+	} {
+		runGit(t, repoPath, "commit", "--allow-empty", "-m", "commit", "--author", author)
+	}
+
+	if count, err := CountRepositoryContributors(repo.Id); err != nil {
+		t.Fatal(err)
+	} else if count != 5 {
+		t.Fatalf("expect 5 contributors but got %d", count)
+	}
+
+	contributors, err := GetRepositoryContributors(repo.Id, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"USER1@fake.local":    "user1",
+		"user1@fake.local":    "user1",
+		"alt@fake.local":      "user2",
+		"pending@fake.local":  "",
+		"stranger@fake.local": "",
+	}
+	for _, c := range contributors {
+		if userName, ok := expect[c.Email]; !ok {
+			t.Errorf("unexpected contributor %+v", c)
+		} else if c.UserName != userName {
+			t.Errorf("%s: expect user %q but got %q", c.Email, userName, c.UserName)
+		} else if len(c.Avatar) == 0 {
+			t.Errorf("%s: expect avatar", c.Email)
+		}
+	}
+
+	if contributors, err = GetRepositoryContributors(repo.Id, 2, 3); err != nil {
+		t.Fatal(err)
+	} else if len(contributors) != 2 {
+		t.Errorf("expect 2 contributors on last page but got %d", len(contributors))
+	}
+}
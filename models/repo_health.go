@@ -0,0 +1,179 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/process"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// Checking large repositories can take much longer than default process timeout.
+const _REPO_HEALTH_TIMEOUT = 10 * time.Minute
+
+// HealthReport represents result of checking a repository with git fsck.
+type HealthReport struct {
+	RepoId    int64
+	RepoName  string
+	Errors    []string // Problems reported by git fsck before repair.
+	HasErrors bool
+	Repaired  bool // No more problems are reported after running git gc.
+	Checked   time.Time
+}
+
+// parseFsckOutput returns lines of git fsck output that report problems,
+// dangling objects are not problems and are skipped.
+func parseFsckOutput(output string) []string {
+	errs := make([]string, 0, 2)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case len(line) == 0, strings.HasPrefix(line, "dangling "),
+			strings.HasPrefix(line, "Checking "), strings.HasPrefix(line, "notice:"):
+			continue
+		case strings.HasPrefix(line, "error"), strings.HasPrefix(line, "fatal:"),
+			strings.HasPrefix(line, "missing "), strings.HasPrefix(line, "broken link"),
+			strings.HasPrefix(line, "bad "), strings.Contains(line, "corrupt"):
+			errs = append(errs, line)
+		}
+	}
+	return errs
+}
+
+// fsckRepo runs git fsck in given repository and returns problems it reports.
+func fsckRepo(repoPath, desc string) ([]string, error) {
+	stdout, stderr, err := process.ExecDir(_REPO_HEALTH_TIMEOUT, repoPath, desc, "git", "fsck", "--no-dangling")
+	errs := parseFsckOutput(stdout + "\n" + stderr)
+	// git fsck exits with non-zero status when it finds problems.
+	if err != nil && len(errs) == 0 {
+		return nil, fmt.Errorf("%v - %s", err, stderr)
+	}
+	return errs, nil
+}
+
+var repoHealth = struct {
+	sync.RWMutex
+	reports map[int64]*HealthReport
+}{reports: make(map[int64]*HealthReport)}
+
+// CheckRepoHealth checks repository of given ID with git fsck, and runs git gc
+// to repair it when problems are found. Latest report of each repository
+// is kept in memory for admin panel.
+func CheckRepoHealth(repoId int64) (*HealthReport, error) {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return nil, err
+	} else if err = repo.GetOwner(); err != nil {
+		return nil, fmt.Errorf("GetOwner: %v", err)
+	}
+	repoPath := RepoPath(repo.Owner.Name, repo.Name)
+
+	report := &HealthReport{
+		RepoId:   repo.Id,
+		RepoName: repo.Owner.Name + "/" + repo.Name,
+		Checked:  time.Now(),
+	}
+	report.Errors, err = fsckRepo(repoPath, fmt.Sprintf("CheckRepoHealth(fsck): %s", report.RepoName))
+	if err != nil {
+		return nil, fmt.Errorf("fsck: %v", err)
+	}
+	report.HasErrors = len(report.Errors) > 0
+
+	if report.HasErrors {
+		if _, stderr, err := process.ExecDir(_REPO_HEALTH_TIMEOUT, repoPath,
+			fmt.Sprintf("CheckRepoHealth(gc): %s", report.RepoName), "git", "gc", "--prune=now"); err != nil {
+			log.Error(4, "CheckRepoHealth(gc) %s: %v - %s", report.RepoName, err, stderr)
+		} else {
+			errs, err := fsckRepo(repoPath, fmt.Sprintf("CheckRepoHealth(fsck): %s", report.RepoName))
+			if err != nil {
+				return nil, fmt.Errorf("fsck after gc: %v", err)
+			}
+			report.Repaired = len(errs) == 0
+		}
+	}
+
+	repoHealth.Lock()
+	repoHealth.reports[repo.Id] = report
+	repoHealth.Unlock()
+	return report, nil
+}
+
+// GetRepoHealthReports returns latest health reports of repositories by their IDs.
+func GetRepoHealthReports() map[int64]*HealthReport {
+	repoHealth.RLock()
+	defer repoHealth.RUnlock()
+
+	reports := make(map[int64]*HealthReport, len(repoHealth.reports))
+	for id, report := range repoHealth.reports {
+		reports[id] = report
+	}
+	return reports
+}
+
+// Prevent duplicate tasks, and show state in admin panel.
+var isCheckingAllRepoHealth = false
+
+// IsCheckingAllRepoHealth returns true if all repositories are being checked.
+func IsCheckingAllRepoHealth() bool {
+	return isCheckingAllRepoHealth
+}
+
+// CheckAllRepoHealth checks all repositories with at most
+// setting.RepoHealthCheckConcurrency checks running at the same time.
+func CheckAllRepoHealth() {
+	if isCheckingAllRepoHealth {
+		return
+	}
+	isCheckingAllRepoHealth = true
+	defer func() { isCheckingAllRepoHealth = false }()
+
+	ids := make([]int64, 0, 50)
+	if err := x.Table("repository").Cols("id").Find(&ids); err != nil {
+		log.Error(4, "CheckAllRepoHealth: %v", err)
+		return
+	}
+
+	limit := setting.RepoHealthCheckConcurrency
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan bool, limit)
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		sem <- true
+		wg.Add(1)
+		go func(id int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			report, err := CheckRepoHealth(id)
+			if err != nil {
+				log.Error(4, "CheckRepoHealth(%d): %v", id, err)
+			} else if report.HasErrors && !report.Repaired {
+				if err = CreateRepositoryNotice(fmt.Sprintf("Repository %s is broken: %s",
+					report.RepoName, strings.Join(report.Errors, "; "))); err != nil {
+					log.Error(4, "CreateRepositoryNotice: %v", err)
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+// QueueAllRepoHealthCheck checks all repositories in background, it returns
+// false if a check of all repositories is already running.
+func QueueAllRepoHealthCheck() bool {
+	if isCheckingAllRepoHealth {
+		return false
+	}
+	go CheckAllRepoHealth()
+	return true
+}
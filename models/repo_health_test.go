@@ -0,0 +1,35 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFsckOutput(t *testing.T) {
+	output := `Checking object directories: 100% (256/256), done.
+dangling commit 4b825dc642cb6eb9a060e54bf8d69288fbee4904
+broken link from    tree 9bc2f3b5f7c4a4c3c1b4b0d1a1a8c2f3c4d5e6f7
+              to    blob 5e1c309dae7f45e0f39b1bf3ac3cd9db12e7d689
+missing blob 5e1c309dae7f45e0f39b1bf3ac3cd9db12e7d689
+error: inflate: data stream error (incorrect header check)
+error in tree 9bc2f3b5f7c4a4c3c1b4b0d1a1a8c2f3c4d5e6f7: contains entries pointing to null sha1
+notice: HEAD points to an unborn branch (master)
+`
+	expect := []string{
+		"broken link from    tree 9bc2f3b5f7c4a4c3c1b4b0d1a1a8c2f3c4d5e6f7",
+		"missing blob 5e1c309dae7f45e0f39b1bf3ac3cd9db12e7d689",
+		"error: inflate: data stream error (incorrect header check)",
+		"error in tree 9bc2f3b5f7c4a4c3c1b4b0d1a1a8c2f3c4d5e6f7: contains entries pointing to null sha1",
+	}
+	if errs := parseFsckOutput(output); !reflect.DeepEqual(errs, expect) {
+		t.Errorf("expect errors:\n%q\nbut got:\n%q", expect, errs)
+	}
+
+	if errs := parseFsckOutput("dangling blob 5e1c309dae7f45e0f39b1bf3ac3cd9db12e7d689\n"); len(errs) != 0 {
+		t.Errorf("expect no errors for dangling objects but got %q", errs)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	enry "github.com/go-enry/go-enry"
+
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/log"
+)
+
+// LANGUAGE_DETECT_READ_LIMIT is the maximum number of bytes read from
+// beginning of each file to detect its language.
+const LANGUAGE_DETECT_READ_LIMIT = 16 * 1024
+
+// RepoLanguage represents amount of code in a language
+// in default branch of a repository.
+type RepoLanguage struct {
+	Id         int64
+	RepoId     int64  `xorm:"UNIQUE(s) INDEX NOT NULL"`
+	Language   string `xorm:"UNIQUE(s) NOT NULL"`
+	Bytes      int64
+	Percentage float64
+}
+
+// Color returns color that is commonly used to represent the language.
+func (l *RepoLanguage) Color() string {
+	return enry.GetColor(l.Language)
+}
+
+// RepoLanguageTask represents a repository waiting for its languages to be detected.
+// Tasks are queued by update hook and run by web server,
+// so pushes do not wait for files to be analyzed.
+type RepoLanguageTask struct {
+	Id      int64
+	RepoId  int64     `xorm:"UNIQUE NOT NULL"`
+	Created time.Time `xorm:"CREATED"`
+}
+
+// queueRepoLanguageDetection adds repository to language detection queue,
+// unless it is already waiting there.
+func queueRepoLanguageDetection(repoId int64) error {
+	has, err := x.Get(&RepoLanguageTask{RepoId: repoId})
+	if err != nil {
+		return err
+	} else if has {
+		return nil
+	}
+	_, err = x.Insert(&RepoLanguageTask{RepoId: repoId})
+	return err
+}
+
+// DetectQueuedRepoLanguages detects languages of queued repositories.
+func DetectQueuedRepoLanguages() {
+	for {
+		tasks := make([]*RepoLanguageTask, 0, 10)
+		if err := x.Asc("id").Limit(10).Find(&tasks); err != nil {
+			log.Error(4, "DetectQueuedRepoLanguages: %v", err)
+			return
+		} else if len(tasks) == 0 {
+			return
+		}
+
+		for _, t := range tasks {
+			// Task is removed first, so a repository that cannot be analyzed
+			// does not block the queue. Next push queues it again.
+			if _, err := x.Delete(&RepoLanguageTask{Id: t.Id}); err != nil {
+				log.Error(4, "Delete repository language task[%d]: %v", t.Id, err)
+				return
+			}
+			if err := DetectRepoLanguages(t.RepoId); err != nil {
+				log.Error(4, "DetectRepoLanguages[%d]: %v", t.RepoId, err)
+			}
+		}
+	}
+}
+
+// walkTree calls fn for every blob in given tree and its subtrees,
+// submodules and symbolic links are skipped.
+func walkTree(tree *git.Tree, dir string, fn func(string, *git.TreeEntry) error) error {
+	entries, err := tree.ListEntries("")
+	if err != nil {
+		return err
+	}
+	for _, te := range entries {
+		p := path.Join(dir, te.Name())
+		switch {
+		case te.IsDir():
+			subTree, err := tree.SubTree(te.Name())
+			if err != nil {
+				return err
+			} else if err = walkTree(subTree, p, fn); err != nil {
+				return err
+			}
+		case te.IsSubModule(), te.EntryMode() == git.ModeSymlink:
+			continue
+		default:
+			if err = fn(p, te); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DetectRepoLanguages analyzes files in default branch of repository
+// and saves amount of code in each detected language.
+func DetectRepoLanguages(repoId int64) error {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return err
+	} else if repo.IsBare {
+		return nil
+	}
+
+	repoPath, err := repo.RepoPath()
+	if err != nil {
+		return fmt.Errorf("RepoPath: %v", err)
+	}
+	gitRepo, err := git.OpenRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("OpenRepository: %v", err)
+	}
+	commit, err := gitRepo.GetCommitOfBranch(repo.DefaultBranch)
+	if err != nil {
+		return fmt.Errorf("GetCommitOfBranch(%s): %v", repo.DefaultBranch, err)
+	}
+
+	sizes := make(map[string]int64)
+	var total int64
+	if err = walkTree(&commit.Tree, "", func(p string, te *git.TreeEntry) error {
+		if enry.IsVendor(p) {
+			return nil
+		}
+
+		// Beginning of file is enough to tell its language,
+		// amount of code is taken from size of whole file.
+		content, err := te.Blob().DataHead(LANGUAGE_DETECT_READ_LIMIT)
+		if err != nil {
+			return fmt.Errorf("DataHead(%s): %v", p, err)
+		} else if enry.IsBinary(content) {
+			return nil
+		}
+
+		if lang := enry.GetLanguage(path.Base(p), content); len(lang) > 0 {
+			size := te.Size()
+			sizes[lang] += size
+			total += size
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("walkTree: %v", err)
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if _, err = sess.Delete(&RepoLanguage{RepoId: repoId}); err != nil {
+		return err
+	}
+	for lang, size := range sizes {
+		if _, err = sess.Insert(&RepoLanguage{
+			RepoId:     repoId,
+			Language:   lang,
+			Bytes:      size,
+			Percentage: float64(size) * 100 / float64(total),
+		}); err != nil {
+			return err
+		}
+	}
+	return sess.Commit()
+}
+
+type repoLanguageList []*RepoLanguage
+
+func (l repoLanguageList) Len() int           { return len(l) }
+func (l repoLanguageList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l repoLanguageList) Less(i, j int) bool { return l[i].Bytes > l[j].Bytes }
+
+// GetRepoLanguages returns detected languages of repository
+// ordered by amount of code descending.
+func GetRepoLanguages(repoId int64) ([]*RepoLanguage, error) {
+	langs := make([]*RepoLanguage, 0, 5)
+	if err := x.Where("repo_id=?", repoId).Find(&langs); err != nil {
+		return nil, err
+	}
+	sort.Sort(repoLanguageList(langs))
+	return langs, nil
+}
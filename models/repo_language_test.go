@@ -0,0 +1,84 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestDetectQueuedRepoLanguages(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(Repository), new(RepoLanguage), new(RepoLanguageTask))
+	defer cleanup()
+	var err error
+
+	owner := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local"}
+	if _, err = x.Insert(owner); err != nil {
+		t.Fatal(err)
+	}
+	repo := &Repository{OwnerId: owner.Id, Name: "repo1", LowerName: "repo1", DefaultBranch: "master"}
+	if _, err = x.Insert(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	// File larger than read limit is still counted by its full size.
+	setting.RepoRootPath = filepath.Join(tmpDir, "repositories")
+	repoPath := RepoPath(owner.Name, repo.Name)
+	runGit(t, tmpDir, "init", repoPath)
+	large := "package main\n\n" + strings.Repeat("var _ = 1\n", LANGUAGE_DETECT_READ_LIMIT)
+	for name, content := range map[string]string{
+		"main.go":    large,
+		"script.py":  "print('hello')\n",
+		"image.png":  "\x89PNG\r\n\x1a\n\x00\x00\x00",
+		"vendor/x.c": "int main() { return 0; }\n",
+	} {
+		p := filepath.Join(repoPath, name)
+		if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		} else if err = ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, repoPath, "add", "--all")
+	runGit(t, repoPath, "commit", "-m", "Initial commit")
+	runGit(t, repoPath, "branch", "-M", "master")
+
+	// Repository is queued once no matter how many pushes are waiting.
+	for i := 0; i < 2; i++ {
+		if err = queueRepoLanguageDetection(repo.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := x.Count(new(RepoLanguageTask)); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Fatalf("expect 1 queued task but got %d", count)
+	}
+
+	DetectQueuedRepoLanguages()
+	if count, err := x.Count(new(RepoLanguageTask)); err != nil {
+		t.Fatal(err)
+	} else if count > 0 {
+		t.Errorf("expect queue to be drained but got %d tasks", count)
+	}
+
+	langs, err := GetRepoLanguages(repo.Id)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(langs) != 2 {
+		t.Fatalf("expect Go and Python but got %d languages", len(langs))
+	} else if langs[0].Language != "Go" || langs[0].Bytes != int64(len(large)) {
+		t.Errorf("expect Go with %d bytes first but got %s with %d bytes", len(large), langs[0].Language, langs[0].Bytes)
+	} else if langs[1].Language != "Python" {
+		t.Errorf("expect Python second but got %s", langs[1].Language)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/process"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var ErrMirrorNotSSH = errors.New("Mirror does not use SSH remote")
+
+// parseSSHRemote returns host and port of an SSH remote address,
+// in either "ssh://[user@]host[:port]/path" or scp-like "[user@]host:path" format.
+func parseSSHRemote(remote string) (host, port string, ok bool) {
+	if strings.HasPrefix(remote, "ssh://") || strings.HasPrefix(remote, "git+ssh://") {
+		u, err := url.Parse(remote)
+		if err != nil || len(u.Host) == 0 {
+			return "", "", false
+		}
+		host, port = u.Host, "22"
+		if h, p, err := net.SplitHostPort(u.Host); err == nil {
+			host, port = h, p
+		}
+		return host, port, true
+	}
+
+	// Other URLs and local paths that happen to contain a colon are not scp-like.
+	if strings.Contains(remote, "://") {
+		return "", "", false
+	}
+	i := strings.Index(remote, ":")
+	if i <= 0 || strings.Contains(remote[:i], "/") {
+		return "", "", false
+	}
+	host = remote[:i]
+	if j := strings.LastIndex(host, "@"); j >= 0 {
+		host = host[j+1:]
+	}
+	return host, "22", len(host) > 0
+}
+
+// scanSSHHostKey returns host keys of given SSH server in known_hosts format.
+func scanSSHHostKey(host, port string) (string, error) {
+	stdout, stderr, err := process.ExecTimeout(time.Minute,
+		fmt.Sprintf("scanSSHHostKey: %s:%s", host, port),
+		"ssh-keyscan", "-p", port, host)
+	if err != nil {
+		return "", fmt.Errorf("ssh-keyscan: %v - %s", err, stderr)
+	}
+
+	lines := make([]string, 0, 3)
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("ssh-keyscan: no host key of %s:%s", host, port)
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// HostKeyFingerprints returns key type and SHA256 fingerprint of each host key of mirror.
+func (m *Mirror) HostKeyFingerprints() []string {
+	fingerprints := make([]string, 0, 3)
+	for _, line := range strings.Split(m.MirrorSSHHostKey, "\n") {
+		// Lines are in "<host> <type> <base64>" format.
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		sha256Fingerprint, _, err := keyFingerprints(strings.Join(fields[1:], " "))
+		if err != nil {
+			continue
+		}
+		fingerprints = append(fingerprints, fields[1]+" "+sha256Fingerprint)
+	}
+	return fingerprints
+}
+
+// mirrorRemoteURL returns remote address of mirror repository at given path.
+func mirrorRemoteURL(repoPath string) (string, error) {
+	stdout, stderr, err := process.ExecDir(-1, repoPath,
+		fmt.Sprintf("mirrorRemoteURL: %s", repoPath),
+		"git", "config", "--get", "remote.origin.url")
+	if err != nil {
+		return "", fmt.Errorf("git config --get remote.origin.url: %v - %s", err, stderr)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// execWithHostKey runs git command that connects to SSH remote, only given host key is trusted
+// so connection fails when server presents any other key. hostKey is in known_hosts format.
+func execWithHostKey(hostKey, dir, desc string, args ...string) (string, error) {
+	f, err := ioutil.TempFile("", "gogs-known-hosts")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(hostKey)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	sshCommand := fmt.Sprintf("ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile='%s' -o GlobalKnownHostsFile=/dev/null", f.Name())
+	_, stderr, err := process.ExecDirEnv(10*time.Minute, dir, desc,
+		[]string{"GIT_SSH_COMMAND=" + sshCommand}, "git", args...)
+	if err != nil {
+		return stderr, err
+	}
+	return "", nil
+}
+
+// notifyMirrorHostKey asks admins to confirm host key of mirror via a system notice.
+func notifyMirrorHostKey(m *Mirror) error {
+	return CreateRepositoryNotice(fmt.Sprintf("SSH host key of mirror repository %s needs to be confirmed in admin panel before next sync: %s",
+		m.RepoName, strings.Join(m.HostKeyFingerprints(), ", ")))
+}
+
+// updateMirrorHostKey saves host key of mirror and whether it has been confirmed.
+func updateMirrorHostKey(m *Mirror) error {
+	_, err := x.Id(m.Id).Cols("mirror_ssh_host_key", "host_key_confirmed").Update(m)
+	return err
+}
+
+// GetMirrorById returns mirror by given ID.
+func GetMirrorById(id int64) (*Mirror, error) {
+	m := new(Mirror)
+	has, err := x.Id(id).Get(m)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrMirrorNotExist
+	}
+	return m, nil
+}
+
+// GetSSHMirrors returns all mirrors of SSH remotes whose host key has been scanned,
+// mirrors waiting for confirmation come first.
+func GetSSHMirrors() ([]*Mirror, error) {
+	mirrors := make([]*Mirror, 0, 10)
+	return mirrors, x.Where("mirror_ssh_host_key != ''").Asc("host_key_confirmed").Asc("id").Find(&mirrors)
+}
+
+// ConfirmMirrorHostKey marks host key of mirror as confirmed by admin, so it can be synced.
+func ConfirmMirrorHostKey(mirrorId int64) error {
+	m, err := GetMirrorById(mirrorId)
+	if err != nil {
+		return err
+	} else if len(m.MirrorSSHHostKey) == 0 {
+		return ErrMirrorNotSSH
+	}
+	m.HostKeyConfirmed = true
+	return updateMirrorHostKey(m)
+}
+
+// RotateMirrorHostKey scans host key of mirror again, for example after remote server
+// has changed its key. Mirror is not synced until new key is confirmed by admin.
+func RotateMirrorHostKey(mirrorId int64) error {
+	m, err := GetMirrorById(mirrorId)
+	if err != nil {
+		return err
+	}
+
+	remote, err := mirrorRemoteURL(filepath.Join(setting.RepoRootPath, m.RepoName+".git"))
+	if err != nil {
+		return err
+	}
+	host, port, ok := parseSSHRemote(remote)
+	if !ok {
+		return ErrMirrorNotSSH
+	}
+	if m.MirrorSSHHostKey, err = scanSSHHostKey(host, port); err != nil {
+		return err
+	}
+	m.HostKeyConfirmed = false
+	if err = updateMirrorHostKey(m); err != nil {
+		return err
+	}
+	return notifyMirrorHostKey(m)
+}
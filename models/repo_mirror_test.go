@@ -0,0 +1,43 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSSHRemote(t *testing.T) {
+	cases := []struct {
+		remote     string
+		host, port string
+		ok         bool
+	}{
+		{"ssh://git@example.com/user/repo.git", "example.com", "22", true},
+		{"ssh://git@example.com:2222/user/repo.git", "example.com", "2222", true},
+		{"git+ssh://example.com/repo.git", "example.com", "22", true},
+		{"git@example.com:user/repo.git", "example.com", "22", true},
+		{"example.com:repo.git", "example.com", "22", true},
+		{"https://example.com/user/repo.git", "", "", false},
+		{"/home/git/repo.git", "", "", false},
+		{"./dir:name/repo.git", "", "", false},
+		{"", "", "", false},
+	}
+	for _, c := range cases {
+		host, port, ok := parseSSHRemote(c.remote)
+		if host != c.host || port != c.port || ok != c.ok {
+			t.Errorf("parseSSHRemote(%q) = %q, %q, %v; expect %q, %q, %v",
+				c.remote, host, port, ok, c.host, c.port, c.ok)
+		}
+	}
+}
+
+func TestMirrorHostKeyFingerprints(t *testing.T) {
+	m := &Mirror{MirrorSSHHostKey: "example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5\n[example.com]:2222 ssh-rsa !invalid\n"}
+	fingerprints := m.HostKeyFingerprints()
+	if len(fingerprints) != 1 || !strings.HasPrefix(fingerprints[0], "ssh-ed25519 SHA256:") {
+		t.Errorf("unexpected fingerprints: %q", fingerprints)
+	}
+}
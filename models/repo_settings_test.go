@@ -0,0 +1,43 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestUpdateRepoSettings(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(Repository))
+	defer cleanup()
+	var err error
+
+	repo := &Repository{OwnerId: 1, Name: "repo", LowerName: "repo", Description: "desc", HasIssues: true, HasWiki: true}
+	if _, err = x.Insert(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, settings := range []RepoSettings{
+		{HasIssues: false, HasWiki: true},
+		{HasIssues: true, HasWiki: false},
+		{HasIssues: false, HasWiki: false},
+		{HasIssues: true, HasWiki: true},
+	} {
+		if err = UpdateRepoSettings(repo.Id, settings); err != nil {
+			t.Fatal(err)
+		}
+		got, err := GetRepositoryById(repo.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.HasIssues != settings.HasIssues || got.HasWiki != settings.HasWiki {
+			t.Errorf("expect %+v but got issues=%v wiki=%v", settings, got.HasIssues, got.HasWiki)
+		}
+		if got.Description != "desc" {
+			t.Errorf("expect other columns to be kept but got description %q", got.Description)
+		}
+	}
+}
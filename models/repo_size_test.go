@@ -0,0 +1,86 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestUpdateRepoSize(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(Repository))
+	defer cleanup()
+	var err error
+
+	owner := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local"}
+	if _, err = x.Insert(owner); err != nil {
+		t.Fatal(err)
+	}
+	small := &Repository{OwnerId: owner.Id, Name: "small", LowerName: "small"}
+	large := &Repository{OwnerId: owner.Id, Name: "large", LowerName: "large"}
+	if _, err = x.Insert(small, large); err != nil {
+		t.Fatal(err)
+	}
+
+	setting.RepoRootPath = filepath.Join(tmpDir, "repositories")
+	for _, repo := range []*Repository{small, large} {
+		repoPath := RepoPath(owner.Name, repo.Name)
+		if err = os.MkdirAll(repoPath, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, repoPath, "init", "--bare")
+	}
+
+	// Random data does not compress, so its loose object takes about as much space.
+	data := make([]byte, 256*1024)
+	if _, err = rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	blobPath := filepath.Join(tmpDir, "blob")
+	if err = ioutil.WriteFile(blobPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, RepoPath(owner.Name, large.Name), "hash-object", "-w", blobPath)
+
+	if err = UpdateRepoSize(small); err != nil {
+		t.Fatal(err)
+	} else if err = UpdateRepoSizeByName(owner.Name, large.Name); err != nil {
+		t.Fatal(err)
+	}
+	if repo, err := GetRepositoryById(large.Id); err != nil {
+		t.Fatal(err)
+	} else if repo.GitSize < 250 || repo.GitSize > 300 {
+		t.Errorf("expect size of about 256 KB but got %d", repo.GitSize)
+	}
+	if err = UpdateRepoSizeByName(owner.Name, "missing"); err == nil {
+		t.Error("expect error for missing repository")
+	}
+
+	for _, c := range []struct {
+		min, max int64
+		expect   int
+	}{
+		{0, 0, 2},
+		{100, 0, 1},
+		{0, 100, 1},
+		{300, 0, 0},
+	} {
+		repos, err := SearchRepositoryByName(SearchOption{Keyword: "l", Limit: 10, MinSize: c.min, MaxSize: c.max})
+		if err != nil {
+			t.Fatal(err)
+		} else if len(repos) != c.expect {
+			t.Errorf("expect %d repositories within [%d, %d] KB but got %d", c.expect, c.min, c.max, len(repos))
+		} else if c.expect == 1 && (repos[0].Id == large.Id) != (c.min > 0) {
+			t.Errorf("unexpected repository %s within [%d, %d] KB", repos[0].Name, c.min, c.max)
+		}
+	}
+}
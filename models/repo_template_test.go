@@ -0,0 +1,50 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestCopyTemplateRepoSettings(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(Repository), new(Label), new(Milestone), new(Webhook))
+	defer cleanup()
+	var err error
+
+	templateRepo := &Repository{OwnerId: 1, Name: "template", LowerName: "template", IsTemplate: true}
+	repo := &Repository{OwnerId: 2, Name: "repo", LowerName: "repo"}
+	if _, err = x.Insert(templateRepo, repo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = x.Insert(&Label{RepoId: templateRepo.Id, Name: "bug", Color: "#ee0701"},
+		&Milestone{RepoId: templateRepo.Id, Index: 1, Name: "v1", IsClosed: true},
+		&Webhook{RepoId: templateRepo.Id, Url: "https://ci.example.com/hook", Secret: "s3cret", IsActive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = copyTemplateRepoSettings(x, templateRepo, repo); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := x.Count(&Label{RepoId: repo.Id}); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Errorf("expect 1 label but got %d", count)
+	}
+	if repo.NumMilestones != 1 || repo.NumClosedMilestones != 1 {
+		t.Errorf("expect 1 closed milestone but got %d of %d", repo.NumClosedMilestones, repo.NumMilestones)
+	}
+
+	ws := make([]*Webhook, 0, 1)
+	if err = x.Where("repo_id=?", repo.Id).Find(&ws); err != nil {
+		t.Fatal(err)
+	} else if len(ws) != 1 {
+		t.Fatalf("expect 1 webhook but got %d", len(ws))
+	} else if ws[0].Url != "https://ci.example.com/hook" || len(ws[0].Secret) > 0 || ws[0].IsActive {
+		t.Errorf("expect webhook to be copied inactive without secret but got %+v", ws[0])
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyRepoInitFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldGitignores := Gitignores
+	defer func() { Gitignores = oldGitignores }()
+	Gitignores = []string{"Go"}
+
+	target := filepath.Join(tmpDir, ".gitignore")
+	for _, name := range []string{"../../conf/app.ini", "../license/MIT", "C"} {
+		if err = copyRepoInitFile("gitignore", name, target); err != ErrRepoFileNotExist {
+			t.Errorf("%q: expect ErrRepoFileNotExist but got %v", name, err)
+		}
+	}
+	if err = copyRepoInitFile("license", "Go", target); err != ErrRepoFileNotExist {
+		t.Errorf("expect name of another type to be rejected but got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
+)
+
+const (
+	TRAFFIC_DATE_FORMAT = "2006-01-02"
+
+	// Maximum days of traffic that can be queried at once.
+	TRAFFIC_MAX_DAYS = 14
+)
+
+type TrafficType int
+
+const (
+	TRAFFIC_CLONE TrafficType = iota + 1
+	TRAFFIC_VIEW
+)
+
+// RepoTraffic represents clone and view counts of a repository in a day.
+type RepoTraffic struct {
+	Id             int64
+	RepoId         int64  `xorm:"UNIQUE(s) INDEX NOT NULL"`
+	Date           string `xorm:"UNIQUE(s) NOT NULL"` // In TRAFFIC_DATE_FORMAT.
+	Clones         int
+	UniqueCloners  int
+	Views          int
+	UniqueVisitors int
+}
+
+// RepoTrafficVisitor represents a visitor who has cloned or viewed a repository in a day,
+// it is only used to count unique visitors and pruned after TRAFFIC_MAX_DAYS.
+type RepoTrafficVisitor struct {
+	Id      int64
+	RepoId  int64       `xorm:"UNIQUE(s) NOT NULL"`
+	Date    string      `xorm:"UNIQUE(s) INDEX NOT NULL"`
+	Type    TrafficType `xorm:"UNIQUE(s) NOT NULL"`
+	Visitor string      `xorm:"UNIQUE(s) NOT NULL"`
+}
+
+// trafficVisitor returns identity of a visitor, signed in users are identified by ID,
+// others by a hash of their address so addresses are not stored.
+func trafficVisitor(uid int64, remoteAddr string) string {
+	if uid > 0 {
+		return "u:" + com.ToStr(uid)
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	return "a:" + base.EncodeSha1(remoteAddr)
+}
+
+// incrRepoTraffic increases counters of existing traffic record,
+// it returns false if the record does not exist yet.
+func incrRepoTraffic(repoId int64, date string, tp TrafficType, isUnique bool) (bool, error) {
+	var sql string
+	switch tp {
+	case TRAFFIC_CLONE:
+		sql = "UPDATE `repo_traffic` SET clones=clones+1"
+		if isUnique {
+			sql += ", unique_cloners=unique_cloners+1"
+		}
+	case TRAFFIC_VIEW:
+		sql = "UPDATE `repo_traffic` SET views=views+1"
+		if isUnique {
+			sql += ", unique_visitors=unique_visitors+1"
+		}
+	default:
+		return false, fmt.Errorf("unknown traffic type: %d", tp)
+	}
+
+	result, err := x.Exec(sql+" WHERE repo_id=? AND date=?", repoId, date)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// increaseRepoTraffic records a clone or view of repository by given visitor.
+func increaseRepoTraffic(repoId int64, tp TrafficType, uid int64, remoteAddr string) error {
+	date := time.Now().Format(TRAFFIC_DATE_FORMAT)
+
+	// Insertion only succeeds for first visit of the day.
+	_, err := x.Insert(&RepoTrafficVisitor{
+		RepoId:  repoId,
+		Date:    date,
+		Type:    tp,
+		Visitor: trafficVisitor(uid, remoteAddr),
+	})
+	isUnique := err == nil
+
+	has, err := incrRepoTraffic(repoId, date, tp, isUnique)
+	if err != nil {
+		return err
+	} else if has {
+		return nil
+	}
+
+	t := &RepoTraffic{RepoId: repoId, Date: date}
+	switch tp {
+	case TRAFFIC_CLONE:
+		t.Clones, t.UniqueCloners = 1, 1
+	case TRAFFIC_VIEW:
+		t.Views, t.UniqueVisitors = 1, 1
+	}
+	if _, err = x.Insert(t); err != nil {
+		// Another request may have inserted the same record in the meantime.
+		if has, err = incrRepoTraffic(repoId, date, tp, isUnique); err != nil {
+			return err
+		} else if !has {
+			return fmt.Errorf("insert repository traffic(%d:%s) failed", repoId, date)
+		}
+	}
+	return nil
+}
+
+// IncreaseRepoClones records a clone or fetch of repository by given user,
+// uid is 0 for anonymous one.
+func IncreaseRepoClones(repoId, uid int64, remoteAddr string) error {
+	return increaseRepoTraffic(repoId, TRAFFIC_CLONE, uid, remoteAddr)
+}
+
+// IncreaseRepoViews records a view of repository home page by given user,
+// uid is 0 for anonymous one.
+func IncreaseRepoViews(repoId, uid int64, remoteAddr string) error {
+	return increaseRepoTraffic(repoId, TRAFFIC_VIEW, uid, remoteAddr)
+}
+
+// trafficSince returns first date of given number of days that ends today.
+func trafficSince(days int) string {
+	if days <= 0 || days > TRAFFIC_MAX_DAYS {
+		days = TRAFFIC_MAX_DAYS
+	}
+	return time.Now().AddDate(0, 0, 1-days).Format(TRAFFIC_DATE_FORMAT)
+}
+
+// GetRepoTraffic returns daily traffic of repository in given number of days that ends today,
+// oldest first. Days without any traffic are not included.
+func GetRepoTraffic(repoId int64, days int) ([]*RepoTraffic, error) {
+	traffic := make([]*RepoTraffic, 0, TRAFFIC_MAX_DAYS)
+	return traffic, x.Where("repo_id=? AND date>=?", repoId, trafficSince(days)).
+		Asc("date").Find(&traffic)
+}
+
+// CountRepoTrafficUniques returns number of distinct visitors of repository
+// for given traffic type in given number of days that ends today.
+func CountRepoTrafficUniques(repoId int64, tp TrafficType, days int) (int64, error) {
+	results, err := x.Query("SELECT COUNT(DISTINCT visitor) AS num FROM `repo_traffic_visitor` WHERE repo_id=? AND type=? AND date>=?",
+		repoId, tp, trafficSince(days))
+	if err != nil {
+		return 0, err
+	} else if len(results) == 0 {
+		return 0, nil
+	}
+	return com.StrTo(results[0]["num"]).Int64()
+}
+
+// PruneRepoTrafficVisitors deletes visitor records that are no longer needed to count unique visitors.
+func PruneRepoTrafficVisitors() {
+	if _, err := x.Where("date<?", trafficSince(TRAFFIC_MAX_DAYS)).Delete(new(RepoTrafficVisitor)); err != nil {
+		log.Error(4, "PruneRepoTrafficVisitors: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestRepoTraffic(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(RepoTraffic), new(RepoTrafficVisitor))
+	defer cleanup()
+	var err error
+
+	// Same user twice, and two anonymous visitors of which one visits twice from another port.
+	for _, v := range []struct {
+		uid  int64
+		addr string
+	}{
+		{1, "10.0.0.1:1234"},
+		{1, "10.0.0.2:1234"},
+		{0, "10.0.0.3:1234"},
+		{0, "10.0.0.3:5678"},
+		{0, "10.0.0.4:1234"},
+	} {
+		if err = IncreaseRepoViews(1, v.uid, v.addr); err != nil {
+			t.Fatalf("IncreaseRepoViews: %v", err)
+		}
+	}
+	if err = IncreaseRepoClones(1, 1, "10.0.0.1:1234"); err != nil {
+		t.Fatalf("IncreaseRepoClones: %v", err)
+	}
+	if err = IncreaseRepoClones(2, 1, "10.0.0.1:1234"); err != nil {
+		t.Fatalf("IncreaseRepoClones: %v", err)
+	}
+
+	traffic, err := GetRepoTraffic(1, 14)
+	if err != nil {
+		t.Fatalf("GetRepoTraffic: %v", err)
+	} else if len(traffic) != 1 {
+		t.Fatalf("expect 1 day of traffic but got %d", len(traffic))
+	}
+	tr := traffic[0]
+	if tr.Views != 5 || tr.UniqueVisitors != 3 || tr.Clones != 1 || tr.UniqueCloners != 1 {
+		t.Errorf("unexpected traffic: %+v", tr)
+	}
+
+	if uniques, err := CountRepoTrafficUniques(1, TRAFFIC_VIEW, 14); err != nil {
+		t.Fatalf("CountRepoTrafficUniques: %v", err)
+	} else if uniques != 3 {
+		t.Errorf("expect 3 unique visitors but got %d", uniques)
+	}
+
+	// Visitors of other days are pruned, today's are kept.
+	if _, err = x.Insert(&RepoTrafficVisitor{RepoId: 1, Date: "2000-01-01", Type: TRAFFIC_VIEW, Visitor: "u:1"}); err != nil {
+		t.Fatal(err)
+	}
+	PruneRepoTrafficVisitors()
+	if count, err := x.Count(new(RepoTrafficVisitor)); err != nil {
+		t.Fatal(err)
+	} else if count != 5 {
+		t.Errorf("expect 5 visitor records after pruning but got %d", count)
+	}
+}
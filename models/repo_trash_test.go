@@ -0,0 +1,70 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestAdminRestoreRepository(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(Repository), new(Access), new(Watch), new(Collaboration))
+	defer cleanup()
+	var err error
+
+	oldTrashPath := setting.RepoTrashPath
+	defer func() { setting.RepoTrashPath = oldTrashPath }()
+	setting.RepoRootPath = filepath.Join(tmpDir, "repositories")
+	setting.RepoTrashPath = filepath.Join(tmpDir, "trash")
+
+	u := &User{Name: "Alice", LowerName: "alice", Email: "alice@example.com"}
+	if _, err = x.Insert(u); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"MyRepo", "Legacy"} {
+		if out, err := exec.Command("git", "init", "--bare", RepoPath(u.Name, name)).CombinedOutput(); err != nil {
+			t.Fatalf("git init: %v - %s", err, out)
+		} else if err = removeRepoFiles(u.Id, u.Name, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Repositories moved to trash before metadata was recorded.
+	if err = os.Remove(filepath.Join(trashRepoPath(u.Name, "Legacy"), TRASH_META_FILE)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Owner is found by ID even if renamed after repository was deleted.
+	if _, err = x.Id(u.Id).Cols("name", "lower_name").Update(&User{Name: "Alicia", LowerName: "alicia"}); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := AdminRestoreRepository("alice/myrepo.git")
+	if err != nil {
+		t.Fatal(err)
+	} else if repo.OwnerId != u.Id || repo.Name != "MyRepo" || !repo.IsPrivate {
+		t.Errorf("expect private repository MyRepo of user(%d) but got %+v", u.Id, repo)
+	}
+	repoPath := RepoPath("Alicia", "MyRepo")
+	if !com.IsDir(repoPath) {
+		t.Errorf("expect repository to be moved to %s", repoPath)
+	} else if com.IsExist(filepath.Join(repoPath, TRASH_META_FILE)) {
+		t.Error("expect trash metadata to be removed from restored repository")
+	}
+	if _, err = AdminRestoreRepository("alice/myrepo.git"); err != ErrTrashRepoNotExist {
+		t.Errorf("expect restored repository to be gone from trash but got %v", err)
+	}
+
+	// Without metadata, owner is found by directory name that no longer exists.
+	if _, err = AdminRestoreRepository("alice/legacy.git"); err != ErrUserNotExist {
+		t.Errorf("expect owner of legacy trash not to be found but got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/search"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// SearchIndexTask represents a pushed commit waiting to be added to search index.
+// Commits are queued by update hook and indexed by web server,
+// because search index can only be opened by one process at a time.
+type SearchIndexTask struct {
+	Id      int64
+	RepoId  int64
+	Sha     string `xorm:"VARCHAR(40)"`
+	Message string `xorm:"TEXT"`
+	Author  string
+	Created time.Time `xorm:"CREATED"`
+}
+
+// queueCommitsForIndex adds given list of commits to search index queue
+// when full-text search is enabled.
+func queueCommitsForIndex(repoId int64, l *list.List) error {
+	if !setting.Search.EnableBleve {
+		return nil
+	}
+
+	tasks := make([]*SearchIndexTask, 0, l.Len())
+	for e := l.Front(); e != nil; e = e.Next() {
+		commit := e.Value.(*git.Commit)
+		tasks = append(tasks, &SearchIndexTask{
+			RepoId:  repoId,
+			Sha:     commit.Id.String(),
+			Message: commit.Message(),
+			Author:  commit.Author.Name,
+		})
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	_, err := x.Insert(&tasks)
+	return err
+}
+
+// IndexQueuedCommits adds queued commits to search index.
+func IndexQueuedCommits() {
+	if search.Engine == nil {
+		return
+	}
+
+	for {
+		tasks := make([]*SearchIndexTask, 0, 100)
+		if err := x.Asc("id").Limit(100).Find(&tasks); err != nil {
+			log.Error(4, "IndexQueuedCommits: %v", err)
+			return
+		} else if len(tasks) == 0 {
+			return
+		}
+
+		for _, t := range tasks {
+			if err := search.Engine.IndexCommit(t.RepoId, t.Sha, t.Message, t.Author); err != nil {
+				log.Error(4, "IndexCommit[%d:%s]: %v", t.RepoId, t.Sha, err)
+				return
+			}
+			if _, err := x.Delete(&SearchIndexTask{Id: t.Id}); err != nil {
+				log.Error(4, "Delete search index task[%d]: %v", t.Id, err)
+				return
+			}
+		}
+	}
+}
+
+// indexIssue adds or replaces issue in search index when full-text search is enabled.
+func indexIssue(issue *Issue) {
+	if search.Engine == nil {
+		return
+	}
+	if err := search.Engine.IndexIssue(&search.Issue{
+		Id:      issue.Id,
+		RepoId:  issue.RepoId,
+		Index:   issue.Index,
+		Title:   issue.Name,
+		Content: issue.Content,
+	}); err != nil {
+		log.Error(4, "IndexIssue[%d]: %v", issue.Id, err)
+	}
+}
+
+// indexAllIssues adds all existing issues of repositories that have issues enabled to search index.
+func indexAllIssues() {
+	if err := x.Where("repo_id IN (SELECT id FROM `repository` WHERE has_issues=?)", true).Iterate(new(Issue), func(idx int, bean interface{}) error {
+		indexIssue(bean.(*Issue))
+		return nil
+	}); err != nil {
+		log.Error(4, "indexAllIssues: %v", err)
+	}
+}
+
+// NewSearchContext opens search index when full-text search is enabled,
+// existing issues are indexed in background when index is newly created.
+func NewSearchContext() {
+	isNew, err := search.NewContext()
+	if err != nil {
+		log.Fatal(4, "Fail to initialize search engine: %v", err)
+	} else if isNew {
+		go indexAllIssues()
+	}
+}
+
+// unindexRepository removes commits and issues of deleted repository from search index.
+func unindexRepository(repoId int64) {
+	if search.Engine == nil {
+		return
+	}
+	if err := search.Engine.DeleteRepository(repoId); err != nil {
+		log.Error(4, "DeleteRepository[%d]: %v", repoId, err)
+	}
+}
+
+// indexRepoIssues adds issues of given repository to search index when they are enabled,
+// or removes them from the index when they are disabled.
+func indexRepoIssues(repoId int64, hasIssues bool) {
+	if search.Engine == nil {
+		return
+	}
+
+	if !hasIssues {
+		if err := search.Engine.DeleteIssues(repoId); err != nil {
+			log.Error(4, "DeleteIssues[%d]: %v", repoId, err)
+		}
+		return
+	}
+	if err := x.Where("repo_id=?", repoId).Iterate(new(Issue), func(idx int, bean interface{}) error {
+		indexIssue(bean.(*Issue))
+		return nil
+	}); err != nil {
+		log.Error(4, "indexRepoIssues[%d]: %v", repoId, err)
+	}
+}
+
+// searchableRepoCond returns SQL condition on ID of repository that matches
+// repositories given user can read, user is nil for anonymous visitors.
+func searchableRepoCond(u *User) (string, []interface{}) {
+	if u == nil {
+		return "SELECT id FROM `repository` WHERE is_private=?", []interface{}{false}
+	}
+	return "SELECT id FROM `repository` WHERE is_private=? OR owner_id=? " +
+			"UNION SELECT repo_id FROM `access` WHERE user_id=? AND mode>=?",
+		[]interface{}{false, u.Id, u.Id, ACCESS_MODE_READ}
+}
+
+// SearchIssues returns a page of issues whose title or content contains keyword,
+// of repositories that have issues enabled and given user can read.
+func SearchIssues(keyword string, u *User, page, size int) ([]*Issue, error) {
+	keyword = strings.TrimSpace(keyword)
+	if len(keyword) == 0 {
+		return []*Issue{}, nil
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	cond, args := searchableRepoCond(u)
+	keyword = "%" + keyword + "%"
+	issues := make([]*Issue, 0, size)
+	return issues, x.Where("repo_id IN ("+cond+")", args...).
+		And("repo_id IN (SELECT id FROM `repository` WHERE has_issues=?)", true).
+		And("(name LIKE ? OR content LIKE ?)", keyword, keyword).
+		Desc("updated").Limit(size, (page-1)*size).Find(&issues)
+}
+
+// SearchAll returns a page of commits and issues that match keyword of repositories
+// given user can read. Only issues are searched with SearchIssues when full-text
+// search is not enabled.
+func SearchAll(keyword string, u *User, page, size int) ([]*search.SearchResult, error) {
+	if search.Engine != nil {
+		repoIds, err := GetSearchableRepoIds(u)
+		if err != nil {
+			return nil, fmt.Errorf("GetSearchableRepoIds: %v", err)
+		}
+		return search.Engine.SearchAll(keyword, repoIds, page, size)
+	}
+
+	issues, err := SearchIssues(keyword, u, page, size)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*search.SearchResult, len(issues))
+	for i, issue := range issues {
+		results[i] = &search.SearchResult{
+			Type:    search.RESULT_ISSUE,
+			RepoId:  issue.RepoId,
+			IssueId: issue.Id,
+			Index:   issue.Index,
+			Title:   issue.Name,
+		}
+	}
+	return results, nil
+}
+
+// GetSearchableRepoIds returns IDs of public repositories and private repositories
+// that given user can read, user is nil for anonymous visitors.
+func GetSearchableRepoIds(u *User) ([]int64, error) {
+	repos := make([]*Repository, 0, 50)
+	sess := x.Cols("id").Where("is_private=?", false)
+	if u != nil {
+		sess = sess.Or("owner_id=?", u.Id)
+	}
+	if err := sess.Find(&repos); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(repos))
+	seen := make(map[int64]bool, len(repos))
+	for _, repo := range repos {
+		ids = append(ids, repo.Id)
+		seen[repo.Id] = true
+	}
+	if u == nil {
+		return ids, nil
+	}
+
+	accesses := make([]*Access, 0, 10)
+	if err := x.Find(&accesses, &Access{UserID: u.Id}); err != nil {
+		return nil, err
+	}
+	for _, access := range accesses {
+		if access.Mode >= ACCESS_MODE_READ && !seen[access.RepoID] {
+			ids = append(ids, access.RepoID)
+			seen[access.RepoID] = true
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,63 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/gogits/gogs/modules/search"
+)
+
+func TestSearchAllFallback(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(Repository), new(Access), new(Issue))
+	defer cleanup()
+	var err error
+	search.Engine = nil
+
+	public := &Repository{OwnerId: 1, LowerName: "public", Name: "public", HasIssues: true}
+	private := &Repository{OwnerId: 1, LowerName: "private", Name: "private", IsPrivate: true, HasIssues: true}
+	shared := &Repository{OwnerId: 3, LowerName: "shared", Name: "shared", IsPrivate: true, HasIssues: true}
+	disabled := &Repository{OwnerId: 1, LowerName: "disabled", Name: "disabled", HasIssues: true}
+	if _, err = x.Insert(public, private, shared, disabled); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = x.Insert(&Access{UserID: 2, RepoID: shared.Id, Mode: ACCESS_MODE_READ}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = x.Insert(
+		&Issue{RepoId: public.Id, Index: 1, Name: "Crash on startup"},
+		&Issue{RepoId: private.Id, Index: 1, Name: "Secret crash"},
+		&Issue{RepoId: shared.Id, Index: 1, Name: "Other", Content: "crash when pushing"},
+		&Issue{RepoId: disabled.Id, Index: 1, Name: "Hidden crash"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = UpdateRepoSettings(disabled.Id, RepoSettings{HasIssues: false, HasWiki: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		user   *User
+		expect int
+	}{
+		{nil, 1},
+		{&User{Id: 1}, 2},
+		{&User{Id: 2}, 2},
+	}
+	for _, c := range cases {
+		results, err := SearchAll("crash", c.user, 1, 10)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(results) != c.expect {
+			t.Errorf("user %v: expect %d results but got %d", c.user, c.expect, len(results))
+		}
+		for _, r := range results {
+			if r.Type != search.RESULT_ISSUE {
+				t.Errorf("expect only issues without Bleve but got %q", r.Type)
+			}
+		}
+	}
+}
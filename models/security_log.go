@@ -0,0 +1,173 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const SECURITY_LOG_PAGE_SIZE = 30
+
+// SecurityActorType represents who performed a security related operation.
+type SecurityActorType int
+
+const (
+	SECURITY_ACTOR_SELF SecurityActorType = iota + 1
+	SECURITY_ACTOR_ADMIN
+	SECURITY_ACTOR_TOKEN
+	SECURITY_ACTOR_SYSTEM
+)
+
+// Operations on keys that are recorded in security log.
+const (
+	SECURITY_OP_KEY_ADD     = "key_add"
+	SECURITY_OP_KEY_DELETE  = "key_delete"
+	SECURITY_OP_KEY_DISABLE = "key_disable"
+	SECURITY_OP_KEY_ENABLE  = "key_enable"
+	SECURITY_OP_KEY_APPROVE = "key_approve"
+	SECURITY_OP_KEY_REJECT  = "key_reject"
+	SECURITY_OP_KEY_EXPIRE  = "key_expire"
+)
+
+// SecurityActor represents the user, admin, access token or system
+// that performed a security related operation.
+type SecurityActor struct {
+	Type SecurityActorType
+	Id   int64
+	Name string // User name, or access token name for token actor.
+}
+
+// SecurityActorSystem represents operations performed by scheduled tasks.
+var SecurityActorSystem = SecurityActor{Type: SECURITY_ACTOR_SYSTEM}
+
+// SecurityLog represents a security related operation on user account.
+// Key name and fingerprint are stored as text so records stay
+// meaningful after key has been deleted.
+type SecurityLog struct {
+	Id             int64
+	OwnerId        int64 `xorm:"INDEX"`
+	OwnerName      string
+	ActorType      SecurityActorType
+	ActorId        int64
+	ActorName      string
+	Operation      string
+	KeyName        string
+	KeyFingerprint string
+	Created        time.Time `xorm:"CREATED INDEX"`
+}
+
+// IsSelf returns true if the operation is performed by owner via web.
+func (l *SecurityLog) IsSelf() bool {
+	return l.ActorType == SECURITY_ACTOR_SELF
+}
+
+// IsAdmin returns true if the operation is performed by an admin.
+func (l *SecurityLog) IsAdmin() bool {
+	return l.ActorType == SECURITY_ACTOR_ADMIN
+}
+
+// IsToken returns true if the operation is performed with an access token.
+func (l *SecurityLog) IsToken() bool {
+	return l.ActorType == SECURITY_ACTOR_TOKEN
+}
+
+// IsSystem returns true if the operation is performed by scheduled tasks.
+func (l *SecurityLog) IsSystem() bool {
+	return l.ActorType == SECURITY_ACTOR_SYSTEM
+}
+
+// logKeyOperation records an operation on given key in security log of its owner,
+// and creates hook tasks of system webhooks for it. It must be called within
+// the transaction of the operation, so the operation is rolled back when it fails.
+func logKeyOperation(e Engine, key *PublicKey, op string, actor SecurityActor) error {
+	var ownerName string
+	owner, err := getUserById(e, key.OwnerId)
+	if err == nil {
+		ownerName = owner.Name
+	} else if err != ErrUserNotExist {
+		return fmt.Errorf("getUserById: %v", err)
+	}
+	return logKeyOperationOfOwner(e, key, ownerName, op, actor)
+}
+
+// logKeyOperationOfOwner is same as logKeyOperation but with name of owner of key given,
+// which is used when owner is being deleted.
+func logKeyOperationOfOwner(e Engine, key *PublicKey, ownerName, op string, actor SecurityActor) error {
+	if _, err := e.Insert(&SecurityLog{
+		OwnerId:        key.OwnerId,
+		OwnerName:      ownerName,
+		ActorType:      actor.Type,
+		ActorId:        actor.Id,
+		ActorName:      actor.Name,
+		Operation:      op,
+		KeyName:        key.Name,
+		KeyFingerprint: key.Fingerprint,
+	}); err != nil {
+		return fmt.Errorf("insert security log: %v", err)
+	} else if err = prepareKeyWebhooks(e, key, ownerName, op, actor); err != nil {
+		return fmt.Errorf("prepareKeyWebhooks: %v", err)
+	}
+	return nil
+}
+
+// keyOperationEvents maps operations on keys to events of system webhooks,
+// key_remove is fired whenever key can no longer be used.
+var keyOperationEvents = map[string]HookEventType{
+	SECURITY_OP_KEY_ADD:     KEY_ADD,
+	SECURITY_OP_KEY_ENABLE:  KEY_ADD,
+	SECURITY_OP_KEY_APPROVE: KEY_ADD,
+	SECURITY_OP_KEY_DELETE:  KEY_REMOVE,
+	SECURITY_OP_KEY_DISABLE: KEY_REMOVE,
+	SECURITY_OP_KEY_REJECT:  KEY_REMOVE,
+	SECURITY_OP_KEY_EXPIRE:  KEY_REMOVE,
+}
+
+// prepareKeyWebhooks creates hook tasks of system webhooks for an operation on key.
+func prepareKeyWebhooks(e Engine, key *PublicKey, ownerName, op string, actor SecurityActor) error {
+	event, ok := keyOperationEvents[op]
+	if !ok {
+		return nil
+	}
+	return prepareSystemWebhooks(e, event, &KeyPayload{
+		Action: strings.TrimPrefix(op, "key_"),
+		Key: &PayloadKey{
+			Id:          key.Id,
+			Name:        key.Name,
+			Fingerprint: key.Fingerprint,
+			Type:        key.Type,
+			Size:        key.Size,
+			Content:     key.OmitEmail(),
+		},
+		User: &PayloadUser{
+			Id:       key.OwnerId,
+			UserName: ownerName,
+		},
+		Actor: &PayloadActor{
+			Type: securityActorTypeNames[actor.Type],
+			Id:   actor.Id,
+			Name: actor.Name,
+		},
+	})
+}
+
+// SearchSecurityLogs returns a page of security logs of given user,
+// or all users when ownerID is zero, latest first.
+func SearchSecurityLogs(ownerID int64, page int) ([]*SecurityLog, error) {
+	if page < 1 {
+		page = 1
+	}
+	logs := make([]*SecurityLog, 0, SECURITY_LOG_PAGE_SIZE)
+	return logs, x.Desc("id").Limit(SECURITY_LOG_PAGE_SIZE, (page-1)*SECURITY_LOG_PAGE_SIZE).
+		Find(&logs, &SecurityLog{OwnerId: ownerID})
+}
+
+// CountSecurityLogs returns number of security logs of given user,
+// or all users when ownerID is zero.
+func CountSecurityLogs(ownerID int64) int64 {
+	count, _ := x.Count(&SecurityLog{OwnerId: ownerID})
+	return count
+}
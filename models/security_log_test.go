@@ -0,0 +1,115 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSecurityLogSurvivesKeyDeletion(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	var err error
+	SSHPath = tmpDir
+
+	key := &PublicKey{
+		OwnerId:     1,
+		Name:        "laptop",
+		Fingerprint: "fingerprint",
+		Content:     "ssh-rsa AAAAB3NzaC1yc2E user1@fake.local",
+	}
+	if _, err = x.Insert(key); err != nil {
+		t.Fatal(err)
+	}
+	if err = RewriteAllPublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if err = DeletePublicKey(1, key.Id, SecurityActor{SECURITY_ACTOR_TOKEN, 1, "ci"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := CountSecurityLogs(1); count != 1 {
+		t.Fatalf("expect 1 security log but got %d", count)
+	} else if count = CountSecurityLogs(2); count != 0 {
+		t.Errorf("expect no security log of other user but got %d", count)
+	}
+
+	logs, err := SearchSecurityLogs(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(logs) != 1 {
+		t.Fatalf("expect 1 security log of all users but got %d", len(logs))
+	}
+	l := logs[0]
+	if l.KeyName != "laptop" || l.KeyFingerprint != "fingerprint" || !l.IsToken() || l.ActorName != "ci" {
+		t.Errorf("unexpected security log: %+v", l)
+	}
+}
+
+func TestKeyOperationSystemWebhooks(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(PublicKey), new(SecurityLog), new(Webhook), new(HookTask))
+	defer cleanup()
+	var err error
+
+	// Only active system webhooks that subscribed to key events receive tasks.
+	hooks := []*Webhook{
+		{Url: "http://localhost/system", IsActive: true, HookTaskType: GOGS, HookEvent: &HookEvent{Key: true}},
+		{Url: "http://localhost/inactive", IsActive: false, HookTaskType: GOGS, HookEvent: &HookEvent{Key: true}},
+		{Url: "http://localhost/repo", RepoId: 1, IsActive: true, HookTaskType: GOGS, HookEvent: &HookEvent{Key: true}},
+		{Url: "http://localhost/push", IsActive: true, HookTaskType: GOGS, HookEvent: &HookEvent{PushOnly: true}},
+	}
+	for _, w := range hooks {
+		if err = w.UpdateEvent(); err != nil {
+			t.Fatal(err)
+		} else if err = CreateWebhook(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := &PublicKey{
+		Id:          3,
+		OwnerId:     1,
+		Name:        "laptop",
+		Fingerprint: "fingerprint",
+		Type:        "rsa",
+		Size:        2048,
+		Content:     "ssh-rsa AAAAB3NzaC1yc2E user1@fake.local",
+	}
+	if err = logKeyOperation(x, key, SECURITY_OP_KEY_ADD, SecurityActor{SECURITY_ACTOR_SELF, 1, "user1"}); err != nil {
+		t.Fatal(err)
+	} else if err = logKeyOperation(x, key, SECURITY_OP_KEY_EXPIRE, SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := make([]*HookTask, 0, 2)
+	if err = x.Asc("id").Find(&tasks); err != nil {
+		t.Fatal(err)
+	} else if len(tasks) != 2 {
+		t.Fatalf("expect 2 hook tasks but got %d", len(tasks))
+	}
+	for i, event := range []HookEventType{KEY_ADD, KEY_REMOVE} {
+		if tasks[i].EventType != event || tasks[i].Url != "http://localhost/system" {
+			t.Errorf("unexpected hook task: %+v", tasks[i])
+		}
+	}
+
+	var p KeyPayload
+	if err = json.Unmarshal([]byte(tasks[1].PayloadContent), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Action != "expire" || p.Actor.Type != "system" {
+		t.Errorf("unexpected action or actor: %s %+v", p.Action, p.Actor)
+	}
+	if p.Key.Fingerprint != "fingerprint" || p.Key.Size != 2048 || p.Key.Content != "ssh-rsa AAAAB3NzaC1yc2E" {
+		t.Errorf("unexpected key: %+v", p.Key)
+	}
+	if strings.Contains(tasks[1].PayloadContent, "fake.local") {
+		t.Errorf("payload must not contain comment of key: %s", tasks[1].PayloadContent)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var (
+	ErrSiteHookNotExist     = errors.New("Site hook does not exist")
+	ErrInvalidSiteHookEvent = errors.New("Invalid site hook event")
+)
+
+// SiteHook represents a site-wide Git hook script
+// that runs before repository-level hooks of every repository.
+type SiteHook struct {
+	Id      int64
+	Event   string `xorm:"INDEX NOT NULL"`
+	Content string `xorm:"TEXT"`
+	Active  bool
+	Created time.Time `xorm:"CREATED"`
+	Updated time.Time `xorm:"UPDATED"`
+}
+
+// CreateSiteHook creates a new site hook.
+func CreateSiteHook(h *SiteHook) error {
+	if !git.IsServerHookName(h.Event) {
+		return ErrInvalidSiteHookEvent
+	}
+	if _, err := x.Insert(h); err != nil {
+		return err
+	}
+	return RewriteSiteHooks()
+}
+
+// GetSiteHookById returns site hook by given ID.
+func GetSiteHookById(id int64) (*SiteHook, error) {
+	h := new(SiteHook)
+	has, err := x.Id(id).Get(h)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrSiteHookNotExist
+	}
+	return h, nil
+}
+
+// GetSiteHooks returns all site hooks.
+func GetSiteHooks() ([]*SiteHook, error) {
+	hooks := make([]*SiteHook, 0, 5)
+	return hooks, x.Asc("id").Find(&hooks)
+}
+
+// GetActiveSiteHooks returns all active site hooks of given event in running order.
+func GetActiveSiteHooks(event string) ([]*SiteHook, error) {
+	hooks := make([]*SiteHook, 0, 5)
+	return hooks, x.Where("event=? AND active=?", event, true).Asc("id").Find(&hooks)
+}
+
+// UpdateSiteHook updates information of site hook.
+func UpdateSiteHook(h *SiteHook) error {
+	if !git.IsServerHookName(h.Event) {
+		return ErrInvalidSiteHookEvent
+	}
+	if _, err := x.Id(h.Id).AllCols().Update(h); err != nil {
+		return err
+	}
+	return RewriteSiteHooks()
+}
+
+// DeleteSiteHook deletes site hook by given ID.
+func DeleteSiteHook(id int64) error {
+	if _, err := x.Delete(&SiteHook{Id: id}); err != nil {
+		return err
+	}
+	return RewriteSiteHooks()
+}
+
+// RewriteSiteHooks removes all site hook scripts and writes active ones from database again.
+// Scripts are named by zero-padded ID so shell globbing runs them in order.
+func RewriteSiteHooks() error {
+	for _, event := range git.ServerHookNames {
+		hookDir := path.Join(setting.SiteHookRoot, event)
+		if err := os.RemoveAll(hookDir); err != nil {
+			return err
+		} else if err = os.MkdirAll(hookDir, os.ModePerm); err != nil {
+			return err
+		}
+
+		hooks, err := GetActiveSiteHooks(event)
+		if err != nil {
+			return fmt.Errorf("GetActiveSiteHooks(%s): %v", event, err)
+		}
+		for _, h := range hooks {
+			if err = ioutil.WriteFile(path.Join(hookDir, fmt.Sprintf("%010d", h.Id)),
+				[]byte(strings.Replace(h.Content, "\r", "", -1)), 0777); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestRewriteSiteHooks(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(SiteHook))
+	defer cleanup()
+	var err error
+
+	oldSiteHookRoot := setting.SiteHookRoot
+	defer func() { setting.SiteHookRoot = oldSiteHookRoot }()
+	setting.SiteHookRoot = filepath.Join(tmpDir, "site_hooks")
+
+	if err = CreateSiteHook(&SiteHook{Event: "post-update", Content: "exit 0", Active: true}); err != ErrInvalidSiteHookEvent {
+		t.Errorf("expect ErrInvalidSiteHookEvent but got %v", err)
+	}
+
+	active := &SiteHook{Event: "pre-receive", Content: "#!/bin/sh\r\nexit 0\r\n", Active: true}
+	inactive := &SiteHook{Event: "pre-receive", Content: "#!/bin/sh\nexit 1\n"}
+	for _, h := range []*SiteHook{active, inactive} {
+		if err = CreateSiteHook(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hookPath := func(h *SiteHook) string {
+		return filepath.Join(setting.SiteHookRoot, h.Event, fmt.Sprintf("%010d", h.Id))
+	}
+	if data, err := ioutil.ReadFile(hookPath(active)); err != nil {
+		t.Fatalf("expect script of active hook: %v", err)
+	} else if string(data) != "#!/bin/sh\nexit 0\n" {
+		t.Errorf("expect carriage returns to be removed but got %q", data)
+	}
+	if _, err = os.Stat(hookPath(inactive)); !os.IsNotExist(err) {
+		t.Errorf("expect no script of inactive hook but got %v", err)
+	}
+	for _, event := range []string{"update", "post-receive"} {
+		if _, err = os.Stat(filepath.Join(setting.SiteHookRoot, event)); err != nil {
+			t.Errorf("expect directory of %s hooks: %v", event, err)
+		}
+	}
+
+	// Moving hook to another event removes its old script.
+	active.Event = "update"
+	if err = UpdateSiteHook(active); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(setting.SiteHookRoot, "pre-receive", fmt.Sprintf("%010d", active.Id))); !os.IsNotExist(err) {
+		t.Errorf("expect old script to be removed but got %v", err)
+	} else if _, err = os.Stat(hookPath(active)); err != nil {
+		t.Errorf("expect script of moved hook: %v", err)
+	}
+
+	if err = DeleteSiteHook(active.Id); err != nil {
+		t.Fatal(err)
+	} else if _, err = os.Stat(hookPath(active)); !os.IsNotExist(err) {
+		t.Errorf("expect script of deleted hook to be removed but got %v", err)
+	}
+	if _, err = GetSiteHookById(active.Id); err != ErrSiteHookNotExist {
+		t.Errorf("expect ErrSiteHookNotExist but got %v", err)
+	}
+}
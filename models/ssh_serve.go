@@ -0,0 +1,269 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// Messages told to SSH clients when a request is rejected, users grep for them
+// so wording should not be changed lightly.
+const (
+	SSH_ACCESS_DENIED_MESSAGE     = "Repository does not exist or you do not have access"
+	SSH_REPO_NOT_EXIST_MESSAGE    = "Repository does not exist"
+	SSH_WRITE_DENIED_MESSAGE      = "You do not have write access to this repository"
+	SSH_KEY_EXPIRED_MESSAGE       = "Key has expired, please add a new key in your account settings"
+	SSH_ACCOUNT_SUSPENDED_MESSAGE = "Your account has been suspended, please contact the site administrator"
+	SSH_ARCHIVE_DISABLED_MESSAGE  = "Archive access over SSH has been disabled by site administrator"
+)
+
+// Environment variables passed to git commands run over SSH,
+// so that server-side hooks can tell who is pushing.
+const (
+	ENV_KEY_ID     = "GOGS_KEY_ID"
+	ENV_USER_ID    = "GOGS_USER_ID"
+	ENV_USER_NAME  = "GOGS_USER_NAME"
+	ENV_REPO_OWNER = "GOGS_REPO_OWNER"
+	ENV_REPO_NAME  = "GOGS_REPO_NAME"
+)
+
+// SSHCommandModes maps git commands that can be run over SSH to access mode they require,
+// git-upload-archive serves repository contents and is treated as a read like git-upload-pack.
+var SSHCommandModes = map[string]AccessMode{
+	"git-upload-pack":    ACCESS_MODE_READ,
+	"git-upload-archive": ACCESS_MODE_READ,
+	"git-receive-pack":   ACCESS_MODE_WRITE,
+}
+
+// ErrSSHAccess represents a rejected SSH request, message is shown to client
+// and reason is only logged.
+type ErrSSHAccess struct {
+	Message string
+	Reason  string
+}
+
+func (err ErrSSHAccess) Error() string {
+	return err.Reason
+}
+
+func IsErrSSHAccess(err error) bool {
+	_, ok := err.(ErrSSHAccess)
+	return ok
+}
+
+func errSSHAccess(message, format string, args ...interface{}) error {
+	return ErrSSHAccess{message, fmt.Sprintf(format, args...)}
+}
+
+// SSHErrorLine returns line written to stderr of SSH client when request
+// fails with given error, details of internal errors are not told to client.
+func SSHErrorLine(err error) string {
+	msg := "Internal error"
+	if IsErrSSHAccess(err) {
+		msg = err.(ErrSSHAccess).Message
+	}
+	return "Gogs: " + msg + "\n"
+}
+
+// CheckSSHKey returns an error if given key cannot be used to access repositories over SSH.
+func CheckSSHKey(key *PublicKey) error {
+	switch {
+	case key.IsDisabled:
+		return errSSHAccess("Key has been disabled", "Disabled public key(%d) is still present in authorized_keys", key.Id)
+	case key.IsPending:
+		return errSSHAccess("Key is waiting for approval", "Pending public key(%d) is present in authorized_keys", key.Id)
+	case !key.IsUsable():
+		return errSSHAccess("Key has not been verified", "Unverified public key(%d) is present in authorized_keys", key.Id)
+	}
+	if expires := key.ExpiresAt(); !expires.IsZero() && time.Now().After(expires) {
+		return errSSHAccess(SSH_KEY_EXPIRED_MESSAGE, "Expired public key(%d) is still present in authorized_keys", key.Id)
+	}
+	return nil
+}
+
+// GetSSHKeyAndOwner reloads key of given ID with its owner, and checks that
+// both of them can still access repositories, since authorized_keys file may be stale.
+// Errors that can be told to client are ErrSSHAccess.
+func GetSSHKeyAndOwner(keyId int64) (*PublicKey, *User, error) {
+	key, err := GetPublicKeyById(keyId)
+	if err != nil {
+		if err == ErrKeyNotExist {
+			return nil, nil, errSSHAccess("Key does not exist", "Deleted public key(%d) is still present in authorized_keys", keyId)
+		}
+		return nil, nil, fmt.Errorf("Fail to get public key(%d): %v", keyId, err)
+	} else if err = CheckSSHKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	owner, err := GetUserById(key.OwnerId)
+	if err != nil {
+		if err == ErrUserNotExist {
+			return nil, nil, errSSHAccess("Key does not exist", "Owner(%d) of public key(%d) does not exist", key.OwnerId, keyId)
+		}
+		return nil, nil, fmt.Errorf("Fail to get owner of public key(%d): %v", keyId, err)
+	} else if !owner.IsActive {
+		return nil, nil, errSSHAccess(SSH_ACCOUNT_SUSPENDED_MESSAGE, "Owner(%s) of public key(%d) is not active", owner.Name, keyId)
+	}
+	return key, owner, nil
+}
+
+// CheckSSHKeyCommand returns an error if given key is not allowed to run git command
+// regardless of access its owner has, e.g. read-only key can never push.
+func CheckSSHKeyCommand(key *PublicKey, cmd string) error {
+	verb, _ := ParseSSHCommand(cmd)
+	if key.IsReadOnly() && SSHCommandModes[verb] > ACCESS_MODE_READ {
+		return errSSHAccess(fmt.Sprintf("Key '%s' is read-only and cannot be used to push", key.Name),
+			"Read-only public key(%d) cannot run %s", key.Id, verb)
+	}
+	return nil
+}
+
+// CheckSSHRequest checks that key of given ID can be used to run given git command,
+// and returns the key, its owner and the command. Command is nil when cmd is empty,
+// which means client only tests authentication.
+func CheckSSHRequest(keyId int64, cmd string) (*PublicKey, *User, *SSHCommand, error) {
+	key, user, err := GetSSHKeyAndOwner(keyId)
+	if err != nil {
+		return nil, nil, nil, err
+	} else if len(cmd) == 0 {
+		return key, user, nil, nil
+	}
+
+	// Restrictions of key apply before access of its owner is checked.
+	if err = CheckSSHKeyCommand(key, cmd); err != nil {
+		return nil, nil, nil, err
+	}
+	sshCmd, err := CheckSSHCommand(user, cmd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return key, user, sshCmd, nil
+}
+
+// CheckSSHUserRequest checks that user of given ID, who has authenticated without
+// a public key in database, e.g. by SSH certificate, can run given git command.
+// Command is nil when cmd is empty.
+func CheckSSHUserRequest(userId int64, cmd string) (*User, *SSHCommand, error) {
+	u, err := GetUserById(userId)
+	if err != nil {
+		if err == ErrUserNotExist {
+			return nil, nil, errSSHAccess(SSH_ACCESS_DENIED_MESSAGE, "User(%d) does not exist", userId)
+		}
+		return nil, nil, fmt.Errorf("Fail to get user(%d): %v", userId, err)
+	} else if !u.IsActive {
+		return nil, nil, errSSHAccess(SSH_ACCOUNT_SUSPENDED_MESSAGE, "User(%s) is not active", u.Name)
+	} else if len(cmd) == 0 {
+		return u, nil, nil
+	}
+
+	sshCmd, err := CheckSSHCommand(u, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, sshCmd, nil
+}
+
+// SSHCommand represents a git command requested over SSH that user is allowed to run.
+type SSHCommand struct {
+	Verb      string
+	RepoPath  string // Path of repository relative to repository root, e.g. "user/repo.git".
+	RepoOwner *User
+	Repo      *Repository
+	Mode      AccessMode // Access mode required by command.
+}
+
+// Operation returns name of operation of command, e.g. "upload-pack".
+func (c *SSHCommand) Operation() string {
+	return strings.TrimPrefix(c.Verb, "git-")
+}
+
+// Env returns environment variables that identify given key, its owner and
+// the repository, in "key=value" form to be appended to environment of git command.
+func (c *SSHCommand) Env(keyId int64, u *User) []string {
+	return []string{
+		ENV_KEY_ID + "=" + com.ToStr(keyId),
+		ENV_USER_ID + "=" + com.ToStr(u.Id),
+		ENV_USER_NAME + "=" + u.Name,
+		ENV_REPO_OWNER + "=" + c.RepoOwner.Name,
+		ENV_REPO_NAME + "=" + c.Repo.Name,
+	}
+}
+
+// ParseSSHCommand splits value of SSH_ORIGINAL_COMMAND into git command and its argument.
+func ParseSSHCommand(cmd string) (string, string) {
+	ss := strings.SplitN(cmd, " ", 2)
+	if len(ss) != 2 {
+		return "", ""
+	}
+	return ss[0], strings.Replace(ss[1], "'/", "'", 1)
+}
+
+// CheckSSHCommand parses given git command and checks that user has enough access
+// to run it on the repository. Errors that can be told to client are ErrSSHAccess.
+func CheckSSHCommand(u *User, cmd string) (*SSHCommand, error) {
+	verb, args := ParseSSHCommand(cmd)
+	repoPath := strings.Trim(args, "'")
+	if verb == "git-upload-archive" && setting.DisableSSHUploadArchive {
+		return nil, errSSHAccess(SSH_ARCHIVE_DISABLED_MESSAGE, "git-upload-archive is disabled: %s", repoPath)
+	}
+	rr := strings.SplitN(repoPath, "/", 2)
+	if len(rr) != 2 {
+		return nil, errSSHAccess("Invalid repository path", "Invalide repository path: %v", args)
+	}
+	repoUserName := rr[0]
+	repoName := strings.TrimSuffix(rr[1], ".git")
+
+	repoUser, err := GetUserByName(repoUserName)
+	if err != nil {
+		if err == ErrUserNotExist {
+			return nil, errSSHAccess("Repository owner does not exist", "Unregistered owner: %s", repoUserName)
+		}
+		return nil, fmt.Errorf("Fail to get repository owner(%s): %v", repoUserName, err)
+	}
+
+	repo, err := GetRepositoryByName(repoUser.Id, repoName)
+	if err != nil {
+		if IsErrRepoNotExist(err) {
+			// Only those who could see the repository learn that it does not exist,
+			// others cannot tell it apart from a private repository.
+			if u.Id == repoUser.Id || repoUser.IsOwnedBy(u.Id) {
+				return nil, errSSHAccess(SSH_REPO_NOT_EXIST_MESSAGE, "Repository does not exist: %s/%s", repoUser.Name, repoName)
+			}
+			return nil, errSSHAccess(SSH_ACCESS_DENIED_MESSAGE, "Repository does not exist: %s/%s", repoUser.Name, repoName)
+		}
+		return nil, fmt.Errorf("Fail to get repository: %v", err)
+	}
+
+	requestedMode, has := SSHCommandModes[verb]
+	if !has {
+		return nil, errSSHAccess("Unknown git command", "Unknown git command %s", verb)
+	}
+
+	mode, err := AccessLevel(u, repo)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to check access: %v", err)
+	} else if mode < requestedMode {
+		clientMessage := SSH_ACCESS_DENIED_MESSAGE
+		if mode >= ACCESS_MODE_READ {
+			clientMessage = SSH_WRITE_DENIED_MESSAGE
+		}
+		return nil, errSSHAccess(clientMessage,
+			"User %s does not have level %v access to repository %s", u.Name, requestedMode, repoPath)
+	}
+
+	return &SSHCommand{
+		Verb:      verb,
+		RepoPath:  repoPath,
+		RepoOwner: repoUser,
+		Repo:      repo,
+		Mode:      requestedMode,
+	}, nil
+}
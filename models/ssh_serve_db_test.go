@@ -0,0 +1,196 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestGetSSHKeyAndOwner(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(PublicKey))
+	defer cleanup()
+	var err error
+
+	oldExpire := setting.UnverifiedSSHKeyExpire
+	setting.UnverifiedSSHKeyExpire = 7
+	defer func() { setting.UnverifiedSSHKeyExpire = oldExpire }()
+
+	active := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true}
+	suspended := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com"}
+	for _, u := range []*User{active, suspended} {
+		if _, err = x.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := map[string]*PublicKey{
+		"usable":    {OwnerId: active.Id, Name: "usable", Verified: true},
+		"disabled":  {OwnerId: active.Id, Name: "disabled", Verified: true, IsDisabled: true},
+		"expired":   {OwnerId: active.Id, Name: "expired"},
+		"suspended": {OwnerId: suspended.Id, Name: "suspended", Verified: true},
+	}
+	for _, key := range keys {
+		key.Fingerprint, key.Content = key.Name, "ssh-ed25519 "+key.Name
+		if _, err = x.Insert(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Created is always set on insert, so expired key is backdated afterwards.
+	if _, err = x.Exec("UPDATE public_key SET created=? WHERE id=?",
+		time.Now().AddDate(0, 0, -8), keys["expired"].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	key, owner, err := GetSSHKeyAndOwner(keys["usable"].Id)
+	if err != nil {
+		t.Fatalf("expect usable key to be accepted: %v", err)
+	} else if key.Id != keys["usable"].Id || owner.Id != active.Id {
+		t.Errorf("expect key %d of user %d but got key %d of user %d", keys["usable"].Id, active.Id, key.Id, owner.Id)
+	}
+
+	cases := []struct {
+		desc    string
+		keyId   int64
+		message string
+	}{
+		{"disabled", keys["disabled"].Id, "Key has been disabled"},
+		{"expired", keys["expired"].Id, "Key has expired, please add a new key in your account settings"},
+		{"suspended owner", keys["suspended"].Id, "Your account has been suspended, please contact the site administrator"},
+		{"deleted row", keys["usable"].Id + 100, "Key does not exist"},
+	}
+	for _, c := range cases {
+		_, _, err := GetSSHKeyAndOwner(c.keyId)
+		if !IsErrSSHAccess(err) {
+			t.Errorf("%s: expect ErrSSHAccess but got %v", c.desc, err)
+		} else if msg := err.(ErrSSHAccess).Message; msg != c.message {
+			t.Errorf("%s: expect message %q but got %q", c.desc, c.message, msg)
+		}
+	}
+}
+
+func TestCheckSSHCommandMessages(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(Repository), new(Access), new(OrgUser))
+	defer cleanup()
+	var err error
+
+	alice := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true}
+	bob := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com", IsActive: true}
+	for _, u := range []*User{alice, bob} {
+		if _, err = x.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, repo := range []*Repository{
+		{OwnerId: alice.Id, Name: "public", LowerName: "public"},
+		{OwnerId: alice.Id, Name: "private", LowerName: "private", IsPrivate: true},
+	} {
+		if _, err = x.Insert(repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Wording is asserted exactly since users grep for it.
+	cases := []struct {
+		desc   string
+		user   *User
+		cmd    string
+		stderr string
+	}{
+		{"missing repository of own", alice, "git-upload-pack 'alice/missing.git'",
+			"Gogs: Repository does not exist\n"},
+		{"missing repository of others", bob, "git-upload-pack 'alice/missing.git'",
+			"Gogs: Repository does not exist or you do not have access\n"},
+		{"private repository without access", bob, "git-upload-pack 'alice/private.git'",
+			"Gogs: Repository does not exist or you do not have access\n"},
+		{"push without write access", bob, "git-receive-pack 'alice/public.git'",
+			"Gogs: You do not have write access to this repository\n"},
+	}
+	for _, c := range cases {
+		_, err := CheckSSHCommand(c.user, c.cmd)
+		if !IsErrSSHAccess(err) {
+			t.Errorf("%s: expect ErrSSHAccess but got %v", c.desc, err)
+		} else if line := SSHErrorLine(err); line != c.stderr {
+			t.Errorf("%s: expect stderr %q but got %q", c.desc, c.stderr, line)
+		}
+	}
+
+	if _, err = CheckSSHCommand(bob, "git-upload-pack 'alice/public.git'"); err != nil {
+		t.Errorf("expect read access to public repository: %v", err)
+	}
+
+	// Archive is a read, and can be disabled for whole instance.
+	sshCmd, err := CheckSSHCommand(bob, "git-upload-archive 'alice/public.git'")
+	if err != nil {
+		t.Fatalf("expect archive of public repository: %v", err)
+	} else if sshCmd.Mode != ACCESS_MODE_READ || sshCmd.Operation() != "upload-archive" {
+		t.Errorf("expect read operation upload-archive but got %v %s", sshCmd.Mode, sshCmd.Operation())
+	}
+	if _, err = CheckSSHCommand(bob, "git-upload-archive 'alice/private.git'"); !IsErrSSHAccess(err) {
+		t.Errorf("expect archive of private repository to be rejected but got %v", err)
+	}
+
+	setting.DisableSSHUploadArchive = true
+	defer func() { setting.DisableSSHUploadArchive = false }()
+	_, err = CheckSSHCommand(alice, "git-upload-archive 'alice/public.git'")
+	if line := SSHErrorLine(err); line != "Gogs: Archive access over SSH has been disabled by site administrator\n" {
+		t.Errorf("expect archive to be disabled but got %q", line)
+	}
+}
+
+func TestCheckSSHUserRequest(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(Repository), new(Access), new(OrgUser))
+	defer cleanup()
+	var err error
+
+	alice := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true}
+	bob := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com"}
+	for _, u := range []*User{alice, bob} {
+		if _, err = x.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = x.Insert(&Repository{OwnerId: alice.Id, Name: "private", LowerName: "private", IsPrivate: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	u, sshCmd, err := CheckSSHUserRequest(alice.Id, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if u.Id != alice.Id || sshCmd != nil {
+		t.Errorf("expect only user %d but got %d with command %v", alice.Id, u.Id, sshCmd)
+	}
+	if _, sshCmd, err = CheckSSHUserRequest(alice.Id, "git-receive-pack 'alice/private.git'"); err != nil {
+		t.Fatal(err)
+	} else if sshCmd.Mode != ACCESS_MODE_WRITE {
+		t.Errorf("expect write command but got %v", sshCmd.Mode)
+	}
+
+	cases := []struct {
+		desc    string
+		userId  int64
+		message string
+	}{
+		{"inactive user", bob.Id, "Your account has been suspended, please contact the site administrator"},
+		{"deleted user", bob.Id + 100, "Repository does not exist or you do not have access"},
+	}
+	for _, c := range cases {
+		_, _, err := CheckSSHUserRequest(c.userId, "git-upload-pack 'alice/private.git'")
+		if !IsErrSSHAccess(err) {
+			t.Errorf("%s: expect ErrSSHAccess but got %v", c.desc, err)
+		} else if msg := err.(ErrSSHAccess).Message; msg != c.message {
+			t.Errorf("%s: expect message %q but got %q", c.desc, c.message, msg)
+		}
+	}
+
+	// Usual permission checks apply afterwards.
+	if _, _, err = CheckSSHUserRequest(alice.Id, "git-upload-pack 'alice/missing.git'"); !IsErrSSHAccess(err) {
+		t.Errorf("expect missing repository to be rejected but got %v", err)
+	}
+}
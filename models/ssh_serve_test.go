@@ -0,0 +1,157 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSSHCommand(t *testing.T) {
+	cases := []struct {
+		cmd, verb, args string
+	}{
+		{"git-upload-pack 'user/repo.git'", "git-upload-pack", "'user/repo.git'"},
+		{"git-receive-pack '/user/repo.git'", "git-receive-pack", "'user/repo.git'"},
+		{"git-upload-archive 'user/repo'", "git-upload-archive", "'user/repo'"},
+		{"git-upload-pack", "", ""},
+		{"", "", ""},
+	}
+	for _, c := range cases {
+		if verb, args := ParseSSHCommand(c.cmd); verb != c.verb || args != c.args {
+			t.Errorf("%q: expect (%q, %q) but got (%q, %q)", c.cmd, c.verb, c.args, verb, args)
+		}
+	}
+}
+
+func TestCheckSSHKey(t *testing.T) {
+	for _, key := range []*PublicKey{{Id: 1, IsDisabled: true}, {Id: 2, IsPending: true}} {
+		err := CheckSSHKey(key)
+		if !IsErrSSHAccess(err) {
+			t.Errorf("key %d: expect ErrSSHAccess but got %v", key.Id, err)
+		} else if len(err.(ErrSSHAccess).Message) == 0 {
+			t.Errorf("key %d: expect message for client", key.Id)
+		}
+	}
+}
+
+func TestCheckSSHKeyCommand(t *testing.T) {
+	full := &PublicKey{Id: 1, Name: "laptop"}
+	readOnly := &PublicKey{Id: 2, Name: "backup", Mode: KEY_ACCESS_MODE_READ}
+	for _, cmd := range []string{"git-upload-pack 'user/repo.git'", "git-upload-archive 'user/repo.git'", "git-receive-pack 'user/repo.git'"} {
+		if err := CheckSSHKeyCommand(full, cmd); err != nil {
+			t.Errorf("%s: expect full key to be allowed but got %v", cmd, err)
+		}
+	}
+	if err := CheckSSHKeyCommand(readOnly, "git-upload-pack 'user/repo.git'"); err != nil {
+		t.Errorf("expect read-only key to fetch but got %v", err)
+	}
+
+	err := CheckSSHKeyCommand(readOnly, "git-receive-pack 'user/repo.git'")
+	if line := SSHErrorLine(err); line != "Gogs: Key 'backup' is read-only and cannot be used to push\n" {
+		t.Errorf("expect read-only key to be rejected but got %q", line)
+	}
+}
+
+func TestParseKeyAccessMode(t *testing.T) {
+	cases := []struct {
+		name string
+		mode KeyAccessMode
+		err  error
+	}{
+		{"", KEY_ACCESS_MODE_FULL, nil},
+		{"full", KEY_ACCESS_MODE_FULL, nil},
+		{"read-only", KEY_ACCESS_MODE_READ, nil},
+		{"write", "", ErrInvalidKeyAccessMode},
+	}
+	for _, c := range cases {
+		if mode, err := ParseKeyAccessMode(c.name); mode != c.mode || err != c.err {
+			t.Errorf("%q: expect (%q, %v) but got (%q, %v)", c.name, c.mode, c.err, mode, err)
+		}
+	}
+}
+
+func TestSSHErrorLine(t *testing.T) {
+	cases := []struct {
+		err    error
+		stderr string
+	}{
+		{CheckSSHKey(&PublicKey{Id: 1, IsDisabled: true}), "Gogs: Key has been disabled\n"},
+		{CheckSSHKey(&PublicKey{Id: 2, IsPending: true}), "Gogs: Key is waiting for approval\n"},
+		{errSSHAccess(SSH_KEY_EXPIRED_MESSAGE, "key(3)"),
+			"Gogs: Key has expired, please add a new key in your account settings\n"},
+		{errSSHAccess(SSH_ACCOUNT_SUSPENDED_MESSAGE, "key(4)"),
+			"Gogs: Your account has been suspended, please contact the site administrator\n"},
+		{fmt.Errorf("database is locked"), "Gogs: Internal error\n"},
+	}
+	for _, c := range cases {
+		if line := SSHErrorLine(c.err); line != c.stderr {
+			t.Errorf("%v: expect stderr %q but got %q", c.err, c.stderr, line)
+		}
+	}
+}
+
+func TestSSHCommandEnvInHooks(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "gogs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	git := func(dir string, env []string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(append(os.Environ(),
+			"GIT_AUTHOR_NAME=Gogs", "GIT_AUTHOR_EMAIL=gogs@fake.local",
+			"GIT_COMMITTER_NAME=Gogs", "GIT_COMMITTER_EMAIL=gogs@fake.local"), env...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v - %s", strings.Join(args, " "), err, out)
+		}
+	}
+
+	// Pre-receive hook of repository records variables it sees.
+	repoPath := filepath.Join(tmpDir, "user1", "repo1.git")
+	envPath := filepath.Join(tmpDir, "env")
+	if err = os.MkdirAll(repoPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	git(repoPath, nil, "init", "--bare")
+	hook := "#!/bin/sh\nenv | grep ^GOGS_ | sort > " + envPath + "\n"
+	if err = ioutil.WriteFile(filepath.Join(repoPath, "hooks", "pre-receive"), []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	workDir := filepath.Join(tmpDir, "work")
+	git(tmpDir, nil, "init", workDir)
+	git(workDir, nil, "commit", "--allow-empty", "-m", "Initial commit")
+
+	sshCmd := &SSHCommand{
+		Verb:      "git-receive-pack",
+		RepoPath:  "user1/repo1.git",
+		RepoOwner: &User{Id: 1, Name: "user1"},
+		Repo:      &Repository{Id: 1, Name: "repo1"},
+		Mode:      ACCESS_MODE_WRITE,
+	}
+	pusher := &User{Id: 2, Name: "user2"}
+	git(workDir, sshCmd.Env(5, pusher), "push", repoPath, "HEAD:refs/heads/master")
+
+	data, err := ioutil.ReadFile(envPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "GOGS_KEY_ID=5\nGOGS_REPO_NAME=repo1\nGOGS_REPO_OWNER=user1\nGOGS_USER_ID=2\nGOGS_USER_NAME=user2\n"
+	if string(data) != expect {
+		t.Errorf("expect hook to see:\n%s\nbut got:\n%s", expect, data)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/sshsig"
+)
+
+// Namespace Git uses when signing commits and tags with SSH keys.
+const SSH_SIGNATURE_NAMESPACE = "git"
+
+// isSSHSigned returns true if given object is signed with an SSH key.
+func isSSHSigned(sig *git.ObjectSignature) bool {
+	return sig != nil && sshsig.IsArmored(sig.Signature)
+}
+
+// isSSHKeyDeleted returns true if key of given authorized string has been deleted
+// by given user according to security log.
+func isSSHKeyDeleted(uid int64, content string) (bool, error) {
+	sha256Fingerprint, md5Fingerprint, err := keyFingerprints(content)
+	if err != nil {
+		return false, err
+	}
+
+	// Fingerprint is recorded in the format printed by ssh-keygen of the server.
+	count, err := x.Where("owner_id=? AND operation=? AND key_fingerprint IN (?,?,?)",
+		uid, SECURITY_OP_KEY_DELETE, sha256Fingerprint, md5Fingerprint, "MD5:"+md5Fingerprint).
+		Count(new(SecurityLog))
+	return count > 0, err
+}
+
+// verifySSHSignature verifies SSH signature of a commit or tag object that claims to be
+// made by given e-mail. Signature is only verified when the signing key is registered
+// by the user who has activated the e-mail, and the key is enabled, approved and verified
+// so nobody can claim signatures of a key that they have not proven to own.
+func verifySSHSignature(sig *git.ObjectSignature, email string) *CommitVerification {
+	v := &CommitVerification{IsSigned: true, Reason: SIGNATURE_BAD}
+	s, err := sshsig.Parse(sig.Signature)
+	if err != nil {
+		return v
+	}
+	v.Fingerprint = s.Fingerprint()
+	if err = s.Verify(SSH_SIGNATURE_NAMESPACE, []byte(sig.Payload)); err != nil {
+		return v
+	}
+
+	v.Reason = SIGNATURE_NO_KEY
+	u, err := GetUserByActivatedEmail(email)
+	if err != nil {
+		if err != ErrUserNotExist {
+			log.Error(4, "GetUserByActivatedEmail(%s): %v", email, err)
+		}
+		return v
+	}
+
+	key := &PublicKey{OwnerId: u.Id, FingerprintSha256: v.Fingerprint}
+	if has, err := x.Get(key); err != nil {
+		log.Error(4, "Get public key(%s): %v", v.Fingerprint, err)
+		return v
+	} else if has {
+		if key.IsDisabled || key.IsPending || !key.Verified {
+			return v
+		}
+		v.IsVerified = true
+		v.Reason = SIGNATURE_GOOD
+		v.SSHKey = key
+		return v
+	}
+
+	if deleted, err := isSSHKeyDeleted(u.Id, s.AuthorizedKey()); err != nil {
+		log.Error(4, "isSSHKeyDeleted(%s): %v", v.Fingerprint, err)
+	} else if deleted {
+		v.Reason = SIGNATURE_KEY_DELETED
+	}
+	return v
+}
+
+// ParseTagVerification returns verification result of signature of given tag,
+// only tags signed with SSH keys are verified against keys registered by the tagger.
+func ParseTagVerification(tag *git.Tag) *CommitVerification {
+	if !isSSHSigned(tag.Signature) || tag.Tagger == nil {
+		return &CommitVerification{IsSigned: tag.Signature != nil}
+	}
+	return verifySSHSignature(tag.Signature, tag.Tagger.Email)
+}
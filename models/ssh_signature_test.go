@@ -0,0 +1,108 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// Signature of "hello gogs\n" made by "ssh-keygen -Y sign -n git".
+const (
+	testSSHSigKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIvJzf2ApZnp4z19WqS3HDpu51JKii+qjfVbQqNvt3/R test@gogs.local"
+	testSSHSig    = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgi8nN/YClmenjPX1apLccOm7nUk
+qKL6qN9VtCo2+3f9EAAAADZ2l0AAAAAAAAAAZzaGE1MTIAAABTAAAAC3NzaC1lZDI1NTE5
+AAAAQMZ2hr9keJJlisgP+jejrrmZ6WQVl5ZhiIoLzdcuYGm/hYQIPgYycOJE76F0rssDjJ
+zF6iDE3Jvy9jqxxZEkVAo=
+-----END SSH SIGNATURE-----
+`
+)
+
+func TestVerifySSHSignature(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(EmailAddress), new(PublicKey), new(SecurityLog))
+	defer cleanup()
+	var err error
+
+	owner := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local", IsActive: true}
+	other := &User{Name: "user2", LowerName: "user2", Email: "user2@fake.local", IsActive: true}
+	if _, err = x.Insert(owner, other); err != nil {
+		t.Fatal(err)
+	}
+	sha256Fingerprint, md5Fingerprint, err := keyFingerprints(testSSHSigKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &PublicKey{
+		OwnerId:           owner.Id,
+		Name:              "signing",
+		Content:           testSSHSigKey,
+		Fingerprint:       md5Fingerprint,
+		FingerprintSha256: sha256Fingerprint,
+		Verified:          true,
+	}
+	if _, err = x.Insert(key); err != nil {
+		t.Fatal(err)
+	}
+
+	sig := &git.ObjectSignature{Signature: testSSHSig, Payload: "hello gogs\n"}
+	expect := func(email, reason string) {
+		v := verifySSHSignature(sig, email)
+		if !v.IsSigned || v.Reason != reason || v.IsVerified != (reason == SIGNATURE_GOOD) {
+			t.Errorf("%s: expect reason %q but got %+v", email, reason, v)
+		}
+	}
+
+	expect("user1@fake.local", SIGNATURE_GOOD)
+	expect("user2@fake.local", SIGNATURE_NO_KEY)
+	expect("nobody@fake.local", SIGNATURE_NO_KEY)
+
+	sig.Payload = "hello gogs!\n"
+	expect("user1@fake.local", SIGNATURE_BAD)
+	sig.Payload = "hello gogs\n"
+
+	// Keys that cannot be used do not verify signatures either.
+	for _, state := range []*PublicKey{
+		{IsDisabled: true, Verified: true},
+		{IsPending: true, Verified: true},
+		{Verified: false},
+	} {
+		if _, err = x.Id(key.Id).Cols("is_disabled", "is_pending", "verified").Update(state); err != nil {
+			t.Fatal(err)
+		}
+		expect("user1@fake.local", SIGNATURE_NO_KEY)
+	}
+	if _, err = x.Id(key.Id).Cols("is_disabled", "is_pending", "verified").Update(&PublicKey{Verified: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Primary e-mail that has not been confirmed does not claim signatures.
+	setting.Service.RegisterEmailConfirm = true
+	if _, err = x.Id(owner.Id).Cols("email_confirm_token").Update(&User{EmailConfirmToken: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("user1@fake.local", SIGNATURE_NO_KEY)
+	if _, err = x.Id(owner.Id).Cols("email_confirm_token").Update(&User{}); err != nil {
+		t.Fatal(err)
+	}
+	expect("user1@fake.local", SIGNATURE_GOOD)
+
+	if _, err = x.Id(key.Id).Delete(new(PublicKey)); err != nil {
+		t.Fatal(err)
+	}
+	expect("user1@fake.local", SIGNATURE_NO_KEY)
+	if _, err = x.Insert(&SecurityLog{
+		OwnerId:        owner.Id,
+		Operation:      SECURITY_OP_KEY_DELETE,
+		KeyFingerprint: md5Fingerprint,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expect("user1@fake.local", SIGNATURE_KEY_DELETED)
+}
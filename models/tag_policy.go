@@ -0,0 +1,129 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gogits/gogs/modules/git"
+)
+
+var (
+	ErrTagPolicyNotExist       = errors.New("Tag policy does not exist")
+	ErrTagPolicyInvalidPattern = errors.New("Tag policy pattern is not a valid glob pattern")
+)
+
+// TagPolicy represents a glob pattern of protected tags of repository,
+// e.g. "v*", and requirements of pushing them.
+type TagPolicy struct {
+	Id            int64
+	RepoId        int64 `xorm:"INDEX"`
+	Pattern       string
+	RequireSigned bool      // Tag must be signed by a registered GPG key.
+	Created       time.Time `xorm:"CREATED"`
+}
+
+// Match returns true if given tag name matches pattern of the policy.
+func (p *TagPolicy) Match(tagName string) bool {
+	matched, err := path.Match(p.Pattern, tagName)
+	return err == nil && matched
+}
+
+// NewTagPolicy creates a new tag policy.
+func NewTagPolicy(p *TagPolicy) error {
+	if _, err := path.Match(p.Pattern, ""); err != nil || len(p.Pattern) == 0 {
+		return ErrTagPolicyInvalidPattern
+	}
+	_, err := x.Insert(p)
+	return err
+}
+
+// GetTagPolicies returns all tag policies of given repository.
+func GetTagPolicies(repoId int64) ([]*TagPolicy, error) {
+	policies := make([]*TagPolicy, 0, 5)
+	return policies, x.Where("repo_id=?", repoId).Asc("id").Find(&policies)
+}
+
+// DeleteTagPolicy deletes tag policy of repository by given ID.
+func DeleteTagPolicy(repoId, id int64) error {
+	_, err := x.Delete(&TagPolicy{Id: id, RepoId: repoId})
+	return err
+}
+
+// GetTagSignatureKey verifies signature of given tag of repository
+// and returns the registered GPG key that made the signature.
+func GetTagSignatureKey(repoId int64, tagName string) (*GPGKey, error) {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		return nil, err
+	}
+	repoPath, err := repo.RepoPath()
+	if err != nil {
+		return nil, err
+	}
+	return verifyTagObject(repoPath, "refs/tags/"+tagName)
+}
+
+// verifyTagObject verifies signature of given tag object
+// and returns the registered GPG key that made the signature.
+func verifyTagObject(repoPath, object string) (*GPGKey, error) {
+	status, err := git.VerifyTag(repoPath, gnupgHome(), object)
+	if !strings.Contains(status, "[GNUPG:]") {
+		return nil, ErrGPGTagNotSigned
+	} else if err != nil {
+		return nil, ErrGPGSignatureNotVerified
+	}
+	return signingGPGKey(status)
+}
+
+// ErrTagSignatureRejected represents a pushed tag that failed signature requirement of a policy.
+type ErrTagSignatureRejected struct {
+	TagName string
+	Policy  *TagPolicy
+	Err     error
+}
+
+func (err ErrTagSignatureRejected) Error() string {
+	return fmt.Sprintf("tag %s rejected: tags matching '%s' must be signed by a registered GPG key: %v",
+		err.TagName, err.Policy.Pattern, err.Err)
+}
+
+func IsErrTagSignatureRejected(err error) bool {
+	_, ok := err.(ErrTagSignatureRejected)
+	return ok
+}
+
+// ValidateTagSignature verifies signature of tag that is created or moved by updating
+// a reference to newObjectId, when tag matches a policy of repository that requires
+// signed tags. It must be called before the reference is updated, i.e. in the update hook,
+// so the tag object is verified by its ID since no reference points to it yet.
+func ValidateTagSignature(repoId int64, repoPath, refName, newObjectId string) error {
+	if !strings.HasPrefix(refName, "refs/tags/") || strings.HasPrefix(newObjectId, "0000000") {
+		return nil
+	}
+	tagName := strings.TrimPrefix(refName, "refs/tags/")
+
+	policies, err := GetTagPolicies(repoId)
+	if err != nil {
+		return fmt.Errorf("GetTagPolicies: %v", err)
+	}
+	for _, p := range policies {
+		if !p.RequireSigned || !p.Match(tagName) {
+			continue
+		}
+		if _, err = verifyTagObject(repoPath, newObjectId); err != nil {
+			if err == ErrGPGTagNotSigned || err == ErrGPGSignatureNotVerified {
+				return ErrTagSignatureRejected{tagName, p, err}
+			}
+			return fmt.Errorf("verifyTagObject: %v", err)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestTagPolicyMatch(t *testing.T) {
+	p := &TagPolicy{Pattern: "v*"}
+	for tagName, expect := range map[string]bool{
+		"v1.0":         true,
+		"v":            true,
+		"release-v1.0": false,
+		"V1.0":         false,
+		"v1/rc":        false,
+	} {
+		if p.Match(tagName) != expect {
+			t.Errorf("expect %q matching %q to be %v", tagName, p.Pattern, expect)
+		}
+	}
+
+	if (&TagPolicy{Pattern: "[v"}).Match("v") {
+		t.Error("expect invalid pattern to match nothing")
+	}
+}
+
+func TestValidateTagSignatureSkipsOtherRefs(t *testing.T) {
+	// Neither needs repository or database.
+	if err := ValidateTagSignature(1, "", "refs/heads/master", "1234567890"); err != nil {
+		t.Errorf("expect branch push to be skipped but got %v", err)
+	}
+	if err := ValidateTagSignature(1, "", "refs/tags/v1.0", "0000000000000000000000000000000000000000"); err != nil {
+		t.Errorf("expect tag deletion to be skipped but got %v", err)
+	}
+}
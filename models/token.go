@@ -6,6 +6,8 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gogits/gogs/modules/base"
@@ -14,24 +16,119 @@ import (
 
 var (
 	ErrAccessTokenNotExist = errors.New("Access token does not exist")
+	ErrAccessTokenNoScope  = errors.New("Access token must have at least one scope")
 )
 
+// Scopes of access tokens, a token can only be used for API routes
+// that require one of its scopes.
+const (
+	ACCESS_TOKEN_SCOPE_READ_USER  = "read:user"
+	ACCESS_TOKEN_SCOPE_WRITE_USER = "write:user"
+	ACCESS_TOKEN_SCOPE_WRITE_KEY  = "write:key"
+	ACCESS_TOKEN_SCOPE_READ_REPO  = "read:repo"
+	ACCESS_TOKEN_SCOPE_WRITE_REPO = "write:repo"
+	ACCESS_TOKEN_SCOPE_READ_ORG   = "read:org"
+	ACCESS_TOKEN_SCOPE_WRITE_ORG  = "write:org"
+	ACCESS_TOKEN_SCOPE_ADMIN      = "admin"
+	// Full access, tokens created before scopes were introduced have it.
+	ACCESS_TOKEN_SCOPE_ALL = "all"
+)
+
+// AccessTokenScopes is the list of scopes that can be selected when creating a token.
+var AccessTokenScopes = []string{
+	ACCESS_TOKEN_SCOPE_READ_USER, ACCESS_TOKEN_SCOPE_WRITE_USER, ACCESS_TOKEN_SCOPE_WRITE_KEY,
+	ACCESS_TOKEN_SCOPE_READ_REPO, ACCESS_TOKEN_SCOPE_WRITE_REPO,
+	ACCESS_TOKEN_SCOPE_READ_ORG, ACCESS_TOKEN_SCOPE_WRITE_ORG,
+	ACCESS_TOKEN_SCOPE_ADMIN, ACCESS_TOKEN_SCOPE_ALL,
+}
+
+type ErrAccessTokenScopeInvalid struct {
+	Scope string
+}
+
+func IsErrAccessTokenScopeInvalid(err error) bool {
+	_, ok := err.(ErrAccessTokenScopeInvalid)
+	return ok
+}
+
+func (err ErrAccessTokenScopeInvalid) Error() string {
+	return fmt.Sprintf("Access token scope is invalid: %s", err.Scope)
+}
+
 // AccessToken represents a personal access token.
 type AccessToken struct {
 	Id                int64
 	Uid               int64
 	Name              string
 	Sha1              string    `xorm:"UNIQUE VARCHAR(40)"`
+	Scopes            string    // Comma-separated list of scopes.
 	Created           time.Time `xorm:"CREATED"`
-	Updated           time.Time
-	HasRecentActivity bool `xorm:"-"`
-	HasUsed           bool `xorm:"-"`
+	Updated           time.Time // Time of last use.
+	HasRecentActivity bool      `xorm:"-"`
+	HasUsed           bool      `xorm:"-"`
+}
+
+// ScopeList returns scopes of access token.
+func (t *AccessToken) ScopeList() []string {
+	if len(t.Scopes) == 0 {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
 }
 
-// NewAccessToken creates new access token.
-func NewAccessToken(t *AccessToken) error {
+// HasScope returns true if access token has given scope,
+// a write scope also grants read scope of same kind.
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope || s == ACCESS_TOKEN_SCOPE_ALL ||
+			(strings.HasPrefix(s, "write:") && "read:"+strings.TrimPrefix(s, "write:") == scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAccessTokenScopes validates given scopes and returns them
+// in order of AccessTokenScopes without duplicates.
+func parseAccessTokenScopes(scopes []string) (string, error) {
+	selected := make(map[string]bool, len(scopes))
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		valid := false
+		for _, scope := range AccessTokenScopes {
+			if s == scope {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", ErrAccessTokenScopeInvalid{s}
+		}
+		selected[s] = true
+	}
+	if len(selected) == 0 {
+		return "", ErrAccessTokenNoScope
+	}
+
+	list := make([]string, 0, len(selected))
+	for _, scope := range AccessTokenScopes {
+		if selected[scope] {
+			list = append(list, scope)
+		}
+	}
+	return strings.Join(list, ","), nil
+}
+
+// NewAccessToken creates new access token with given scopes.
+func NewAccessToken(t *AccessToken, scopes []string) (err error) {
+	if t.Scopes, err = parseAccessTokenScopes(scopes); err != nil {
+		return err
+	}
 	t.Sha1 = base.EncodeSha1(uuid.NewV4().String())
-	_, err := x.Insert(t)
+	_, err = x.Insert(t)
 	return err
 }
 
@@ -47,6 +144,13 @@ func GetAccessTokenBySha(sha string) (*AccessToken, error) {
 	return t, nil
 }
 
+// UpdateAccessTokenUsed records that access token has been used now.
+func UpdateAccessTokenUsed(t *AccessToken) error {
+	t.Updated = time.Now()
+	_, err := x.Id(t.Id).Cols("updated").Update(t)
+	return err
+}
+
 // ListAccessTokens returns a list of access tokens belongs to given user.
 func ListAccessTokens(uid int64) ([]*AccessToken, error) {
 	tokens := make([]*AccessToken, 0, 5)
@@ -0,0 +1,47 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestParseAccessTokenScopes(t *testing.T) {
+	scopes, err := parseAccessTokenScopes([]string{"write:repo", "read:user", " write:repo", ""})
+	if err != nil {
+		t.Fatalf("expect valid scopes but got error: %v", err)
+	} else if scopes != "read:user,write:repo" {
+		t.Errorf("expect scopes to be ordered without duplicates but got %q", scopes)
+	}
+
+	if _, err = parseAccessTokenScopes([]string{"read:user", "delete:everything"}); !IsErrAccessTokenScopeInvalid(err) {
+		t.Errorf("expect ErrAccessTokenScopeInvalid but got %v", err)
+	}
+	if _, err = parseAccessTokenScopes(nil); err != ErrAccessTokenNoScope {
+		t.Errorf("expect ErrAccessTokenNoScope but got %v", err)
+	}
+}
+
+func TestAccessTokenHasScope(t *testing.T) {
+	tests := []struct {
+		scopes string
+		scope  string
+		expect bool
+	}{
+		{"read:user", "read:user", true},
+		{"read:user", "write:key", false},
+		{"write:repo", "read:repo", true},
+		{"read:repo", "write:repo", false},
+		{"write:key", "read:user", false},
+		{"all", "admin", true},
+		{"", "read:user", false},
+	}
+	for _, tc := range tests {
+		token := &AccessToken{Scopes: tc.scopes}
+		if has := token.HasScope(tc.scope); has != tc.expect {
+			t.Errorf("token with scopes %q: HasScope(%q) = %v, expect %v", tc.scopes, tc.scope, has, tc.expect)
+		}
+	}
+}
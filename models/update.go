@@ -49,11 +49,13 @@ func DelUpdateTasksByUuid(uuid string) error {
 	return err
 }
 
-func Update(refName, oldCommitId, newCommitId, userName, repoUserName, repoName string, userId int64) error {
+// Update handles a ref that has been pushed, and returns pushed commits of a branch
+// from newest to oldest, which is empty for tags and deleted refs.
+func Update(refName, oldCommitId, newCommitId, userName, repoUserName, repoName string, userId int64) ([]*git.Commit, error) {
 	isNew := strings.HasPrefix(oldCommitId, "0000000")
 	if isNew &&
 		strings.HasPrefix(newCommitId, "0000000") {
-		return fmt.Errorf("old rev and new rev both 000000")
+		return nil, fmt.Errorf("old rev and new rev both 000000")
 	}
 
 	f := RepoPath(repoUserName, repoName)
@@ -65,23 +67,24 @@ func Update(refName, oldCommitId, newCommitId, userName, repoUserName, repoName
 	isDel := strings.HasPrefix(newCommitId, "0000000")
 	if isDel {
 		log.GitLogger.Info("del rev", refName, "from", userName+"/"+repoName+".git", "by", userId)
-		return nil
+		return nil, nil
 	}
 
 	repo, err := git.OpenRepository(f)
 	if err != nil {
-		return fmt.Errorf("runUpdate.Open repoId: %v", err)
+		return nil, fmt.Errorf("runUpdate.Open repoId: %v", err)
 	}
 
 	ru, err := GetUserByName(repoUserName)
 	if err != nil {
-		return fmt.Errorf("runUpdate.GetUserByName: %v", err)
+		return nil, fmt.Errorf("runUpdate.GetUserByName: %v", err)
 	}
 
 	repos, err := GetRepositoryByName(ru.Id, repoName)
 	if err != nil {
-		return fmt.Errorf("runUpdate.GetRepositoryByName userId: %v", err)
+		return nil, fmt.Errorf("runUpdate.GetRepositoryByName userId: %v", err)
 	}
+	InvalidateRepositoryContributors(repos.Id)
 
 	// Push tags.
 	if strings.HasPrefix(refName, "refs/tags/") {
@@ -108,12 +111,12 @@ func Update(refName, oldCommitId, newCommitId, userName, repoUserName, repoName
 			repos.Id, repoUserName, repoName, refName, commit, oldCommitId, newCommitId); err != nil {
 			log.GitLogger.Fatal(4, "CommitRepoAction: %s/%s:%v", repoUserName, repoName, err)
 		}
-		return err
+		return nil, err
 	}
 
 	newCommit, err := repo.GetCommit(newCommitId)
 	if err != nil {
-		return fmt.Errorf("runUpdate GetCommit of newCommitId: %v", err)
+		return nil, fmt.Errorf("runUpdate GetCommit of newCommitId: %v", err)
 	}
 
 	// Push new branch.
@@ -121,17 +124,17 @@ func Update(refName, oldCommitId, newCommitId, userName, repoUserName, repoName
 	if isNew {
 		l, err = newCommit.CommitsBefore()
 		if err != nil {
-			return fmt.Errorf("Find CommitsBefore erro: %v", err)
+			return nil, fmt.Errorf("Find CommitsBefore erro: %v", err)
 		}
 	} else {
 		l, err = newCommit.CommitsBeforeUntil(oldCommitId)
 		if err != nil {
-			return fmt.Errorf("Find CommitsBeforeUntil erro: %v", err)
+			return nil, fmt.Errorf("Find CommitsBeforeUntil erro: %v", err)
 		}
 	}
 
 	if err != nil {
-		return fmt.Errorf("runUpdate.Commit repoId: %v", err)
+		return nil, fmt.Errorf("runUpdate.Commit repoId: %v", err)
 	}
 
 	// Push commits.
@@ -155,7 +158,23 @@ func Update(refName, oldCommitId, newCommitId, userName, repoUserName, repoName
 
 	if err = CommitRepoAction(userId, ru.Id, userName, actEmail,
 		repos.Id, repoUserName, repoName, refName, &base.PushCommits{l.Len(), commits, ""}, oldCommitId, newCommitId); err != nil {
-		return fmt.Errorf("runUpdate.models.CommitRepoAction: %s/%s:%v", repoUserName, repoName, err)
+		return nil, fmt.Errorf("runUpdate.models.CommitRepoAction: %s/%s:%v", repoUserName, repoName, err)
+	}
+
+	if err = queueCommitsForIndex(repos.Id, l); err != nil {
+		log.GitLogger.Error(4, "queueCommitsForIndex: %s/%s: %v", repoUserName, repoName, err)
+	}
+
+	// Languages are only detected for default branch.
+	if git.RefEndName(refName) == repos.DefaultBranch {
+		if err = queueRepoLanguageDetection(repos.Id); err != nil {
+			log.GitLogger.Error(4, "queueRepoLanguageDetection: %s/%s: %v", repoUserName, repoName, err)
+		}
+	}
+
+	pushed := make([]*git.Commit, 0, l.Len())
+	for e := l.Front(); e != nil; e = e.Next() {
+		pushed = append(pushed, e.Value.(*git.Commit))
 	}
-	return nil
+	return pushed, nil
 }
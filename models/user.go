@@ -12,14 +12,19 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/Unknwon/com"
-	"github.com/nfnt/resize"
+	"golang.org/x/image/draw"
 
 	"github.com/gogits/gogs/modules/avatar"
 	"github.com/gogits/gogs/modules/base"
@@ -28,6 +33,15 @@ import (
 	"github.com/gogits/gogs/modules/setting"
 )
 
+const (
+	AVATAR_SIZE          = 290
+	MAX_AVATAR_FILE_SIZE = 10 << 20
+	// Uploaded images are decoded in full before resizing,
+	// so their dimensions are limited as well as file size.
+	MAX_AVATAR_WIDTH  = 4096
+	MAX_AVATAR_HEIGHT = 4096
+)
+
 type UserType int
 
 const (
@@ -48,6 +62,11 @@ var (
 	ErrLoginSourceNotExist   = errors.New("Login source does not exist")
 	ErrLoginSourceNotActived = errors.New("Login source is not actived")
 	ErrUnsupportedLoginType  = errors.New("Login source is unknown")
+	ErrAvatarNotImage        = errors.New("Uploaded avatar is not a JPEG, PNG or GIF image")
+	ErrAvatarTooLarge        = errors.New("Uploaded avatar exceeds maximum file size")
+	ErrAvatarTooManyPixels   = errors.New("Uploaded avatar exceeds maximum dimensions")
+	ErrBotUserCannotLogin    = errors.New("Bot user cannot log in")
+	ErrUserNotActive         = errors.New("User is not active")
 )
 
 // User represents the object of individual and member of organization.
@@ -72,21 +91,44 @@ type User struct {
 	Created     time.Time `xorm:"CREATED"`
 	Updated     time.Time `xorm:"UPDATED"`
 
+	// Token of e-mail confirmation links, it is cleared once address is confirmed.
+	EmailConfirmToken string `xorm:"VARCHAR(40)"`
+	IsEmailConfirmed  bool
+
+	// Default branch name of new repositories, empty means site default is used.
+	DefaultBranchName string
+
 	// Permissions.
 	IsActive     bool
 	IsAdmin      bool
 	AllowGitHook bool
+	// Maximum number of SSH keys, 0 means global setting is used and -1 means unlimited.
+	MaxSSHKeys int
+
+	// Bot accounts only act through access tokens, they cannot sign in
+	// and do not receive any e-mail.
+	IsBot      bool
+	BotOwnerId int64 `xorm:"INDEX"`
 
 	// Avatar.
 	Avatar          string `xorm:"VARCHAR(2048) NOT NULL"`
 	AvatarEmail     string `xorm:"NOT NULL"`
 	UseCustomAvatar bool
 
+	// Notifications.
+	EnableEmailNotification bool `xorm:"NOT NULL DEFAULT true"`
+
+	// Privacy.
+	HideSSHKeys bool // Do not list public keys to other users via API.
+
 	// Counters.
 	NumFollowers  int
 	NumFollowings int
 	NumStars      int
 	NumRepos      int
+	// Derived from repositories when needed, see CountUserPublicRepos and CountUserPrivateRepos.
+	PublicRepos  int `xorm:"-"`
+	PrivateRepos int `xorm:"-"`
 
 	// For organization.
 	Description string
@@ -99,11 +141,12 @@ type User struct {
 // EmailAdresses is the list of all email addresses of a user. Can contain the
 // primary email address, but is not obligatory
 type EmailAddress struct {
-	Id          int64
-	Uid         int64  `xorm:"INDEX NOT NULL"`
-	Email       string `xorm:"UNIQUE NOT NULL"`
-	IsActivated bool
-	IsPrimary   bool `xorm:"-"`
+	Id           int64
+	Uid          int64  `xorm:"INDEX NOT NULL"`
+	Email        string `xorm:"UNIQUE NOT NULL"`
+	IsActivated  bool
+	ConfirmToken string `xorm:"VARCHAR(40)"` // Token of activation links, separate from the one of primary e-mail.
+	IsPrimary    bool   `xorm:"-"`
 }
 
 // DashboardLink returns the user dashboard page link.
@@ -123,7 +166,7 @@ func (u *User) HomeLink() string {
 func (u *User) AvatarLink() string {
 	switch {
 	case u.UseCustomAvatar:
-		return setting.AppSubUrl + "/avatars/" + com.ToStr(u.Id)
+		return setting.AppSubUrl + "/avatars/" + com.ToStr(u.Id) + ".png"
 	case setting.DisableGravatar, setting.OfflineMode:
 		return setting.AppSubUrl + "/img/avatar_default.jpg"
 	case setting.Service.EnableCacheAvatar:
@@ -132,6 +175,14 @@ func (u *User) AvatarLink() string {
 	return setting.GravatarSource + u.Avatar
 }
 
+// RepoDefaultBranch returns default branch name of new repositories of user.
+func (u *User) RepoDefaultBranch() string {
+	if len(u.DefaultBranchName) > 0 {
+		return u.DefaultBranchName
+	}
+	return setting.DefaultBranch
+}
+
 // NewGitSig generates and returns the signature of given user.
 func (u *User) NewGitSig() *git.Signature {
 	return &git.Signature{
@@ -156,44 +207,76 @@ func (u *User) ValidtePassword(passwd string) bool {
 
 // CustomAvatarPath returns user custom avatar file path.
 func (u *User) CustomAvatarPath() string {
-	return filepath.Join(setting.AvatarUploadPath, com.ToStr(u.Id))
+	return filepath.Join(setting.AvatarUploadPath, com.ToStr(u.Id)+".png")
 }
 
-// UploadAvatar saves custom avatar for user.
-// FIXME: split uploads to different subdirs in case we have massive users.
-func (u *User) UploadAvatar(data []byte) error {
-	u.UseCustomAvatar = true
-
-	img, _, err := image.Decode(bytes.NewReader(data))
+// UploadAvatar decodes, resizes and saves uploaded image as custom avatar of user.
+// Only JPEG, PNG and GIF images that are no larger than MAX_AVATAR_FILE_SIZE
+// and MAX_AVATAR_WIDTH x MAX_AVATAR_HEIGHT are accepted. Type of image is
+// detected from its content, not the one claimed by client.
+func UploadAvatar(uid int64, r io.Reader) error {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MAX_AVATAR_FILE_SIZE+1))
 	if err != nil {
 		return err
+	} else if len(data) > MAX_AVATAR_FILE_SIZE {
+		return ErrAvatarTooLarge
 	}
-	m := resize.Resize(200, 200, img, resize.NearestNeighbor)
 
-	sess := x.NewSession()
-	defer sess.Close()
-	if err = sess.Begin(); err != nil {
-		return err
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return ErrAvatarNotImage
 	}
 
-	if _, err = sess.Id(u.Id).AllCols().Update(u); err != nil {
-		sess.Rollback()
+	// Check dimensions before decoding whole image.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ErrAvatarNotImage
+	} else if cfg.Width > MAX_AVATAR_WIDTH || cfg.Height > MAX_AVATAR_HEIGHT {
+		return ErrAvatarTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ErrAvatarNotImage
+	}
+	m := image.NewRGBA(image.Rect(0, 0, AVATAR_SIZE, AVATAR_SIZE))
+	draw.CatmullRom.Scale(m, m.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	u, err := GetUserById(uid)
+	if err != nil {
 		return err
 	}
 
 	os.MkdirAll(setting.AvatarUploadPath, os.ModePerm)
 	fw, err := os.Create(u.CustomAvatarPath())
 	if err != nil {
-		sess.Rollback()
 		return err
 	}
 	defer fw.Close()
-	if err = jpeg.Encode(fw, m, nil); err != nil {
-		sess.Rollback()
+	if err = png.Encode(fw, m); err != nil {
 		return err
 	}
 
-	return sess.Commit()
+	u.UseCustomAvatar = true
+	_, err = x.Id(u.Id).Cols("use_custom_avatar").Update(u)
+	return err
+}
+
+// DeleteAvatar removes custom avatar of user and falls back to Gravatar.
+func DeleteAvatar(uid int64) error {
+	u, err := GetUserById(uid)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(u.CustomAvatarPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	u.UseCustomAvatar = false
+	_, err = x.Id(u.Id).Cols("use_custom_avatar").Update(u)
+	return err
 }
 
 // IsOrganization returns true if user is actually a organization.
@@ -299,7 +382,12 @@ func CreateUser(u *User) error {
 	u.Avatar = avatar.HashEmail(u.AvatarEmail)
 	u.Rands = GetUserSalt()
 	u.Salt = GetUserSalt()
-	u.EncodePasswd()
+	u.EmailConfirmToken = base.GetRandomString(40)
+	// Password of bot is already unusable and must not be turned into a valid hash.
+	if !u.IsBot {
+		u.EncodePasswd()
+	}
+	u.EnableEmailNotification = !u.IsBot
 
 	sess := x.NewSession()
 	defer sess.Close()
@@ -339,6 +427,13 @@ func GetUsers(num, offset int) ([]*User, error) {
 	return users, err
 }
 
+// GetAdminUsers returns all active site admins.
+func GetAdminUsers() ([]*User, error) {
+	users := make([]*User, 0, 5)
+	err := x.Where("is_admin=? AND is_active=?", true, true).Asc("id").Find(&users)
+	return users, err
+}
+
 // get user by erify code
 func getVerifyUser(code string) (user *User) {
 	if len(code) <= base.TimeLimitCodeLength {
@@ -357,16 +452,14 @@ func getVerifyUser(code string) (user *User) {
 	return nil
 }
 
-// verify active code when active account
-func VerifyUserActiveCode(code string) (user *User) {
+// verify time limit code of given data
+func verifyUserCode(code string, getData func(*User) string) *User {
 	minutes := setting.Service.ActiveCodeLives
 
-	if user = getVerifyUser(code); user != nil {
+	if user := getVerifyUser(code); user != nil {
 		// time limit code
 		prefix := code[:base.TimeLimitCodeLength]
-		data := com.ToStr(user.Id) + user.Email + user.LowerName + user.Passwd + user.Rands
-
-		if base.VerifyTimeLimitCode(data, minutes, prefix) {
+		if data := getData(user); len(data) > 0 && base.VerifyTimeLimitCode(data, minutes, prefix) {
 			return user
 		}
 	}
@@ -374,24 +467,103 @@ func VerifyUserActiveCode(code string) (user *User) {
 }
 
 // verify active code when active account
-func VerifyActiveEmailCode(code, email string) *EmailAddress {
-	minutes := setting.Service.ActiveCodeLives
+func VerifyUserActiveCode(code string) (user *User) {
+	return verifyUserCode(code, func(u *User) string {
+		// No confirmation is pending.
+		if len(u.EmailConfirmToken) == 0 {
+			return ""
+		}
+		return com.ToStr(u.Id) + u.Email + u.LowerName + u.Passwd + u.EmailConfirmToken
+	})
+}
 
-	if user := getVerifyUser(code); user != nil {
-		// time limit code
-		prefix := code[:base.TimeLimitCodeLength]
-		data := com.ToStr(user.Id) + email + user.LowerName + user.Passwd + user.Rands
+// verify code when reset password
+func VerifyUserResetPasswdCode(code string) (user *User) {
+	return verifyUserCode(code, func(u *User) string {
+		return com.ToStr(u.Id) + u.Email + u.LowerName + u.Passwd + u.Rands
+	})
+}
 
-		if base.VerifyTimeLimitCode(data, minutes, prefix) {
-			emailAddress := &EmailAddress{Email: email}
-			if has, _ := x.Get(emailAddress); has {
-				return emailAddress
-			}
+// verify active code when active account
+func VerifyActiveEmailCode(code, email string) *EmailAddress {
+	emailAddress := &EmailAddress{Email: email}
+	if has, _ := x.Get(emailAddress); !has || len(emailAddress.ConfirmToken) == 0 {
+		return nil
+	}
+
+	user := verifyUserCode(code, func(u *User) string {
+		if u.Id != emailAddress.Uid {
+			return ""
 		}
+		return com.ToStr(u.Id) + email + u.LowerName + u.Passwd + emailAddress.ConfirmToken
+	})
+	if user != nil {
+		return emailAddress
 	}
 	return nil
 }
 
+// GenerateEmailConfirmToken makes sure user has a token for e-mail confirmation
+// links, existing token is kept so links sent before stay valid.
+func GenerateEmailConfirmToken(u *User) error {
+	if len(u.EmailConfirmToken) > 0 {
+		return nil
+	}
+	u.EmailConfirmToken = base.GetRandomString(40)
+	_, err := x.Id(u.Id).Cols("email_confirm_token").Update(u)
+	return err
+}
+
+// GenerateEmailAddressConfirmToken makes sure e-mail address has a token for activation links,
+// existing token is kept so links sent before stay valid.
+func GenerateEmailAddressConfirmToken(email *EmailAddress) error {
+	if len(email.ConfirmToken) > 0 {
+		return nil
+	}
+	email.ConfirmToken = base.GetRandomString(40)
+	_, err := x.Id(email.Id).Cols("confirm_token").Update(email)
+	return err
+}
+
+// IsPrimaryEmailActivated returns true if primary e-mail of user can be trusted to be its own.
+// Users activated before confirmation tokens existed have no token and are trusted as before.
+func (u *User) IsPrimaryEmailActivated() bool {
+	return u.IsActive && (u.IsEmailConfirmed || len(u.EmailConfirmToken) == 0 || !setting.Service.RegisterEmailConfirm)
+}
+
+// SetUserEmail changes primary e-mail of user, changes are saved by UpdateUser.
+// Confirmation is reset and a new token is issued unless the new address is an activated
+// e-mail address of the user, it returns true in that case so confirmation can be sent.
+func SetUserEmail(u *User, email string) (bool, error) {
+	if strings.ToLower(u.Email) == strings.ToLower(email) {
+		return false, nil
+	}
+
+	u.Email = email
+	has, err := x.Get(&EmailAddress{Uid: u.Id, Email: strings.ToLower(email), IsActivated: true})
+	if err != nil {
+		return false, err
+	} else if has {
+		u.IsEmailConfirmed = true
+		u.EmailConfirmToken = ""
+		return false, nil
+	}
+
+	u.IsEmailConfirmed = false
+	u.EmailConfirmToken = base.GetRandomString(40)
+	return true, nil
+}
+
+// ConfirmUserEmail activates user and marks its primary e-mail address as confirmed,
+// token is cleared so the same link cannot be used again.
+func ConfirmUserEmail(u *User) error {
+	u.IsActive = true
+	u.IsEmailConfirmed = true
+	u.EmailConfirmToken = ""
+	_, err := x.Id(u.Id).Cols("is_active", "is_email_confirmed", "email_confirm_token").Update(u)
+	return err
+}
+
 // ChangeUserName changes all corresponding setting from old user name to new one.
 func ChangeUserName(u *User, newUserName string) (err error) {
 	if !IsLegalName(newUserName) {
@@ -433,8 +605,9 @@ func UpdateUser(u *User) error {
 }
 
 // FIXME: need some kind of mechanism to record failure. HINT: system notice
-// DeleteUser completely and permanently deletes everything of user.
-func DeleteUser(u *User) error {
+// DeleteUser completely and permanently deletes everything of user,
+// deletion of SSH keys is recorded in security log as done by actor.
+func DeleteUser(u *User, actor SecurityActor) error {
 	// Check ownership of repository.
 	count, err := GetRepositoryCount(u)
 	if err != nil {
@@ -452,50 +625,81 @@ func DeleteUser(u *User) error {
 	}
 
 	// FIXME: check issues, other repos' commits
-	// FIXME: roll backable in some point.
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
 
 	// Delete all followers.
-	if _, err = x.Delete(&Follow{FollowId: u.Id}); err != nil {
+	if _, err = sess.Delete(&Follow{FollowId: u.Id}); err != nil {
 		return err
 	}
 	// Delete oauth2.
-	if _, err = x.Delete(&Oauth2{Uid: u.Id}); err != nil {
+	if _, err = sess.Delete(&Oauth2{Uid: u.Id}); err != nil {
 		return err
 	}
 	// Delete all feeds.
-	if _, err = x.Delete(&Action{UserId: u.Id}); err != nil {
+	if _, err = sess.Delete(&Action{UserId: u.Id}); err != nil {
 		return err
 	}
 	// Delete all watches.
-	if _, err = x.Delete(&Watch{UserId: u.Id}); err != nil {
+	if _, err = sess.Delete(&Watch{UserId: u.Id}); err != nil {
+		return err
+	}
+	// Delete all push subscriptions.
+	if _, err = sess.Delete(&PushSubscription{UserId: u.Id}); err != nil {
 		return err
 	}
 	// Delete all accesses.
-	if _, err = x.Delete(&Access{UserID: u.Id}); err != nil {
+	if _, err = sess.Delete(&Access{UserID: u.Id}); err != nil {
 		return err
 	}
 	// Delete all alternative email addresses
-	if _, err = x.Delete(&EmailAddress{Uid: u.Id}); err != nil {
+	if _, err = sess.Delete(&EmailAddress{Uid: u.Id}); err != nil {
+		return err
+	}
+	// Delete all blocks.
+	if _, err = sess.Delete(&UserBlock{BlockerId: u.Id}); err != nil {
+		return err
+	}
+	if _, err = sess.Delete(&UserBlock{BlockeeId: u.Id}); err != nil {
+		return err
+	}
+	if _, err = sess.Delete(&AccountExport{UserId: u.Id}); err != nil {
 		return err
 	}
-	// Delete all SSH keys.
-	keys := make([]*PublicKey, 0, 10)
-	if err = x.Find(&keys, &PublicKey{OwnerId: u.Id}); err != nil {
+	if _, err = sess.Delete(&KeySource{OwnerId: u.Id}); err != nil {
+		return err
+	}
+	if _, err = sess.Delete(&ImportedPublicKey{OwnerId: u.Id}); err != nil {
+		return err
+	}
+	if _, err = sess.Delete(u); err != nil {
+		return err
+	}
+	// Delete all SSH keys, this has to be the last database operation
+	// because authorized_keys file cannot be rolled back.
+	keys, err := deletePublicKeysByUser(sess, u.Id, u.Name, actor)
+	if err != nil {
+		return fmt.Errorf("deletePublicKeysByUser: %v", err)
+	}
+	if err = sess.Commit(); err != nil {
 		return err
 	}
 	for _, key := range keys {
-		if err = DeletePublicKey(key); err != nil {
-			return err
-		}
+		runKeyChangeHookOfOwner(KEY_EVENT_DELETE, key, u.Name)
 	}
+	runKeyChangeHook(KEY_EVENT_REWRITE, nil)
 
-	// Delete user directory.
-	if err = os.RemoveAll(UserPath(u.Name)); err != nil {
-		return err
+	// Delete generated account exports.
+	if err = os.RemoveAll(accountExportsPath(u.Id)); err != nil {
+		return fmt.Errorf("remove account exports: %v", err)
 	}
 
-	_, err = x.Delete(u)
-	return err
+	// Delete user directory.
+	return os.RemoveAll(UserPath(u.Name))
 }
 
 // DeleteInactivateUsers deletes all inactivate users and email addresses.
@@ -514,7 +718,7 @@ func UserPath(userName string) string {
 
 func GetUserByKeyId(keyId int64) (*User, error) {
 	user := new(User)
-	has, err := x.Sql("SELECT a.* FROM `user` AS a, public_key AS b WHERE a.id = b.owner_id AND b.id=?", keyId).Get(user)
+	has, err := x.Sql("SELECT a.* FROM `user` AS a, public_key AS b WHERE a.id = b.owner_id AND b.id=? AND b.is_disabled=?", keyId, false).Get(user)
 	if err != nil {
 		return nil, err
 	} else if !has {
@@ -559,7 +763,7 @@ func GetUserEmailsByNames(names []string) []string {
 	mails := make([]string, 0, len(names))
 	for _, name := range names {
 		u, err := GetUserByName(name)
-		if err != nil {
+		if err != nil || u.IsBot {
 			continue
 		}
 		mails = append(mails, u.Email)
@@ -629,16 +833,9 @@ func AddEmailAddress(email *EmailAddress) error {
 
 func (email *EmailAddress) Activate() error {
 	email.IsActivated = true
-	if _, err := x.Id(email.Id).AllCols().Update(email); err != nil {
-		return err
-	}
-
-	if user, err := GetUserById(email.Uid); err != nil {
-		return err
-	} else {
-		user.Rands = GetUserSalt()
-		return UpdateUser(user)
-	}
+	email.ConfirmToken = ""
+	_, err := x.Id(email.Id).AllCols().Update(email)
+	return err
 }
 
 func DeleteEmailAddress(email *EmailAddress) error {
@@ -762,6 +959,100 @@ func GetUserByEmail(email string) (*User, error) {
 	return nil, ErrUserNotExist
 }
 
+// getUsersByEmails returns users who own given e-mail addresses in the same way
+// as GetUserByEmail, but with fixed number of queries. Result is keyed by lower-cased
+// e-mail address and does not contain addresses that no one owns.
+func getUsersByEmails(emails []string) (map[string]*User, error) {
+	users := make(map[string]*User, len(emails))
+	args := make([]interface{}, 0, len(emails))
+	for _, email := range emails {
+		email = strings.ToLower(email)
+		if len(email) == 0 {
+			continue
+		}
+		if _, ok := users[email]; !ok {
+			users[email] = nil
+			args = append(args, email)
+		}
+	}
+	if len(args) == 0 {
+		return map[string]*User{}, nil
+	}
+
+	found := make([]*User, 0, len(args))
+	if err := x.In("email", args...).Find(&found); err != nil {
+		return nil, err
+	}
+	for _, u := range found {
+		users[strings.ToLower(u.Email)] = u
+	}
+
+	// Remaining addresses are looked up in activated alternative e-mail addresses.
+	args = args[:0]
+	for email, u := range users {
+		if u == nil {
+			args = append(args, email)
+		}
+	}
+	if len(args) > 0 {
+		emailAddresses := make([]*EmailAddress, 0, len(args))
+		if err := x.Where("is_activated=?", true).In("email", args...).Find(&emailAddresses); err != nil {
+			return nil, err
+		}
+		uids := make([]interface{}, 0, len(emailAddresses))
+		for _, e := range emailAddresses {
+			uids = append(uids, e.Uid)
+		}
+		if len(uids) > 0 {
+			found = found[:0]
+			if err := x.In("id", uids...).Find(&found); err != nil {
+				return nil, err
+			}
+			owners := make(map[int64]*User, len(found))
+			for _, u := range found {
+				owners[u.Id] = u
+			}
+			for _, e := range emailAddresses {
+				users[strings.ToLower(e.Email)] = owners[e.Uid]
+			}
+		}
+	}
+
+	for email, u := range users {
+		if u == nil {
+			delete(users, email)
+		}
+	}
+	return users, nil
+}
+
+// GetUserByActivatedEmail returns user who owns given e-mail address and has activated it,
+// unlike GetUserByEmail unconfirmed primary e-mails do not match. It is used to attribute
+// signatures and imported keys, which must not be claimed by adding someone else's e-mail address.
+func GetUserByActivatedEmail(email string) (*User, error) {
+	if len(email) == 0 {
+		return nil, ErrUserNotExist
+	}
+	email = strings.ToLower(email)
+
+	u := &User{Email: email}
+	has, err := x.Get(u)
+	if err != nil {
+		return nil, err
+	} else if has && u.IsPrimaryEmailActivated() {
+		return u, nil
+	}
+
+	emailAddress := &EmailAddress{Email: email, IsActivated: true}
+	has, err = x.Get(emailAddress)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrUserNotExist
+	}
+	return GetUserById(emailAddress.Uid)
+}
+
 // SearchUserByName returns given number of users whose name contains keyword.
 func SearchUserByName(opt SearchOption) (us []*User, err error) {
 	if len(opt.Keyword) == 0 {
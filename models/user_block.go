@@ -0,0 +1,67 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserBlocked     = errors.New("You have been blocked by the owner of this repository")
+	ErrCannotBlockSelf = errors.New("User cannot block himself/herself")
+)
+
+// UserBlock represents a user who is blocked by another user.
+type UserBlock struct {
+	Id        int64
+	BlockerId int64     `xorm:"UNIQUE(s) NOT NULL"`
+	BlockeeId int64     `xorm:"UNIQUE(s) INDEX NOT NULL"`
+	Created   time.Time `xorm:"CREATED"`
+}
+
+// IsBlocked returns true if blockee is blocked by blocker.
+func IsBlocked(blockerId, blockeeId int64) bool {
+	has, _ := x.Get(&UserBlock{BlockerId: blockerId, BlockeeId: blockeeId})
+	return has
+}
+
+// BlockUser blocks blockee from interacting with repositories of blocker.
+func BlockUser(blockerId, blockeeId int64) error {
+	if blockerId == blockeeId {
+		return ErrCannotBlockSelf
+	} else if IsBlocked(blockerId, blockeeId) {
+		return nil
+	}
+	_, err := x.Insert(&UserBlock{BlockerId: blockerId, BlockeeId: blockeeId})
+	return err
+}
+
+// UnblockUser unblocks blockee.
+func UnblockUser(blockerId, blockeeId int64) error {
+	_, err := x.Delete(&UserBlock{BlockerId: blockerId, BlockeeId: blockeeId})
+	return err
+}
+
+// ListBlockedUsers returns all users who are blocked by given user.
+func ListBlockedUsers(uid int64) ([]*User, error) {
+	blocks := make([]*UserBlock, 0, 10)
+	if err := x.Where("blocker_id=?", uid).Find(&blocks); err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, 0, len(blocks))
+	for _, b := range blocks {
+		u, err := GetUserById(b.BlockeeId)
+		if err != nil {
+			if err == ErrUserNotExist {
+				continue
+			}
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
@@ -0,0 +1,62 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestBlockUser(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(User), new(UserBlock))
+	defer cleanup()
+	var err error
+
+	owner := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local"}
+	spammer := &User{Name: "user2", LowerName: "user2", Email: "user2@fake.local"}
+	if _, err = x.Insert(owner, spammer); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = BlockUser(owner.Id, owner.Id); err != ErrCannotBlockSelf {
+		t.Errorf("expect ErrCannotBlockSelf but got %v", err)
+	}
+
+	// Blocking twice is not an error and keeps a single record.
+	for i := 0; i < 2; i++ {
+		if err = BlockUser(owner.Id, spammer.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := x.Count(new(UserBlock)); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Errorf("expect 1 block but got %d", count)
+	}
+	if !IsBlocked(owner.Id, spammer.Id) {
+		t.Error("expect user to be blocked")
+	} else if IsBlocked(spammer.Id, owner.Id) {
+		t.Error("expect block to be one-way")
+	}
+
+	users, err := ListBlockedUsers(owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(users) != 1 || users[0].Id != spammer.Id {
+		t.Errorf("expect %s to be listed as blocked but got %v", spammer.Name, users)
+	}
+
+	if err = UnblockUser(owner.Id, spammer.Id); err != nil {
+		t.Fatal(err)
+	} else if IsBlocked(owner.Id, spammer.Id) {
+		t.Error("expect user to be unblocked")
+	}
+	if users, err = ListBlockedUsers(owner.Id); err != nil {
+		t.Fatal(err)
+	} else if len(users) != 0 {
+		t.Errorf("expect no blocked user but got %d", len(users))
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"strings"
+
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// CreateBotUser creates a bot account owned by given user or organization.
+// Bot gets an address that never receives mail and a password that never matches,
+// so it can only be used through access tokens.
+func CreateBotUser(owner *User, name string) (*User, error) {
+	u := &User{
+		Name:       name,
+		Email:      strings.ToLower(name) + "@bots.noreply." + setting.Domain,
+		Passwd:     "!" + base.GetRandomString(40),
+		IsActive:   true,
+		IsBot:      true,
+		BotOwnerId: owner.Id,
+	}
+	if err := CreateUser(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetBotUsers returns bot accounts owned by given user or organization
+// and total number of them.
+func GetBotUsers(ownerId int64, page, limit int) ([]*User, int64, error) {
+	total, err := x.Where("is_bot=? AND bot_owner_id=?", true, ownerId).Count(new(User))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bots := make([]*User, 0, limit)
+	return bots, total, x.Where("is_bot=? AND bot_owner_id=?", true, ownerId).
+		Asc("id").Limit(limit, (page-1)*limit).Find(&bots)
+}
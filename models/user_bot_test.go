@@ -0,0 +1,47 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestCreateBotUser(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(EmailAddress))
+	defer cleanup()
+	var err error
+	setting.RepoRootPath = filepath.Join(tmpDir, "repositories")
+
+	org := &User{Name: "org1", LowerName: "org1", Email: "org1@fake.local", Type: ORGANIZATION}
+	if _, err = x.Insert(org); err != nil {
+		t.Fatal(err)
+	}
+
+	bot, err := CreateBotUser(org, "ci-bot")
+	if err != nil {
+		t.Fatalf("CreateBotUser: %v", err)
+	} else if !bot.IsBot || bot.BotOwnerId != org.Id || bot.EnableEmailNotification {
+		t.Errorf("unexpected bot: %+v", bot)
+	}
+
+	if _, err = UserSignIn("ci-bot", bot.Passwd); err != ErrBotUserCannotLogin {
+		t.Errorf("expect ErrBotUserCannotLogin but got %v", err)
+	}
+	if mails := GetUserEmailsByNames([]string{"ci-bot"}); len(mails) != 0 {
+		t.Errorf("expect no e-mail of bot but got %v", mails)
+	}
+
+	bots, total, err := GetBotUsers(org.Id, 1, 10)
+	if err != nil {
+		t.Fatalf("GetBotUsers: %v", err)
+	} else if total != 1 || len(bots) != 1 || bots[0].Id != bot.Id {
+		t.Errorf("expect bot to be listed but got %d: %v", total, bots)
+	}
+}
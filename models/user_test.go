@@ -0,0 +1,326 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestDeleteUserRemovesPublicKeys(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(Repository), new(OrgUser), new(Follow), new(Oauth2),
+		new(Action), new(Watch), new(Access), new(EmailAddress), new(UserBlock), new(PublicKey),
+		new(PushSubscription), new(AccountExport), new(KeySource), new(ImportedPublicKey),
+		new(SecurityLog), new(Webhook), new(HookTask), new(SiteHook))
+	defer cleanup()
+	var err error
+
+	SSHPath = filepath.Join(tmpDir, ".ssh")
+	if err = os.MkdirAll(SSHPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	setting.RepoRootPath = filepath.Join(tmpDir, "repositories")
+
+	owner := &User{Name: "user1", LowerName: "user1", Email: "user1@fake.local"}
+	other := &User{Name: "user2", LowerName: "user2", Email: "user2@fake.local"}
+	if _, err = x.Insert(owner, other); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]*PublicKey, 3)
+	for i := range keys {
+		keys[i] = &PublicKey{
+			OwnerId:     owner.Id,
+			Name:        fmt.Sprintf("key%d", i),
+			Fingerprint: fmt.Sprintf("fingerprint%d", i),
+			Content:     fmt.Sprintf("ssh-rsa AAAAB3NzaC1yc2E%d user1@fake.local", i),
+		}
+		if _, err = x.Insert(keys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	otherKey := &PublicKey{
+		OwnerId:     other.Id,
+		Name:        "key",
+		Fingerprint: "fingerprint",
+		Content:     "ssh-rsa AAAAB3NzaC1yc2E user2@fake.local",
+	}
+	if _, err = x.Insert(otherKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = RewriteAllPublicKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if err = BlockUser(owner.Id, other.Id); err != nil {
+		t.Fatal(err)
+	} else if err = BlockUser(other.Id, owner.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = DeleteUser(owner, SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := x.Count(new(UserBlock)); err != nil {
+		t.Fatal(err)
+	} else if count > 0 {
+		t.Errorf("expect blocks of deleted user to be removed but got %d", count)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(SSHPath, "authorized_keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	for _, key := range keys {
+		if strings.Contains(content, fmt.Sprintf("key-%d ", key.Id)) {
+			t.Errorf("authorized_keys still contains key(%d) of deleted user", key.Id)
+		}
+	}
+	if !strings.Contains(content, fmt.Sprintf("key-%d ", otherKey.Id)) {
+		t.Errorf("authorized_keys does not contain key(%d) of other user", otherKey.Id)
+	}
+
+	count, err := x.Count(&PublicKey{OwnerId: owner.Id})
+	if err != nil {
+		t.Fatal(err)
+	} else if count > 0 {
+		t.Errorf("expect no public key of deleted user but got %d", count)
+	}
+
+	logs := make([]*SecurityLog, 0, 3)
+	if err = x.Where("owner_id=? AND operation=?", owner.Id, SECURITY_OP_KEY_DELETE).Find(&logs); err != nil {
+		t.Fatal(err)
+	} else if len(logs) != len(keys) {
+		t.Fatalf("expect %d key deletions in security log but got %d", len(keys), len(logs))
+	}
+	for _, l := range logs {
+		if l.OwnerName != owner.Name || !l.IsSystem() {
+			t.Errorf("expect deletion by system of key of %s but got %+v", owner.Name, l)
+		}
+	}
+}
+
+func TestUserRepoDefaultBranch(t *testing.T) {
+	oldDefaultBranch := setting.DefaultBranch
+	defer func() { setting.DefaultBranch = oldDefaultBranch }()
+	setting.DefaultBranch = "trunk"
+	if branch := (&User{}).RepoDefaultBranch(); branch != "trunk" {
+		t.Errorf("expect site default branch but got %q", branch)
+	}
+	if branch := (&User{DefaultBranchName: "main"}).RepoDefaultBranch(); branch != "main" {
+		t.Errorf("expect user default branch but got %q", branch)
+	}
+}
+
+func TestConfirmUserEmail(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(EmailAddress))
+	defer cleanup()
+	var err error
+	setting.RepoRootPath = tmpDir
+	setting.Service.ActiveCodeLives = 180
+
+	u := &User{Name: "alice", Email: "alice@example.com", Passwd: "password"}
+	if err = CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	rands := u.Rands
+
+	// Same as code in confirmation link sent by mailer.
+	activeCode := func(u *User) string {
+		data := com.ToStr(u.Id) + u.Email + u.LowerName + u.Passwd + u.EmailConfirmToken
+		return base.CreateTimeLimitCode(data, setting.Service.ActiveCodeLives, nil) + hex.EncodeToString([]byte(u.LowerName))
+	}
+	code := activeCode(u)
+
+	user := VerifyUserActiveCode(code)
+	if user == nil {
+		t.Fatal("expect confirmation code to be valid")
+	} else if err = ConfirmUserEmail(user); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, err = GetUserById(u.Id); err != nil {
+		t.Fatal(err)
+	} else if !u.IsActive || !u.IsEmailConfirmed {
+		t.Errorf("expect user to be active and confirmed but got active=%v confirmed=%v", u.IsActive, u.IsEmailConfirmed)
+	} else if len(u.EmailConfirmToken) > 0 {
+		t.Errorf("expect token to be cleared but got %q", u.EmailConfirmToken)
+	} else if u.Rands != rands {
+		t.Error("expect session salt to be untouched by confirmation")
+	}
+
+	if VerifyUserActiveCode(code) != nil {
+		t.Error("expect used confirmation code to be rejected")
+	}
+	if VerifyUserActiveCode(activeCode(u)) != nil {
+		t.Error("expect code without token to be rejected")
+	}
+
+	// Reset password codes are still based on session salt.
+	if VerifyUserResetPasswdCode(code) != nil {
+		t.Error("expect confirmation code not to reset password")
+	}
+}
+
+func TestSetUserEmail(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User), new(EmailAddress))
+	defer cleanup()
+	var err error
+	setting.RepoRootPath = tmpDir
+	setting.Service.ActiveCodeLives = 180
+
+	u := &User{Name: "alice", Email: "alice@example.com", Passwd: "password"}
+	if err = CreateUser(u); err != nil {
+		t.Fatal(err)
+	} else if err = ConfirmUserEmail(u); err != nil {
+		t.Fatal(err)
+	}
+	work := &EmailAddress{Uid: u.Id, Email: "alice@work.example.com"}
+	other := &EmailAddress{Uid: u.Id, Email: "alice@home.example.com"}
+	for _, e := range []*EmailAddress{work, other} {
+		if err = AddEmailAddress(e); err != nil {
+			t.Fatal(err)
+		} else if err = GenerateEmailAddressConfirmToken(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if work.ConfirmToken == other.ConfirmToken {
+		t.Fatal("expect each e-mail address to have its own token")
+	}
+
+	// Same as code in activation link sent by mailer.
+	emailCode := func(e *EmailAddress) string {
+		data := com.ToStr(u.Id) + e.Email + u.LowerName + u.Passwd + e.ConfirmToken
+		return base.CreateTimeLimitCode(data, setting.Service.ActiveCodeLives, nil) + hex.EncodeToString([]byte(u.LowerName))
+	}
+	workCode, otherCode := emailCode(work), emailCode(other)
+	if VerifyActiveEmailCode(otherCode, work.Email) != nil {
+		t.Error("expect code of one address not to activate another")
+	}
+	if e := VerifyActiveEmailCode(workCode, work.Email); e == nil {
+		t.Fatal("expect activation code to be valid")
+	} else if err = e.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	if VerifyActiveEmailCode(workCode, work.Email) != nil {
+		t.Error("expect used activation code to be rejected")
+	}
+	if VerifyActiveEmailCode(otherCode, other.Email) == nil {
+		t.Error("expect activation of one address not to invalidate links of another")
+	}
+
+	// Activated address does not need to be confirmed again.
+	if needConfirm, err := SetUserEmail(u, work.Email); err != nil {
+		t.Fatal(err)
+	} else if needConfirm || !u.IsEmailConfirmed || !u.IsPrimaryEmailActivated() {
+		t.Errorf("expect activated address to stay confirmed but got confirm=%v confirmed=%v", needConfirm, u.IsEmailConfirmed)
+	}
+
+	setting.Service.RegisterEmailConfirm = true
+	if needConfirm, err := SetUserEmail(u, "someone@example.com"); err != nil {
+		t.Fatal(err)
+	} else if !needConfirm || u.IsEmailConfirmed || len(u.EmailConfirmToken) == 0 {
+		t.Errorf("expect new address to need confirmation but got confirm=%v confirmed=%v token=%q",
+			needConfirm, u.IsEmailConfirmed, u.EmailConfirmToken)
+	} else if u.IsPrimaryEmailActivated() {
+		t.Error("expect unconfirmed primary address not to be trusted")
+	}
+	if err = UpdateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	if u, err = GetUserById(u.Id); err != nil {
+		t.Fatal(err)
+	} else if u.IsEmailConfirmed || len(u.EmailConfirmToken) == 0 {
+		t.Errorf("expect reset confirmation to be saved but got confirmed=%v token=%q", u.IsEmailConfirmed, u.EmailConfirmToken)
+	}
+}
+
+func TestCountUserRepos(t *testing.T) {
+	_, cleanup := newTestEngine(t, new(Repository))
+	defer cleanup()
+	var err error
+
+	for _, repo := range []*Repository{
+		{OwnerId: 1, Name: "public1", LowerName: "public1"},
+		{OwnerId: 1, Name: "public2", LowerName: "public2"},
+		{OwnerId: 1, Name: "private", LowerName: "private", IsPrivate: true},
+		{OwnerId: 2, Name: "other", LowerName: "other"},
+	} {
+		if _, err = x.Insert(repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count, err := CountUserPublicRepos(1); err != nil {
+		t.Fatal(err)
+	} else if count != 2 {
+		t.Errorf("expect 2 public repositories but got %d", count)
+	}
+	if count, err := CountUserPrivateRepos(1); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Errorf("expect 1 private repository but got %d", count)
+	}
+	if count, err := CountUserPrivateRepos(2); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Errorf("expect no private repository but got %d", count)
+	}
+}
+
+func TestUploadAvatar(t *testing.T) {
+	tmpDir, cleanup := newTestEngine(t, new(User))
+	defer cleanup()
+	var err error
+
+	oldAvatarUploadPath := setting.AvatarUploadPath
+	defer func() { setting.AvatarUploadPath = oldAvatarUploadPath }()
+	setting.AvatarUploadPath = filepath.Join(tmpDir, "avatars")
+
+	u := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com"}
+	if _, err = x.Insert(u); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(width, height int) []byte {
+		buf := new(bytes.Buffer)
+		if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	// Type is detected from content, whatever name or type client claims.
+	if err = UploadAvatar(u.Id, strings.NewReader("<html>not an image</html>")); err != ErrAvatarNotImage {
+		t.Errorf("expect ErrAvatarNotImage but got %v", err)
+	}
+	// Image with huge dimensions compresses well but must not be decoded.
+	if err = UploadAvatar(u.Id, bytes.NewReader(encode(MAX_AVATAR_WIDTH+1, 1))); err != ErrAvatarTooManyPixels {
+		t.Errorf("expect ErrAvatarTooManyPixels but got %v", err)
+	}
+
+	if err = UploadAvatar(u.Id, bytes.NewReader(encode(100, 100))); err != nil {
+		t.Fatal(err)
+	} else if u, err = GetUserById(u.Id); err != nil {
+		t.Fatal(err)
+	} else if !u.UseCustomAvatar || !com.IsFile(u.CustomAvatarPath()) {
+		t.Error("expect custom avatar to be saved")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/setting"
 	"github.com/gogits/gogs/modules/uuid"
+	"github.com/gogits/gogs/modules/webhook"
 )
 
 var (
@@ -57,6 +59,10 @@ func IsValidHookContentType(name string) bool {
 // HookEvent represents events that will delivery hook.
 type HookEvent struct {
 	PushOnly bool `json:"push_only"`
+	Fork     bool `json:"fork"`
+	Star     bool `json:"star"`
+	Watch    bool `json:"watch"`
+	Key      bool `json:"key"` // Only for system webhooks.
 }
 
 // Webhook represents a web hook object.
@@ -108,6 +114,23 @@ func (w *Webhook) HasPushEvent() bool {
 	return false
 }
 
+// HasEvent returns true if hook enabled given event.
+func (w *Webhook) HasEvent(event HookEventType) bool {
+	switch event {
+	case PUSH:
+		return w.HasPushEvent()
+	case FORK:
+		return w.Fork
+	case STAR:
+		return w.Star
+	case WATCH:
+		return w.Watch
+	case KEY_ADD, KEY_REMOVE:
+		return w.Key
+	}
+	return false
+}
+
 // CreateWebhook creates a new web hook.
 func CreateWebhook(w *Webhook) error {
 	_, err := x.Insert(w)
@@ -162,6 +185,28 @@ func GetActiveWebhooksByOrgId(orgId int64) (ws []*Webhook, err error) {
 	return ws, err
 }
 
+// GetSystemWebhooks returns all system webhooks,
+// which belong to neither a repository nor an organization.
+func GetSystemWebhooks() (ws []*Webhook, err error) {
+	err = x.Where("repo_id=0 AND org_id=0").Find(&ws)
+	return ws, err
+}
+
+// GetActiveSystemWebhooks returns all active system webhooks.
+func GetActiveSystemWebhooks() ([]*Webhook, error) {
+	return getActiveSystemWebhooks(x)
+}
+
+func getActiveSystemWebhooks(e Engine) (ws []*Webhook, err error) {
+	err = e.Where("repo_id=0 AND org_id=0").And("is_active=?", true).Find(&ws)
+	return ws, err
+}
+
+// IsSystem returns true if webhook is a system webhook.
+func (w *Webhook) IsSystem() bool {
+	return w.RepoId == 0 && w.OrgId == 0
+}
+
 //   ___ ___                __   ___________              __
 //  /   |   \  ____   ____ |  | _\__    ___/____    _____|  | __
 // /    ~    \/  _ \ /  _ \|  |/ / |    |  \__  \  /  ___/  |/ /
@@ -205,7 +250,14 @@ func IsValidHookTaskType(name string) bool {
 type HookEventType string
 
 const (
-	PUSH HookEventType = "push"
+	PUSH  HookEventType = "push"
+	FORK  HookEventType = "fork"
+	STAR  HookEventType = "star"
+	WATCH HookEventType = "watch"
+
+	// Events of system webhooks.
+	KEY_ADD    HookEventType = "key_add"
+	KEY_REMOVE HookEventType = "key_remove"
 )
 
 // FIXME: just use go-gogs-client structs maybe?
@@ -257,6 +309,235 @@ func (p Payload) GetJSONPayload() ([]byte, error) {
 	return data, nil
 }
 
+// SecretPayload represents a payload that carries secret of webhook it is delivered to.
+type SecretPayload interface {
+	BasePayload
+	SetSecret(secret string)
+}
+
+// ForkPayload represents payload of fork event,
+// Repo is the repository that has been forked and Forkee is the new fork.
+type ForkPayload struct {
+	Secret string         `json:"secret"`
+	Forkee *PayloadRepo   `json:"forkee"`
+	Repo   *PayloadRepo   `json:"repository"`
+	Sender *PayloadAuthor `json:"sender"`
+}
+
+func (p ForkPayload) GetJSONPayload() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *ForkPayload) SetSecret(secret string) {
+	p.Secret = secret
+}
+
+// StarPayload represents payload of star event,
+// Action is "created" when repository is starred and "deleted" when unstarred.
+type StarPayload struct {
+	Secret string         `json:"secret"`
+	Action string         `json:"action"`
+	Repo   *PayloadRepo   `json:"repository"`
+	Sender *PayloadAuthor `json:"sender"`
+}
+
+func (p StarPayload) GetJSONPayload() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *StarPayload) SetSecret(secret string) {
+	p.Secret = secret
+}
+
+// WatchPayload represents payload of watch event,
+// Action is "created" when repository is watched and "deleted" when unwatched.
+type WatchPayload struct {
+	Secret string         `json:"secret"`
+	Action string         `json:"action"`
+	Repo   *PayloadRepo   `json:"repository"`
+	Sender *PayloadAuthor `json:"sender"`
+}
+
+func (p WatchPayload) GetJSONPayload() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *WatchPayload) SetSecret(secret string) {
+	p.Secret = secret
+}
+
+// PayloadUser represents user in payloads of system webhooks,
+// which are kept to identifiers only.
+type PayloadUser struct {
+	Id       int64  `json:"id"`
+	UserName string `json:"username"`
+}
+
+// PayloadKey represents public information of an SSH key.
+type PayloadKey struct {
+	Id          int64  `json:"id"`
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	Size        int    `json:"size"`
+	Content     string `json:"content"` // Without comment of key.
+}
+
+// PayloadActor represents who performed the operation that triggered event,
+// Type is one of "self", "admin", "token" and "system".
+type PayloadActor struct {
+	Type string `json:"type"`
+	Id   int64  `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// KeyPayload represents payload of key_add and key_remove events,
+// Action is the operation on key, e.g. "add", "delete", "disable" or "expire".
+type KeyPayload struct {
+	Secret string        `json:"secret"`
+	Action string        `json:"action"`
+	Key    *PayloadKey   `json:"key"`
+	User   *PayloadUser  `json:"user"`
+	Actor  *PayloadActor `json:"actor"`
+}
+
+func (p KeyPayload) GetJSONPayload() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *KeyPayload) SetSecret(secret string) {
+	p.Secret = secret
+}
+
+func payloadAction(created bool) string {
+	if created {
+		return "created"
+	}
+	return "deleted"
+}
+
+func payloadAuthor(u *User) *PayloadAuthor {
+	return &PayloadAuthor{
+		Name:     u.GetFullNameFallback(),
+		Email:    u.Email,
+		UserName: u.Name,
+	}
+}
+
+// composePayloadRepo returns payload information of repository,
+// owner of repository must be loaded.
+func (repo *Repository) composePayloadRepo() *PayloadRepo {
+	return &PayloadRepo{
+		Id:          repo.Id,
+		Name:        repo.LowerName,
+		Url:         fmt.Sprintf("%s%s/%s", setting.AppUrl, repo.Owner.Name, repo.Name),
+		Description: repo.Description,
+		Website:     repo.Website,
+		Watchers:    repo.NumWatches,
+		Owner:       payloadAuthor(repo.Owner),
+		Private:     repo.IsPrivate,
+	}
+}
+
+// PrepareWebhooks creates hook tasks of given event for all active webhooks
+// of repository and its owner organization that subscribed to the event.
+// Slack hooks only support push event and are skipped.
+func PrepareWebhooks(repo *Repository, event HookEventType, p SecretPayload) error {
+	if err := repo.GetOwner(); err != nil {
+		return fmt.Errorf("GetOwner: %v", err)
+	}
+
+	ws, err := GetActiveWebhooksByRepoId(repo.Id)
+	if err != nil {
+		return fmt.Errorf("GetActiveWebhooksByRepoId: %v", err)
+	}
+	if repo.Owner.IsOrganization() {
+		orgws, err := GetActiveWebhooksByOrgId(repo.OwnerId)
+		if err != nil {
+			return fmt.Errorf("GetActiveWebhooksByOrgId: %v", err)
+		}
+		ws = append(ws, orgws...)
+	}
+
+	for _, w := range ws {
+		w.GetEvent()
+		if !w.HasEvent(event) || w.HookTaskType == SLACK {
+			continue
+		}
+
+		p.SetSecret(w.Secret)
+		if err = CreateHookTask(&HookTask{
+			Type:        w.HookTaskType,
+			Url:         w.Url,
+			BasePayload: p,
+			ContentType: w.ContentType,
+			EventType:   event,
+			IsSsl:       w.IsSsl,
+			Secret:      w.Secret,
+		}); err != nil {
+			return fmt.Errorf("CreateHookTask: %v", err)
+		}
+	}
+	return nil
+}
+
+// PrepareSystemWebhooks creates hook tasks of given event
+// for all active system webhooks that subscribed to the event.
+func PrepareSystemWebhooks(event HookEventType, p SecretPayload) error {
+	return prepareSystemWebhooks(x, event, p)
+}
+
+func prepareSystemWebhooks(e Engine, event HookEventType, p SecretPayload) error {
+	ws, err := getActiveSystemWebhooks(e)
+	if err != nil {
+		return fmt.Errorf("getActiveSystemWebhooks: %v", err)
+	}
+
+	for _, w := range ws {
+		w.GetEvent()
+		if !w.HasEvent(event) || w.HookTaskType == SLACK {
+			continue
+		}
+
+		p.SetSecret(w.Secret)
+		if err = createHookTask(e, &HookTask{
+			Type:        w.HookTaskType,
+			Url:         w.Url,
+			BasePayload: p,
+			ContentType: w.ContentType,
+			EventType:   event,
+			IsSsl:       w.IsSsl,
+			Secret:      w.Secret,
+		}); err != nil {
+			return fmt.Errorf("createHookTask: %v", err)
+		}
+	}
+	return nil
+}
+
+// prepareRepoWebhooks is same as PrepareWebhooks for events triggered by
+// given user on given repository, failures are only logged because
+// they must not fail the operation that triggered the event.
+func prepareRepoWebhooks(uid, repoId int64, event HookEventType, compose func(repo *PayloadRepo, sender *PayloadAuthor) SecretPayload) {
+	repo, err := GetRepositoryById(repoId)
+	if err != nil {
+		log.Error(4, "GetRepositoryById(%d): %v", repoId, err)
+		return
+	} else if err = repo.GetOwner(); err != nil {
+		log.Error(4, "GetOwner(%d): %v", repoId, err)
+		return
+	}
+	u, err := GetUserById(uid)
+	if err != nil {
+		log.Error(4, "GetUserById(%d): %v", uid, err)
+		return
+	}
+
+	if err = PrepareWebhooks(repo, event, compose(repo.composePayloadRepo(), payloadAuthor(u))); err != nil {
+		log.Error(4, "PrepareWebhooks(%s, %d): %v", event, repoId, err)
+	}
+}
+
 // HookTask represents a hook task.
 type HookTask struct {
 	Id             int64
@@ -268,20 +549,30 @@ type HookTask struct {
 	ContentType    HookContentType
 	EventType      HookEventType
 	IsSsl          bool
-	IsDelivered    bool
+	Secret         string `xorm:"-"` // Signs payload when task is created, never stored.
+	Signature      string // HMAC-SHA256 of payload keyed by secret of webhook.
+	IsDelivered    bool   // Delivered successfully or out of attempts.
 	IsSucceed      bool
+	Attempts       int
 }
 
 // CreateHookTask creates a new hook task,
 // it handles conversion from Payload to PayloadContent.
 func CreateHookTask(t *HookTask) error {
+	return createHookTask(x, t)
+}
+
+func createHookTask(e Engine, t *HookTask) error {
 	data, err := t.BasePayload.GetJSONPayload()
 	if err != nil {
 		return err
 	}
 	t.Uuid = uuid.NewV4().String()
 	t.PayloadContent = string(data)
-	_, err = x.Insert(t)
+	if len(t.Secret) > 0 {
+		t.Signature = webhook.Sign([]byte(t.Secret), data)
+	}
+	_, err = e.Insert(t)
 	return err
 }
 
@@ -316,6 +607,9 @@ func DeliverHooks() {
 				Header("X-Gogs-Delivery", t.Uuid).
 				Header("X-Gogs-Event", string(t.EventType)).
 				SetTLSClientConfig(&tls.Config{InsecureSkipVerify: setting.Webhook.SkipTLSVerify})
+			if len(t.Signature) > 0 {
+				req.Header(webhook.SIGNATURE_HEADER, webhook.SIGNATURE_PREFIX+t.Signature)
+			}
 
 			switch t.ContentType {
 			case JSON:
@@ -324,7 +618,8 @@ func DeliverHooks() {
 				req.Param("payload", t.PayloadContent)
 			}
 
-			t.IsDelivered = true
+			// Failed tasks are retried by next runs until out of attempts.
+			t.Attempts++
 
 			// FIXME: record response.
 			switch t.Type {
@@ -356,6 +651,7 @@ func DeliverHooks() {
 				}
 			}
 
+			t.IsDelivered = t.IsSucceed || t.Attempts >= setting.Webhook.MaxAttempts
 			tasks = append(tasks, t)
 
 			if t.IsSucceed {
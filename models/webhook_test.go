@@ -0,0 +1,46 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWebhookHasEvent(t *testing.T) {
+	w := &Webhook{HookEvent: &HookEvent{Star: true}}
+	for event, has := range map[HookEventType]bool{
+		PUSH:       false,
+		FORK:       false,
+		STAR:       true,
+		WATCH:      false,
+		KEY_ADD:    false,
+		KEY_REMOVE: false,
+	} {
+		if w.HasEvent(event) != has {
+			t.Errorf("%s: expect %v but got %v", event, has, !has)
+		}
+	}
+}
+
+func TestStarPayloadSecret(t *testing.T) {
+	var p SecretPayload = &StarPayload{Action: payloadAction(false)}
+	p.SetSecret("secret")
+
+	data, err := p.GetJSONPayload()
+	if err != nil {
+		t.Fatalf("GetJSONPayload: %v", err)
+	}
+	fields := make(map[string]interface{})
+	if err = json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if fields["secret"] != "secret" {
+		t.Errorf("expect secret %q but got %v", "secret", fields["secret"])
+	}
+	if fields["action"] != "deleted" {
+		t.Errorf("expect action %q but got %v", "deleted", fields["action"])
+	}
+}
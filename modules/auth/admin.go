@@ -5,6 +5,8 @@
 package auth
 
 import (
+	"mime/multipart"
+
 	"github.com/Unknwon/macaron"
 
 	"github.com/macaron-contrib/binding"
@@ -19,9 +21,18 @@ type AdminEditUserForm struct {
 	Active       bool
 	Admin        bool
 	AllowGitHook bool
+	MaxSSHKeys   int `form:"max_ssh_keys"`
 	LoginType    int
 }
 
 func (f *AdminEditUserForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
 	return validate(errs, ctx.Data, f, ctx.Locale)
 }
+
+type ImportKeysForm struct {
+	File *multipart.FileHeader `form:"file"`
+}
+
+func (f *ImportKeysForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
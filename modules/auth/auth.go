@@ -21,10 +21,11 @@ import (
 	"github.com/gogits/gogs/modules/uuid"
 )
 
-// SignedInId returns the id of signed in user.
-func SignedInId(req *http.Request, sess session.Store) int64 {
+// SignedInId returns the id of signed in user,
+// along with access token when API request is authenticated by token.
+func SignedInId(req *http.Request, sess session.Store) (int64, *models.AccessToken) {
 	if !models.HasEngine {
-		return 0
+		return 0, nil
 	}
 
 	// API calls need to check access token.
@@ -38,37 +39,40 @@ func SignedInId(req *http.Request, sess session.Store) int64 {
 					if err != models.ErrAccessTokenNotExist {
 						log.Error(4, "GetAccessTokenBySha: %v", err)
 					}
-					return 0
+					return 0, nil
 				}
-				return t.Uid
+				if err = models.UpdateAccessTokenUsed(t); err != nil {
+					log.Error(4, "UpdateAccessTokenUsed: %v", err)
+				}
+				return t.Uid, t
 			}
 		}
 	}
 
 	uid := sess.Get("uid")
 	if uid == nil {
-		return 0
+		return 0, nil
 	}
 	if id, ok := uid.(int64); ok {
 		if _, err := models.GetUserById(id); err != nil {
 			if err != models.ErrUserNotExist {
 				log.Error(4, "GetUserById: %v", err)
 			}
-			return 0
+			return 0, nil
 		}
-		return id
+		return id, nil
 	}
-	return 0
+	return 0, nil
 }
 
-// SignedInUser returns the user object of signed user.
+// SignedInUser returns the user object of signed user and access token used by request if any.
 // It returns a bool value to indicate whether user uses basic auth or not.
-func SignedInUser(req *http.Request, sess session.Store) (*models.User, bool) {
+func SignedInUser(req *http.Request, sess session.Store) (*models.User, *models.AccessToken, bool) {
 	if !models.HasEngine {
-		return nil, false
+		return nil, nil, false
 	}
 
-	uid := SignedInId(req, sess)
+	uid, token := SignedInId(req, sess)
 
 	if uid <= 0 {
 		if setting.Service.EnableReverseProxyAuth {
@@ -78,7 +82,7 @@ func SignedInUser(req *http.Request, sess session.Store) (*models.User, bool) {
 				if err != nil {
 					if err != models.ErrUserNotExist {
 						log.Error(4, "GetUserByName: %v", err)
-						return nil, false
+						return nil, nil, false
 					}
 
 					// Check if enabled auto-registration.
@@ -92,13 +96,15 @@ func SignedInUser(req *http.Request, sess session.Store) (*models.User, bool) {
 						if err = models.CreateUser(u); err != nil {
 							// FIXME: should I create a system notice?
 							log.Error(4, "CreateUser: %v", err)
-							return nil, false
+							return nil, nil, false
 						} else {
-							return u, false
+							return u, nil, false
 						}
 					}
+				} else if u.IsBot {
+					return nil, nil, false
 				}
-				return u, false
+				return u, nil, false
 			}
 		}
 
@@ -111,24 +117,24 @@ func SignedInUser(req *http.Request, sess session.Store) (*models.User, bool) {
 
 				u, err := models.UserSignIn(uname, passwd)
 				if err != nil {
-					if err != models.ErrUserNotExist {
+					if err != models.ErrUserNotExist && err != models.ErrBotUserCannotLogin {
 						log.Error(4, "UserSignIn: %v", err)
 					}
-					return nil, false
+					return nil, nil, false
 				}
 
-				return u, true
+				return u, nil, true
 			}
 		}
-		return nil, false
+		return nil, nil, false
 	}
 
 	u, err := models.GetUserById(uid)
 	if err != nil {
 		log.Error(4, "GetUserById: %v", err)
-		return nil, false
+		return nil, nil, false
 	}
-	return u, false
+	return u, token, false
 }
 
 type Form interface {
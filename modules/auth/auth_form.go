@@ -30,6 +30,14 @@ type AuthenticationForm struct {
 	SmtpPort          int    `form:"smtpport"`
 	Tls               bool   `form:"tls"`
 	AllowAutoRegister bool   `form:"allowautoregister"`
+	MetadataURL       string `form:"metadata_url"`
+	EntityId          string `form:"entity_id"`
+	CertificatePEM    string `form:"certificate_pem"`
+	PrivateKeyPEM     string `form:"private_key_pem"`
+	AttributeFullName string `form:"attribute_full_name"`
+	AttributeSSHKey   string `form:"attribute_ssh_key"`
+	BindDN            string `form:"bind_dn"`
+	BindPassword      string `form:"bind_password"`
 }
 
 func (f *AuthenticationForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
@@ -27,6 +27,11 @@ type Ldapsource struct {
 	Filter            string // Query filter to validate entry
 	MsAdSAFormat      string // in the case of MS AD Simple Authen, the format to use (see: http://msdn.microsoft.com/en-us/library/cc223499.aspx)
 	Enabled           bool   // if this source is disabled
+
+	// SSH public keys are synchronized when attribute is set.
+	AttributeSSHPublicKey string // SSH public key attribute, e.g. sshPublicKey
+	BindDN                string // DN to search keys without password of user, anonymous when empty
+	BindPassword          string
 }
 
 //Global LDAP directory pool
@@ -36,7 +41,7 @@ var (
 
 // Add a new source (LDAP directory) to the global pool
 func AddSource(name string, host string, port int, usessl bool, basedn string, attribcn string, attribname string, attribsn string, attribmail string, filter string, msadsaformat string) {
-	ldaphost := Ldapsource{name, host, port, usessl, basedn, attribcn, attribname, attribsn, attribmail, filter, msadsaformat, true}
+	ldaphost := Ldapsource{name, host, port, usessl, basedn, attribcn, attribname, attribsn, attribmail, filter, msadsaformat, true, "", "", ""}
 	Authensource = append(Authensource, ldaphost)
 }
 
@@ -93,6 +98,46 @@ func (ls Ldapsource) SearchEntry(name, passwd string) (string, string, string, s
 	return "", "", "", "", true
 }
 
+// SearchPublicKeys returns values of SSH public key attribute of given user.
+// It binds as user when password is given, otherwise with BindDN or anonymously,
+// so keys can be synchronized without user logging in. An error is returned when
+// user cannot be found, so callers never mistake a failed search for a user without keys.
+func (ls Ldapsource) SearchPublicKeys(name, passwd string) ([]string, error) {
+	if len(ls.AttributeSSHPublicKey) == 0 {
+		return nil, nil
+	}
+
+	l, err := ldapDial(ls)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %v", err)
+	}
+	defer l.Close()
+
+	switch {
+	case len(passwd) > 0:
+		err = l.Bind(fmt.Sprintf(ls.MsAdSAFormat, name), passwd)
+	case len(ls.BindDN) > 0:
+		err = l.Bind(ls.BindDN, ls.BindPassword)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bind: %v", err)
+	}
+
+	search := ldap.NewSearchRequest(
+		ls.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(ls.Filter, name),
+		[]string{ls.AttributeSSHPublicKey},
+		nil)
+	sr, err := l.Search(search)
+	if err != nil {
+		return nil, fmt.Errorf("search: %v", err)
+	} else if len(sr.Entries) == 0 {
+		return nil, fmt.Errorf("user %s not found", name)
+	}
+	return sr.Entries[0].GetAttributeValues(ls.AttributeSSHPublicKey), nil
+}
+
 func ldapDial(ls Ldapsource) (*ldap.Conn, error) {
 	if ls.UseSSL {
 		return ldap.DialTLS("tcp", fmt.Sprintf("%s:%d", ls.Host, ls.Port), nil)
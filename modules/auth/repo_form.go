@@ -46,18 +46,39 @@ func (f *MigrateRepoForm) Validate(ctx *macaron.Context, errs binding.Errors) bi
 }
 
 type RepoSettingForm struct {
-	RepoName    string `form:"repo_name" binding:"Required;AlphaDashDot;MaxSize(100)"`
-	Description string `form:"desc" binding:"MaxSize(255)"`
-	Website     string `form:"site" binding:"Url;MaxSize(100)"`
-	Branch      string `form:"branch"`
-	Interval    int    `form:"interval"`
-	Private     bool   `form:"private"`
+	RepoName     string `form:"repo_name" binding:"Required;AlphaDashDot;MaxSize(100)"`
+	Description  string `form:"desc" binding:"MaxSize(255)"`
+	Website      string `form:"site" binding:"Url;MaxSize(100)"`
+	Branch       string `form:"branch"`
+	Interval     int    `form:"interval"`
+	Private      bool   `form:"private"`
+	Template     bool   `form:"template"`
+	EnableIssues bool   `form:"enable_issues"`
 }
 
 func (f *RepoSettingForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
 	return validate(errs, ctx.Data, f, ctx.Locale)
 }
 
+type CommitMessageRuleForm struct {
+	Pattern      string `form:"pattern" binding:"Required;MaxSize(255)"`
+	ErrorMessage string `form:"error_message" binding:"Required;MaxSize(255)"`
+	IsRequired   bool   `form:"is_required"`
+}
+
+func (f *CommitMessageRuleForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
+type TagPolicyForm struct {
+	Pattern       string `form:"pattern" binding:"Required;MaxSize(255)"`
+	RequireSigned bool   `form:"require_signed"`
+}
+
+func (f *TagPolicyForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
 //  __      __      ___.   .__    .__            __
 // /  \    /  \ ____\_ |__ |  |__ |  |__   ____ |  | __
 // \   \/\/   // __ \| __ \|  |  \|  |  \ /  _ \|  |/ /
@@ -71,6 +92,10 @@ type NewWebhookForm struct {
 	ContentType  string `form:"content_type" binding:"Required"`
 	Secret       string `form:"secret"`
 	PushOnly     bool   `form:"push_only"`
+	Fork         bool   `form:"fork"`
+	Star         bool   `form:"star"`
+	Watch        bool   `form:"watch"`
+	Key          bool   `form:"key"`
 	Active       bool   `form:"active"`
 }
 
@@ -0,0 +1,237 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package saml provides a SAML 2.0 service provider for authentication sources.
+package saml
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// SAMLSource represents a SAML 2.0 identity provider.
+type SAMLSource struct {
+	MetadataURL       string // Metadata URL of identity provider
+	EntityId          string // Entity ID of identity provider, optional
+	CertificatePEM    string // PEM encoded certificate of service provider
+	PrivateKeyPEM     string // PEM encoded RSA private key of service provider
+	AttributeUsername string // Username attribute, falls back to NameID
+	AttributeFullName string // Full name attribute
+	AttributeMail     string // E-mail attribute
+}
+
+// Identity represents the user information asserted by an identity provider.
+type Identity struct {
+	NameID   string
+	Name     string
+	FullName string
+	Email    string
+}
+
+func parseCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not a RSA key")
+	}
+	return rsaKey, nil
+}
+
+// METADATA_CACHE_TTL is how long metadata of identity provider is reused
+// before it is downloaded again.
+const METADATA_CACHE_TTL = time.Hour
+
+type cachedMetadata struct {
+	metadata *saml.EntityDescriptor
+	expires  time.Time
+}
+
+var (
+	metadataLock  sync.Mutex
+	metadataCache = make(map[string]*cachedMetadata)
+)
+
+// metadata returns metadata of identity provider, it is only downloaded
+// when there is no cached copy or the copy has expired.
+func (s *SAMLSource) metadata() (*saml.EntityDescriptor, error) {
+	key := s.MetadataURL + "\n" + s.EntityId
+
+	metadataLock.Lock()
+	cache, ok := metadataCache[key]
+	metadataLock.Unlock()
+	if ok && time.Now().Before(cache.expires) {
+		return cache.metadata, nil
+	}
+
+	metadata, err := s.fetchMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	metadataLock.Lock()
+	metadataCache[key] = &cachedMetadata{metadata, time.Now().Add(METADATA_CACHE_TTL)}
+	metadataLock.Unlock()
+	return metadata, nil
+}
+
+// fetchMetadata downloads and parses metadata of identity provider.
+func (s *SAMLSource) fetchMetadata() (*saml.EntityDescriptor, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(s.MetadataURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := new(saml.EntityDescriptor)
+	if err = xml.Unmarshal(data, metadata); err != nil {
+		return nil, err
+	}
+	if len(s.EntityId) > 0 && metadata.EntityID != s.EntityId {
+		return nil, fmt.Errorf("entity ID mismatch: %s", metadata.EntityID)
+	}
+	return metadata, nil
+}
+
+// ServiceProvider returns a service provider that uses given URLs
+// for its metadata and assertion consumer service.
+func (s *SAMLSource) ServiceProvider(metadataURL, acsURL string) (*saml.ServiceProvider, error) {
+	mURL, err := url.Parse(metadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse metadata URL: %v", err)
+	}
+	aURL, err := url.Parse(acsURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse ACS URL: %v", err)
+	}
+
+	cert, err := parseCertificate(s.CertificatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %v", err)
+	}
+	key, err := parsePrivateKey(s.PrivateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %v", err)
+	}
+
+	idpMetadata, err := s.metadata()
+	if err != nil {
+		return nil, fmt.Errorf("fetch identity provider metadata: %v", err)
+	}
+
+	return &saml.ServiceProvider{
+		Key:               key,
+		Certificate:       cert,
+		MetadataURL:       *mURL,
+		AcsURL:            *aURL,
+		IDPMetadata:       idpMetadata,
+		AllowIDPInitiated: false,
+	}, nil
+}
+
+// attribute returns first value of named attribute in the assertion.
+func attribute(assertion *saml.Assertion, name string) string {
+	if len(name) == 0 {
+		return ""
+	}
+	for _, stmt := range assertion.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			if (attr.Name == name || attr.FriendlyName == name) && len(attr.Values) > 0 {
+				return strings.TrimSpace(attr.Values[0].Value)
+			}
+		}
+	}
+	return ""
+}
+
+// AuthnRequest makes a new authentication request to identity provider.
+// It returns ID of the request, which must be given back to ParseResponse,
+// and the URL to redirect user to.
+func AuthnRequest(sp *saml.ServiceProvider) (string, string, error) {
+	req, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+	redirect, err := req.Redirect("", sp)
+	if err != nil {
+		return "", "", err
+	}
+	return req.ID, redirect.String(), nil
+}
+
+// ParseResponse validates the SAML response posted to the assertion consumer service
+// and extracts the identity according to the attribute mappings.
+// Only responses to the authentication request with given ID are accepted.
+func (s *SAMLSource) ParseResponse(sp *saml.ServiceProvider, req *http.Request, requestId string) (*Identity, error) {
+	if len(requestId) == 0 {
+		return nil, errors.New("no authentication request in progress")
+	}
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	assertion, err := sp.ParseResponse(req, []string{requestId})
+	if err != nil {
+		if ire, ok := err.(*saml.InvalidResponseError); ok {
+			return nil, fmt.Errorf("invalid response: %v", ire.PrivateErr)
+		}
+		return nil, err
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || len(assertion.Subject.NameID.Value) == 0 {
+		return nil, errors.New("assertion does not contain NameID")
+	}
+
+	id := &Identity{
+		NameID:   assertion.Subject.NameID.Value,
+		Name:     attribute(assertion, s.AttributeUsername),
+		FullName: attribute(assertion, s.AttributeFullName),
+		Email:    attribute(assertion, s.AttributeMail),
+	}
+	if len(id.Name) == 0 {
+		id.Name = id.NameID
+		if i := strings.Index(id.Name, "@"); i > 0 {
+			id.Name = id.Name[:i]
+		}
+	}
+	return id, nil
+}
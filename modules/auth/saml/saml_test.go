@@ -0,0 +1,106 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package saml
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIDPMetadata = `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com/">
+  <IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso"/>
+  </IDPSSODescriptor>
+</EntityDescriptor>`
+
+// newTestSource returns a source of identity provider served by a test server,
+// and number of times its metadata has been requested.
+func newTestSource(t *testing.T) (*SAMLSource, *int, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gogs"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte(testIDPMetadata))
+	}))
+	return &SAMLSource{
+		MetadataURL:    ts.URL,
+		EntityId:       "https://idp.example.com/",
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	}, &hits, ts.Close
+}
+
+func TestServiceProvider(t *testing.T) {
+	source, hits, closeServer := newTestSource(t)
+	defer closeServer()
+
+	for i := 0; i < 3; i++ {
+		sp, err := source.ServiceProvider("https://gogs.example.com/auth/saml/1/metadata", "https://gogs.example.com/auth/saml/1/acs")
+		if err != nil {
+			t.Fatal(err)
+		} else if sp.AllowIDPInitiated {
+			t.Fatal("expect IdP initiated sign in to be disallowed")
+		}
+	}
+	if *hits != 1 {
+		t.Errorf("expect metadata to be fetched once but got %d", *hits)
+	}
+
+	// Metadata of other identity provider is not mixed up with cached one.
+	source.EntityId = "https://other.example.com/"
+	if _, err := source.ServiceProvider("https://gogs.example.com/auth/saml/1/metadata", "https://gogs.example.com/auth/saml/1/acs"); err == nil {
+		t.Error("expect entity ID mismatch to be reported")
+	}
+}
+
+func TestAuthnRequest(t *testing.T) {
+	source, _, closeServer := newTestSource(t)
+	defer closeServer()
+
+	sp, err := source.ServiceProvider("https://gogs.example.com/auth/saml/1/metadata", "https://gogs.example.com/auth/saml/1/acs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestId, redirect, err := AuthnRequest(sp)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(requestId) == 0 {
+		t.Error("expect request ID to be returned")
+	} else if !strings.HasPrefix(redirect, "https://idp.example.com/sso?") {
+		t.Errorf("expect redirect to identity provider but got %s", redirect)
+	}
+
+	// Unsolicited responses are rejected before they are parsed.
+	req, _ := http.NewRequest("POST", "https://gogs.example.com/auth/saml/1/acs",
+		strings.NewReader(url.Values{"SAMLResponse": {"PHJlc3BvbnNlLz4="}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err = source.ParseResponse(sp, req, ""); err == nil {
+		t.Error("expect response without authentication request to be rejected")
+	}
+}
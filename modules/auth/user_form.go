@@ -83,6 +83,11 @@ type UpdateProfileForm struct {
 	Website  string `form:"website" binding:"Url;MaxSize(100)"`
 	Location string `form:"location" binding:"MaxSize(50)"`
 	Avatar   string `form:"avatar" binding:"Required;Email;MaxSize(50)"`
+
+	DefaultBranchName string `form:"default_branch" binding:"MaxSize(100)"`
+
+	EnableEmailNotification bool `form:"enable_email_notification"`
+	HideSSHKeys             bool `form:"hide_ssh_keys"`
 }
 
 func (f *UpdateProfileForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
@@ -125,8 +130,33 @@ func (f *AddSSHKeyForm) Validate(ctx *macaron.Context, errs binding.Errors) bind
 	return validate(errs, ctx.Data, f, ctx.Locale)
 }
 
+type ImportSSHKeysForm struct {
+	GitHubName string `form:"github_name" binding:"Required;MaxSize(39)"`
+}
+
+func (f *ImportSSHKeysForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
+type KeySourceForm struct {
+	KeySourceUrl string `form:"key_source_url" binding:"Required;Url;MaxSize(255)"`
+}
+
+func (f *KeySourceForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
+type AddGPGKeyForm struct {
+	Content string `form:"content" binding:"Required"`
+}
+
+func (f *AddGPGKeyForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
 type NewAccessTokenForm struct {
-	Name string `form:"name" binding:"Required"`
+	Name   string   `form:"name" binding:"Required"`
+	Scopes []string `form:"scopes"`
 }
 
 func (f *NewAccessTokenForm) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
@@ -112,9 +112,15 @@ var TemplateFuncs template.FuncMap = map[string]interface{}{
 	"AppVer": func() string {
 		return setting.AppVer
 	},
+	"Branding": func() interface{} {
+		return setting.Branding
+	},
 	"AppDomain": func() string {
 		return setting.Domain
 	},
+	"DefaultBranch": func() string {
+		return setting.DefaultBranch
+	},
 	"CdnMode": func() bool {
 		return setting.ProdMode && !setting.OfflineMode
 	},
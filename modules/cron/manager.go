@@ -8,6 +8,9 @@ import (
 	"fmt"
 
 	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/mailer"
+	"github.com/gogits/gogs/modules/process"
 	"github.com/gogits/gogs/modules/setting"
 )
 
@@ -19,9 +22,69 @@ func NewCronContext() {
 	if setting.Git.Fsck.Enable {
 		c.AddFunc("Repository health check", fmt.Sprintf("@every %dh", setting.Git.Fsck.Interval), models.GitFsck)
 	}
+	if setting.SSHKeyActivityRetention > 0 {
+		c.AddFunc("Prune SSH key activities", "@every 24h", models.PruneKeyActivities)
+	}
+	if setting.RequireSSHKeyVerify && setting.UnverifiedSSHKeyExpire > 0 {
+		c.AddFunc("Delete expired unverified SSH keys", "@every 24h", models.DeleteExpiredUnverifiedPublicKeys)
+	}
+	c.AddFunc("Check SSH keys against policy", "@every 24h", checkPublicKeysPolicy)
+	c.AddFunc("Synchronize SSH keys from LDAP", "@every 1h", models.SyncLDAPPublicKeys)
+	c.AddFunc("Synchronize SSH keys from key source URLs", "@every 10m", syncKeySources)
+	c.AddFunc("Prune repository traffic visitors", "@every 24h", models.PruneRepoTrafficVisitors)
+	if setting.AccountExportExpire > 0 {
+		c.AddFunc("Delete expired account data exports", "@every 24h", models.PruneAccountExports)
+	}
+	c.AddFunc("Detect repository languages", "@every 1m", models.DetectQueuedRepoLanguages)
+	if setting.Search.EnableBleve {
+		c.AddFunc("Index pushed commits", "@every 1m", models.IndexQueuedCommits)
+	}
+	if setting.RequireSSHKeyApproval {
+		c.AddFunc("Notify admins of SSH keys waiting for approval", "@every 1m", mailer.SendQueuedKeyApprovalNotifications)
+	}
+	if setting.Service.EnableNotifyMail {
+		c.AddFunc("Send push notifications", "@every 1m", mailer.SendQueuedPushNotifications)
+	}
+	if setting.ProcessTimeout > 0 {
+		c.AddFunc("Kill stale processes", "@every 10m", killStaleProcesses)
+	}
 	c.Start()
 }
 
+// killStaleProcesses kills processes that have been running longer than configured timeout.
+func killStaleProcesses() {
+	if n := process.KillStale(setting.ProcessTimeout); n > 0 {
+		log.Info("%d stale processes killed", n)
+	}
+}
+
+// checkPublicKeysPolicy flags SSH keys no longer meeting policy,
+// then disables the ones beyond grace period and notifies their owners.
+func checkPublicKeysPolicy() {
+	models.CheckPublicKeysPolicy()
+
+	keys, err := models.DisableExpiredBelowPolicyPublicKeys()
+	if err != nil {
+		log.Error(4, "DisableExpiredBelowPolicyPublicKeys: %v", err)
+	}
+	for _, key := range keys {
+		u, err := models.GetUserById(key.OwnerId)
+		if err != nil {
+			log.Error(4, "GetUserById[%d]: %v", key.OwnerId, err)
+			continue
+		}
+		mailer.SendSSHKeyBelowPolicyMail(u, key)
+	}
+}
+
+// syncKeySources synchronizes SSH keys from key source URLs that are due,
+// and notifies users whose keys have been changed.
+func syncKeySources() {
+	for _, change := range models.SyncKeySources() {
+		mailer.SendKeySourceSyncMail(change)
+	}
+}
+
 func ListEntries() []*Entry {
 	return c.Entries()
 }
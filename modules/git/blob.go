@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
+	"os/exec"
 
 	"github.com/Unknwon/com"
 )
@@ -24,3 +26,35 @@ func (b *Blob) Data() (io.Reader, error) {
 	}
 	return bytes.NewBuffer(stdout), nil
 }
+
+// DataHead returns at most n bytes from the beginning of blob,
+// the rest of blob is never read.
+func (b *Blob) DataHead(n int64) ([]byte, error) {
+	cmd := exec.Command("git", "cat-file", "blob", b.Id.String())
+	cmd.Dir = b.repo.Path
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	} else if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(stdout, n))
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	} else if int64(len(data)) == n {
+		// Stop reading rest of blob.
+		cmd.Process.Kill()
+		cmd.Wait()
+		return data, nil
+	}
+
+	if err = cmd.Wait(); err != nil {
+		return nil, errors.New(stderr.String())
+	}
+	return data, nil
+}
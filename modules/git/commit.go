@@ -6,7 +6,10 @@ package git
 
 import (
 	"bufio"
+	"bytes"
 	"container/list"
+	"os"
+	"os/exec"
 	"strings"
 )
 
@@ -17,6 +20,7 @@ type Commit struct {
 	Author        *Signature
 	Committer     *Signature
 	CommitMessage string
+	Signature     *ObjectSignature // Nil if commit is not signed.
 
 	parents    []sha1 // sha1 strings
 	submodules map[string]*SubModule
@@ -132,3 +136,16 @@ func (c *Commit) GetSubModules() (map[string]*SubModule, error) {
 
 	return c.submodules, nil
 }
+
+// VerifySignature runs "git verify-commit" against keyring in given GnuPG home
+// and returns machine-readable status output of GnuPG.
+// Error is returned along with the output when signature cannot be verified.
+func (c *Commit) VerifySignature(gnupgHome string) (string, error) {
+	cmd := exec.Command("git", "verify-commit", "--raw", c.Id.String())
+	cmd.Dir = c.repo.Path
+	cmd.Env = append(os.Environ(), "GNUPGHOME="+gnupgHome)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	return stderr.String(), err
+}
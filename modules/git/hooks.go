@@ -6,6 +6,7 @@ package git
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
@@ -25,10 +26,73 @@ var hookNames = []string{
 	"post-update",
 }
 
+// ServerHookNames is a list of server-side Git hooks' name that are wrapped by
+// generated scripts, so site-wide hooks always run before repository-level hooks.
+var ServerHookNames = []string{
+	"pre-receive",
+	"update",
+	"post-receive",
+}
+
 var (
 	ErrNotValidHook = errors.New("not a valid Git hook")
 )
 
+const (
+	_SERVER_HOOK_MARK = "# autogenerated by Gogs, DO NOT EDIT"
+	_TPL_SERVER_HOOK  = `#!/usr/bin/env %s
+` + _SERVER_HOOK_MARK + `
+data=$(cat)
+for hook in "%s"/* "$(dirname "$0")/%s.d"/*; do
+	test -x "$hook" || continue
+	echo "$data" | "$hook" "$@" || exit $?
+done
+%s
+`
+)
+
+// IsServerHookName returns true if given name is a server-side Git hook
+// that is wrapped by generated script.
+func IsServerHookName(name string) bool {
+	for _, hn := range ServerHookNames {
+		if hn == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteServerHooks writes wrapper scripts of server-side hooks to repository.
+// Each script passes same stdin and arguments to executables in <siteHookRoot>/<name>
+// and then hooks/<name>.d of repository, followed by command in cmds with same name if any.
+// Existing hand-written hook is moved to hooks/<name>.d so it keeps running.
+func WriteServerHooks(repoPath, scriptType, siteHookRoot string, cmds map[string]string) error {
+	for _, name := range ServerHookNames {
+		hookPath := path.Join(repoPath, "hooks", name)
+		hookDir := hookPath + ".d"
+		if err := os.MkdirAll(hookDir, os.ModePerm); err != nil {
+			return err
+		}
+
+		if _, ok := cmds[name]; !ok && isFile(hookPath) {
+			data, err := ioutil.ReadFile(hookPath)
+			if err != nil {
+				return err
+			} else if !strings.Contains(string(data), _SERVER_HOOK_MARK) {
+				if err = os.Rename(hookPath, path.Join(hookDir, name)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := ioutil.WriteFile(hookPath, []byte(fmt.Sprintf(_TPL_SERVER_HOOK,
+			scriptType, path.Join(siteHookRoot, name), name, cmds[name])), 0777); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // IsValidHookName returns true if given name is a valid Git hook.
 func IsValidHookName(name string) bool {
 	for _, hn := range hookNames {
@@ -57,6 +121,11 @@ func GetHook(repoPath, name string) (*Hook, error) {
 		name: name,
 		path: path.Join(repoPath, "hooks", name),
 	}
+	samplePath := h.path + ".sample"
+	// Repository-level server-side hook lives in directory of wrapper script.
+	if IsServerHookName(name) {
+		h.path = path.Join(repoPath, "hooks", name+".d", name)
+	}
 	if isFile(h.path) {
 		data, err := ioutil.ReadFile(h.path)
 		if err != nil {
@@ -64,8 +133,8 @@ func GetHook(repoPath, name string) (*Hook, error) {
 		}
 		h.IsActive = true
 		h.Content = string(data)
-	} else if isFile(h.path + ".sample") {
-		data, err := ioutil.ReadFile(h.path + ".sample")
+	} else if isFile(samplePath) {
+		data, err := ioutil.ReadFile(samplePath)
 		if err != nil {
 			return nil, err
 		}
@@ -83,6 +152,9 @@ func (h *Hook) Update() error {
 	if len(strings.TrimSpace(h.Content)) == 0 {
 		return os.Remove(h.path)
 	}
+	if err := os.MkdirAll(path.Dir(h.path), os.ModePerm); err != nil {
+		return err
+	}
 	return ioutil.WriteFile(h.path, []byte(strings.Replace(h.Content, "\r", "", -1)), os.ModePerm)
 }
 
@@ -0,0 +1,96 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, p, content string) {
+	if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+		t.Fatal(err)
+	} else if err = ioutil.WriteFile(p, []byte("#!/bin/sh\n"+content), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteServerHooks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoPath := path.Join(tmpDir, "repo.git")
+	siteHookRoot := path.Join(tmpDir, "site_hooks")
+	logPath := path.Join(tmpDir, "hooks.log")
+
+	// Hand-written hook of repository is kept, but runs after site hooks.
+	writeScript(t, path.Join(repoPath, "hooks", "pre-receive"), `echo "repo $(cat)" >> "`+logPath+`"`+"\n")
+	writeScript(t, path.Join(siteHookRoot, "pre-receive", "0000000001"), `echo "site1 $(cat)" >> "`+logPath+`"`+"\n")
+	writeScript(t, path.Join(siteHookRoot, "pre-receive", "0000000002"), `echo "site2 $(cat)" >> "`+logPath+`"`+"\n")
+
+	cmds := map[string]string{"update": `echo "gogs $1 $2 $3" >> "` + logPath + `"`}
+	if err = WriteServerHooks(repoPath, "sh", siteHookRoot, cmds); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path.Join(repoPath, "hooks", "pre-receive.d", "pre-receive")); err != nil {
+		t.Fatalf("expect hand-written hook to be moved: %v", err)
+	} else if !strings.Contains(string(data), "repo") {
+		t.Errorf("unexpected content of moved hook: %s", data)
+	}
+
+	// Writing again must not move generated wrapper into hook directory.
+	if err = WriteServerHooks(repoPath, "sh", siteHookRoot, cmds); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path.Join(repoPath, "hooks", "pre-receive.d", "pre-receive")); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(data), _SERVER_HOOK_MARK) {
+		t.Error("expect generated wrapper not to be moved as hand-written hook")
+	}
+
+	run := func(name string, args ...string) error {
+		cmd := exec.Command(path.Join(repoPath, "hooks", name), args...)
+		cmd.Stdin = strings.NewReader("old new refs/heads/master")
+		return cmd.Run()
+	}
+	if err = run("pre-receive"); err != nil {
+		t.Fatalf("run pre-receive: %v", err)
+	}
+	if err = run("update", "refs/heads/master", "old", "new"); err != nil {
+		t.Fatalf("run update: %v", err)
+	}
+	if err = run("post-receive"); err != nil {
+		t.Fatalf("run post-receive without any hook: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "site1 old new refs/heads/master\n" +
+		"site2 old new refs/heads/master\n" +
+		"repo old new refs/heads/master\n" +
+		"gogs refs/heads/master old new\n"
+	if string(data) != expect {
+		t.Errorf("expect hooks to run in order:\n%s\nbut got:\n%s", expect, data)
+	}
+
+	// A failing site hook rejects the push and stops later hooks.
+	os.Remove(logPath)
+	writeScript(t, path.Join(siteHookRoot, "update", "0000000003"), "exit 3\n")
+	if err = run("update", "refs/heads/master", "old", "new"); err == nil {
+		t.Error("expect update to fail when site hook fails")
+	}
+	if _, err = os.Stat(logPath); !os.IsNotExist(err) {
+		t.Errorf("expect no hook to run after failed site hook but got %v", err)
+	}
+}
@@ -5,7 +5,11 @@
 package git
 
 import (
+	"errors"
 	"path/filepath"
+	"strings"
+
+	"github.com/Unknwon/com"
 )
 
 // Repository represents a Git repository.
@@ -25,3 +29,83 @@ func OpenRepository(repoPath string) (*Repository, error) {
 
 	return &Repository{Path: repoPath}, nil
 }
+
+// GetRepoSize returns disk usage of the repository at the given path in KB,
+// which is the sum of packed and loose objects reported by git count-objects.
+func GetRepoSize(repoPath string) (int64, error) {
+	stdout, stderr, err := com.ExecCmdDir(repoPath, "git", "count-objects", "-v")
+	if err != nil {
+		return 0, errors.New(stderr)
+	}
+
+	var size int64
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(fields[0]) {
+		case "size", "size-pack":
+			size += com.StrTo(strings.TrimSpace(fields[1])).MustInt64()
+		}
+	}
+	return size, nil
+}
+
+// GetHeadCommitId returns commit ID that HEAD of the repository at the given path points to,
+// or an empty string if HEAD is unborn, i.e. the branch it points to has no commits.
+func GetHeadCommitId(repoPath string) (string, error) {
+	stdout, stderr, err := com.ExecCmdDir(repoPath, "git", "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		// Unborn HEAD is not an error but exits without any output.
+		if len(strings.TrimSpace(stdout)) == 0 && len(strings.TrimSpace(stderr)) == 0 {
+			return "", nil
+		}
+		return "", errors.New(stderr)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// ShortlogEntry represents an author with number of commits in git shortlog.
+type ShortlogEntry struct {
+	Name    string
+	Email   string
+	Commits int
+}
+
+// GetShortlog returns authors of commits reachable from HEAD of the repository
+// at the given path, most commits first.
+func GetShortlog(repoPath string) ([]*ShortlogEntry, error) {
+	stdout, stderr, err := com.ExecCmdDir(repoPath, "git", "shortlog", "-sne", "HEAD")
+	if err != nil {
+		return nil, errors.New(stderr)
+	}
+	return parseShortlog(stdout), nil
+}
+
+// parseShortlog parses output of git shortlog -sne,
+// each line is in format "<commits>\t<name> <<email>>".
+func parseShortlog(stdout string) []*ShortlogEntry {
+	entries := make([]*ShortlogEntry, 0, 10)
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		e := &ShortlogEntry{
+			Name:    strings.TrimSpace(fields[1]),
+			Commits: com.StrTo(fields[0]).MustInt(),
+		}
+		if i := strings.LastIndex(e.Name, " <"); i > -1 && strings.HasSuffix(e.Name, ">") {
+			e.Email = e.Name[i+2 : len(e.Name)-1]
+			e.Name = e.Name[:i]
+		} else if strings.HasPrefix(e.Name, "<") && strings.HasSuffix(e.Name, ">") {
+			// Author without name.
+			e.Email = e.Name[1 : len(e.Name)-1]
+			e.Name = ""
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
@@ -16,6 +16,24 @@ func IsBranchExist(repoPath, branchName string) bool {
 	return err == nil
 }
 
+// IsValidBranchName returns true if given name can be used as a branch name,
+// following rules of git check-ref-format.
+func IsValidBranchName(name string) bool {
+	if len(name) == 0 || name == "@" ||
+		strings.HasPrefix(name, "-") || strings.HasPrefix(name, "/") ||
+		strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") ||
+		strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") ||
+		strings.Contains(name, "/.") || strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	return true
+}
+
 func (repo *Repository) IsBranchExist(branchName string) bool {
 	return IsBranchExist(repo.Path, branchName)
 }
@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"container/list"
 	"errors"
+	"os"
+	"os/exec"
 	"strings"
 	"sync"
 
@@ -53,6 +55,12 @@ func (repo *Repository) GetCommitOfTag(tagName string) (*Commit, error) {
 func parseCommitData(data []byte) (*Commit, error) {
 	commit := new(Commit)
 	commit.parents = make([]sha1, 0, 1)
+
+	// Signed payload is the object without signature header.
+	payload := new(bytes.Buffer)
+	var sigBuf *bytes.Buffer
+	inSig, isSHA1Sig := false, false
+
 	// we now have the contents of the commit object. Let's investigate...
 	nextline := 0
 l:
@@ -61,6 +69,23 @@ l:
 		switch {
 		case eol > 0:
 			line := data[nextline : nextline+eol]
+
+			// Lines of multi-line header start with a space.
+			if line[0] == ' ' {
+				if inSig {
+					if isSHA1Sig {
+						sigBuf.Write(line[1:])
+						sigBuf.WriteByte('\n')
+					}
+				} else {
+					payload.Write(line)
+					payload.WriteByte('\n')
+				}
+				nextline += eol + 1
+				continue
+			}
+			inSig = false
+
 			spacepos := bytes.IndexByte(line, ' ')
 			reftype := line[:spacepos]
 			switch string(reftype) {
@@ -89,15 +114,37 @@ l:
 					return nil, err
 				}
 				commit.Committer = sig
+			case "gpgsig", "gpgsig-sha256":
+				// Signature over SHA-256 object format is not used by SHA-1 repositories,
+				// but it is not part of payload either.
+				inSig = true
+				isSHA1Sig = string(reftype) == "gpgsig"
+				if isSHA1Sig {
+					sigBuf = new(bytes.Buffer)
+					sigBuf.Write(line[spacepos+1:])
+					sigBuf.WriteByte('\n')
+				}
+				nextline += eol + 1
+				continue
 			}
+			payload.Write(line)
+			payload.WriteByte('\n')
 			nextline += eol + 1
 		case eol == 0:
 			commit.CommitMessage = string(data[nextline+1:])
+			payload.Write(data[nextline:])
 			break l
 		default:
 			break l
 		}
 	}
+
+	if sigBuf != nil {
+		commit.Signature = &ObjectSignature{
+			Signature: sigBuf.String(),
+			Payload:   payload.String(),
+		}
+	}
 	return commit, nil
 }
 
@@ -297,3 +344,57 @@ func (repo *Repository) getCommitOfRelPath(id sha1, relPath string) (*Commit, er
 
 	return repo.getCommit(id)
 }
+
+// CommitSignatureOutput represents output of GnuPG for signature of a commit.
+type CommitSignatureOutput struct {
+	CommitId       string
+	CommitterEmail string
+	Output         string // Empty when commit is not signed.
+}
+
+// _SIGNATURE_LOG_MARKER starts the line that follows signature output of each commit.
+const _SIGNATURE_LOG_MARKER = "\x1ecommit "
+
+// LogSignatures runs "git log --show-signature" from given ref against keyring
+// in given GnuPG home and returns signature output of each commit.
+func (repo *Repository) LogSignatures(ref string, skip, limit int, gnupgHome string) ([]*CommitSignatureOutput, error) {
+	cmd := exec.Command("git", "log", "--show-signature", "--format=%x1ecommit %H %ce",
+		"--skip="+com.ToStr(skip), "--max-count="+com.ToStr(limit), ref, "--")
+	cmd.Dir = repo.Path
+	// Output of GnuPG is parsed, so it must not be translated.
+	cmd.Env = append(os.Environ(), "GNUPGHOME="+gnupgHome, "LC_ALL=C")
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New(stderr.String())
+	}
+	return parseSignatureLog(stdout.String()), nil
+}
+
+// parseSignatureLog splits output of "git log --show-signature" by commit,
+// signature output of a commit is printed before its formatted line.
+func parseSignatureLog(log string) []*CommitSignatureOutput {
+	sigs := make([]*CommitSignatureOutput, 0, 10)
+	output := make([]string, 0, 5)
+	for _, line := range strings.Split(log, "\n") {
+		if strings.HasPrefix(line, _SIGNATURE_LOG_MARKER) {
+			// Committer e-mail may be empty.
+			fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, _SIGNATURE_LOG_MARKER)), " ", 2)
+			sig := &CommitSignatureOutput{
+				CommitId: fields[0],
+				Output:   strings.Join(output, "\n"),
+			}
+			if len(fields) == 2 {
+				sig.CommitterEmail = fields[1]
+			}
+			sigs = append(sigs, sig)
+			output = output[:0]
+			continue
+		}
+		if len(strings.TrimSpace(line)) > 0 {
+			output = append(output, line)
+		}
+	}
+	return sigs
+}
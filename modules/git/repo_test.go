@@ -0,0 +1,77 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Unknwon/com"
+)
+
+func TestParseShortlog(t *testing.T) {
+	entries := parseShortlog("    12\tUnknwon <u@gogs.io>\n" +
+		"     3\tJohn <Doe> Smith <john@example.com>\n" +
+		"     1\t<nameless@example.com>\n" +
+		"     1\tNo Email\n" +
+		"malformed line\n\n")
+
+	expect := []ShortlogEntry{
+		{"Unknwon", "u@gogs.io", 12},
+		{"John <Doe> Smith", "john@example.com", 3},
+		{"", "nameless@example.com", 1},
+		{"No Email", "", 1},
+	}
+	if len(entries) != len(expect) {
+		t.Fatalf("expect %d entries but got %d", len(expect), len(entries))
+	}
+	for i, e := range entries {
+		if *e != expect[i] {
+			t.Errorf("entry %d: expect %+v but got %+v", i, expect[i], *e)
+		}
+	}
+}
+
+func TestGetRepoSize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, _, err = com.ExecCmdDir(tmpDir, "git", "init", "--bare"); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := GetRepoSize(tmpDir); err != nil {
+		t.Fatal(err)
+	} else if size != 0 {
+		t.Errorf("expect empty repository to have size 0 but got %d", size)
+	}
+
+	// Random data does not compress, so its loose object takes about as much space.
+	data := make([]byte, 256*1024)
+	if _, err = rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	blobPath := path.Join(tmpDir, "blob")
+	if err = ioutil.WriteFile(blobPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, err := com.ExecCmdDir(tmpDir, "git", "hash-object", "-w", blobPath); err != nil {
+		t.Fatalf("git hash-object: %v - %s", err, stderr)
+	}
+	if size, err := GetRepoSize(tmpDir); err != nil {
+		t.Fatal(err)
+	} else if size < 250 || size > 300 {
+		t.Errorf("expect size of about 256 KB but got %d", size)
+	}
+
+	if _, err = GetRepoSize(path.Join(tmpDir, "missing")); err == nil {
+		t.Error("expect error for missing repository")
+	}
+}
@@ -17,6 +17,13 @@ type Signature struct {
 	When  time.Time
 }
 
+// ObjectSignature represents signature of a commit or tag object, i.e. the gpgsig header
+// of commit or the trailing signature of tag message, along with the payload that has been signed.
+type ObjectSignature struct {
+	Signature string
+	Payload   string
+}
+
 // Helper to get a signature from the commit line, which looks like these:
 //     author Patrick Gundlach <gundlach@speedata.de> 1378823654 +0200
 //     author Patrick Gundlach <gundlach@speedata.de> Thu, 07 Apr 2005 22:13:13 +0200
@@ -6,6 +6,9 @@ package git
 
 import (
 	"bytes"
+	"os"
+	"os/exec"
+	"strings"
 )
 
 // Tag represents a Git tag.
@@ -17,12 +20,33 @@ type Tag struct {
 	Type       string
 	Tagger     *Signature
 	TagMessage string
+	Signature  *ObjectSignature // Nil if tag is not signed.
 }
 
 func (tag *Tag) Commit() (*Commit, error) {
 	return tag.repo.getCommit(tag.Object)
 }
 
+// VerifyTag runs "git verify-tag" on given tag object of repository against keyring
+// in given GnuPG home and returns machine-readable status output of GnuPG.
+// Object can be a tag name or ID of a tag object that no reference points to yet.
+// Error is returned along with the output when signature cannot be verified.
+func VerifyTag(repoPath, gnupgHome, object string) (string, error) {
+	cmd := exec.Command("git", "verify-tag", "--raw", object)
+	cmd.Dir = repoPath
+	cmd.Env = append(os.Environ(), "GNUPGHOME="+gnupgHome)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	return stderr.String(), err
+}
+
+// signatureArmorStarts is the list of headers of signatures that can be appended to tag message.
+var signatureArmorStarts = []string{
+	"-----BEGIN PGP SIGNATURE-----",
+	"-----BEGIN SSH SIGNATURE-----",
+}
+
 // Parse commit information from the (uncompressed) raw
 // data from the commit object.
 // \n\n separate headers from message
@@ -58,6 +82,18 @@ l:
 			nextline += eol + 1
 		case eol == 0:
 			tag.TagMessage = string(data[nextline+1:])
+
+			// Signature is appended to message, and payload is everything before it.
+			for _, start := range signatureArmorStarts {
+				if i := strings.LastIndex(tag.TagMessage, start); i > -1 {
+					tag.Signature = &ObjectSignature{
+						Signature: tag.TagMessage[i:],
+						Payload:   string(data[:nextline+1+i]),
+					}
+					tag.TagMessage = tag.TagMessage[:i]
+					break
+				}
+			}
 			break l
 		default:
 			break l
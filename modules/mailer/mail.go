@@ -5,16 +5,21 @@
 package mailer
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"html/template"
 	"path"
+	"sync"
+	"time"
 
 	"github.com/Unknwon/com"
 	"github.com/Unknwon/macaron"
 
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/git"
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/setting"
 )
@@ -25,8 +30,14 @@ const (
 	AUTH_REGISTER_SUCCESS base.TplName = "mail/auth/register_success"
 	AUTH_RESET_PASSWORD   base.TplName = "mail/auth/reset_passwd"
 
-	NOTIFY_COLLABORATOR base.TplName = "mail/notify/collaborator"
-	NOTIFY_MENTION      base.TplName = "mail/notify/mention"
+	NOTIFY_COLLABORATOR  base.TplName = "mail/notify/collaborator"
+	NOTIFY_MENTION       base.TplName = "mail/notify/mention"
+	NOTIFY_ORG_TRANSFER  base.TplName = "mail/notify/org_transfer"
+	NOTIFY_PUSH          base.TplName = "mail/notify/push"
+	NOTIFY_SSH_KEY       base.TplName = "mail/notify/ssh_key_added"
+	NOTIFY_SSH_KEY_ADMIN base.TplName = "mail/notify/ssh_key_admin"
+	NOTIFY_SSH_KEY_PEND  base.TplName = "mail/notify/ssh_key_pending"
+	NOTIFY_STAR          base.TplName = "mail/notify/star_notification"
 )
 
 // Create New mail message use MailFrom and MailUser
@@ -54,6 +65,17 @@ func GetMailTmplData(u *models.User) map[interface{}]interface{} {
 
 // create a time limit code for user active
 func CreateUserActiveCode(u *models.User, startInf interface{}) string {
+	minutes := setting.Service.ActiveCodeLives
+	data := com.ToStr(u.Id) + u.Email + u.LowerName + u.Passwd + u.EmailConfirmToken
+	code := base.CreateTimeLimitCode(data, minutes, startInf)
+
+	// add tail hex username
+	code += hex.EncodeToString([]byte(u.LowerName))
+	return code
+}
+
+// create a time limit code for reset password
+func CreateUserResetPasswdCode(u *models.User, startInf interface{}) string {
 	minutes := setting.Service.ActiveCodeLives
 	data := com.ToStr(u.Id) + u.Email + u.LowerName + u.Passwd + u.Rands
 	code := base.CreateTimeLimitCode(data, minutes, startInf)
@@ -66,7 +88,7 @@ func CreateUserActiveCode(u *models.User, startInf interface{}) string {
 // create a time limit code for user active
 func CreateUserEmailActivateCode(u *models.User, e *models.EmailAddress, startInf interface{}) string {
 	minutes := setting.Service.ActiveCodeLives
-	data := com.ToStr(u.Id) + e.Email + u.LowerName + u.Passwd + u.Rands
+	data := com.ToStr(u.Id) + e.Email + u.LowerName + u.Passwd + e.ConfirmToken
 	code := base.CreateTimeLimitCode(data, minutes, startInf)
 
 	// add tail hex username
@@ -76,6 +98,10 @@ func CreateUserEmailActivateCode(u *models.User, e *models.EmailAddress, startIn
 
 // Send user register mail with active code
 func SendRegisterMail(r macaron.Render, u *models.User) {
+	if err := models.GenerateEmailConfirmToken(u); err != nil {
+		log.Error(4, "mail.SendRegisterMail(GenerateEmailConfirmToken): %v", err)
+		return
+	}
 	code := CreateUserActiveCode(u, nil)
 	subject := "Register success, Welcome"
 
@@ -95,6 +121,10 @@ func SendRegisterMail(r macaron.Render, u *models.User) {
 
 // Send email verify active email.
 func SendActiveMail(r macaron.Render, u *models.User) {
+	if err := models.GenerateEmailConfirmToken(u); err != nil {
+		log.Error(4, "mail.SendActiveMail(GenerateEmailConfirmToken): %v", err)
+		return
+	}
 	code := CreateUserActiveCode(u, nil)
 
 	subject := "Verify your e-mail address"
@@ -115,6 +145,10 @@ func SendActiveMail(r macaron.Render, u *models.User) {
 
 // Send email to verify secondary email.
 func SendActivateEmail(r macaron.Render, user *models.User, email *models.EmailAddress) {
+	if err := models.GenerateEmailAddressConfirmToken(email); err != nil {
+		log.Error(4, "mail.SendActivateEmail(GenerateEmailAddressConfirmToken): %v", err)
+		return
+	}
 	code := CreateUserEmailActivateCode(user, email, nil)
 
 	subject := "Verify your e-mail address"
@@ -136,7 +170,7 @@ func SendActivateEmail(r macaron.Render, user *models.User, email *models.EmailA
 
 // Send reset password email.
 func SendResetPasswdMail(r macaron.Render, u *models.User) {
-	code := CreateUserActiveCode(u, nil)
+	code := CreateUserResetPasswdCode(u, nil)
 
 	subject := "Reset your password"
 
@@ -170,6 +204,8 @@ func SendIssueNotifyMail(u, owner *models.User, repo *models.Repository, issue *
 		u, err := models.GetUserById(uid)
 		if err != nil {
 			return nil, errors.New("mail.NotifyWatchers(GetUserById): " + err.Error())
+		} else if u.IsBot {
+			continue
 		}
 		tos = append(tos, u.Email)
 	}
@@ -216,6 +252,9 @@ func SendIssueMentionMail(r macaron.Render, u, owner *models.User,
 // SendCollaboratorMail sends mail notification to new collaborator.
 func SendCollaboratorMail(r macaron.Render, u, owner *models.User,
 	repo *models.Repository) error {
+	if u.IsBot {
+		return nil
+	}
 
 	subject := fmt.Sprintf("%s added you to %s", owner.Name, repo.Name)
 
@@ -234,3 +273,343 @@ func SendCollaboratorMail(r macaron.Render, u, owner *models.User,
 	SendAsync(&msg)
 	return nil
 }
+
+// SendOrgTransferMail sends mail notification to both previous and new owner
+// about ownership transfer of organization.
+func SendOrgTransferMail(r macaron.Render, org, oldOwner, newOwner *models.User) {
+	subject := fmt.Sprintf("Ownership of organization %s has been transferred", org.Name)
+
+	for _, u := range []*models.User{oldOwner, newOwner} {
+		data := GetMailTmplData(u)
+		data["Subject"] = subject
+		data["Org"] = org
+		data["OldOwner"] = oldOwner
+		data["NewOwner"] = newOwner
+		data["IsNewOwner"] = u.Id == newOwner.Id
+		body, err := r.HTMLString(string(NOTIFY_ORG_TRANSFER), data)
+		if err != nil {
+			log.Error(4, "mail.SendOrgTransferMail(fail to render): %v", err)
+			return
+		}
+
+		msg := NewMailMessage([]string{u.Email}, subject, body)
+		msg.Info = fmt.Sprintf("UID: %d, send organization transfer mail", u.Id)
+
+		SendAsync(&msg)
+	}
+}
+
+// SendSSHKeyAddedMail sends mail notification to owner of newly added SSH key,
+// source describes how the key was added, e.g. via web or by an admin.
+func SendSSHKeyAddedMail(r macaron.Render, u *models.User, key *models.PublicKey, source string) {
+	if !setting.Service.EnableSSHKeyNotifyMail || u.IsBot {
+		return
+	}
+
+	subject := "A new SSH key was added to your account"
+
+	data := GetMailTmplData(u)
+	data["Subject"] = subject
+	data["Key"] = key
+	data["Source"] = source
+	body, err := r.HTMLString(string(NOTIFY_SSH_KEY), data)
+	if err != nil {
+		log.Error(4, "mail.SendSSHKeyAddedMail(fail to render): %v", err)
+		return
+	}
+
+	msg := NewMailMessage([]string{u.Email}, subject, body)
+	msg.Info = fmt.Sprintf("UID: %d, send SSH key added mail", u.Id)
+
+	SendAsync(&msg)
+}
+
+// SendSSHKeyAdminMail sends mail notification to owner of SSH key that has been
+// deleted, disabled, enabled, approved or rejected by an admin, reason is optional.
+func SendSSHKeyAdminMail(r macaron.Render, u *models.User, key *models.PublicKey, action, reason string) {
+	if !setting.Service.EnableSSHKeyNotifyMail || u.IsBot {
+		return
+	}
+
+	subject := fmt.Sprintf("Your SSH key has been %s by an administrator", action)
+
+	data := GetMailTmplData(u)
+	data["Subject"] = subject
+	data["Key"] = key
+	data["Action"] = action
+	data["Reason"] = reason
+	body, err := r.HTMLString(string(NOTIFY_SSH_KEY_ADMIN), data)
+	if err != nil {
+		log.Error(4, "mail.SendSSHKeyAdminMail(fail to render): %v", err)
+		return
+	}
+
+	msg := NewMailMessage([]string{u.Email}, subject, body)
+	msg.Info = fmt.Sprintf("UID: %d, send SSH key %s mail", u.Id, action)
+
+	SendAsync(&msg)
+}
+
+// notifyKeyApproval sends mail notification to admins
+// about queued SSH key that is waiting for approval.
+func notifyKeyApproval(task *models.KeyApprovalNotifyTask) error {
+	key, err := models.GetPublicKeyById(task.KeyId)
+	if err != nil {
+		if err == models.ErrKeyNotExist {
+			return nil
+		}
+		return fmt.Errorf("GetPublicKeyById: %v", err)
+	} else if !key.IsPending {
+		return nil
+	}
+	u, err := models.GetUserById(key.OwnerId)
+	if err != nil {
+		return fmt.Errorf("GetUserById: %v", err)
+	}
+	admins, err := models.GetAdminUsers()
+	if err != nil {
+		return fmt.Errorf("GetAdminUsers: %v", err)
+	} else if len(admins) == 0 {
+		return nil
+	}
+
+	subject := fmt.Sprintf("SSH key of %s is waiting for approval", u.Name)
+
+	data := GetMailTmplData(u)
+	data["Subject"] = subject
+	data["Key"] = key
+	body, err := renderMailTemplate(NOTIFY_SSH_KEY_PEND, data)
+	if err != nil {
+		return fmt.Errorf("renderMailTemplate: %v", err)
+	}
+
+	tos := make([]string, len(admins))
+	for i := range admins {
+		tos[i] = admins[i].Email
+	}
+	msg := NewMailMessage(tos, subject, body)
+	msg.Info = fmt.Sprintf("UID: %d, send SSH key pending mail", u.Id)
+
+	SendAsync(&msg)
+	return nil
+}
+
+// SendQueuedKeyApprovalNotifications notifies admins of queued SSH keys
+// waiting for approval, queue is emptied even when notifications are disabled.
+func SendQueuedKeyApprovalNotifications() {
+	for {
+		tasks, err := models.GetKeyApprovalNotifyTasks(100)
+		if err != nil {
+			log.Error(4, "GetKeyApprovalNotifyTasks: %v", err)
+			return
+		} else if len(tasks) == 0 {
+			return
+		}
+
+		for _, t := range tasks {
+			if setting.Service.EnableSSHKeyNotifyMail {
+				if err = notifyKeyApproval(t); err != nil {
+					log.Error(4, "notifyKeyApproval[%d]: %v", t.Id, err)
+				}
+			}
+			if err = models.DeleteKeyApprovalNotifyTask(t.Id); err != nil {
+				log.Error(4, "DeleteKeyApprovalNotifyTask[%d]: %v", t.Id, err)
+				return
+			}
+		}
+	}
+}
+
+// SendSSHKeyBelowPolicyMail sends mail notification to owner of SSH key that has been
+// disabled because it no longer meets minimum key size or type policy.
+func SendSSHKeyBelowPolicyMail(u *models.User, key *models.PublicKey) {
+	if !setting.Service.EnableSSHKeyNotifyMail || u.IsBot {
+		return
+	}
+
+	subject := "Your SSH key has been disabled because it no longer meets key policy"
+	content := fmt.Sprintf(`Your SSH key "%s" (%s, %s %d bits) no longer meets minimum key size or type policy of %s and has been disabled.<br><br>`+
+		`Please generate a new key, for example with <code>ssh-keygen -t ed25519</code>, and add it on <a href="%suser/settings/ssh">your SSH keys settings page</a>.`,
+		key.Name, key.Fingerprint, key.Type, key.Size, setting.AppName, setting.AppUrl)
+	msg := NewMailMessage([]string{u.Email}, subject, content)
+	msg.Info = fmt.Sprintf("UID: %d, send SSH key below policy mail", u.Id)
+
+	SendAsync(&msg)
+}
+
+// SendKeySourceSyncMail sends mail notification to user whose SSH keys have been changed
+// by synchronization from its key source URL.
+func SendKeySourceSyncMail(change *models.KeySourceChange) {
+	u := change.Owner
+	if !setting.Service.EnableSSHKeyNotifyMail || u.IsBot {
+		return
+	}
+
+	subject := "Your SSH keys have been synchronized from your key source URL"
+	content := fmt.Sprintf("SSH keys of your account have been synchronized from %s.<br><br>",
+		template.HTMLEscapeString(change.Source.Url))
+	for _, key := range change.Added {
+		content += fmt.Sprintf("Added: %s (%s)<br>", template.HTMLEscapeString(key.Name), key.Fingerprint)
+	}
+	for _, key := range change.Removed {
+		content += fmt.Sprintf("Removed: %s (%s)<br>", template.HTMLEscapeString(key.Name), key.Fingerprint)
+	}
+	content += fmt.Sprintf(`<br>If you did not expect these changes, please check the file published at your key source URL and review <a href="%suser/settings/ssh">your SSH keys settings page</a>.`,
+		setting.AppUrl)
+	msg := NewMailMessage([]string{u.Email}, subject, content)
+	msg.Info = fmt.Sprintf("UID: %d, send key source sync mail", u.Id)
+
+	SendAsync(&msg)
+}
+
+const _STAR_NOTIFY_INTERVAL = time.Hour
+
+var (
+	starNotifyLocker = sync.Mutex{}
+	starNotifyTimes  = make(map[int64]time.Time) // Last notified time by repository ID.
+)
+
+// SendStarNotification sends mail notification to owner of repository that has been starred,
+// at most one mail is sent per repository within notify interval.
+func SendStarNotification(r macaron.Render, repo *models.Repository, starrer *models.User) {
+	if !setting.Notification.NotifyOnStar || setting.MailService == nil {
+		return
+	}
+
+	if err := repo.GetOwner(); err != nil {
+		log.Error(4, "mail.SendStarNotification(GetOwner): %v", err)
+		return
+	}
+	owner := repo.Owner
+	if owner.Id == starrer.Id || !owner.EnableEmailNotification || len(owner.Email) == 0 {
+		return
+	}
+
+	starNotifyLocker.Lock()
+	if last, ok := starNotifyTimes[repo.Id]; ok && time.Since(last) < _STAR_NOTIFY_INTERVAL {
+		starNotifyLocker.Unlock()
+		return
+	}
+	starNotifyTimes[repo.Id] = time.Now()
+	starNotifyLocker.Unlock()
+
+	subject := fmt.Sprintf("[%s] %s starred your repository", repo.Name, starrer.Name)
+
+	data := GetMailTmplData(owner)
+	data["Subject"] = subject
+	data["Repo"] = repo
+	data["Starrer"] = starrer
+	body, err := r.HTMLString(string(NOTIFY_STAR), data)
+	if err != nil {
+		log.Error(4, "mail.SendStarNotification(fail to render): %v", err)
+		return
+	}
+
+	msg := NewMailMessage([]string{owner.Email}, subject, body)
+	msg.Info = fmt.Sprintf("UID: %d, send star notification of repository(%d)", owner.Id, repo.Id)
+
+	SendAsync(&msg)
+}
+
+// _PUSH_DIGEST_MAX_COMMITS is the max number of commits listed in a push notification.
+const _PUSH_DIGEST_MAX_COMMITS = 20
+
+// renderMailTemplate renders mail template for processes that have no renderer of web server,
+// template file is parsed on every call.
+func renderMailTemplate(tplName base.TplName, data interface{}) (string, error) {
+	tplPath := path.Join(setting.StaticRootPath, "templates", string(tplName)+".tmpl")
+	tpl, err := template.New(path.Base(tplPath)).Funcs(base.TemplateFuncs).ParseFiles(tplPath)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = tpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// notifyPushSubscribers sends a digest of commits of queued push to users who subscribed
+// to pushes of the branch, except pusher.
+func notifyPushSubscribers(task *models.PushNotifyTask) error {
+	subscribers, err := models.GetPushSubscribers(task.RepoId, task.Branch)
+	if err != nil {
+		return fmt.Errorf("GetPushSubscribers: %v", err)
+	}
+	tos := make([]string, 0, len(subscribers))
+	for _, u := range subscribers {
+		if u.Name != task.Pusher {
+			tos = append(tos, u.Email)
+		}
+	}
+	if len(tos) == 0 {
+		return nil
+	}
+
+	repo, err := models.GetRepositoryById(task.RepoId)
+	if err != nil {
+		return fmt.Errorf("GetRepositoryById: %v", err)
+	} else if err = repo.GetOwner(); err != nil {
+		return fmt.Errorf("GetOwner: %v", err)
+	}
+	gitRepo, err := git.OpenRepository(models.RepoPath(repo.Owner.Name, repo.Name))
+	if err != nil {
+		return fmt.Errorf("OpenRepository: %v", err)
+	}
+
+	ids := task.CommitIdList()
+	commits := make([]*git.Commit, 0, _PUSH_DIGEST_MAX_COMMITS)
+	for i := 0; i < len(ids) && i < _PUSH_DIGEST_MAX_COMMITS; i++ {
+		c, err := gitRepo.GetCommit(ids[i])
+		if err != nil {
+			return fmt.Errorf("GetCommit(%s): %v", ids[i], err)
+		}
+		commits = append(commits, c)
+	}
+
+	subject := fmt.Sprintf("[%s/%s] %s pushed %d commit(s) to %s", repo.Owner.Name, repo.Name, task.Pusher, len(ids), task.Branch)
+	data := map[string]interface{}{
+		"Subject":    subject,
+		"AppUrl":     setting.AppUrl,
+		"Repo":       repo,
+		"Branch":     task.Branch,
+		"Pusher":     task.Pusher,
+		"Commits":    commits,
+		"NumCommits": len(ids),
+		"NumMore":    len(ids) - len(commits),
+	}
+	body, err := renderMailTemplate(NOTIFY_PUSH, data)
+	if err != nil {
+		return fmt.Errorf("render: %v", err)
+	}
+
+	msg := NewMailMessage(tos, subject, body)
+	msg.Massive = true
+	msg.Info = fmt.Sprintf("Subject: %s, send push notify emails", subject)
+	SendAsync(&msg)
+	return nil
+}
+
+// SendQueuedPushNotifications sends digests of queued pushes to subscribers of repositories.
+func SendQueuedPushNotifications() {
+	for {
+		tasks, err := models.GetPushNotifyTasks(100)
+		if err != nil {
+			log.Error(4, "GetPushNotifyTasks: %v", err)
+			return
+		} else if len(tasks) == 0 {
+			return
+		}
+
+		for _, t := range tasks {
+			if err = notifyPushSubscribers(t); err != nil {
+				log.Error(4, "notifyPushSubscribers[%d]: %v", t.Id, err)
+			}
+			if err = models.DeletePushNotifyTask(t.Id); err != nil {
+				log.Error(4, "DeletePushNotifyTask[%d]: %v", t.Id, err)
+				return
+			}
+		}
+	}
+}
@@ -213,6 +213,17 @@ func SendAsync(msg *Message) {
 	}()
 }
 
+// SendNow sends mail message through mail queue if it is running, otherwise sends it right away,
+// so processes that do not run the queue, e.g. serv, can send mail messages as well.
+func SendNow(msg *Message) error {
+	if mailQueue != nil {
+		SendAsync(msg)
+		return nil
+	}
+	_, err := Send(msg)
+	return err
+}
+
 // Create html mail message
 func NewHtmlMessage(To []string, From, Subject, Body string) Message {
 	return Message{
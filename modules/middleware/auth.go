@@ -6,6 +6,7 @@ package middleware
 
 import (
 	"net/url"
+	"strings"
 
 	"github.com/Unknwon/macaron"
 	"github.com/macaron-contrib/csrf"
@@ -78,6 +79,45 @@ func ApiReqToken() macaron.Handler {
 	}
 }
 
+func ApiReqAdmin() macaron.Handler {
+	return func(ctx *Context) {
+		if !ctx.IsSigned || !ctx.User.IsAdmin {
+			ctx.Error(403)
+			return
+		}
+	}
+}
+
+// checkScope responds with 403 unless access token used by request has any of given scopes,
+// requests that are not authenticated by token are not limited.
+func checkScope(ctx *Context, scopes []string) {
+	if ctx.AccessToken == nil {
+		return
+	}
+	for _, scope := range scopes {
+		if ctx.AccessToken.HasScope(scope) {
+			return
+		}
+	}
+	ctx.HandleAPI(403, "Access token requires scope: "+strings.Join(scopes, " or "))
+}
+
+// ApiReqScope requires access token used by request to have any of given scopes.
+func ApiReqScope(scopes ...string) macaron.Handler {
+	return func(ctx *Context) {
+		checkScope(ctx, scopes)
+	}
+}
+
+// ApiReqWriteScope is like ApiReqScope but only applies to requests that modify data.
+func ApiReqWriteScope(scopes ...string) macaron.Handler {
+	return func(ctx *Context) {
+		if ctx.Req.Method != "GET" && ctx.Req.Method != "HEAD" {
+			checkScope(ctx, scopes)
+		}
+	}
+}
+
 func ApiReqBasicAuth() macaron.Handler {
 	return func(ctx *Context) {
 		if !ctx.IsBasicAuth {
@@ -5,13 +5,16 @@
 package middleware
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
+	"github.com/Unknwon/com"
 	"github.com/Unknwon/macaron"
 	"github.com/macaron-contrib/cache"
 	"github.com/macaron-contrib/csrf"
@@ -35,6 +38,7 @@ type Context struct {
 	Session session.Store
 
 	User        *models.User
+	AccessToken *models.AccessToken // Set when API request is authenticated by token.
 	IsSigned    bool
 	IsBasicAuth bool
 
@@ -131,14 +135,54 @@ func (ctx *Context) Handle(status int, title string, err error) {
 	}
 
 	switch status {
+	case 403:
+		ctx.Data["Title"] = "Forbidden"
 	case 404:
 		ctx.Data["Title"] = "Page Not Found"
 	case 500:
 		ctx.Data["Title"] = "Internal Server Error"
 	}
+	ctx.Data["AppName"] = setting.AppName
+	ctx.Data["RequestPath"] = ctx.Req.URL.Path
+
+	if tplPath := customStatusTemplate(status); len(tplPath) > 0 {
+		err := ctx.renderCustomStatus(status, tplPath)
+		if err == nil {
+			return
+		}
+		log.Error(4, "Fail to render custom status page '%s': %v", tplPath, err)
+	}
 	ctx.HTML(status, base.TplName(fmt.Sprintf("status/%d", status)))
 }
 
+// customStatusTemplate returns path of template in custom directory that overrides
+// built-in page of given status, or empty string if there is no such file.
+func customStatusTemplate(status int) string {
+	tplPath := path.Join(setting.CustomPath, "templates/status", com.ToStr(status)+".tmpl")
+	if !com.IsFile(tplPath) {
+		return ""
+	}
+	return tplPath
+}
+
+// renderCustomStatus renders given template file as response of given status,
+// file is parsed on every call so changes take effect without restart.
+func (ctx *Context) renderCustomStatus(status int, tplPath string) error {
+	tpl, err := template.New(path.Base(tplPath)).Funcs(base.TemplateFuncs).ParseFiles(tplPath)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = tpl.Execute(buf, ctx.Data); err != nil {
+		return err
+	}
+	ctx.Resp.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	ctx.Resp.WriteHeader(status)
+	_, err = ctx.Resp.Write(buf.Bytes())
+	return err
+}
+
 func (ctx *Context) HandleAPI(status int, obj interface{}) {
 	var message string
 	if err, ok := obj.(error); ok {
@@ -169,6 +213,37 @@ func (ctx *Context) ServeContent(name string, r io.ReadSeeker, params ...interfa
 	http.ServeContent(ctx.Resp, ctx.Req.Request, name, modtime, r)
 }
 
+// etagMatches returns true if value of If-None-Match header matches given ETag
+// using weak comparison.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkETag sets caching headers of response and responds with 304
+// when client already has the version identified by given ETag.
+func checkETag(w http.ResponseWriter, r *http.Request, etag string, maxAge time.Duration) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(304)
+		return true
+	}
+	return false
+}
+
+// HandleETag sets weak ETag computed from given stamp and Cache-Control headers,
+// it returns true when request is already handled with 304 Not Modified.
+func (ctx *Context) HandleETag(stamp string, maxAge time.Duration) bool {
+	return checkETag(ctx.Resp, ctx.Req.Request, `W/"`+base.EncodeSha1(stamp)+`"`, maxAge)
+}
+
 // Contexter initializes a classic context for a request.
 func Contexter() macaron.Handler {
 	return func(c *macaron.Context, l i18n.Locale, cache cache.Cache, sess session.Store, f *session.Flash, x csrf.CSRF) {
@@ -190,7 +265,7 @@ func Contexter() macaron.Handler {
 		ctx.Data["PageStartTime"] = time.Now()
 
 		// Get user from session if logined.
-		ctx.User, ctx.IsBasicAuth = auth.SignedInUser(ctx.Req.Request, ctx.Session)
+		ctx.User, ctx.AccessToken, ctx.IsBasicAuth = auth.SignedInUser(ctx.Req.Request, ctx.Session)
 
 		if ctx.User != nil {
 			ctx.IsSigned = true
@@ -0,0 +1,111 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestEtagMatches(t *testing.T) {
+	cases := []struct {
+		header, etag string
+		expect       bool
+	}{
+		{"", `W/"abc"`, false},
+		{`W/"abc"`, `W/"abc"`, true},
+		{`"abc"`, `W/"abc"`, true},
+		{`"def", W/"abc"`, `W/"abc"`, true},
+		{`"def"`, `W/"abc"`, false},
+		{"*", `W/"abc"`, true},
+	}
+	for _, c := range cases {
+		if got := etagMatches(c.header, c.etag); got != c.expect {
+			t.Errorf("etagMatches(%q, %q) = %v, expect %v", c.header, c.etag, got, c.expect)
+		}
+	}
+}
+
+func TestCheckETag(t *testing.T) {
+	etag := `W/"1"`
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if checkETag(w, r, etag, 30*time.Second) {
+			return
+		}
+		w.Write([]byte("keys"))
+	}
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/user.keys", nil)
+		if len(ifNoneMatch) > 0 {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		return resp
+	}
+
+	resp := get("")
+	if resp.Code != 200 || resp.Body.String() != "keys" {
+		t.Fatalf("expect 200 with body but got %d %q", resp.Code, resp.Body.String())
+	}
+	if cc := resp.Header().Get("Cache-Control"); cc != "private, max-age=30" {
+		t.Errorf("unexpected Cache-Control: %q", cc)
+	}
+	received := resp.Header().Get("ETag")
+
+	if resp = get(received); resp.Code != 304 || resp.Body.Len() != 0 {
+		t.Fatalf("expect 304 without body but got %d %q", resp.Code, resp.Body.String())
+	}
+
+	etag = `W/"2"`
+	if resp = get(received); resp.Code != 200 || resp.Header().Get("ETag") != etag {
+		t.Fatalf("expect 200 with new ETag after change but got %d %q", resp.Code, resp.Header().Get("ETag"))
+	}
+}
+
+func TestCustomStatusTemplate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldCustomPath := setting.CustomPath
+	defer func() { setting.CustomPath = oldCustomPath }()
+	setting.CustomPath = tmpDir
+
+	if p := customStatusTemplate(404); p != "" {
+		t.Errorf("expect no custom template but got %q", p)
+	}
+
+	statusDir := filepath.Join(tmpDir, "templates", "status")
+	if err = os.MkdirAll(statusDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(statusDir, "404.tmpl"), []byte("{{.RequestPath}} not found"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Directory with name of template must not be treated as override.
+	if err = os.MkdirAll(filepath.Join(statusDir, "500.tmpl"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := customStatusTemplate(404); p != filepath.Join(statusDir, "404.tmpl") {
+		t.Errorf("expect custom 404 template but got %q", p)
+	}
+	for _, status := range []int{403, 500} {
+		if p := customStatusTemplate(status); p != "" {
+			t.Errorf("expect no custom template for %d but got %q", status, p)
+		}
+	}
+}
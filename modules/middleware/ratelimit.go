@@ -0,0 +1,91 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Unknwon/macaron"
+
+	"github.com/gogits/gogs/modules/ratelimit"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var (
+	keyRateLimitOnce sync.Once
+	// KeyRateLimitStore keeps counters of SSH key mutations,
+	// it is created from settings on first use unless set beforehand.
+	KeyRateLimitStore ratelimit.Store
+)
+
+func keyRateLimitStore() ratelimit.Store {
+	keyRateLimitOnce.Do(func() {
+		if KeyRateLimitStore == nil {
+			KeyRateLimitStore = ratelimit.NewMemoryStore(setting.KeyRateLimit.Burst, setting.KeyRateLimit.RefillInterval)
+		}
+	})
+	return KeyRateLimitStore
+}
+
+// keyRateLimitKeys returns counter keys of signed in user and access token used by request,
+// web and API requests of same user share the counter so limit cannot be bypassed.
+func keyRateLimitKeys(ctx *Context) []string {
+	keys := []string{fmt.Sprintf("user:%d", ctx.User.Id)}
+	if fields := strings.Fields(ctx.Req.Header.Get("Authorization")); len(fields) == 2 && fields[0] == "token" {
+		keys = append(keys, "token:"+fields[1])
+	}
+	return keys
+}
+
+// KeyRateLimit limits number of SSH key mutations by signed in user and access token.
+func KeyRateLimit() macaron.Handler {
+	return func(ctx *Context) {
+		if !setting.KeyRateLimit.Enabled || !ctx.IsSigned ||
+			ctx.Req.Method == "GET" || ctx.Req.Method == "HEAD" {
+			return
+		}
+
+		store := keyRateLimitStore()
+		keys := keyRateLimitKeys(ctx)
+		var wait time.Duration
+		for _, key := range keys {
+			if w := store.Wait(key); w > wait {
+				wait = w
+			}
+		}
+		if wait > 0 {
+			seconds := int(math.Ceil(wait.Seconds()))
+			if strings.HasPrefix(ctx.Req.URL.Path, "/api/") {
+				ctx.Resp.Header().Set("Retry-After", fmt.Sprint(seconds))
+				ctx.HandleAPI(429, "Too many SSH key operations, try again later")
+			} else {
+				ctx.Flash.Error(ctx.Tr("form.key_rate_limited", seconds))
+				ctx.Redirect(setting.AppSubUrl + ctx.Req.URL.Path)
+			}
+			return
+		}
+
+		for _, key := range keys {
+			store.Take(key, 1)
+		}
+	}
+}
+
+// KeyRateLimitFailure charges request of SSH key mutation that failed validation
+// additionally, so repeated invalid attempts are limited sooner than successful ones.
+func KeyRateLimitFailure(ctx *Context) {
+	if !setting.KeyRateLimit.Enabled || !ctx.IsSigned || setting.KeyRateLimit.FailureCost <= 1 {
+		return
+	}
+
+	store := keyRateLimitStore()
+	for _, key := range keyRateLimitKeys(ctx) {
+		store.Take(key, setting.KeyRateLimit.FailureCost-1)
+	}
+}
@@ -362,6 +362,24 @@ func RequireAdmin() macaron.Handler {
 	}
 }
 
+// RepoIssuesEnabled responds 404 when issues of repository are turned off.
+func RepoIssuesEnabled() macaron.Handler {
+	return func(ctx *Context) {
+		if !ctx.Repo.Repository.HasIssues {
+			ctx.Handle(404, "RepoIssuesEnabled", nil)
+		}
+	}
+}
+
+// ApiRepoIssuesEnabled is same as RepoIssuesEnabled for API routes.
+func ApiRepoIssuesEnabled() macaron.Handler {
+	return func(ctx *Context) {
+		if !ctx.Repo.Repository.HasIssues {
+			ctx.Error(404)
+		}
+	}
+}
+
 // GitHookService checks if repository Git hooks service has been enabled.
 func GitHookService() macaron.Handler {
 	return func(ctx *Context) {
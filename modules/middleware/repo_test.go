@@ -0,0 +1,44 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Unknwon/macaron"
+
+	"github.com/gogits/gogs/models"
+)
+
+func TestApiRepoIssuesEnabled(t *testing.T) {
+	repo := &models.Repository{HasIssues: true}
+
+	m := macaron.New()
+	m.Use(func(c *macaron.Context) {
+		ctx := &Context{Context: c}
+		ctx.Repo.Repository = repo
+		c.Map(ctx)
+	})
+	m.Get("/issues", ApiRepoIssuesEnabled(), func(ctx *Context) {
+		ctx.Status(200)
+	})
+
+	get := func() int {
+		req, _ := http.NewRequest("GET", "/issues", nil)
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	if code := get(); code != 200 {
+		t.Errorf("expect 200 with issues enabled but got %d", code)
+	}
+	repo.HasIssues = false
+	if code := get(); code != 404 {
+		t.Errorf("expect 404 with issues disabled but got %d", code)
+	}
+}
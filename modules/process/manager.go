@@ -8,7 +8,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"sync"
 	"time"
 
 	"github.com/gogits/gogs/modules/log"
@@ -30,16 +32,45 @@ type Process struct {
 	Description string
 	Start       time.Time
 	Cmd         *exec.Cmd
+
+	// done is set once Wait of the command has returned.
+	done bool
 }
 
 // List of existing processes.
 var (
 	curPid    int64 = 1
 	Processes []*Process
+
+	// numKilledStale is the total number of stale processes have been killed.
+	numKilledStale int
+
+	processLocker = sync.Mutex{}
 )
 
+// List returns a copy of list of existing processes,
+// which is safe to read while processes are added or removed.
+func List() []*Process {
+	processLocker.Lock()
+	defer processLocker.Unlock()
+
+	procs := make([]*Process, len(Processes))
+	copy(procs, Processes)
+	return procs
+}
+
+// NumKilledStale returns the total number of stale processes have been killed.
+func NumKilledStale() int {
+	processLocker.Lock()
+	defer processLocker.Unlock()
+	return numKilledStale
+}
+
 // Add adds a existing process and returns its PID.
 func Add(desc string, cmd *exec.Cmd) int64 {
+	processLocker.Lock()
+	defer processLocker.Unlock()
+
 	pid := curPid
 	Processes = append(Processes, &Process{
 		Pid:         pid,
@@ -53,6 +84,12 @@ func Add(desc string, cmd *exec.Cmd) int64 {
 
 // Exec starts executing a command in given path, it records its process and timeout.
 func ExecDir(timeout time.Duration, dir, desc, cmdName string, args ...string) (string, string, error) {
+	return ExecDirEnv(timeout, dir, desc, nil, cmdName, args...)
+}
+
+// ExecDirEnv is same as ExecDir but runs command with given extra environment variables
+// in "key=value" format.
+func ExecDirEnv(timeout time.Duration, dir, desc string, env []string, cmdName string, args ...string) (string, string, error) {
 	if timeout == -1 {
 		timeout = DEFAULT
 	}
@@ -62,6 +99,9 @@ func ExecDir(timeout time.Duration, dir, desc, cmdName string, args ...string) (
 
 	cmd := exec.Command(cmdName, args...)
 	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	cmd.Stdout = bufOut
 	cmd.Stderr = bufErr
 	if err := cmd.Start(); err != nil {
@@ -71,7 +111,9 @@ func ExecDir(timeout time.Duration, dir, desc, cmdName string, args ...string) (
 	pid := Add(desc, cmd)
 	done := make(chan error)
 	go func() {
-		done <- cmd.Wait()
+		err := cmd.Wait()
+		setDone(pid)
+		done <- err
 	}()
 
 	var err error
@@ -99,8 +141,24 @@ func Exec(desc, cmdName string, args ...string) (string, string, error) {
 	return ExecDir(-1, "", desc, cmdName, args...)
 }
 
+// setDone marks a process as finished.
+func setDone(pid int64) {
+	processLocker.Lock()
+	defer processLocker.Unlock()
+
+	for _, proc := range Processes {
+		if proc.Pid == pid {
+			proc.done = true
+			return
+		}
+	}
+}
+
 // Remove removes a process from list.
 func Remove(pid int64) {
+	processLocker.Lock()
+	defer processLocker.Unlock()
+
 	for i, proc := range Processes {
 		if proc.Pid == pid {
 			Processes = append(Processes[:i], Processes[i+1:]...)
@@ -111,9 +169,12 @@ func Remove(pid int64) {
 
 // Kill kills and removes a process from list.
 func Kill(pid int64) error {
+	processLocker.Lock()
+	defer processLocker.Unlock()
+
 	for i, proc := range Processes {
 		if proc.Pid == pid {
-			if proc.Cmd.Process != nil && proc.Cmd.ProcessState != nil && !proc.Cmd.ProcessState.Exited() {
+			if proc.Cmd.Process != nil && !proc.done {
 				if err := proc.Cmd.Process.Kill(); err != nil {
 					return fmt.Errorf("fail to kill process(%d/%s): %v", proc.Pid, proc.Description, err)
 				}
@@ -124,3 +185,39 @@ func Kill(pid int64) error {
 	}
 	return nil
 }
+
+// KillStale kills processes that have been running for longer than maxAge,
+// and removes processes that have finished from list.
+// It returns the number of processes have been killed.
+func KillStale(maxAge time.Duration) int {
+	processLocker.Lock()
+	defer processLocker.Unlock()
+
+	killed := 0
+	now := time.Now()
+	procs := make([]*Process, 0, len(Processes))
+	for _, proc := range Processes {
+		if proc.Cmd.Process == nil {
+			// Not started yet.
+			procs = append(procs, proc)
+			continue
+		} else if proc.done {
+			continue
+		} else if now.Sub(proc.Start) <= maxAge {
+			procs = append(procs, proc)
+			continue
+		}
+
+		if err := proc.Cmd.Process.Kill(); err != nil {
+			log.Error(4, "KillStale(%d:%s): %v", proc.Pid, proc.Description, err)
+			procs = append(procs, proc)
+			continue
+		}
+		log.Warn("Stale process killed(%d:%s): system PID %d, started at %s",
+			proc.Pid, proc.Description, proc.Cmd.Process.Pid, proc.Start)
+		killed++
+	}
+	Processes = procs
+	numKilledStale += killed
+	return killed
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func isListed(pid int64) bool {
+	for _, proc := range List() {
+		if proc.Pid == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func TestKillStale(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep command is not available")
+	}
+
+	stale := exec.Command("sleep", "60")
+	fresh := exec.Command("sleep", "60")
+	for _, cmd := range []*exec.Cmd{stale, fresh} {
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer cmd.Process.Kill()
+	}
+	stalePid := Add("stale", stale)
+	freshPid := Add("fresh", fresh)
+	defer Remove(freshPid)
+	for _, proc := range Processes {
+		if proc.Pid == stalePid {
+			proc.Start = time.Now().Add(-time.Hour)
+		}
+	}
+
+	before := NumKilledStale()
+	if n := KillStale(time.Minute); n != 1 {
+		t.Fatalf("expect 1 process to be killed but got %d", n)
+	} else if NumKilledStale() != before+1 {
+		t.Errorf("expect total of killed processes to be %d but got %d", before+1, NumKilledStale())
+	}
+
+	if isListed(stalePid) {
+		t.Error("expect killed process to be removed from list")
+	}
+	if err := stale.Wait(); err == nil {
+		t.Error("expect stale process to be killed")
+	}
+	if !isListed(freshPid) {
+		t.Error("expect fresh process to keep running")
+	}
+
+	// Finished process is removed without being counted as killed.
+	setDone(freshPid)
+	if n := KillStale(time.Minute); n != 0 {
+		t.Errorf("expect no process to be killed but got %d", n)
+	} else if isListed(freshPid) {
+		t.Error("expect finished process to be removed from list")
+	}
+}
+
+func TestExecDirEnvTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep command is not available")
+	}
+
+	start := time.Now()
+	if _, _, err := ExecDirEnv(100*time.Millisecond, "", "sleep", nil, "sleep", "60"); err != ErrExecTimeout {
+		t.Fatalf("expect ErrExecTimeout but got %v", err)
+	} else if time.Since(start) > 10*time.Second {
+		t.Error("expect timed out process to be killed")
+	}
+}
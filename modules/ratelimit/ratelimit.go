@@ -0,0 +1,84 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package ratelimit implements token bucket rate limiting.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps token buckets by key.
+type Store interface {
+	// Wait returns how long to wait until bucket of given key has at least
+	// one token, which is 0 when an operation is allowed now.
+	Wait(key string) time.Duration
+	// Take removes n tokens from bucket of given key, bucket can go below zero
+	// which delays next allowed operation accordingly.
+	Take(key string, n int)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore is a Store that keeps buckets in memory of current process.
+type MemoryStore struct {
+	lock     sync.Mutex
+	burst    float64
+	interval time.Duration // Time to regain one token.
+	buckets  map[string]*bucket
+	now      func() time.Time
+}
+
+// NewMemoryStore creates a new MemoryStore with buckets that hold at most burst
+// tokens and regain one token every interval.
+func NewMemoryStore(burst int, interval time.Duration) *MemoryStore {
+	return &MemoryStore{
+		burst:    float64(burst),
+		interval: interval,
+		buckets:  make(map[string]*bucket),
+		now:      time.Now,
+	}
+}
+
+// refill returns bucket of given key with tokens regained since last update,
+// full buckets are not stored so memory usage only grows with active keys.
+// Caller must hold the lock.
+func (s *MemoryStore) refill(key string, now time.Time) *bucket {
+	b, ok := s.buckets[key]
+	if !ok {
+		return &bucket{s.burst, now}
+	}
+
+	b.tokens += float64(now.Sub(b.updated)) / float64(s.interval)
+	b.updated = now
+	if b.tokens >= s.burst {
+		b.tokens = s.burst
+		delete(s.buckets, key)
+	}
+	return b
+}
+
+func (s *MemoryStore) Wait(key string) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b := s.refill(key, s.now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(s.interval))
+}
+
+func (s *MemoryStore) Take(key string, n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b := s.refill(key, s.now())
+	b.tokens -= float64(n)
+	s.buckets[key] = b
+}
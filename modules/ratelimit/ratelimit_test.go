@@ -0,0 +1,48 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := NewMemoryStore(2, 10*time.Second)
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait := s.Wait("user:1"); wait != 0 {
+			t.Fatalf("operation %d: expect no wait but got %v", i, wait)
+		}
+		s.Take("user:1", 1)
+	}
+	if wait := s.Wait("user:1"); wait != 10*time.Second {
+		t.Errorf("expect to wait 10s after burst but got %v", wait)
+	}
+	if wait := s.Wait("user:2"); wait != 0 {
+		t.Errorf("expect other key not to be limited but got %v", wait)
+	}
+
+	now = now.Add(10 * time.Second)
+	if wait := s.Wait("user:1"); wait != 0 {
+		t.Errorf("expect one token regained but got wait %v", wait)
+	}
+
+	// Going below zero delays next operation accordingly.
+	s.Take("user:1", 3)
+	if wait := s.Wait("user:1"); wait != 30*time.Second {
+		t.Errorf("expect to wait 30s after penalty but got %v", wait)
+	}
+
+	now = now.Add(time.Hour)
+	if wait := s.Wait("user:1"); wait != 0 {
+		t.Errorf("expect bucket to be full again but got wait %v", wait)
+	}
+	if len(s.buckets) != 0 {
+		t.Errorf("expect full buckets to be dropped but got %d", len(s.buckets))
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Unknwon/com"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// bleveDoc represents a commit or an issue stored in Bleve index.
+type bleveDoc struct {
+	Type    string
+	RepoId  string
+	Sha     string
+	IssueId int64
+	Index   int64
+	Title   string
+	Content string
+	Author  string
+}
+
+// BleveEngine is a SearchEngine that stores index on local disk with Bleve.
+type BleveEngine struct {
+	index bleve.Index
+}
+
+func newBleveMapping() mapping.IndexMapping {
+	// Identifiers are matched exactly and never as part of query text.
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+	keywordField.IncludeInAll = false
+	textField := bleve.NewTextFieldMapping()
+
+	docMapping := bleve.NewDocumentMapping()
+	docMapping.AddFieldMappingsAt("Type", keywordField)
+	docMapping.AddFieldMappingsAt("RepoId", keywordField)
+	docMapping.AddFieldMappingsAt("Sha", keywordField)
+	docMapping.AddFieldMappingsAt("Title", textField)
+	docMapping.AddFieldMappingsAt("Content", textField)
+	docMapping.AddFieldMappingsAt("Author", textField)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = docMapping
+	return m
+}
+
+// NewBleveEngine opens Bleve index at given path, the index is created
+// when it does not exist and then true is returned.
+func NewBleveEngine(indexPath string) (*BleveEngine, bool, error) {
+	idx, err := bleve.Open(indexPath)
+	if err == nil {
+		return &BleveEngine{idx}, false, nil
+	} else if err != bleve.ErrorIndexPathDoesNotExist {
+		return nil, false, fmt.Errorf("open index: %v", err)
+	}
+
+	if err = os.MkdirAll(path.Dir(indexPath), os.ModePerm); err != nil {
+		return nil, false, err
+	}
+	if idx, err = bleve.New(indexPath, newBleveMapping()); err != nil {
+		return nil, false, fmt.Errorf("create index: %v", err)
+	}
+	return &BleveEngine{idx}, true, nil
+}
+
+func (e *BleveEngine) IndexCommit(repoId int64, sha, message, author string) error {
+	return e.index.Index(fmt.Sprintf("commit-%d-%s", repoId, sha), &bleveDoc{
+		Type:    RESULT_COMMIT,
+		RepoId:  com.ToStr(repoId),
+		Sha:     sha,
+		Title:   strings.SplitN(strings.TrimSpace(message), "\n", 2)[0],
+		Content: message,
+		Author:  author,
+	})
+}
+
+func (e *BleveEngine) IndexIssue(issue *Issue) error {
+	return e.index.Index(fmt.Sprintf("issue-%d", issue.Id), &bleveDoc{
+		Type:    RESULT_ISSUE,
+		RepoId:  com.ToStr(issue.RepoId),
+		IssueId: issue.Id,
+		Index:   issue.Index,
+		Title:   issue.Title,
+		Content: issue.Content,
+	})
+}
+
+func (e *BleveEngine) SearchAll(keywords string, repoIds []int64, page, size int) ([]*SearchResult, error) {
+	if len(repoIds) == 0 || len(strings.TrimSpace(keywords)) == 0 {
+		return []*SearchResult{}, nil
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	repoQueries := make([]query.Query, len(repoIds))
+	for i, id := range repoIds {
+		repoQueries[i] = repoIdQuery(id)
+	}
+	q := bleve.NewConjunctionQuery(bleve.NewMatchQuery(keywords), bleve.NewDisjunctionQuery(repoQueries...))
+
+	req := bleve.NewSearchRequestOptions(q, size, (page-1)*size, false)
+	req.Fields = []string{"Type", "RepoId", "Sha", "IssueId", "Index", "Title", "Author"}
+	res, err := e.index.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, len(res.Hits))
+	for i, hit := range res.Hits {
+		results[i] = &SearchResult{
+			Type:    fieldString(hit.Fields, "Type"),
+			RepoId:  com.StrTo(fieldString(hit.Fields, "RepoId")).MustInt64(),
+			Sha:     fieldString(hit.Fields, "Sha"),
+			IssueId: fieldInt64(hit.Fields, "IssueId"),
+			Index:   fieldInt64(hit.Fields, "Index"),
+			Title:   fieldString(hit.Fields, "Title"),
+			Author:  fieldString(hit.Fields, "Author"),
+			Score:   hit.Score,
+		}
+	}
+	return results, nil
+}
+
+func (e *BleveEngine) DeleteRepository(repoId int64) error {
+	return e.deleteByQuery(repoIdQuery(repoId))
+}
+
+func (e *BleveEngine) DeleteIssues(repoId int64) error {
+	typeQuery := bleve.NewTermQuery(RESULT_ISSUE)
+	typeQuery.SetField("Type")
+	return e.deleteByQuery(bleve.NewConjunctionQuery(repoIdQuery(repoId), typeQuery))
+}
+
+// deleteByQuery removes all documents that match given query in batches.
+func (e *BleveEngine) deleteByQuery(q query.Query) error {
+	for {
+		res, err := e.index.Search(bleve.NewSearchRequestOptions(q, 100, 0, false))
+		if err != nil {
+			return err
+		} else if len(res.Hits) == 0 {
+			return nil
+		}
+
+		batch := e.index.NewBatch()
+		for _, hit := range res.Hits {
+			batch.Delete(hit.ID)
+		}
+		if err = e.index.Batch(batch); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes underlying index.
+func (e *BleveEngine) Close() error {
+	return e.index.Close()
+}
+
+func repoIdQuery(repoId int64) query.Query {
+	q := bleve.NewTermQuery(com.ToStr(repoId))
+	q.SetField("RepoId")
+	return q
+}
+
+func fieldString(fields map[string]interface{}, name string) string {
+	s, _ := fields[name].(string)
+	return s
+}
+
+// fieldInt64 returns value of numeric field, which is stored as float64 by Bleve.
+func fieldInt64(fields map[string]interface{}, name string) int64 {
+	f, _ := fields[name].(float64)
+	return int64(f)
+}
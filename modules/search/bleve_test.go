@@ -0,0 +1,95 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBleveEngine(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	indexPath := filepath.Join(tmpDir, "search.bleve")
+	e, isNew, err := NewBleveEngine(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if !isNew {
+		t.Error("expect index to be created")
+	}
+
+	if err = e.IndexCommit(1, "d8e8fca2dc0f896fd7cb4cb0031ba249", "Fix crash on startup\n\nDetails", "Joe"); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.IndexIssue(&Issue{Id: 5, RepoId: 2, Index: 3, Title: "Crash when pushing"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.IndexCommit(3, "a1b2c3", "Crash in other repository", "Jane"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := e.SearchAll("crash", []int64{1, 2}, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(results) != 2 {
+		t.Fatalf("expect 2 results but got %d", len(results))
+	}
+	for _, r := range results {
+		switch r.Type {
+		case RESULT_COMMIT:
+			if r.RepoId != 1 || r.Title != "Fix crash on startup" || r.Author != "Joe" {
+				t.Errorf("unexpected commit result: %+v", r)
+			}
+		case RESULT_ISSUE:
+			if r.RepoId != 2 || r.IssueId != 5 || r.Index != 3 {
+				t.Errorf("unexpected issue result: %+v", r)
+			}
+		default:
+			t.Errorf("unexpected result type: %q", r.Type)
+		}
+	}
+
+	if results, err = e.SearchAll("crash", nil, 1, 10); err != nil {
+		t.Fatal(err)
+	} else if len(results) != 0 {
+		t.Errorf("expect no results without repositories but got %d", len(results))
+	}
+
+	// Issues and whole repositories can be removed from index.
+	if err = e.IndexIssue(&Issue{Id: 6, RepoId: 1, Index: 1, Title: "Crash again"}); err != nil {
+		t.Fatal(err)
+	} else if err = e.DeleteIssues(1); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = e.SearchAll("crash", []int64{1, 2}, 1, 10); err != nil {
+		t.Fatal(err)
+	} else if len(results) != 2 {
+		t.Errorf("expect commit of repository 1 to be kept but got %d results", len(results))
+	}
+	if err = e.DeleteRepository(2); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = e.SearchAll("crash", []int64{1, 2}, 1, 10); err != nil {
+		t.Fatal(err)
+	} else if len(results) != 1 || results[0].Type != RESULT_COMMIT {
+		t.Errorf("expect only commit of repository 1 but got %d results", len(results))
+	}
+
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if e, isNew, err = NewBleveEngine(indexPath); err != nil {
+		t.Fatal(err)
+	} else if isNew {
+		t.Error("expect existing index to be opened")
+	}
+	e.Close()
+}
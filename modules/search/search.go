@@ -0,0 +1,69 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package search implements full-text search of commits and issues.
+package search
+
+import (
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// Issue represents content of an issue to be indexed.
+type Issue struct {
+	Id      int64
+	RepoId  int64
+	Index   int64 // Index in one repository.
+	Title   string
+	Content string
+}
+
+const (
+	RESULT_COMMIT = "commit"
+	RESULT_ISSUE  = "issue"
+)
+
+// SearchResult represents a commit or an issue that matches search query.
+type SearchResult struct {
+	Type    string
+	RepoId  int64
+	Sha     string // Only set for commit.
+	IssueId int64  // Only set for issue.
+	Index   int64  // Only set for issue.
+	Title   string // First line of commit message or title of issue.
+	Author  string
+	Score   float64
+}
+
+// SearchEngine represents a full-text search backend.
+type SearchEngine interface {
+	// IndexCommit adds or replaces commit of given repository in index.
+	IndexCommit(repoId int64, sha, message, author string) error
+	// IndexIssue adds or replaces issue in index.
+	IndexIssue(issue *Issue) error
+	// SearchAll returns a page of commits and issues of given repositories
+	// that match query, best matches come first.
+	SearchAll(query string, repoIds []int64, page, size int) ([]*SearchResult, error)
+	// DeleteRepository removes commits and issues of given repository from index.
+	DeleteRepository(repoId int64) error
+	// DeleteIssues removes issues of given repository from index.
+	DeleteIssues(repoId int64) error
+}
+
+// Engine is the search engine in use, it is nil when full-text search is not enabled.
+var Engine SearchEngine
+
+// NewContext opens search index when full-text search is enabled,
+// it returns true when index did not exist and has been created.
+func NewContext() (bool, error) {
+	if !setting.Search.EnableBleve {
+		return false, nil
+	}
+
+	e, isNew, err := NewBleveEngine(setting.Search.IndexPath)
+	if err != nil {
+		return false, err
+	}
+	Engine = e
+	return isNew, nil
+}
@@ -0,0 +1,106 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package serv
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// Repository does not exist, so request is rejected only after
+// key, its owner, repository owner and repository are all queried.
+const _BENCH_CMD = "git-upload-pack 'alice/missing.git'"
+
+// setupDatabase creates database with a user and its key in temporary directory.
+func setupDatabase(b *testing.B) (keyId int64, cleanup func()) {
+	tmpDir, err := ioutil.TempDir("", "gogs-serv")
+	if err != nil {
+		b.Fatal(err)
+	}
+	setting.LogRootPath = tmpDir
+	setting.RepoRootPath = filepath.Join(tmpDir, "repos")
+	models.SSHPath = filepath.Join(tmpDir, ".ssh")
+	if err = os.MkdirAll(models.SSHPath, 0700); err != nil {
+		b.Fatal(err)
+	}
+	models.DbCfg.Type = "sqlite3"
+	models.DbCfg.Path = filepath.Join(tmpDir, "gogs.db")
+	if err = models.NewEngine(); err != nil {
+		b.Fatal(err)
+	}
+
+	// First user is always active.
+	u := &models.User{Name: "alice", Email: "alice@example.com", Passwd: "password"}
+	if err = models.CreateUser(u); err != nil {
+		b.Fatal(err)
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+	key := &models.PublicKey{
+		OwnerId: u.Id,
+		Name:    "laptop",
+		Content: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+	}
+	if err = models.AddPublicKey(key, models.SecurityActorSystem); err != nil {
+		b.Fatal(err)
+	}
+
+	return key.Id, func() {
+		models.CloseEngine()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+// BenchmarkCheckDatabase checks request the way serv does without web process,
+// which connects to database before querying it.
+func BenchmarkCheckDatabase(b *testing.B) {
+	keyId, cleanup := setupDatabase(b)
+	defer cleanup()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := models.SetEngine(); err != nil {
+			b.Fatal(err)
+		}
+		if _, _, _, err := models.CheckSSHRequest(keyId, _BENCH_CMD); !models.IsErrSSHAccess(err) {
+			b.Fatalf("expect ErrSSHAccess but got %v", err)
+		}
+		models.CloseEngine()
+	}
+}
+
+// BenchmarkCheckSocket checks request through web process, which keeps
+// its connections to database open.
+func BenchmarkCheckSocket(b *testing.B) {
+	keyId, cleanup := setupDatabase(b)
+	defer cleanup()
+	socketPath, tokenFile, stop := startServer(b, models.CheckSSHRequest, models.AddKeyActivity, models.RecordKeyUsage)
+	defer stop()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := Check(socketPath, tokenFile, keyId, _BENCH_CMD); !models.IsErrSSHAccess(err) {
+			b.Fatalf("expect ErrSSHAccess but got %v", err)
+		}
+	}
+}
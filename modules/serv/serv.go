@@ -0,0 +1,365 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package serv implements internal endpoint that web process serves on a unix socket,
+// so serv command can check SSH requests and record key activity in one round trip each
+// instead of loading database engine and querying database itself.
+package serv
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
+)
+
+const (
+	_CHECK_PATH    = "/check"
+	_ACTIVITY_PATH = "/key_activity"
+	_USAGE_PATH    = "/key_usage"
+	_TOKEN_HEADER  = "X-Gogs-Internal-Token"
+
+	// REQUEST_TIMEOUT is how long serv waits for web process before
+	// it falls back to query database directly.
+	REQUEST_TIMEOUT = 5 * time.Second
+)
+
+// CheckFunc checks that key of given ID can be used to run given git command.
+type CheckFunc func(keyId int64, cmd string) (*models.PublicKey, *models.User, *models.SSHCommand, error)
+
+// ActivityFunc records given key activity.
+type ActivityFunc func(a *models.KeyActivity) error
+
+// UsageFunc records usage of key on repository by given operation.
+type UsageFunc func(keyId, repoId int64, action string) error
+
+// response is the body of reply to a check request.
+type response struct {
+	// Set when request is rejected, message is told to client and reason is only logged.
+	Message string `json:"message,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// Set when request cannot be checked.
+	Error string `json:"error,omitempty"`
+
+	KeyId    int64  `json:"key_id"`
+	KeyName  string `json:"key_name"`
+	KeyMode  string `json:"key_mode"`
+	UserId   int64  `json:"user_id"`
+	UserName string `json:"user_name"`
+	IsAdmin  bool   `json:"is_admin"`
+
+	// Empty when no command is given.
+	Verb          string `json:"verb,omitempty"`
+	RepoPath      string `json:"repo_path,omitempty"`
+	RepoOwnerId   int64  `json:"repo_owner_id,omitempty"`
+	RepoOwnerName string `json:"repo_owner_name,omitempty"`
+	RepoId        int64  `json:"repo_id,omitempty"`
+	RepoName      string `json:"repo_name,omitempty"`
+	Mode          int    `json:"mode,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp *response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+type handler struct {
+	token       []byte
+	check       CheckFunc
+	addActivity ActivityFunc
+	recordUsage UsageFunc
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var serve func(http.ResponseWriter, *http.Request)
+	switch r.URL.Path {
+	case _CHECK_PATH:
+		serve = h.serveCheck
+	case _ACTIVITY_PATH:
+		serve = h.serveActivity
+	case _USAGE_PATH:
+		serve = h.serveUsage
+	}
+	if serve == nil || r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(_TOKEN_HEADER)), h.token) != 1 {
+		writeJSON(w, 401, &response{Error: "invalid token"})
+		return
+	}
+	serve(w, r)
+}
+
+func (h *handler) serveCheck(w http.ResponseWriter, r *http.Request) {
+	keyId := com.StrTo(r.FormValue("key_id")).MustInt64()
+	key, user, sshCmd, err := h.check(keyId, r.FormValue("cmd"))
+	if err != nil {
+		if models.IsErrSSHAccess(err) {
+			e := err.(models.ErrSSHAccess)
+			writeJSON(w, 403, &response{Message: e.Message, Reason: e.Reason})
+			return
+		}
+		log.Error(4, "Internal: check key(%d): %v", keyId, err)
+		writeJSON(w, 500, &response{Error: err.Error()})
+		return
+	}
+
+	resp := &response{
+		KeyId:    key.Id,
+		KeyName:  key.Name,
+		KeyMode:  string(key.Mode),
+		UserId:   user.Id,
+		UserName: user.Name,
+		IsAdmin:  user.IsAdmin,
+	}
+	if sshCmd != nil {
+		resp.Verb = sshCmd.Verb
+		resp.RepoPath = sshCmd.RepoPath
+		resp.RepoOwnerId = sshCmd.RepoOwner.Id
+		resp.RepoOwnerName = sshCmd.RepoOwner.Name
+		resp.RepoId = sshCmd.Repo.Id
+		resp.RepoName = sshCmd.Repo.Name
+		resp.Mode = int(sshCmd.Mode)
+	}
+	writeJSON(w, 200, resp)
+}
+
+func (h *handler) serveActivity(w http.ResponseWriter, r *http.Request) {
+	a := &models.KeyActivity{
+		KeyId:      com.StrTo(r.FormValue("key_id")).MustInt64(),
+		KeyName:    r.FormValue("key_name"),
+		OwnerId:    com.StrTo(r.FormValue("owner_id")).MustInt64(),
+		OwnerName:  r.FormValue("owner_name"),
+		RepoId:     com.StrTo(r.FormValue("repo_id")).MustInt64(),
+		RepoName:   r.FormValue("repo_name"),
+		Operation:  r.FormValue("operation"),
+		RemoteAddr: r.FormValue("remote_addr"),
+	}
+	if err := h.addActivity(a); err != nil {
+		log.Error(4, "Internal: add activity of key(%d): %v", a.KeyId, err)
+		writeJSON(w, 500, &response{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (h *handler) serveUsage(w http.ResponseWriter, r *http.Request) {
+	keyId := com.StrTo(r.FormValue("key_id")).MustInt64()
+	repoId := com.StrTo(r.FormValue("repo_id")).MustInt64()
+	if err := h.recordUsage(keyId, repoId, r.FormValue("action")); err != nil {
+		log.Error(4, "Internal: record usage of key(%d): %v", keyId, err)
+		writeJSON(w, 500, &response{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// newHandler returns handler of requests authenticated by given token.
+func newHandler(token string, check CheckFunc, addActivity ActivityFunc, recordUsage UsageFunc) http.Handler {
+	return &handler{[]byte(token), check, addActivity, recordUsage}
+}
+
+// loadToken reads shared secret from given file, a new one is generated
+// when file does not exist.
+func loadToken(tokenFile string) (string, error) {
+	data, err := ioutil.ReadFile(tokenFile)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	token := base.GetRandomString(40)
+	if err = os.MkdirAll(filepath.Dir(tokenFile), os.ModePerm); err != nil {
+		return "", err
+	} else if err = ioutil.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Listen starts serving requests of serv command on given unix socket in background.
+// Serv command still works without the socket, so failures are only logged.
+func Listen(socketPath, tokenFile string) {
+	token, err := loadToken(tokenFile)
+	if err != nil {
+		log.Error(4, "Internal: Fail to load token: %v", err)
+		return
+	}
+
+	// Socket file is left behind when web process is killed.
+	if err = os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		log.Error(4, "Internal: Fail to remove old socket: %v", err)
+		return
+	}
+	os.MkdirAll(filepath.Dir(socketPath), os.ModePerm)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Error(4, "Internal: Fail to listen on %s: %v", socketPath, err)
+		return
+	}
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		log.Error(4, "Internal: Fail to change mode of socket: %v", err)
+		listener.Close()
+		return
+	}
+	log.Info("Internal: Listen on %s", socketPath)
+
+	go func() {
+		if err := http.Serve(listener, newHandler(token, models.CheckSSHRequest, models.AddKeyActivity, models.RecordKeyUsage)); err != nil {
+			log.Error(4, "Internal: Fail to serve: %v", err)
+		}
+	}()
+}
+
+// post sends form to given path of web process listening on given unix socket,
+// and decodes JSON reply into resp when there is one. It returns status code of reply.
+func post(socketPath, tokenFile, path string, form url.Values, resp *response) (int, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return 0, fmt.Errorf("read token: %v", err)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.DialTimeout("unix", socketPath, REQUEST_TIMEOUT)
+			},
+			// Serv makes only a few requests before it exits.
+			DisableKeepAlives: true,
+		},
+		Timeout: REQUEST_TIMEOUT,
+	}
+	req, err := http.NewRequest("POST", "http://gogs"+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(_TOKEN_HEADER, strings.TrimSpace(string(token)))
+
+	r, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == 204 {
+		return r.StatusCode, nil
+	} else if err = json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return r.StatusCode, fmt.Errorf("decode response(%d): %v", r.StatusCode, err)
+	}
+	return r.StatusCode, nil
+}
+
+// Check asks web process listening on given unix socket to check that key of
+// given ID can be used to run given git command. Rejected requests return
+// models.ErrSSHAccess, any other error means web process cannot answer and
+// caller should check the request against database itself.
+func Check(socketPath, tokenFile string, keyId int64, cmd string) (*models.PublicKey, *models.User, *models.SSHCommand, error) {
+	resp := new(response)
+	status, err := post(socketPath, tokenFile, _CHECK_PATH, url.Values{
+		"key_id": {com.ToStr(keyId)},
+		"cmd":    {cmd},
+	}, resp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	switch status {
+	case 200:
+	case 403:
+		return nil, nil, nil, models.ErrSSHAccess{Message: resp.Message, Reason: resp.Reason}
+	default:
+		return nil, nil, nil, fmt.Errorf("status %d: %s", status, resp.Error)
+	}
+
+	key := &models.PublicKey{
+		Id:      resp.KeyId,
+		OwnerId: resp.UserId,
+		Name:    resp.KeyName,
+		Mode:    models.KeyAccessMode(resp.KeyMode),
+	}
+	user := &models.User{
+		Id:        resp.UserId,
+		Name:      resp.UserName,
+		LowerName: strings.ToLower(resp.UserName),
+		IsAdmin:   resp.IsAdmin,
+	}
+	if len(resp.Verb) == 0 {
+		return key, user, nil, nil
+	}
+
+	repoOwner := &models.User{
+		Id:        resp.RepoOwnerId,
+		Name:      resp.RepoOwnerName,
+		LowerName: strings.ToLower(resp.RepoOwnerName),
+	}
+	return key, user, &models.SSHCommand{
+		Verb:      resp.Verb,
+		RepoPath:  resp.RepoPath,
+		RepoOwner: repoOwner,
+		Repo: &models.Repository{
+			Id:        resp.RepoId,
+			OwnerId:   repoOwner.Id,
+			Owner:     repoOwner,
+			Name:      resp.RepoName,
+			LowerName: strings.ToLower(resp.RepoName),
+		},
+		Mode: models.AccessMode(resp.Mode),
+	}, nil
+}
+
+// record sends form to given path of web process to record something,
+// any error means nothing has been recorded.
+func record(socketPath, tokenFile, path string, form url.Values) error {
+	resp := new(response)
+	status, err := post(socketPath, tokenFile, path, form, resp)
+	if err != nil {
+		return err
+	} else if status != 204 {
+		return fmt.Errorf("status %d: %s", status, resp.Error)
+	}
+	return nil
+}
+
+// AddKeyActivity asks web process listening on given unix socket to record given
+// key activity. Any error means activity has not been recorded and caller should
+// write it to database itself.
+func AddKeyActivity(socketPath, tokenFile string, a *models.KeyActivity) error {
+	return record(socketPath, tokenFile, _ACTIVITY_PATH, url.Values{
+		"key_id":      {com.ToStr(a.KeyId)},
+		"key_name":    {a.KeyName},
+		"owner_id":    {com.ToStr(a.OwnerId)},
+		"owner_name":  {a.OwnerName},
+		"repo_id":     {com.ToStr(a.RepoId)},
+		"repo_name":   {a.RepoName},
+		"operation":   {a.Operation},
+		"remote_addr": {a.RemoteAddr},
+	})
+}
+
+// RecordKeyUsage asks web process listening on given unix socket to record usage
+// of key on repository by given operation. Any error means usage has not been
+// recorded and caller should write it to database itself.
+func RecordKeyUsage(socketPath, tokenFile string, keyId, repoId int64, action string) error {
+	return record(socketPath, tokenFile, _USAGE_PATH, url.Values{
+		"key_id":  {com.ToStr(keyId)},
+		"repo_id": {com.ToStr(repoId)},
+		"action":  {action},
+	})
+}
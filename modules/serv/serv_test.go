@@ -0,0 +1,208 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package serv
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogits/gogs/models"
+)
+
+// startServer serves requests with given functions on a socket in temporary directory.
+func startServer(tb testing.TB, check CheckFunc, addActivity ActivityFunc, recordUsage UsageFunc) (socketPath, tokenFile string, cleanup func()) {
+	tmpDir, err := ioutil.TempDir("", "gogs-serv")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	socketPath = filepath.Join(tmpDir, "internal.sock")
+	tokenFile = filepath.Join(tmpDir, "internal.token")
+
+	token, err := loadToken(tokenFile)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go http.Serve(listener, newHandler(token, check, addActivity, recordUsage))
+
+	return socketPath, tokenFile, func() {
+		listener.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	owner := &models.User{Id: 2, Name: "Org"}
+	socketPath, tokenFile, cleanup := startServer(t, func(keyId int64, cmd string) (*models.PublicKey, *models.User, *models.SSHCommand, error) {
+		key := &models.PublicKey{Id: keyId, OwnerId: 1, Name: "laptop", Mode: models.KEY_ACCESS_MODE_READ}
+		user := &models.User{Id: 1, Name: "Alice", IsAdmin: true}
+		switch cmd {
+		case "":
+			return key, user, nil, nil
+		case "git-upload-pack 'org/repo.git'":
+			return key, user, &models.SSHCommand{
+				Verb:      "git-upload-pack",
+				RepoPath:  "org/repo.git",
+				RepoOwner: owner,
+				Repo:      &models.Repository{Id: 3, OwnerId: owner.Id, Name: "Repo"},
+				Mode:      models.ACCESS_MODE_READ,
+			}, nil
+		case "git-receive-pack 'org/repo.git'":
+			return nil, nil, nil, models.ErrSSHAccess{Message: "Key 'laptop' is read-only and cannot be used to push", Reason: "read-only key"}
+		}
+		return nil, nil, nil, errors.New("database is down")
+	}, nil, nil)
+	defer cleanup()
+
+	key, user, sshCmd, err := Check(socketPath, tokenFile, 5, "git-upload-pack 'org/repo.git'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Id != 5 || key.Name != "laptop" || !key.IsReadOnly() {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if user.Id != 1 || user.Name != "Alice" || user.LowerName != "alice" || !user.IsAdmin {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if sshCmd == nil {
+		t.Fatal("expect command but got nil")
+	} else if sshCmd.Verb != "git-upload-pack" || sshCmd.RepoPath != "org/repo.git" || sshCmd.Mode != models.ACCESS_MODE_READ {
+		t.Errorf("unexpected command: %+v", sshCmd)
+	} else if sshCmd.RepoOwner.Id != owner.Id || sshCmd.Repo.Id != 3 || sshCmd.Repo.Owner != sshCmd.RepoOwner ||
+		sshCmd.Repo.LowerName != "repo" {
+		t.Errorf("unexpected repository %+v of owner %+v", sshCmd.Repo, sshCmd.RepoOwner)
+	}
+
+	if _, user, sshCmd, err = Check(socketPath, tokenFile, 5, ""); err != nil {
+		t.Fatal(err)
+	} else if user.Id != 1 || sshCmd != nil {
+		t.Errorf("expect only user without command but got %+v, %+v", user, sshCmd)
+	}
+
+	// Rejections are final, serv must not retry against database.
+	_, _, _, err = Check(socketPath, tokenFile, 5, "git-receive-pack 'org/repo.git'")
+	if !models.IsErrSSHAccess(err) {
+		t.Fatalf("expect ErrSSHAccess but got %v", err)
+	} else if e := err.(models.ErrSSHAccess); e.Message != "Key 'laptop' is read-only and cannot be used to push" || e.Reason != "read-only key" {
+		t.Errorf("unexpected rejection: %+v", e)
+	}
+
+	if _, _, _, err = Check(socketPath, tokenFile, 5, "git-upload-pack 'org/broken.git'"); err == nil || models.IsErrSSHAccess(err) {
+		t.Errorf("expect internal error but got %v", err)
+	}
+}
+
+func TestCheckUnavailable(t *testing.T) {
+	socketPath, tokenFile, cleanup := startServer(t, func(keyId int64, cmd string) (*models.PublicKey, *models.User, *models.SSHCommand, error) {
+		return &models.PublicKey{Id: keyId}, &models.User{Id: 1}, nil, nil
+	}, nil, nil)
+	defer cleanup()
+
+	// Wrong token must not be accepted, and callers fall back to database.
+	wrongToken := filepath.Join(filepath.Dir(tokenFile), "wrong.token")
+	if err := ioutil.WriteFile(wrongToken, []byte("wrong"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := Check(socketPath, wrongToken, 1, ""); err == nil || models.IsErrSSHAccess(err) {
+		t.Errorf("expect wrong token to be rejected but got %v", err)
+	}
+
+	if _, _, _, err := Check(socketPath, tokenFile+".missing", 1, ""); err == nil || models.IsErrSSHAccess(err) {
+		t.Errorf("expect missing token file to fail but got %v", err)
+	}
+	if _, _, _, err := Check(socketPath+".missing", tokenFile, 1, ""); err == nil || models.IsErrSSHAccess(err) {
+		t.Errorf("expect missing socket to fail but got %v", err)
+	}
+}
+
+func TestRecordKeyActivity(t *testing.T) {
+	var activities []*models.KeyActivity
+	var usages []string
+	fail := false
+	socketPath, tokenFile, cleanup := startServer(t, nil, func(a *models.KeyActivity) error {
+		if fail {
+			return errors.New("database is down")
+		}
+		activities = append(activities, a)
+		return nil
+	}, func(keyId, repoId int64, action string) error {
+		if fail {
+			return errors.New("database is down")
+		}
+		usages = append(usages, fmt.Sprintf("%d:%d:%s", keyId, repoId, action))
+		return nil
+	})
+	defer cleanup()
+
+	activity := &models.KeyActivity{
+		KeyId:      5,
+		KeyName:    "laptop",
+		OwnerId:    1,
+		OwnerName:  "alice",
+		RepoId:     3,
+		RepoName:   "org/repo",
+		Operation:  "clone",
+		RemoteAddr: "10.0.0.1",
+	}
+	if err := AddKeyActivity(socketPath, tokenFile, activity); err != nil {
+		t.Fatal(err)
+	} else if len(activities) != 1 || *activities[0] != *activity {
+		t.Errorf("expect activity %+v to be recorded but got %v", activity, activities)
+	}
+	if err := RecordKeyUsage(socketPath, tokenFile, 5, 3, "clone"); err != nil {
+		t.Fatal(err)
+	} else if len(usages) != 1 || usages[0] != "5:3:clone" {
+		t.Errorf("expect usage to be recorded but got %v", usages)
+	}
+
+	// Failures are reported, so serv writes database itself.
+	fail = true
+	if err := AddKeyActivity(socketPath, tokenFile, activity); err == nil {
+		t.Error("expect error when activity cannot be recorded")
+	}
+	if err := RecordKeyUsage(socketPath, tokenFile, 5, 3, "clone"); err == nil {
+		t.Error("expect error when usage cannot be recorded")
+	}
+	if err := RecordKeyUsage(socketPath+".missing", tokenFile, 5, 3, "clone"); err == nil {
+		t.Error("expect missing socket to fail")
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-serv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tokenFile := filepath.Join(tmpDir, "data", "internal.token")
+	token, err := loadToken(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(token) != 40 {
+		t.Errorf("expect token of 40 characters but got %q", token)
+	}
+	if fi, err := os.Stat(tokenFile); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("expect token file to be private but got mode %v", fi.Mode())
+	}
+
+	// Token is kept across restarts of web process.
+	if again, err := loadToken(tokenFile); err != nil {
+		t.Fatal(err)
+	} else if again != token {
+		t.Errorf("expect token %q to be kept but got %q", token, again)
+	}
+}
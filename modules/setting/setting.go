@@ -5,7 +5,9 @@
 package setting
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
@@ -24,6 +26,12 @@ import (
 	// "github.com/gogits/gogs/modules/ssh"
 )
 
+// BrandingLink represents a custom link in page footer.
+type BrandingLink struct {
+	Label string `json:"label"`
+	Url   string `json:"url"`
+}
+
 type Scheme string
 
 const (
@@ -47,17 +55,38 @@ var (
 	AppSubUrl string
 
 	// Server settings.
-	Protocol           Scheme
-	Domain             string
-	HttpAddr, HttpPort string
-	DisableSSH         bool
-	SSHPort            int
-	OfflineMode        bool
-	DisableRouterLog   bool
-	CertFile, KeyFile  string
-	StaticRootPath     string
-	EnableGzip         bool
-	LandingPageUrl     LandingPage
+	Protocol                Scheme
+	Domain                  string
+	HttpAddr, HttpPort      string
+	DisableSSH              bool
+	SSHPort                 int
+	SSHRootPath             string
+	StartSSHServer          bool
+	SSHListenHost           string
+	SSHListenPort           int
+	SSHServerHostKey        string
+	SSHTrustedUserCAKeys    []string
+	SSHKeepAuthorizedKeys   bool
+	SSHKeyActivityInterval  time.Duration
+	SSHKeyActivityRetention int
+	MaxSSHKeysPerUser       int
+	RequireSSHKeyVerify     bool
+	RequireSSHKeyApproval   bool
+	UnverifiedSSHKeyExpire  int
+	SSHKeyPolicyGraceDays   int
+	SSHKeysCacheMaxAge      time.Duration
+	SSHKeyChangeHook        string
+	SSHKeyChangeHookTimeout time.Duration
+	DisableSSHUploadArchive bool
+	InternalSocket          string
+	InternalTokenFile       string
+	OfflineMode             bool
+	DisableRouterLog        bool
+	CertFile, KeyFile       string
+	StaticRootPath          string
+	EnableGzip              bool
+	LandingPageUrl          LandingPage
+	ProcessTimeout          time.Duration
 
 	// Security settings.
 	InstallLock          bool
@@ -66,6 +95,11 @@ var (
 	CookieUserName       string
 	CookieRememberName   string
 	ReverseProxyAuthUser string
+	AccountExportPath    string
+	AccountExportExpire  int
+
+	// Admin settings.
+	DiskUsageWarnThreshold int64 // In bytes, 0 means never warn.
 
 	// Database settings.
 	UseSQLite3    bool
@@ -77,11 +111,58 @@ var (
 		TaskInterval   int
 		DeliverTimeout int
 		SkipTLSVerify  bool
+		MaxAttempts    int
+	}
+
+	// Notification settings.
+	Notification struct {
+		NotifyOnStar bool
+	}
+
+	// Outbound HTTP proxy, nil means to use proxy from environment variables.
+	ProxyURL *url.URL
+
+	// Search settings.
+	Search struct {
+		EnableBleve bool
+		IndexPath   string
+	}
+
+	// Rate limit of SSH key mutations per user and access token.
+	KeyRateLimit struct {
+		Enabled        bool
+		Burst          int
+		RefillInterval time.Duration
+		FailureCost    int
+	}
+
+	// Replication of authorized_keys file to additional hosts.
+	SSHReplication struct {
+		Enabled        bool
+		Targets        []string
+		IdentityFile   string
+		KnownHostsFile string
+		MaxRetries     int
+		RetryInterval  time.Duration
+		Timeout        time.Duration
 	}
 
 	// Repository settings.
-	RepoRootPath string
-	ScriptType   string
+	RepoRootPath       string
+	ScriptType         string
+	SiteHookRoot       string
+	RepoTrashPath      string
+	DefaultBranch      string   // Branch of new repositories when owner has no preference.
+	IssueCloseKeywords []string // Keywords in commit messages that close issues.
+
+	RepoHealthCheckConcurrency int // Number of repositories checked at the same time.
+
+	// Branding settings, paths are absolute and empty when not customized.
+	Branding struct {
+		LogoPath    string
+		FaviconPath string
+		FooterLinks []BrandingLink
+	}
 
 	// Picture settings.
 	PictureService   string
@@ -224,6 +305,46 @@ func NewConfigContext() {
 	HttpPort = sec.Key("HTTP_PORT").MustString("3000")
 	DisableSSH = sec.Key("DISABLE_SSH").MustBool()
 	SSHPort = sec.Key("SSH_PORT").MustInt(22)
+	SSHRootPath = sec.Key("SSH_AUTHORIZED_KEYS_BACKUP").String()
+	if len(SSHRootPath) > 0 {
+		if !filepath.IsAbs(SSHRootPath) {
+			log.Fatal(4, "SSH_AUTHORIZED_KEYS_BACKUP must be an absolute path: %s", SSHRootPath)
+		}
+		// Path of authorized_keys file itself is accepted as well.
+		SSHRootPath = filepath.Clean(SSHRootPath)
+		if filepath.Base(SSHRootPath) == "authorized_keys" {
+			SSHRootPath = filepath.Dir(SSHRootPath)
+		}
+	}
+	StartSSHServer = sec.Key("START_SSH_SERVER").MustBool()
+	SSHListenHost = sec.Key("SSH_LISTEN_HOST").MustString("0.0.0.0")
+	SSHListenPort = sec.Key("SSH_LISTEN_PORT").MustInt(SSHPort)
+	SSHServerHostKey = sec.Key("SSH_SERVER_HOST_KEY").MustString("data/ssh/gogs.rsa")
+	if !filepath.IsAbs(SSHServerHostKey) {
+		SSHServerHostKey = path.Join(workDir, SSHServerHostKey)
+	}
+	SSHTrustedUserCAKeys = sec.Key("SSH_TRUSTED_USER_CA_KEYS").Strings(",")
+	SSHKeepAuthorizedKeys = sec.Key("SSH_KEEP_AUTHORIZED_KEYS").MustBool(!StartSSHServer)
+	SSHKeyActivityInterval = time.Duration(sec.Key("SSH_KEY_ACTIVITY_INTERVAL").MustInt(60)) * time.Minute
+	SSHKeyActivityRetention = sec.Key("SSH_KEY_ACTIVITY_RETENTION").MustInt(90)
+	MaxSSHKeysPerUser = sec.Key("MAX_SSH_KEYS_PER_USER").MustInt(50)
+	RequireSSHKeyVerify = sec.Key("REQUIRE_SSH_KEY_VERIFICATION").MustBool()
+	RequireSSHKeyApproval = sec.Key("REQUIRE_SSH_KEY_APPROVAL").MustBool()
+	UnverifiedSSHKeyExpire = sec.Key("UNVERIFIED_SSH_KEY_EXPIRE_DAYS").MustInt(0)
+	SSHKeyPolicyGraceDays = sec.Key("SSH_KEY_POLICY_GRACE_DAYS").MustInt(0)
+	SSHKeysCacheMaxAge = time.Duration(sec.Key("SSH_KEYS_CACHE_MAX_AGE").MustInt(30)) * time.Second
+	SSHKeyChangeHook = sec.Key("SSH_KEY_CHANGE_HOOK").String()
+	SSHKeyChangeHookTimeout = time.Duration(sec.Key("SSH_KEY_CHANGE_HOOK_TIMEOUT").MustInt(30)) * time.Second
+	DisableSSHUploadArchive = sec.Key("DISABLE_SSH_UPLOAD_ARCHIVE").MustBool()
+	// Default comes from conf/app.ini, so an empty value in custom config disables the socket.
+	InternalSocket = sec.Key("INTERNAL_SOCKET").String()
+	if len(InternalSocket) > 0 && !filepath.IsAbs(InternalSocket) {
+		InternalSocket = path.Join(workDir, InternalSocket)
+	}
+	InternalTokenFile = sec.Key("INTERNAL_TOKEN_FILE").MustString("data/internal.token")
+	if !filepath.IsAbs(InternalTokenFile) {
+		InternalTokenFile = path.Join(workDir, InternalTokenFile)
+	}
 	OfflineMode = sec.Key("OFFLINE_MODE").MustBool()
 	DisableRouterLog = sec.Key("DISABLE_ROUTER_LOG").MustBool()
 	StaticRootPath = sec.Key("STATIC_ROOT_PATH").MustString(workDir)
@@ -235,6 +356,22 @@ func NewConfigContext() {
 	default:
 		LandingPageUrl = LANDING_PAGE_HOME
 	}
+	ProcessTimeout = time.Duration(sec.Key("PROCESS_TIMEOUT").MustInt()) * time.Second
+
+	// Loaded here instead of by a service, so admin commands rewriting
+	// authorized_keys file also replicate it.
+	sec = Cfg.Section("ssh_replication")
+	SSHReplication.Enabled = sec.Key("ENABLED").MustBool()
+	for _, target := range sec.Key("TARGETS").Strings(",") {
+		if len(target) > 0 {
+			SSHReplication.Targets = append(SSHReplication.Targets, target)
+		}
+	}
+	SSHReplication.IdentityFile = sec.Key("IDENTITY_FILE").String()
+	SSHReplication.KnownHostsFile = sec.Key("KNOWN_HOSTS_FILE").String()
+	SSHReplication.MaxRetries = sec.Key("MAX_RETRIES").MustInt(3)
+	SSHReplication.RetryInterval = time.Duration(sec.Key("RETRY_INTERVAL").MustInt(10)) * time.Second
+	SSHReplication.Timeout = time.Duration(sec.Key("TIMEOUT").MustInt(60)) * time.Second
 
 	sec = Cfg.Section("security")
 	InstallLock = sec.Key("INSTALL_LOCK").MustBool()
@@ -243,6 +380,13 @@ func NewConfigContext() {
 	CookieUserName = sec.Key("COOKIE_USERNAME").String()
 	CookieRememberName = sec.Key("COOKIE_REMEMBER_NAME").String()
 	ReverseProxyAuthUser = sec.Key("REVERSE_PROXY_AUTHENTICATION_USER").MustString("X-WEBAUTH-USER")
+	AccountExportPath = sec.Key("ACCOUNT_EXPORT_PATH").MustString("data/exports")
+	if !filepath.IsAbs(AccountExportPath) {
+		AccountExportPath = path.Join(workDir, AccountExportPath)
+	}
+	AccountExportExpire = sec.Key("ACCOUNT_EXPORT_EXPIRE_DAYS").MustInt(7)
+
+	DiskUsageWarnThreshold = Cfg.Section("admin").Key("DISK_USAGE_WARN_THRESHOLD").MustInt64() * 1024 * 1024
 
 	sec = Cfg.Section("attachment")
 	AttachmentPath = sec.Key("PATH").MustString("data/attachments")
@@ -254,6 +398,19 @@ func NewConfigContext() {
 	AttachmentMaxFiles = sec.Key("MAX_FILES").MustInt(10)
 	AttachmentEnabled = sec.Key("ENABLE").MustBool(true)
 
+	sec = Cfg.Section("search")
+	Search.EnableBleve = sec.Key("ENABLE_BLEVE").MustBool()
+	Search.IndexPath = sec.Key("INDEX_PATH").MustString("data/search.bleve")
+	if !filepath.IsAbs(Search.IndexPath) {
+		Search.IndexPath = path.Join(workDir, Search.IndexPath)
+	}
+
+	if proxy := Cfg.Section("proxy").Key("URL").String(); len(proxy) > 0 {
+		if ProxyURL, err = url.Parse(proxy); err != nil {
+			log.Fatal(4, "Invalid proxy URL(%s): %v", proxy, err)
+		}
+	}
+
 	TimeFormat = map[string]string{
 		"ANSIC":       time.ANSIC,
 		"UnixDate":    time.UnixDate,
@@ -295,6 +452,17 @@ func NewConfigContext() {
 		RepoRootPath = filepath.Clean(RepoRootPath)
 	}
 	ScriptType = sec.Key("SCRIPT_TYPE").MustString("bash")
+	SiteHookRoot = sec.Key("SITE_HOOK_ROOT").MustString(path.Join(CustomPath, "site_hooks"))
+	if !filepath.IsAbs(SiteHookRoot) {
+		SiteHookRoot = filepath.Join(workDir, SiteHookRoot)
+	}
+	RepoTrashPath = sec.Key("TRASH_PATH").String()
+	if len(RepoTrashPath) > 0 && !filepath.IsAbs(RepoTrashPath) {
+		RepoTrashPath = filepath.Join(workDir, RepoTrashPath)
+	}
+	DefaultBranch = sec.Key("DEFAULT_BRANCH").MustString("master")
+	IssueCloseKeywords = sec.Key("CLOSE_ISSUE_KEYWORDS").Strings(",")
+	RepoHealthCheckConcurrency = sec.Key("FSCK_CONCURRENCY").MustInt(2)
 
 	sec = Cfg.Section("picture")
 	PictureService = sec.Key("SERVICE").In("server", []string{"server"})
@@ -310,6 +478,20 @@ func NewConfigContext() {
 	}
 	DisableGravatar = sec.Key("DISABLE_GRAVATAR").MustBool()
 
+	sec = Cfg.Section("branding")
+	// Paths are relative to custom directory and must not go outside of it.
+	if logoPath := sec.Key("LOGO_PATH").String(); len(logoPath) > 0 {
+		Branding.LogoPath = path.Join(CustomPath, path.Clean("/"+logoPath))
+	}
+	if faviconPath := sec.Key("FAVICON_PATH").String(); len(faviconPath) > 0 {
+		Branding.FaviconPath = path.Join(CustomPath, path.Clean("/"+faviconPath))
+	}
+	if links := sec.Key("FOOTER_LINKS").String(); len(links) > 0 {
+		if err = json.Unmarshal([]byte(links), &Branding.FooterLinks); err != nil {
+			log.Fatal(4, "Fail to parse FOOTER_LINKS of branding settings: %v", err)
+		}
+	}
+
 	if err = Cfg.Section("git").MapTo(&Git); err != nil {
 		log.Fatal(4, "Fail to map Git settings: %v", err)
 	}
@@ -327,6 +509,7 @@ var Service struct {
 	RequireSignInView              bool
 	EnableCacheAvatar              bool
 	EnableNotifyMail               bool
+	EnableSSHKeyNotifyMail         bool
 	EnableReverseProxyAuth         bool
 	EnableReverseProxyAutoRegister bool
 	ActiveCodeLives                int
@@ -520,11 +703,50 @@ func newNotifyMailService() {
 	log.Info("Notify Mail Service Enabled")
 }
 
+func newSSHKeyNotifyMailService() {
+	if !Cfg.Section("service").Key("ENABLE_SSH_KEY_NOTIFY_MAIL").MustBool(true) {
+		return
+	} else if MailService == nil {
+		return
+	}
+	Service.EnableSSHKeyNotifyMail = true
+	log.Info("SSH Key Notify Mail Service Enabled")
+}
+
+// Proxy returns proxy URL for outbound request,
+// it can be used as Proxy of http.Transport.
+func Proxy(req *http.Request) (*url.URL, error) {
+	if ProxyURL != nil {
+		return ProxyURL, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
 func newWebhookService() {
 	sec := Cfg.Section("webhook")
 	Webhook.TaskInterval = sec.Key("TASK_INTERVAL").MustInt(1)
 	Webhook.DeliverTimeout = sec.Key("DELIVER_TIMEOUT").MustInt(5)
 	Webhook.SkipTLSVerify = sec.Key("SKIP_TLS_VERIFY").MustBool()
+	Webhook.MaxAttempts = sec.Key("MAX_ATTEMPTS").MustInt(3)
+}
+
+func newNotificationService() {
+	sec := Cfg.Section("notification")
+	Notification.NotifyOnStar = sec.Key("NOTIFY_ON_STAR").MustBool()
+}
+
+func newKeyRateLimitService() {
+	sec := Cfg.Section("key_rate_limit")
+	KeyRateLimit.Enabled = sec.Key("ENABLED").MustBool(true)
+	KeyRateLimit.Burst = sec.Key("BURST").MustInt(10)
+	KeyRateLimit.RefillInterval = time.Duration(sec.Key("REFILL_INTERVAL").MustInt(6)) * time.Second
+	KeyRateLimit.FailureCost = sec.Key("FAILURE_COST").MustInt(3)
+}
+
+// NewMailServices initializes mail services only, for processes that do not run web server, e.g. serv.
+func NewMailServices() {
+	newMailService()
+	newNotifyMailService()
 }
 
 func NewServices() {
@@ -535,6 +757,8 @@ func NewServices() {
 	newMailService()
 	newRegisterMailService()
 	newNotifyMailService()
+	newSSHKeyNotifyMailService()
 	newWebhookService()
-	// ssh.Listen("2222")
+	newNotificationService()
+	newKeyRateLimitService()
 }
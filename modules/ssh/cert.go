@@ -0,0 +1,134 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/log"
+)
+
+var (
+	ErrCertNotUser          = errors.New("Certificate is not a user certificate")
+	ErrCertUntrustedCA      = errors.New("Certificate is signed by untrusted CA")
+	ErrCertExpired          = errors.New("Certificate has expired or is not yet valid")
+	ErrCertUnknownPrincipal = errors.New("Certificate has no principal that matches a user")
+)
+
+// trustedCAKeys are public keys of CAs whose user certificates are accepted.
+var trustedCAKeys []ssh.PublicKey
+
+// parseCAKeys parses public keys of CAs in authorized_keys format.
+func parseCAKeys(keys []string) ([]ssh.PublicKey, error) {
+	caKeys := make([]ssh.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if len(key) == 0 {
+			continue
+		}
+		caKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		caKeys = append(caKeys, caKey)
+	}
+	return caKeys, nil
+}
+
+func isTrustedCA(key ssh.PublicKey) bool {
+	data := key.Marshal()
+	for _, caKey := range trustedCAKeys {
+		if bytes.Equal(caKey.Marshal(), data) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCert checks type, CA and validity period of certificate at given time,
+// each with its own error so that rejections can be told apart in log.
+func checkCert(cert *ssh.Certificate, now time.Time) error {
+	if cert.CertType != ssh.UserCert {
+		return ErrCertNotUser
+	} else if !isTrustedCA(cert.SignatureKey) {
+		return ErrCertUntrustedCA
+	}
+
+	unix := uint64(now.Unix())
+	if unix < cert.ValidAfter || (cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore) {
+		return ErrCertExpired
+	}
+	return nil
+}
+
+// certUser returns first user whose name is one of principals of certificate,
+// and the principal. Organizations and bots cannot sign in, so their names are skipped.
+func certUser(cert *ssh.Certificate) (*models.User, string, error) {
+	for _, principal := range cert.ValidPrincipals {
+		u, err := models.GetUserByName(principal)
+		if err != nil {
+			if err == models.ErrUserNotExist {
+				continue
+			}
+			return nil, "", err
+		} else if u.IsOrganization() || u.IsBot {
+			continue
+		}
+		return u, principal, nil
+	}
+	return nil, "", ErrCertUnknownPrincipal
+}
+
+// certCallback authenticates user certificate signed by a trusted CA,
+// no public key in database is needed.
+func certCallback(conn ssh.ConnMetadata, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	desc := fmt.Sprintf("certificate(%s, serial %d) from %s", cert.KeyId, cert.Serial, conn.RemoteAddr())
+	if err := checkCert(cert, time.Now()); err != nil {
+		switch err {
+		case ErrCertUntrustedCA:
+			log.Warn("SSH: Rejected %s: signed by untrusted CA %s", desc, fingerprint(cert.SignatureKey))
+		case ErrCertExpired:
+			log.Warn("SSH: Rejected %s: expired or not yet valid, valid from %d to %d", desc, cert.ValidAfter, cert.ValidBefore)
+		default:
+			log.Warn("SSH: Rejected %s: %v", desc, err)
+		}
+		return nil, err
+	}
+
+	u, principal, err := certUser(cert)
+	if err != nil {
+		if err == ErrCertUnknownPrincipal {
+			log.Warn("SSH: Rejected %s: unknown principals %v", desc, cert.ValidPrincipals)
+		} else {
+			log.Error(4, "SSH: Fail to get user of %s: %v", desc, err)
+		}
+		return nil, err
+	}
+
+	// Signature is verified by checker, which also rejects certificates with
+	// critical options such as force-command that are not supported.
+	checker := &ssh.CertChecker{IsAuthority: isTrustedCA}
+	if err = checker.CheckCert(principal, cert); err != nil {
+		log.Warn("SSH: Rejected %s: %v", desc, err)
+		return nil, err
+	}
+
+	log.Trace("SSH: Accepted %s as user %s", desc, u.Name)
+	return &ssh.Permissions{
+		CriticalOptions: cert.CriticalOptions,
+		Extensions: map[string]string{
+			"user-id": com.ToStr(u.Id),
+			"cert-id": cert.KeyId,
+		},
+	}, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newCert(t *testing.T, ca ssh.Signer, certType uint32, validAfter, validBefore uint64) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             newSigner(t).PublicKey(),
+		CertType:        certType,
+		KeyId:           "alice@laptop",
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      validAfter,
+		ValidBefore:     validBefore,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestParseCAKeys(t *testing.T) {
+	ca := newSigner(t)
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.PublicKey()))) + " ca@example.com"
+	keys, err := parseCAKeys([]string{line, " "})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 1 || string(keys[0].Marshal()) != string(ca.PublicKey().Marshal()) {
+		t.Errorf("expect one CA key but got %d", len(keys))
+	}
+
+	if _, err = parseCAKeys([]string{"ssh-rsa invalid"}); err == nil {
+		t.Error("expect invalid key to be rejected")
+	}
+}
+
+func TestCheckCert(t *testing.T) {
+	trusted, untrusted := newSigner(t), newSigner(t)
+	trustedCAKeys = []ssh.PublicKey{trusted.PublicKey()}
+	defer func() { trustedCAKeys = nil }()
+
+	now := time.Now()
+	hourAgo, inHour := uint64(now.Add(-time.Hour).Unix()), uint64(now.Add(time.Hour).Unix())
+	cases := []struct {
+		desc string
+		cert *ssh.Certificate
+		err  error
+	}{
+		{"valid", newCert(t, trusted, ssh.UserCert, hourAgo, inHour), nil},
+		{"valid forever", newCert(t, trusted, ssh.UserCert, 0, ssh.CertTimeInfinity), nil},
+		{"host certificate", newCert(t, trusted, ssh.HostCert, hourAgo, inHour), ErrCertNotUser},
+		{"untrusted CA", newCert(t, untrusted, ssh.UserCert, hourAgo, inHour), ErrCertUntrustedCA},
+		{"expired", newCert(t, trusted, ssh.UserCert, 0, hourAgo), ErrCertExpired},
+		{"not yet valid", newCert(t, trusted, ssh.UserCert, inHour, ssh.CertTimeInfinity), ErrCertExpired},
+	}
+	for _, c := range cases {
+		if err := checkCert(c.cert, now); err != c.err {
+			t.Errorf("%s: expect %v but got %v", c.desc, c.err, err)
+		}
+	}
+
+	// Without trusted CAs no certificate is accepted.
+	trustedCAKeys = nil
+	if err := checkCert(cases[0].cert, now); err != ErrCertUntrustedCA {
+		t.Errorf("expect untrusted CA but got %v", err)
+	}
+}
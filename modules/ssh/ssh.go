@@ -2,119 +2,336 @@
 // Use of this source code is governed by a MIT-style
 // license that can be found in the LICENSE file.
 
-// Prototype, git client looks like do not recognize req.Reply.
+// Package ssh implements built-in SSH server, which authenticates public keys
+// against database and serves git commands in-process without sshd or authorized_keys file.
 package ssh
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/Unknwon/com"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/setting"
+	"github.com/gogits/gogs/modules/uuid"
 )
 
-func handleServerConn(keyId string, chans <-chan ssh.NewChannel) {
+// fingerprint returns SHA256 fingerprint of public key in format printed by OpenSSH.
+func fingerprint(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "=")
+}
+
+func sendExitStatus(ch ssh.Channel, status uint32) {
+	ch.SendRequest("exit-status", false, ssh.Marshal(&struct{ Status uint32 }{status}))
+}
+
+// identity is what client has authenticated with, either a public key
+// in database or a certificate signed by trusted CA.
+type identity struct {
+	keyId  int64  // ID of public key, 0 for certificate.
+	userId int64  // ID of user of certificate.
+	certId string // Key ID of certificate.
+}
+
+func newIdentity(perms *ssh.Permissions) *identity {
+	return &identity{
+		keyId:  com.StrTo(perms.Extensions["key-id"]).MustInt64(),
+		userId: com.StrTo(perms.Extensions["user-id"]).MustInt64(),
+		certId: perms.Extensions["cert-id"],
+	}
+}
+
+func (id *identity) String() string {
+	if id.keyId > 0 {
+		return fmt.Sprintf("Key(%d)", id.keyId)
+	}
+	return fmt.Sprintf("Certificate(%s) of user(%d)", id.certId, id.userId)
+}
+
+// check checks that git command can be run with the identity, the key is a
+// placeholder carrying key ID of certificate when client has authenticated with one.
+func (id *identity) check(cmd string) (*models.PublicKey, *models.User, *models.SSHCommand, error) {
+	if id.keyId > 0 {
+		return models.CheckSSHRequest(id.keyId, cmd)
+	}
+	user, sshCmd, err := models.CheckSSHUserRequest(id.userId, cmd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &models.PublicKey{OwnerId: user.Id, Name: id.certId}, user, sshCmd, nil
+}
+
+// runCommand runs git command requested with given identity the same way as serv command,
+// and returns exit status.
+func runCommand(id *identity, remoteAddr, cmd string, ch ssh.Channel) uint32 {
+	fail := func(userMessage, logMessage string, args ...interface{}) uint32 {
+		fmt.Fprintln(ch.Stderr(), "Gogs:", userMessage)
+		log.Error(4, "SSH: "+logMessage, args...)
+		return 1
+	}
+	// failRequest tells client why request is rejected, detailed reason is only logged.
+	failRequest := func(err error) uint32 {
+		fmt.Fprint(ch.Stderr(), models.SSHErrorLine(err))
+		log.Error(4, "SSH: %s: %v", id, err)
+		return 1
+	}
+
+	keyId := id.keyId
+	key, user, sshCmd, err := id.check(cmd)
+	if err != nil {
+		return failRequest(err)
+	}
+
+	if sshCmd == nil {
+		fmt.Fprintf(ch, "Hi %s! You've successfully authenticated, but Gogs does not provide shell access.\n", user.Name)
+		return 0
+	}
+	repo := sshCmd.Repo
+
+	// Recording activity does not hold up git command.
+	addKeyActivity := func() {
+		activity := &models.KeyActivity{
+			KeyId:      keyId,
+			KeyName:    key.Name,
+			OwnerId:    user.Id,
+			OwnerName:  user.Name,
+			RepoId:     repo.Id,
+			RepoName:   sshCmd.RepoOwner.Name + "/" + repo.Name,
+			Operation:  sshCmd.Operation(),
+			RemoteAddr: remoteAddr,
+		}
+		go func() {
+			if err := models.AddKeyActivity(activity); err != nil {
+				log.Error(4, "SSH: AddKeyActivity: %v", err)
+			}
+		}()
+	}
+
+	// Reads are recorded right away, pushes are recorded once refs
+	// have been updated, so rejected pushes are not shown as last push.
+	if sshCmd.Mode != models.ACCESS_MODE_WRITE {
+		addKeyActivity()
+	}
+
+	// Environment variables are passed to git command only, since
+	// a process may serve many connections at the same time.
+	uuid := uuid.NewV4().String()
+	gitcmd := exec.Command(sshCmd.Verb, sshCmd.RepoPath)
+	gitcmd.Dir = setting.RepoRootPath
+	gitcmd.Env = append(os.Environ(), "uuid="+uuid, "repoId="+com.ToStr(repo.Id), "SSH_ORIGINAL_COMMAND="+cmd)
+	gitcmd.Env = append(gitcmd.Env, sshCmd.Env(keyId, user)...)
+	gitcmd.Stdout = ch
+	gitcmd.Stderr = ch.Stderr()
+	stdin, err := gitcmd.StdinPipe()
+	if err != nil {
+		return fail("Internal error", "StdinPipe: %v", err)
+	}
+	if err = gitcmd.Start(); err != nil {
+		return fail("Internal error", "Fail to start git command: %v", err)
+	}
+	// Client may keep channel open after git command exits, do not wait for it.
+	go func() {
+		io.Copy(stdin, ch)
+		stdin.Close()
+	}()
+	if err = gitcmd.Wait(); err != nil {
+		return fail("Internal error", "Fail to execute git command: %v", err)
+	}
+
+	if sshCmd.Mode == models.ACCESS_MODE_WRITE {
+		tasks, err := models.GetUpdateTasksByUuid(uuid)
+		if err != nil {
+			log.Error(4, "SSH: GetUpdateTasksByUuid: %v", err)
+		}
+		if len(tasks) > 0 {
+			addKeyActivity()
+		}
+		for _, task := range tasks {
+			commits, err := models.Update(task.RefName, task.OldCommitId, task.NewCommitId,
+				user.Name, sshCmd.RepoOwner.Name, repo.Name, user.Id)
+			if err != nil {
+				log.Error(4, "SSH: Fail to update: %v", err)
+			} else if err = models.QueuePushNotification(repo, models.RepoPath(sshCmd.RepoOwner.Name, repo.Name),
+				task.RefName, task.OldCommitId, user.Name, commits); err != nil {
+				log.Error(4, "SSH: QueuePushNotification: %v", err)
+			}
+		}
+		if err = models.DelUpdateTasksByUuid(uuid); err != nil {
+			log.Error(4, "SSH: DelUpdateTasksByUuid: %v", err)
+		}
+
+		go func() {
+			if err := models.UpdateRepoSize(repo); err != nil {
+				log.Error(4, "SSH: UpdateRepoSize: %v", err)
+			}
+		}()
+	}
+
+	// Certificates have no key in database to record usage of.
+	if keyId > 0 {
+		if err = models.RecordKeyUsage(keyId, repo.Id, sshCmd.Operation()); err != nil {
+			log.Error(4, "SSH: RecordKeyUsage: %v", err)
+		}
+	}
+	return 0
+}
+
+// handleSession serves requests of a session channel, only one command is run per session.
+func handleSession(id *identity, remoteAddr string, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	for req := range reqs {
+		var cmd string
+		switch req.Type {
+		case "env":
+			// Environment variables sent by client are ignored.
+			req.Reply(true, nil)
+			continue
+		case "shell":
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			cmd = payload.Command
+		default:
+			req.Reply(false, nil)
+			continue
+		}
+
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(reqs)
+		sendExitStatus(ch, runCommand(id, remoteAddr, cmd, ch))
+		return
+	}
+}
+
+func handleServerConn(id *identity, remoteAddr string, chans <-chan ssh.NewChannel) {
 	for newChan := range chans {
 		if newChan.ChannelType() != "session" {
 			newChan.Reject(ssh.UnknownChannelType, "unknown channel type")
 			continue
 		}
-		channel, requests, err := newChan.Accept()
+		ch, reqs, err := newChan.Accept()
 		if err != nil {
-			log.Error(3, "Could not accept channel: %v", err)
+			log.Error(4, "SSH: Fail to accept channel: %v", err)
 			continue
 		}
-
-		go func(in <-chan *ssh.Request) {
-			defer channel.Close()
-			for req := range in {
-				ok, payload := false, strings.TrimLeft(string(req.Payload), "\x00&")
-				fmt.Println("Request:", req.Type, req.WantReply, payload)
-				if req.WantReply {
-					fmt.Println(req.Reply(true, nil))
-				}
-				switch req.Type {
-				case "env":
-					args := strings.Split(strings.Replace(payload, "\x00", "", -1), "\v")
-					if len(args) != 2 {
-						break
-					}
-					args[0] = strings.TrimLeft(args[0], "\x04")
-					_, _, err := com.ExecCmdBytes("env", args[0]+"="+args[1])
-					if err != nil {
-						log.Error(3, "env: %v", err)
-						channel.Stderr().Write([]byte(err.Error()))
-						break
-					}
-					ok = true
-				case "exec":
-					os.Setenv("SSH_ORIGINAL_COMMAND", strings.TrimLeft(payload, "'("))
-					log.Info("Payload: %v", strings.TrimLeft(payload, "'("))
-					cmd := exec.Command("/Users/jiahuachen/Applications/Go/src/github.com/gogits/gogs/gogs", "serv", "key-"+keyId)
-					cmd.Stdout = channel
-					cmd.Stdin = channel
-					cmd.Stderr = channel.Stderr()
-					if err := cmd.Run(); err != nil {
-						log.Error(3, "exec: %v", err)
-					} else {
-						ok = true
-					}
-				}
-				fmt.Println("Done:", ok)
-			}
-			fmt.Println("Done!!!")
-		}(requests)
+		go handleSession(id, remoteAddr, ch, reqs)
 	}
 }
 
-func listen(config *ssh.ServerConfig, port string) {
-	listener, err := net.Listen("tcp", "0.0.0.0:"+port)
-	if err != nil {
-		panic(err)
-	}
+func listen(config *ssh.ServerConfig, listener net.Listener) {
 	for {
-		// Once a ServerConfig has been configured, connections can be accepted.
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Error(3, "Fail to accept incoming connection: %v", err)
+			log.Error(4, "SSH: Fail to accept incoming connection: %v", err)
 			continue
 		}
-		// Before use, a handshake must be performed on the incoming net.Conn.
-		sConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+
+		go func() {
+			// Before use, a handshake must be performed on the incoming net.Conn.
+			sConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				log.Trace("SSH: Fail to handshake with %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+			defer sConn.Close()
+
+			remoteAddr := sConn.RemoteAddr().String()
+			if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+				remoteAddr = host
+			}
+			go ssh.DiscardRequests(reqs)
+			handleServerConn(newIdentity(sConn.Permissions), remoteAddr, chans)
+		}()
+	}
+}
+
+// publicKeyCallback authenticates public key of client against public keys in database,
+// or certificate of client against trusted CAs.
+func publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return certCallback(conn, cert)
+	}
+
+	pkey, err := models.SearchPublicKeyByFingerprint(fingerprint(key))
+	if err != nil {
+		if err != models.ErrKeyNotExist {
+			log.Error(4, "SSH: SearchPublicKeyByFingerprint: %v", err)
+		}
+		return nil, err
+	} else if err = models.CheckSSHKey(pkey); err != nil {
+		return nil, err
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"key-id": com.ToStr(pkey.Id)}}, nil
+}
+
+// loadHostKey loads private host key of given path, a new RSA key is generated
+// when it does not exist yet.
+func loadHostKey(fpath string) (ssh.Signer, error) {
+	if !com.IsExist(fpath) {
+		if err := os.MkdirAll(filepath.Dir(fpath), 0700); err != nil {
+			return nil, err
+		}
+		key, err := rsa.GenerateKey(rand.Reader, 3072)
 		if err != nil {
-			log.Error(3, "Fail to handshake: %v", err)
-			continue
+			return nil, fmt.Errorf("GenerateKey: %v", err)
 		}
-		// The incoming Request channel must be serviced.
-		go ssh.DiscardRequests(reqs)
-		go handleServerConn(sConn.Permissions.Extensions["key-id"], chans)
+		data := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})
+		if err = ioutil.WriteFile(fpath, data, 0600); err != nil {
+			return nil, err
+		}
+		log.Info("SSH: Generated new host key: %s", fpath)
 	}
+
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
 }
 
-// Listen starts a SSH server listens on given port.
-func Listen(port string) {
+// Listen starts built-in SSH server on given address.
+func Listen(host string, port int) {
 	config := &ssh.ServerConfig{
-		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-			// keyCache[string(ssh.MarshalAuthorizedKey(key))] = 2
-			return &ssh.Permissions{Extensions: map[string]string{"key-id": "1"}}, nil
-		},
+		PublicKeyCallback: publicKeyCallback,
 	}
 
-	privateBytes, err := ioutil.ReadFile("/Users/jiahuachen/.ssh/id_rsa")
+	hostKey, err := loadHostKey(setting.SSHServerHostKey)
 	if err != nil {
-		panic("failed to load private key")
+		log.Fatal(4, "SSH: Fail to load host key: %v", err)
 	}
-	private, err := ssh.ParsePrivateKey(privateBytes)
-	if err != nil {
-		panic("failed to parse private key")
+	config.AddHostKey(hostKey)
+
+	if trustedCAKeys, err = parseCAKeys(setting.SSHTrustedUserCAKeys); err != nil {
+		log.Fatal(4, "SSH: Fail to parse trusted CA keys: %v", err)
 	}
-	config.AddHostKey(private)
 
-	go listen(config, port)
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		log.Fatal(4, "SSH: Fail to listen on %s:%d: %v", host, port, err)
+	}
+	log.Info("SSH: Listen on %s:%d", host, port)
+	go listen(config, listener)
 }
@@ -0,0 +1,177 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// channel is an ssh.Channel over a plain connection, which is enough
+// to run git commands without SSH handshake.
+type channel struct {
+	net.Conn
+	stderr bytes.Buffer
+}
+
+func (ch *channel) CloseWrite() error {
+	return nil
+}
+
+func (ch *channel) SendRequest(string, bool, []byte) (bool, error) {
+	return true, nil
+}
+
+func (ch *channel) Stderr() io.ReadWriter {
+	return &ch.stderr
+}
+
+// TestHelperProcess is not a real test, but the transport of git client,
+// it connects standard input and output to socket of test that runs the command.
+func TestHelperProcess(t *testing.T) {
+	socketPath := os.Getenv("GOGS_TEST_SOCKET")
+	if len(socketPath) == 0 {
+		return
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		os.Exit(1)
+	}
+	go io.Copy(conn, os.Stdin)
+	io.Copy(os.Stdout, conn)
+	os.Exit(0)
+}
+
+func runGit(t *testing.T, dir string, env []string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v - %s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestRunCommandPush(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	setting.ConfRootPath = "../../conf"
+	setting.LogRootPath = tmpDir
+	setting.RepoRootPath = filepath.Join(tmpDir, "repos")
+	models.SSHPath = filepath.Join(tmpDir, ".ssh")
+	if err = os.MkdirAll(models.SSHPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	models.DbCfg.Type = "sqlite3"
+	models.DbCfg.Path = filepath.Join(tmpDir, "gogs.db")
+	if err = models.NewEngine(); err != nil {
+		t.Fatal(err)
+	}
+	defer models.CloseEngine()
+
+	u := &models.User{Name: "alice", Email: "alice@example.com", Passwd: "password"}
+	if err = models.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(newSigner(t).PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &models.PublicKey{
+		OwnerId: u.Id,
+		Name:    "laptop",
+		Content: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+	}
+	if err = models.AddPublicKey(key, models.SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = models.CreateRepository(u, "repo", "", "", "", false, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Update hook records what it is given instead of calling gogs binary,
+	// which does not exist in tests.
+	repoPath := models.RepoPath(u.Name, "repo")
+	envFile := filepath.Join(tmpDir, "hook.env")
+	if err = ioutil.WriteFile(filepath.Join(repoPath, "hooks", "update"),
+		[]byte("#!/bin/sh\necho \"$SSH_ORIGINAL_COMMAND\" > '"+envFile+"'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	socketPath := filepath.Join(tmpDir, "git.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	const cmd = "git-receive-pack 'alice/repo.git'"
+	status := make(chan uint32, 1)
+	ch := new(channel)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			status <- 255
+			return
+		}
+		ch.Conn = conn
+		status <- runCommand(&identity{keyId: key.Id}, "127.0.0.1", cmd, ch)
+		conn.Close()
+	}()
+
+	workDir := filepath.Join(tmpDir, "work")
+	env := []string{
+		"GIT_AUTHOR_NAME=alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+		"GIT_COMMITTER_NAME=alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+		"GOGS_TEST_SOCKET=" + socketPath,
+	}
+	runGit(t, tmpDir, env, "init", workDir)
+	if err = ioutil.WriteFile(filepath.Join(workDir, "README.md"), []byte("# repo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, workDir, env, "add", "README.md")
+	runGit(t, workDir, env, "commit", "-m", "Initial commit")
+	runGit(t, workDir, env, "-c", "protocol.ext.allow=always", "push",
+		"ext::"+os.Args[0]+" -test.run=TestHelperProcess", "HEAD:refs/heads/master")
+
+	if s := <-status; s != 0 {
+		t.Fatalf("expect push to succeed but got status %d: %s", s, ch.stderr.String())
+	}
+	data, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("expect update hook to run: %v", err)
+	} else if got := strings.TrimSpace(string(data)); got != cmd {
+		t.Errorf("expect update hook to get SSH_ORIGINAL_COMMAND %q but got %q", cmd, got)
+	}
+
+	// Update hook of test adds no update task as if it had rejected the push,
+	// so the push must not be recorded as last push via key.
+	repo, err := models.GetRepositoryByName(u.Id, "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, err := models.GetLastKeyPush(repo.Id); err != nil {
+		t.Fatal(err)
+	} else if act != nil {
+		t.Errorf("expect push without updated refs not to be recorded but got %+v", act)
+	}
+}
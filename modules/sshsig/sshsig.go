@@ -0,0 +1,284 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package sshsig parses and verifies signatures made by "ssh-keygen -Y sign",
+// which Git uses to sign commits and tags with SSH keys.
+// See PROTOCOL.sshsig of OpenSSH for the format.
+package sshsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	ARMOR_START = "-----BEGIN SSH SIGNATURE-----"
+	ARMOR_END   = "-----END SSH SIGNATURE-----"
+
+	MAGIC   = "SSHSIG"
+	VERSION = 1
+)
+
+var (
+	ErrNotSSHSignature     = errors.New("Not an armored SSH signature")
+	ErrMalformedSignature  = errors.New("Malformed SSH signature")
+	ErrUnsupportedVersion  = errors.New("Unsupported SSH signature version")
+	ErrUnsupportedHash     = errors.New("Unsupported hash algorithm of SSH signature")
+	ErrUnsupportedKeyType  = errors.New("Unsupported key type of SSH signature")
+	ErrNamespaceMismatch   = errors.New("Namespace of SSH signature does not match")
+	ErrSignatureNotMatched = errors.New("SSH signature does not match")
+)
+
+// Signature represents a parsed SSH signature.
+type Signature struct {
+	PublicKey     []byte // Public key in SSH wire format.
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Format        string // Signature algorithm, e.g. "ssh-ed25519" or "rsa-sha2-512".
+	Blob          []byte
+}
+
+// IsArmored returns true if given signature looks like an armored SSH signature.
+func IsArmored(sig string) bool {
+	return strings.HasPrefix(strings.TrimSpace(sig), ARMOR_START)
+}
+
+// readString reads a length-prefixed string of SSH wire format.
+func readString(b []byte) (s, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+n:], true
+}
+
+// appendString appends a length-prefixed string of SSH wire format.
+func appendString(b, s []byte) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+	return append(append(b, n[:]...), s...)
+}
+
+// Parse parses an armored SSH signature.
+func Parse(armored string) (*Signature, error) {
+	armored = strings.TrimSpace(armored)
+	if !strings.HasPrefix(armored, ARMOR_START) || !strings.HasSuffix(armored, ARMOR_END) {
+		return nil, ErrNotSSHSignature
+	}
+	body := strings.Join(strings.Fields(armored[len(ARMOR_START):len(armored)-len(ARMOR_END)]), "")
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, ErrMalformedSignature
+	}
+
+	if !bytes.HasPrefix(data, []byte(MAGIC)) || len(data) < len(MAGIC)+4 {
+		return nil, ErrMalformedSignature
+	}
+	data = data[len(MAGIC):]
+	if binary.BigEndian.Uint32(data) != VERSION {
+		return nil, ErrUnsupportedVersion
+	}
+	data = data[4:]
+
+	var fields [5][]byte
+	var ok bool
+	for i := range fields {
+		if fields[i], data, ok = readString(data); !ok {
+			return nil, ErrMalformedSignature
+		}
+	}
+	sig := &Signature{
+		PublicKey:     fields[0],
+		Namespace:     string(fields[1]),
+		Reserved:      fields[2],
+		HashAlgorithm: string(fields[3]),
+	}
+
+	format, rest, ok := readString(fields[4])
+	if !ok {
+		return nil, ErrMalformedSignature
+	}
+	sig.Format = string(format)
+	if sig.Blob, _, ok = readString(rest); !ok {
+		return nil, ErrMalformedSignature
+	}
+	return sig, nil
+}
+
+// KeyType returns type of public key that made the signature, e.g. "ssh-ed25519".
+func (s *Signature) KeyType() string {
+	tp, _, _ := readString(s.PublicKey)
+	return string(tp)
+}
+
+// AuthorizedKey returns public key that made the signature
+// in format of authorized_keys file without comment.
+func (s *Signature) AuthorizedKey() string {
+	return s.KeyType() + " " + base64.StdEncoding.EncodeToString(s.PublicKey)
+}
+
+// Fingerprint returns SHA256 fingerprint of public key that made the signature
+// in format printed by OpenSSH.
+func (s *Signature) Fingerprint() string {
+	sum := sha256.Sum256(s.PublicKey)
+	return "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "=")
+}
+
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, ErrUnsupportedHash
+}
+
+// signedData returns data that is actually signed by the key for given message.
+func (s *Signature) signedData(message []byte) ([]byte, error) {
+	h, err := newHash(s.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(message)
+
+	data := []byte(MAGIC)
+	data = appendString(data, []byte(s.Namespace))
+	data = appendString(data, s.Reserved)
+	data = appendString(data, []byte(s.HashAlgorithm))
+	return appendString(data, h.Sum(nil)), nil
+}
+
+// Verify verifies that the signature is made over given message in given namespace,
+// e.g. "git", by its public key.
+func (s *Signature) Verify(namespace string, message []byte) error {
+	if s.Namespace != namespace {
+		return ErrNamespaceMismatch
+	}
+	data, err := s.signedData(message)
+	if err != nil {
+		return err
+	}
+	return verifyBlob(s.PublicKey, s.Format, s.Blob, data)
+}
+
+// parseMPInt reads a multiple precision integer of SSH wire format.
+func parseMPInt(b []byte) (*big.Int, []byte, bool) {
+	n, rest, ok := readString(b)
+	if !ok {
+		return nil, nil, false
+	}
+	return new(big.Int).SetBytes(n), rest, true
+}
+
+var ecdsaCurves = map[string]struct {
+	curve elliptic.Curve
+	hash  crypto.Hash
+}{
+	"nistp256": {elliptic.P256(), crypto.SHA256},
+	"nistp384": {elliptic.P384(), crypto.SHA384},
+	"nistp521": {elliptic.P521(), crypto.SHA512},
+}
+
+// verifyBlob verifies signature blob of given format over data with public key in SSH wire format.
+func verifyBlob(pubKey []byte, format string, blob, data []byte) error {
+	keyType, rest, ok := readString(pubKey)
+	if !ok {
+		return ErrMalformedSignature
+	}
+
+	switch string(keyType) {
+	case "ssh-ed25519":
+		key, _, ok := readString(rest)
+		if !ok || len(key) != ed25519.PublicKeySize {
+			return ErrMalformedSignature
+		} else if format != "ssh-ed25519" {
+			return ErrSignatureNotMatched
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key), data, blob) {
+			return ErrSignatureNotMatched
+		}
+		return nil
+
+	case "ssh-rsa":
+		e, rest, ok := parseMPInt(rest)
+		if !ok || e.BitLen() > 31 {
+			return ErrMalformedSignature
+		}
+		n, _, ok := parseMPInt(rest)
+		if !ok {
+			return ErrMalformedSignature
+		}
+
+		// SHA-1 signatures are not accepted for SSH signatures.
+		var h crypto.Hash
+		switch format {
+		case "rsa-sha2-256":
+			h = crypto.SHA256
+		case "rsa-sha2-512":
+			h = crypto.SHA512
+		default:
+			return ErrSignatureNotMatched
+		}
+		hashed := h.New()
+		hashed.Write(data)
+		if rsa.VerifyPKCS1v15(&rsa.PublicKey{N: n, E: int(e.Int64())}, h, hashed.Sum(nil), blob) != nil {
+			return ErrSignatureNotMatched
+		}
+		return nil
+
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		curveName, rest, ok := readString(rest)
+		if !ok {
+			return ErrMalformedSignature
+		}
+		curve, has := ecdsaCurves[string(curveName)]
+		if !has || string(keyType) != "ecdsa-sha2-"+string(curveName) {
+			return ErrMalformedSignature
+		}
+		point, _, ok := readString(rest)
+		if !ok {
+			return ErrMalformedSignature
+		}
+		x, y := elliptic.Unmarshal(curve.curve, point)
+		if x == nil {
+			return ErrMalformedSignature
+		} else if format != string(keyType) {
+			return ErrSignatureNotMatched
+		}
+
+		r, rest, ok := parseMPInt(blob)
+		if !ok {
+			return ErrMalformedSignature
+		}
+		s, _, ok := parseMPInt(rest)
+		if !ok {
+			return ErrMalformedSignature
+		}
+		hashed := curve.hash.New()
+		hashed.Write(data)
+		if !ecdsa.Verify(&ecdsa.PublicKey{Curve: curve.curve, X: x, Y: y}, hashed.Sum(nil), r, s) {
+			return ErrSignatureNotMatched
+		}
+		return nil
+	}
+	return ErrUnsupportedKeyType
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sshsig
+
+import (
+	"testing"
+)
+
+// Signatures of testMessage made by "ssh-keygen -Y sign -n git".
+const (
+	testMessage = "hello gogs\n"
+
+	testEd25519Key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIvJzf2ApZnp4z19WqS3HDpu51JKii+qjfVbQqNvt3/R"
+	testEd25519Sig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgi8nN/YClmenjPX1apLccOm7nUk
+qKL6qN9VtCo2+3f9EAAAADZ2l0AAAAAAAAAAZzaGE1MTIAAABTAAAAC3NzaC1lZDI1NTE5
+AAAAQMZ2hr9keJJlisgP+jejrrmZ6WQVl5ZhiIoLzdcuYGm/hYQIPgYycOJE76F0rssDjJ
+zF6iDE3Jvy9jqxxZEkVAo=
+-----END SSH SIGNATURE-----`
+
+	testRSAKey = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC/HCrfpvJfXzyPu7VBInJUSR8j3anHy/tkrNIQVdNC9HQnHtvwOFsLxljbdZKxfYzDT5QWSj3K8NuTJhdyT8NIjjG19zpkU8rnlMtOtYXfEw8VCDqmOuf6KsZUuzn/zpZxhcSA/L6mxzkmlNh7B+Ya0JtlGqZ2Dv9a4Qgt+RovxaWddZo8mQVS7aNb+shZ0I3wizEbDuKkHChCREWE/DQGqRelR6wiWm8tQEacMmBy1g8tJ9MoBohKGDRm8+nPdD7t2AMYrW2uE2w91BFw2uxNgQCFcjZ3VuVCenTw4uvQfG+Di5VqL1kpVKb2OfN1odkcAAPL7XSz9SpLXAaPqOHB"
+	testRSASig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAARcAAAAHc3NoLXJzYQAAAAMBAAEAAAEBAL8cKt+m8l9fPI+7tUEicl
+RJHyPdqcfL+2Ss0hBV00L0dCce2/A4WwvGWNt1krF9jMNPlBZKPcrw25MmF3JPw0iOMbX3
+OmRTyueUy061hd8TDxUIOqY65/oqxlS7Of/OlnGFxID8vqbHOSaU2HsH5hrQm2UapnYO/1
+rhCC35Gi/FpZ11mjyZBVLto1v6yFnQjfCLMRsO4qQcKEJERYT8NAapF6VHrCJaby1ARpwy
+YHLWDy0n0ygGiEoYNGbz6c90Pu3YAxitba4TbD3UEXDa7E2BAIVyNndW5UJ6dPDi69B8b4
+OLlWovWSlUpvY583Wh2RwAA8vtdLP1KktcBo+o4cEAAAADZ2l0AAAAAAAAAAZzaGE1MTIA
+AAEUAAAADHJzYS1zaGEyLTUxMgAAAQCF0WaRzmCHohwe9+F/Dk+gkIb1wF6/fdMroUhd/W
+IGj2HYuCHRdczRMG/6oEd4bd4xdWsVZvTaQrbLbBD0QTummguIpM2GXjK2Ft5hLUisQlEx
+La5vMFXAt8AkcjZb4VG3gDKjZXGrgYLsnfzdac+yJcJ7BVelgfgI4YJJuuUIIO8+qimYFd
+4mv8Hw2ZMrLmbXzmOw7M9A1CzRyhrH8/A3ZoL4Y0SjFP8tTdkfjdALCSh6KiFzE7U67vxd
+df38etWZzhtRmpMViorFl77P6ygiED+sBsNqTlhTpdEGUir/65dNeDg4TdZwDl9dWSQmKC
+t5uWVkZjolz+C7E497RJt3
+-----END SSH SIGNATURE-----`
+
+	testECDSAKey = "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBAptWc+sPt7UXgMCeXCxtS2byT+SxqiU3V8Qh8536elo0SP3bvxPFiPCRO71pPVdPsRv+iOecsDiGSc7URS6QnE="
+	testECDSASig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAAGgAAAATZWNkc2Etc2hhMi1uaXN0cDI1NgAAAAhuaXN0cDI1NgAAAE
+EECm1Zz6w+3tReAwJ5cLG1LZvJP5LGqJTdXxCHznfp6WjRI/du/E8WI8JE7vWk9V0+xG/6
+I55ywOIZJztRFLpCcQAAAANnaXQAAAAAAAAABnNoYTUxMgAAAGQAAAATZWNkc2Etc2hhMi
+1uaXN0cDI1NgAAAEkAAAAgKOS1jwwB4xmQZw+cnTHR50v/XZGbRZRrHFiTj3nvFZUAAAAh
+AMTOo38lhd75eFAGQsqrztPBVAFA2vE2pqFng9CMUZHn
+-----END SSH SIGNATURE-----`
+
+	// Made by the Ed25519 key with namespace "file".
+	testFileSig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgi8nN/YClmenjPX1apLccOm7nUk
+qKL6qN9VtCo2+3f9EAAAAEZmlsZQAAAAAAAAAGc2hhNTEyAAAAUwAAAAtzc2gtZWQyNTUx
+OQAAAEAke49dwJi+XQHKnezB9oBslO9/WWIG3J0jyu4cHGwyGQarXxpiaRfxQdk6BsnGxh
+q0PjGS+NJ1oipyDYQWL9YE
+-----END SSH SIGNATURE-----`
+)
+
+func TestVerify(t *testing.T) {
+	for _, tc := range []struct {
+		key, sig string
+	}{
+		{testEd25519Key, testEd25519Sig},
+		{testRSAKey, testRSASig},
+		{testECDSAKey, testECDSASig},
+	} {
+		sig, err := Parse(tc.sig)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if sig.AuthorizedKey() != tc.key {
+			t.Errorf("expect key %q but got %q", tc.key, sig.AuthorizedKey())
+		}
+		if err = sig.Verify("git", []byte(testMessage)); err != nil {
+			t.Errorf("Verify(%s): %v", sig.KeyType(), err)
+		}
+		if err = sig.Verify("git", []byte("hello gogs!\n")); err != ErrSignatureNotMatched {
+			t.Errorf("Verify(%s) of tampered message: expect ErrSignatureNotMatched but got %v", sig.KeyType(), err)
+		}
+	}
+}
+
+func TestVerifyNamespace(t *testing.T) {
+	sig, err := Parse(testFileSig)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err = sig.Verify("git", []byte(testMessage)); err != ErrNamespaceMismatch {
+		t.Errorf("expect ErrNamespaceMismatch but got %v", err)
+	}
+	if err = sig.Verify("file", []byte(testMessage)); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if !IsArmored(testEd25519Sig) || IsArmored("-----BEGIN PGP SIGNATURE-----") {
+		t.Errorf("IsArmored does not tell SSH signatures apart")
+	}
+
+	sig, err := Parse(testEd25519Sig)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	} else if sig.Namespace != "git" || sig.HashAlgorithm != "sha512" || sig.Format != "ssh-ed25519" {
+		t.Errorf("unexpected signature: %+v", sig)
+	}
+	if fp := sig.Fingerprint(); len(fp) != 50 || fp[:7] != "SHA256:" {
+		t.Errorf("unexpected fingerprint: %s", fp)
+	}
+
+	for _, armored := range []string{
+		"",
+		"-----BEGIN PGP SIGNATURE-----\nabc\n-----END PGP SIGNATURE-----",
+		ARMOR_START + "\n!!!\n" + ARMOR_END,
+		ARMOR_START + "\nU1NIU0lHAAAAAQ==\n" + ARMOR_END,
+	} {
+		if _, err = Parse(armored); err == nil {
+			t.Errorf("Parse(%q): expect error but got none", armored)
+		}
+	}
+}
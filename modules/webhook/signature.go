@@ -0,0 +1,51 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package webhook provides helpers for services that receive webhooks from Gogs.
+//
+// When a webhook has a secret, every delivery carries header X-Gogs-Signature
+// in form of "sha256=<hex>", which is HMAC-SHA256 of the payload keyed by the secret.
+// Payload is the request body for JSON content type, and value of "payload"
+// field for form content type. A receiver written in Go can check it with:
+//
+//	body, _ := ioutil.ReadAll(r.Body)
+//	if !webhook.VerifySignature(secret, body, r.Header.Get(webhook.SIGNATURE_HEADER)) {
+//		http.Error(w, "invalid signature", http.StatusUnauthorized)
+//		return
+//	}
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// Header that carries signature of a delivery.
+	SIGNATURE_HEADER = "X-Gogs-Signature"
+
+	SIGNATURE_PREFIX = "sha256="
+)
+
+// Sign returns hex-encoded HMAC-SHA256 of body keyed by secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature returns true if sigHeader is signature of body with given secret,
+// an optional "sha256=" prefix of sigHeader is stripped. The comparison takes constant
+// time so it does not leak how much of signature is correct.
+func VerifySignature(secret, body []byte, sigHeader string) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(sigHeader), SIGNATURE_PREFIX))
+	if err != nil || len(sig) != sha256.Size {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
@@ -0,0 +1,43 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"testing"
+)
+
+// Test vector from GitHub documentation of validating webhook deliveries.
+const (
+	testSecret    = "It's a Secret to Everybody"
+	testPayload   = "Hello, World!"
+	testSignature = "757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+)
+
+func TestSign(t *testing.T) {
+	if sig := Sign([]byte(testSecret), []byte(testPayload)); sig != testSignature {
+		t.Errorf("expect signature %s but got %s", testSignature, sig)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	for _, c := range []struct {
+		secret, body, header string
+		valid                bool
+	}{
+		{testSecret, testPayload, "sha256=" + testSignature, true},
+		{testSecret, testPayload, testSignature, true},
+		{testSecret, testPayload, " sha256=" + testSignature + "\n", true},
+		{"wrong secret", testPayload, "sha256=" + testSignature, false},
+		{testSecret, "Hello, World?", "sha256=" + testSignature, false},
+		{testSecret, testPayload, "sha1=" + testSignature, false},
+		{testSecret, testPayload, "sha256=" + testSignature[:62], false},
+		{testSecret, testPayload, "sha256=not-hex", false},
+		{testSecret, testPayload, "", false},
+	} {
+		if valid := VerifySignature([]byte(c.secret), []byte(c.body), c.header); valid != c.valid {
+			t.Errorf("VerifySignature(%q, %q, %q): expect %v but got %v", c.secret, c.body, c.header, c.valid, valid)
+		}
+	}
+}
@@ -753,10 +753,17 @@ function initAdmin() {
         if (v == 2) {
             $('.ldap').toggleShow();
             $('.smtp').toggleHide();
+            $('.saml').toggleHide();
         }
         if (v == 3) {
             $('.smtp').toggleShow();
             $('.ldap').toggleHide();
+            $('.saml').toggleHide();
+        }
+        if (v == 4) {
+            $('.saml').toggleShow();
+            $('.ldap').toggleHide();
+            $('.smtp').toggleHide();
         }
     });
 
@@ -772,6 +779,52 @@ function initAdmin() {
         var $form = $('#auth-setting-form');
         $form.attr('action', $form.data('delete-url'));
     });
+
+    // Import from GitLab, progress is streamed as server-sent events.
+    $('#gitlab-import-form').submit(function (e) {
+        e.preventDefault();
+        var $form = $(this);
+        var $log = $('#gitlab-import-log').empty().show();
+        var parsed = 0;
+        var xhr = new XMLHttpRequest();
+        var handleEvents = function () {
+            var events = xhr.responseText.substring(parsed).split("\n\n");
+            // Last part is an incomplete event or empty.
+            for (var i = 0; i < events.length - 1; i++) {
+                parsed += events[i].length + 2;
+                var name = "", data = "";
+                $.each(events[i].split("\n"), function (_, line) {
+                    if (line.indexOf("event: ") === 0) {
+                        name = line.substring(7);
+                    } else if (line.indexOf("data: ") === 0) {
+                        data = line.substring(6);
+                    }
+                });
+                if (name == "done") {
+                    var report = JSON.parse(data);
+                    data = $log.data('done')
+                        .replace('%repos', JSON.stringify(report.repos))
+                        .replace('%keys', JSON.stringify(report.keys));
+                    $.each(report.key_failures || [], function (_, f) {
+                        $('<li class="text-red">').text($log.data('key-failure')
+                            .replace('%user', f.username)
+                            .replace('%title', f.title)
+                            .replace('%reason', f.reason)).appendTo($log);
+                    });
+                }
+                $('<li>').text(data).toggleClass('text-red', name == "error").appendTo($log);
+            }
+        };
+        xhr.open("POST", $form.attr('action'));
+        xhr.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+        xhr.onprogress = handleEvents;
+        xhr.onload = function () {
+            handleEvents();
+            $form.find('button').removeAttr('disabled');
+        };
+        $form.find('button').attr('disabled', 'disabled');
+        xhr.send($form.serialize());
+    });
 }
 
 function initInstall() {
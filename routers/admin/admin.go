@@ -5,6 +5,7 @@
 package admin
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 	"strings"
@@ -16,6 +17,7 @@ import (
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/cron"
+	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/middleware"
 	"github.com/gogits/gogs/modules/process"
 	"github.com/gogits/gogs/modules/setting"
@@ -147,8 +149,12 @@ func Dashboard(ctx *middleware.Context) {
 			success = ctx.Tr("admin.dashboard.git_gc_repos_success")
 			err = models.GitGcRepos()
 		case SYNC_SSH_AUTHORIZED_KEY:
-			success = ctx.Tr("admin.dashboard.resync_all_sshkeys_success")
-			err = models.RewriteAllPublicKeys()
+			var result *models.PublicKeysRewriteResult
+			if result, err = models.RewriteAllPublicKeysOnce(); err == nil {
+				success = ctx.Tr("admin.dashboard.resync_all_sshkeys_result", result.NumKeys, result.Duration)
+			} else if err == models.ErrKeysRewriteInProgress {
+				err = errors.New(ctx.Tr("admin.dashboard.resync_all_sshkeys_in_progress"))
+			}
 		case SYNC_REPOSITORY_UPDATE_HOOK:
 			success = ctx.Tr("admin.dashboard.resync_all_update_hooks_success")
 			err = models.RewriteRepositoryUpdateHook()
@@ -164,6 +170,12 @@ func Dashboard(ctx *middleware.Context) {
 	}
 
 	ctx.Data["Stats"] = models.GetStatistic()
+	ctx.Data["FailingKeyReplicas"] = models.CountFailingKeyReplicas()
+	if diskStats, err := GetDiskUsageStats(); err != nil {
+		log.Error(4, "GetDiskUsageStats: %v", err)
+	} else {
+		ctx.Data["DiskStats"] = diskStats
+	}
 	// FIXME: update periodically
 	updateSystemStatus()
 	ctx.Data["SysStatus"] = sysStatus
@@ -228,7 +240,8 @@ func Monitor(ctx *middleware.Context) {
 	ctx.Data["Title"] = ctx.Tr("admin.monitor")
 	ctx.Data["PageIsAdmin"] = true
 	ctx.Data["PageIsAdminMonitor"] = true
-	ctx.Data["Processes"] = process.Processes
+	ctx.Data["Processes"] = process.List()
+	ctx.Data["NumKilledStale"] = process.NumKilledStale()
 	ctx.Data["Entries"] = cron.ListEntries()
 	ctx.HTML(200, MONITOR)
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/auth"
 	"github.com/gogits/gogs/modules/auth/ldap"
+	"github.com/gogits/gogs/modules/auth/saml"
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/middleware"
@@ -75,6 +76,10 @@ func NewAuthSourcePost(ctx *middleware.Context, form auth.AuthenticationForm) {
 				MsAdSAFormat:      form.MsAdSA,
 				Enabled:           true,
 				Name:              form.AuthName,
+
+				AttributeSSHPublicKey: form.AttributeSSHKey,
+				BindDN:                form.BindDN,
+				BindPassword:          form.BindPassword,
 			},
 		}
 	case models.SMTP:
@@ -84,6 +89,18 @@ func NewAuthSourcePost(ctx *middleware.Context, form auth.AuthenticationForm) {
 			Port: form.SmtpPort,
 			TLS:  form.Tls,
 		}
+	case models.SAML:
+		u = &models.SAMLConfig{
+			SAMLSource: saml.SAMLSource{
+				MetadataURL:       form.MetadataURL,
+				EntityId:          form.EntityId,
+				CertificatePEM:    form.CertificatePEM,
+				PrivateKeyPEM:     form.PrivateKeyPEM,
+				AttributeUsername: form.AttributeUsername,
+				AttributeFullName: form.AttributeFullName,
+				AttributeMail:     form.AttributeMail,
+			},
+		}
 	default:
 		ctx.Error(400)
 		return
@@ -157,6 +174,10 @@ func EditAuthSourcePost(ctx *middleware.Context, form auth.AuthenticationForm) {
 				MsAdSAFormat:      form.MsAdSA,
 				Enabled:           true,
 				Name:              form.AuthName,
+
+				AttributeSSHPublicKey: form.AttributeSSHKey,
+				BindDN:                form.BindDN,
+				BindPassword:          form.BindPassword,
 			},
 		}
 	case models.SMTP:
@@ -166,11 +187,30 @@ func EditAuthSourcePost(ctx *middleware.Context, form auth.AuthenticationForm) {
 			Port: form.SmtpPort,
 			TLS:  form.Tls,
 		}
+	case models.SAML:
+		config = &models.SAMLConfig{
+			SAMLSource: saml.SAMLSource{
+				MetadataURL:       form.MetadataURL,
+				EntityId:          form.EntityId,
+				CertificatePEM:    form.CertificatePEM,
+				PrivateKeyPEM:     form.PrivateKeyPEM,
+				AttributeUsername: form.AttributeUsername,
+				AttributeFullName: form.AttributeFullName,
+				AttributeMail:     form.AttributeMail,
+			},
+		}
 	default:
 		ctx.Error(400)
 		return
 	}
 
+	// Bind password is not shown in form, keep current one when it is left empty.
+	if cfg, ok := config.(*models.LDAPConfig); ok && len(cfg.BindPassword) == 0 {
+		if source, err := models.GetLoginSourceById(form.Id); err == nil && source.Type == models.LDAP {
+			cfg.BindPassword = source.LDAP().BindPassword
+		}
+	}
+
 	u := models.LoginSource{
 		Id:                form.Id,
 		Name:              form.AuthName,
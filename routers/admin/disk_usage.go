@@ -0,0 +1,194 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+const _DISK_USAGE_CACHE_TTL = 30 * time.Minute
+
+// DiskStats represents disk usage of data stored by Gogs.
+type DiskStats struct {
+	TotalRepos               int64
+	TotalRepoSizeBytes       int64
+	TotalAttachmentSizeBytes int64
+	TotalAvatarSizeBytes     int64
+	TotalLFSSizeBytes        int64 // Git LFS is not supported yet, so it is always 0.
+	Updated                  time.Time
+}
+
+// DiskUsageCategory represents a category of disk usage as a slice of pie chart.
+type DiskUsageCategory struct {
+	Name     string // Locale key.
+	Size     int64
+	Percent  float64
+	Color    string
+	Arc      string // SVG path of the slice, empty when IsFull.
+	IsFull   bool   // Category takes the whole chart.
+	Exceeded bool   // Size is above warning threshold.
+}
+
+// Categories returns disk usage categories along with slices of pie chart
+// centered at (50, 50) with radius of 50.
+func (s *DiskStats) Categories() []*DiskUsageCategory {
+	cats := []*DiskUsageCategory{
+		{Name: "admin.dashboard.disk_usage_repos", Size: s.TotalRepoSizeBytes, Color: "#4183c4"},
+		{Name: "admin.dashboard.disk_usage_attachments", Size: s.TotalAttachmentSizeBytes, Color: "#6cc644"},
+		{Name: "admin.dashboard.disk_usage_avatars", Size: s.TotalAvatarSizeBytes, Color: "#f29513"},
+		{Name: "admin.dashboard.disk_usage_lfs", Size: s.TotalLFSSizeBytes, Color: "#bd2c00"},
+	}
+
+	var total int64
+	for _, c := range cats {
+		total += c.Size
+		c.Exceeded = setting.DiskUsageWarnThreshold > 0 && c.Size > setting.DiskUsageWarnThreshold
+	}
+	if total == 0 {
+		return cats
+	}
+
+	angle := 0.0
+	for _, c := range cats {
+		if c.Size == 0 {
+			continue
+		}
+		c.Percent = float64(c.Size) * 100 / float64(total)
+		if c.Size == total {
+			c.IsFull = true
+			continue
+		}
+
+		next := angle + float64(c.Size)/float64(total)*2*math.Pi
+		largeArc := 0
+		if next-angle > math.Pi {
+			largeArc = 1
+		}
+		c.Arc = fmt.Sprintf("M50,50 L%.3f,%.3f A50,50 0 %d,1 %.3f,%.3f Z",
+			50+50*math.Sin(angle), 50-50*math.Cos(angle), largeArc,
+			50+50*math.Sin(next), 50-50*math.Cos(next))
+		angle = next
+	}
+	return cats
+}
+
+// HasExceeded returns true if any category is above warning threshold.
+func (s *DiskStats) HasExceeded() bool {
+	for _, c := range s.Categories() {
+		if c.Exceeded {
+			return true
+		}
+	}
+	return false
+}
+
+// dirSize returns total size of regular files in given directory,
+// which is 0 when directory does not exist.
+func dirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func calculateDiskUsage() (_ *DiskStats, err error) {
+	stats := &DiskStats{Updated: time.Now()}
+
+	repoPaths, err := filepath.Glob(filepath.Join(setting.RepoRootPath, "*", "*.git"))
+	if err != nil {
+		return nil, fmt.Errorf("list repositories: %v", err)
+	}
+	stats.TotalRepos = int64(len(repoPaths))
+
+	if stats.TotalRepoSizeBytes, err = dirSize(setting.RepoRootPath); err != nil {
+		return nil, fmt.Errorf("repositories: %v", err)
+	} else if stats.TotalAttachmentSizeBytes, err = dirSize(setting.AttachmentPath); err != nil {
+		return nil, fmt.Errorf("attachments: %v", err)
+	} else if stats.TotalAvatarSizeBytes, err = dirSize(setting.AvatarUploadPath); err != nil {
+		return nil, fmt.Errorf("avatars: %v", err)
+	}
+	return stats, nil
+}
+
+var diskUsage struct {
+	sync.Mutex
+	stats *DiskStats
+
+	// calculating serializes traversals, so that the mutex above
+	// is never held while directories are walked.
+	calculating sync.Mutex
+}
+
+// cachedDiskUsage returns cached disk usage or nil if it has expired.
+func cachedDiskUsage() *DiskStats {
+	diskUsage.Lock()
+	defer diskUsage.Unlock()
+
+	if diskUsage.stats != nil && time.Since(diskUsage.stats.Updated) < _DISK_USAGE_CACHE_TTL {
+		return diskUsage.stats
+	}
+	return nil
+}
+
+// updateDiskUsage calculates disk usage and caches the result,
+// unless force is false and it has been calculated while waiting.
+func updateDiskUsage(force bool) (*DiskStats, error) {
+	diskUsage.calculating.Lock()
+	defer diskUsage.calculating.Unlock()
+
+	if !force {
+		if stats := cachedDiskUsage(); stats != nil {
+			return stats, nil
+		}
+	}
+
+	stats, err := calculateDiskUsage()
+	if err != nil {
+		return nil, err
+	}
+	diskUsage.Lock()
+	diskUsage.stats = stats
+	diskUsage.Unlock()
+	return stats, nil
+}
+
+// GetDiskUsageStats returns disk usage of repositories, attachments and avatars,
+// result is cached because traversing directories can take a long time.
+func GetDiskUsageStats() (*DiskStats, error) {
+	if stats := cachedDiskUsage(); stats != nil {
+		return stats, nil
+	}
+	return updateDiskUsage(false)
+}
+
+// RecalculateDiskUsage drops cached disk usage and calculates it again.
+func RecalculateDiskUsage(ctx *middleware.Context) {
+	if _, err := updateDiskUsage(true); err != nil {
+		log.Error(4, "updateDiskUsage: %v", err)
+		ctx.Flash.Error(ctx.Tr("admin.dashboard.disk_usage_failed", err))
+	} else {
+		ctx.Flash.Success(ctx.Tr("admin.dashboard.disk_usage_recalculated"))
+	}
+	ctx.Redirect(setting.AppSubUrl + "/admin")
+}
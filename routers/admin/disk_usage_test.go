@@ -0,0 +1,94 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestDiskStatsCategories(t *testing.T) {
+	oldThreshold := setting.DiskUsageWarnThreshold
+	defer func() { setting.DiskUsageWarnThreshold = oldThreshold }()
+	setting.DiskUsageWarnThreshold = 100
+
+	stats := &DiskStats{TotalRepoSizeBytes: 150, TotalAttachmentSizeBytes: 50}
+	cats := stats.Categories()
+	if cats[0].Percent != 75 || cats[1].Percent != 25 || cats[2].Percent != 0 {
+		t.Errorf("unexpected percents: %v, %v, %v", cats[0].Percent, cats[1].Percent, cats[2].Percent)
+	}
+	if len(cats[0].Arc) == 0 || len(cats[1].Arc) == 0 || len(cats[2].Arc) > 0 {
+		t.Errorf("expect arcs only for non-empty categories but got %q, %q, %q", cats[0].Arc, cats[1].Arc, cats[2].Arc)
+	}
+	if !cats[0].Exceeded || cats[1].Exceeded || !stats.HasExceeded() {
+		t.Error("expect only repositories to exceed threshold")
+	}
+
+	stats = &DiskStats{TotalAvatarSizeBytes: 10}
+	if cats = stats.Categories(); !cats[2].IsFull || len(cats[2].Arc) > 0 {
+		t.Errorf("expect single category to take whole chart but got %+v", cats[2])
+	}
+	if stats.HasExceeded() {
+		t.Error("expect no category to exceed threshold")
+	}
+}
+
+func TestGetDiskUsageStats(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gogs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldRepoRootPath, oldAttachmentPath, oldAvatarUploadPath := setting.RepoRootPath, setting.AttachmentPath, setting.AvatarUploadPath
+	defer func() {
+		setting.RepoRootPath, setting.AttachmentPath, setting.AvatarUploadPath = oldRepoRootPath, oldAttachmentPath, oldAvatarUploadPath
+		diskUsage.stats = nil
+	}()
+	setting.RepoRootPath = filepath.Join(tmpDir, "repos")
+	setting.AttachmentPath = filepath.Join(tmpDir, "attachments")
+	setting.AvatarUploadPath = filepath.Join(tmpDir, "avatars")
+	diskUsage.stats = nil
+
+	repoPath := filepath.Join(setting.RepoRootPath, "user1", "repo1.git")
+	if err = os.MkdirAll(repoPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(repoPath, "HEAD"), make([]byte, 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := GetDiskUsageStats()
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.TotalRepos != 1 || stats.TotalRepoSizeBytes != 10 ||
+		stats.TotalAttachmentSizeBytes != 0 || stats.TotalAvatarSizeBytes != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// Cached result is returned until it is recalculated.
+	if err = ioutil.WriteFile(filepath.Join(repoPath, "config"), make([]byte, 5), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = GetDiskUsageStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.TotalRepoSizeBytes != 10 {
+		t.Errorf("expect cached size 10 but got %d", stats.TotalRepoSizeBytes)
+	}
+	if stats, err = updateDiskUsage(true); err != nil {
+		t.Fatal(err)
+	} else if stats.TotalRepoSizeBytes != 15 {
+		t.Errorf("expect recalculated size 15 but got %d", stats.TotalRepoSizeBytes)
+	}
+	if stats, err = GetDiskUsageStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.TotalRepoSizeBytes != 15 {
+		t.Errorf("expect recalculated size to be cached but got %d", stats.TotalRepoSizeBytes)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+const (
+	HOOKS base.TplName = "admin/hooks"
+)
+
+// Webhooks lists system webhooks, which receive events of SSH keys of all users.
+func Webhooks(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("admin.hooks")
+	ctx.Data["PageIsAdminHooks"] = true
+
+	// Delete web hook.
+	remove := com.StrTo(ctx.Query("remove")).MustInt64()
+	if remove > 0 {
+		w, err := models.GetWebhookById(remove)
+		if err != nil {
+			if err == models.ErrWebhookNotExist {
+				ctx.Handle(404, "GetWebhookById", nil)
+			} else {
+				ctx.Handle(500, "GetWebhookById", err)
+			}
+			return
+		} else if !w.IsSystem() {
+			ctx.Handle(404, "GetWebhookById", nil)
+			return
+		}
+
+		if err = models.DeleteWebhook(remove); err != nil {
+			ctx.Handle(500, "DeleteWebhook", err)
+			return
+		}
+		log.Trace("System webhook deleted by admin(%s): %d", ctx.User.Name, remove)
+		ctx.Flash.Success(ctx.Tr("repo.settings.remove_hook_success"))
+		ctx.Redirect(setting.AppSubUrl + "/admin/hooks")
+		return
+	}
+
+	ws, err := models.GetSystemWebhooks()
+	if err != nil {
+		ctx.Handle(500, "GetSystemWebhooks", err)
+		return
+	}
+	ctx.Data["Webhooks"] = ws
+	ctx.HTML(200, HOOKS)
+}
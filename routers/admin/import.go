@@ -0,0 +1,67 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gogits/gogs/models/import"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+const (
+	IMPORT_GITLAB base.TplName = "admin/import/gitlab"
+)
+
+func ImportGitLab(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("admin.import_gitlab")
+	ctx.Data["PageIsAdmin"] = true
+	ctx.Data["PageIsAdminImport"] = true
+	ctx.HTML(200, IMPORT_GITLAB)
+}
+
+// ImportGitLabPost imports projects and SSH keys, or only SSH keys from GitLab,
+// progress is streamed to client as server-sent events.
+func ImportGitLabPost(ctx *middleware.Context) {
+	token := ctx.Query("token")
+	gitlabURL := ctx.Query("url")
+	if len(token) == 0 || !strings.HasPrefix(gitlabURL, "http") {
+		ctx.Error(422, ctx.Tr("admin.import_gitlab.invalid_params"))
+		return
+	}
+
+	ctx.Resp.Header().Set("Content-Type", "text/event-stream")
+	ctx.Resp.Header().Set("Cache-Control", "no-cache")
+	ctx.Resp.WriteHeader(200)
+	send := func(event, data string) {
+		fmt.Fprintf(ctx.Resp, "event: %s\ndata: %s\n\n", event, strings.Replace(data, "\n", " ", -1))
+		ctx.Resp.Flush()
+	}
+
+	progress := func(msg string) {
+		send("progress", msg)
+	}
+	log.Trace("GitLab import started by admin(%s): %s", ctx.User.Name, gitlabURL)
+	var report *importer.ImportReport
+	var err error
+	if ctx.Query("keys_only") == "on" {
+		report, err = importer.ImportKeysFromGitLab(token, gitlabURL, progress)
+	} else {
+		report, err = importer.ImportFromGitLabWithProgress(token, gitlabURL, progress)
+	}
+	if err != nil {
+		log.Error(4, "ImportFromGitLab(%s): %v", gitlabURL, err)
+		send("error", err.Error())
+	}
+	if report != nil {
+		data, _ := json.Marshal(report)
+		send("done", string(data))
+		log.Trace("GitLab import finished by admin(%s): %s", ctx.User.Name, data)
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"html/template"
+	"net/url"
+	"time"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/auth"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/mailer"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+const (
+	KEYS           base.TplName = "admin/key/list"
+	KEY_ACTIVITIES base.TplName = "admin/key/activity"
+	SECURITY_LOGS  base.TplName = "admin/key/security"
+	KEYS_IMPORT    base.TplName = "admin/key/import"
+	KEY_REPLICAS   base.TplName = "admin/key/replication"
+)
+
+// parseKeySearchOptions parses filters of public keys from query parameters.
+func parseKeySearchOptions(ctx *middleware.Context) *models.PublicKeySearchOptions {
+	opts := &models.PublicKeySearchOptions{
+		Type:              ctx.Query("type"),
+		MinSize:           com.StrTo(ctx.Query("min_size")).MustInt(),
+		MaxSize:           com.StrTo(ctx.Query("max_size")).MustInt(),
+		FingerprintPrefix: ctx.Query("fingerprint"),
+		OnlyPending:       ctx.QueryInt("pending") == 1,
+	}
+
+	query := url.Values{}
+	for _, key := range []string{"user", "type", "min_size", "max_size", "fingerprint", "used_before", "used_after", "pending"} {
+		if val := ctx.Query(key); len(val) > 0 {
+			query.Set(key, val)
+			ctx.Data["Filter_"+key] = val
+		}
+	}
+	ctx.Data["FilterQuery"] = query.Encode()
+
+	if userName := ctx.Query("user"); len(userName) > 0 {
+		u, err := models.GetUserByName(userName)
+		if err != nil {
+			if err == models.ErrUserNotExist {
+				ctx.Handle(404, "GetUserByName", err)
+			} else {
+				ctx.Handle(500, "GetUserByName", err)
+			}
+			return nil
+		}
+		opts.OwnerId = u.Id
+	}
+	if t, err := time.Parse("2006-01-02", ctx.Query("used_before")); err == nil {
+		opts.UsedBefore = t
+	}
+	if t, err := time.Parse("2006-01-02", ctx.Query("used_after")); err == nil {
+		opts.UsedAfter = t
+	}
+	return opts
+}
+
+// Keys shows all SSH keys that match filters.
+func Keys(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("admin.keys")
+	ctx.Data["PageIsAdmin"] = true
+	ctx.Data["PageIsAdminKeys"] = true
+
+	opts := parseKeySearchOptions(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	pageNum := 50
+	opts.Page = ctx.QueryInt("p")
+	opts.PageSize = pageNum
+	keys, total, err := models.SearchPublicKeys(opts)
+	if err != nil {
+		ctx.Handle(500, "SearchPublicKeys", err)
+		return
+	}
+	pagination(ctx, total, pageNum)
+
+	owners := make(map[int64]*models.User)
+	for _, key := range keys {
+		if _, ok := owners[key.OwnerId]; ok {
+			continue
+		}
+		if owners[key.OwnerId], err = models.GetUserById(key.OwnerId); err != nil {
+			ctx.Handle(500, "GetUserById", err)
+			return
+		}
+	}
+	ctx.Data["Keys"] = keys
+	ctx.Data["Owners"] = owners
+	ctx.Data["Total"] = total
+	ctx.HTML(200, KEYS)
+}
+
+// ExportKeys exports all SSH keys that match filters as CSV file.
+func ExportKeys(ctx *middleware.Context) {
+	opts := parseKeySearchOptions(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	keys, _, err := models.SearchPublicKeys(opts)
+	if err != nil {
+		ctx.Handle(500, "SearchPublicKeys", err)
+		return
+	}
+
+	ctx.Resp.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	ctx.Resp.Header().Set("Content-Disposition", "attachment; filename=ssh_keys.csv")
+	w := csv.NewWriter(ctx.Resp)
+	w.Write([]string{"id", "owner", "name", "type", "size", "fingerprint", "disabled", "created", "last_used"})
+	owners := make(map[int64]string)
+	for _, key := range keys {
+		if _, ok := owners[key.OwnerId]; !ok {
+			if u, err := models.GetUserById(key.OwnerId); err == nil {
+				owners[key.OwnerId] = u.Name
+			}
+		}
+		lastUsed := ""
+		if !key.Updated.IsZero() {
+			lastUsed = key.Updated.Format(time.RFC3339)
+		}
+		w.Write([]string{com.ToStr(key.Id), owners[key.OwnerId], key.Name, key.Type, com.ToStr(key.Size),
+			key.Fingerprint, com.ToStr(key.IsDisabled), key.Created.Format(time.RFC3339), lastUsed})
+	}
+	w.Flush()
+}
+
+func ImportKeys(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("admin.keys.import")
+	ctx.Data["PageIsAdminKeys"] = true
+	ctx.HTML(200, KEYS_IMPORT)
+}
+
+// ImportKeysPost adds public keys listed in uploaded CSV file,
+// and shows what happened to each row with a downloadable report.
+func ImportKeysPost(ctx *middleware.Context, form auth.ImportKeysForm) {
+	ctx.Data["Title"] = ctx.Tr("admin.keys.import")
+	ctx.Data["PageIsAdminKeys"] = true
+
+	if form.File == nil {
+		ctx.RenderWithErr(ctx.Tr("admin.keys.import_no_file"), KEYS_IMPORT, nil)
+		return
+	}
+	fr, err := form.File.Open()
+	if err != nil {
+		ctx.Handle(500, "Open", err)
+		return
+	}
+	defer fr.Close()
+
+	// Rows processed before an error are still reported.
+	results, err := models.BulkImportPublicKeys(fr, adminActor(ctx))
+	if err != nil {
+		log.Error(4, "BulkImportPublicKeys: %v", err)
+		ctx.Flash.ErrorMsg = ctx.Tr("admin.keys.import_failed", err)
+		ctx.Data["Flash"] = ctx.Flash
+	}
+	log.Trace("SSH keys imported from %s by admin(%s): %d rows", form.File.Filename, ctx.User.Name, len(results))
+
+	stats := make(map[string]int)
+	for _, r := range results {
+		stats[r.Status]++
+	}
+	var report bytes.Buffer
+	if err = models.WriteBulkKeyImportReport(&report, results); err != nil {
+		ctx.Handle(500, "WriteBulkKeyImportReport", err)
+		return
+	}
+	ctx.Data["ImportResults"] = results
+	ctx.Data["ImportStats"] = stats
+	ctx.Data["ReportURL"] = template.URL("data:text/csv;charset=utf-8;base64," + base64.StdEncoding.EncodeToString(report.Bytes()))
+	ctx.HTML(200, KEYS_IMPORT)
+}
+
+// KeyReplication shows state of replicating authorized_keys file to each configured target.
+func KeyReplication(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("admin.keys.replication")
+	ctx.Data["PageIsAdminKeys"] = true
+
+	replicas, err := models.GetKeyReplicas()
+	if err != nil {
+		ctx.Handle(500, "GetKeyReplicas", err)
+		return
+	}
+	ctx.Data["Replicas"] = replicas
+	ctx.Data["IsReplicationEnabled"] = setting.SSHReplication.Enabled
+	ctx.Data["IsReplicating"] = models.IsKeyReplicating()
+	ctx.HTML(200, KEY_REPLICAS)
+}
+
+// KeyReplicationSync replicates authorized_keys file to all targets in background.
+func KeyReplicationSync(ctx *middleware.Context) {
+	if models.QueueKeyReplication() {
+		ctx.Flash.Success(ctx.Tr("admin.keys.replication_queued"))
+	} else {
+		ctx.Flash.Error(ctx.Tr("admin.keys.replication_disabled"))
+	}
+	ctx.Redirect(setting.AppSubUrl + "/admin/keys/replication")
+}
+
+// adminActor returns signed in admin as actor of security log.
+func adminActor(ctx *middleware.Context) models.SecurityActor {
+	return models.SecurityActor{models.SECURITY_ACTOR_ADMIN, ctx.User.Id, ctx.User.Name}
+}
+
+// getKeyAndOwner returns public key by ID in URL and its owner.
+func getKeyAndOwner(ctx *middleware.Context) (*models.PublicKey, *models.User) {
+	key, err := models.GetPublicKeyById(com.StrTo(ctx.Params(":id")).MustInt64())
+	if err != nil {
+		if err == models.ErrKeyNotExist {
+			ctx.Handle(404, "GetPublicKeyById", err)
+		} else {
+			ctx.Handle(500, "GetPublicKeyById", err)
+		}
+		return nil, nil
+	}
+	owner, err := models.GetUserById(key.OwnerId)
+	if err != nil {
+		ctx.Handle(500, "GetUserById", err)
+		return nil, nil
+	}
+	return key, owner
+}
+
+// DeleteKey deletes SSH key and notifies its owner.
+func DeleteKey(ctx *middleware.Context) {
+	key, owner := getKeyAndOwner(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	if err := models.DeletePublicKeyAdmin(key.Id, adminActor(ctx)); err != nil {
+		ctx.Handle(500, "DeletePublicKeyAdmin", err)
+		return
+	}
+	log.Trace("SSH key(%d) of %s deleted by admin(%s)", key.Id, owner.Name, ctx.User.Name)
+	mailer.SendSSHKeyAdminMail(ctx.Render, owner, key, "deleted", "")
+
+	ctx.Flash.Success(ctx.Tr("admin.keys.deletion_success"))
+	ctx.Redirect(setting.AppSubUrl + "/admin/keys?" + ctx.Query("query"))
+}
+
+// ToggleKey disables or enables SSH key and notifies its owner.
+func ToggleKey(ctx *middleware.Context) {
+	key, owner := getKeyAndOwner(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	if err := models.SetPublicKeyDisabled(key, !key.IsDisabled, adminActor(ctx)); err != nil {
+		ctx.Handle(500, "SetPublicKeyDisabled", err)
+		return
+	}
+	action := "enabled"
+	if key.IsDisabled {
+		action = "disabled"
+	}
+	log.Trace("SSH key(%d) of %s %s by admin(%s)", key.Id, owner.Name, action, ctx.User.Name)
+	mailer.SendSSHKeyAdminMail(ctx.Render, owner, key, action, "")
+
+	ctx.Flash.Success(ctx.Tr("admin.keys.update_success"))
+	ctx.Redirect(setting.AppSubUrl + "/admin/keys?" + ctx.Query("query"))
+}
+
+// ApproveKey activates pending SSH key and notifies its owner.
+func ApproveKey(ctx *middleware.Context) {
+	key, owner := getKeyAndOwner(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	if err := models.ApprovePublicKey(key, adminActor(ctx)); err != nil {
+		ctx.Handle(500, "ApprovePublicKey", err)
+		return
+	}
+	log.Trace("SSH key(%d) of %s approved by admin(%s)", key.Id, owner.Name, ctx.User.Name)
+	mailer.SendSSHKeyAdminMail(ctx.Render, owner, key, "approved", "")
+
+	ctx.Flash.Success(ctx.Tr("admin.keys.approve_success", 1))
+	ctx.Redirect(setting.AppSubUrl + "/admin/keys?" + ctx.Query("query"))
+}
+
+// BulkApproveKeys activates all selected pending SSH keys and notifies their owners.
+func BulkApproveKeys(ctx *middleware.Context) {
+	ctx.Req.ParseForm()
+	approved := 0
+	for _, id := range ctx.Req.Form["ids"] {
+		key, err := models.GetPublicKeyById(com.StrTo(id).MustInt64())
+		if err != nil {
+			if err == models.ErrKeyNotExist {
+				continue
+			}
+			ctx.Handle(500, "GetPublicKeyById", err)
+			return
+		} else if !key.IsPending {
+			continue
+		}
+
+		owner, err := models.GetUserById(key.OwnerId)
+		if err != nil {
+			ctx.Handle(500, "GetUserById", err)
+			return
+		}
+		if err = models.ApprovePublicKey(key, adminActor(ctx)); err != nil {
+			ctx.Handle(500, "ApprovePublicKey", err)
+			return
+		}
+		log.Trace("SSH key(%d) of %s approved by admin(%s)", key.Id, owner.Name, ctx.User.Name)
+		mailer.SendSSHKeyAdminMail(ctx.Render, owner, key, "approved", "")
+		approved++
+	}
+
+	ctx.Flash.Success(ctx.Tr("admin.keys.approve_success", approved))
+	ctx.Redirect(setting.AppSubUrl + "/admin/keys?" + ctx.Query("query"))
+}
+
+// RejectKey deletes pending SSH key and sends reason to its owner.
+func RejectKey(ctx *middleware.Context) {
+	key, owner := getKeyAndOwner(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	if err := models.RejectPublicKey(key, adminActor(ctx)); err != nil {
+		if err == models.ErrKeyNotPending {
+			ctx.Flash.Error(ctx.Tr("admin.keys.reject_not_pending", key.Name))
+			ctx.Redirect(setting.AppSubUrl + "/admin/keys?" + ctx.Query("query"))
+		} else {
+			ctx.Handle(500, "RejectPublicKey", err)
+		}
+		return
+	}
+	log.Trace("SSH key(%d) of %s rejected by admin(%s)", key.Id, owner.Name, ctx.User.Name)
+	mailer.SendSSHKeyAdminMail(ctx.Render, owner, key, "rejected", ctx.Query("reason"))
+
+	ctx.Flash.Success(ctx.Tr("admin.keys.reject_success"))
+	ctx.Redirect(setting.AppSubUrl + "/admin/keys?" + ctx.Query("query"))
+}
+
+// KeyActivities shows audit log of SSH key operations,
+// optionally filtered by repository and user.
+func KeyActivities(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("admin.key_activities")
+	ctx.Data["PageIsAdmin"] = true
+	ctx.Data["PageIsAdminKeyActivities"] = true
+
+	var repoID, ownerID int64
+	query := url.Values{}
+	if repoRef := ctx.Query("repo"); len(repoRef) > 0 {
+		repo, err := models.GetRepositoryByRef(repoRef)
+		if err != nil {
+			if err == models.ErrInvalidReference || err == models.ErrUserNotExist || models.IsErrRepoNotExist(err) {
+				ctx.Handle(404, "GetRepositoryByRef", err)
+			} else {
+				ctx.Handle(500, "GetRepositoryByRef", err)
+			}
+			return
+		}
+		repoID = repo.Id
+		query.Set("repo", repoRef)
+	}
+	if userName := ctx.Query("user"); len(userName) > 0 {
+		u, err := models.GetUserByName(userName)
+		if err != nil {
+			if err == models.ErrUserNotExist {
+				ctx.Handle(404, "GetUserByName", err)
+			} else {
+				ctx.Handle(500, "GetUserByName", err)
+			}
+			return
+		}
+		ownerID = u.Id
+		query.Set("user", userName)
+	}
+	ctx.Data["FilterRepo"] = query.Get("repo")
+	ctx.Data["FilterUser"] = query.Get("user")
+	ctx.Data["FilterQuery"] = query.Encode()
+
+	p := pagination(ctx, models.CountKeyActivities(repoID, ownerID), models.KEY_ACTIVITY_PAGE_SIZE)
+	acts, err := models.SearchKeyActivities(repoID, ownerID, p)
+	if err != nil {
+		ctx.Handle(500, "SearchKeyActivities", err)
+		return
+	}
+	ctx.Data["Activities"] = acts
+	ctx.HTML(200, KEY_ACTIVITIES)
+}
+
+// SecurityLogs shows security logs of all users for incident reviews,
+// optionally filtered by user.
+func SecurityLogs(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("admin.security_logs")
+	ctx.Data["PageIsAdmin"] = true
+	ctx.Data["PageIsAdminSecurityLogs"] = true
+
+	var ownerID int64
+	query := url.Values{}
+	if userName := ctx.Query("user"); len(userName) > 0 {
+		u, err := models.GetUserByName(userName)
+		if err != nil {
+			if err == models.ErrUserNotExist {
+				ctx.Handle(404, "GetUserByName", err)
+			} else {
+				ctx.Handle(500, "GetUserByName", err)
+			}
+			return
+		}
+		ownerID = u.Id
+		query.Set("user", userName)
+	}
+	ctx.Data["FilterUser"] = query.Get("user")
+	ctx.Data["FilterQuery"] = query.Encode()
+
+	p := pagination(ctx, models.CountSecurityLogs(ownerID), models.SECURITY_LOG_PAGE_SIZE)
+	logs, err := models.SearchSecurityLogs(ownerID, p)
+	if err != nil {
+		ctx.Handle(500, "SearchSecurityLogs", err)
+		return
+	}
+	ctx.Data["Logs"] = logs
+	ctx.HTML(200, SECURITY_LOGS)
+}
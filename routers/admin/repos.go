@@ -5,9 +5,13 @@
 package admin
 
 import (
+	"github.com/Unknwon/com"
+
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
 )
 
 const (
@@ -28,5 +32,122 @@ func Repositories(ctx *middleware.Context) {
 		ctx.Handle(500, "GetRepositoriesWithUsers", err)
 		return
 	}
+
+	ctx.Data["SSHMirrors"], err = models.GetSSHMirrors()
+	if err != nil {
+		ctx.Handle(500, "GetSSHMirrors", err)
+		return
+	}
+
+	ctx.Data["HealthReports"] = models.GetRepoHealthReports()
+	ctx.Data["IsCheckingAllRepoHealth"] = models.IsCheckingAllRepoHealth()
+
+	ctx.Data["TrashEnabled"] = len(setting.RepoTrashPath) > 0
+	ctx.Data["TrashRepos"], err = models.ListTrashRepositories()
+	if err != nil {
+		ctx.Handle(500, "ListTrashRepositories", err)
+		return
+	}
 	ctx.HTML(200, REPOS)
 }
+
+// RestoreRepository restores deleted repository from trash to its original owner.
+func RestoreRepository(ctx *middleware.Context) {
+	trashPath := ctx.Query("path")
+	repo, err := models.AdminRestoreRepository(trashPath)
+	if err != nil {
+		switch err {
+		case models.ErrRepoTrashDisabled, models.ErrTrashRepoNotExist:
+			ctx.Handle(404, "AdminRestoreRepository", err)
+		case models.ErrUserNotExist:
+			ctx.Flash.Error(ctx.Tr("admin.repos.restore_owner_not_exist", trashPath))
+			ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+		case models.ErrRepoAlreadyExist:
+			ctx.Flash.Error(ctx.Tr("admin.repos.restore_repo_exist", trashPath))
+			ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+		default:
+			ctx.Handle(500, "AdminRestoreRepository", err)
+		}
+		return
+	}
+	log.Trace("Repository restored by admin(%s): %s/%s", ctx.User.Name, repo.Owner.Name, repo.Name)
+
+	ctx.Flash.Success(ctx.Tr("admin.repos.restore_success", repo.Owner.Name+"/"+repo.Name))
+	ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+}
+
+// ConfirmMirrorHostKey allows mirror to be synced with its recorded SSH host key.
+func ConfirmMirrorHostKey(ctx *middleware.Context) {
+	id := com.StrTo(ctx.Params(":id")).MustInt64()
+	if err := models.ConfirmMirrorHostKey(id); err != nil {
+		if err == models.ErrMirrorNotExist || err == models.ErrMirrorNotSSH {
+			ctx.Handle(404, "ConfirmMirrorHostKey", err)
+		} else {
+			ctx.Handle(500, "ConfirmMirrorHostKey", err)
+		}
+		return
+	}
+	log.Trace("Mirror(%d) host key confirmed by admin(%s)", id, ctx.User.Name)
+
+	ctx.Flash.Success(ctx.Tr("admin.repos.mirror_host_key_confirmed"))
+	ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+}
+
+// RotateMirrorHostKey scans SSH host key of mirror again, which has to be confirmed afterwards.
+func RotateMirrorHostKey(ctx *middleware.Context) {
+	id := com.StrTo(ctx.Params(":id")).MustInt64()
+	if err := models.RotateMirrorHostKey(id); err != nil {
+		switch err {
+		case models.ErrMirrorNotExist, models.ErrMirrorNotSSH:
+			ctx.Handle(404, "RotateMirrorHostKey", err)
+		default:
+			log.Error(4, "RotateMirrorHostKey(%d): %v", id, err)
+			ctx.Flash.Error(ctx.Tr("admin.repos.mirror_host_key_scan_failed", err.Error()))
+			ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+		}
+		return
+	}
+	log.Trace("Mirror(%d) host key rotated by admin(%s)", id, ctx.User.Name)
+
+	ctx.Flash.Success(ctx.Tr("admin.repos.mirror_host_key_rotated"))
+	ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+}
+
+// CheckRepoHealth checks repository with git fsck and repairs it when possible,
+// result is shown inline in repository list.
+func CheckRepoHealth(ctx *middleware.Context) {
+	id := com.StrTo(ctx.Params(":id")).MustInt64()
+	report, err := models.CheckRepoHealth(id)
+	if err != nil {
+		if models.IsErrRepoNotExist(err) {
+			ctx.Handle(404, "CheckRepoHealth", err)
+			return
+		}
+		log.Error(4, "CheckRepoHealth(%d): %v", id, err)
+		ctx.Flash.Error(ctx.Tr("admin.repos.health_check_failed", err.Error()))
+		ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+		return
+	}
+	log.Trace("Repository(%s) health checked by admin(%s)", report.RepoName, ctx.User.Name)
+
+	switch {
+	case !report.HasErrors:
+		ctx.Flash.Success(ctx.Tr("admin.repos.health_ok", report.RepoName))
+	case report.Repaired:
+		ctx.Flash.Success(ctx.Tr("admin.repos.health_repaired", report.RepoName))
+	default:
+		ctx.Flash.Error(ctx.Tr("admin.repos.health_broken", report.RepoName))
+	}
+	ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+}
+
+// CheckAllRepoHealth queues health checks of all repositories.
+func CheckAllRepoHealth(ctx *middleware.Context) {
+	if models.QueueAllRepoHealthCheck() {
+		log.Trace("Health check of all repositories queued by admin(%s)", ctx.User.Name)
+		ctx.Flash.Success(ctx.Tr("admin.repos.health_all_queued"))
+	} else {
+		ctx.Flash.Error(ctx.Tr("admin.repos.health_all_running"))
+	}
+	ctx.Redirect(setting.AppSubUrl + "/admin/repos")
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/gogits/gogs/modules/auth"
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/mailer"
 	"github.com/gogits/gogs/modules/middleware"
 	"github.com/gogits/gogs/modules/setting"
 )
@@ -182,7 +183,11 @@ func EditUserPost(ctx *middleware.Context, form auth.AdminEditUserForm) {
 		u.EncodePasswd()
 	}
 
-	u.Email = form.Email
+	needConfirm, err := models.SetUserEmail(u, form.Email)
+	if err != nil {
+		ctx.Handle(500, "SetUserEmail", err)
+		return
+	}
 	u.Website = form.Website
 	u.Location = form.Location
 	if len(form.Avatar) == 0 {
@@ -193,6 +198,7 @@ func EditUserPost(ctx *middleware.Context, form auth.AdminEditUserForm) {
 	u.IsActive = form.Active
 	u.IsAdmin = form.Admin
 	u.AllowGitHook = form.AllowGitHook
+	u.MaxSSHKeys = form.MaxSSHKeys
 
 	if err := models.UpdateUser(u); err != nil {
 		if err == models.ErrEmailAlreadyUsed {
@@ -204,6 +210,9 @@ func EditUserPost(ctx *middleware.Context, form auth.AdminEditUserForm) {
 		return
 	}
 	log.Trace("Account profile updated by admin(%s): %s", ctx.User.Name, u.Name)
+	if needConfirm && setting.Service.RegisterEmailConfirm {
+		mailer.SendActiveMail(ctx.Render, u)
+	}
 	ctx.Flash.Success(ctx.Tr("admin.users.update_profile_success"))
 	ctx.Redirect(setting.AppSubUrl + "/admin/users/" + ctx.Params(":userid"))
 }
@@ -221,7 +230,7 @@ func DeleteUser(ctx *middleware.Context) {
 		return
 	}
 
-	if err = models.DeleteUser(u); err != nil {
+	if err = models.DeleteUser(u, adminActor(ctx)); err != nil {
 		switch err {
 		case models.ErrUserOwnRepos:
 			ctx.Flash.Error(ctx.Tr("admin.users.still_own_repo"))
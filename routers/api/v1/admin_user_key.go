@@ -0,0 +1,146 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"time"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/mailer"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+// adminApiActor returns signed in admin as actor of security log.
+func adminApiActor(ctx *middleware.Context) models.SecurityActor {
+	return models.SecurityActor{models.SECURITY_ACTOR_ADMIN, ctx.User.Id, ctx.User.Name}
+}
+
+// getAdminTargetUser returns user specified by ":username" in URL.
+func getAdminTargetUser(ctx *middleware.Context) *models.User {
+	u, err := models.GetUserByName(ctx.Params(":username"))
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetUserByName: " + err.Error(), base.DOC_URL})
+		}
+		return nil
+	}
+	return u
+}
+
+// POST /admin/users/:username/keys
+func AdminCreatePublicKey(ctx *middleware.Context, form CreatePublicKeyOption) {
+	u := getAdminTargetUser(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	content, err := models.ParseKeyString(form.Key)
+	if err != nil {
+		middleware.KeyRateLimitFailure(ctx)
+		ctx.JSON(422, &base.ApiJsonErr{"Invalid SSH key: " + err.Error(), base.DOC_URL})
+		return
+	}
+	if ok, err := models.CheckPublicKeyString(content); !ok && err != models.ErrKeyUnableVerify {
+		middleware.KeyRateLimitFailure(ctx)
+		ctx.JSON(422, &base.ApiJsonErr{"Invalid SSH key: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	key := &models.PublicKey{
+		OwnerId: u.Id,
+		Name:    form.Title,
+		Content: content,
+	}
+	if err = models.AddPublicKey(key, adminApiActor(ctx)); err != nil {
+		switch {
+		case err == models.ErrKeyAlreadyExist, models.IsErrKeyFingerprintAlreadyUsed(err),
+			models.IsErrKeyNameAlreadyUsed(err):
+			ctx.JSON(409, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		case models.IsErrKeyQuotaExceeded(err):
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"AddPublicKey: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	log.Trace("SSH key of %s added by admin(%s) via API", u.Name, ctx.User.Name)
+
+	// Key added by an admin needs no further approval.
+	if key.IsPending {
+		if err = models.ApprovePublicKey(key, adminApiActor(ctx)); err != nil {
+			ctx.JSON(500, &base.ApiJsonErr{"ApprovePublicKey: " + err.Error(), base.DOC_URL})
+			return
+		}
+	}
+	mailer.SendSSHKeyAddedMail(ctx.Render, u, key, "admin")
+	ctx.JSON(201, ToApiPublicKey(key))
+}
+
+// DELETE /admin/users/:username/keys/:id
+func AdminDeletePublicKey(ctx *middleware.Context) {
+	u := getAdminTargetUser(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	id := com.StrTo(ctx.Params(":id")).MustInt64()
+	key, err := models.GetPublicKeyById(id)
+	if err != nil {
+		if err == models.ErrKeyNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetPublicKeyById: " + err.Error(), base.DOC_URL})
+		}
+		return
+	} else if key.OwnerId != u.Id {
+		ctx.Error(404)
+		return
+	}
+
+	if err = models.DeletePublicKey(u.Id, id, adminApiActor(ctx)); err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"DeletePublicKey: " + err.Error(), base.DOC_URL})
+		return
+	}
+	log.Trace("SSH key(%d) of %s deleted by admin(%s) via API", key.Id, u.Name, ctx.User.Name)
+	mailer.SendSSHKeyAdminMail(ctx.Render, u, key, "deleted", "")
+	ctx.WriteHeader(204)
+}
+
+type PublicKeysRewriteResult struct {
+	Keys       int   `json:"keys"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+type PublicKeysRewriteStatus struct {
+	InProgress bool      `json:"in_progress"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// POST /admin/keys/rewrite
+func AdminRewritePublicKeys(ctx *middleware.Context) {
+	if ctx.AccessToken == nil {
+		ctx.HandleAPI(403, "Access token is required")
+		return
+	}
+
+	result, err := models.RewriteAllPublicKeysOnce()
+	if err != nil {
+		if err == models.ErrKeysRewriteInProgress {
+			_, startedAt := models.PublicKeysRewriteStatus()
+			ctx.JSON(409, &PublicKeysRewriteStatus{true, startedAt})
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"RewriteAllPublicKeysOnce: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	log.Trace("authorized_keys rewritten with %d keys in %s by admin(%s) via API", result.NumKeys, result.Duration, ctx.User.Name)
+	ctx.JSON(200, &PublicKeysRewriteResult{result.NumKeys, int64(result.Duration / time.Millisecond)})
+}
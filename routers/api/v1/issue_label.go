@@ -0,0 +1,60 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+type IssueLabelsOption struct {
+	Labels []int `json:"labels"`
+}
+
+type BulkIssueLabelsOption struct {
+	Issues    []int  `json:"issues" binding:"Required"`
+	Labels    []int  `json:"labels"`
+	Operation string `json:"operation" binding:"Required"`
+}
+
+func batchUpdateIssueLabels(ctx *middleware.Context, indexes, labels []int, op string) {
+	if ctx.Repo.AccessMode < models.ACCESS_MODE_WRITE {
+		ctx.Error(403)
+		return
+	}
+
+	if err := models.BatchUpdateIssueLabels(ctx.Repo.Repository.Id, indexes, labels, op); err != nil {
+		switch err {
+		case models.ErrIssueNotExist:
+			ctx.Error(404)
+		case models.ErrLabelNotExist, models.ErrInvalidLabelOp:
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"BatchUpdateIssueLabels: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+// POST /repos/:username/:reponame/issues/:index/labels
+func ReplaceIssueLabels(ctx *middleware.Context, opt IssueLabelsOption) {
+	batchUpdateIssueLabels(ctx, []int{com.StrTo(ctx.Params(":index")).MustInt()},
+		opt.Labels, models.LABEL_OP_REPLACE)
+}
+
+// DELETE /repos/:username/:reponame/issues/:index/labels
+func ClearIssueLabels(ctx *middleware.Context) {
+	batchUpdateIssueLabels(ctx, []int{com.StrTo(ctx.Params(":index")).MustInt()},
+		nil, models.LABEL_OP_REPLACE)
+}
+
+// POST /repos/:username/:reponame/issues/labels/bulk
+func BulkIssueLabels(ctx *middleware.Context, opt BulkIssueLabelsOption) {
+	batchUpdateIssueLabels(ctx, opt.Issues, opt.Labels, opt.Operation)
+}
@@ -0,0 +1,107 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Unknwon/macaron"
+	"github.com/macaron-contrib/binding"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+// newIssueLabelRoutes returns routes of issue labels of given repository
+// as seen by a user with given access mode.
+func newIssueLabelRoutes(repo *models.Repository, mode models.AccessMode) *macaron.Macaron {
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(func(c *macaron.Context) {
+		ctx := &middleware.Context{Context: c, IsSigned: true}
+		ctx.Repo.Repository = repo
+		ctx.Repo.AccessMode = mode
+		c.Map(ctx)
+	})
+	bind := binding.Bind
+	m.Post("/issues/labels/bulk", bind(BulkIssueLabelsOption{}), BulkIssueLabels)
+	m.Combo("/issues/:index:int/labels").Post(bind(IssueLabelsOption{}), ReplaceIssueLabels).
+		Delete(ClearIssueLabels)
+	return m
+}
+
+func TestIssueLabelRoutes(t *testing.T) {
+	defer newTestEngine(t)()
+	var err error
+
+	repo := &models.Repository{Id: 1}
+	bug := &models.Label{RepoId: repo.Id, Name: "bug", Color: "#ee0701"}
+	docs := &models.Label{RepoId: repo.Id, Name: "docs", Color: "#cccccc"}
+	for _, l := range []*models.Label{bug, docs} {
+		if err = models.NewLabel(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := int64(1); i <= 2; i++ {
+		if err = models.NewIssue(&models.Issue{RepoId: repo.Id, Index: i, Name: fmt.Sprintf("issue%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLabels := func(step string, index int64, labelIds string) {
+		issue, err := models.GetIssueByIndex(repo.Id, index)
+		if err != nil {
+			t.Fatal(err)
+		} else if issue.LabelIds != labelIds {
+			t.Errorf("%s: expect labels %q of issue %d but got %q", step, labelIds, index, issue.LabelIds)
+		}
+	}
+	bugIds := fmt.Sprintf("$%d|", bug.Id)
+
+	// Users who can only read cannot change labels.
+	m := newIssueLabelRoutes(repo, models.ACCESS_MODE_READ)
+	if resp := serveRoute(m, "POST", "/issues/1/labels", IssueLabelsOption{[]int{int(bug.Id)}}); resp.Code != 403 {
+		t.Errorf("replace as reader: expect 403 but got %d", resp.Code)
+	}
+	expectLabels("replace as reader", 1, "")
+
+	m = newIssueLabelRoutes(repo, models.ACCESS_MODE_WRITE)
+	if resp := serveRoute(m, "POST", "/issues/labels/bulk", BulkIssueLabelsOption{
+		Issues:    []int{1, 2},
+		Labels:    []int{int(bug.Id)},
+		Operation: models.LABEL_OP_ADD,
+	}); resp.Code != 204 {
+		t.Fatalf("bulk add: expect 204 but got %d %s", resp.Code, resp.Body.String())
+	}
+	expectLabels("bulk add", 1, bugIds)
+	expectLabels("bulk add", 2, bugIds)
+
+	if resp := serveRoute(m, "POST", "/issues/1/labels", IssueLabelsOption{[]int{int(docs.Id)}}); resp.Code != 204 {
+		t.Errorf("replace: expect 204 but got %d %s", resp.Code, resp.Body.String())
+	}
+	expectLabels("replace", 1, fmt.Sprintf("$%d|", docs.Id))
+
+	if resp := serveRoute(m, "DELETE", "/issues/2/labels", nil); resp.Code != 204 {
+		t.Errorf("clear: expect 204 but got %d %s", resp.Code, resp.Body.String())
+	}
+	expectLabels("clear", 2, "")
+
+	for _, c := range []struct {
+		name   string
+		opt    BulkIssueLabelsOption
+		expect int
+	}{
+		{"unknown operation", BulkIssueLabelsOption{[]int{1}, []int{int(bug.Id)}, "toggle"}, 422},
+		{"unknown label", BulkIssueLabelsOption{[]int{1}, []int{9999}, models.LABEL_OP_ADD}, 422},
+		{"unknown issue", BulkIssueLabelsOption{[]int{1, 9999}, []int{int(bug.Id)}, models.LABEL_OP_ADD}, 404},
+	} {
+		if resp := serveRoute(m, "POST", "/issues/labels/bulk", c.opt); resp.Code != c.expect {
+			t.Errorf("%s: expect %d but got %d %s", c.name, c.expect, resp.Code, resp.Body.String())
+		}
+	}
+	expectLabels("failed bulk", 1, fmt.Sprintf("$%d|", docs.Id))
+}
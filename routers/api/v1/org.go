@@ -0,0 +1,239 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Unknwon/com"
+
+	api "github.com/gogits/go-gogs-client"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/mailer"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+const (
+	DEFAULT_PAGE_LIMIT = 30
+	MAX_PAGE_LIMIT     = 100
+)
+
+// Team represents a team of organization.
+type Team struct {
+	Id          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Permission  string `json:"permission"`
+}
+
+// ToApiTeam converts team to API format.
+func ToApiTeam(t *models.Team) *Team {
+	perm := "read"
+	switch t.Authorize {
+	case models.ACCESS_MODE_WRITE:
+		perm = "write"
+	case models.ACCESS_MODE_ADMIN:
+		perm = "admin"
+	case models.ACCESS_MODE_OWNER:
+		perm = "owner"
+	}
+	return &Team{t.ID, t.Name, t.Description, perm}
+}
+
+// parsePagination returns page and limit from query parameters.
+func parsePagination(ctx *middleware.Context) (page, limit int) {
+	page = com.StrTo(ctx.Query("page")).MustInt()
+	if page < 1 {
+		page = 1
+	}
+	limit = com.StrTo(ctx.Query("limit")).MustInt()
+	if limit < 1 {
+		limit = DEFAULT_PAGE_LIMIT
+	} else if limit > MAX_PAGE_LIMIT {
+		limit = MAX_PAGE_LIMIT
+	}
+	return page, limit
+}
+
+// setLinkHeader sets RFC 5988 Link header of next and last pages.
+func setLinkHeader(ctx *middleware.Context, link string, total int64, page, limit int) {
+	lastPage := int((total + int64(limit) - 1) / int64(limit))
+	if page >= lastPage {
+		return
+	}
+
+	// Link may already carry query parameters such as filters.
+	sep := "?"
+	if strings.Contains(link, "?") {
+		sep = "&"
+	}
+	links := make([]string, 0, 2)
+	links = append(links, fmt.Sprintf(`<%s%spage=%d&limit=%d>; rel="next"`, link, sep, page+1, limit))
+	links = append(links, fmt.Sprintf(`<%s%spage=%d&limit=%d>; rel="last"`, link, sep, lastPage, limit))
+	ctx.Resp.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// orgAssignment returns organization by name in URL
+// that signed in user is a member of.
+func orgAssignment(ctx *middleware.Context) *models.User {
+	org, err := models.GetOrgByName(ctx.Params(":orgname"))
+	if err != nil {
+		if err == models.ErrOrgNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetOrgByName: " + err.Error(), base.DOC_URL})
+		}
+		return nil
+	}
+
+	if !ctx.User.IsAdmin && !org.IsOrgMember(ctx.User.Id) {
+		ctx.Error(403)
+		return nil
+	}
+	return org
+}
+
+// GET /orgs/:orgname/members
+func ListOrgMembers(ctx *middleware.Context) {
+	org := orgAssignment(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	page, limit := parsePagination(ctx)
+	members, total, err := models.GetOrgMembers(org.Name, page, limit)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetOrgMembers: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiMembers := make([]*api.User, len(members))
+	for i := range members {
+		apiMembers[i] = ToApiUser(members[i])
+	}
+	setLinkHeader(ctx, setting.AppUrl+"api/v1/orgs/"+org.Name+"/members", total, page, limit)
+	ctx.JSON(200, &apiMembers)
+}
+
+// GET /orgs/:orgname/bots
+func ListOrgBots(ctx *middleware.Context) {
+	org := orgAssignment(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	page, limit := parsePagination(ctx)
+	bots, total, err := models.GetBotUsers(org.Id, page, limit)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetBotUsers: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiBots := make([]*api.User, len(bots))
+	for i := range bots {
+		apiBots[i] = ToApiUser(bots[i])
+	}
+	setLinkHeader(ctx, setting.AppUrl+"api/v1/orgs/"+org.Name+"/bots", total, page, limit)
+	ctx.JSON(200, &apiBots)
+}
+
+type TransferOrgOption struct {
+	NewOwner string `json:"new_owner" binding:"Required"`
+}
+
+// POST /orgs/:orgname/transfer
+func TransferOrg(ctx *middleware.Context, form TransferOrgOption) {
+	org := orgAssignment(ctx)
+	if ctx.Written() {
+		return
+	}
+	if !org.IsOwnedBy(ctx.User.Id) {
+		ctx.Error(403)
+		return
+	}
+
+	newOwner, err := models.GetUserByName(form.NewOwner)
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetUserByName: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+
+	if err = models.TransferOrgOwnership(org.Id, ctx.User, newOwner); err != nil {
+		switch err {
+		case models.ErrOrgTransferNotMember, models.ErrOrgTransferSameOwner:
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"TransferOrgOwnership: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	log.Trace("Organization ownership transferred via API: %s, %s -> %s", org.Name, ctx.User.Name, newOwner.Name)
+	mailer.SendOrgTransferMail(ctx.Render, org, ctx.User, newOwner)
+	ctx.WriteHeader(204)
+}
+
+// GET /orgs/:orgname/teams
+func ListOrgTeams(ctx *middleware.Context) {
+	org := orgAssignment(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	page, limit := parsePagination(ctx)
+	teams, err := models.GetOrgTeams(org.Id, page, limit)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetOrgTeams: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiTeams := make([]*Team, len(teams))
+	for i := range teams {
+		apiTeams[i] = ToApiTeam(teams[i])
+	}
+	setLinkHeader(ctx, setting.AppUrl+"api/v1/orgs/"+org.Name+"/teams", int64(org.NumTeams), page, limit)
+	ctx.JSON(200, &apiTeams)
+}
+
+// GET /teams/:id/members
+func ListTeamMembers(ctx *middleware.Context) {
+	team, err := models.GetTeamById(com.StrTo(ctx.Params(":id")).MustInt64())
+	if err != nil {
+		if err == models.ErrTeamNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetTeamById: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+
+	if !ctx.User.IsAdmin && !models.IsOrganizationMember(team.OrgID, ctx.User.Id) {
+		ctx.Error(403)
+		return
+	}
+
+	page, limit := parsePagination(ctx)
+	members, err := models.GetTeamMembers(team.ID, page, limit)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetTeamMembers: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiMembers := make([]*api.User, len(members))
+	for i := range members {
+		apiMembers[i] = ToApiUser(members[i])
+	}
+	setLinkHeader(ctx, fmt.Sprintf("%sapi/v1/teams/%d/members", setting.AppUrl, team.ID),
+		int64(team.NumMembers), page, limit)
+	ctx.JSON(200, &apiMembers)
+}
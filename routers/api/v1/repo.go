@@ -16,6 +16,7 @@ import (
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/auth"
 	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/git"
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/middleware"
 	"github.com/gogits/gogs/modules/setting"
@@ -40,11 +41,21 @@ func ToApiRepository(owner *models.User, repo *models.Repository, permission api
 	}
 }
 
+// Repository represents a repository with its disk usage.
+type Repository struct {
+	*api.Repository
+	Size      int64 `json:"size"` // In KB.
+	HasIssues bool  `json:"has_issues"`
+	HasWiki   bool  `json:"has_wiki"`
+}
+
 func SearchRepos(ctx *middleware.Context) {
 	opt := models.SearchOption{
 		Keyword: path.Base(ctx.Query("q")),
 		Uid:     com.StrTo(ctx.Query("uid")).MustInt64(),
 		Limit:   com.StrTo(ctx.Query("limit")).MustInt(),
+		MinSize: com.StrTo(ctx.Query("min_size")).MustInt64(),
+		MaxSize: com.StrTo(ctx.Query("max_size")).MustInt64(),
 	}
 	if opt.Limit == 0 {
 		opt.Limit = 10
@@ -267,3 +278,184 @@ func ListMyRepos(ctx *middleware.Context) {
 
 	ctx.JSON(200, &repos)
 }
+
+type GenerateRepoOption struct {
+	Owner             string `json:"owner"`
+	Name              string `json:"name" binding:"Required"`
+	Description       string `json:"description"`
+	IncludeGitHistory bool   `json:"include_git_history"`
+}
+
+// POST /repos/:username/:reponame/generate
+func GenerateRepo(ctx *middleware.Context, opt GenerateRepoOption) {
+	owner := ctx.User
+	if len(opt.Owner) > 0 && opt.Owner != ctx.User.Name {
+		org, err := models.GetOrgByName(opt.Owner)
+		if err != nil {
+			if err == models.ErrUserNotExist {
+				ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+			} else {
+				ctx.JSON(500, &base.ApiJsonErr{"GetOrgByName: " + err.Error(), base.DOC_URL})
+			}
+			return
+		}
+		if !org.IsOwnedBy(ctx.User.Id) {
+			ctx.Error(403)
+			return
+		}
+		owner = org
+	}
+
+	repo, err := models.CreateRepoFromTemplate(ctx.Repo.Repository.Id, owner,
+		opt.Name, opt.Description, opt.IncludeGitHistory)
+	if err != nil {
+		switch err {
+		case models.ErrRepoNotTemplate, models.ErrRepoAlreadyExist, models.ErrRepoNameIllegal:
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"CreateRepoFromTemplate: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+
+	log.Trace("Repository generated from template %s/%s: %s/%s",
+		ctx.Repo.Owner.Name, ctx.Repo.Repository.Name, owner.Name, repo.Name)
+	ctx.JSON(201, ToApiRepository(owner, repo, api.Permission{true, true, true}))
+}
+
+// GET /repos/:username/:reponame
+// https://developer.github.com/v3/repos/#get
+func GetRepo(ctx *middleware.Context) {
+	mode := ctx.Repo.AccessMode
+	ctx.JSON(200, &Repository{
+		Repository: ToApiRepository(ctx.Repo.Owner, ctx.Repo.Repository, api.Permission{
+			mode >= models.ACCESS_MODE_ADMIN,
+			mode >= models.ACCESS_MODE_WRITE,
+			mode >= models.ACCESS_MODE_READ,
+		}),
+		Size:      ctx.Repo.Repository.GitSize,
+		HasIssues: ctx.Repo.Repository.HasIssues,
+		HasWiki:   ctx.Repo.Repository.HasWiki,
+	})
+}
+
+// EditRepoOption represents fields of repository that can be changed,
+// omitted fields are left unchanged. Names of fields match GitHub API.
+type EditRepoOption struct {
+	DefaultBranch string `json:"default_branch"`
+	HasIssues     *bool  `json:"has_issues"`
+	HasWiki       *bool  `json:"has_wiki"`
+}
+
+// PATCH /repos/:username/:reponame
+func EditRepo(ctx *middleware.Context, opt EditRepoOption) {
+	if ctx.Repo.AccessMode < models.ACCESS_MODE_ADMIN {
+		ctx.Error(403)
+		return
+	}
+
+	repo := ctx.Repo.Repository
+	if len(opt.DefaultBranch) > 0 && opt.DefaultBranch != repo.DefaultBranch {
+		if err := models.UpdateDefaultBranch(repo, opt.DefaultBranch); err != nil {
+			if err == models.ErrBranchNotExist {
+				ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+			} else {
+				ctx.JSON(500, &base.ApiJsonErr{"UpdateDefaultBranch: " + err.Error(), base.DOC_URL})
+			}
+			return
+		}
+		log.Trace("Default branch of repository %s/%s changed: %s", ctx.Repo.Owner.Name, repo.Name, repo.DefaultBranch)
+	}
+
+	if opt.HasIssues != nil || opt.HasWiki != nil {
+		settings := models.RepoSettings{repo.HasIssues, repo.HasWiki}
+		if opt.HasIssues != nil {
+			settings.HasIssues = *opt.HasIssues
+		}
+		if opt.HasWiki != nil {
+			settings.HasWiki = *opt.HasWiki
+		}
+		if err := models.UpdateRepoSettings(repo.Id, settings); err != nil {
+			ctx.JSON(500, &base.ApiJsonErr{"UpdateRepoSettings: " + err.Error(), base.DOC_URL})
+			return
+		}
+		repo.HasIssues, repo.HasWiki = settings.HasIssues, settings.HasWiki
+	}
+
+	ctx.JSON(200, &Repository{
+		Repository: ToApiRepository(ctx.Repo.Owner, repo, api.Permission{true, true, true}),
+		Size:       repo.GitSize,
+		HasIssues:  repo.HasIssues,
+		HasWiki:    repo.HasWiki,
+	})
+}
+
+// BranchProtection represents protection settings of a branch.
+type BranchProtection struct {
+	Branch    string `json:"branch"`
+	IsDefault bool   `json:"is_default"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// GET /repos/:username/:reponame/branches/:branch/protection
+func GetBranchProtection(ctx *middleware.Context) {
+	branch := ctx.Params(":branch")
+	if !git.IsBranchExist(models.RepoPath(ctx.Repo.Owner.Name, ctx.Repo.Repository.Name), branch) {
+		ctx.Error(404)
+		return
+	}
+
+	// Branch protection rules are not supported yet.
+	ctx.JSON(200, &BranchProtection{
+		Branch:    branch,
+		IsDefault: branch == ctx.Repo.Repository.DefaultBranch,
+	})
+}
+
+// Contributor represents an author of commits in a repository,
+// e-mail address is not exposed.
+type Contributor struct {
+	Name      string `json:"name"`
+	Commits   int    `json:"commits"`
+	AvatarUrl string `json:"avatar_url"`
+	UserName  string `json:"username,omitempty"`
+}
+
+// GET /repos/:username/:reponame/contributors
+func ListRepoContributors(ctx *middleware.Context) {
+	total, err := models.CountRepositoryContributors(ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"CountRepositoryContributors: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	page, limit := parsePagination(ctx)
+	contributors, err := models.GetRepositoryContributors(ctx.Repo.Repository.Id, page, limit)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetRepositoryContributors: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiContributors := make([]*Contributor, len(contributors))
+	for i, c := range contributors {
+		apiContributors[i] = &Contributor{c.Name, c.Commits, c.Avatar, c.UserName}
+	}
+	setLinkHeader(ctx, setting.AppUrl+"api/v1/repos/"+ctx.Repo.Owner.Name+"/"+ctx.Repo.Repository.Name+"/contributors",
+		int64(total), page, limit)
+	ctx.JSON(200, &apiContributors)
+}
+
+// GET /repos/:username/:reponame/languages
+func ListRepoLanguages(ctx *middleware.Context) {
+	languages, err := models.GetRepoLanguages(ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetRepoLanguages: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiLanguages := make(map[string]int64, len(languages))
+	for _, l := range languages {
+		apiLanguages[l.Language] = l.Bytes
+	}
+	ctx.JSON(200, apiLanguages)
+}
@@ -0,0 +1,157 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"time"
+
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+type CommitUser struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
+// CommitVerification represents result of verifying signature of a commit,
+// reason is "unsigned" or one of models.SIGNATURE_*.
+type CommitVerification struct {
+	Verified  bool   `json:"verified"`
+	Reason    string `json:"reason"`
+	Signature string `json:"signature"`
+	Payload   string `json:"payload"`
+	KeyId     string `json:"key_id"` // GPG key ID or fingerprint of SSH key.
+}
+
+type CommitMeta struct {
+	Author       *CommitUser         `json:"author"`
+	Committer    *CommitUser         `json:"committer"`
+	Message      string              `json:"message"`
+	Verification *CommitVerification `json:"verification"`
+}
+
+type CommitParent struct {
+	Sha string `json:"sha"`
+}
+
+type Commit struct {
+	Sha     string          `json:"sha"`
+	HtmlUrl string          `json:"html_url"`
+	Commit  *CommitMeta     `json:"commit"`
+	Parents []*CommitParent `json:"parents"`
+}
+
+func toApiCommitUser(sig *git.Signature) *CommitUser {
+	if sig == nil {
+		return nil
+	}
+	return &CommitUser{sig.Name, sig.Email, sig.When}
+}
+
+// ToApiCommit converts commit to API format along with verification result of its signature.
+func ToApiCommit(repo *models.Repository, c *git.Commit) *Commit {
+	v := models.ParseCommitVerification(c)
+	verification := &CommitVerification{
+		Verified: v.IsVerified,
+		Reason:   v.Reason,
+		KeyId:    v.SignerKeyId(),
+	}
+	if !v.IsSigned {
+		verification.Reason = "unsigned"
+	}
+	if c.Signature != nil {
+		verification.Signature = c.Signature.Signature
+		verification.Payload = c.Signature.Payload
+	}
+
+	parents := make([]*CommitParent, c.ParentCount())
+	for i := range parents {
+		id, _ := c.ParentId(i)
+		parents[i] = &CommitParent{id.String()}
+	}
+
+	return &Commit{
+		Sha:     c.Id.String(),
+		HtmlUrl: setting.AppUrl + repo.Owner.Name + "/" + repo.Name + "/commit/" + c.Id.String(),
+		Commit: &CommitMeta{
+			Author:       toApiCommitUser(c.Author),
+			Committer:    toApiCommitUser(c.Committer),
+			Message:      c.CommitMessage,
+			Verification: verification,
+		},
+		Parents: parents,
+	}
+}
+
+// getRepoCommit returns commit of given branch, tag or commit ID of repository.
+func getRepoCommit(ctx *middleware.Context, ref string) (*git.Commit, bool) {
+	gitRepo, err := git.OpenRepository(models.RepoPath(ctx.Repo.Owner.Name, ctx.Repo.Repository.Name))
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"OpenRepository: " + err.Error(), base.DOC_URL})
+		return nil, false
+	}
+
+	var commit *git.Commit
+	switch {
+	case gitRepo.IsBranchExist(ref):
+		commit, err = gitRepo.GetCommitOfBranch(ref)
+	case gitRepo.IsTagExist(ref):
+		commit, err = gitRepo.GetCommitOfTag(ref)
+	case len(ref) == 40:
+		commit, err = gitRepo.GetCommit(ref)
+	default:
+		ctx.Error(404)
+		return nil, false
+	}
+	if err != nil {
+		ctx.Error(404)
+		return nil, false
+	}
+	return commit, true
+}
+
+// GET /repos/:username/:reponame/commits
+func ListRepoCommits(ctx *middleware.Context) {
+	ref := ctx.Query("sha")
+	if len(ref) == 0 {
+		ref = ctx.Repo.Repository.DefaultBranch
+	}
+	commit, ok := getRepoCommit(ctx, ref)
+	if !ok {
+		return
+	}
+
+	page := com.StrTo(ctx.Query("page")).MustInt()
+	if page < 1 {
+		page = 1
+	}
+	commits, err := commit.CommitsByRange(page)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"CommitsByRange: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiCommits := make([]*Commit, 0, commits.Len())
+	for e := commits.Front(); e != nil; e = e.Next() {
+		apiCommits = append(apiCommits, ToApiCommit(ctx.Repo.Repository, e.Value.(*git.Commit)))
+	}
+	ctx.JSON(200, &apiCommits)
+}
+
+// GET /repos/:username/:reponame/commits/:sha
+func GetRepoCommit(ctx *middleware.Context) {
+	commit, ok := getRepoCommit(ctx, ctx.Params(":sha"))
+	if !ok {
+		return
+	}
+	ctx.JSON(200, ToApiCommit(ctx.Repo.Repository, commit))
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/webhook"
 )
 
 // GET /repos/:username/:reponame/hooks
@@ -167,3 +168,24 @@ func EditRepoHook(ctx *middleware.Context, form api.EditHookOption) {
 		"ok": true,
 	})
 }
+
+type TestSignatureOption struct {
+	Secret    string `json:"secret" binding:"Required"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type TestSignatureResult struct {
+	Signature string `json:"signature"`
+	Valid     bool   `json:"valid"`
+}
+
+// POST /webhooks/test-signature
+// TestWebhookSignature returns signature Gogs sends along with given payload for given secret,
+// and whether given signature, e.g. computed by a receiver, matches it.
+func TestWebhookSignature(ctx *middleware.Context, opt TestSignatureOption) {
+	ctx.JSON(200, &TestSignatureResult{
+		Signature: webhook.SIGNATURE_PREFIX + webhook.Sign([]byte(opt.Secret), []byte(opt.Payload)),
+		Valid:     webhook.VerifySignature([]byte(opt.Secret), []byte(opt.Payload), opt.Signature),
+	})
+}
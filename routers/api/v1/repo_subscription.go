@@ -0,0 +1,59 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"strings"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+// PushSubscription represents e-mail subscription of current user to pushes of a repository,
+// empty branches means all branches.
+type PushSubscription struct {
+	Subscribed bool     `json:"subscribed"`
+	Branches   []string `json:"branches"`
+}
+
+type PushSubscriptionOption struct {
+	Branches []string `json:"branches"`
+}
+
+func toApiPushSubscription(s *models.PushSubscription) *PushSubscription {
+	if s == nil {
+		return &PushSubscription{Branches: []string{}}
+	}
+	return &PushSubscription{true, s.BranchList()}
+}
+
+// GET /repos/:username/:reponame/subscription/push
+func GetPushSubscription(ctx *middleware.Context) {
+	s, err := models.GetPushSubscription(ctx.User.Id, ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetPushSubscription: " + err.Error(), base.DOC_URL})
+		return
+	}
+	ctx.JSON(200, toApiPushSubscription(s))
+}
+
+// PUT /repos/:username/:reponame/subscription/push
+func SubscribePush(ctx *middleware.Context, form PushSubscriptionOption) {
+	if err := models.SubscribePush(ctx.User.Id, ctx.Repo.Repository.Id, strings.Join(form.Branches, ",")); err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"SubscribePush: " + err.Error(), base.DOC_URL})
+		return
+	}
+	GetPushSubscription(ctx)
+}
+
+// DELETE /repos/:username/:reponame/subscription/push
+func UnsubscribePush(ctx *middleware.Context) {
+	if err := models.UnsubscribePush(ctx.User.Id, ctx.Repo.Repository.Id); err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"UnsubscribePush: " + err.Error(), base.DOC_URL})
+		return
+	}
+	ctx.WriteHeader(204)
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"time"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+// TrafficCount represents traffic of a repository in a day.
+type TrafficCount struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     int       `json:"count"`
+	Uniques   int       `json:"uniques"`
+}
+
+type CloneTraffic struct {
+	Count   int             `json:"count"`
+	Uniques int64           `json:"uniques"`
+	Clones  []*TrafficCount `json:"clones"`
+}
+
+type ViewTraffic struct {
+	Count   int             `json:"count"`
+	Uniques int64           `json:"uniques"`
+	Views   []*TrafficCount `json:"views"`
+}
+
+// getRepoTraffic returns daily traffic of last TRAFFIC_MAX_DAYS days and number of
+// distinct visitors in that period, only users with push access can see traffic.
+func getRepoTraffic(ctx *middleware.Context, tp models.TrafficType) ([]*models.RepoTraffic, int64, bool) {
+	if ctx.Repo.AccessMode < models.ACCESS_MODE_WRITE {
+		ctx.Error(403)
+		return nil, 0, false
+	}
+	if per := ctx.Query("per"); len(per) > 0 && per != "day" {
+		ctx.JSON(422, &base.ApiJsonErr{"only daily traffic is supported", base.DOC_URL})
+		return nil, 0, false
+	}
+
+	repoId := ctx.Repo.Repository.Id
+	traffic, err := models.GetRepoTraffic(repoId, models.TRAFFIC_MAX_DAYS)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetRepoTraffic: " + err.Error(), base.DOC_URL})
+		return nil, 0, false
+	}
+	uniques, err := models.CountRepoTrafficUniques(repoId, tp, models.TRAFFIC_MAX_DAYS)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"CountRepoTrafficUniques: " + err.Error(), base.DOC_URL})
+		return nil, 0, false
+	}
+	return traffic, uniques, true
+}
+
+// trafficTimestamp returns beginning of day of traffic record in UTC.
+func trafficTimestamp(t *models.RepoTraffic) time.Time {
+	timestamp, _ := time.Parse(models.TRAFFIC_DATE_FORMAT, t.Date)
+	return timestamp
+}
+
+// GET /repos/:username/:reponame/traffic/clones
+func GetRepoCloneTraffic(ctx *middleware.Context) {
+	traffic, uniques, ok := getRepoTraffic(ctx, models.TRAFFIC_CLONE)
+	if !ok {
+		return
+	}
+
+	result := &CloneTraffic{
+		Uniques: uniques,
+		Clones:  make([]*TrafficCount, 0, len(traffic)),
+	}
+	for _, t := range traffic {
+		if t.Clones == 0 {
+			continue
+		}
+		result.Count += t.Clones
+		result.Clones = append(result.Clones, &TrafficCount{trafficTimestamp(t), t.Clones, t.UniqueCloners})
+	}
+	ctx.JSON(200, result)
+}
+
+// GET /repos/:username/:reponame/traffic/views
+func GetRepoViewTraffic(ctx *middleware.Context) {
+	traffic, uniques, ok := getRepoTraffic(ctx, models.TRAFFIC_VIEW)
+	if !ok {
+		return
+	}
+
+	result := &ViewTraffic{
+		Uniques: uniques,
+		Views:   make([]*TrafficCount, 0, len(traffic)),
+	}
+	for _, t := range traffic {
+		if t.Views == 0 {
+			continue
+		}
+		result.Count += t.Views
+		result.Views = append(result.Views, &TrafficCount{trafficTimestamp(t), t.Views, t.UniqueVisitors})
+	}
+	ctx.JSON(200, result)
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/search"
+)
+
+type SearchResult struct {
+	Type       string  `json:"type"`
+	RepoId     int64   `json:"repo_id"`
+	Sha        string  `json:"sha,omitempty"`
+	IssueIndex int64   `json:"issue_index,omitempty"`
+	Title      string  `json:"title"`
+	Author     string  `json:"author,omitempty"`
+	Score      float64 `json:"score"`
+}
+
+func ToApiSearchResult(r *search.SearchResult) *SearchResult {
+	return &SearchResult{r.Type, r.RepoId, r.Sha, r.Index, r.Title, r.Author, r.Score}
+}
+
+// GET /search
+func SearchAll(ctx *middleware.Context) {
+	var u *models.User
+	if ctx.IsSigned {
+		u = ctx.User
+	}
+	page, limit := parsePagination(ctx)
+	results, err := models.SearchAll(ctx.Query("q"), u, page, limit)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"SearchAll: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiResults := make([]*SearchResult, len(results))
+	for i := range results {
+		apiResults[i] = ToApiSearchResult(results[i])
+	}
+	ctx.JSON(200, &apiResults)
+}
@@ -0,0 +1,315 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"github.com/Unknwon/com"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+type CreateTeamOption struct {
+	Name        string `json:"name" binding:"Required;AlphaDashDot;MaxSize(30)"`
+	Description string `json:"description" binding:"MaxSize(255)"`
+	Permission  string `json:"permission"`
+}
+
+type EditTeamOption struct {
+	Name        string  `json:"name" binding:"AlphaDashDot;MaxSize(30)"`
+	Description *string `json:"description"`
+	Permission  string  `json:"permission"`
+}
+
+// parseTeamPermission returns access mode of given permission name,
+// owner permission cannot be assigned to any team other than owner team.
+func parseTeamPermission(perm string) (models.AccessMode, bool) {
+	switch perm {
+	case "", "read":
+		return models.ACCESS_MODE_READ, true
+	case "write":
+		return models.ACCESS_MODE_WRITE, true
+	case "admin":
+		return models.ACCESS_MODE_ADMIN, true
+	}
+	return 0, false
+}
+
+// teamAssignment returns team by ID in URL. Signed in user must be
+// a member of its organization to read, or an owner to make changes.
+func teamAssignment(ctx *middleware.Context, requireOwner bool) *models.Team {
+	team, err := models.GetTeamById(com.StrTo(ctx.Params(":id")).MustInt64())
+	if err != nil {
+		if err == models.ErrTeamNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetTeamById: " + err.Error(), base.DOC_URL})
+		}
+		return nil
+	}
+
+	if !ctx.User.IsAdmin {
+		if (requireOwner && !models.IsOrganizationOwner(team.OrgID, ctx.User.Id)) ||
+			!models.IsOrganizationMember(team.OrgID, ctx.User.Id) {
+			ctx.Error(403)
+			return nil
+		}
+	}
+	return team
+}
+
+// POST /orgs/:orgname/teams
+func CreateTeam(ctx *middleware.Context, form CreateTeamOption) {
+	org := orgAssignment(ctx)
+	if ctx.Written() {
+		return
+	}
+	if !ctx.User.IsAdmin && !org.IsOwnedBy(ctx.User.Id) {
+		ctx.Error(403)
+		return
+	}
+
+	auth, ok := parseTeamPermission(form.Permission)
+	if !ok {
+		ctx.JSON(422, &base.ApiJsonErr{"invalid permission: " + form.Permission, base.DOC_URL})
+		return
+	}
+
+	t := &models.Team{
+		OrgID:       org.Id,
+		Name:        form.Name,
+		Description: form.Description,
+		Authorize:   auth,
+	}
+	if err := models.NewTeam(t); err != nil {
+		switch err {
+		case models.ErrTeamNameIllegal, models.ErrTeamAlreadyExist:
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"NewTeam: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	ctx.JSON(201, ToApiTeam(t))
+}
+
+// GET /teams/:id
+func GetTeam(ctx *middleware.Context) {
+	team := teamAssignment(ctx, false)
+	if ctx.Written() {
+		return
+	}
+	ctx.JSON(200, ToApiTeam(team))
+}
+
+// PATCH /teams/:id
+func EditTeam(ctx *middleware.Context, form EditTeamOption) {
+	team := teamAssignment(ctx, true)
+	if ctx.Written() {
+		return
+	}
+
+	isAuthChanged := false
+	if len(form.Name) > 0 || len(form.Permission) > 0 {
+		if team.IsOwnerTeam() {
+			ctx.JSON(422, &base.ApiJsonErr{"name and permission of owner team cannot be changed", base.DOC_URL})
+			return
+		}
+
+		if len(form.Name) > 0 && form.Name != team.Name {
+			if t, err := models.GetTeam(team.OrgID, form.Name); err == nil && t.ID != team.ID {
+				ctx.JSON(422, &base.ApiJsonErr{models.ErrTeamAlreadyExist.Error(), base.DOC_URL})
+				return
+			} else if err != nil && err != models.ErrTeamNotExist {
+				ctx.JSON(500, &base.ApiJsonErr{"GetTeam: " + err.Error(), base.DOC_URL})
+				return
+			}
+			team.Name = form.Name
+		}
+
+		if len(form.Permission) > 0 {
+			auth, ok := parseTeamPermission(form.Permission)
+			if !ok {
+				ctx.JSON(422, &base.ApiJsonErr{"invalid permission: " + form.Permission, base.DOC_URL})
+				return
+			}
+			if team.Authorize != auth {
+				isAuthChanged = true
+				team.Authorize = auth
+			}
+		}
+	}
+	if form.Description != nil {
+		team.Description = *form.Description
+	}
+
+	if err := models.UpdateTeam(team, isAuthChanged); err != nil {
+		if err == models.ErrTeamNameIllegal {
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"UpdateTeam: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	ctx.JSON(200, ToApiTeam(team))
+}
+
+// DELETE /teams/:id
+func DeleteTeam(ctx *middleware.Context) {
+	team := teamAssignment(ctx, true)
+	if ctx.Written() {
+		return
+	}
+	if team.IsOwnerTeam() {
+		ctx.JSON(422, &base.ApiJsonErr{"owner team cannot be deleted", base.DOC_URL})
+		return
+	}
+
+	if err := models.DeleteTeam(team); err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"DeleteTeam: " + err.Error(), base.DOC_URL})
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+func teamMemberAssignment(ctx *middleware.Context, requireOwner bool) (*models.Team, *models.User) {
+	team := teamAssignment(ctx, requireOwner)
+	if ctx.Written() {
+		return nil, nil
+	}
+
+	u, err := models.GetUserByName(ctx.Params(":username"))
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetUserByName: " + err.Error(), base.DOC_URL})
+		}
+		return nil, nil
+	}
+	return team, u
+}
+
+// GET /teams/:id/members/:username
+func GetTeamMember(ctx *middleware.Context) {
+	team, u := teamMemberAssignment(ctx, false)
+	if ctx.Written() {
+		return
+	}
+
+	if !team.IsMember(u.Id) {
+		ctx.Error(404)
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+// PUT /teams/:id/members/:username
+func AddTeamMember(ctx *middleware.Context) {
+	team, u := teamMemberAssignment(ctx, true)
+	if ctx.Written() {
+		return
+	}
+
+	if err := team.AddMember(u.Id); err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"AddMember: " + err.Error(), base.DOC_URL})
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+// DELETE /teams/:id/members/:username
+func RemoveTeamMember(ctx *middleware.Context) {
+	team, u := teamMemberAssignment(ctx, true)
+	if ctx.Written() {
+		return
+	}
+
+	if err := team.RemoveMember(u.Id); err != nil {
+		if err == models.ErrLastOrgOwner {
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"RemoveMember: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+// teamRepoAssignment returns team and repository in URL,
+// repository must belong to organization of team.
+func teamRepoAssignment(ctx *middleware.Context, requireOwner bool) (*models.Team, *models.Repository) {
+	team := teamAssignment(ctx, requireOwner)
+	if ctx.Written() {
+		return nil, nil
+	}
+
+	owner, err := models.GetUserByName(ctx.Params(":owner"))
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetUserByName: " + err.Error(), base.DOC_URL})
+		}
+		return nil, nil
+	} else if owner.Id != team.OrgID {
+		ctx.JSON(422, &base.ApiJsonErr{"repository does not belong to organization of team", base.DOC_URL})
+		return nil, nil
+	}
+
+	repo, err := models.GetRepositoryByName(owner.Id, ctx.Params(":reponame"))
+	if err != nil {
+		if models.IsErrRepoNotExist(err) {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetRepositoryByName: " + err.Error(), base.DOC_URL})
+		}
+		return nil, nil
+	}
+	return team, repo
+}
+
+// GET /teams/:id/repos/:owner/:reponame
+func GetTeamRepo(ctx *middleware.Context) {
+	team, repo := teamRepoAssignment(ctx, false)
+	if ctx.Written() {
+		return
+	}
+
+	if !team.HasRepository(repo.Id) {
+		ctx.Error(404)
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+// PUT /teams/:id/repos/:owner/:reponame
+func AddTeamRepo(ctx *middleware.Context) {
+	team, repo := teamRepoAssignment(ctx, true)
+	if ctx.Written() {
+		return
+	}
+
+	if err := team.AddRepository(repo); err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"AddRepository: " + err.Error(), base.DOC_URL})
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+// DELETE /teams/:id/repos/:owner/:reponame
+func RemoveTeamRepo(ctx *middleware.Context) {
+	team, repo := teamRepoAssignment(ctx, true)
+	if ctx.Written() {
+		return
+	}
+
+	if err := team.RemoveRepository(repo.Id); err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"RemoveRepository: " + err.Error(), base.DOC_URL})
+		return
+	}
+	ctx.WriteHeader(204)
+}
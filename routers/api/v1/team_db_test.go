@@ -0,0 +1,159 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Unknwon/macaron"
+	"github.com/macaron-contrib/binding"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+// newTeamRoutes returns routes of organization teams as seen by signed in user u.
+func newTeamRoutes(u *models.User) *macaron.Macaron {
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(func(c *macaron.Context) {
+		c.Map(&middleware.Context{Context: c, User: u, IsSigned: true})
+	})
+	bind := binding.Bind
+	m.Combo("/orgs/:orgname/teams").Get(ListOrgTeams).Post(bind(CreateTeamOption{}), CreateTeam)
+	m.Group("/teams/:id:int", func() {
+		m.Combo("").Get(GetTeam).Patch(bind(EditTeamOption{}), EditTeam).Delete(DeleteTeam)
+		m.Combo("/members/:username").Get(GetTeamMember).Put(AddTeamMember).Delete(RemoveTeamMember)
+		m.Combo("/repos/:owner/:reponame").Get(GetTeamRepo).Put(AddTeamRepo).Delete(RemoveTeamRepo)
+	})
+	return m
+}
+
+func TestTeamRoutes(t *testing.T) {
+	defer newTestEngine(t)()
+	var err error
+
+	alice := &models.User{Name: "alice", Email: "alice@example.com", Passwd: "password"}
+	bob := &models.User{Name: "bob", Email: "bob@example.com", Passwd: "password"}
+	carol := &models.User{Name: "carol", Email: "carol@example.com", Passwd: "password"}
+	for _, u := range []*models.User{alice, bob, carol} {
+		if err = models.CreateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	org, err := models.CreateOrganization(&models.User{Name: "acme", Type: models.ORGANIZATION, IsActive: true}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerTeam, err := org.GetOwnerTeam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = models.CreateRepository(org, "repo", "", "", "", false, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	asAlice, asBob, asCarol := newTeamRoutes(alice), newTeamRoutes(bob), newTeamRoutes(carol)
+
+	// Create.
+	if resp := serveRoute(asBob, "POST", "/orgs/acme/teams", CreateTeamOption{Name: "devs"}); resp.Code != 403 {
+		t.Errorf("create as non-member: expect 403 but got %d", resp.Code)
+	}
+	if resp := serveRoute(asAlice, "POST", "/orgs/acme/teams", CreateTeamOption{Name: "devs", Permission: "owner"}); resp.Code != 422 {
+		t.Errorf("create with owner permission: expect 422 but got %d", resp.Code)
+	}
+	resp := serveRoute(asAlice, "POST", "/orgs/acme/teams", CreateTeamOption{Name: "devs", Permission: "write"})
+	if resp.Code != 201 {
+		t.Fatalf("create: expect 201 but got %d %s", resp.Code, resp.Body.String())
+	}
+	team := new(Team)
+	if err = json.Unmarshal(resp.Body.Bytes(), team); err != nil {
+		t.Fatal(err)
+	} else if team.Name != "devs" || team.Permission != "write" {
+		t.Errorf("create: unexpected team %+v", team)
+	}
+	if resp = serveRoute(asAlice, "POST", "/orgs/acme/teams", CreateTeamOption{Name: "devs"}); resp.Code != 422 {
+		t.Errorf("create duplicate: expect 422 but got %d", resp.Code)
+	}
+	teamPath := fmt.Sprintf("/teams/%d", team.Id)
+
+	// Members.
+	if resp = serveRoute(asCarol, "PUT", teamPath+"/members/carol", nil); resp.Code != 403 {
+		t.Errorf("add member as non-member: expect 403 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asAlice, "PUT", teamPath+"/members/carol", nil); resp.Code != 204 {
+		t.Fatalf("add member: expect 204 but got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = serveRoute(asAlice, "GET", teamPath+"/members/carol", nil); resp.Code != 204 {
+		t.Errorf("get member: expect 204 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asAlice, "GET", teamPath+"/members/bob", nil); resp.Code != 404 {
+		t.Errorf("get non-member: expect 404 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asAlice, "PUT", teamPath+"/members/nobody", nil); resp.Code != 404 {
+		t.Errorf("add missing user: expect 404 but got %d", resp.Code)
+	}
+
+	// Members can read, only owners can change.
+	if resp = serveRoute(asCarol, "GET", teamPath, nil); resp.Code != 200 {
+		t.Errorf("get as member: expect 200 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asBob, "GET", teamPath, nil); resp.Code != 403 {
+		t.Errorf("get as non-member: expect 403 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asCarol, "PATCH", teamPath, EditTeamOption{Permission: "admin"}); resp.Code != 403 {
+		t.Errorf("edit as member: expect 403 but got %d", resp.Code)
+	}
+	desc := "Developers"
+	if resp = serveRoute(asAlice, "PATCH", teamPath, EditTeamOption{Description: &desc, Permission: "admin"}); resp.Code != 200 {
+		t.Errorf("edit: expect 200 but got %d %s", resp.Code, resp.Body.String())
+	} else if err = json.Unmarshal(resp.Body.Bytes(), team); err != nil {
+		t.Fatal(err)
+	} else if team.Description != desc || team.Permission != "admin" {
+		t.Errorf("edit: unexpected team %+v", team)
+	}
+
+	// Repositories.
+	repoPath := teamPath + "/repos/acme/repo"
+	if resp = serveRoute(asAlice, "PUT", teamPath+"/repos/alice/repo", nil); resp.Code != 422 {
+		t.Errorf("add repository of other owner: expect 422 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asAlice, "PUT", repoPath, nil); resp.Code != 204 {
+		t.Fatalf("add repository: expect 204 but got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = serveRoute(asCarol, "GET", repoPath, nil); resp.Code != 204 {
+		t.Errorf("get repository: expect 204 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asAlice, "DELETE", repoPath, nil); resp.Code != 204 {
+		t.Errorf("remove repository: expect 204 but got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = serveRoute(asAlice, "GET", repoPath, nil); resp.Code != 404 {
+		t.Errorf("get removed repository: expect 404 but got %d", resp.Code)
+	}
+
+	// Owner team is protected.
+	ownerTeamPath := fmt.Sprintf("/teams/%d", ownerTeam.ID)
+	if resp = serveRoute(asAlice, "PATCH", ownerTeamPath, EditTeamOption{Name: "admins"}); resp.Code != 422 {
+		t.Errorf("rename owner team: expect 422 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asAlice, "DELETE", ownerTeamPath, nil); resp.Code != 422 {
+		t.Errorf("delete owner team: expect 422 but got %d", resp.Code)
+	}
+	if resp = serveRoute(asAlice, "DELETE", ownerTeamPath+"/members/alice", nil); resp.Code != 422 {
+		t.Errorf("remove last owner: expect 422 but got %d", resp.Code)
+	}
+
+	// Delete.
+	if resp = serveRoute(asAlice, "DELETE", teamPath, nil); resp.Code != 204 {
+		t.Errorf("delete: expect 204 but got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = serveRoute(asAlice, "GET", teamPath, nil); resp.Code != 404 {
+		t.Errorf("get deleted team: expect 404 but got %d", resp.Code)
+	}
+}
@@ -42,13 +42,17 @@ func SearchUsers(ctx *middleware.Context) {
 		return
 	}
 
-	results := make([]*api.User, len(us))
+	results := make([]*api.User, 0, len(us))
 	for i := range us {
-		results[i] = &api.User{
+		// Users blocked by current user should not be mentioned.
+		if ctx.IsSigned && models.IsBlocked(ctx.User.Id, us[i].Id) {
+			continue
+		}
+		results = append(results, &api.User{
 			UserName:  us[i].Name,
 			AvatarUrl: us[i].AvatarLink(),
 			FullName:  us[i].FullName,
-		}
+		})
 	}
 
 	ctx.Render.JSON(200, map[string]interface{}{
@@ -70,3 +74,60 @@ func GetUserInfo(ctx *middleware.Context) {
 	}
 	ctx.JSON(200, &api.User{u.Id, u.Name, u.FullName, u.Email, u.AvatarLink()})
 }
+
+// GET /user/blocks
+func ListBlockedUsers(ctx *middleware.Context) {
+	us, err := models.ListBlockedUsers(ctx.User.Id)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"ListBlockedUsers: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiUsers := make([]*api.User, len(us))
+	for i := range us {
+		apiUsers[i] = ToApiUser(us[i])
+	}
+	ctx.JSON(200, &apiUsers)
+}
+
+// PUT /user/blocks/:username
+func BlockUser(ctx *middleware.Context) {
+	u, err := models.GetUserByName(ctx.Params(":username"))
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetUserByName: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+
+	if err = models.BlockUser(ctx.User.Id, u.Id); err != nil {
+		if err == models.ErrCannotBlockSelf {
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"BlockUser: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+// DELETE /user/blocks/:username
+func UnblockUser(ctx *middleware.Context) {
+	u, err := models.GetUserByName(ctx.Params(":username"))
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetUserByName: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+
+	if err = models.UnblockUser(ctx.User.Id, u.Id); err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"UnblockUser: " + err.Error(), base.DOC_URL})
+		return
+	}
+	ctx.WriteHeader(204)
+}
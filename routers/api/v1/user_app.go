@@ -28,17 +28,27 @@ func ListAccessTokens(ctx *middleware.Context) {
 }
 
 type CreateAccessTokenForm struct {
-	Name string `json:"name" binding:"Required"`
+	Name   string   `json:"name" binding:"Required"`
+	Scopes []string `json:"scopes"`
 }
 
 // POST /users/:username/tokens
 func CreateAccessToken(ctx *middleware.Context, form CreateAccessTokenForm) {
+	// Clients that do not know about scopes still get full access.
+	if len(form.Scopes) == 0 {
+		form.Scopes = []string{models.ACCESS_TOKEN_SCOPE_ALL}
+	}
+
 	t := &models.AccessToken{
 		Uid:  ctx.User.Id,
 		Name: form.Name,
 	}
-	if err := models.NewAccessToken(t); err != nil {
-		ctx.JSON(500, &base.ApiJsonErr{"NewAccessToken: " + err.Error(), base.DOC_URL})
+	if err := models.NewAccessToken(t, form.Scopes); err != nil {
+		if models.IsErrAccessTokenScopeInvalid(err) {
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"NewAccessToken: " + err.Error(), base.DOC_URL})
+		}
 		return
 	}
 	ctx.JSON(201, &api.AccessToken{t.Name, t.Sha1})
@@ -0,0 +1,411 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+
+	api "github.com/gogits/go-gogs-client"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/mailer"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
+	"github.com/gogits/gogs/routers/user"
+)
+
+// PublicKey represents a SSH key in API responses,
+// unknown values are always null instead of zero values.
+type PublicKey struct {
+	ID                int64      `json:"id"`
+	Key               string     `json:"key"`
+	Title             string     `json:"title"`
+	Fingerprint       string     `json:"fingerprint"`
+	FingerprintSha256 *string    `json:"fingerprint_sha256"`
+	KeyType           *string    `json:"key_type"`
+	KeySize           *int       `json:"key_size"`
+	Disabled          bool       `json:"disabled"`
+	Pending           bool       `json:"pending"`
+	BelowPolicy       bool       `json:"below_policy"`
+	AccessMode        string     `json:"access_mode"`
+	Created           *time.Time `json:"created_at"`
+	LastUsed          *time.Time `json:"last_used_at"`
+	Expires           *time.Time `json:"expires_at"`
+}
+
+type CreatePublicKeyOption struct {
+	Title      string `json:"title" binding:"Required;MaxSize(50)"`
+	Key        string `json:"key" binding:"Required"`
+	AccessMode string `json:"access_mode"` // "full" or "read-only", default is "full".
+}
+
+type EditPublicKeyOption struct {
+	Title      string `json:"title" binding:"Required;MaxSize(50)"`
+	AccessMode string `json:"access_mode"` // Unchanged when empty.
+}
+
+type ImportPublicKeysOption struct {
+	Username string `json:"username" binding:"Required;MaxSize(39)"`
+}
+
+// KeyImportResult represents what happened to one of imported keys,
+// status is one of "added", "exists", "invalid" and "failed".
+type KeyImportResult struct {
+	Line   int        `json:"line"`
+	Title  string     `json:"title,omitempty"`
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	Key    *PublicKey `json:"key,omitempty"`
+}
+
+// timeOrNil returns nil for zero time so it is serialized as null.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func ToApiPublicKey(key *models.PublicKey) *PublicKey {
+	apiKey := &PublicKey{
+		ID:          key.Id,
+		Key:         strings.TrimSpace(key.Content),
+		Title:       key.Name,
+		Fingerprint: key.Fingerprint,
+		Disabled:    key.IsDisabled,
+		Pending:     key.IsPending,
+		BelowPolicy: key.BelowPolicy,
+		AccessMode:  string(key.AccessMode()),
+		Created:     timeOrNil(key.Created),
+		Expires:     timeOrNil(key.ExpiresAt()),
+	}
+	if len(key.FingerprintSha256) > 0 {
+		apiKey.FingerprintSha256 = &key.FingerprintSha256
+	}
+	if len(key.Type) > 0 {
+		apiKey.KeyType = &key.Type
+	}
+	if key.Size > 0 {
+		apiKey.KeySize = &key.Size
+	}
+	if key.Updated.After(key.Created) {
+		apiKey.LastUsed = timeOrNil(key.Updated)
+	}
+	return apiKey
+}
+
+// ToApiPublicKeyPublic returns API format of key that is listed publicly,
+// which has no comment in content and no information of usage.
+func ToApiPublicKeyPublic(key *models.PublicKey) *PublicKey {
+	apiKey := ToApiPublicKey(key)
+	apiKey.Key = strings.TrimSpace(key.OmitEmail())
+	apiKey.LastUsed = nil
+	return apiKey
+}
+
+// GET /user/keys
+func ListMyPublicKeys(ctx *middleware.Context) {
+	stamp, err := models.PublicKeysStamp(ctx.User.Id)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"PublicKeysStamp: " + err.Error(), base.DOC_URL})
+		return
+	} else if ctx.HandleETag(stamp, setting.SSHKeysCacheMaxAge) {
+		return
+	}
+
+	opts := &models.PublicKeyListOptions{
+		Type:    ctx.Query("type"),
+		OrderBy: ctx.Query("sort"),
+	}
+	page, limit := parsePagination(ctx)
+	total, err := models.CountPublicKeys(ctx.User.Id, opts)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"CountPublicKeys: " + err.Error(), base.DOC_URL})
+		return
+	}
+	keys, err := models.ListPublicKeysPaged(ctx.User.Id, page, limit, opts)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"ListPublicKeysPaged: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	apiKeys := make([]*PublicKey, len(keys))
+	for i := range keys {
+		apiKeys[i] = ToApiPublicKey(keys[i])
+	}
+
+	link := setting.AppUrl + "api/v1/user/keys"
+	query := make(url.Values)
+	if len(opts.Type) > 0 {
+		query.Set("type", opts.Type)
+	}
+	if len(opts.OrderBy) > 0 {
+		query.Set("sort", opts.OrderBy)
+	}
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	setLinkHeader(ctx, link, total, page, limit)
+	ctx.JSON(200, &apiKeys)
+}
+
+// GET /users/:username/keys
+func ListUserPublicKeys(ctx *middleware.Context) {
+	// Do not reveal whether the account exists when instance requires sign in.
+	if setting.Service.RequireSignInView && !ctx.IsSigned {
+		ctx.Error(404)
+		return
+	}
+
+	u, err := models.GetUserByName(ctx.Params(":username"))
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetUserByName: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+
+	apiKeys := make([]*PublicKey, 0)
+	if u.HideSSHKeys && (!ctx.IsSigned || (ctx.User.Id != u.Id && !ctx.User.IsAdmin)) {
+		ctx.JSON(200, &apiKeys)
+		return
+	}
+
+	page, limit := parsePagination(ctx)
+	keys, total, err := models.ListUsablePublicKeys(u.Id, ctx.Query("fingerprint"), page, limit)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"ListUsablePublicKeys: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	for i := range keys {
+		apiKeys = append(apiKeys, ToApiPublicKeyPublic(keys[i]))
+	}
+	setLinkHeader(ctx, setting.AppUrl+"api/v1/users/"+u.Name+"/keys", total, page, limit)
+	ctx.JSON(200, &apiKeys)
+}
+
+// GET /user/keys/:id
+func GetMyPublicKey(ctx *middleware.Context) {
+	key, err := models.GetPublicKeyById(com.StrTo(ctx.Params(":id")).MustInt64())
+	if err != nil {
+		if err == models.ErrKeyNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.JSON(500, &base.ApiJsonErr{"GetPublicKeyById: " + err.Error(), base.DOC_URL})
+		}
+		return
+	} else if key.OwnerId != ctx.User.Id {
+		ctx.Error(404)
+		return
+	}
+	ctx.JSON(200, ToApiPublicKey(key))
+}
+
+// POST /user/keys
+func CreateMyPublicKey(ctx *middleware.Context, form CreatePublicKeyOption) {
+	mode, err := models.ParseKeyAccessMode(form.AccessMode)
+	if err != nil {
+		ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		return
+	}
+
+	content, err := models.ParseKeyString(form.Key)
+	if err != nil {
+		middleware.KeyRateLimitFailure(ctx)
+		ctx.JSON(422, &base.ApiJsonErr{"Invalid SSH key: " + err.Error(), base.DOC_URL})
+		return
+	}
+	if ok, err := models.CheckPublicKeyString(content); !ok && err != models.ErrKeyUnableVerify {
+		middleware.KeyRateLimitFailure(ctx)
+		ctx.JSON(422, &base.ApiJsonErr{"Invalid SSH key: " + err.Error(), base.DOC_URL})
+		return
+	}
+
+	key := &models.PublicKey{
+		OwnerId: ctx.User.Id,
+		Name:    form.Title,
+		Content: content,
+		Mode:    mode,
+	}
+	if err = models.AddPublicKey(key, apiActor(ctx)); err != nil {
+		switch {
+		case err == models.ErrKeyAlreadyExist, models.IsErrKeyFingerprintAlreadyUsed(err),
+			models.IsErrKeyNameAlreadyUsed(err):
+			ctx.JSON(409, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		case models.IsErrKeyQuotaExceeded(err):
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"AddPublicKey: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	log.Trace("SSH key added via API: %s", ctx.User.Name)
+	mailer.SendSSHKeyAddedMail(ctx.Render, ctx.User, key, "api")
+	ctx.JSON(201, ToApiPublicKey(key))
+}
+
+// POST /user/keys/import
+func ImportMyPublicKeys(ctx *middleware.Context, form ImportPublicKeysOption) {
+	results, err := models.ImportGitHubPublicKeys(ctx.User.Id, form.Username, apiActor(ctx))
+	if err != nil {
+		switch {
+		case err == models.ErrGitHubUserNameInvalid:
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		case err == models.ErrGitHubUserNotExist:
+			ctx.JSON(404, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		case models.IsErrGitHubKeysFetch(err):
+			ctx.JSON(502, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"ImportGitHubPublicKeys: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+
+	apiResults := make([]*KeyImportResult, len(results))
+	for i, r := range results {
+		apiResults[i] = &KeyImportResult{
+			Line:   r.Line,
+			Title:  r.Name,
+			Status: r.Status,
+			Error:  r.Error,
+		}
+		if r.Key == nil {
+			continue
+		}
+		apiResults[i].Key = ToApiPublicKey(r.Key)
+		mailer.SendSSHKeyAddedMail(ctx.Render, ctx.User, r.Key, "api")
+	}
+	log.Trace("SSH keys imported from GitHub user %s via API: %s", form.Username, ctx.User.Name)
+	ctx.JSON(200, apiResults)
+}
+
+// PATCH /user/keys/:id
+func EditPublicKey(ctx *middleware.Context, form EditPublicKeyOption) {
+	id := com.StrTo(ctx.Params(":id")).MustInt64()
+	if len(form.AccessMode) > 0 {
+		mode, err := models.ParseKeyAccessMode(form.AccessMode)
+		if err != nil {
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+			return
+		}
+		if err = models.UpdatePublicKeyMode(ctx.User.Id, id, mode); err != nil {
+			if err == models.ErrKeyNotExist {
+				ctx.Error(404)
+			} else {
+				ctx.JSON(500, &base.ApiJsonErr{"UpdatePublicKeyMode: " + err.Error(), base.DOC_URL})
+			}
+			return
+		}
+	}
+
+	if err := models.UpdatePublicKeyName(ctx.User.Id, id, form.Title); err != nil {
+		switch {
+		case err == models.ErrKeyNotExist:
+			ctx.Error(404)
+		case err == models.ErrKeyExternallyManaged:
+			ctx.JSON(403, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		case models.IsErrKeyNameAlreadyUsed(err):
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"UpdatePublicKeyName: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+
+	key, err := models.GetPublicKeyById(id)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetPublicKeyById: " + err.Error(), base.DOC_URL})
+		return
+	}
+	ctx.JSON(200, ToApiPublicKey(key))
+}
+
+// DELETE /user/keys/:id
+func DeletePublicKey(ctx *middleware.Context) {
+	id := com.StrTo(ctx.Params(":id")).MustInt64()
+	// Existence and ownership are checked by deletion.
+	key, _ := models.GetPublicKeyById(id)
+	if key != nil && key.OwnerId == ctx.User.Id && key.IsExternal() {
+		ctx.JSON(403, &base.ApiJsonErr{models.ErrKeyExternallyManaged.Error(), base.DOC_URL})
+		return
+	}
+	if err := models.DeletePublicKey(ctx.User.Id, id, apiActor(ctx)); err != nil {
+		switch err {
+		case models.ErrKeyNotExist:
+			ctx.Error(404)
+		case models.ErrKeyAccessDenied:
+			ctx.Error(403)
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"DeletePublicKey: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	ctx.WriteHeader(204)
+}
+
+// GET /user/keys/export
+func ExportPublicKeys(ctx *middleware.Context) {
+	// Headers have been sent once streaming starts, so error can only be logged.
+	if err := user.ServePublicKeys(ctx); err != nil {
+		log.Error(4, "ServePublicKeys: %v", err)
+	}
+}
+
+// PublicKeySearchResult represents a public key found by fingerprint along with its owner.
+type PublicKeySearchResult struct {
+	*PublicKey
+	Owner       *api.User `json:"owner"`
+	IsDeployKey bool      `json:"is_deploy_key"`
+}
+
+// GET /keys/search
+func SearchPublicKeyByFingerprint(ctx *middleware.Context) {
+	key, err := models.SearchPublicKeyByFingerprint(ctx.Query("fingerprint"))
+	if err != nil {
+		switch err {
+		case models.ErrInvalidFingerprint:
+			ctx.JSON(422, &base.ApiJsonErr{err.Error(), base.DOC_URL})
+		case models.ErrKeyNotExist:
+			ctx.Error(404)
+		default:
+			ctx.JSON(500, &base.ApiJsonErr{"SearchPublicKeyByFingerprint: " + err.Error(), base.DOC_URL})
+		}
+		return
+	}
+	// Do not reveal keys of other users to non-admins.
+	if !ctx.User.IsAdmin && key.OwnerId != ctx.User.Id {
+		ctx.Error(404)
+		return
+	}
+
+	owner, err := models.GetUserById(key.OwnerId)
+	if err != nil {
+		ctx.JSON(500, &base.ApiJsonErr{"GetUserById: " + err.Error(), base.DOC_URL})
+		return
+	}
+	// Deploy keys are not supported yet, every key belongs to a user.
+	ctx.JSON(200, &PublicKeySearchResult{
+		PublicKey: ToApiPublicKey(key),
+		Owner:     &api.User{owner.Id, owner.Name, owner.FullName, owner.Email, owner.AvatarLink()},
+	})
+}
+
+// apiActor returns access token used by request as actor of security log,
+// or signed in user when request is not authenticated by token.
+func apiActor(ctx *middleware.Context) models.SecurityActor {
+	if ctx.AccessToken != nil {
+		return models.SecurityActor{models.SECURITY_ACTOR_TOKEN, ctx.User.Id, ctx.AccessToken.Name}
+	}
+	return models.SecurityActor{models.SECURITY_ACTOR_SELF, ctx.User.Id, ctx.User.Name}
+}
@@ -0,0 +1,193 @@
+// +build sqlite
+
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Unknwon/macaron"
+	"github.com/macaron-contrib/binding"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// newTestKey returns a new RSA public key in authorized_keys format.
+func newTestKey(t *testing.T, comment string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))) + " " + comment
+}
+
+// newKeyRoutes returns routes of SSH keys of signed in user u.
+func newKeyRoutes(u *models.User) *macaron.Macaron {
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(func(c *macaron.Context) {
+		c.Map(&middleware.Context{Context: c, User: u, IsSigned: true})
+	})
+	bind := binding.Bind
+	m.Combo("/user/keys").
+		Get(ListMyPublicKeys).
+		Post(bind(CreatePublicKeyOption{}), CreateMyPublicKey)
+	m.Combo("/user/keys/:id:int").
+		Get(GetMyPublicKey).
+		Patch(bind(EditPublicKeyOption{}), EditPublicKey).
+		Delete(DeletePublicKey)
+	return m
+}
+
+func serveRoute(m *macaron.Macaron, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body != nil {
+		data, _ := json.Marshal(body)
+		req, _ = http.NewRequest(method, path, strings.NewReader(string(data)))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req, _ = http.NewRequest(method, path, nil)
+	}
+	resp := httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	return resp
+}
+
+// newTestEngine opens a new sqlite database with all tables in a temporary
+// directory, the returned function closes it and restores changed settings.
+func newTestEngine(t *testing.T) func() {
+	tmpDir, err := ioutil.TempDir("", "gogs-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldConfRootPath, oldLogRootPath, oldRepoRootPath := setting.ConfRootPath, setting.LogRootPath, setting.RepoRootPath
+	oldSSHPath, oldDbCfg := models.SSHPath, models.DbCfg
+	restore := func() {
+		setting.ConfRootPath, setting.LogRootPath, setting.RepoRootPath = oldConfRootPath, oldLogRootPath, oldRepoRootPath
+		models.SSHPath, models.DbCfg = oldSSHPath, oldDbCfg
+		os.RemoveAll(tmpDir)
+	}
+
+	setting.ConfRootPath = "../../../conf"
+	setting.LogRootPath = tmpDir
+	setting.RepoRootPath = filepath.Join(tmpDir, "repos")
+	models.SSHPath = filepath.Join(tmpDir, ".ssh")
+	if err = os.MkdirAll(models.SSHPath, 0700); err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	models.DbCfg.Type = "sqlite3"
+	models.DbCfg.Path = filepath.Join(tmpDir, "gogs.db")
+	if err = models.NewEngine(); err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	return func() {
+		models.CloseEngine()
+		restore()
+	}
+}
+
+func TestPublicKeyRoutes(t *testing.T) {
+	defer newTestEngine(t)()
+	var err error
+
+	alice := &models.User{Name: "alice", Email: "alice@example.com", Passwd: "password"}
+	bob := &models.User{Name: "bob", Email: "bob@example.com", Passwd: "password"}
+	for _, u := range []*models.User{alice, bob} {
+		if err = models.CreateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bobKey := &models.PublicKey{OwnerId: bob.Id, Name: "desktop", Content: newTestKey(t, "bob@example.com")}
+	if err = models.AddPublicKey(bobKey, models.SecurityActorSystem); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newKeyRoutes(alice)
+	content := newTestKey(t, "alice@example.com")
+
+	// Create.
+	resp := serveRoute(m, "POST", "/user/keys", CreatePublicKeyOption{Title: "laptop", Key: content})
+	if resp.Code != 201 {
+		t.Fatalf("create: expect 201 but got %d %s", resp.Code, resp.Body.String())
+	}
+	created := new(PublicKey)
+	if err = json.Unmarshal(resp.Body.Bytes(), created); err != nil {
+		t.Fatal(err)
+	} else if created.Title != "laptop" || created.ID == 0 {
+		t.Fatalf("create: unexpected key %+v", created)
+	}
+	if resp = serveRoute(m, "POST", "/user/keys", CreatePublicKeyOption{Title: "again", Key: content}); resp.Code != 409 {
+		t.Errorf("create duplicate: expect 409 but got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = serveRoute(m, "POST", "/user/keys", CreatePublicKeyOption{Title: "bad", Key: "ssh-rsa AAAA"}); resp.Code != 422 {
+		t.Errorf("create invalid: expect 422 but got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// List only has own keys.
+	resp = serveRoute(m, "GET", "/user/keys", nil)
+	keys := make([]*PublicKey, 0, 1)
+	if resp.Code != 200 {
+		t.Fatalf("list: expect 200 but got %d %s", resp.Code, resp.Body.String())
+	} else if err = json.Unmarshal(resp.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 1 || keys[0].ID != created.ID {
+		t.Errorf("list: expect only created key but got %d keys", len(keys))
+	}
+
+	// Get.
+	ownPath := fmt.Sprintf("/user/keys/%d", created.ID)
+	if resp = serveRoute(m, "GET", ownPath, nil); resp.Code != 200 {
+		t.Errorf("get: expect 200 but got %d", resp.Code)
+	}
+	if resp = serveRoute(m, "GET", fmt.Sprintf("/user/keys/%d", bobKey.Id), nil); resp.Code != 404 {
+		t.Errorf("get key of other user: expect 404 but got %d", resp.Code)
+	}
+	if resp = serveRoute(m, "GET", "/user/keys/9999", nil); resp.Code != 404 {
+		t.Errorf("get missing key: expect 404 but got %d", resp.Code)
+	}
+
+	// Edit.
+	if resp = serveRoute(m, "PATCH", ownPath, EditPublicKeyOption{Title: "work laptop"}); resp.Code != 200 {
+		t.Errorf("edit: expect 200 but got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = serveRoute(m, "PATCH", fmt.Sprintf("/user/keys/%d", bobKey.Id), EditPublicKeyOption{Title: "mine"}); resp.Code == 200 {
+		t.Error("edit key of other user: expect failure but got 200")
+	}
+
+	// Delete.
+	if resp = serveRoute(m, "DELETE", fmt.Sprintf("/user/keys/%d", bobKey.Id), nil); resp.Code != 403 {
+		t.Errorf("delete key of other user: expect 403 but got %d", resp.Code)
+	}
+	if resp = serveRoute(m, "DELETE", ownPath, nil); resp.Code != 204 {
+		t.Errorf("delete: expect 204 but got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = serveRoute(m, "GET", ownPath, nil); resp.Code != 404 {
+		t.Errorf("get deleted key: expect 404 but got %d", resp.Code)
+	}
+	if _, err = models.GetPublicKeyById(bobKey.Id); err != nil {
+		t.Errorf("expect key of other user to be kept: %v", err)
+	}
+}
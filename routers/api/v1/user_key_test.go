@@ -0,0 +1,91 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func TestToApiPublicKey(t *testing.T) {
+	oldExpire := setting.UnverifiedSSHKeyExpire
+	defer func() { setting.UnverifiedSSHKeyExpire = oldExpire }()
+	setting.UnverifiedSSHKeyExpire = 7
+
+	created := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	key := &models.PublicKey{
+		Id:                1,
+		Name:              "laptop",
+		Content:           "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFake joe@example.com\n",
+		Fingerprint:       "SHA256:abc",
+		FingerprintSha256: "SHA256:abc",
+		Type:              "ed25519",
+		Size:              256,
+		Created:           created,
+		Updated:           created.Add(time.Hour),
+	}
+
+	apiKey := ToApiPublicKey(key)
+	if apiKey.Key != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFake joe@example.com" {
+		t.Errorf("unexpected key content: %q", apiKey.Key)
+	}
+	if apiKey.KeyType == nil || *apiKey.KeyType != "ed25519" || apiKey.KeySize == nil || *apiKey.KeySize != 256 {
+		t.Errorf("unexpected key type or size: %v, %v", apiKey.KeyType, apiKey.KeySize)
+	}
+	if apiKey.LastUsed == nil || !apiKey.LastUsed.Equal(key.Updated) {
+		t.Errorf("expect last used at %v but got %v", key.Updated, apiKey.LastUsed)
+	}
+	if apiKey.Expires == nil || !apiKey.Expires.Equal(created.AddDate(0, 0, 7)) {
+		t.Errorf("expect unverified key to expire in 7 days but got %v", apiKey.Expires)
+	}
+
+	if apiKey.AccessMode != "full" {
+		t.Errorf("expect key without mode to have full access but got %q", apiKey.AccessMode)
+	}
+	key.Mode = models.KEY_ACCESS_MODE_READ
+	if apiKey = ToApiPublicKey(key); apiKey.AccessMode != "read-only" {
+		t.Errorf("expect read-only access but got %q", apiKey.AccessMode)
+	}
+
+	publicKey := ToApiPublicKeyPublic(key)
+	if publicKey.Key != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFake" {
+		t.Errorf("expect public key without comment but got %q", publicKey.Key)
+	}
+	if publicKey.LastUsed != nil {
+		t.Errorf("expect no usage in public key but got %v", publicKey.LastUsed)
+	}
+}
+
+func TestToApiPublicKeyNulls(t *testing.T) {
+	oldExpire := setting.UnverifiedSSHKeyExpire
+	defer func() { setting.UnverifiedSSHKeyExpire = oldExpire }()
+	setting.UnverifiedSSHKeyExpire = 0
+
+	data, err := json.Marshal(ToApiPublicKey(&models.PublicKey{
+		Id:       1,
+		Content:  "ssh-rsa AAAAB3NzaC1yc2EFake",
+		Verified: true,
+	}))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	fields := make(map[string]interface{})
+	if err = json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	for _, name := range []string{"fingerprint_sha256", "key_type", "key_size", "created_at", "last_used_at", "expires_at"} {
+		value, ok := fields[name]
+		if !ok {
+			t.Errorf("expect field %q to be present", name)
+		} else if value != nil {
+			t.Errorf("expect field %q to be null but got %v", name, value)
+		}
+	}
+}
@@ -24,8 +24,10 @@ import (
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/mailer"
 	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/serv"
 	"github.com/gogits/gogs/modules/setting"
 	"github.com/gogits/gogs/modules/social"
+	"github.com/gogits/gogs/modules/ssh"
 )
 
 const (
@@ -66,8 +68,21 @@ func GlobalInit() {
 		}
 
 		models.HasEngine = true
+		models.NewSearchContext()
+		models.NewKeyReplicationContext()
+		models.NewKeyChangeHookContext()
 		cron.NewCronContext()
 		log.NewGitLogger(path.Join(setting.LogRootPath, "http.log"))
+
+		if err := models.CheckSSHPath(); err != nil {
+			log.Warn("authorized_keys file in %s is not writable, SSH keys cannot be updated: %v", models.SSHPath, err)
+		}
+		if setting.StartSSHServer && !setting.DisableSSH {
+			ssh.Listen(setting.SSHListenHost, setting.SSHListenPort)
+		}
+		if len(setting.InternalSocket) > 0 && !setting.DisableSSH {
+			serv.Listen(setting.InternalSocket, setting.InternalTokenFile)
+		}
 	}
 	if models.EnableSQLite3 {
 		log.Info("SQLite3 Enabled")
@@ -10,14 +10,16 @@ import (
 	"github.com/gogits/gogs/modules/auth"
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/mailer"
 	"github.com/gogits/gogs/modules/middleware"
 	"github.com/gogits/gogs/modules/setting"
 )
 
 const (
-	SETTINGS_OPTIONS base.TplName = "org/settings/options"
-	SETTINGS_DELETE  base.TplName = "org/settings/delete"
-	SETTINGS_HOOKS   base.TplName = "org/settings/hooks"
+	SETTINGS_OPTIONS  base.TplName = "org/settings/options"
+	SETTINGS_DELETE   base.TplName = "org/settings/delete"
+	SETTINGS_HOOKS    base.TplName = "org/settings/hooks"
+	SETTINGS_TRANSFER base.TplName = "org/settings/transfer"
 )
 
 func Settings(ctx *middleware.Context) {
@@ -81,6 +83,61 @@ func SettingsPost(ctx *middleware.Context, form auth.UpdateOrgSettingForm) {
 	ctx.Redirect(setting.AppSubUrl + "/org/" + org.Name + "/settings")
 }
 
+// SettingsTransfer transfers ownership of organization to another member,
+// which takes two steps: choosing new owner and confirming with password.
+func SettingsTransfer(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("org.settings")
+	ctx.Data["PageIsSettingsTransfer"] = true
+
+	org := ctx.Org.Organization
+	if ctx.Req.Method != "POST" {
+		ctx.HTML(200, SETTINGS_TRANSFER)
+		return
+	}
+
+	newOwner, err := models.GetUserByName(ctx.Query("new_owner"))
+	if err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.RenderWithErr(ctx.Tr("form.enterred_invalid_owner_name"), SETTINGS_TRANSFER, nil)
+		} else {
+			ctx.Handle(500, "GetUserByName", err)
+		}
+		return
+	} else if newOwner.Id == ctx.User.Id {
+		ctx.RenderWithErr(ctx.Tr("org.settings.transfer_same_owner"), SETTINGS_TRANSFER, nil)
+		return
+	} else if !org.IsOrgMember(newOwner.Id) {
+		ctx.RenderWithErr(ctx.Tr("org.settings.transfer_not_member", newOwner.Name), SETTINGS_TRANSFER, nil)
+		return
+	}
+	ctx.Data["NewOwner"] = newOwner
+
+	// Ask for confirmation before anything is changed.
+	if ctx.Query("confirm") != "1" {
+		ctx.HTML(200, SETTINGS_TRANSFER)
+		return
+	}
+
+	if _, err = models.UserSignIn(ctx.User.Name, ctx.Query("password")); err != nil {
+		if err == models.ErrUserNotExist {
+			ctx.RenderWithErr(ctx.Tr("form.enterred_invalid_password"), SETTINGS_TRANSFER, nil)
+		} else {
+			ctx.Handle(500, "UserSignIn", err)
+		}
+		return
+	}
+
+	if err = models.TransferOrgOwnership(org.Id, ctx.User, newOwner); err != nil {
+		ctx.Handle(500, "TransferOrgOwnership", err)
+		return
+	}
+	log.Trace("Organization ownership transferred: %s, %s -> %s", org.Name, ctx.User.Name, newOwner.Name)
+	mailer.SendOrgTransferMail(ctx.Render, org, ctx.User, newOwner)
+
+	ctx.Flash.Success(ctx.Tr("org.settings.transfer_success", newOwner.Name))
+	ctx.Redirect(setting.AppSubUrl + "/org/" + org.Name)
+}
+
 func SettingsDelete(ctx *middleware.Context) {
 	ctx.Data["Title"] = ctx.Tr("org.settings")
 	ctx.Data["PageIsSettingsDelete"] = true
@@ -13,6 +13,7 @@ import (
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/git"
+	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/middleware"
 	"github.com/gogits/gogs/modules/setting"
 )
@@ -86,6 +87,12 @@ func Commits(ctx *middleware.Context) {
 	}
 	commits = RenderIssueLinks(commits, ctx.Repo.RepoLink)
 	commits = models.ValidateCommitsWithEmails(commits)
+	// Commits are still listed as unverified when signatures cannot be checked.
+	verifications, err := models.GetSignedCommits(ctx.Repo.Repository.Id, ctx.Repo.CommitId, page, 50)
+	if err != nil {
+		log.Error(4, "GetSignedCommits: %v", err)
+	}
+	commits = models.ParseCommitsWithVerifications(commits, verifications)
 
 	ctx.Data["Commits"] = commits
 	ctx.Data["Username"] = userName
@@ -125,6 +132,7 @@ func SearchCommits(ctx *middleware.Context) {
 	}
 	commits = RenderIssueLinks(commits, ctx.Repo.RepoLink)
 	commits = models.ValidateCommitsWithEmails(commits)
+	commits = models.ParseCommitsWithSignature(commits)
 
 	ctx.Data["Keyword"] = keyword
 	ctx.Data["Username"] = userName
@@ -187,6 +195,7 @@ func FileHistory(ctx *middleware.Context) {
 	}
 	commits = RenderIssueLinks(commits, ctx.Repo.RepoLink)
 	commits = models.ValidateCommitsWithEmails(commits)
+	commits = models.ParseCommitsWithSignature(commits)
 
 	ctx.Data["Commits"] = commits
 	ctx.Data["Username"] = userName
@@ -249,6 +258,7 @@ func Diff(ctx *middleware.Context) {
 	ctx.Data["Title"] = commit.Summary() + " · " + base.ShortSha(commitId)
 	ctx.Data["Commit"] = commit
 	ctx.Data["Author"] = models.ValidateCommitWithEmail(commit)
+	ctx.Data["Verification"] = models.ParseCommitVerification(commit)
 	ctx.Data["Diff"] = diff
 	ctx.Data["Parents"] = parents
 	ctx.Data["DiffNotAvailable"] = diff.NumFiles() == 0
@@ -306,6 +316,7 @@ func CompareDiff(ctx *middleware.Context) {
 		return
 	}
 	commits = models.ValidateCommitsWithEmails(commits)
+	commits = models.ParseCommitsWithSignature(commits)
 
 	ctx.Data["Commits"] = commits
 	ctx.Data["CommitCount"] = commits.Len()
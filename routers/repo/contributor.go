@@ -0,0 +1,47 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repo
+
+import (
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/middleware"
+)
+
+const (
+	CONTRIBUTORS base.TplName = "repo/contributors"
+
+	CONTRIBUTORS_PAGE_SIZE = 30
+)
+
+func Contributors(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("repo.contributors") + " - " + ctx.Repo.Repository.Name
+	ctx.Data["IsRepoToolbarContributors"] = true
+
+	total, err := models.CountRepositoryContributors(ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.Handle(500, "CountRepositoryContributors", err)
+		return
+	}
+	ctx.Data["ContributorsCount"] = total
+
+	page := ctx.QueryInt("p")
+	if page < 1 {
+		page = 1
+	}
+	if page*CONTRIBUTORS_PAGE_SIZE < total {
+		ctx.Data["NextPageNum"] = page + 1
+	}
+	if page > 1 {
+		ctx.Data["LastPageNum"] = page - 1
+	}
+
+	ctx.Data["Contributors"], err = models.GetRepositoryContributors(ctx.Repo.Repository.Id, page, CONTRIBUTORS_PAGE_SIZE)
+	if err != nil {
+		ctx.Handle(500, "GetRepositoryContributors", err)
+		return
+	}
+	ctx.HTML(200, CONTRIBUTORS)
+}
@@ -21,6 +21,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Unknwon/com"
+
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/log"
@@ -107,7 +109,7 @@ func Http(ctx *middleware.Context) {
 
 		authUser, err = models.UserSignIn(authUsername, authPasswd)
 		if err != nil {
-			if err != models.ErrUserNotExist {
+			if err != models.ErrUserNotExist && err != models.ErrBotUserCannotLogin {
 				ctx.Handle(500, "UserSignIn error: %v", err)
 				return
 			}
@@ -161,6 +163,16 @@ func Http(ctx *middleware.Context) {
 	}
 
 	callback := func(rpc string, input []byte) {
+		if rpc == "upload-pack" {
+			var uid int64
+			if authUser != nil {
+				uid = authUser.Id
+			}
+			if err := models.IncreaseRepoClones(repo.Id, uid, ctx.Req.RemoteAddr); err != nil {
+				log.Error(4, "IncreaseRepoClones: %v", err)
+			}
+		}
+
 		if rpc == "receive-pack" {
 			var lastLine int64 = 0
 
@@ -189,33 +201,61 @@ func Http(ctx *middleware.Context) {
 						newCommitId := fields[1]
 						refName := fields[2]
 
+						// Refs rejected by update hook are left as they were.
+						if !isRefUpdated(models.RepoPath(username, reponame), refName, newCommitId) {
+							lastLine = lastLine + size
+							continue
+						}
+
 						// FIXME: handle error.
-						models.Update(refName, oldCommitId, newCommitId, authUsername, username, reponame, authUser.Id)
+						commits, _ := models.Update(refName, oldCommitId, newCommitId, authUsername, username, reponame, authUser.Id)
+						if err = models.QueuePushNotification(repo, models.RepoPath(username, reponame), refName, oldCommitId, authUsername, commits); err != nil {
+							log.Error(4, "QueuePushNotification: %v", err)
+						}
 					}
 					lastLine = lastLine + size
 				} else {
 					break
 				}
 			}
+
+			go func() {
+				if err := models.UpdateRepoSizeByName(username, reponame); err != nil {
+					log.Error(4, "UpdateRepoSizeByName: %v", err)
+				}
+			}()
 		}
 	}
 
+	// Update hook is given repository ID to check rules of repository on pushes over HTTP as well.
 	HTTPBackend(&Config{
 		RepoRootPath: setting.RepoRootPath,
 		GitBinPath:   "git",
 		UploadPack:   true,
 		ReceivePack:  true,
+		Env:          []string{"repoId=" + com.ToStr(repo.Id)},
 		OnSucceed:    callback,
 	})(ctx.Resp, ctx.Req.Request)
 
 	runtime.GC()
 }
 
+// isRefUpdated returns true if ref has been changed to given commit,
+// or deleted when commit ID is all zeros.
+func isRefUpdated(repoPath, refName, newCommitId string) bool {
+	stdout, _, err := com.ExecCmdDir(repoPath, "git", "show-ref", "--verify", refName)
+	if strings.HasPrefix(newCommitId, "0000000") {
+		return err != nil
+	}
+	return err == nil && strings.Split(stdout, " ")[0] == newCommitId
+}
+
 type Config struct {
 	RepoRootPath string
 	GitBinPath   string
 	UploadPack   bool
 	ReceivePack  bool
+	Env          []string
 	OnSucceed    func(rpc string, input []byte)
 }
 
@@ -329,6 +369,9 @@ func serviceRpc(rpc string, hr handler) {
 	args := []string{rpc, "--stateless-rpc", dir}
 	cmd := exec.Command(hr.Config.GitBinPath, args...)
 	cmd.Dir = dir
+	if len(hr.Config.Env) > 0 {
+		cmd.Env = append(os.Environ(), hr.Config.Env...)
+	}
 	cmd.Stdout = w
 	cmd.Stdin = br
 
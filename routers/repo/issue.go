@@ -7,9 +7,6 @@ package repo
 import (
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -36,8 +33,7 @@ const (
 )
 
 var (
-	ErrFileTypeForbidden = errors.New("File type is not allowed")
-	ErrTooManyFiles      = errors.New("Maximum number of files to upload exceeded")
+	ErrTooManyFiles = errors.New("Maximum number of files to upload exceeded")
 )
 
 func Issues(ctx *middleware.Context) {
@@ -183,6 +179,45 @@ func CreateIssue(ctx *middleware.Context) {
 	ctx.Data["AllowedTypes"] = setting.AttachmentAllowedTypes
 	ctx.Data["Collaborators"] = us
 
+	// Templates are optional, broken ones do not prevent creating issues.
+	templates, err := models.GetIssueTemplates(ctx.Repo.Repository.Id, "")
+	if err != nil {
+		log.Error(4, "issue.CreateIssue(GetIssueTemplates): %v", err)
+	} else if len(templates) > 0 {
+		// Use template that is asked for, otherwise the first one.
+		tmpl := templates[0]
+		name := strings.ToLower(ctx.Query("template"))
+		for _, t := range templates {
+			if strings.ToLower(t.Name) == name {
+				tmpl = t
+				break
+			}
+		}
+
+		labels, err := models.GetLabels(ctx.Repo.Repository.Id)
+		if err != nil {
+			ctx.Handle(500, "issue.CreateIssue(GetLabels)", err)
+			return
+		}
+		tmplLabels := make([]*models.Label, 0, len(tmpl.Labels))
+		labelIds := ""
+		for _, l := range labels {
+			for _, name := range tmpl.Labels {
+				if strings.ToLower(l.Name) == strings.ToLower(name) {
+					tmplLabels = append(tmplLabels, l)
+					labelIds += "$" + com.ToStr(l.Id) + "|"
+					break
+				}
+			}
+		}
+
+		ctx.Data["IssueTemplates"] = templates
+		ctx.Data["IssueTemplate"] = tmpl
+		ctx.Data["IssueTemplateLabels"] = tmplLabels
+		ctx.Data["labels"] = labelIds
+		ctx.Data["content"] = tmpl.Body
+	}
+
 	ctx.HTML(200, ISSUE_CREATE)
 }
 
@@ -229,10 +264,29 @@ func CreateIssuePost(ctx *middleware.Context, form auth.CreateIssueForm) {
 		return
 	}
 
+	if models.IsBlocked(ctx.Repo.Owner.Id, ctx.User.Id) {
+		send(403, nil, models.ErrUserBlocked)
+		return
+	}
+
 	// Only collaborators can assign.
 	if !ctx.Repo.IsOwner() {
 		form.AssigneeId = 0
 	}
+
+	// Only keep labels of current repository, which are given by issue template.
+	labels := make([]*models.Label, 0, 5)
+	labelIds := ""
+	for _, strId := range strings.Split(form.Labels, "|") {
+		label, err := models.GetLabelById(com.StrTo(strings.TrimPrefix(strId, "$")).MustInt64())
+		if err != nil || label.RepoId != ctx.Repo.Repository.Id ||
+			strings.Contains(labelIds, "$"+com.ToStr(label.Id)+"|") {
+			continue
+		}
+		labels = append(labels, label)
+		labelIds += "$" + com.ToStr(label.Id) + "|"
+	}
+	form.Labels = labelIds
 	issue := &models.Issue{
 		RepoId:      ctx.Repo.Repository.Id,
 		Index:       int64(ctx.Repo.Repository.NumIssues) + 1,
@@ -252,6 +306,14 @@ func CreateIssuePost(ctx *middleware.Context, form auth.CreateIssueForm) {
 		return
 	}
 
+	for _, label := range labels {
+		label.NumIssues++
+		if err := models.UpdateLabel(label); err != nil {
+			send(500, nil, err)
+			return
+		}
+	}
+
 	if setting.AttachmentEnabled {
 		uploadFiles(ctx, issue.Id, 0)
 	}
@@ -649,7 +711,6 @@ func uploadFiles(ctx *middleware.Context, issueId, commentId int64) {
 		return
 	}
 
-	allowedTypes := strings.Split(setting.AttachmentAllowedTypes, "|")
 	attachments := ctx.Req.MultipartForm.File["attachments"]
 
 	if len(attachments) > setting.AttachmentMaxFiles {
@@ -659,57 +720,19 @@ func uploadFiles(ctx *middleware.Context, issueId, commentId int64) {
 
 	for _, header := range attachments {
 		file, err := header.Open()
-
 		if err != nil {
 			ctx.Handle(500, "issue.Comment(header.Open)", err)
 			return
 		}
 
-		defer file.Close()
-
-		buf := make([]byte, 1024)
-		n, _ := file.Read(buf)
-		if n > 0 {
-			buf = buf[:n]
-		}
-		fileType := http.DetectContentType(buf)
-		fmt.Println(fileType)
-
-		allowed := false
-
-		for _, t := range allowedTypes {
-			t := strings.Trim(t, " ")
-
-			if t == "*/*" || t == fileType {
-				allowed = true
-				break
-			}
-		}
-
-		if !allowed {
-			ctx.Handle(400, "issue.Comment", ErrFileTypeForbidden)
-			return
-		}
-
-		out, err := ioutil.TempFile(setting.AttachmentPath, "attachment_")
-
-		if err != nil {
-			ctx.Handle(500, "ioutil.TempFile", err)
-			return
-		}
-
-		defer out.Close()
-
-		out.Write(buf)
-		_, err = io.Copy(out, file)
+		_, err = models.UploadCommentAttachment(file, header.Filename, issueId, commentId)
+		file.Close()
 		if err != nil {
-			ctx.Handle(500, "io.Copy", err)
-			return
-		}
-
-		_, err = models.CreateAttachment(issueId, commentId, header.Filename, out.Name())
-		if err != nil {
-			ctx.Handle(500, "CreateAttachment", err)
+			if err == models.ErrAttachmentTypeForbidden || err == models.ErrAttachmentTooLarge {
+				ctx.Handle(400, "issue.Comment", err)
+			} else {
+				ctx.Handle(500, "UploadCommentAttachment", err)
+			}
 			return
 		}
 	}
@@ -740,6 +763,11 @@ func Comment(ctx *middleware.Context) {
 		return
 	}
 
+	if models.IsBlocked(ctx.Repo.Owner.Id, ctx.User.Id) {
+		send(403, nil, models.ErrUserBlocked)
+		return
+	}
+
 	issue, err := models.GetIssueByIndex(ctx.Repo.Repository.Id, index)
 	if err != nil {
 		if err == models.ErrIssueNotExist {
@@ -1121,18 +1149,49 @@ func UpdateMilestonePost(ctx *middleware.Context, form auth.CreateMilestoneForm)
 }
 
 func IssueGetAttachment(ctx *middleware.Context) {
-	id := com.StrTo(ctx.Params(":id")).MustInt64()
-	if id == 0 {
-		ctx.Error(404)
+	attachment, err := models.GetAttachmentByUUID(ctx.Params(":uuid"))
+	if err != nil {
+		if err == models.ErrAttachmentNotExist {
+			ctx.Error(404)
+		} else {
+			ctx.Handle(500, "GetAttachmentByUUID", err)
+		}
 		return
 	}
 
-	attachment, err := models.GetAttachmentById(id)
-
+	// Attachment is only visible to users who can read its repository.
+	issue, err := models.GetIssueById(attachment.IssueId)
 	if err != nil {
-		ctx.Handle(404, "issue.IssueGetAttachment(models.GetAttachmentById)", err)
+		ctx.Handle(500, "GetIssueById", err)
+		return
+	}
+	repo, err := models.GetRepositoryById(issue.RepoId)
+	if err != nil {
+		ctx.Handle(500, "GetRepositoryById", err)
+		return
+	}
+	if !repo.HasIssues {
+		ctx.Error(404)
 		return
 	}
+	if repo.IsPrivate {
+		if !ctx.IsSigned {
+			ctx.Error(404)
+			return
+		}
+		has, err := models.HasAccess(ctx.User, repo, models.ACCESS_MODE_READ)
+		if err != nil {
+			ctx.Handle(500, "HasAccess", err)
+			return
+		} else if !has {
+			ctx.Error(404)
+			return
+		}
+	}
+
+	if err = models.IncreaseAttachmentDownloadCount(attachment); err != nil {
+		log.Error(4, "IncreaseAttachmentDownloadCount(%d): %v", attachment.Id, err)
+	}
 
 	// Fix #312. Attachments with , in their name are not handled correctly by Google Chrome.
 	// We must put the name in " manually.
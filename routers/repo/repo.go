@@ -18,6 +18,7 @@ import (
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/git"
 	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/mailer"
 	"github.com/gogits/gogs/modules/middleware"
 	"github.com/gogits/gogs/modules/setting"
 )
@@ -345,7 +346,11 @@ func Action(ctx *middleware.Context) {
 	case "unwatch":
 		err = models.WatchRepo(ctx.User.Id, ctx.Repo.Repository.Id, false)
 	case "star":
-		err = models.StarRepo(ctx.User.Id, ctx.Repo.Repository.Id, true)
+		isStarred := models.IsStaring(ctx.User.Id, ctx.Repo.Repository.Id)
+		if err = models.StarRepo(ctx.User.Id, ctx.Repo.Repository.Id, true); err == nil && !isStarred {
+			ctx.Repo.Repository.NumStars++
+			mailer.SendStarNotification(ctx.Render, ctx.Repo.Repository, ctx.User)
+		}
 	case "unstar":
 		err = models.StarRepo(ctx.User.Id, ctx.Repo.Repository.Id, false)
 	case "desc":
@@ -29,13 +29,23 @@ const (
 	HOOKS            base.TplName = "repo/settings/hooks"
 	GITHOOKS         base.TplName = "repo/settings/githooks"
 	GITHOOK_EDIT     base.TplName = "repo/settings/githook_edit"
+	COMMIT_RULES     base.TplName = "repo/settings/commit_rules"
+	TAG_POLICIES     base.TplName = "repo/settings/tag_policies"
 	HOOK_NEW         base.TplName = "repo/settings/hook_new"
 	ORG_HOOK_NEW     base.TplName = "org/settings/hook_new"
+	ADMIN_HOOK_NEW   base.TplName = "admin/hook_new"
 )
 
 func Settings(ctx *middleware.Context) {
 	ctx.Data["Title"] = ctx.Tr("repo.settings")
 	ctx.Data["PageIsSettingsOptions"] = true
+
+	lastPush, err := models.GetLastKeyPush(ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.Handle(500, "GetLastKeyPush", err)
+		return
+	}
+	ctx.Data["LastKeyPush"] = lastPush
 	ctx.HTML(200, SETTINGS_OPTIONS)
 }
 
@@ -73,17 +83,28 @@ func SettingsPost(ctx *middleware.Context, form auth.RepoSettingForm) {
 
 		br := form.Branch
 
-		if ctx.Repo.GitRepo.IsBranchExist(br) {
-			ctx.Repo.Repository.DefaultBranch = br
+		if br != ctx.Repo.Repository.DefaultBranch && ctx.Repo.GitRepo.IsBranchExist(br) {
+			if err := models.UpdateDefaultBranch(ctx.Repo.Repository, br); err != nil {
+				ctx.Handle(500, "UpdateDefaultBranch", err)
+				return
+			}
 		}
 		ctx.Repo.Repository.Description = form.Description
 		ctx.Repo.Repository.Website = form.Website
 		visibilityChanged := ctx.Repo.Repository.IsPrivate != form.Private
 		ctx.Repo.Repository.IsPrivate = form.Private
+		ctx.Repo.Repository.IsTemplate = form.Template
 		if err := models.UpdateRepository(ctx.Repo.Repository, visibilityChanged); err != nil {
 			ctx.Handle(404, "UpdateRepository", err)
 			return
 		}
+		if ctx.Repo.Repository.HasIssues != form.EnableIssues {
+			if err := models.UpdateRepoSettings(ctx.Repo.Repository.Id,
+				models.RepoSettings{form.EnableIssues, ctx.Repo.Repository.HasWiki}); err != nil {
+				ctx.Handle(500, "UpdateRepoSettings", err)
+				return
+			}
+		}
 		log.Trace("Repository updated: %s/%s", ctx.Repo.Owner.Name, ctx.Repo.Repository.Name)
 
 		if ctx.Repo.Repository.IsMirror {
@@ -242,6 +263,156 @@ func SettingsCollaboration(ctx *middleware.Context) {
 	ctx.HTML(200, COLLABORATION)
 }
 
+func CommitRules(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("repo.settings")
+	ctx.Data["PageIsSettingsCommitRules"] = true
+
+	// Delete commit message rule.
+	remove := com.StrTo(ctx.Query("remove")).MustInt64()
+	if remove > 0 {
+		if err := models.DeleteCommitMessageRule(ctx.Repo.Repository.Id, remove); err != nil {
+			ctx.Handle(500, "DeleteCommitMessageRule", err)
+			return
+		}
+		ctx.Flash.Success(ctx.Tr("repo.settings.remove_commit_rule_success"))
+		ctx.Redirect(ctx.Repo.RepoLink + "/settings/commit_rules")
+		return
+	}
+
+	// Activate or deactivate commit message rule.
+	toggle := com.StrTo(ctx.Query("toggle")).MustInt64()
+	if toggle > 0 {
+		r, err := models.GetCommitMessageRuleById(ctx.Repo.Repository.Id, toggle)
+		if err != nil {
+			if err == models.ErrCommitMessageRuleNotExist {
+				ctx.Handle(404, "GetCommitMessageRuleById", err)
+			} else {
+				ctx.Handle(500, "GetCommitMessageRuleById", err)
+			}
+			return
+		}
+		r.IsActive = !r.IsActive
+		if err = models.UpdateCommitMessageRule(r); err != nil {
+			ctx.Handle(500, "UpdateCommitMessageRule", err)
+			return
+		}
+		ctx.Redirect(ctx.Repo.RepoLink + "/settings/commit_rules")
+		return
+	}
+
+	rules, err := models.GetCommitMessageRules(ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.Handle(500, "GetCommitMessageRules", err)
+		return
+	}
+	ctx.Data["CommitRules"] = rules
+	ctx.HTML(200, COMMIT_RULES)
+}
+
+func CommitRulesPost(ctx *middleware.Context, form auth.CommitMessageRuleForm) {
+	ctx.Data["Title"] = ctx.Tr("repo.settings")
+	ctx.Data["PageIsSettingsCommitRules"] = true
+
+	rules, err := models.GetCommitMessageRules(ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.Handle(500, "GetCommitMessageRules", err)
+		return
+	}
+	ctx.Data["CommitRules"] = rules
+
+	if ctx.HasError() {
+		ctx.HTML(200, COMMIT_RULES)
+		return
+	}
+
+	if err = models.NewCommitMessageRule(&models.CommitMessageRule{
+		RepoId:       ctx.Repo.Repository.Id,
+		Pattern:      form.Pattern,
+		ErrorMessage: form.ErrorMessage,
+		IsRequired:   form.IsRequired,
+		IsActive:     true,
+	}); err != nil {
+		if err == models.ErrCommitMessageRuleInvalidPattern {
+			ctx.Data["Err_Pattern"] = true
+			ctx.RenderWithErr(ctx.Tr("repo.settings.commit_rule_invalid_pattern"), COMMIT_RULES, &form)
+		} else {
+			ctx.Handle(500, "NewCommitMessageRule", err)
+		}
+		return
+	}
+
+	ctx.Flash.Success(ctx.Tr("repo.settings.add_commit_rule_success"))
+	ctx.Redirect(ctx.Repo.RepoLink + "/settings/commit_rules")
+}
+
+func CommitRulesConventional(ctx *middleware.Context) {
+	if err := models.NewConventionalCommitsRule(ctx.Repo.Repository.Id); err != nil {
+		ctx.Handle(500, "NewConventionalCommitsRule", err)
+		return
+	}
+	ctx.Flash.Success(ctx.Tr("repo.settings.add_commit_rule_success"))
+	ctx.Redirect(ctx.Repo.RepoLink + "/settings/commit_rules")
+}
+
+func TagPolicies(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("repo.settings")
+	ctx.Data["PageIsSettingsTagPolicies"] = true
+
+	// Delete tag policy.
+	remove := com.StrTo(ctx.Query("remove")).MustInt64()
+	if remove > 0 {
+		if err := models.DeleteTagPolicy(ctx.Repo.Repository.Id, remove); err != nil {
+			ctx.Handle(500, "DeleteTagPolicy", err)
+			return
+		}
+		ctx.Flash.Success(ctx.Tr("repo.settings.remove_tag_policy_success"))
+		ctx.Redirect(ctx.Repo.RepoLink + "/settings/tag_policies")
+		return
+	}
+
+	policies, err := models.GetTagPolicies(ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.Handle(500, "GetTagPolicies", err)
+		return
+	}
+	ctx.Data["TagPolicies"] = policies
+	ctx.HTML(200, TAG_POLICIES)
+}
+
+func TagPoliciesPost(ctx *middleware.Context, form auth.TagPolicyForm) {
+	ctx.Data["Title"] = ctx.Tr("repo.settings")
+	ctx.Data["PageIsSettingsTagPolicies"] = true
+
+	policies, err := models.GetTagPolicies(ctx.Repo.Repository.Id)
+	if err != nil {
+		ctx.Handle(500, "GetTagPolicies", err)
+		return
+	}
+	ctx.Data["TagPolicies"] = policies
+
+	if ctx.HasError() {
+		ctx.HTML(200, TAG_POLICIES)
+		return
+	}
+
+	if err = models.NewTagPolicy(&models.TagPolicy{
+		RepoId:        ctx.Repo.Repository.Id,
+		Pattern:       form.Pattern,
+		RequireSigned: form.RequireSigned,
+	}); err != nil {
+		if err == models.ErrTagPolicyInvalidPattern {
+			ctx.Data["Err_Pattern"] = true
+			ctx.RenderWithErr(ctx.Tr("repo.settings.tag_policy_invalid_pattern"), TAG_POLICIES, &form)
+		} else {
+			ctx.Handle(500, "NewTagPolicy", err)
+		}
+		return
+	}
+
+	ctx.Flash.Success(ctx.Tr("repo.settings.add_tag_policy_success"))
+	ctx.Redirect(ctx.Repo.RepoLink + "/settings/tag_policies")
+}
+
 func Webhooks(ctx *middleware.Context) {
 	ctx.Data["Title"] = ctx.Tr("repo.settings")
 	ctx.Data["PageIsSettingsHooks"] = true
@@ -318,6 +489,10 @@ func WebHooksNewPost(ctx *middleware.Context, form auth.NewWebhookForm) {
 		Secret:      form.Secret,
 		HookEvent: &models.HookEvent{
 			PushOnly: form.PushOnly,
+			Fork:     form.Fork,
+			Star:     form.Star,
+			Watch:    form.Watch,
+			Key:      form.Key,
 		},
 		IsActive:     form.Active,
 		HookTaskType: models.GOGS,
@@ -334,7 +509,7 @@ func WebHooksNewPost(ctx *middleware.Context, form auth.NewWebhookForm) {
 	}
 
 	ctx.Flash.Success(ctx.Tr("repo.settings.add_hook_success"))
-	ctx.Redirect(orCtx.Link + "/settings/hooks")
+	ctx.Redirect(orCtx.HooksLink)
 }
 
 func WebHooksEdit(ctx *middleware.Context) {
@@ -348,6 +523,12 @@ func WebHooksEdit(ctx *middleware.Context) {
 		return
 	}
 
+	orCtx, err := getOrgRepoCtx(ctx)
+	if err != nil {
+		ctx.Handle(500, "WebHooksEdit(getOrgRepoCtx)", err)
+		return
+	}
+
 	w, err := models.GetWebhookById(hookId)
 	if err != nil {
 		if err == models.ErrWebhookNotExist {
@@ -356,6 +537,9 @@ func WebHooksEdit(ctx *middleware.Context) {
 			ctx.Handle(500, "GetWebhookById", err)
 		}
 		return
+	} else if !orCtx.Owns(w) {
+		ctx.Handle(404, "GetWebhookById", nil)
+		return
 	}
 
 	// set data per HookTaskType
@@ -372,11 +556,6 @@ func WebHooksEdit(ctx *middleware.Context) {
 	}
 	w.GetEvent()
 	ctx.Data["Webhook"] = w
-	orCtx, err := getOrgRepoCtx(ctx)
-	if err != nil {
-		ctx.Handle(500, "WebHooksEdit(getOrgRepoCtx)", err)
-		return
-	}
 	ctx.HTML(200, orCtx.NewTemplate)
 }
 
@@ -391,6 +570,12 @@ func WebHooksEditPost(ctx *middleware.Context, form auth.NewWebhookForm) {
 		return
 	}
 
+	orCtx, err := getOrgRepoCtx(ctx)
+	if err != nil {
+		ctx.Handle(500, "WebHooksEditPost(getOrgRepoCtx)", err)
+		return
+	}
+
 	w, err := models.GetWebhookById(hookId)
 	if err != nil {
 		if err == models.ErrWebhookNotExist {
@@ -399,15 +584,13 @@ func WebHooksEditPost(ctx *middleware.Context, form auth.NewWebhookForm) {
 			ctx.Handle(500, "GetWebhookById", err)
 		}
 		return
+	} else if !orCtx.Owns(w) {
+		ctx.Handle(404, "GetWebhookById", nil)
+		return
 	}
 	w.GetEvent()
 	ctx.Data["Webhook"] = w
 
-	orCtx, err := getOrgRepoCtx(ctx)
-	if err != nil {
-		ctx.Handle(500, "WebHooksEditPost(getOrgRepoCtx)", err)
-		return
-	}
 	if ctx.HasError() {
 		ctx.HTML(200, orCtx.NewTemplate)
 		return
@@ -423,6 +606,10 @@ func WebHooksEditPost(ctx *middleware.Context, form auth.NewWebhookForm) {
 	w.Secret = form.Secret
 	w.HookEvent = &models.HookEvent{
 		PushOnly: form.PushOnly,
+		Fork:     form.Fork,
+		Star:     form.Star,
+		Watch:    form.Watch,
+		Key:      form.Key,
 	}
 	w.IsActive = form.Active
 	if err := w.UpdateEvent(); err != nil {
@@ -434,7 +621,7 @@ func WebHooksEditPost(ctx *middleware.Context, form auth.NewWebhookForm) {
 	}
 
 	ctx.Flash.Success(ctx.Tr("repo.settings.update_hook_success"))
-	ctx.Redirect(fmt.Sprintf("%s/settings/hooks/%d", orCtx.Link, hookId))
+	ctx.Redirect(fmt.Sprintf("%s/%d", orCtx.HooksLink, hookId))
 }
 
 func SlackHooksNewPost(ctx *middleware.Context, form auth.NewSlackHookForm) {
@@ -484,7 +671,7 @@ func SlackHooksNewPost(ctx *middleware.Context, form auth.NewSlackHookForm) {
 	}
 
 	ctx.Flash.Success(ctx.Tr("repo.settings.add_hook_success"))
-	ctx.Redirect(orCtx.Link + "/settings/hooks")
+	ctx.Redirect(orCtx.HooksLink)
 }
 
 func SlackHooksEditPost(ctx *middleware.Context, form auth.NewSlackHookForm) {
@@ -512,6 +699,9 @@ func SlackHooksEditPost(ctx *middleware.Context, form auth.NewSlackHookForm) {
 			ctx.Handle(500, "GetWebhookById", err)
 		}
 		return
+	} else if !orCtx.Owns(w) {
+		ctx.Handle(404, "GetWebhookById", nil)
+		return
 	}
 	w.GetEvent()
 	ctx.Data["Webhook"] = w
@@ -543,35 +733,54 @@ func SlackHooksEditPost(ctx *middleware.Context, form auth.NewSlackHookForm) {
 	}
 
 	ctx.Flash.Success(ctx.Tr("repo.settings.update_hook_success"))
-	ctx.Redirect(fmt.Sprintf("%s/settings/hooks/%d", orCtx.Link, hookId))
+	ctx.Redirect(fmt.Sprintf("%s/%d", orCtx.HooksLink, hookId))
 }
 
 type OrgRepoCtx struct {
 	OrgId       int64
 	RepoId      int64
 	Link        string
+	HooksLink   string
 	NewTemplate base.TplName
 }
 
-// determines whether this is a repo context or organization context
+// Owns returns true if webhook belongs to this context.
+func (c *OrgRepoCtx) Owns(w *models.Webhook) bool {
+	return w.OrgId == c.OrgId && w.RepoId == c.RepoId
+}
+
+// determines whether this is a repo, organization or system context,
+// system webhooks are managed by admins and only support Gogs type.
 func getOrgRepoCtx(ctx *middleware.Context) (*OrgRepoCtx, error) {
+	var orCtx *OrgRepoCtx
 	if _, ok := ctx.Data["RepoLink"]; ok {
-		return &OrgRepoCtx{
+		orCtx = &OrgRepoCtx{
 			OrgId:       int64(0),
 			RepoId:      ctx.Repo.Repository.Id,
 			Link:        ctx.Repo.RepoLink,
+			HooksLink:   ctx.Repo.RepoLink + "/settings/hooks",
 			NewTemplate: HOOK_NEW,
-		}, nil
+		}
 	} else if _, ok := ctx.Data["OrgLink"]; ok {
-		return &OrgRepoCtx{
+		orCtx = &OrgRepoCtx{
 			OrgId:       ctx.Org.Organization.Id,
 			RepoId:      int64(0),
 			Link:        ctx.Org.OrgLink,
+			HooksLink:   ctx.Org.OrgLink + "/settings/hooks",
 			NewTemplate: ORG_HOOK_NEW,
-		}, nil
+		}
+	} else if ctx.Data["PageIsAdmin"] == true {
+		orCtx = &OrgRepoCtx{
+			Link:        setting.AppSubUrl + "/admin",
+			HooksLink:   setting.AppSubUrl + "/admin/hooks",
+			NewTemplate: ADMIN_HOOK_NEW,
+		}
+		ctx.Data["HookTypes"] = []string{"Gogs"}
 	} else {
 		return &OrgRepoCtx{}, errors.New("Unable to set OrgRepo context")
 	}
+	ctx.Data["HooksLink"] = orCtx.HooksLink
+	return orCtx, nil
 }
 
 func GitHooks(ctx *middleware.Context) {
@@ -41,6 +41,14 @@ func Home(ctx *middleware.Context) {
 		return
 	}
 
+	var uid int64
+	if ctx.IsSigned {
+		uid = ctx.User.Id
+	}
+	if err := models.IncreaseRepoViews(ctx.Repo.Repository.Id, uid, ctx.Req.RemoteAddr); err != nil {
+		log.Error(4, "IncreaseRepoViews: %v", err)
+	}
+
 	ctx.Data["IsRepoToolbarSource"] = true
 
 	isViewBranch := ctx.Repo.IsBranch
@@ -232,6 +240,16 @@ func Home(ctx *middleware.Context) {
 	}
 
 	ctx.Data["Paths"] = Paths
+
+	if len(treename) == 0 {
+		// Language breakdown is optional, page is shown without it on error.
+		languages, err := models.GetRepoLanguages(ctx.Repo.Repository.Id)
+		if err != nil {
+			log.Error(4, "GetRepoLanguages: %v", err)
+		} else {
+			ctx.Data["Languages"] = languages
+		}
+	}
 	ctx.Data["TreeName"] = treename
 	ctx.Data["Treenames"] = treenames
 	ctx.Data["TreePath"] = treePath
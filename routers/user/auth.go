@@ -107,6 +107,8 @@ func SignInPost(ctx *middleware.Context, form auth.SignInForm) {
 	if err != nil {
 		if err == models.ErrUserNotExist {
 			ctx.RenderWithErr(ctx.Tr("form.username_password_incorrect"), SIGNIN, &form)
+		} else if err == models.ErrBotUserCannotLogin {
+			ctx.RenderWithErr(ctx.Tr("form.bot_cannot_login"), SIGNIN, &form)
 		} else {
 			ctx.Handle(500, "UserSignIn", err)
 		}
@@ -322,14 +324,8 @@ func Activate(ctx *middleware.Context) {
 
 	// Verify code.
 	if user := models.VerifyUserActiveCode(code); user != nil {
-		user.IsActive = true
-		user.Rands = models.GetUserSalt()
-		if err := models.UpdateUser(user); err != nil {
-			if err == models.ErrUserNotExist {
-				ctx.Error(404)
-			} else {
-				ctx.Handle(500, "UpdateUser", err)
-			}
+		if err := models.ConfirmUserEmail(user); err != nil {
+			ctx.Handle(500, "ConfirmUserEmail", err)
 			return
 		}
 
@@ -437,7 +433,7 @@ func ResetPasswdPost(ctx *middleware.Context) {
 	}
 	ctx.Data["Code"] = code
 
-	if u := models.VerifyUserActiveCode(code); u != nil {
+	if u := models.VerifyUserResetPasswdCode(code); u != nil {
 		// Validate password length.
 		passwd := ctx.Query("password")
 		if len(passwd) < 6 {
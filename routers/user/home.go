@@ -5,8 +5,8 @@
 package user
 
 import (
-	"bytes"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/Unknwon/com"
@@ -142,18 +142,31 @@ func Pulls(ctx *middleware.Context) {
 	ctx.HTML(200, PULLS)
 }
 
-func ShowSSHKeys(ctx *middleware.Context, uid int64) {
-	keys, err := models.ListPublicKeys(uid)
+// ShowSSHKeys streams usable public keys of given user as openssh lines,
+// following same visibility rules as listing keys via API.
+func ShowSSHKeys(ctx *middleware.Context, u *models.User) {
+	ctx.Resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if u.HideSSHKeys && (!ctx.IsSigned || (ctx.User.Id != u.Id && !ctx.User.IsAdmin)) {
+		ctx.Resp.WriteHeader(200)
+		return
+	}
+
+	stamp, err := models.PublicKeysStamp(u.Id)
 	if err != nil {
-		ctx.Handle(500, "ListPublicKeys", err)
+		ctx.Handle(500, "PublicKeysStamp", err)
+		return
+	} else if ctx.HandleETag(stamp, setting.SSHKeysCacheMaxAge) {
 		return
 	}
 
-	var buf bytes.Buffer
-	for i := range keys {
-		buf.WriteString(keys[i].OmitEmail())
+	ctx.Resp.WriteHeader(200)
+	if err = models.IterateUsablePublicKeys(u.Id, func(key *models.PublicKey) error {
+		_, err := io.WriteString(ctx.Resp, key.OmitEmail()+"\n")
+		return err
+	}); err != nil {
+		// Headers have been sent, nothing else can be done.
+		log.Error(4, "IterateUsablePublicKeys: %v", err)
 	}
-	ctx.RenderData(200, buf.Bytes())
 }
 
 func Profile(ctx *middleware.Context) {
@@ -185,7 +198,7 @@ func Profile(ctx *middleware.Context) {
 
 	// Show SSH keys.
 	if isShowKeys {
-		ShowSSHKeys(ctx, u.Id)
+		ShowSSHKeys(ctx, u)
 		return
 	}
 
@@ -200,6 +213,20 @@ func Profile(ctx *middleware.Context) {
 	}
 	ctx.Data["Owner"] = u
 
+	// Number of private repositories is only shown to the user and site admins.
+	if u.PublicRepos, err = models.CountUserPublicRepos(u.Id); err != nil {
+		ctx.Handle(500, "CountUserPublicRepos", err)
+		return
+	}
+	isShowPrivateRepos := ctx.IsSigned && (ctx.User.Id == u.Id || ctx.User.IsAdmin)
+	if isShowPrivateRepos {
+		if u.PrivateRepos, err = models.CountUserPrivateRepos(u.Id); err != nil {
+			ctx.Handle(500, "CountUserPrivateRepos", err)
+			return
+		}
+	}
+	ctx.Data["IsShowPrivateRepos"] = isShowPrivateRepos
+
 	tab := ctx.Query("tab")
 	ctx.Data["TabName"] = tab
 	switch tab {
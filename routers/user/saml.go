@@ -0,0 +1,115 @@
+// Copyright 2015 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/Unknwon/com"
+	gosaml "github.com/crewjam/saml"
+
+	"github.com/gogits/gogs/models"
+	"github.com/gogits/gogs/modules/auth/saml"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/middleware"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// samlServiceProvider returns the SAML login source and its service provider
+// by source ID in the URL.
+func samlServiceProvider(ctx *middleware.Context) (*models.LoginSource, *gosaml.ServiceProvider) {
+	source, err := models.GetLoginSourceById(com.StrTo(ctx.Params(":source")).MustInt64())
+	if err != nil {
+		if err == models.ErrAuthenticationNotExist {
+			ctx.Handle(404, "GetLoginSourceById", err)
+		} else {
+			ctx.Handle(500, "GetLoginSourceById", err)
+		}
+		return nil, nil
+	} else if source.Type != models.SAML || !source.IsActived {
+		ctx.Handle(404, "GetLoginSourceById", nil)
+		return nil, nil
+	}
+
+	prefix := fmt.Sprintf("%sauth/saml/%d/", setting.AppUrl, source.Id)
+	sp, err := source.SAML().ServiceProvider(prefix+"metadata", prefix+"acs")
+	if err != nil {
+		ctx.Handle(500, "ServiceProvider", err)
+		return nil, nil
+	}
+	return source, sp
+}
+
+// SAMLMetadata renders metadata of service provider for identity provider to consume.
+func SAMLMetadata(ctx *middleware.Context) {
+	_, sp := samlServiceProvider(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		ctx.Handle(500, "MarshalIndent", err)
+		return
+	}
+	ctx.Resp.Header().Set("Content-Type", "application/samlmetadata+xml")
+	ctx.Resp.Write(data)
+}
+
+// SAMLLogin starts sign in by redirecting user to identity provider.
+func SAMLLogin(ctx *middleware.Context) {
+	source, sp := samlServiceProvider(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	requestId, redirect, err := saml.AuthnRequest(sp)
+	if err != nil {
+		ctx.Handle(500, "AuthnRequest", err)
+		return
+	}
+	ctx.Session.Set(samlRequestKey(source.Id), requestId)
+	ctx.Redirect(redirect)
+}
+
+func samlRequestKey(sourceId int64) string {
+	return fmt.Sprintf("saml_request_%d", sourceId)
+}
+
+// SAMLAssertionConsumer validates SAML response posted by identity provider
+// and signs in the corresponding local user. Only responses to the request
+// made by SAMLLogin in the same session are accepted.
+func SAMLAssertionConsumer(ctx *middleware.Context) {
+	source, sp := samlServiceProvider(ctx)
+	if ctx.Written() {
+		return
+	}
+
+	requestId, _ := ctx.Session.Get(samlRequestKey(source.Id)).(string)
+	ctx.Session.Delete(samlRequestKey(source.Id))
+	id, err := source.SAML().ParseResponse(sp, ctx.Req.Request, requestId)
+	if err != nil {
+		log.Warn("Fail to validate SAML response by %s: %v", source.Name, err)
+		ctx.Handle(403, "ParseResponse", err)
+		return
+	}
+
+	u, err := models.LoginUserSAMLSource(id, source.Id, source.AllowAutoRegister)
+	if err != nil {
+		switch err {
+		case models.ErrUserNotExist, models.ErrUserNotActive, models.ErrBotUserCannotLogin:
+			ctx.Handle(403, "LoginUserSAMLSource", err)
+		default:
+			ctx.Handle(500, "LoginUserSAMLSource", err)
+		}
+		return
+	}
+	log.Trace("User signed in by SAML(%s): %s", source.Name, u.Name)
+
+	ctx.Session.Set("uid", u.Id)
+	ctx.Session.Set("uname", u.Name)
+	ctx.Redirect(setting.AppSubUrl + "/")
+}
@@ -5,7 +5,6 @@
 package user
 
 import (
-	"io/ioutil"
 	"strings"
 
 	"github.com/Unknwon/com"
@@ -13,6 +12,7 @@ import (
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/auth"
 	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/git"
 	"github.com/gogits/gogs/modules/log"
 	"github.com/gogits/gogs/modules/mailer"
 	"github.com/gogits/gogs/modules/middleware"
@@ -24,6 +24,10 @@ const (
 	SETTINGS_PASSWORD     base.TplName = "user/settings/password"
 	SETTINGS_EMAILS       base.TplName = "user/settings/email"
 	SETTINGS_SSH_KEYS     base.TplName = "user/settings/sshkeys"
+	SETTINGS_SSH_ACTIVITY base.TplName = "user/settings/ssh_activity"
+	SETTINGS_GPG_KEYS     base.TplName = "user/settings/gpgkeys"
+	SETTINGS_SECURITY_LOG base.TplName = "user/settings/security_log"
+	SETTINGS_EXPORT       base.TplName = "user/settings/export"
 	SETTINGS_SOCIAL       base.TplName = "user/settings/social"
 	SETTINGS_APPLICATIONS base.TplName = "user/settings/applications"
 	SETTINGS_DELETE       base.TplName = "user/settings/delete"
@@ -48,6 +52,12 @@ func SettingsPost(ctx *middleware.Context, form auth.UpdateProfileForm) {
 		return
 	}
 
+	if len(form.DefaultBranchName) > 0 && !git.IsValidBranchName(form.DefaultBranchName) {
+		ctx.Data["Err_DefaultBranchName"] = true
+		ctx.RenderWithErr(ctx.Tr("settings.default_branch_invalid"), SETTINGS_PROFILE, &form)
+		return
+	}
+
 	// Check if user name has been changed.
 	if ctx.User.Name != form.UserName {
 		isExist, err := models.IsUserExist(ctx.User.Id, form.UserName)
@@ -74,22 +84,33 @@ func SettingsPost(ctx *middleware.Context, form auth.UpdateProfileForm) {
 		ctx.User.Name = form.UserName
 	}
 
+	needConfirm, err := models.SetUserEmail(ctx.User, form.Email)
+	if err != nil {
+		ctx.Handle(500, "SetUserEmail", err)
+		return
+	}
 	ctx.User.FullName = form.FullName
-	ctx.User.Email = form.Email
 	ctx.User.Website = form.Website
 	ctx.User.Location = form.Location
+	ctx.User.DefaultBranchName = form.DefaultBranchName
 	ctx.User.Avatar = base.EncodeMd5(form.Avatar)
 	ctx.User.AvatarEmail = form.Avatar
+	ctx.User.EnableEmailNotification = form.EnableEmailNotification
+	ctx.User.HideSSHKeys = form.HideSSHKeys
 	if err := models.UpdateUser(ctx.User); err != nil {
 		ctx.Handle(500, "UpdateUser", err)
 		return
 	}
 	log.Trace("User setting updated: %s", ctx.User.Name)
-	ctx.Flash.Success(ctx.Tr("settings.update_profile_success"))
+	if needConfirm && setting.Service.RegisterEmailConfirm {
+		mailer.SendActiveMail(ctx.Render, ctx.User)
+		ctx.Flash.Success(ctx.Tr("settings.update_profile_success_confirm_email", ctx.User.Email))
+	} else {
+		ctx.Flash.Success(ctx.Tr("settings.update_profile_success"))
+	}
 	ctx.Redirect(setting.AppSubUrl + "/user/settings")
 }
 
-// FIXME: limit size.
 func SettingsAvatar(ctx *middleware.Context, form auth.UploadAvatarForm) {
 	defer ctx.Redirect(setting.AppSubUrl + "/user/settings")
 
@@ -101,20 +122,22 @@ func SettingsAvatar(ctx *middleware.Context, form auth.UploadAvatarForm) {
 			ctx.Flash.Error(err.Error())
 			return
 		}
+		defer fr.Close()
 
-		data, err := ioutil.ReadAll(fr)
-		if err != nil {
-			ctx.Flash.Error(err.Error())
-			return
-		}
-		if _, ok := base.IsImageFile(data); !ok {
-			ctx.Flash.Error(ctx.Tr("settings.uploaded_avatar_not_a_image"))
-			return
-		}
-		if err = ctx.User.UploadAvatar(data); err != nil {
-			ctx.Flash.Error(err.Error())
+		if err = models.UploadAvatar(ctx.User.Id, fr); err != nil {
+			switch err {
+			case models.ErrAvatarNotImage:
+				ctx.Flash.Error(ctx.Tr("settings.uploaded_avatar_not_a_image"))
+			case models.ErrAvatarTooLarge:
+				ctx.Flash.Error(ctx.Tr("settings.uploaded_avatar_is_too_big", models.MAX_AVATAR_FILE_SIZE>>20))
+			case models.ErrAvatarTooManyPixels:
+				ctx.Flash.Error(ctx.Tr("settings.uploaded_avatar_too_many_pixels", models.MAX_AVATAR_WIDTH, models.MAX_AVATAR_HEIGHT))
+			default:
+				ctx.Flash.Error(err.Error())
+			}
 			return
 		}
+		ctx.User.UseCustomAvatar = true
 	} else {
 		// In case no avatar at all.
 		if form.Enable && !com.IsFile(ctx.User.CustomAvatarPath()) {
@@ -131,6 +154,15 @@ func SettingsAvatar(ctx *middleware.Context, form auth.UploadAvatarForm) {
 	ctx.Flash.Success(ctx.Tr("settings.update_avatar_success"))
 }
 
+func SettingsDeleteAvatar(ctx *middleware.Context) {
+	if err := models.DeleteAvatar(ctx.User.Id); err != nil {
+		ctx.Flash.Error(err.Error())
+	} else {
+		ctx.Flash.Success(ctx.Tr("settings.delete_avatar_success"))
+	}
+	ctx.Redirect(setting.AppSubUrl + "/user/settings")
+}
+
 func SettingsEmails(ctx *middleware.Context) {
 	ctx.Data["Title"] = ctx.Tr("settings")
 	ctx.Data["PageIsUserSettings"] = true
@@ -272,15 +304,63 @@ func SettingsPasswordPost(ctx *middleware.Context, form auth.ChangePasswordForm)
 	ctx.Redirect(setting.AppSubUrl + "/user/settings/password")
 }
 
+// selfActor returns signed in user as actor of security log.
+func selfActor(ctx *middleware.Context) models.SecurityActor {
+	return models.SecurityActor{models.SECURITY_ACTOR_SELF, ctx.User.Id, ctx.User.Name}
+}
+
+// prepareSSHKeys sets a page of SSH keys of signed in user filtered and ordered
+// by query parameters, and whether any key does not meet current key policy.
+func prepareSSHKeys(ctx *middleware.Context) {
+	opts := &models.PublicKeyListOptions{
+		Type:    ctx.Query("type"),
+		OrderBy: ctx.Query("sort"),
+	}
+	ctx.Data["KeyType"] = opts.Type
+	ctx.Data["SortType"] = opts.OrderBy
+	ctx.Data["KeyTypes"] = []string{"RSA", "DSA", "ECDSA", "ED25519"}
+
+	page := ctx.QueryInt("p")
+	if page < 1 {
+		page = 1
+	}
+	total, err := models.CountPublicKeys(ctx.User.Id, opts)
+	if err != nil {
+		ctx.Handle(500, "CountPublicKeys", err)
+		return
+	}
+	if int64(page*models.PUBLIC_KEY_PAGE_SIZE) < total {
+		ctx.Data["NextPageNum"] = page + 1
+	}
+	if page > 1 {
+		ctx.Data["LastPageNum"] = page - 1
+	}
+
+	ctx.Data["Keys"], err = models.ListPublicKeysPaged(ctx.User.Id, page, models.PUBLIC_KEY_PAGE_SIZE, opts)
+	if err != nil {
+		ctx.Handle(500, "ListPublicKeysPaged", err)
+		return
+	}
+	ctx.Data["HasKeyBelowPolicy"], err = models.HasPublicKeyBelowPolicy(ctx.User.Id)
+	if err != nil {
+		ctx.Handle(500, "HasPublicKeyBelowPolicy", err)
+		return
+	}
+	if source, err := models.GetKeySourceByOwner(ctx.User.Id); err == nil {
+		ctx.Data["KeySource"] = source
+	} else if err != models.ErrKeySourceNotExist {
+		ctx.Handle(500, "GetKeySourceByOwner", err)
+		return
+	}
+}
+
 func SettingsSSHKeys(ctx *middleware.Context) {
 	ctx.Data["Title"] = ctx.Tr("settings")
 	ctx.Data["PageIsUserSettings"] = true
 	ctx.Data["PageIsSettingsSSHKeys"] = true
 
-	var err error
-	ctx.Data["Keys"], err = models.ListPublicKeys(ctx.User.Id)
-	if err != nil {
-		ctx.Handle(500, "ssh.ListPublicKey", err)
+	prepareSSHKeys(ctx)
+	if ctx.Written() {
 		return
 	}
 
@@ -292,13 +372,13 @@ func SettingsSSHKeysPost(ctx *middleware.Context, form auth.AddSSHKeyForm) {
 	ctx.Data["PageIsUserSettings"] = true
 	ctx.Data["PageIsSettingsSSHKeys"] = true
 
-	var err error
-	ctx.Data["Keys"], err = models.ListPublicKeys(ctx.User.Id)
-	if err != nil {
-		ctx.Handle(500, "ssh.ListPublicKey", err)
+	prepareSSHKeys(ctx)
+	if ctx.Written() {
 		return
 	}
 
+	var err error
+
 	// Delete SSH key.
 	if ctx.Query("_method") == "DELETE" {
 		id := com.StrTo(ctx.Query("id")).MustInt64()
@@ -306,8 +386,22 @@ func SettingsSSHKeysPost(ctx *middleware.Context, form auth.AddSSHKeyForm) {
 			return
 		}
 
-		if err = models.DeletePublicKey(&models.PublicKey{Id: id}); err != nil {
-			ctx.Handle(500, "DeletePublicKey", err)
+		// Existence and ownership are checked by deletion.
+		key, _ := models.GetPublicKeyById(id)
+		if key != nil && key.OwnerId == ctx.User.Id && key.IsExternal() {
+			ctx.Flash.Error(ctx.Tr("settings.ssh_key_externally_managed", key.Name))
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+			return
+		}
+		if err = models.DeletePublicKey(ctx.User.Id, id, selfActor(ctx)); err != nil {
+			switch err {
+			case models.ErrKeyNotExist:
+				ctx.Handle(404, "DeletePublicKey", err)
+			case models.ErrKeyAccessDenied:
+				ctx.Handle(403, "DeletePublicKey", err)
+			default:
+				ctx.Handle(500, "DeletePublicKey", err)
+			}
 		} else {
 			log.Trace("SSH key deleted: %s", ctx.User.Name)
 			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
@@ -315,6 +409,117 @@ func SettingsSSHKeysPost(ctx *middleware.Context, form auth.AddSSHKeyForm) {
 		return
 	}
 
+	// Verify ownership of SSH key.
+	if ctx.Query("_method") == "VERIFY" {
+		if err = models.VerifyPublicKey(ctx.User.Id, com.StrTo(ctx.Query("id")).MustInt64(), ctx.Query("signature")); err != nil {
+			switch err {
+			case models.ErrKeyNotExist:
+				ctx.Handle(404, "VerifyPublicKey", err)
+			case models.ErrKeyAccessDenied:
+				ctx.Handle(403, "VerifyPublicKey", err)
+			case models.ErrKeyVerifyFailed:
+				ctx.Flash.Error(ctx.Tr("settings.ssh_key_verify_failed"))
+				ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+			default:
+				ctx.Handle(500, "VerifyPublicKey", err)
+			}
+			return
+		}
+		log.Trace("SSH key verified: %s", ctx.User.Name)
+		ctx.Flash.Success(ctx.Tr("settings.ssh_key_verify_success"))
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		return
+	}
+
+	// Rename SSH key.
+	if ctx.Query("_method") == "RENAME" {
+		id := com.StrTo(ctx.Query("id")).MustInt64()
+		if len(form.SSHTitle) == 0 {
+			ctx.Flash.Error(ctx.Tr("settings.key_name") + ctx.Tr("form.require_error"))
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+			return
+		}
+
+		if err = models.UpdatePublicKeyName(ctx.User.Id, id, form.SSHTitle); err != nil {
+			switch {
+			case err == models.ErrKeyNotExist:
+				ctx.Handle(404, "UpdatePublicKeyName", err)
+			case err == models.ErrKeyExternallyManaged:
+				ctx.Flash.Error(ctx.Tr("settings.ssh_key_externally_managed", form.SSHTitle))
+				ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+			case models.IsErrKeyNameAlreadyUsed(err):
+				ctx.Data["RenameKeyId"] = id
+				ctx.RenderWithErr(ctx.Tr("form.ssh_key_name_been_used", err.(models.ErrKeyNameAlreadyUsed).Name), SETTINGS_SSH_KEYS, &form)
+			default:
+				ctx.Handle(500, "UpdatePublicKeyName", err)
+			}
+			return
+		}
+		log.Trace("SSH key(%d) renamed: %s", id, ctx.User.Name)
+		ctx.Flash.Success(ctx.Tr("settings.ssh_key_rename_success"))
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		return
+	}
+
+	// Disable or enable SSH key.
+	if method := ctx.Query("_method"); method == "DISABLE" || method == "ENABLE" {
+		key, err := models.GetPublicKeyById(com.StrTo(ctx.Query("id")).MustInt64())
+		if err != nil {
+			if err == models.ErrKeyNotExist {
+				ctx.Handle(404, "GetPublicKeyById", err)
+			} else {
+				ctx.Handle(500, "GetPublicKeyById", err)
+			}
+			return
+		} else if key.OwnerId != ctx.User.Id {
+			ctx.Handle(404, "GetPublicKeyById", models.ErrKeyNotExist)
+			return
+		} else if key.IsExternal() {
+			ctx.Flash.Error(ctx.Tr("settings.ssh_key_externally_managed", key.Name))
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+			return
+		}
+
+		if key.IsDisabled == (method == "DISABLE") {
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+			return
+		}
+		if err = models.SetPublicKeyDisabled(key, method == "DISABLE", selfActor(ctx)); err != nil {
+			ctx.Handle(500, "SetPublicKeyDisabled", err)
+			return
+		}
+		log.Trace("SSH key %s(%d) disabled[%v]: %s", key.Name, key.Id, key.IsDisabled, ctx.User.Name)
+		if key.IsDisabled {
+			ctx.Flash.Success(ctx.Tr("settings.ssh_key_disabled_success", key.Name))
+		} else {
+			ctx.Flash.Success(ctx.Tr("settings.ssh_key_enabled_success", key.Name))
+		}
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		return
+	}
+
+	// Change access mode of SSH key.
+	if ctx.Query("_method") == "MODE" {
+		id := com.StrTo(ctx.Query("id")).MustInt64()
+		mode, err := models.ParseKeyAccessMode(ctx.Query("mode"))
+		if err != nil {
+			ctx.Handle(400, "ParseKeyAccessMode", err)
+			return
+		}
+		if err = models.UpdatePublicKeyMode(ctx.User.Id, id, mode); err != nil {
+			if err == models.ErrKeyNotExist {
+				ctx.Handle(404, "UpdatePublicKeyMode", err)
+			} else {
+				ctx.Handle(500, "UpdatePublicKeyMode", err)
+			}
+			return
+		}
+		log.Trace("SSH key(%d) access mode changed to %s: %s", id, mode, ctx.User.Name)
+		ctx.Flash.Success(ctx.Tr("settings.ssh_key_mode_success"))
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		return
+	}
+
 	// Add new SSH key.
 	if ctx.Req.Method == "POST" {
 		if ctx.HasError() {
@@ -325,6 +530,7 @@ func SettingsSSHKeysPost(ctx *middleware.Context, form auth.AddSSHKeyForm) {
 		// Parse openssh style string from form content
 		content, err := models.ParseKeyString(form.Content)
 		if err != nil {
+			middleware.KeyRateLimitFailure(ctx)
 			ctx.Flash.Error(ctx.Tr("form.invalid_ssh_key", err.Error()))
 			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
 			return
@@ -334,6 +540,7 @@ func SettingsSSHKeysPost(ctx *middleware.Context, form auth.AddSSHKeyForm) {
 			if err == models.ErrKeyUnableVerify {
 				ctx.Flash.Info(ctx.Tr("form.unable_verify_ssh_key"))
 			} else {
+				middleware.KeyRateLimitFailure(ctx)
 				ctx.Flash.Error(ctx.Tr("form.invalid_ssh_key", err.Error()))
 				ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
 				return
@@ -345,16 +552,31 @@ func SettingsSSHKeysPost(ctx *middleware.Context, form auth.AddSSHKeyForm) {
 			Name:    form.SSHTitle,
 			Content: content,
 		}
-		if err := models.AddPublicKey(k); err != nil {
+		if err := models.AddPublicKey(k, selfActor(ctx)); err != nil {
 			if err == models.ErrKeyAlreadyExist {
 				ctx.RenderWithErr(ctx.Tr("form.ssh_key_been_used"), SETTINGS_SSH_KEYS, &form)
 				return
+			} else if models.IsErrKeyFingerprintAlreadyUsed(err) {
+				ctx.RenderWithErr(ctx.Tr("form.ssh_key_registered_to", err.(models.ErrKeyFingerprintAlreadyUsed).OwnerName), SETTINGS_SSH_KEYS, &form)
+				return
+			} else if models.IsErrKeyQuotaExceeded(err) {
+				ctx.RenderWithErr(ctx.Tr("form.ssh_key_quota_exceeded", err.(models.ErrKeyQuotaExceeded).Quota), SETTINGS_SSH_KEYS, &form)
+				return
+			} else if models.IsErrKeyNameAlreadyUsed(err) {
+				ctx.Data["Err_SSHTitle"] = true
+				ctx.RenderWithErr(ctx.Tr("form.ssh_key_name_been_used", form.SSHTitle), SETTINGS_SSH_KEYS, &form)
+				return
 			}
 			ctx.Handle(500, "ssh.AddPublicKey", err)
 			return
 		} else {
 			log.Trace("SSH key added: %s", ctx.User.Name)
-			ctx.Flash.Success(ctx.Tr("settings.add_key_success"))
+			mailer.SendSSHKeyAddedMail(ctx.Render, ctx.User, k, "web")
+			if k.IsPending {
+				ctx.Flash.Success(ctx.Tr("settings.add_key_pending"))
+			} else {
+				ctx.Flash.Success(ctx.Tr("settings.add_key_success"))
+			}
 			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
 			return
 		}
@@ -363,6 +585,300 @@ func SettingsSSHKeysPost(ctx *middleware.Context, form auth.AddSSHKeyForm) {
 	ctx.HTML(200, SETTINGS_SSH_KEYS)
 }
 
+// SettingsSSHKeySourcePost sets or removes key source URL of user,
+// keys are synchronized right away when URL is set.
+func SettingsSSHKeySourcePost(ctx *middleware.Context, form auth.KeySourceForm) {
+	if ctx.Query("_method") == "DELETE" {
+		if err := models.DeleteKeySource(ctx.User.Id); err != nil && err != models.ErrKeySourceNotExist {
+			ctx.Handle(500, "DeleteKeySource", err)
+			return
+		}
+		log.Trace("Key source removed: %s", ctx.User.Name)
+		ctx.Flash.Success(ctx.Tr("settings.key_source_removed"))
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		return
+	}
+
+	if ctx.HasError() {
+		ctx.Flash.Error(ctx.Data["ErrorMsg"].(string))
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		return
+	}
+
+	source, err := models.SetKeySource(ctx.User.Id, form.KeySourceUrl)
+	if err != nil {
+		if err == models.ErrKeySourceURLInvalid {
+			ctx.Flash.Error(ctx.Tr("settings.key_source_invalid"))
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		} else if err == models.ErrKeySourceURLNotAllowed {
+			ctx.Flash.Error(ctx.Tr("settings.key_source_not_allowed"))
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		} else {
+			ctx.Handle(500, "SetKeySource", err)
+		}
+		return
+	}
+	log.Trace("Key source set: %s", ctx.User.Name)
+
+	added, removed, err := models.SyncKeySource(source)
+	if err != nil {
+		log.Warn("SyncKeySource[%d]: %v", source.Id, err)
+		ctx.Flash.Error(ctx.Tr("settings.key_source_sync_failed"))
+	} else {
+		ctx.Flash.Success(ctx.Tr("settings.key_source_synced", len(added), len(removed)))
+	}
+	ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+}
+
+// SettingsSSHKeysImportPost imports public keys of a GitHub user,
+// and shows what happened to each of them.
+func SettingsSSHKeysImportPost(ctx *middleware.Context, form auth.ImportSSHKeysForm) {
+	ctx.Data["Title"] = ctx.Tr("settings")
+	ctx.Data["PageIsUserSettings"] = true
+	ctx.Data["PageIsSettingsSSHKeys"] = true
+
+	if ctx.HasError() {
+		ctx.Flash.Error(ctx.Data["ErrorMsg"].(string))
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		return
+	}
+
+	results, err := models.ImportGitHubPublicKeys(ctx.User.Id, form.GitHubName, selfActor(ctx))
+	if err != nil {
+		switch {
+		case err == models.ErrGitHubUserNameInvalid:
+			ctx.Flash.Error(ctx.Tr("settings.import_github_invalid_name", form.GitHubName))
+		case err == models.ErrGitHubUserNotExist:
+			ctx.Flash.Error(ctx.Tr("settings.import_github_user_not_exist", form.GitHubName))
+		case models.IsErrGitHubKeysFetch(err):
+			log.Warn("ImportGitHubPublicKeys: %v", err)
+			ctx.Flash.Error(ctx.Tr("settings.import_github_fetch_failed", form.GitHubName))
+		default:
+			ctx.Handle(500, "ImportGitHubPublicKeys", err)
+			return
+		}
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/ssh")
+		return
+	}
+	log.Trace("SSH keys imported from GitHub user %s: %s", form.GitHubName, ctx.User.Name)
+
+	for _, r := range results {
+		if r.Key != nil {
+			mailer.SendSSHKeyAddedMail(ctx.Render, ctx.User, r.Key, "web")
+		}
+	}
+
+	// Load keys after importing so new ones are listed.
+	prepareSSHKeys(ctx)
+	if ctx.Written() {
+		return
+	}
+	ctx.Data["GitHubName"] = form.GitHubName
+	ctx.Data["ImportResults"] = results
+	ctx.HTML(200, SETTINGS_SSH_KEYS)
+}
+
+func SettingsGPGKeys(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("settings")
+	ctx.Data["PageIsUserSettings"] = true
+	ctx.Data["PageIsSettingsGPGKeys"] = true
+
+	var err error
+	ctx.Data["Keys"], err = models.ListGPGKeys(ctx.User.Id)
+	if err != nil {
+		ctx.Handle(500, "ListGPGKeys", err)
+		return
+	}
+
+	ctx.HTML(200, SETTINGS_GPG_KEYS)
+}
+
+func SettingsGPGKeysPost(ctx *middleware.Context, form auth.AddGPGKeyForm) {
+	ctx.Data["Title"] = ctx.Tr("settings")
+	ctx.Data["PageIsUserSettings"] = true
+	ctx.Data["PageIsSettingsGPGKeys"] = true
+
+	var err error
+	ctx.Data["Keys"], err = models.ListGPGKeys(ctx.User.Id)
+	if err != nil {
+		ctx.Handle(500, "ListGPGKeys", err)
+		return
+	}
+
+	// Delete GPG key.
+	if ctx.Query("_method") == "DELETE" {
+		id := com.StrTo(ctx.Query("id")).MustInt64()
+		if id <= 0 {
+			return
+		}
+
+		if err = models.DeleteGPGKey(ctx.User.Id, id); err != nil && err != models.ErrGPGKeyNotExist {
+			ctx.Handle(500, "DeleteGPGKey", err)
+		} else {
+			log.Trace("GPG key deleted: %s", ctx.User.Name)
+			ctx.Flash.Success(ctx.Tr("settings.delete_gpg_key_success"))
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/gpg")
+		}
+		return
+	}
+
+	// Add new GPG key.
+	if ctx.HasError() {
+		ctx.HTML(200, SETTINGS_GPG_KEYS)
+		return
+	}
+
+	if _, err = models.AddGPGKey(ctx.User.Id, form.Content); err != nil {
+		switch err {
+		case models.ErrGPGKeyAlreadyExist:
+			ctx.RenderWithErr(ctx.Tr("form.gpg_key_been_used"), SETTINGS_GPG_KEYS, &form)
+		case models.ErrGPGKeyInvalid:
+			ctx.RenderWithErr(ctx.Tr("form.invalid_gpg_key"), SETTINGS_GPG_KEYS, &form)
+		default:
+			ctx.Handle(500, "AddGPGKey", err)
+		}
+		return
+	}
+
+	log.Trace("GPG key added: %s", ctx.User.Name)
+	ctx.Flash.Success(ctx.Tr("settings.add_gpg_key_success"))
+	ctx.Redirect(setting.AppSubUrl + "/user/settings/gpg")
+}
+
+// ServePublicKeys streams all SSH keys of signed in user
+// as openssh lines or JSON array when format is "json".
+func ServePublicKeys(ctx *middleware.Context) error {
+	asJSON := ctx.Query("format") == "json"
+	if asJSON {
+		ctx.Resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+		ctx.Resp.Header().Set("Content-Disposition", "attachment; filename="+ctx.User.Name+"_ssh_keys.json")
+	} else {
+		ctx.Resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		ctx.Resp.Header().Set("Content-Disposition", "attachment; filename="+ctx.User.Name+"_ssh_keys.pub")
+	}
+	return models.ExportPublicKeys(ctx.Resp, ctx.User.Id, asJSON)
+}
+
+func SettingsSSHKeysExport(ctx *middleware.Context) {
+	if err := ServePublicKeys(ctx); err != nil {
+		log.Error(4, "ServePublicKeys: %v", err)
+	}
+}
+
+func SettingsSSHKeyActivity(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("settings")
+	ctx.Data["PageIsUserSettings"] = true
+	ctx.Data["PageIsSettingsSSHKeys"] = true
+
+	key, err := models.GetPublicKeyById(com.StrTo(ctx.Params(":id")).MustInt64())
+	if err != nil {
+		if err == models.ErrKeyNotExist {
+			ctx.Handle(404, "GetPublicKeyById", err)
+		} else {
+			ctx.Handle(500, "GetPublicKeyById", err)
+		}
+		return
+	} else if key.OwnerId != ctx.User.Id {
+		ctx.Handle(404, "GetPublicKeyById", models.ErrKeyNotExist)
+		return
+	}
+	ctx.Data["Key"] = key
+
+	page := ctx.QueryInt("p")
+	if page < 1 {
+		page = 1
+	}
+	if int64(page*models.KEY_ACTIVITY_PAGE_SIZE) < models.CountKeyActivity(key.Id) {
+		ctx.Data["NextPageNum"] = page + 1
+	}
+	if page > 1 {
+		ctx.Data["LastPageNum"] = page - 1
+	}
+
+	ctx.Data["Usages"], err = models.GetKeyUsages(key.Id)
+	if err != nil {
+		ctx.Handle(500, "GetKeyUsages", err)
+		return
+	}
+	ctx.Data["Activities"], err = models.ListKeyActivity(key.Id, page)
+	if err != nil {
+		ctx.Handle(500, "ListKeyActivity", err)
+		return
+	}
+	ctx.HTML(200, SETTINGS_SSH_ACTIVITY)
+}
+
+func SettingsSecurityLog(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("settings")
+	ctx.Data["PageIsUserSettings"] = true
+	ctx.Data["PageIsSettingsSecurityLog"] = true
+
+	page := ctx.QueryInt("p")
+	if page < 1 {
+		page = 1
+	}
+	if int64(page*models.SECURITY_LOG_PAGE_SIZE) < models.CountSecurityLogs(ctx.User.Id) {
+		ctx.Data["NextPageNum"] = page + 1
+	}
+	if page > 1 {
+		ctx.Data["LastPageNum"] = page - 1
+	}
+
+	var err error
+	ctx.Data["Logs"], err = models.SearchSecurityLogs(ctx.User.Id, page)
+	if err != nil {
+		ctx.Handle(500, "SearchSecurityLogs", err)
+		return
+	}
+	ctx.HTML(200, SETTINGS_SECURITY_LOG)
+}
+
+func SettingsExport(ctx *middleware.Context) {
+	ctx.Data["Title"] = ctx.Tr("settings")
+	ctx.Data["PageIsUserSettings"] = true
+	ctx.Data["PageIsSettingsExport"] = true
+
+	var err error
+	ctx.Data["Exports"], err = models.GetAccountExports(ctx.User.Id)
+	if err != nil {
+		ctx.Handle(500, "GetAccountExports", err)
+		return
+	}
+	ctx.HTML(200, SETTINGS_EXPORT)
+}
+
+func SettingsExportPost(ctx *middleware.Context) {
+	if _, err := models.CreateAccountExport(ctx.User); err != nil {
+		if err == models.ErrAccountExportInProgress {
+			ctx.Flash.Error(ctx.Tr("settings.export_in_progress"))
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/export")
+		} else {
+			ctx.Handle(500, "CreateAccountExport", err)
+		}
+		return
+	}
+	log.Trace("Account export requested: %s", ctx.User.Name)
+
+	ctx.Flash.Success(ctx.Tr("settings.export_requested"))
+	ctx.Redirect(setting.AppSubUrl + "/user/settings/export")
+}
+
+func SettingsExportDownload(ctx *middleware.Context) {
+	e, err := models.GetAccountExportById(ctx.User.Id, ctx.ParamsInt64(":id"))
+	if err != nil {
+		if err == models.ErrAccountExportNotExist {
+			ctx.Handle(404, "GetAccountExportById", err)
+		} else {
+			ctx.Handle(500, "GetAccountExportById", err)
+		}
+		return
+	} else if !e.IsReady() {
+		ctx.Handle(404, "GetAccountExportById", models.ErrAccountExportNotExist)
+		return
+	}
+	ctx.ServeFile(e.ArchivePath(), e.FileName(ctx.User))
+}
+
 func SettingsSocial(ctx *middleware.Context) {
 	ctx.Data["Title"] = ctx.Tr("settings")
 	ctx.Data["PageIsUserSettings"] = true
@@ -413,6 +929,16 @@ func SettingsApplications(ctx *middleware.Context) {
 	}
 	ctx.Data["Tokens"] = tokens
 
+	// Locale keys cannot contain colons of scope names.
+	scopes := make([]map[string]string, len(models.AccessTokenScopes))
+	for i, scope := range models.AccessTokenScopes {
+		scopes[i] = map[string]string{
+			"Name": scope,
+			"Desc": ctx.Tr("settings.token_scope_desc." + strings.Replace(scope, ":", "_", 1)),
+		}
+	}
+	ctx.Data["TokenScopes"] = scopes
+
 	ctx.HTML(200, SETTINGS_APPLICATIONS)
 }
 
@@ -433,8 +959,17 @@ func SettingsApplicationsPost(ctx *middleware.Context, form auth.NewAccessTokenF
 			Uid:  ctx.User.Id,
 			Name: form.Name,
 		}
-		if err := models.NewAccessToken(t); err != nil {
-			ctx.Handle(500, "NewAccessToken", err)
+		if err := models.NewAccessToken(t, form.Scopes); err != nil {
+			switch {
+			case err == models.ErrAccessTokenNoScope:
+				ctx.Flash.Error(ctx.Tr("settings.token_scope_required"))
+			case models.IsErrAccessTokenScopeInvalid(err):
+				ctx.Flash.Error(ctx.Tr("settings.token_scope_invalid", err.(models.ErrAccessTokenScopeInvalid).Scope))
+			default:
+				ctx.Handle(500, "NewAccessToken", err)
+				return
+			}
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/applications")
 			return
 		}
 
@@ -459,7 +994,7 @@ func SettingsDelete(ctx *middleware.Context) {
 		// if tmpUser.Passwd != ctx.User.Passwd {
 		// 	ctx.Flash.Error("Password is not correct. Make sure you are owner of this account.")
 		// } else {
-		if err := models.DeleteUser(ctx.User); err != nil {
+		if err := models.DeleteUser(ctx.User, selfActor(ctx)); err != nil {
 			switch err {
 			case models.ErrUserOwnRepos:
 				ctx.Flash.Error(ctx.Tr("form.still_own_repo"))